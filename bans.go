@@ -0,0 +1,117 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package mqtt
+
+import (
+	"net"
+	"sync"
+)
+
+// Ban describes a client id and/or remote ip address which is prevented from
+// connecting to the server. An empty ClientID or IP matches any value, so a ban
+// with both fields set applies only when both match.
+type Ban struct {
+	ClientID string // the banned client id, or empty to match any client id
+	IP       string // the banned remote ip address, or empty to match any address
+	Reason   string // an optional description of why the ban was applied
+	Created  int64  // the unix time the ban was created
+	Expiry   int64  // the unix time the ban expires, or 0 if the ban is permanent
+}
+
+// Key returns the unique key for the ban, derived from the client id and ip.
+func (b Ban) Key() string {
+	return b.ClientID + "@" + b.IP
+}
+
+// Expired returns true if the ban has a set expiry which is before now.
+func (b Ban) Expired(now int64) bool {
+	return b.Expiry > 0 && b.Expiry <= now
+}
+
+// Matches returns true if the ban applies to the given client id and ip address.
+func (b Ban) Matches(id, ip string) bool {
+	if b.ClientID == "" && b.IP == "" {
+		return false
+	}
+
+	return (b.ClientID == "" || b.ClientID == id) && (b.IP == "" || b.IP == ip)
+}
+
+// Bans is a map of bans keyed on client id and ip address.
+type Bans struct {
+	internal map[string]Ban // bans known by the broker, keyed on Ban.Key
+	sync.RWMutex
+}
+
+// NewBans returns a new instance of Bans.
+func NewBans() *Bans {
+	return &Bans{
+		internal: map[string]Ban{},
+	}
+}
+
+// Add adds or replaces a ban.
+func (b *Bans) Add(val Ban) {
+	b.Lock()
+	defer b.Unlock()
+	b.internal[val.Key()] = val
+}
+
+// Get returns a ban by key, if it exists.
+func (b *Bans) Get(key string) (Ban, bool) {
+	b.RLock()
+	defer b.RUnlock()
+	val, ok := b.internal[key]
+	return val, ok
+}
+
+// GetAll returns all bans.
+func (b *Bans) GetAll() map[string]Ban {
+	b.RLock()
+	defer b.RUnlock()
+	m := map[string]Ban{}
+	for k, v := range b.internal {
+		m[k] = v
+	}
+	return m
+}
+
+// Len returns the number of bans.
+func (b *Bans) Len() int {
+	b.RLock()
+	defer b.RUnlock()
+	return len(b.internal)
+}
+
+// Delete removes a ban by key, returning true if it existed.
+func (b *Bans) Delete(key string) bool {
+	b.Lock()
+	defer b.Unlock()
+	_, ok := b.internal[key]
+	delete(b.internal, key)
+	return ok
+}
+
+// Match returns the first unexpired ban which applies to the client id and ip address.
+func (b *Bans) Match(id, ip string, now int64) (Ban, bool) {
+	b.RLock()
+	defer b.RUnlock()
+	for _, v := range b.internal {
+		if !v.Expired(now) && v.Matches(id, ip) {
+			return v, true
+		}
+	}
+	return Ban{}, false
+}
+
+// remoteIP returns the ip address portion of a remote address, or the
+// address unchanged if it does not contain a port.
+func remoteIP(remote string) string {
+	host, _, err := net.SplitHostPort(remote)
+	if err != nil {
+		return remote
+	}
+	return host
+}
@@ -0,0 +1,111 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package mqtt
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBanKey(t *testing.T) {
+	require.Equal(t, "mochi@127.0.0.1", Ban{ClientID: "mochi", IP: "127.0.0.1"}.Key())
+	require.Equal(t, "mochi@", Ban{ClientID: "mochi"}.Key())
+	require.Equal(t, "@127.0.0.1", Ban{IP: "127.0.0.1"}.Key())
+}
+
+func TestBanExpired(t *testing.T) {
+	require.False(t, Ban{}.Expired(10))
+	require.False(t, Ban{Expiry: 11}.Expired(10))
+	require.True(t, Ban{Expiry: 10}.Expired(10))
+	require.True(t, Ban{Expiry: 9}.Expired(10))
+}
+
+func TestBanMatches(t *testing.T) {
+	tt := []struct {
+		ban    Ban
+		id     string
+		ip     string
+		expect bool
+	}{
+		{ban: Ban{}, id: "mochi", ip: "127.0.0.1", expect: false},
+		{ban: Ban{ClientID: "mochi"}, id: "mochi", ip: "127.0.0.1", expect: true},
+		{ban: Ban{ClientID: "mochi"}, id: "zen", ip: "127.0.0.1", expect: false},
+		{ban: Ban{IP: "127.0.0.1"}, id: "zen", ip: "127.0.0.1", expect: true},
+		{ban: Ban{IP: "127.0.0.1"}, id: "zen", ip: "10.0.0.1", expect: false},
+		{ban: Ban{ClientID: "mochi", IP: "127.0.0.1"}, id: "mochi", ip: "127.0.0.1", expect: true},
+		{ban: Ban{ClientID: "mochi", IP: "127.0.0.1"}, id: "mochi", ip: "10.0.0.1", expect: false},
+		{ban: Ban{ClientID: "mochi", IP: "127.0.0.1"}, id: "zen", ip: "127.0.0.1", expect: false},
+	}
+
+	for _, tx := range tt {
+		require.Equal(t, tx.expect, tx.ban.Matches(tx.id, tx.ip), tx.ban.Key())
+	}
+}
+
+func TestNewBans(t *testing.T) {
+	b := NewBans()
+	require.NotNil(t, b)
+	require.NotNil(t, b.internal)
+	require.Equal(t, 0, b.Len())
+}
+
+func TestBansAddGetDelete(t *testing.T) {
+	b := NewBans()
+	b.Add(Ban{ClientID: "mochi", Reason: "test"})
+	require.Equal(t, 1, b.Len())
+
+	v, ok := b.Get("mochi@")
+	require.True(t, ok)
+	require.Equal(t, "test", v.Reason)
+
+	b.Add(Ban{ClientID: "mochi", Reason: "updated"})
+	require.Equal(t, 1, b.Len())
+	v, ok = b.Get("mochi@")
+	require.True(t, ok)
+	require.Equal(t, "updated", v.Reason)
+
+	require.True(t, b.Delete("mochi@"))
+	require.False(t, b.Delete("mochi@"))
+	_, ok = b.Get("mochi@")
+	require.False(t, ok)
+}
+
+func TestBansGetAll(t *testing.T) {
+	b := NewBans()
+	b.Add(Ban{ClientID: "mochi"})
+	b.Add(Ban{IP: "127.0.0.1"})
+
+	all := b.GetAll()
+	require.Len(t, all, 2)
+	require.Contains(t, all, "mochi@")
+	require.Contains(t, all, "@127.0.0.1")
+}
+
+func TestBansMatch(t *testing.T) {
+	b := NewBans()
+	b.Add(Ban{ClientID: "mochi", Expiry: 5})
+	b.Add(Ban{IP: "127.0.0.1"})
+
+	_, ok := b.Match("mochi", "10.0.0.1", 4)
+	require.True(t, ok)
+
+	_, ok = b.Match("mochi", "10.0.0.1", 6) // expired
+	require.False(t, ok)
+
+	v, ok := b.Match("zen", "127.0.0.1", 6)
+	require.True(t, ok)
+	require.Equal(t, "127.0.0.1", v.IP)
+
+	_, ok = b.Match("zen", "10.0.0.1", 4)
+	require.False(t, ok)
+}
+
+func TestRemoteIP(t *testing.T) {
+	require.Equal(t, "127.0.0.1", remoteIP("127.0.0.1:1883"))
+	require.Equal(t, "::1", remoteIP("[::1]:1883"))
+	require.Equal(t, "pipe", remoteIP("pipe"))
+	require.Equal(t, "", remoteIP(""))
+}
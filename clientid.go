@@ -0,0 +1,78 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package mqtt
+
+import (
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/mochi-mqtt/server/v2/packets"
+	"github.com/rs/xid"
+)
+
+// ClientIDPolicy determines which client identifiers are accepted when clients connect.
+type ClientIDPolicy string
+
+const (
+	// ClientIDAny accepts any client identifier which is a valid UTF-8 string. This is the default.
+	ClientIDAny ClientIDPolicy = "any"
+
+	// ClientIDStrict accepts only client identifiers of up to ClientIDMaxLength bytes
+	// (23 by default) containing the characters 0-9, a-z, and A-Z, as described by
+	// MQTT v3.1.1 [MQTT-3.1.3-5].
+	ClientIDStrict ClientIDPolicy = "strict"
+
+	// ClientIDRelaxed accepts client identifiers of up to ClientIDMaxLength bytes containing
+	// any printable characters, rejecting control characters and whitespace.
+	ClientIDRelaxed ClientIDPolicy = "relaxed"
+)
+
+const defaultClientIDMaxLength = 23 // the client id length all servers must accept [MQTT-3.1.3-5]
+
+// ClientIDGenerator returns a new identifier for a client which connected with an empty
+// client id, eg. to embed a tenant or region prefix, or to use ULIDs. The listener, remote
+// address, username, and connect properties of the client are set when it is called. The
+// identifiers must be unique, and if an empty string is returned an xid is assigned instead.
+type ClientIDGenerator func(cl *Client) string
+
+// assignClientID returns a new identifier for a client which connected with an empty
+// client id, from the client id generator of the options, or an xid if none is set.
+func (o *Options) assignClientID(cl *Client) string {
+	if o.ClientIDGenerator != nil {
+		if id := o.ClientIDGenerator(cl); id != "" {
+			return id
+		}
+	}
+
+	return xid.New().String()
+}
+
+// validateClientID checks a client identifier against the client id policy, returning
+// a client identifier not valid code if it is rejected. Empty client identifiers are
+// assigned by the server, so are not checked.
+func (s *Server) validateClientID(id string) packets.Code {
+	if id == "" || s.Options.ClientIDPolicy == ClientIDAny {
+		return packets.CodeSuccess
+	}
+
+	if len(id) > s.Options.ClientIDMaxLength {
+		return packets.ErrClientIdentifierTooLong
+	}
+
+	for _, r := range id {
+		switch s.Options.ClientIDPolicy {
+		case ClientIDStrict:
+			if !(r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z') {
+				return packets.ErrClientIdentifierNotValid
+			}
+		case ClientIDRelaxed:
+			if r == utf8.RuneError || unicode.IsControl(r) || unicode.IsSpace(r) || !unicode.IsPrint(r) {
+				return packets.ErrClientIdentifierNotValid
+			}
+		}
+	}
+
+	return packets.CodeSuccess
+}
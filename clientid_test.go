@@ -0,0 +1,94 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package mqtt
+
+import (
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/mochi-mqtt/server/v2/packets"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOptionsSetDefaultsClientID(t *testing.T) {
+	opts := new(Options)
+	opts.ensureDefaults()
+	require.Equal(t, ClientIDAny, opts.ClientIDPolicy)
+	require.Equal(t, defaultClientIDMaxLength, opts.ClientIDMaxLength)
+}
+
+func TestServerValidateClientID(t *testing.T) {
+	tt := []struct {
+		policy ClientIDPolicy
+		id     string
+		expect packets.Code
+	}{
+		{policy: ClientIDAny, id: strings.Repeat("a", 100) + " /#", expect: packets.CodeSuccess},
+		{policy: ClientIDStrict, id: "", expect: packets.CodeSuccess},
+		{policy: ClientIDStrict, id: "mochi1AZ", expect: packets.CodeSuccess},
+		{policy: ClientIDStrict, id: strings.Repeat("a", 23), expect: packets.CodeSuccess},
+		{policy: ClientIDStrict, id: strings.Repeat("a", 24), expect: packets.ErrClientIdentifierTooLong},
+		{policy: ClientIDStrict, id: "mochi-1", expect: packets.ErrClientIdentifierNotValid},
+		{policy: ClientIDStrict, id: "mochí", expect: packets.ErrClientIdentifierNotValid},
+		{policy: ClientIDRelaxed, id: "mochi-1:sensor_a.b", expect: packets.CodeSuccess},
+		{policy: ClientIDRelaxed, id: "mochí", expect: packets.CodeSuccess},
+		{policy: ClientIDRelaxed, id: "mochi 1", expect: packets.ErrClientIdentifierNotValid},
+		{policy: ClientIDRelaxed, id: "mochi\x01", expect: packets.ErrClientIdentifierNotValid},
+		{policy: ClientIDRelaxed, id: strings.Repeat("a", 24), expect: packets.ErrClientIdentifierTooLong},
+	}
+
+	s := newServer()
+	for _, tx := range tt {
+		t.Run(string(tx.policy)+"/"+tx.id, func(t *testing.T) {
+			s.Options.ClientIDPolicy = tx.policy
+			require.Equal(t, tx.expect, s.validateClientID(tx.id))
+		})
+	}
+
+	s.Options.ClientIDPolicy = ClientIDRelaxed
+	s.Options.ClientIDMaxLength = 64
+	require.Equal(t, packets.CodeSuccess, s.validateClientID(strings.Repeat("a", 64)))
+	require.Equal(t, packets.ErrClientIdentifierTooLong, s.validateClientID(strings.Repeat("a", 65)))
+}
+
+func TestServerValidateConnectClientID(t *testing.T) {
+	s := newServer()
+	s.Options.ClientIDPolicy = ClientIDStrict
+
+	pk := *packets.TPacketData[packets.Connect].Get(packets.TConnectMqtt5).Packet
+	pk.Connect.ClientIdentifier = "mochi/1"
+	cl := &Client{Properties: ClientProperties{ProtocolVersion: 5}}
+	require.ErrorIs(t, s.validateConnect(cl, pk), packets.ErrClientIdentifierNotValid)
+}
+
+func TestEstablishConnectionClientIDRejectedMqtt3(t *testing.T) {
+	s := newServer()
+	s.Options.ClientIDPolicy = ClientIDStrict
+	s.Options.ClientIDMaxLength = 2
+	defer s.Close()
+
+	r, w := net.Pipe()
+	o := make(chan error)
+	go func() {
+		o <- s.EstablishConnection("tcp", r)
+	}()
+
+	go func() {
+		_, _ = w.Write(packets.TPacketData[packets.Connect].Get(packets.TConnectMqtt311).RawBytes)
+	}()
+
+	recv := make(chan []byte)
+	go func() {
+		buf, _ := io.ReadAll(w)
+		recv <- buf
+	}()
+
+	err := <-o
+	require.ErrorIs(t, err, packets.ErrClientIdentifierTooLong)
+	_ = r.Close()
+	require.Equal(t, []byte{packets.Connack << 4, 2, 0, packets.Err3ClientIdentifierNotValid.Code}, <-recv)
+}
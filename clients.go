@@ -16,8 +16,6 @@ import (
 	"sync/atomic"
 	"time"
 
-	"github.com/rs/xid"
-
 	"github.com/mochi-mqtt/server/v2/packets"
 )
 
@@ -25,6 +23,8 @@ const (
 	defaultKeepalive             uint16 = 10 // the default connection keepalive value in seconds.
 	defaultClientProtocolVersion byte   = 4  // the default mqtt protocol version of connecting clients (if somehow unspecified).
 	minimumKeepalive             uint16 = 5  // the minimum recommended keepalive - values under with display a warning.
+
+	defaultKeepaliveGrace = 1.5 // the default multiple of the keepalive after which a connection expires.
 )
 
 var (
@@ -101,6 +101,19 @@ func (cl *Clients) GetByListener(id string) []*Client {
 	return clients
 }
 
+// GetByUsername returns all connected clients which authenticated with a username.
+func (cl *Clients) GetByUsername(username string) []*Client {
+	cl.RLock()
+	defer cl.RUnlock()
+	clients := make([]*Client, 0)
+	for _, client := range cl.internal {
+		if !client.Net.Inline && !client.Closed() && string(client.Properties.Username) == username {
+			clients = append(clients, client)
+		}
+	}
+	return clients
+}
+
 // Client contains information about a client known by the broker.
 type Client struct {
 	Properties   ClientProperties // client properties
@@ -108,6 +121,7 @@ type Client struct {
 	Net          ClientConnection // network connection state of the client
 	ID           string           // the client id.
 	ops          *ops             // ops provides a reference to server ops.
+	claims       any              // identity claims set by an auth hook
 	sync.RWMutex                  // mutex
 }
 
@@ -141,24 +155,57 @@ type Will struct {
 	Retain            bool                   // -
 }
 
+// Session contains the lifecycle timestamps of a client session, in unix time.
+type Session struct {
+	ClientID         string `json:"client_id"`                   // the id of the client
+	Username         string `json:"username,omitempty"`          // the username the client last connected with
+	Connected        bool   `json:"connected"`                   // the client is currently connected
+	Created          int64  `json:"created"`                     // the time the session was created
+	LastConnected    int64  `json:"last_connected,omitempty"`    // the time the client last connected
+	LastDisconnected int64  `json:"last_disconnected,omitempty"` // the time the client last disconnected, if ever
+	DisconnectReason string `json:"disconnect_reason,omitempty"` // the reason the client last disconnected, if any
+}
+
 // ClientState tracks the state of the client.
 type ClientState struct {
-	TopicAliases    TopicAliases         // a map of topic aliases
-	stopCause       atomic.Value         // reason for stopping
-	Inflight        *Inflight            // a map of in-flight qos messages
-	Subscriptions   *Subscriptions       // a map of the subscription filters a client maintains
-	disconnected    int64                // the time the client disconnected in unix time, for calculating expiry
-	outbound        chan *packets.Packet // queue for pending outbound packets
-	endOnce         sync.Once            // only end once
-	isTakenOver     uint32               // used to identify orphaned clients
-	packetID        uint32               // the current highest packetID
-	open            context.Context      // indicate that the client is open for packet exchange
-	cancelOpen      context.CancelFunc   // cancel function for open context
-	outboundQty     int32                // number of messages currently in the outbound queue
-	Keepalive       uint16               // the number of seconds the connection can wait
-	ServerKeepalive bool                 // keepalive was set by the server
+	TopicAliases      TopicAliases         // a map of topic aliases
+	stopCause         atomic.Value         // reason for stopping
+	writeErr          atomic.Value         // the first error writing to the connection, if any
+	Inflight          *Inflight            // a map of in-flight qos messages
+	Subscriptions     *Subscriptions       // a map of the subscription filters a client maintains
+	disconnected      int64                // the time the client disconnected in unix time, for calculating expiry
+	connected         int64                // the time the client connected in unix nanoseconds, atomic
+	created           int64                // the time the session was first created in unix time, atomic
+	lastDisconnect    int64                // the time an inherited session last disconnected in unix time
+	lastCause         string               // the reason an inherited session last disconnected
+	outbound          chan *packets.Packet // queue for pending outbound packets
+	endOnce           sync.Once            // only end once
+	isTakenOver       uint32               // used to identify orphaned clients
+	forgotten         uint32               // the client data is being erased, so the session expires on disconnect
+	packetID          uint32               // the current highest packetID
+	open              context.Context      // indicate that the client is open for packet exchange
+	cancelOpen        context.CancelFunc   // cancel function for open context
+	outboundQty       int32                // number of messages currently in the outbound queue
+	drained           chan struct{}        // closed when the outbound queue falls below the backpressure watermark
+	drainedMu         sync.Mutex           // guards drained
+	violations        int32                // number of malformed packets and protocol violations from the client
+	keepaliveDeadline int64                // unix time in nanoseconds by the server clock after which the connection has expired, or 0 if no keepalive
+	maximumQos        *byte                // the maximum qos negotiated by the OnConnectNegotiate hook, if lower than the server maximum
+	keepaliveGrace    float64              // the multiple of the keepalive after which the connection expires, or 0 for the default
+	pings             pingStats            // the arrival intervals of pingreq packets, if keepalive metrics are enabled
+	readOnly          bool                 // publishes from the client are rejected, as it connected to a read-only listener
+	writeOnly         bool                 // subscriptions from the client are denied, as it connected to a write-only listener
+	Keepalive         uint16               // the number of seconds the connection can wait
+	ServerKeepalive   bool                 // keepalive was set by the server
 }
 
+// closedContext is a cancelled context for clients which were never opened.
+var closedContext = func() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return ctx
+}()
+
 // newClient returns a new instance of Client. This is almost exclusively used by Server
 // for creating new clients, but it lives here because it's not dependent.
 func newClient(c net.Conn, o *ops) *Client {
@@ -192,6 +239,7 @@ func newClient(c net.Conn, o *ops) *Client {
 
 // WriteLoop ranges over pending outbound messages and writes them to the client connection.
 func (cl *Client) WriteLoop() {
+	defer cl.recoverPanic(nil)
 	for {
 		select {
 		case pk := <-cl.State.outbound:
@@ -199,13 +247,38 @@ func (cl *Client) WriteLoop() {
 				// TODO : Figure out what to do with error
 				cl.ops.log.Debug("failed publishing packet", "error", err, "client", cl.ID, "packet", pk)
 			}
-			atomic.AddInt32(&cl.State.outboundQty, -1)
-		case <-cl.State.open.Done():
+			n := atomic.AddInt32(&cl.State.outboundQty, -1)
+			if w := cl.ops.options.BackpressureWatermark; w > 0 && n < w {
+				cl.signalDrained()
+			}
+		case <-cl.Done():
 			return
 		}
 	}
 }
 
+// drainedSignal returns a channel which is closed when the outbound queue of the client
+// next falls below the backpressure watermark.
+func (cl *Client) drainedSignal() <-chan struct{} {
+	cl.State.drainedMu.Lock()
+	defer cl.State.drainedMu.Unlock()
+	if cl.State.drained == nil {
+		cl.State.drained = make(chan struct{})
+	}
+
+	return cl.State.drained
+}
+
+// signalDrained releases any publishes held by backpressure waiting on the client.
+func (cl *Client) signalDrained() {
+	cl.State.drainedMu.Lock()
+	defer cl.State.drainedMu.Unlock()
+	if cl.State.drained != nil {
+		close(cl.State.drained)
+		cl.State.drained = nil
+	}
+}
+
 // ParseConnect parses the connect parameters and properties for a client.
 func (cl *Client) ParseConnect(lid string, pk packets.Packet) {
 	cl.Net.Listener = lid
@@ -235,7 +308,7 @@ func (cl *Client) ParseConnect(lid string, pk packets.Packet) {
 
 	cl.ID = pk.Connect.ClientIdentifier
 	if cl.ID == "" {
-		cl.ID = xid.New().String() // [MQTT-3.1.3-6] [MQTT-3.1.3-7]
+		cl.ID = cl.ops.options.assignClientID(cl) // [MQTT-3.1.3-6] [MQTT-3.1.3-7]
 		cl.Properties.Props.AssignedClientID = cl.ID
 	}
 
@@ -258,21 +331,34 @@ func (cl *Client) ParseConnect(lid string, pk packets.Packet) {
 	}
 }
 
-// refreshDeadline refreshes the read/write deadline for the net.Conn connection.
+// refreshDeadline refreshes the read deadline for the net.Conn connection, allowing the
+// keepalive grace of the listener (one and a half times the keepalive by default). Only the
+// read deadline is set, so that a client which receives messages but never sends a packet
+// is still expired, and writes are not cut short. The deadline is also recorded against the
+// server clock, so that it can be enforced when the clock is controlled in tests.
 func (cl *Client) refreshDeadline(keepalive uint16) {
 	var expiry time.Time // nil time can be used to disable deadline if keepalive = 0
+	var deadline int64
 	if keepalive > 0 {
-		expiry = time.Now().Add(time.Duration(keepalive+(keepalive/2)) * time.Second) // [MQTT-3.1.2-22]
+		grace := cl.State.keepaliveGrace
+		if grace <= 0 {
+			grace = defaultKeepaliveGrace
+		}
+		d := time.Duration(float64(keepalive) * grace * float64(time.Second)) // [MQTT-3.1.2-22]
+		expiry = time.Now().Add(d)
+		deadline = cl.now().Add(d).UnixNano()
 	}
+	atomic.StoreInt64(&cl.State.keepaliveDeadline, deadline)
 
 	if cl.Net.Conn != nil {
-		_ = cl.Net.Conn.SetDeadline(expiry) // [MQTT-3.1.2-22]
+		_ = cl.Net.Conn.SetReadDeadline(expiry) // [MQTT-3.1.2-22]
 	}
 }
 
-// NextPacketID returns the next available (unused) packet id for the client.
-// If no unused packet ids are available, an error is returned and the client
-// should be disconnected.
+// NextPacketID returns the next available (unused) packet id for the client, skipping
+// any ids which are inflight. The id is not reserved, so a packet should be stored with
+// Inflight.Add, choosing another id if it was claimed concurrently. If no unused packet
+// ids are available, an error is returned and the client should be disconnected.
 func (cl *Client) NextPacketID() (i uint32, err error) {
 	cl.Lock()
 	defer cl.Unlock()
@@ -293,7 +379,7 @@ func (cl *Client) NextPacketID() (i uint32, err error) {
 
 		i++
 
-		if _, ok := cl.State.Inflight.Get(uint16(i)); !ok {
+		if _, ok := cl.State.Inflight.Get(uint16(i)); !ok && !cl.State.Inflight.Reserved(uint16(i)) {
 			atomic.StoreUint32(&cl.State.packetID, i)
 			return i, nil
 		}
@@ -309,6 +395,9 @@ func (cl *Client) ResendInflightMessages(force bool) error {
 	for _, tk := range cl.State.Inflight.GetAll(false) {
 		if tk.FixedHeader.Type == packets.Publish {
 			tk.FixedHeader.Dup = true // [MQTT-3.3.1-1] [MQTT-3.3.1-3]
+			cl.State.Inflight.Update(tk.PacketID, func(pk *packets.Packet) {
+				pk.FixedHeader.Dup = true
+			})
 		}
 
 		cl.ops.hooks.OnQosPublish(cl, tk, tk.Created, 0)
@@ -362,8 +451,8 @@ func (cl *Client) ClearExpiredInflights(now, maximumExpiry int64) []uint16 {
 
 // Read reads incoming packets from the connected client and transforms them into
 // packets to be handled by the packetHandler.
-func (cl *Client) Read(packetHandler ReadFn) error {
-	var err error
+func (cl *Client) Read(packetHandler ReadFn) (err error) {
+	defer cl.recoverPanic(&err)
 
 	for {
 		if cl.Closed() {
@@ -379,7 +468,20 @@ func (cl *Client) Read(packetHandler ReadFn) error {
 
 		pk, err := cl.ReadPacket(fh)
 		if err != nil {
-			return err
+			var code packets.Code
+			if errors.As(err, &code) {
+				reportError(cl.ops.hooks, cl, ErrorSubsystemDecode, err)
+			}
+
+			if cl.ops.violation == nil || !IsViolation(err) {
+				return err
+			}
+
+			// the packet was read in full, so the connection can continue if the policy allows.
+			if err = cl.ops.violation(cl, pk, err); err != nil {
+				return err
+			}
+			continue
 		}
 
 		err = packetHandler(cl, pk) // Process inbound packet.
@@ -405,10 +507,23 @@ func (cl *Client) Stop(err error) {
 			cl.State.cancelOpen()
 		}
 
-		atomic.StoreInt64(&cl.State.disconnected, time.Now().Unix())
+		atomic.StoreInt64(&cl.State.disconnected, cl.now().Unix())
 	})
 }
 
+// writeError wraps connection write errors so they are stored with a consistent type.
+type writeError struct {
+	err error
+}
+
+// WriteError returns the first error which occurred writing to the client connection, if any.
+func (cl *Client) WriteError() error {
+	if v, ok := cl.State.writeErr.Load().(writeError); ok {
+		return v.err
+	}
+	return nil
+}
+
 // StopCause returns the reason the client connection was stopped, if any.
 func (cl *Client) StopCause() error {
 	if cl.State.stopCause.Load() == nil {
@@ -417,11 +532,66 @@ func (cl *Client) StopCause() error {
 	return cl.State.stopCause.Load().(error)
 }
 
+// SetClaims stores identity claims for the client, such as roles or attributes, typically
+// set by an auth hook in OnConnectAuthenticate so that later ACL checks and hooks can use
+// them without re-parsing the username or credentials.
+func (cl *Client) SetClaims(claims any) {
+	cl.Lock()
+	defer cl.Unlock()
+	cl.claims = claims
+}
+
+// Claims returns the identity claims of the client, or nil if none were set.
+func (cl *Client) Claims() any {
+	cl.RLock()
+	defer cl.RUnlock()
+	return cl.claims
+}
+
+// Session returns the lifecycle of the client session, such as when it was created and
+// when it last connected and disconnected.
+func (cl *Client) Session() Session {
+	sess := Session{
+		ClientID:         cl.ID,
+		Username:         string(cl.Properties.Username),
+		Connected:        !cl.Closed(),
+		Created:          atomic.LoadInt64(&cl.State.created),
+		LastConnected:    atomic.LoadInt64(&cl.State.connected) / int64(time.Second),
+		LastDisconnected: cl.State.lastDisconnect,
+		DisconnectReason: cl.State.lastCause,
+	}
+
+	if !sess.Connected {
+		sess.LastDisconnected = atomic.LoadInt64(&cl.State.disconnected)
+		sess.DisconnectReason = ""
+		if err := cl.StopCause(); err != nil {
+			sess.DisconnectReason = err.Error()
+		}
+	}
+
+	return sess
+}
+
 // Closed returns true if client connection is closed.
 func (cl *Client) Closed() bool {
 	return cl.State.open == nil || cl.State.open.Err() != nil
 }
 
+// Context returns a context which is cancelled when the client is stopped, so that
+// hooks and embedding code can tie goroutines to the lifetime of the client.
+func (cl *Client) Context() context.Context {
+	if cl.State.open == nil {
+		return closedContext
+	}
+
+	return cl.State.open
+}
+
+// Done returns a channel which is closed when the client is stopped.
+func (cl *Client) Done() <-chan struct{} {
+	return cl.Context().Done()
+}
+
 // ReadFixedHeader reads in the values of the next packet's fixed header.
 func (cl *Client) ReadFixedHeader(fh *packets.FixedHeader) error {
 	if cl.Net.bconn == nil {
@@ -466,6 +636,13 @@ func (cl *Client) ReadPacket(fh *packets.FixedHeader) (pk packets.Packet, err er
 
 	atomic.AddInt64(&cl.ops.info.BytesReceived, int64(n))
 
+	if cl.ops.hooks.Provides(OnPacket) {
+		raw := new(bytes.Buffer)
+		fh.Encode(raw)
+		raw.Write(p)
+		cl.ops.hooks.OnPacket(cl, PacketInbound, raw.Bytes())
+	}
+
 	// Decode the remaining packet values using a fresh copy of the bytes,
 	// otherwise the next packet will change the data of this one.
 	px := append([]byte{}, p[:]...)
@@ -502,17 +679,35 @@ func (cl *Client) ReadPacket(fh *packets.FixedHeader) (pk packets.Packet, err er
 	case packets.Auth:
 		err = pk.AuthDecode(px)
 	default:
-		err = fmt.Errorf("invalid packet type; %v", pk.FixedHeader.Type)
+		err = fmt.Errorf("%w: %v", packets.ErrNoValidPacketAvailable, pk.FixedHeader.Type)
 	}
 
 	if err != nil {
 		return pk, err
 	}
 
+	if cl.ops.traces.Has(cl.ID) {
+		cl.ops.log.Info("trace inbound packet", "client", cl.ID, "listener", cl.Net.Listener, "pk", pk)
+	}
+
 	pk, err = cl.ops.hooks.OnPacketRead(cl, pk)
 	return
 }
 
+// recordLatency records the time taken from the receipt of a publish to its write to the client.
+func (cl *Client) recordLatency(pk packets.Packet) {
+	if cl.ops.latency == nil {
+		return
+	}
+
+	topic := pk.TopicName
+	if topic == "" && pk.Properties.TopicAlias > 0 {
+		topic = cl.State.TopicAliases.Outbound.Topic(pk.Properties.TopicAlias)
+	}
+
+	cl.ops.latency.Record(cl.Net.Listener, topic, time.Since(time.Unix(0, pk.Received)))
+}
+
 // WritePacket encodes and writes a packet to the client.
 func (cl *Client) WritePacket(pk packets.Packet) error {
 	if cl.Closed() {
@@ -524,7 +719,7 @@ func (cl *Client) WritePacket(pk packets.Packet) error {
 	}
 
 	if pk.Expiry > 0 {
-		pk.Properties.MessageExpiryInterval = uint32(pk.Expiry - time.Now().Unix()) // [MQTT-3.3.2-6]
+		pk.Properties.MessageExpiryInterval = uint32(pk.Expiry - cl.now().Unix()) // [MQTT-3.3.2-6]
 	}
 
 	pk.ProtocolVersion = cl.Properties.ProtocolVersion
@@ -586,6 +781,16 @@ func (cl *Client) WritePacket(pk packets.Packet) error {
 		return packets.ErrPacketTooLarge // [MQTT-3.1.2-24] [MQTT-3.1.2-25]
 	}
 
+	if cl.ops.traces.Has(cl.ID) {
+		cl.ops.log.Info("trace outbound packet", "client", cl.ID, "listener", cl.Net.Listener, "pk", pk)
+	}
+
+	raw := buf.Bytes() // retain the encoded bytes, as writing drains the buffer
+	var capture []byte
+	if cl.ops.hooks.Provides(OnPacket) {
+		capture = append([]byte{}, raw...) // capture hooks may retain the bytes, so they are given their own copy
+	}
+
 	n, err := func() (int64, error) {
 		cl.Lock()
 		defer cl.Unlock()
@@ -609,14 +814,17 @@ func (cl *Client) WritePacket(pk packets.Packet) error {
 		}
 
 		n, _ := cl.Net.outbuf.Write(buf.Bytes()) // will always be successful
-		if cl.Net.outbuf.Len() < cl.ops.options.ClientNetWriteBufferSize {
-			return int64(n), nil
+		if cl.Net.outbuf.Len() < cl.ops.options.ClientNetWriteBufferSize && pk.FixedHeader.Type != packets.Disconnect {
+			return int64(n), nil // disconnects are always flushed, as the connection is about to close
 		}
 
 		err = cl.flushOutbuf()
 		return int64(n), err
 	}()
 	if err != nil {
+		if cl.State.writeErr.CompareAndSwap(nil, writeError{err}) && !cl.Closed() { // keep the first error
+			reportError(cl.ops.hooks, cl, ErrorSubsystemWrite, err)
+		}
 		return err
 	}
 
@@ -624,9 +832,15 @@ func (cl *Client) WritePacket(pk packets.Packet) error {
 	atomic.AddInt64(&cl.ops.info.PacketsSent, 1)
 	if pk.FixedHeader.Type == packets.Publish {
 		atomic.AddInt64(&cl.ops.info.MessagesSent, 1)
+		if pk.Received > 0 && !pk.FixedHeader.Dup {
+			cl.recordLatency(pk)
+		}
 	}
 
-	cl.ops.hooks.OnPacketSent(cl, pk, buf.Bytes())
+	cl.ops.hooks.OnPacketSent(cl, pk, raw)
+	if capture != nil {
+		cl.ops.hooks.OnPacket(cl, PacketOutbound, capture)
+	}
 
 	return err
 }
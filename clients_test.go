@@ -12,7 +12,9 @@ import (
 	"io"
 	"log/slog"
 	"net"
+	"os"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -131,6 +133,17 @@ func TestClientsGetByListener(t *testing.T) {
 	require.Equal(t, "tcp1", clients[0].Net.Listener)
 }
 
+func TestClientsGetByUsername(t *testing.T) {
+	cl := NewClients()
+	cl.Add(&Client{ID: "t1", State: ClientState{open: context.Background()}, Properties: ClientProperties{Username: []byte("mochi")}})
+	cl.Add(&Client{ID: "t2", State: ClientState{open: context.Background()}, Properties: ClientProperties{Username: []byte("other")}})
+	cl.Add(&Client{ID: "t3", Properties: ClientProperties{Username: []byte("mochi")}}) // closed
+
+	clients := cl.GetByUsername("mochi")
+	require.Len(t, clients, 1)
+	require.Equal(t, "t1", clients[0].ID)
+}
+
 func TestNewClient(t *testing.T) {
 	cl, _, _ := newTestClient()
 
@@ -254,6 +267,28 @@ func TestClientParseConnectNoID(t *testing.T) {
 	require.NotEmpty(t, cl.ID)
 }
 
+func TestClientParseConnectGeneratedID(t *testing.T) {
+	cl, _, _ := newTestClient()
+	cl.ops.options.ClientIDGenerator = func(cl *Client) string {
+		return "eu-" + cl.Net.Listener + "-" + string(cl.Properties.Username)
+	}
+
+	pk := packets.Packet{Connect: packets.ConnectParams{Username: []byte("zen")}}
+	cl.ParseConnect("tcp1", pk)
+	require.Equal(t, "eu-tcp1-zen", cl.ID)
+	require.Equal(t, "eu-tcp1-zen", cl.Properties.Props.AssignedClientID)
+}
+
+func TestClientParseConnectGeneratedIDEmpty(t *testing.T) {
+	cl, _, _ := newTestClient()
+	cl.ops.options.ClientIDGenerator = func(cl *Client) string {
+		return ""
+	}
+
+	cl.ParseConnect("tcp1", packets.Packet{})
+	require.NotEmpty(t, cl.ID) // falls back to xid
+}
+
 func TestClientParseConnectBelowMinimumKeepalive(t *testing.T) {
 	cl, _, _ := newTestClient()
 	var b bytes.Buffer
@@ -414,6 +449,21 @@ func TestClientResendInflightMessages(t *testing.T) {
 	require.Equal(t, pk1.RawBytes, buf)
 }
 
+func TestClientResendInflightMessagesMarksDup(t *testing.T) {
+	pk1 := packets.TPacketData[packets.Publish].Get(packets.TPublishQos1)
+	cl, r, _ := newTestClient()
+	go func() { _, _ = io.Copy(io.Discard, r) }()
+
+	cl.State.Inflight.Set(*pk1.Packet)
+	err := cl.ResendInflightMessages(true)
+	require.NoError(t, err)
+
+	pk, ok := cl.State.Inflight.Get(pk1.Packet.PacketID)
+	require.True(t, ok)
+	require.True(t, pk.FixedHeader.Dup)
+	require.False(t, pk1.Packet.FixedHeader.Dup)
+}
+
 func TestClientResendInflightMessagesWriteFailure(t *testing.T) {
 	pk1 := packets.TPacketData[packets.Publish].Get(packets.TPublishQos1Dup)
 	cl, r, _ := newTestClient()
@@ -437,6 +487,56 @@ func TestClientRefreshDeadline(t *testing.T) {
 	cl, _, _ := newTestClient()
 	cl.refreshDeadline(10)
 	require.NotNil(t, cl.Net.Conn) // how do we check net.Conn deadline?
+	require.InDelta(t, time.Now().Add(15*time.Second).UnixNano(), atomic.LoadInt64(&cl.State.keepaliveDeadline), float64(time.Second))
+
+	cl.ops.options.Clock = fixedClock(time.Unix(100, 0))
+	cl.refreshDeadline(10)
+	require.Equal(t, time.Unix(115, 0).UnixNano(), atomic.LoadInt64(&cl.State.keepaliveDeadline))
+
+	// the deadline is not truncated to whole seconds.
+	cl.ops.options.Clock = fixedClock(time.Unix(100, 0))
+	cl.refreshDeadline(1)
+	require.Equal(t, time.Unix(101, int64(500*time.Millisecond)).UnixNano(), atomic.LoadInt64(&cl.State.keepaliveDeadline))
+
+	cl.refreshDeadline(0)
+	require.Equal(t, int64(0), atomic.LoadInt64(&cl.State.keepaliveDeadline))
+}
+
+func TestClientRefreshDeadlineGrace(t *testing.T) {
+	cl, _, _ := newTestClient()
+	cl.ops.options.Clock = fixedClock(time.Unix(100, 0))
+
+	cl.State.keepaliveGrace = 3
+	cl.refreshDeadline(10)
+	require.Equal(t, time.Unix(130, 0).UnixNano(), atomic.LoadInt64(&cl.State.keepaliveDeadline))
+
+	cl.State.keepaliveGrace = 1
+	cl.refreshDeadline(10)
+	require.Equal(t, time.Unix(110, 0).UnixNano(), atomic.LoadInt64(&cl.State.keepaliveDeadline))
+}
+
+func TestClientRefreshDeadlineReadOnly(t *testing.T) {
+	cl, r, _ := newTestClient()
+	cl.State.keepaliveGrace = 0.02 // 20ms
+	cl.refreshDeadline(1)
+
+	go func() {
+		_, _ = io.ReadAll(r)
+	}()
+
+	time.Sleep(time.Millisecond * 50)
+	_, err := cl.Net.Conn.Write([]byte{packets.Pingresp << 4, 0})
+	require.NoError(t, err) // writes are not subject to the keepalive deadline
+
+	_, err = cl.Net.Conn.Read(make([]byte, 1))
+	require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+}
+
+func TestClientStopUsesClock(t *testing.T) {
+	cl, _, _ := newTestClient()
+	cl.ops.options.Clock = fixedClock(time.Unix(100, 0))
+	cl.Stop(errClientStop)
+	require.Equal(t, int64(100), atomic.LoadInt64(&cl.State.disconnected))
 }
 
 func TestClientReadFixedHeader(t *testing.T) {
@@ -590,6 +690,41 @@ func TestClientStop(t *testing.T) {
 	require.Equal(t, nil, cl.StopCause())
 }
 
+func TestClientClaims(t *testing.T) {
+	cl, _, _ := newTestClient()
+	require.Nil(t, cl.Claims())
+
+	cl.SetClaims(map[string]any{"role": "admin"})
+	require.Equal(t, map[string]any{"role": "admin"}, cl.Claims())
+}
+
+func TestClientSession(t *testing.T) {
+	cl, _, _ := newTestClient()
+	cl.ID = "mochi"
+	cl.Properties.Username = []byte("zen")
+	cl.State.created = 100
+	cl.State.connected = 200 * int64(time.Second)
+	cl.State.lastDisconnect = 150
+	cl.State.lastCause = packets.ErrKeepAliveTimeout.Error()
+
+	sess := cl.Session()
+	require.Equal(t, Session{
+		ClientID:         "mochi",
+		Username:         "zen",
+		Connected:        true,
+		Created:          100,
+		LastConnected:    200,
+		LastDisconnected: 150,
+		DisconnectReason: packets.ErrKeepAliveTimeout.Error(),
+	}, sess)
+
+	cl.Stop(packets.ErrSessionTakenOver)
+	sess = cl.Session()
+	require.False(t, sess.Connected)
+	require.Equal(t, time.Now().Unix(), sess.LastDisconnected)
+	require.Equal(t, packets.ErrSessionTakenOver.Error(), sess.DisconnectReason)
+}
+
 func TestClientClosed(t *testing.T) {
 	cl, _, _ := newTestClient()
 	require.False(t, cl.Closed())
@@ -597,6 +732,33 @@ func TestClientClosed(t *testing.T) {
 	require.True(t, cl.Closed())
 }
 
+func TestClientContextDone(t *testing.T) {
+	cl, _, _ := newTestClient()
+	require.NoError(t, cl.Context().Err())
+
+	select {
+	case <-cl.Done():
+		t.Fatal("client should not be done")
+	default:
+	}
+
+	cl.Stop(errClientStop)
+	select {
+	case <-cl.Done():
+	case <-time.After(time.Second):
+		t.Fatal("client should be done")
+	}
+
+	require.ErrorIs(t, cl.Context().Err(), context.Canceled)
+	require.ErrorIs(t, cl.StopCause(), errClientStop)
+}
+
+func TestClientContextNeverOpened(t *testing.T) {
+	cl := new(Client)
+	require.ErrorIs(t, cl.Context().Err(), context.Canceled)
+	<-cl.Done()
+}
+
 func TestClientReadFixedHeaderError(t *testing.T) {
 	cl, r, _ := newTestClient()
 	defer cl.Stop(errClientStop)
@@ -704,8 +866,142 @@ func TestClientReadPacketInvalidTypeError(t *testing.T) {
 	cl, _, _ := newTestClient()
 	_ = cl.Net.Conn.Close()
 	_, err := cl.ReadPacket(&packets.FixedHeader{})
-	require.Error(t, err)
-	require.Contains(t, err.Error(), "invalid packet type")
+	require.ErrorIs(t, err, packets.ErrNoValidPacketAvailable)
+}
+
+type packetCaptureHook struct {
+	HookBase
+	sync.Mutex
+	inbound  [][]byte
+	outbound [][]byte
+}
+
+func (h *packetCaptureHook) Provides(b byte) bool {
+	return b == OnPacket
+}
+
+func (h *packetCaptureHook) OnPacket(cl *Client, direction byte, b []byte) {
+	h.Lock()
+	defer h.Unlock()
+	if direction == PacketInbound {
+		h.inbound = append(h.inbound, b)
+		return
+	}
+	h.outbound = append(h.outbound, b)
+}
+
+func TestClientReadWritePacketCapture(t *testing.T) {
+	cl, r, _ := newTestClient()
+	defer cl.Stop(errClientStop)
+
+	hook := new(packetCaptureHook)
+	err := cl.ops.hooks.Add(hook, nil)
+	require.NoError(t, err)
+
+	cl.Properties.ProtocolVersion = 4
+	in := packets.TPacketData[packets.Publish].Get(packets.TPublishQos1)
+	go func() {
+		_, _ = r.Write(in.RawBytes)
+	}()
+
+	fh := new(packets.FixedHeader)
+	require.NoError(t, cl.ReadFixedHeader(fh))
+	_, err = cl.ReadPacket(fh)
+	require.NoError(t, err)
+
+	go func() {
+		_, _ = io.ReadAll(r)
+	}()
+
+	out := packets.TPacketData[packets.Pingresp].Get(packets.TPingresp)
+	err = cl.WritePacket(*out.Packet)
+	require.NoError(t, err)
+
+	hook.Lock()
+	defer hook.Unlock()
+	require.Equal(t, [][]byte{in.RawBytes}, hook.inbound)
+	require.Equal(t, [][]byte{out.RawBytes}, hook.outbound)
+}
+
+type packetSentMutateHook struct {
+	HookBase
+}
+
+func (h *packetSentMutateHook) Provides(b byte) bool {
+	return b == OnPacketSent
+}
+
+func (h *packetSentMutateHook) OnPacketSent(cl *Client, pk packets.Packet, b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+func TestClientWritePacketCaptureCopy(t *testing.T) {
+	cl, r, _ := newTestClient()
+	defer cl.Stop(errClientStop)
+
+	require.NoError(t, cl.ops.hooks.Add(new(packetSentMutateHook), nil))
+	hook := new(packetCaptureHook)
+	require.NoError(t, cl.ops.hooks.Add(hook, nil))
+
+	go func() {
+		_, _ = io.ReadAll(r)
+	}()
+
+	out := packets.TPacketData[packets.Publish].Get(packets.TPublishBasic)
+	err := cl.WritePacket(*out.Packet)
+	require.NoError(t, err)
+
+	hook.Lock()
+	defer hook.Unlock()
+	require.Equal(t, [][]byte{out.RawBytes}, hook.outbound)
+}
+
+func TestClientReadWritePacketTrace(t *testing.T) {
+	cl, r, _ := newTestClient()
+	defer cl.Stop(errClientStop)
+
+	buf := new(bytes.Buffer)
+	cl.ops.log = slog.New(slog.NewTextHandler(buf, nil))
+	cl.ops.traces = NewTraces()
+	cl.ops.traces.Add(cl.ID)
+
+	go func() {
+		_, _ = r.Write(packets.TPacketData[packets.Pingreq].Get(packets.TPingreq).RawBytes)
+	}()
+
+	fh := new(packets.FixedHeader)
+	require.NoError(t, cl.ReadFixedHeader(fh))
+	_, err := cl.ReadPacket(fh)
+	require.NoError(t, err)
+	require.Contains(t, buf.String(), "trace inbound packet")
+
+	go func() {
+		_, _ = io.ReadAll(r)
+	}()
+
+	err = cl.WritePacket(*packets.TPacketData[packets.Pingresp].Get(packets.TPingresp).Packet)
+	require.NoError(t, err)
+	require.Contains(t, buf.String(), "trace outbound packet")
+}
+
+func TestClientWritePacketNotTraced(t *testing.T) {
+	cl, r, _ := newTestClient()
+	defer cl.Stop(errClientStop)
+
+	buf := new(bytes.Buffer)
+	cl.ops.log = slog.New(slog.NewTextHandler(buf, nil))
+	cl.ops.traces = NewTraces()
+	cl.ops.traces.Add("zen")
+
+	go func() {
+		_, _ = io.ReadAll(r)
+	}()
+
+	err := cl.WritePacket(*packets.TPacketData[packets.Pingresp].Get(packets.TPingresp).Packet)
+	require.NoError(t, err)
+	require.Empty(t, buf.String())
 }
 
 func TestClientWritePacket(t *testing.T) {
@@ -829,6 +1125,31 @@ func TestClientWritePacketBuffer(t *testing.T) {
 	}
 }
 
+func TestClientWritePacketBufferFlushesDisconnect(t *testing.T) {
+	cl, r, _ := newTestClient()
+	cl.ops.options.ClientNetWriteBufferSize = 1000
+	cl.Properties.ProtocolVersion = 5
+	defer cl.Stop(errClientStop)
+
+	cl.State.outbound <- new(packets.Packet) // writes are pending, so packets are buffered
+
+	go func() {
+		err := cl.WritePacket(packets.Packet{
+			FixedHeader: packets.FixedHeader{Type: packets.Disconnect},
+			ReasonCode:  packets.ErrSessionTakenOver.Code,
+		})
+		require.NoError(t, err)
+	}()
+
+	buf := make([]byte, 100)
+	_ = r.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := io.ReadAtLeast(r, buf, 3)
+	require.NoError(t, err)
+	require.Equal(t, packets.Disconnect<<4, buf[0])
+	require.Equal(t, packets.ErrSessionTakenOver.Code, buf[2])
+	require.Greater(t, n, 2)
+}
+
 func TestWriteClientOversizePacket(t *testing.T) {
 	cl, _, _ := newTestClient()
 	cl.Properties.Props.MaximumPacketSize = 2
@@ -877,6 +1198,44 @@ func TestClientReadPacketReadUnknown(t *testing.T) {
 	require.Error(t, err)
 }
 
+func TestClientWritePacketRecordsLatency(t *testing.T) {
+	cl, r, _ := newTestClient()
+	defer cl.Stop(errClientStop)
+	go func() { _, _ = io.Copy(io.Discard, r) }()
+
+	cl.ops.latency = NewDeliveryLatency(1)
+	cl.Net.Listener = "tcp1"
+	cl.Properties.ProtocolVersion = 5
+	alias, _ := cl.State.TopicAliases.Outbound.Set("a/b/c")
+
+	pk := packets.Packet{
+		FixedHeader: packets.FixedHeader{Type: packets.Publish},
+		TopicName:   "a/b/c",
+		Payload:     []byte("hello"),
+		Received:    time.Now().Add(-time.Millisecond * 20).UnixNano(),
+	}
+	require.NoError(t, cl.WritePacket(pk))
+
+	pk.TopicName = ""
+	pk.Properties.TopicAlias = alias
+	require.NoError(t, cl.WritePacket(pk))
+
+	pk.FixedHeader.Dup = true // redeliveries are not measured
+	require.NoError(t, cl.WritePacket(pk))
+
+	pk.Received = 0
+	pk.FixedHeader.Dup = false
+	pk.TopicName = "x"
+	require.NoError(t, cl.WritePacket(pk))
+
+	hs := cl.ops.latency.GetAll()
+	require.Len(t, hs, 1)
+	require.Equal(t, "tcp1", hs[0].Listener)
+	require.Equal(t, "a", hs[0].Prefix)
+	require.Equal(t, int64(2), hs[0].Count)
+	require.Equal(t, int64(2), hs[0].Buckets[4]) // 20ms falls in the 25ms bucket
+}
+
 func TestClientWritePacketWriteNoConn(t *testing.T) {
 	cl, _, _ := newTestClient()
 	cl.Stop(errClientStop)
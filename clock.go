@@ -0,0 +1,41 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package mqtt
+
+import "time"
+
+// Clock provides the current time to the server, so that time based behaviour
+// can be controlled in tests.
+type Clock interface {
+	Now() time.Time
+}
+
+// systemClock is a Clock which returns the system time.
+type systemClock struct{}
+
+// Now returns the current system time.
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// now returns the current time from the clock of the options, or the system time
+// if no clock is set.
+func (o *Options) now() time.Time {
+	if o.Clock == nil {
+		return time.Now()
+	}
+
+	return o.Clock.Now()
+}
+
+// now returns the current time from the server clock, or the system time if the client
+// is not attached to a server.
+func (cl *Client) now() time.Time {
+	if cl.ops == nil || cl.ops.options == nil {
+		return time.Now()
+	}
+
+	return cl.ops.options.now()
+}
@@ -0,0 +1,54 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package mqtt
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fixedClock is a Clock which always returns the same time.
+type fixedClock time.Time
+
+func (c fixedClock) Now() time.Time {
+	return time.Time(c)
+}
+
+// steppedClock is a Clock which can be moved forward while in use by other goroutines.
+type steppedClock struct {
+	unix atomic.Int64
+}
+
+func (c *steppedClock) Now() time.Time {
+	return time.Unix(c.unix.Load(), 0)
+}
+
+func TestSystemClock(t *testing.T) {
+	require.InDelta(t, time.Now().Unix(), systemClock{}.Now().Unix(), 1)
+}
+
+func TestOptionsNow(t *testing.T) {
+	o := new(Options)
+	require.InDelta(t, time.Now().Unix(), o.now().Unix(), 1)
+
+	o.Clock = fixedClock(time.Unix(100, 0))
+	require.Equal(t, time.Unix(100, 0), o.now())
+}
+
+func TestNewDefaultClock(t *testing.T) {
+	s := New(&Options{Logger: logger})
+	require.Equal(t, systemClock{}, s.Options.Clock)
+}
+
+func TestClientNowDetached(t *testing.T) {
+	cl := new(Client)
+	require.InDelta(t, time.Now().Unix(), cl.now().Unix(), 1)
+
+	cl.Stop(errClientStop) // a client which is not attached to a server can be stopped
+	require.NotZero(t, atomic.LoadInt64(&cl.State.disconnected))
+}
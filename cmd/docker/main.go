@@ -5,6 +5,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"github.com/mochi-mqtt/server/v2/config"
 	"log"
@@ -22,14 +23,6 @@ func main() {
 	configFile := flag.String("config", "config.yaml", "path to mochi config yaml or json file")
 	flag.Parse()
 
-	sigs := make(chan os.Signal, 1)
-	done := make(chan bool, 1)
-	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
-	go func() {
-		<-sigs
-		done <- true
-	}()
-
 	configBytes, err := os.ReadFile(*configFile)
 	if err != nil {
 		log.Fatal(err)
@@ -42,15 +35,12 @@ func main() {
 
 	server := mqtt.New(options)
 
-	go func() {
-		err := server.Serve()
-		if err != nil {
-			log.Fatal(err)
-		}
-	}()
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if err := server.Run(ctx); err != nil {
+		log.Fatal(err)
+	}
 
-	<-done
-	server.Log.Warn("caught signal, stopping...")
-	_ = server.Close()
 	server.Log.Info("mochi mqtt shutdown complete")
 }
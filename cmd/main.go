@@ -20,6 +20,8 @@ func main() {
 	tcpAddr := flag.String("tcp", ":1883", "network address for TCP listener")
 	wsAddr := flag.String("ws", ":1882", "network address for Websocket listener")
 	infoAddr := flag.String("info", ":8080", "network address for web info dashboard listener")
+	importRetained := flag.String("import-retained", "", "path to a JSON file of retained messages to import on start")
+	exportRetained := flag.String("export-retained", "", "path to write a JSON file of retained messages on shutdown")
 	flag.Parse()
 
 	sigs := make(chan os.Signal, 1)
@@ -63,6 +65,19 @@ func main() {
 		log.Fatal(err)
 	}
 
+	if *importRetained != "" {
+		f, err := os.Open(*importRetained)
+		if err != nil {
+			log.Fatal(err)
+		}
+		n, err := server.ImportRetained(f)
+		_ = f.Close()
+		if err != nil {
+			log.Fatal(err)
+		}
+		server.Log.Info("imported retained messages", "messages", n, "path", *importRetained)
+	}
+
 	go func() {
 		err := server.Serve()
 		if err != nil {
@@ -72,6 +87,29 @@ func main() {
 
 	<-done
 	server.Log.Warn("caught signal, stopping...")
+	if *exportRetained != "" {
+		if err := writeRetained(server, *exportRetained); err != nil {
+			server.Log.Error("failed to export retained messages", "error", err, "path", *exportRetained)
+		}
+	}
+
 	_ = server.Close()
 	server.Log.Info("mochi mqtt shutdown complete")
 }
+
+// writeRetained exports the retained messages held by the server to a file at path.
+func writeRetained(server *mqtt.Server, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	n, err := server.ExportRetained(f)
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	server.Log.Info("exported retained messages", "messages", n, "path", path)
+	return f.Close()
+}
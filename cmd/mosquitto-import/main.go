@@ -0,0 +1,69 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+// Command mosquitto-import migrates the sessions, subscriptions, and retained messages
+// of a mosquitto.db persistence file into a mochi-mqtt storage backend.
+package main
+
+import (
+	"flag"
+	"log"
+	"log/slog"
+	"os"
+
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/storage/badger"
+	"github.com/mochi-mqtt/server/v2/hooks/storage/bolt"
+	"github.com/mochi-mqtt/server/v2/hooks/storage/mosquitto"
+	"github.com/mochi-mqtt/server/v2/hooks/storage/pebble"
+)
+
+func main() {
+	dbPath := flag.String("db", "mosquitto.db", "path to the mosquitto persistence file")
+	boltPath := flag.String("bolt", "", "path to a bolt database to import into")
+	badgerPath := flag.String("badger", "", "path to a badger database to import into")
+	pebblePath := flag.String("pebble", "", "path to a pebble database to import into")
+	flag.Parse()
+
+	var h mqtt.Hook
+	var config any
+	switch {
+	case *boltPath != "":
+		h, config = new(bolt.Hook), &bolt.Options{Path: *boltPath}
+	case *badgerPath != "":
+		h, config = new(badger.Hook), &badger.Options{Path: *badgerPath}
+	case *pebblePath != "":
+		h, config = new(pebble.Hook), &pebble.Options{Path: *pebblePath}
+	default:
+		log.Fatal("a -bolt, -badger, or -pebble destination is required")
+	}
+
+	f, err := os.Open(*dbPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	db, err := mosquitto.Read(f)
+	_ = f.Close()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	h.SetOpts(logger, nil)
+	if err := h.Init(config); err != nil {
+		log.Fatal(err)
+	}
+
+	mosquitto.Import(db, h)
+	if err := h.Stop(); err != nil {
+		log.Fatal(err)
+	}
+
+	logger.Info("imported mosquitto persistence file",
+		"clients", len(db.Clients),
+		"subscriptions", len(db.Subscriptions),
+		"retained", len(db.Retained),
+	)
+}
@@ -0,0 +1,140 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package mqtt
+
+import (
+	"slices"
+
+	"github.com/golang/snappy"
+	"github.com/mochi-mqtt/server/v2/packets"
+)
+
+// CompressionSnappy is the value of the compression user property for snappy compressed
+// payloads, and for clients which can receive them.
+const CompressionSnappy = "snappy"
+
+// maxDecompressedSize is the largest decoded payload accepted when the maximum packet size
+// is not limited, being the largest remaining length of an mqtt packet.
+const maxDecompressedSize = 268435455
+
+// DecompressPayload returns the payload of a packet, decoding it if the packet was
+// compressed as a retained message. Packets read from Topics.RetainedMessage are already
+// decoded, but packets read from Topics.Retained, and records read directly from storage
+// written by earlier versions of the broker, may still be compressed.
+func DecompressPayload(pk packets.Packet) ([]byte, error) {
+	if !pk.Compressed {
+		return pk.Payload, nil
+	}
+
+	return snappy.Decode(nil, pk.Payload)
+}
+
+// compressRetained compresses the payload of a retained packet if it meets the retained
+// compression threshold and compression reduces its size.
+func (s *Server) compressRetained(pk packets.Packet) packets.Packet {
+	if pk.Compressed || s.Options.RetainedCompressionThreshold <= 0 || len(pk.Payload) < s.Options.RetainedCompressionThreshold {
+		return pk
+	}
+
+	b := snappy.Encode(nil, pk.Payload)
+	if len(b) >= len(pk.Payload) {
+		return pk
+	}
+
+	pk.Payload = b
+	pk.Compressed = true
+	return pk
+}
+
+// compressionListener returns true if the client is connected to one of the compression
+// listeners, on which all payloads are compressed.
+func (s *Server) compressionListener(cl *Client) bool {
+	return slices.Contains(s.Options.CompressionListeners, cl.Net.Listener)
+}
+
+// compressionCapable returns true if the client can receive compressed payloads, either
+// because it connected to a compression listener or set the compression user property
+// in its CONNECT packet.
+func (s *Server) compressionCapable(cl *Client) bool {
+	if s.compressionListener(cl) {
+		return true
+	}
+
+	for _, up := range cl.Properties.Props.User {
+		if up.Key == s.Options.CompressionProperty && up.Val == CompressionSnappy {
+			return true
+		}
+	}
+
+	return false
+}
+
+// decompressPublish decodes the payload of a publish received from a client if it is marked
+// with the compression user property, or was sent on a compression listener, removing the
+// property so the message continues as though it had been sent uncompressed. Payloads which
+// would decode to more than the maximum packet size are rejected before they are decoded.
+func (s *Server) decompressPublish(cl *Client, pk *packets.Packet) error {
+	i := slices.IndexFunc(pk.Properties.User, func(up packets.UserProperty) bool {
+		return up.Key == s.Options.CompressionProperty
+	})
+
+	if i == -1 && !s.compressionListener(cl) {
+		return nil
+	}
+
+	if i > -1 && pk.Properties.User[i].Val != CompressionSnappy {
+		return nil // an encoding the broker does not handle is passed through untouched
+	}
+
+	n, err := snappy.DecodedLen(pk.Payload)
+	if err != nil {
+		return err
+	}
+
+	limit := maxDecompressedSize
+	if s.Options.Capabilities.MaximumPacketSize > 0 {
+		limit = int(s.Options.Capabilities.MaximumPacketSize)
+	}
+
+	if n > limit {
+		return packets.ErrPacketTooLarge
+	}
+
+	b, err := snappy.Decode(nil, pk.Payload)
+	if err != nil {
+		return err
+	}
+
+	pk.Payload = b
+	if i > -1 {
+		pk.Properties.User = slices.Delete(slices.Clone(pk.Properties.User), i, i+1)
+	}
+
+	return nil
+}
+
+// compressPublish compresses the payload of an outbound publish for a compression capable
+// client. Clients which negotiated compression with the user property are sent the payload
+// compressed only when it is smaller, marked with the property, while clients of compression
+// listeners always receive compressed payloads.
+func (s *Server) compressPublish(cl *Client, pk *packets.Packet) {
+	if !s.compressionCapable(cl) {
+		return
+	}
+
+	b := snappy.Encode(nil, pk.Payload)
+	if !s.compressionListener(cl) {
+		if len(b) >= len(pk.Payload) {
+			return
+		}
+
+		// copy the user properties so the marker is not appended into a shared backing array
+		user := make([]packets.UserProperty, len(pk.Properties.User), len(pk.Properties.User)+1)
+		copy(user, pk.Properties.User)
+		pk.Properties.User = append(user, packets.UserProperty{Key: s.Options.CompressionProperty, Val: CompressionSnappy})
+	}
+
+	pk.Payload = b
+}
@@ -0,0 +1,368 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package mqtt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+
+	"github.com/golang/snappy"
+	"github.com/mochi-mqtt/server/v2/packets"
+	"github.com/stretchr/testify/require"
+)
+
+type retainCaptureHook struct {
+	HookBase
+	retained []packets.Packet
+}
+
+func (h *retainCaptureHook) ID() string {
+	return "retain-capture"
+}
+
+func (h *retainCaptureHook) Provides(b byte) bool {
+	return b == OnRetainMessage
+}
+
+func (h *retainCaptureHook) OnRetainMessage(cl *Client, pk packets.Packet, r int64) {
+	h.retained = append(h.retained, pk)
+}
+
+func TestDecompressPayload(t *testing.T) {
+	payload := bytes.Repeat([]byte("mochi"), 20)
+
+	b, err := DecompressPayload(packets.Packet{Payload: payload})
+	require.NoError(t, err)
+	require.Equal(t, payload, b)
+
+	b, err = DecompressPayload(packets.Packet{Payload: snappy.Encode(nil, payload), Compressed: true})
+	require.NoError(t, err)
+	require.Equal(t, payload, b)
+
+	_, err = DecompressPayload(packets.Packet{Payload: []byte{0xff}, Compressed: true})
+	require.Error(t, err)
+}
+
+func TestServerCompressRetained(t *testing.T) {
+	s := newServer()
+	payload := bytes.Repeat([]byte("mochi"), 20)
+
+	pk := s.compressRetained(packets.Packet{Payload: payload})
+	require.False(t, pk.Compressed)
+	require.Equal(t, payload, pk.Payload)
+
+	s.Options.RetainedCompressionThreshold = 200
+	pk = s.compressRetained(packets.Packet{Payload: payload})
+	require.False(t, pk.Compressed)
+
+	s.Options.RetainedCompressionThreshold = 16
+	pk = s.compressRetained(packets.Packet{Payload: payload})
+	require.True(t, pk.Compressed)
+	require.Less(t, len(pk.Payload), len(payload))
+
+	again := s.compressRetained(pk)
+	require.Equal(t, pk.Payload, again.Payload)
+
+	incompressible := []byte("abcdefghijklmnopqrstuvwxyz")
+	pk = s.compressRetained(packets.Packet{Payload: incompressible})
+	require.False(t, pk.Compressed)
+	require.Equal(t, incompressible, pk.Payload)
+}
+
+func TestServerRetainMessageCompressed(t *testing.T) {
+	s := newServerWithInlineClient()
+	s.Options.RetainedCompressionThreshold = 16
+	hook := new(retainCaptureHook)
+	require.NoError(t, s.AddHook(hook, nil))
+
+	payload := bytes.Repeat([]byte("mochi"), 20)
+	err := s.Publish("a/b/c", payload, true, 0)
+	require.NoError(t, err)
+
+	// the payload is only compressed within the topics index, and is decoded when read.
+	raw, ok := s.Topics.Retained.Get("a/b/c")
+	require.True(t, ok)
+	require.True(t, raw.Compressed)
+
+	stored, ok := s.Topics.RetainedMessage("a/b/c")
+	require.True(t, ok)
+	require.False(t, stored.Compressed)
+	require.Equal(t, payload, stored.Payload)
+	require.Equal(t, payload, s.Topics.RetainedMessages()["a/b/c"].Payload)
+
+	msgs := s.Topics.Messages("a/#")
+	require.Len(t, msgs, 1)
+	require.False(t, msgs[0].Compressed)
+	require.Equal(t, payload, msgs[0].Payload)
+
+	require.Len(t, hook.retained, 1)
+	require.False(t, hook.retained[0].Compressed)
+	require.Equal(t, payload, hook.retained[0].Payload)
+
+	var exported bytes.Buffer
+	_, err = s.ExportRetained(&exported)
+	require.NoError(t, err)
+	require.NotContains(t, exported.String(), `"compressed"`)
+
+	var got []byte
+	err = s.Subscribe("a/b/c", 1, func(cl *Client, sub packets.Subscription, pk packets.Packet) {
+		got = pk.Payload
+	})
+	require.NoError(t, err)
+	require.Equal(t, payload, got)
+
+	cl, _, _ := newTestClient()
+	s.Clients.Add(cl)
+	s.publishRetainedToClient(cl, packets.Subscription{Filter: "a/b/c"}, false)
+	require.Len(t, cl.State.outbound, 1)
+	out := <-cl.State.outbound
+	require.False(t, out.Compressed)
+	require.Equal(t, payload, out.Payload)
+}
+
+func TestServerRetainedMessagesSkipsCorrupt(t *testing.T) {
+	s := newServer()
+	s.Topics.RetainMessage(packets.Packet{
+		FixedHeader: packets.FixedHeader{Type: packets.Publish, Retain: true},
+		TopicName:   "a/b/c",
+		Payload:     []byte{0xff},
+		Compressed:  true,
+	})
+
+	hook := new(errorHook)
+	require.NoError(t, s.AddHook(hook, nil))
+
+	require.Empty(t, s.Topics.Messages("a/b/c"))
+	_, ok := s.Topics.RetainedMessage("a/b/c")
+	require.False(t, ok)
+
+	// the corrupt message is kept, so it is not lost from snapshots.
+	all := s.Topics.RetainedMessages()
+	require.Len(t, all, 1)
+	require.True(t, all["a/b/c"].Compressed)
+
+	errs := hook.Errors()
+	require.Len(t, errs, 3)
+	var ie *InternalError
+	require.ErrorAs(t, errs[0], &ie)
+	require.Equal(t, ErrorSubsystemDecode, ie.Subsystem)
+	require.Contains(t, ie.Error(), "retained message a/b/c")
+}
+
+func TestServerDecompressPublish(t *testing.T) {
+	s := newServer()
+	s.Options.PayloadCompression = true
+	s.Options.CompressionListeners = []string{"cellular"}
+	s.Options.ensureDefaults()
+	require.Equal(t, defaultCompressionProperty, s.Options.CompressionProperty)
+
+	payload := bytes.Repeat([]byte("mochi"), 20)
+	compressed := snappy.Encode(nil, payload)
+	marker := packets.UserProperty{Key: defaultCompressionProperty, Val: CompressionSnappy}
+	other := packets.UserProperty{Key: "a", Val: "b"}
+
+	tt := []struct {
+		name     string
+		listener string
+		pk       packets.Packet
+		expect   []byte
+		user     []packets.UserProperty
+	}{
+		{
+			name:   "uncompressed",
+			pk:     packets.Packet{Payload: payload},
+			expect: payload,
+		},
+		{
+			name:   "property",
+			pk:     packets.Packet{Payload: compressed, Properties: packets.Properties{User: []packets.UserProperty{other, marker}}},
+			expect: payload,
+			user:   []packets.UserProperty{other},
+		},
+		{
+			name:   "unknown encoding",
+			pk:     packets.Packet{Payload: compressed, Properties: packets.Properties{User: []packets.UserProperty{{Key: defaultCompressionProperty, Val: "gzip"}}}},
+			expect: compressed,
+			user:   []packets.UserProperty{{Key: defaultCompressionProperty, Val: "gzip"}},
+		},
+		{
+			name:     "listener",
+			listener: "cellular",
+			pk:       packets.Packet{Payload: compressed},
+			expect:   payload,
+		},
+	}
+
+	for _, tx := range tt {
+		t.Run(tx.name, func(t *testing.T) {
+			cl, _, _ := newTestClient()
+			cl.Net.Listener = tx.listener
+			pk := tx.pk
+			require.NoError(t, s.decompressPublish(cl, &pk))
+			require.Equal(t, tx.expect, pk.Payload)
+			require.Equal(t, tx.user, pk.Properties.User)
+		})
+	}
+
+	cl, _, _ := newTestClient()
+	pk := packets.Packet{Payload: []byte{0xff}, Properties: packets.Properties{User: []packets.UserProperty{marker}}}
+	require.Error(t, s.decompressPublish(cl, &pk))
+}
+
+func TestServerDecompressPublishTooLarge(t *testing.T) {
+	s := newServer()
+	s.Options.PayloadCompression = true
+	s.Options.ensureDefaults()
+	marker := packets.UserProperty{Key: defaultCompressionProperty, Val: CompressionSnappy}
+	cl, _, _ := newTestClient()
+
+	// a header claiming a payload larger than any mqtt packet is rejected without decoding.
+	header := binary.AppendUvarint(nil, maxDecompressedSize+1)
+	pk := packets.Packet{Payload: header, Properties: packets.Properties{User: []packets.UserProperty{marker}}}
+	require.ErrorIs(t, s.decompressPublish(cl, &pk), packets.ErrPacketTooLarge)
+	require.Equal(t, header, pk.Payload)
+
+	compressed := snappy.Encode(nil, make([]byte, 1000))
+	s.Options.Capabilities.MaximumPacketSize = 999
+	pk = packets.Packet{Payload: compressed, Properties: packets.Properties{User: []packets.UserProperty{marker}}}
+	require.ErrorIs(t, s.decompressPublish(cl, &pk), packets.ErrPacketTooLarge)
+
+	s.Options.Capabilities.MaximumPacketSize = 1000
+	require.NoError(t, s.decompressPublish(cl, &pk))
+	require.Len(t, pk.Payload, 1000)
+}
+
+func TestServerCompressPublish(t *testing.T) {
+	s := newServer()
+	s.Options.PayloadCompression = true
+	s.Options.CompressionListeners = []string{"cellular"}
+	s.Options.ensureDefaults()
+
+	payload := bytes.Repeat([]byte("mochi"), 20)
+	marker := packets.UserProperty{Key: defaultCompressionProperty, Val: CompressionSnappy}
+
+	cl, _, _ := newTestClient()
+	pk := packets.Packet{Payload: payload}
+	s.compressPublish(cl, &pk)
+	require.Equal(t, payload, pk.Payload)
+
+	cl.Properties.Props.User = []packets.UserProperty{marker}
+	pk = packets.Packet{Payload: payload}
+	s.compressPublish(cl, &pk)
+	require.Equal(t, snappy.Encode(nil, payload), pk.Payload)
+	require.Equal(t, []packets.UserProperty{marker}, pk.Properties.User)
+
+	pk = packets.Packet{Payload: []byte("abc")}
+	s.compressPublish(cl, &pk)
+	require.Equal(t, []byte("abc"), pk.Payload)
+	require.Empty(t, pk.Properties.User)
+
+	cl, _, _ = newTestClient()
+	cl.Net.Listener = "cellular"
+	pk = packets.Packet{Payload: []byte("abc")}
+	s.compressPublish(cl, &pk)
+	require.Equal(t, snappy.Encode(nil, []byte("abc")), pk.Payload)
+	require.Empty(t, pk.Properties.User)
+}
+
+func TestServerProcessPublishCompressed(t *testing.T) {
+	s := newServerWithInlineClient()
+	s.Options.PayloadCompression = true
+	s.Options.ensureDefaults()
+
+	var got []byte
+	err := s.Subscribe("a/b/c", 1, func(cl *Client, sub packets.Subscription, pk packets.Packet) {
+		got = pk.Payload
+	})
+	require.NoError(t, err)
+
+	marker := packets.UserProperty{Key: defaultCompressionProperty, Val: CompressionSnappy}
+	sub, _, _ := newTestClient()
+	sub.ID = "sub"
+	sub.Properties.ProtocolVersion = 5
+	sub.Properties.Props.User = []packets.UserProperty{marker}
+	s.Clients.Add(sub)
+	s.Topics.Subscribe(sub.ID, packets.Subscription{Filter: "a/b/c"})
+
+	cl, _, _ := newTestClient()
+	cl.Properties.ProtocolVersion = 5
+	s.Clients.Add(cl)
+
+	payload := bytes.Repeat([]byte("mochi"), 20)
+	err = s.processPublish(cl, packets.Packet{
+		FixedHeader: packets.FixedHeader{Type: packets.Publish},
+		TopicName:   "a/b/c",
+		Payload:     snappy.Encode(nil, payload),
+		Properties:  packets.Properties{User: []packets.UserProperty{marker}},
+	})
+	require.NoError(t, err)
+	require.Equal(t, payload, got)
+
+	require.Len(t, sub.State.outbound, 1)
+	out := <-sub.State.outbound
+	require.Equal(t, snappy.Encode(nil, payload), out.Payload)
+	require.Equal(t, []packets.UserProperty{marker}, out.Properties.User)
+}
+
+func TestServerProcessPublishCompressedInvalid(t *testing.T) {
+	s := newServer()
+	s.Options.PayloadCompression = true
+	s.Options.ensureDefaults()
+	_ = s.Serve()
+	defer s.Close()
+
+	cl, r, w := newTestClient()
+	cl.Properties.ProtocolVersion = 5
+	s.Clients.Add(cl)
+
+	go func() {
+		err := s.processPublish(cl, packets.Packet{
+			FixedHeader: packets.FixedHeader{Type: packets.Publish, Qos: 1},
+			TopicName:   "a/b/c",
+			PacketID:    7,
+			Payload:     []byte{0xff},
+			Properties:  packets.Properties{User: []packets.UserProperty{{Key: defaultCompressionProperty, Val: CompressionSnappy}}},
+		})
+		require.NoError(t, err)
+		_ = w.Close()
+	}()
+
+	buf, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, packets.Puback, buf[0]>>4)
+	require.Equal(t, packets.ErrPayloadFormatInvalid.Code, buf[4])
+}
+
+func TestServerProcessPublishCompressedTooLarge(t *testing.T) {
+	s := newServer()
+	s.Options.PayloadCompression = true
+	s.Options.Capabilities.MaximumPacketSize = 100
+	s.Options.ensureDefaults()
+	_ = s.Serve()
+	defer s.Close()
+
+	cl, r, w := newTestClient()
+	cl.Properties.ProtocolVersion = 5
+	s.Clients.Add(cl)
+
+	go func() {
+		err := s.processPublish(cl, packets.Packet{
+			FixedHeader: packets.FixedHeader{Type: packets.Publish, Qos: 1},
+			TopicName:   "a/b/c",
+			PacketID:    7,
+			Payload:     snappy.Encode(nil, make([]byte, 1000)),
+			Properties:  packets.Properties{User: []packets.UserProperty{{Key: defaultCompressionProperty, Val: CompressionSnappy}}},
+		})
+		require.NoError(t, err)
+		_ = w.Close()
+	}()
+
+	buf, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, packets.Puback, buf[0]>>4)
+	require.Equal(t, packets.ErrPacketTooLarge.Code, buf[4])
+}
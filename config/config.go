@@ -35,8 +35,11 @@ type HookConfigs struct {
 
 // HookAuthConfig contains configurations for the auth hook.
 type HookAuthConfig struct {
-	Ledger   auth.Ledger `yaml:"ledger" json:"ledger"`
-	AllowAll bool        `yaml:"allow_all" json:"allow_all"`
+	Ledger         auth.Ledger `yaml:"ledger" json:"ledger"`
+	AllowAll       bool        `yaml:"allow_all" json:"allow_all"`
+	Path           string      `yaml:"path" json:"path"`                         // a rules file to load instead of the ledger
+	WatchInterval  int64       `yaml:"watch_interval" json:"watch_interval"`     // seconds between checks for changes to the rules file
+	ReloadOnSignal bool        `yaml:"reload_on_signal" json:"reload_on_signal"` // reload the rules file on SIGHUP
 }
 
 // HookStorageConfig contains configurations for the different storage hooks.
@@ -76,6 +79,15 @@ func (hc HookConfigs) toHooksAuth() []mqtt.HookLoadConfig {
 		hlc = append(hlc, mqtt.HookLoadConfig{
 			Hook: new(auth.AllowHook),
 		})
+	} else if hc.Auth.Path != "" {
+		hlc = append(hlc, mqtt.HookLoadConfig{
+			Hook: new(auth.Hook),
+			Config: &auth.Options{
+				Path:           hc.Auth.Path,
+				WatchInterval:  hc.Auth.WatchInterval,
+				ReloadOnSignal: hc.Auth.ReloadOnSignal,
+			},
+		})
 	} else {
 		hlc = append(hlc, mqtt.HookLoadConfig{
 			Hook: new(auth.Hook),
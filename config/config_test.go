@@ -152,6 +152,29 @@ func TestToHooksAuthAllowLedger(t *testing.T) {
 	require.Equal(t, expect, th)
 }
 
+func TestToHooksAuthPath(t *testing.T) {
+	hc := HookConfigs{
+		Auth: &HookAuthConfig{
+			Path:           "auth.yaml",
+			WatchInterval:  5,
+			ReloadOnSignal: true,
+		},
+	}
+
+	th := hc.toHooksAuth()
+	expect := []mqtt.HookLoadConfig{
+		{
+			Hook: new(auth.Hook),
+			Config: &auth.Options{
+				Path:           "auth.yaml",
+				WatchInterval:  5,
+				ReloadOnSignal: true,
+			},
+		},
+	}
+	require.Equal(t, expect, th)
+}
+
 func TestToHooksStorageBadger(t *testing.T) {
 	hc := HookConfigs{
 		Storage: &HookStorageConfig{
@@ -0,0 +1,96 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package mqtt
+
+import (
+	"strings"
+
+	"github.com/mochi-mqtt/server/v2/packets"
+)
+
+// The reasons a message was dropped, given in the dead-letter-reason user property of
+// messages republished to the dead-letter topic.
+const (
+	DeadLetterExpired      = "expired"       // the message expired before the client acknowledged it
+	DeadLetterQueueFull    = "queue_full"    // the pending writes queue of the client was full
+	DeadLetterInflightFull = "inflight_full" // the client had no inflight quota or packet ids left
+	DeadLetterEvicted      = "evicted"       // the message was evicted from a full inflight store
+)
+
+// The user properties added to messages republished to the dead-letter topic.
+const (
+	DeadLetterReasonProperty = "dead-letter-reason" // the reason the message was dropped
+	DeadLetterTopicProperty  = "dead-letter-topic"  // the topic the message was published to
+	DeadLetterClientProperty = "dead-letter-client" // the id of the client the message was dropped for
+)
+
+// DeadLetter republishes a message to the dead-letter topic with a reason, in the same way
+// as messages dropped by the server, such as for hooks which reject invalid messages. The
+// client is the client the message was dropped for, or rejected from. Nothing is published
+// if no dead-letter topic is set.
+func (s *Server) DeadLetter(cl *Client, pk packets.Packet, reason string) {
+	s.deadLetter(cl, pk, reason)
+}
+
+// deadLetter republishes a publish which was dropped instead of being delivered to a client
+// to the dead-letter topic, if one is set, with user properties describing why and for whom
+// it was dropped. Messages on the dead-letter topic are never themselves dead-lettered, so a
+// saturated dead-letter subscriber cannot cause a loop.
+func (s *Server) deadLetter(cl *Client, pk packets.Packet, reason string) {
+	topic := s.Options.DeadLetterTopic
+	if topic == "" || pk.FixedHeader.Type != packets.Publish {
+		return
+	}
+
+	if pk.TopicName == topic || strings.HasPrefix(pk.TopicName, topic+"/") {
+		return
+	}
+
+	out := pk.Copy(false)
+	out.TopicName = topic
+	out.FixedHeader.Retain = false
+	out.FixedHeader.Dup = false
+	out.Created = s.Options.now().Unix()
+	out.Received = 0
+
+	// copy the user properties so the metadata is not appended into a shared backing array
+	user := make([]packets.UserProperty, len(out.Properties.User), len(out.Properties.User)+3)
+	copy(user, out.Properties.User)
+	out.Properties.User = append(user,
+		packets.UserProperty{Key: DeadLetterReasonProperty, Val: reason},
+		packets.UserProperty{Key: DeadLetterTopicProperty, Val: pk.TopicName},
+		packets.UserProperty{Key: DeadLetterClientProperty, Val: cl.ID},
+	)
+
+	s.Log.Debug("dead lettered message", "client", cl.ID, "topic", pk.TopicName, "reason", reason)
+	s.publishToSubscribers(out)
+}
+
+// deadLetterInflight republishes an inflight publish which was dropped to the dead-letter
+// topic. As inflight messages are stored as they were sent to the client, the topic name is
+// restored from any outbound topic alias, and any payload compression is reversed.
+func (s *Server) deadLetterInflight(cl *Client, pk packets.Packet, reason string) {
+	if s.Options.DeadLetterTopic == "" || pk.FixedHeader.Type != packets.Publish {
+		return
+	}
+
+	topic := pk.TopicName
+	if topic == "" && pk.Properties.TopicAliasFlag {
+		topic = cl.State.TopicAliases.Outbound.Topic(pk.Properties.TopicAlias)
+	}
+
+	pk = pk.Copy(false) // the copy drops the topic alias, which belongs to the connection of the client
+	pk.TopicName = topic
+	pk.Properties.SubscriptionIdentifier = nil
+
+	if s.Options.PayloadCompression && !cl.Net.Inline {
+		if err := s.decompressPublish(cl, &pk); err != nil {
+			cl.ops.log.Warn("failed to decompress dead letter", "error", err, "client", cl.ID, "topic", pk.TopicName)
+			return
+		}
+	}
+
+	s.deadLetter(cl, pk, reason)
+}
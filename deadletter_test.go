@@ -0,0 +1,138 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package mqtt
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"github.com/mochi-mqtt/server/v2/packets"
+	"github.com/stretchr/testify/require"
+)
+
+// newDeadLetterClients returns a client subscribed to the dead-letter topic, and a client
+// whose pending writes queue is full.
+func newDeadLetterClients(s *Server) (dead, full *Client) {
+	dead, _, _ = newTestClient()
+	dead.ID = "dead"
+	s.Clients.Add(dead)
+	s.Topics.Subscribe(dead.ID, packets.Subscription{Filter: s.Options.DeadLetterTopic})
+
+	full, _, _ = newTestClient()
+	full.ID = "full"
+	s.Clients.Add(full)
+	for i := int32(0); i < full.ops.options.Capabilities.MaximumClientWritesPending; i++ {
+		full.State.outbound <- new(packets.Packet)
+		atomic.AddInt32(&full.State.outboundQty, 1)
+	}
+
+	return dead, full
+}
+
+func TestServerDeadLetterQueueFull(t *testing.T) {
+	s := newServer()
+	s.Options.DeadLetterTopic = "dlq"
+	dead, full := newDeadLetterClients(s)
+
+	pk := *packets.TPacketData[packets.Publish].Get(packets.TPublishBasicMqtt5).Packet
+	_, err := s.publishToClient(full, packets.Subscription{Filter: pk.TopicName}, pk)
+	require.ErrorIs(t, err, packets.ErrPendingClientWritesExceeded)
+
+	require.Len(t, dead.State.outbound, 1)
+	out := <-dead.State.outbound
+	require.Equal(t, "dlq", out.TopicName)
+	require.Equal(t, pk.Payload, out.Payload)
+	require.Contains(t, out.Properties.User, packets.UserProperty{Key: DeadLetterReasonProperty, Val: DeadLetterQueueFull})
+	require.Contains(t, out.Properties.User, packets.UserProperty{Key: DeadLetterTopicProperty, Val: pk.TopicName})
+	require.Contains(t, out.Properties.User, packets.UserProperty{Key: DeadLetterClientProperty, Val: "full"})
+	require.Len(t, pk.Properties.User, len(out.Properties.User)-3) // the original is not modified
+}
+
+func TestServerDeadLetterDisabled(t *testing.T) {
+	s := newServer()
+	s.Options.DeadLetterTopic = "dlq"
+	dead, full := newDeadLetterClients(s)
+	s.Options.DeadLetterTopic = ""
+
+	pk := *packets.TPacketData[packets.Publish].Get(packets.TPublishBasic).Packet
+	_, err := s.publishToClient(full, packets.Subscription{Filter: pk.TopicName}, pk)
+	require.ErrorIs(t, err, packets.ErrPendingClientWritesExceeded)
+	require.Len(t, dead.State.outbound, 0)
+}
+
+func TestServerDeadLetterNoLoop(t *testing.T) {
+	s := newServer()
+	s.Options.DeadLetterTopic = "dlq"
+	dead, full := newDeadLetterClients(s)
+
+	pk := *packets.TPacketData[packets.Publish].Get(packets.TPublishBasic).Packet
+	pk.TopicName = "dlq"
+	_, err := s.publishToClient(full, packets.Subscription{Filter: pk.TopicName}, pk)
+	require.ErrorIs(t, err, packets.ErrPendingClientWritesExceeded)
+	require.Len(t, dead.State.outbound, 0)
+}
+
+func TestServerDeadLetterEvicted(t *testing.T) {
+	s := newServer()
+	s.Options.DeadLetterTopic = "dlq"
+	s.Options.InflightOverflow = InflightEvictOldest
+	dead, _ := newDeadLetterClients(s)
+
+	cl, _, _ := newTestClient()
+	s.Clients.Add(cl)
+	s.Options.Capabilities.MaximumInflight = 1
+	cl.ops.options.Capabilities.MaximumInflight = 1
+	cl.State.Inflight.Set(packets.Packet{
+		FixedHeader: packets.FixedHeader{Type: packets.Publish, Qos: 1},
+		TopicName:   "a/b",
+		Payload:     []byte("old"),
+		PacketID:    1,
+	})
+
+	pk := *packets.TPacketData[packets.Publish].Get(packets.TPublishQos1).Packet
+	_, err := s.publishToClient(cl, packets.Subscription{Filter: pk.TopicName, Qos: 1}, pk)
+	require.NoError(t, err)
+
+	require.Len(t, dead.State.outbound, 1)
+	out := <-dead.State.outbound
+	require.Equal(t, []byte("old"), out.Payload)
+	require.Contains(t, out.Properties.User, packets.UserProperty{Key: DeadLetterReasonProperty, Val: DeadLetterEvicted})
+	require.Contains(t, out.Properties.User, packets.UserProperty{Key: DeadLetterTopicProperty, Val: "a/b"})
+}
+
+func TestServerDeadLetterExpired(t *testing.T) {
+	s := newServer()
+	s.Options.DeadLetterTopic = "dlq"
+	dead, _ := newDeadLetterClients(s)
+
+	cl, _, _ := newTestClient()
+	cl.ops.info = s.Info
+	cl.State.TopicAliases.Outbound = NewOutboundTopicAliases(5)
+	alias, _ := cl.State.TopicAliases.Outbound.Set("a/b")
+	s.Clients.Add(cl)
+
+	n := s.Options.now().Unix()
+	cl.State.Inflight.Set(packets.Packet{
+		FixedHeader:     packets.FixedHeader{Type: packets.Publish, Qos: 1},
+		ProtocolVersion: 5,
+		Properties:      packets.Properties{TopicAlias: alias, TopicAliasFlag: true},
+		Payload:         []byte("expired"),
+		PacketID:        1,
+		Expiry:          n - 1,
+	})
+	cl.State.Inflight.Set(packets.Packet{FixedHeader: packets.FixedHeader{Type: packets.Pubrel}, ProtocolVersion: 5, PacketID: 2, Expiry: n - 1})
+
+	s.clearExpiredInflights(n)
+	require.Equal(t, 0, cl.State.Inflight.Len())
+
+	require.Len(t, dead.State.outbound, 1)
+	out := <-dead.State.outbound
+	require.Equal(t, "dlq", out.TopicName)
+	require.Equal(t, []byte("expired"), out.Payload)
+	require.False(t, out.Properties.TopicAliasFlag)
+	require.Contains(t, out.Properties.User, packets.UserProperty{Key: DeadLetterReasonProperty, Val: DeadLetterExpired})
+	require.Contains(t, out.Properties.User, packets.UserProperty{Key: DeadLetterTopicProperty, Val: "a/b"})
+	require.Contains(t, out.Properties.User, packets.UserProperty{Key: DeadLetterClientProperty, Val: cl.ID})
+}
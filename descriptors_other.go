@@ -0,0 +1,12 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+//go:build !unix
+
+package mqtt
+
+// descriptorLimit returns 0, as the open file descriptor limit is unknown on this platform.
+func descriptorLimit() int64 {
+	return 0
+}
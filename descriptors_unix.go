@@ -0,0 +1,27 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+//go:build unix
+
+package mqtt
+
+import (
+	"math"
+	"syscall"
+)
+
+// descriptorLimit returns the soft limit on open file descriptors for the process, or
+// 0 if the limit is unknown or unlimited.
+func descriptorLimit() int64 {
+	var rl syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rl); err != nil {
+		return 0
+	}
+
+	if uint64(rl.Cur) >= math.MaxInt64 {
+		return 0
+	}
+
+	return int64(rl.Cur)
+}
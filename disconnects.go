@@ -0,0 +1,63 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package mqtt
+
+import (
+	"errors"
+
+	"github.com/mochi-mqtt/server/v2/packets"
+)
+
+// DisconnectReason classifies why a client connection ended.
+type DisconnectReason string
+
+const (
+	DisconnectClean     DisconnectReason = "clean"             // the client sent a DISCONNECT packet
+	DisconnectKeepalive DisconnectReason = "keepalive_timeout" // the client did not send a packet within its keepalive
+	DisconnectRead      DisconnectReason = "read_error"        // the connection failed or was closed by the client without a DISCONNECT
+	DisconnectWrite     DisconnectReason = "write_error"       // a packet could not be written to the connection
+	DisconnectProtocol  DisconnectReason = "protocol_error"    // the client sent a malformed packet or violated the protocol
+	DisconnectTakeover  DisconnectReason = "takeover"          // another connection took over the session of the client
+	DisconnectShutdown  DisconnectReason = "shutdown"          // the server is shutting down
+	DisconnectPolicy    DisconnectReason = "policy"            // the server disconnected the client, eg. by administrative action or a limit
+)
+
+// DisconnectEvent describes the end of a client connection.
+type DisconnectEvent struct {
+	Reason DisconnectReason // the classified reason the connection ended
+	Err    error            // the underlying error, if any
+	Expire bool             // the session of the client expires with the connection
+}
+
+// classifyDisconnect returns the reason a client connection ended and the error which
+// caused it, given the error returned by the read loop of the client.
+func classifyDisconnect(cl *Client, err error) DisconnectEvent {
+	cause := cl.StopCause()
+	if cause == nil {
+		cause = err
+	}
+
+	var code packets.Code
+	switch {
+	case errors.Is(cause, packets.CodeDisconnect), errors.Is(cause, packets.CodeDisconnectWillMessage):
+		return DisconnectEvent{Reason: DisconnectClean}
+	case errors.Is(cause, packets.ErrKeepAliveTimeout):
+		return DisconnectEvent{Reason: DisconnectKeepalive, Err: cause}
+	case errors.Is(cause, packets.ErrSessionTakenOver):
+		return DisconnectEvent{Reason: DisconnectTakeover, Err: cause}
+	case errors.Is(cause, packets.ErrServerShuttingDown):
+		return DisconnectEvent{Reason: DisconnectShutdown, Err: cause}
+	case IsViolation(cause):
+		return DisconnectEvent{Reason: DisconnectProtocol, Err: cause}
+	case errors.As(cause, &code) && code.Code >= packets.ErrUnspecifiedError.Code:
+		return DisconnectEvent{Reason: DisconnectPolicy, Err: cause}
+	}
+
+	if werr := cl.WriteError(); werr != nil {
+		return DisconnectEvent{Reason: DisconnectWrite, Err: werr}
+	}
+
+	return DisconnectEvent{Reason: DisconnectRead, Err: cause}
+}
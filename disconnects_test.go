@@ -0,0 +1,112 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package mqtt
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/mochi-mqtt/server/v2/packets"
+	"github.com/stretchr/testify/require"
+)
+
+type disconnectedHook struct {
+	HookBase
+	events chan DisconnectEvent
+}
+
+func (h *disconnectedHook) ID() string {
+	return "disconnected-hook"
+}
+
+func (h *disconnectedHook) Provides(b byte) bool {
+	return b == OnDisconnected
+}
+
+func (h *disconnectedHook) OnDisconnected(cl *Client, ev DisconnectEvent) {
+	h.events <- ev
+}
+
+func TestClassifyDisconnect(t *testing.T) {
+	errWrite := errors.New("broken pipe")
+	tt := []struct {
+		desc   string
+		cause  error
+		err    error
+		write  error
+		reason DisconnectReason
+		expect error
+	}{
+		{desc: "clean", cause: packets.CodeDisconnect, reason: DisconnectClean},
+		{desc: "clean with will", cause: packets.CodeDisconnectWillMessage, reason: DisconnectClean},
+		{desc: "keepalive", cause: packets.ErrKeepAliveTimeout, err: io.EOF, reason: DisconnectKeepalive, expect: packets.ErrKeepAliveTimeout},
+		{desc: "takeover", cause: packets.ErrSessionTakenOver, err: net.ErrClosed, reason: DisconnectTakeover, expect: packets.ErrSessionTakenOver},
+		{desc: "shutdown", cause: packets.ErrServerShuttingDown, reason: DisconnectShutdown, expect: packets.ErrServerShuttingDown},
+		{desc: "protocol", err: packets.ErrProtocolViolationNoPacketID, reason: DisconnectProtocol, expect: packets.ErrProtocolViolationNoPacketID},
+		{desc: "policy", cause: packets.ErrAdministrativeAction, reason: DisconnectPolicy, expect: packets.ErrAdministrativeAction},
+		{desc: "read", err: io.EOF, reason: DisconnectRead, expect: io.EOF},
+		{desc: "write", err: io.EOF, write: errWrite, reason: DisconnectWrite, expect: errWrite},
+	}
+
+	for _, tx := range tt {
+		t.Run(tx.desc, func(t *testing.T) {
+			cl, _, _ := newTestClient()
+			if tx.cause != nil {
+				cl.Stop(tx.cause)
+			}
+			if tx.write != nil {
+				cl.State.writeErr.Store(writeError{tx.write})
+			}
+
+			ev := classifyDisconnect(cl, tx.err)
+			require.Equal(t, tx.reason, ev.Reason)
+			require.Equal(t, tx.expect, ev.Err)
+		})
+	}
+}
+
+func TestClientWriteError(t *testing.T) {
+	cl, r, _ := newTestClient()
+	require.NoError(t, cl.WriteError())
+
+	_ = r.Close()
+	err := cl.WritePacket(packets.Packet{FixedHeader: packets.FixedHeader{Type: packets.Pingresp}})
+	require.Error(t, err)
+	require.ErrorIs(t, cl.WriteError(), err)
+
+	_ = cl.WritePacket(packets.Packet{FixedHeader: packets.FixedHeader{Type: packets.Pingresp}})
+	require.Equal(t, err, cl.WriteError())
+}
+
+func TestEstablishConnectionDisconnected(t *testing.T) {
+	s := newServer()
+	h := &disconnectedHook{events: make(chan DisconnectEvent, 1)}
+	require.NoError(t, s.AddHook(h, nil))
+	defer s.Close()
+
+	r, w := net.Pipe()
+	o := make(chan error)
+	go func() {
+		o <- s.EstablishConnection("tcp", r)
+	}()
+
+	go func() {
+		_, _ = w.Write(packets.TPacketData[packets.Connect].Get(packets.TConnectMqtt5).RawBytes)
+		_, _ = w.Write(packets.TPacketData[packets.Disconnect].Get(packets.TDisconnect).RawBytes)
+	}()
+
+	go func() {
+		_, _ = io.ReadAll(w)
+	}()
+
+	require.NoError(t, <-o)
+	_ = r.Close()
+
+	ev := <-h.events
+	require.Equal(t, DisconnectClean, ev.Reason)
+	require.NoError(t, ev.Err)
+}
@@ -46,15 +46,35 @@ const (
 	OnQosComplete
 	OnQosDropped
 	OnPacketIDExhausted
+	OnInflightOverflow
 	OnWill
 	OnWillSent
 	OnClientExpired
 	OnRetainedExpired
+	OnBanned
+	OnUnbanned
+	OnPacket
+	OnCompact
+	OnRetainSet
+	OnRetainCleared
+	OnClientForgotten
+	OnError
+	OnConnectNegotiate
+	OnDisconnected
 	StoredClients
 	StoredSubscriptions
 	StoredInflightMessages
 	StoredRetainedMessages
 	StoredSysInfo
+	StoredBans
+	StoredArchivedMessages
+)
+
+// Packet directions indicating whether raw packet bytes passed to OnPacket were
+// received from or written to the client.
+const (
+	PacketInbound  byte = iota // bytes received from the client
+	PacketOutbound             // bytes written to the client
 )
 
 var (
@@ -83,9 +103,11 @@ type Hook interface {
 	OnACLCheck(cl *Client, topic string, write bool) bool
 	OnSysInfoTick(*system.Info)
 	OnConnect(cl *Client, pk packets.Packet) error
+	OnConnectNegotiate(cl *Client, pk packets.Packet, n Negotiation) (Negotiation, error)
 	OnSessionEstablish(cl *Client, pk packets.Packet)
 	OnSessionEstablished(cl *Client, pk packets.Packet)
 	OnDisconnect(cl *Client, err error, expire bool)
+	OnDisconnected(cl *Client, ev DisconnectEvent)
 	OnAuthPacket(cl *Client, pk packets.Packet) (packets.Packet, error)
 	OnPacketRead(cl *Client, pk packets.Packet) (packets.Packet, error) // triggers when a new packet is received by a client, but before packet validation
 	OnPacketEncode(cl *Client, pk packets.Packet) packets.Packet        // modify a packet before it is byte-encoded and written to the client
@@ -105,20 +127,35 @@ type Hook interface {
 	OnQosComplete(cl *Client, pk packets.Packet)
 	OnQosDropped(cl *Client, pk packets.Packet)
 	OnPacketIDExhausted(cl *Client, pk packets.Packet)
+	OnInflightOverflow(cl *Client, pk packets.Packet)
 	OnWill(cl *Client, will Will) (Will, error)
 	OnWillSent(cl *Client, pk packets.Packet)
 	OnClientExpired(cl *Client)
 	OnRetainedExpired(filter string)
+	OnBanned(ban Ban)
+	OnUnbanned(ban Ban)
+	OnPacket(cl *Client, direction byte, b []byte) // triggers with the raw wire bytes of each packet received from or written to the client
+	OnCompact() error                              // triggers when persistence stores should reclaim space and remove stale records
+	OnRetainSet(cl *Client, pk packets.Packet)     // triggers when a retained message is set or replaced for a topic
+	OnRetainCleared(cl *Client, pk packets.Packet) // triggers when the retained message for a topic is cleared
+	OnClientForgotten(id string) error             // triggers when all data held for a client should be erased
+	OnError(cl *Client, err error)                 // triggers for non-fatal internal errors, such as panics, decode, write, and storage failures
 	StoredClients() ([]storage.Client, error)
 	StoredSubscriptions() ([]storage.Subscription, error)
 	StoredInflightMessages() ([]storage.Message, error)
 	StoredRetainedMessages() ([]storage.Message, error)
 	StoredSysInfo() (storage.SystemInfo, error)
+	StoredBans() ([]storage.Ban, error)
+	StoredArchivedMessages(filter string, from, to int64) ([]storage.Message, error)
 }
 
 // HookOptions contains values which are inherited from the server on initialisation.
 type HookOptions struct {
 	Capabilities *Capabilities
+
+	// ReportError passes a non-fatal error encountered by a hook, such as a failure writing
+	// to a persistent store, to the OnError hooks of the server. Client may be nil.
+	ReportError func(cl *Client, subsystem string, err error)
 }
 
 // Hooks is a slice of Hook interfaces to be called in sequence.
@@ -265,6 +302,16 @@ func (h *Hooks) OnDisconnect(cl *Client, err error, expire bool) {
 	}
 }
 
+// OnDisconnected is called after OnDisconnect with the classified reason the connection of
+// a client ended, such as a clean disconnect, keepalive timeout, or session takeover.
+func (h *Hooks) OnDisconnected(cl *Client, ev DisconnectEvent) {
+	for _, hook := range h.GetAll() {
+		if hook.Provides(OnDisconnected) {
+			hook.OnDisconnected(cl, ev)
+		}
+	}
+}
+
 // OnPacketRead is called when a packet is received from a client.
 func (h *Hooks) OnPacketRead(cl *Client, pk packets.Packet) (pkx packets.Packet, err error) {
 	pkx = pk
@@ -346,7 +393,9 @@ func (h *Hooks) OnSubscribe(cl *Client, pk packets.Packet) packets.Packet {
 	return pk
 }
 
-// OnSubscribed is called when a client subscribes to one or more filters.
+// OnSubscribed is called when a client subscribes to one or more filters. The filters of
+// the packet carry the qos granted to each subscription after any changes by OnSubscribe and
+// the server maximum qos, and the reason codes are those sent to the client in the SUBACK.
 func (h *Hooks) OnSubscribed(cl *Client, pk packets.Packet, reasonCodes []byte) {
 	for _, hook := range h.GetAll() {
 		if hook.Provides(OnSubscribed) {
@@ -381,7 +430,9 @@ func (h *Hooks) OnUnsubscribe(cl *Client, pk packets.Packet) packets.Packet {
 	return pk
 }
 
-// OnUnsubscribed is called when a client unsubscribes from one or more filters.
+// OnUnsubscribed is called when a client unsubscribes from one or more filters. The reason
+// codes of the packet hold the outcome for each filter, such as success, no subscription
+// existed, or topic filter invalid.
 func (h *Hooks) OnUnsubscribed(cl *Client, pk packets.Packet) {
 	for _, hook := range h.GetAll() {
 		if hook.Provides(OnUnsubscribed) {
@@ -499,6 +550,16 @@ func (h *Hooks) OnPacketIDExhausted(cl *Client, pk packets.Packet) {
 	}
 }
 
+// OnInflightOverflow is called when a message cannot be sent to a client because its
+// inflight store is full, before the inflight overflow policy is applied.
+func (h *Hooks) OnInflightOverflow(cl *Client, pk packets.Packet) {
+	for _, hook := range h.GetAll() {
+		if hook.Provides(OnInflightOverflow) {
+			hook.OnInflightOverflow(cl, pk)
+		}
+	}
+}
+
 // OnWill is called when a client disconnects and publishes an LWT message. This method
 // differs from OnWillSent in that it allows you to modify the LWT message before it is
 // published. The return values of the hook methods are passed-through in the order
@@ -548,6 +609,121 @@ func (h *Hooks) OnRetainedExpired(filter string) {
 	}
 }
 
+// OnBanned is called when a ban is added to the server.
+func (h *Hooks) OnBanned(ban Ban) {
+	for _, hook := range h.GetAll() {
+		if hook.Provides(OnBanned) {
+			hook.OnBanned(ban)
+		}
+	}
+}
+
+// OnUnbanned is called when a ban is removed from the server or has expired.
+func (h *Hooks) OnUnbanned(ban Ban) {
+	for _, hook := range h.GetAll() {
+		if hook.Provides(OnUnbanned) {
+			hook.OnUnbanned(ban)
+		}
+	}
+}
+
+// OnPacket is called with the raw wire bytes of every packet received from or written
+// to a client. The direction is either PacketInbound or PacketOutbound. Inbound bytes
+// are assembled from the decoded fixed header and the remaining packet bytes.
+func (h *Hooks) OnPacket(cl *Client, direction byte, b []byte) {
+	for _, hook := range h.GetAll() {
+		if hook.Provides(OnPacket) {
+			hook.OnPacket(cl, direction, b)
+		}
+	}
+}
+
+// OnCompact is called when the server requests that persistence stores reclaim space
+// and remove stale records, either on schedule or on demand. The errors of all hooks
+// are joined and returned.
+func (h *Hooks) OnCompact() error {
+	var errs []error
+	for _, hook := range h.GetAll() {
+		if hook.Provides(OnCompact) {
+			if err := hook.OnCompact(); err != nil {
+				h.Log.Error("failed to compact store", "error", err, "hook", hook.ID())
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// OnRetainSet is called when a published message is set as the retained message for
+// a topic, replacing any existing retained message.
+func (h *Hooks) OnRetainSet(cl *Client, pk packets.Packet) {
+	for _, hook := range h.GetAll() {
+		if hook.Provides(OnRetainSet) {
+			hook.OnRetainSet(cl, pk)
+		}
+	}
+}
+
+// OnRetainCleared is called when a published message with an empty payload clears
+// the existing retained message for a topic.
+func (h *Hooks) OnRetainCleared(cl *Client, pk packets.Packet) {
+	for _, hook := range h.GetAll() {
+		if hook.Provides(OnRetainCleared) {
+			hook.OnRetainCleared(cl, pk)
+		}
+	}
+}
+
+// OnClientForgotten is called when all data held for a client should be erased, such
+// as for a right to be forgotten request. Hooks which keep records of the client, such
+// as audit logs or message archives, should delete them. The errors of all hooks are
+// joined and returned.
+func (h *Hooks) OnClientForgotten(id string) error {
+	var errs []error
+	for _, hook := range h.GetAll() {
+		if hook.Provides(OnClientForgotten) {
+			if err := hook.OnClientForgotten(id); err != nil {
+				h.Log.Error("failed to forget client", "error", err, "hook", hook.ID(), "client", id)
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// OnError is called for each non-fatal internal error encountered by the server, such as a
+// panic in a hook or packet handler, a malformed packet, a failed write to a client, or a
+// failed write to a persistent store. The error is an *InternalError identifying the
+// subsystem and the client, if any. A panic wraps a *PanicError containing the stack trace.
+func (h *Hooks) OnError(cl *Client, err error) {
+	for _, hook := range h.GetAll() {
+		if hook.Provides(OnError) {
+			hook.OnError(cl, err)
+		}
+	}
+}
+
+// OnConnectNegotiate is called with the values to be sent in the CONNACK of a connecting client,
+// after it has been authenticated and before any existing session is taken over. Hooks may adjust
+// the keepalive and maximum qos of the client, or return a packets.Code as an error to reject the
+// connection. The return values of the hook methods are passed-through in the order the hooks
+// were attached.
+func (h *Hooks) OnConnectNegotiate(cl *Client, pk packets.Packet, n Negotiation) (Negotiation, error) {
+	for _, hook := range h.GetAll() {
+		if hook.Provides(OnConnectNegotiate) {
+			nx, err := hook.OnConnectNegotiate(cl, pk, n)
+			if err != nil {
+				return n, err
+			}
+			n = nx
+		}
+	}
+
+	return n, nil
+}
+
 // StoredClients returns all clients, e.g. from a persistent store, is used to
 // populate the server clients list before start.
 func (h *Hooks) StoredClients() (v []storage.Client, err error) {
@@ -647,6 +823,46 @@ func (h *Hooks) StoredSysInfo() (v storage.SystemInfo, err error) {
 	return
 }
 
+// StoredBans returns all bans, e.g. from a persistent store, and is used to
+// populate the server ban list before start.
+func (h *Hooks) StoredBans() (v []storage.Ban, err error) {
+	for _, hook := range h.GetAll() {
+		if hook.Provides(StoredBans) {
+			v, err := hook.StoredBans()
+			if err != nil {
+				h.Log.Error("failed to load bans", "error", err, "hook", hook.ID())
+				return v, err
+			}
+
+			if len(v) > 0 {
+				return v, nil
+			}
+		}
+	}
+
+	return
+}
+
+// StoredArchivedMessages returns archived messages matching a filter which were created
+// between from and to (unix seconds, inclusive), and is used to replay messages.
+func (h *Hooks) StoredArchivedMessages(filter string, from, to int64) (v []storage.Message, err error) {
+	for _, hook := range h.GetAll() {
+		if hook.Provides(StoredArchivedMessages) {
+			v, err := hook.StoredArchivedMessages(filter, from, to)
+			if err != nil {
+				h.Log.Error("failed to load archived messages", "error", err, "hook", hook.ID())
+				return v, err
+			}
+
+			if len(v) > 0 {
+				return v, nil
+			}
+		}
+	}
+
+	return
+}
+
 // OnConnectAuthenticate is called when a user attempts to authenticate with the server.
 // An implementation of this method MUST be used to allow or deny access to the
 // server (see hooks/auth/allow_all or basic). It can be used in custom hooks to
@@ -711,6 +927,14 @@ func (h *HookBase) SetOpts(l *slog.Logger, opts *HookOptions) {
 	h.Opts = opts
 }
 
+// ReportError passes a non-fatal error in a subsystem to the OnError hooks of the server,
+// if the hook is attached to a server. Client may be nil.
+func (h *HookBase) ReportError(cl *Client, subsystem string, err error) {
+	if h.Opts != nil && h.Opts.ReportError != nil {
+		h.Opts.ReportError(cl, subsystem, err)
+	}
+}
+
 // Stop is called to gracefully shut down the hook.
 func (h *HookBase) Stop() error {
 	return nil
@@ -750,6 +974,9 @@ func (h *HookBase) OnSessionEstablished(cl *Client, pk packets.Packet) {}
 // OnDisconnect is called when a client is disconnected for any reason.
 func (h *HookBase) OnDisconnect(cl *Client, err error, expire bool) {}
 
+// OnDisconnected is called with the classified reason a client connection ended.
+func (h *HookBase) OnDisconnected(cl *Client, ev DisconnectEvent) {}
+
 // OnAuthPacket is called when an auth packet is received from the client.
 func (h *HookBase) OnAuthPacket(cl *Client, pk packets.Packet) (packets.Packet, error) {
 	return pk, nil
@@ -821,6 +1048,9 @@ func (h *HookBase) OnQosDropped(cl *Client, pk packets.Packet) {}
 // OnPacketIDExhausted is called when the client runs out of unused packet ids to assign to a packet.
 func (h *HookBase) OnPacketIDExhausted(cl *Client, pk packets.Packet) {}
 
+// OnInflightOverflow is called when a message cannot be sent to a client because its inflight store is full.
+func (h *HookBase) OnInflightOverflow(cl *Client, pk packets.Packet) {}
+
 // OnWill is called when a client disconnects and publishes an LWT message.
 func (h *HookBase) OnWill(cl *Client, will Will) (Will, error) {
 	return will, nil
@@ -835,6 +1065,39 @@ func (h *HookBase) OnClientExpired(cl *Client) {}
 // OnRetainedExpired is called when a retained message for a topic has expired.
 func (h *HookBase) OnRetainedExpired(topic string) {}
 
+// OnBanned is called when a ban is added to the server.
+func (h *HookBase) OnBanned(ban Ban) {}
+
+// OnUnbanned is called when a ban is removed from the server or has expired.
+func (h *HookBase) OnUnbanned(ban Ban) {}
+
+// OnPacket is called with the raw wire bytes of each packet sent or received.
+func (h *HookBase) OnPacket(cl *Client, direction byte, b []byte) {}
+
+// OnCompact is called when persistence stores should be compacted.
+func (h *HookBase) OnCompact() error {
+	return nil
+}
+
+// OnRetainSet is called when a retained message is set for a topic.
+func (h *HookBase) OnRetainSet(cl *Client, pk packets.Packet) {}
+
+// OnRetainCleared is called when the retained message for a topic is cleared.
+func (h *HookBase) OnRetainCleared(cl *Client, pk packets.Packet) {}
+
+// OnClientForgotten is called when all data held for a client should be erased.
+func (h *HookBase) OnClientForgotten(id string) error {
+	return nil
+}
+
+// OnError is called for each non-fatal internal error encountered by the server.
+func (h *HookBase) OnError(cl *Client, err error) {}
+
+// OnConnectNegotiate is called with the values to be sent in the CONNACK of a connecting client.
+func (h *HookBase) OnConnectNegotiate(cl *Client, pk packets.Packet, n Negotiation) (Negotiation, error) {
+	return n, nil
+}
+
 // StoredClients returns all clients from a store.
 func (h *HookBase) StoredClients() (v []storage.Client, err error) {
 	return
@@ -859,3 +1122,13 @@ func (h *HookBase) StoredRetainedMessages() (v []storage.Message, err error) {
 func (h *HookBase) StoredSysInfo() (v storage.SystemInfo, err error) {
 	return
 }
+
+// StoredBans returns all bans from a store.
+func (h *HookBase) StoredBans() (v []storage.Ban, err error) {
+	return
+}
+
+// StoredArchivedMessages returns archived messages matching a filter from a store.
+func (h *HookBase) StoredArchivedMessages(filter string, from, to int64) (v []storage.Message, err error) {
+	return
+}
@@ -0,0 +1,182 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+// Package archive provides an in-memory message archive hook which records published
+// messages so they can be replayed to late joining clients with Server.Replay.
+package archive
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/storage"
+	"github.com/mochi-mqtt/server/v2/packets"
+)
+
+const (
+	defaultMaxMessages = 10000 // the default maximum number of archived messages
+	archiveKey         = "ARC" // unique key to denote archived messages
+)
+
+// Options contains configuration settings for the archive.
+type Options struct {
+	Filters     []string `yaml:"filters" json:"filters"`           // topic filters of messages to archive; all non-$SYS messages if empty
+	MaxMessages int      `yaml:"max_messages" json:"max_messages"` // maximum number of messages kept; oldest are discarded first
+	MaxAge      int64    `yaml:"max_age" json:"max_age"`           // maximum age of archived messages in seconds, unlimited if 0
+}
+
+// Hook is an in-memory message archive.
+type Hook struct {
+	mqtt.HookBase
+	config   *Options          // hook configuration
+	messages []storage.Message // archived messages, oldest first
+	sync.RWMutex
+}
+
+// ID returns the id of the hook.
+func (h *Hook) ID() string {
+	return "archive"
+}
+
+// Provides indicates which hook methods this hook provides.
+func (h *Hook) Provides(b byte) bool {
+	return bytes.Contains([]byte{
+		mqtt.OnPublished,
+		mqtt.OnClientForgotten,
+		mqtt.StoredArchivedMessages,
+	}, []byte{b})
+}
+
+// Init initializes the archive.
+func (h *Hook) Init(config any) error {
+	if _, ok := config.(*Options); !ok && config != nil {
+		return mqtt.ErrInvalidConfigType
+	}
+
+	if config == nil {
+		config = new(Options)
+	}
+
+	h.config = config.(*Options)
+	if h.config.MaxMessages <= 0 {
+		h.config.MaxMessages = defaultMaxMessages
+	}
+
+	return nil
+}
+
+// Len returns the number of archived messages.
+func (h *Hook) Len() int {
+	h.RLock()
+	defer h.RUnlock()
+	return len(h.messages)
+}
+
+// OnPublished archives messages published to matching topics.
+func (h *Hook) OnPublished(cl *mqtt.Client, pk packets.Packet) {
+	if !h.matches(pk.TopicName) {
+		return
+	}
+
+	props := pk.Properties.Copy(false)
+	msg := storage.Message{
+		ID:          archiveKey + "_" + pk.TopicName,
+		T:           archiveKey,
+		FixedHeader: pk.FixedHeader,
+		TopicName:   pk.TopicName,
+		Payload:     append([]byte{}, pk.Payload...),
+		Created:     pk.Created,
+		Origin:      pk.Origin,
+		Properties: storage.MessageProperties{
+			PayloadFormat:         props.PayloadFormat,
+			PayloadFormatFlag:     props.PayloadFormatFlag,
+			MessageExpiryInterval: props.MessageExpiryInterval,
+			ContentType:           props.ContentType,
+			ResponseTopic:         props.ResponseTopic,
+			CorrelationData:       props.CorrelationData,
+			User:                  props.User,
+		},
+	}
+
+	if msg.Created == 0 {
+		msg.Created = time.Now().Unix()
+	}
+
+	h.Lock()
+	defer h.Unlock()
+	h.messages = append(h.messages, msg)
+	h.trim(msg.Created)
+}
+
+// OnClientForgotten discards the archived messages published by a client.
+func (h *Hook) OnClientForgotten(id string) error {
+	h.Lock()
+	defer h.Unlock()
+
+	kept := h.messages[:0]
+	for _, msg := range h.messages {
+		if msg.Origin != id {
+			kept = append(kept, msg)
+		}
+	}
+
+	clear(h.messages[len(kept):]) // release the payloads of discarded messages
+	h.messages = kept
+	return nil
+}
+
+// StoredArchivedMessages returns archived messages matching the filter which were
+// created between from and to (unix seconds, inclusive). A to value of 0 is unbounded.
+func (h *Hook) StoredArchivedMessages(filter string, from, to int64) (v []storage.Message, err error) {
+	h.RLock()
+	defer h.RUnlock()
+	for _, msg := range h.messages {
+		if msg.Created < from || (to > 0 && msg.Created > to) {
+			continue
+		}
+
+		if mqtt.MatchTopic(filter, msg.TopicName) {
+			v = append(v, msg)
+		}
+	}
+
+	return v, nil
+}
+
+// matches returns true if the topic should be archived.
+func (h *Hook) matches(topic string) bool {
+	if len(h.config.Filters) == 0 {
+		return !strings.HasPrefix(topic, mqtt.SysPrefix)
+	}
+
+	for _, filter := range h.config.Filters {
+		if mqtt.MatchTopic(filter, topic) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// trim discards the oldest messages which exceed the size or age limits.
+// The caller must hold the write lock.
+func (h *Hook) trim(now int64) {
+	start := 0
+	if over := len(h.messages) - h.config.MaxMessages; over > 0 {
+		start = over
+	}
+
+	if h.config.MaxAge > 0 {
+		for start < len(h.messages) && h.messages[start].Created < now-h.config.MaxAge {
+			start++
+		}
+	}
+
+	if start > 0 {
+		h.messages = append(h.messages[:0:0], h.messages[start:]...)
+	}
+}
@@ -0,0 +1,168 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package archive
+
+import (
+	"log/slog"
+	"os"
+	"testing"
+	"time"
+
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/packets"
+
+	"github.com/stretchr/testify/require"
+)
+
+var logger = slog.New(slog.NewTextHandler(os.Stdout, nil))
+
+func newHook(t *testing.T, opts *Options) *Hook {
+	h := new(Hook)
+	h.SetOpts(logger, nil)
+	err := h.Init(opts)
+	require.NoError(t, err)
+	return h
+}
+
+func TestID(t *testing.T) {
+	h := new(Hook)
+	require.Equal(t, "archive", h.ID())
+}
+
+func TestProvides(t *testing.T) {
+	h := new(Hook)
+	require.True(t, h.Provides(mqtt.OnPublished))
+	require.True(t, h.Provides(mqtt.StoredArchivedMessages))
+	require.True(t, h.Provides(mqtt.OnClientForgotten))
+	require.False(t, h.Provides(mqtt.OnPublish))
+}
+
+func TestInitBadConfig(t *testing.T) {
+	h := new(Hook)
+	h.SetOpts(logger, nil)
+	err := h.Init(map[string]any{})
+	require.ErrorIs(t, err, mqtt.ErrInvalidConfigType)
+}
+
+func TestInitUseDefaults(t *testing.T) {
+	h := new(Hook)
+	h.SetOpts(logger, nil)
+	err := h.Init(nil)
+	require.NoError(t, err)
+	require.Equal(t, defaultMaxMessages, h.config.MaxMessages)
+	require.Equal(t, int64(0), h.config.MaxAge)
+}
+
+func TestOnPublishedDefaultSkipsSys(t *testing.T) {
+	h := newHook(t, new(Options))
+	cl := &mqtt.Client{ID: "mochi"}
+	h.OnPublished(cl, packets.Packet{TopicName: "a/b/c", Payload: []byte("hello")})
+	h.OnPublished(cl, packets.Packet{TopicName: "$SYS/broker/uptime", Payload: []byte("1")})
+	require.Equal(t, 1, h.Len())
+	require.Equal(t, "a/b/c", h.messages[0].TopicName)
+	require.Equal(t, []byte("hello"), h.messages[0].Payload)
+	require.Greater(t, h.messages[0].Created, int64(0))
+}
+
+func TestOnPublishedFiltered(t *testing.T) {
+	h := newHook(t, &Options{Filters: []string{"sensors/#"}})
+	cl := &mqtt.Client{ID: "mochi"}
+	h.OnPublished(cl, packets.Packet{TopicName: "sensors/a/temp"})
+	h.OnPublished(cl, packets.Packet{TopicName: "other/a/temp"})
+	require.Equal(t, 1, h.Len())
+}
+
+func TestOnPublishedMaxMessages(t *testing.T) {
+	h := newHook(t, &Options{MaxMessages: 2})
+	cl := &mqtt.Client{ID: "mochi"}
+	h.OnPublished(cl, packets.Packet{TopicName: "a", Created: 1})
+	h.OnPublished(cl, packets.Packet{TopicName: "b", Created: 2})
+	h.OnPublished(cl, packets.Packet{TopicName: "c", Created: 3})
+	require.Equal(t, 2, h.Len())
+	require.Equal(t, "b", h.messages[0].TopicName)
+	require.Equal(t, "c", h.messages[1].TopicName)
+}
+
+func TestOnPublishedMaxAge(t *testing.T) {
+	h := newHook(t, &Options{MaxAge: 10})
+	cl := &mqtt.Client{ID: "mochi"}
+	now := time.Now().Unix()
+	h.OnPublished(cl, packets.Packet{TopicName: "a", Created: now - 20})
+	h.OnPublished(cl, packets.Packet{TopicName: "b", Created: now - 5})
+	h.OnPublished(cl, packets.Packet{TopicName: "c", Created: now})
+	require.Equal(t, 2, h.Len())
+	require.Equal(t, "b", h.messages[0].TopicName)
+}
+
+func TestOnClientForgotten(t *testing.T) {
+	h := newHook(t, new(Options))
+	cl := &mqtt.Client{ID: "mochi"}
+	h.OnPublished(cl, packets.Packet{TopicName: "a", Origin: "mochi", Payload: []byte("1")})
+	h.OnPublished(cl, packets.Packet{TopicName: "b", Origin: "zen", Payload: []byte("2")})
+	h.OnPublished(cl, packets.Packet{TopicName: "c", Origin: "mochi", Payload: []byte("3")})
+
+	require.NoError(t, h.OnClientForgotten("mochi"))
+	require.Equal(t, 1, h.Len())
+	require.Equal(t, "zen", h.messages[0].Origin)
+}
+
+func TestStoredArchivedMessages(t *testing.T) {
+	h := newHook(t, new(Options))
+	cl := &mqtt.Client{ID: "mochi"}
+	h.OnPublished(cl, packets.Packet{TopicName: "a/b/c", Created: 10})
+	h.OnPublished(cl, packets.Packet{TopicName: "a/b/d", Created: 20})
+	h.OnPublished(cl, packets.Packet{TopicName: "x/y/z", Created: 30})
+
+	v, err := h.StoredArchivedMessages("a/b/#", 0, 0)
+	require.NoError(t, err)
+	require.Len(t, v, 2)
+
+	v, err = h.StoredArchivedMessages("#", 15, 25)
+	require.NoError(t, err)
+	require.Len(t, v, 1)
+	require.Equal(t, "a/b/d", v[0].TopicName)
+
+	v, err = h.StoredArchivedMessages("#", 20, 0)
+	require.NoError(t, err)
+	require.Len(t, v, 2)
+
+	v, err = h.StoredArchivedMessages("q/#", 0, 0)
+	require.NoError(t, err)
+	require.Empty(t, v)
+}
+
+func TestReplayFromArchive(t *testing.T) {
+	s := mqtt.New(&mqtt.Options{
+		Logger:       logger,
+		InlineClient: true,
+	})
+	h := new(Hook)
+	require.NoError(t, s.AddHook(h, nil))
+
+	h.OnPublished(&mqtt.Client{ID: "mochi"}, packets.Packet{
+		FixedHeader: packets.FixedHeader{Type: packets.Publish, Retain: true},
+		TopicName:   "a/b/c",
+		Payload:     []byte("hello"),
+	})
+
+	recv := make(chan packets.Packet, 1)
+	err := s.Subscribe("late/joiner", 1, func(cl *mqtt.Client, sub packets.Subscription, pk packets.Packet) {
+		recv <- pk
+	})
+	require.NoError(t, err)
+
+	n, err := s.Replay("a/b/c", 0, 0, mqtt.ReplayTarget{Topic: "late/joiner"})
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+
+	select {
+	case pk := <-recv:
+		require.Equal(t, "late/joiner", pk.TopicName)
+		require.Equal(t, []byte("hello"), pk.Payload)
+		require.False(t, pk.FixedHeader.Retain)
+	case <-time.After(time.Second):
+		require.Fail(t, "replayed message not received")
+	}
+}
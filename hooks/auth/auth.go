@@ -6,22 +6,40 @@ package auth
 
 import (
 	"bytes"
+	"errors"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
 	mqtt "github.com/mochi-mqtt/server/v2"
 	"github.com/mochi-mqtt/server/v2/packets"
 )
 
+var (
+	// ErrNoRulesPath indicates the rules were reloaded but no rules file path was configured.
+	ErrNoRulesPath = errors.New("auth rules path not configured")
+)
+
 // Options contains the configuration/rules data for the auth ledger.
 type Options struct {
-	Data   []byte
-	Ledger *Ledger
+	Data           []byte
+	Ledger         *Ledger
+	Path           string // a JSON or YAML rules file to load, if neither Data nor Ledger are set
+	WatchInterval  int64  // seconds between checks for changes to the rules file; disabled if 0
+	ReloadOnSignal bool   // reload the rules file when the process receives SIGHUP
 }
 
 // Hook is an authentication hook which implements an auth ledger.
 type Hook struct {
 	mqtt.HookBase
-	config *Options
-	ledger *Ledger
+	config  *Options
+	ledger  *Ledger
+	modTime time.Time     // the modification time of the rules file when it was last loaded
+	size    int64         // the size of the rules file when it was last loaded
+	cancel  chan struct{} // closed to stop watching the rules file
+	sync.Mutex
 }
 
 // ID returns the ID of the hook.
@@ -55,6 +73,8 @@ func (h *Hook) Init(config any) error {
 	} else if len(h.config.Data) > 0 {
 		h.ledger = new(Ledger)
 		err = h.ledger.Unmarshal(h.config.Data)
+	} else if h.config.Path != "" {
+		h.ledger, err = h.readRules()
 	}
 	if err != nil {
 		return err
@@ -71,9 +91,132 @@ func (h *Hook) Init(config any) error {
 		"authentication", len(h.ledger.Auth),
 		"acl", len(h.ledger.ACL))
 
+	if h.config.Path != "" && (h.config.WatchInterval > 0 || h.config.ReloadOnSignal) {
+		var sigs chan os.Signal
+		if h.config.ReloadOnSignal {
+			sigs = make(chan os.Signal, 1)
+			signal.Notify(sigs, syscall.SIGHUP)
+		}
+
+		h.cancel = make(chan struct{})
+		go h.watch(h.cancel, sigs)
+	}
+
+	return nil
+}
+
+// Stop stops watching the rules file for changes.
+func (h *Hook) Stop() error {
+	h.Lock()
+	defer h.Unlock()
+	if h.cancel != nil {
+		close(h.cancel)
+		h.cancel = nil
+	}
+
+	return nil
+}
+
+// Reload reads the rules file and replaces the rules of the ledger, applying them to
+// all subsequent connections and ACL checks without disconnecting existing clients.
+// If the file cannot be read or parsed, the existing rules are kept.
+func (h *Hook) Reload() error {
+	if h.config == nil || h.config.Path == "" {
+		return ErrNoRulesPath
+	}
+
+	ln, err := h.readRules()
+	if err != nil {
+		h.Log.Error("failed to reload auth rules", "error", err, "path", h.config.Path)
+		return err
+	}
+
+	h.ledger.Update(ln)
+	h.Log.Info("reloaded auth rules",
+		"path", h.config.Path,
+		"authentication", len(ln.Auth),
+		"acl", len(ln.ACL))
+
+	return nil
+}
+
+// RotatePassword sets a new password for a user of the ledger, continuing to accept the
+// current password until the grace period has elapsed.
+func (h *Hook) RotatePassword(username, password string, grace time.Duration) error {
+	err := h.ledger.RotatePassword(username, RString(password), grace)
+	if err != nil {
+		return err
+	}
+
+	h.Log.Info("rotated user password", "username", username, "grace", grace.String())
 	return nil
 }
 
+// readRules reads a ledger from the rules file, noting the file modification time and
+// size so that changes can be detected.
+func (h *Hook) readRules() (*Ledger, error) {
+	fi, err := os.Stat(h.config.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(h.config.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	ln := new(Ledger)
+	if err := ln.Unmarshal(data); err != nil {
+		return nil, err
+	}
+
+	h.Lock()
+	h.modTime = fi.ModTime()
+	h.size = fi.Size()
+	h.Unlock()
+
+	return ln, nil
+}
+
+// changed returns true if the rules file has been modified since it was last loaded.
+func (h *Hook) changed() bool {
+	fi, err := os.Stat(h.config.Path)
+	if err != nil {
+		return false
+	}
+
+	h.Lock()
+	defer h.Unlock()
+	return !fi.ModTime().Equal(h.modTime) || fi.Size() != h.size
+}
+
+// watch reloads the rules file when it changes or when a signal is received, until cancelled.
+func (h *Hook) watch(cancel chan struct{}, sigs chan os.Signal) {
+	var tick <-chan time.Time
+	if h.config.WatchInterval > 0 {
+		ticker := time.NewTicker(time.Duration(h.config.WatchInterval) * time.Second)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	if sigs != nil {
+		defer signal.Stop(sigs)
+	}
+
+	for {
+		select {
+		case <-cancel:
+			return
+		case <-tick:
+			if h.changed() {
+				_ = h.Reload()
+			}
+		case <-sigs:
+			_ = h.Reload()
+		}
+	}
+}
+
 // OnConnectAuthenticate returns true if the connecting client has rules which provide access
 // in the auth ledger.
 func (h *Hook) OnConnectAuthenticate(cl *mqtt.Client, pk packets.Packet) bool {
@@ -7,7 +7,10 @@ package auth
 import (
 	"log/slog"
 	"os"
+	"path/filepath"
+	"syscall"
 	"testing"
+	"time"
 
 	mqtt "github.com/mochi-mqtt/server/v2"
 	"github.com/mochi-mqtt/server/v2/packets"
@@ -117,6 +120,144 @@ func TestBasicInitWithLedgerBadDAta(t *testing.T) {
 	require.Error(t, err)
 }
 
+func TestBasicInitWithPath(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "auth.yaml")
+	require.NoError(t, os.WriteFile(path, ledgerYAML, 0600))
+
+	h := new(Hook)
+	h.SetOpts(logger, nil)
+	err := h.Init(&Options{
+		Path: path,
+	})
+
+	require.NoError(t, err)
+	require.Equal(t, ledgerStruct.Auth[0].Username, h.ledger.Auth[0].Username)
+	require.Nil(t, h.cancel)
+}
+
+func TestBasicInitWithPathNotFound(t *testing.T) {
+	h := new(Hook)
+	h.SetOpts(logger, nil)
+	err := h.Init(&Options{
+		Path: filepath.Join(t.TempDir(), "missing.yaml"),
+	})
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "auth.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"auth":[{"username":"a","allow":true}]}`), 0600))
+
+	h := new(Hook)
+	h.SetOpts(logger, nil)
+	err := h.Init(&Options{Path: path})
+	require.NoError(t, err)
+	ledger := h.ledger
+
+	cl := &mqtt.Client{}
+	cl.Properties.Username = []byte("b")
+	pk := packets.Packet{Connect: packets.ConnectParams{Username: []byte("b")}}
+	require.False(t, h.OnConnectAuthenticate(cl, pk))
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"auth":[{"username":"b","allow":true}]}`), 0600))
+	err = h.Reload()
+	require.NoError(t, err)
+	require.Same(t, ledger, h.ledger)
+	require.True(t, h.OnConnectAuthenticate(cl, pk))
+
+	// invalid rules are not applied.
+	require.NoError(t, os.WriteFile(path, []byte("fdsfdsafasd"), 0600))
+	err = h.Reload()
+	require.Error(t, err)
+	require.True(t, h.OnConnectAuthenticate(cl, pk))
+}
+
+func TestReloadNoPath(t *testing.T) {
+	h := new(Hook)
+	h.SetOpts(logger, nil)
+	err := h.Reload()
+	require.ErrorIs(t, err, ErrNoRulesPath)
+
+	err = h.Init(&Options{Data: ledgerJSON})
+	require.NoError(t, err)
+	err = h.Reload()
+	require.ErrorIs(t, err, ErrNoRulesPath)
+}
+
+func TestWatchFileChanged(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "auth.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"auth":[{"username":"a","allow":true}]}`), 0600))
+
+	h := new(Hook)
+	h.SetOpts(logger, nil)
+	err := h.Init(&Options{Path: path, WatchInterval: 1})
+	require.NoError(t, err)
+	defer h.Stop()
+	require.False(t, h.changed())
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"auth":[{"username":"b","allow":true},{"username":"c","allow":true}]}`), 0600))
+	require.Eventually(t, func() bool {
+		h.ledger.Lock()
+		defer h.ledger.Unlock()
+		return len(h.ledger.Auth) == 2
+	}, time.Second*3, time.Millisecond*50)
+}
+
+func TestWatchSignal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "auth.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"auth":[{"username":"a","allow":true}]}`), 0600))
+
+	h := new(Hook)
+	h.SetOpts(logger, nil)
+	err := h.Init(&Options{Path: path, ReloadOnSignal: true})
+	require.NoError(t, err)
+	defer h.Stop()
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"auth":[{"username":"b","allow":true},{"username":"c","allow":true}]}`), 0600))
+	p, err := os.FindProcess(os.Getpid())
+	require.NoError(t, err)
+	require.NoError(t, p.Signal(syscall.SIGHUP))
+	require.Eventually(t, func() bool {
+		h.ledger.Lock()
+		defer h.ledger.Unlock()
+		return len(h.ledger.Auth) == 2
+	}, time.Second*3, time.Millisecond*50)
+}
+
+func TestStop(t *testing.T) {
+	h := new(Hook)
+	h.SetOpts(logger, nil)
+	err := h.Init(&Options{Path: filepath.Join(t.TempDir(), "auth.json"), WatchInterval: 1})
+	require.Error(t, err)
+	require.NoError(t, h.Stop())
+
+	path := filepath.Join(t.TempDir(), "auth.json")
+	require.NoError(t, os.WriteFile(path, ledgerJSON, 0600))
+	err = h.Init(&Options{Path: path, WatchInterval: 1})
+	require.NoError(t, err)
+	require.NotNil(t, h.cancel)
+	require.NoError(t, h.Stop())
+	require.Nil(t, h.cancel)
+}
+
+func TestRotatePassword(t *testing.T) {
+	h := new(Hook)
+	h.SetOpts(logger, nil)
+	err := h.Init(&Options{Ledger: &Ledger{Users: Users{"mochi": {Password: "old"}}}})
+	require.NoError(t, err)
+
+	err = h.RotatePassword("mochi", "new", time.Minute)
+	require.NoError(t, err)
+
+	cl := &mqtt.Client{}
+	cl.Properties.Username = []byte("mochi")
+	require.True(t, h.OnConnectAuthenticate(cl, packets.Packet{Connect: packets.ConnectParams{Password: []byte("old")}}))
+	require.True(t, h.OnConnectAuthenticate(cl, packets.Packet{Connect: packets.ConnectParams{Password: []byte("new")}}))
+
+	err = h.RotatePassword("melon", "new", time.Minute)
+	require.ErrorIs(t, err, ErrUserNotFound)
+}
+
 func TestOnConnectAuthenticate(t *testing.T) {
 	h := new(Hook)
 	h.SetOpts(logger, nil)
@@ -6,8 +6,10 @@ package auth
 
 import (
 	"encoding/json"
+	"errors"
 	"strings"
 	"sync"
+	"time"
 
 	"gopkg.in/yaml.v3"
 
@@ -22,18 +24,44 @@ const (
 	ReadWrite               // user can both publish and subscribe to the topic
 )
 
+var (
+	// ErrUserNotFound indicates a user does not exist in the users map of the ledger.
+	ErrUserNotFound = errors.New("user not found")
+)
+
 // Access determines the read/write privileges for an ACL rule.
 type Access byte
 
+// Claims contains identity attributes of an authenticated user, such as roles, which are
+// stored on the client and can be retrieved by other hooks with Client.Claims.
+type Claims map[string]any
+
 // Users contains a map of access rules for specific users, keyed on username.
 type Users map[string]UserRule
 
 // UserRule defines a set of access rules for a specific user.
 type UserRule struct {
-	Username RString `json:"username,omitempty" yaml:"username,omitempty"` // the username of a user
-	Password RString `json:"password,omitempty" yaml:"password,omitempty"` // the password of a user
-	ACL      Filters `json:"acl,omitempty" yaml:"acl,omitempty"`           // filters to match, if desired
-	Disallow bool    `json:"disallow,omitempty" yaml:"disallow,omitempty"` // allow or disallow the user
+	Username         RString `json:"username,omitempty" yaml:"username,omitempty"`                   // the username of a user
+	Password         RString `json:"password,omitempty" yaml:"password,omitempty"`                   // the password of a user
+	ACL              Filters `json:"acl,omitempty" yaml:"acl,omitempty"`                             // filters to match, if desired
+	Disallow         bool    `json:"disallow,omitempty" yaml:"disallow,omitempty"`                   // allow or disallow the user
+	PreviousPassword RString `json:"previous_password,omitempty" yaml:"previous_password,omitempty"` // a rotated password which is accepted until it expires
+	PreviousExpires  int64   `json:"previous_expires,omitempty" yaml:"previous_expires,omitempty"`   // unix time after which the previous password is rejected
+	Claims           Claims  `json:"claims,omitempty" yaml:"claims,omitempty"`                       // identity claims stored on the client when authenticated
+}
+
+// PasswordOk returns true if the password matches the password of the user, or the
+// previous password of the user before the rotation grace period expires.
+func (u UserRule) PasswordOk(password RString, now int64) bool {
+	if u.Password == "" {
+		return false
+	}
+
+	if u.Password == password {
+		return true
+	}
+
+	return u.PreviousPassword != "" && u.PreviousPassword == password && now < u.PreviousExpires
 }
 
 // AuthRules defines generic access rules applicable to all users.
@@ -45,6 +73,7 @@ type AuthRule struct {
 	Remote   RString `json:"remote,omitempty" yaml:"remote,omitempty"`     // remote address or
 	Password RString `json:"password,omitempty" yaml:"password,omitempty"` // the password of a user
 	Allow    bool    `json:"allow,omitempty" yaml:"allow,omitempty"`       // allow or disallow the users
+	Claims   Claims  `json:"claims,omitempty" yaml:"claims,omitempty"`     // identity claims stored on the client when authenticated
 }
 
 // ACLRules defines generic topic or filter access rules applicable to all users.
@@ -130,18 +159,49 @@ type Ledger struct {
 func (l *Ledger) Update(ln *Ledger) {
 	l.Lock()
 	defer l.Unlock()
+	l.Users = ln.Users
 	l.Auth = ln.Auth
 	l.ACL = ln.ACL
 }
 
-// AuthOk returns true if the rules indicate the user is allowed to authenticate.
+// RotatePassword sets a new password for a user in the users map. If grace is greater than
+// zero, the current password continues to be accepted until the grace period has elapsed,
+// so that clients can be moved to the new password gradually.
+func (l *Ledger) RotatePassword(username string, password RString, grace time.Duration) error {
+	l.Lock()
+	defer l.Unlock()
+
+	u, ok := l.Users[username]
+	if !ok {
+		return ErrUserNotFound
+	}
+
+	u.PreviousPassword = ""
+	u.PreviousExpires = 0
+	if grace > 0 && u.Password != "" && u.Password != password {
+		u.PreviousPassword = u.Password
+		u.PreviousExpires = time.Now().Add(grace).Unix()
+	}
+
+	u.Password = password
+	l.Users[username] = u
+	return nil
+}
+
+// AuthOk returns true if the rules indicate the user is allowed to authenticate. The claims
+// of the matching user or rule, if any, are stored on the client.
 func (l *Ledger) AuthOk(cl *mqtt.Client, pk packets.Packet) (n int, ok bool) {
+	l.Lock()
+	defer l.Unlock()
+
 	// If the users map is set, always check for a predefined user first instead
 	// of iterating through global rules.
 	if l.Users != nil {
 		if u, ok := l.Users[string(cl.Properties.Username)]; ok &&
-			u.Password != "" &&
-			u.Password == RString(pk.Connect.Password) {
+			u.PasswordOk(RString(pk.Connect.Password), time.Now().Unix()) {
+			if !u.Disallow && u.Claims != nil {
+				cl.SetClaims(u.Claims)
+			}
 			return 0, !u.Disallow
 		}
 	}
@@ -153,6 +213,9 @@ func (l *Ledger) AuthOk(cl *mqtt.Client, pk packets.Packet) (n int, ok bool) {
 			rule.Username.Matches(string(cl.Properties.Username)) &&
 			rule.Password.Matches(string(pk.Connect.Password)) &&
 			rule.Remote.Matches(cl.Net.Remote) {
+			if rule.Allow && rule.Claims != nil {
+				cl.SetClaims(rule.Claims)
+			}
 			return n, rule.Allow
 		}
 	}
@@ -163,6 +226,9 @@ func (l *Ledger) AuthOk(cl *mqtt.Client, pk packets.Packet) (n int, ok bool) {
 // ACLOk returns true if the rules indicate the user is allowed to read or write to
 // a specific filter or topic respectively, based on the `write` bool.
 func (l *Ledger) ACLOk(cl *mqtt.Client, topic string, write bool) (n int, ok bool) {
+	l.Lock()
+	defer l.Unlock()
+
 	// If the users map is set, always check for a predefined user first instead
 	// of iterating through global rules.
 	if l.Users != nil {
@@ -6,6 +6,7 @@ package auth
 
 import (
 	"testing"
+	"time"
 
 	"github.com/mochi-mqtt/server/v2"
 	"github.com/mochi-mqtt/server/v2/packets"
@@ -228,6 +229,34 @@ func TestCanAuthenticate(t *testing.T) {
 	}
 }
 
+func TestAuthOkClaims(t *testing.T) {
+	ledger := Ledger{
+		Users: Users{
+			"mochi-co": {Password: "melon", Claims: Claims{"role": "admin"}},
+			"blocked":  {Password: "melon", Disallow: true, Claims: Claims{"role": "admin"}},
+		},
+		Auth: AuthRules{
+			{Username: "device", Allow: true, Claims: Claims{"role": "device"}},
+		},
+	}
+
+	pk := packets.Packet{Connect: packets.ConnectParams{Password: []byte("melon")}}
+	cl := &mqtt.Client{Properties: mqtt.ClientProperties{Username: []byte("mochi-co")}}
+	_, ok := ledger.AuthOk(cl, pk)
+	require.True(t, ok)
+	require.Equal(t, Claims{"role": "admin"}, cl.Claims())
+
+	cl = &mqtt.Client{Properties: mqtt.ClientProperties{Username: []byte("device")}}
+	_, ok = ledger.AuthOk(cl, pk)
+	require.True(t, ok)
+	require.Equal(t, Claims{"role": "device"}, cl.Claims())
+
+	cl = &mqtt.Client{Properties: mqtt.ClientProperties{Username: []byte("blocked")}}
+	_, ok = ledger.AuthOk(cl, pk)
+	require.False(t, ok)
+	require.Nil(t, cl.Claims())
+}
+
 func TestCanACL(t *testing.T) {
 	tt := []struct {
 		client *mqtt.Client
@@ -574,6 +603,45 @@ func TestLedgerUpdate(t *testing.T) {
 	require.NotSame(t, n, old)
 }
 
+func TestUserRulePasswordOk(t *testing.T) {
+	u := UserRule{Password: "new", PreviousPassword: "old", PreviousExpires: 100}
+	require.True(t, u.PasswordOk("new", 200))
+	require.True(t, u.PasswordOk("old", 99))
+	require.False(t, u.PasswordOk("old", 100))
+	require.False(t, u.PasswordOk("", 99))
+	require.False(t, UserRule{}.PasswordOk("", 0))
+}
+
+func TestLedgerRotatePassword(t *testing.T) {
+	l := &Ledger{
+		Users: Users{
+			"mochi": {Password: "old"},
+		},
+	}
+
+	err := l.RotatePassword("mochi", "new", time.Hour)
+	require.NoError(t, err)
+	require.Equal(t, RString("new"), l.Users["mochi"].Password)
+	require.Equal(t, RString("old"), l.Users["mochi"].PreviousPassword)
+	require.Greater(t, l.Users["mochi"].PreviousExpires, time.Now().Unix())
+
+	cl := &mqtt.Client{}
+	cl.Properties.Username = []byte("mochi")
+	_, ok := l.AuthOk(cl, packets.Packet{Connect: packets.ConnectParams{Password: []byte("old")}})
+	require.True(t, ok)
+	_, ok = l.AuthOk(cl, packets.Packet{Connect: packets.ConnectParams{Password: []byte("new")}})
+	require.True(t, ok)
+
+	err = l.RotatePassword("mochi", "newer", 0)
+	require.NoError(t, err)
+	require.Equal(t, RString(""), l.Users["mochi"].PreviousPassword)
+	_, ok = l.AuthOk(cl, packets.Packet{Connect: packets.ConnectParams{Password: []byte("new")}})
+	require.False(t, ok)
+
+	err = l.RotatePassword("melon", "new", time.Hour)
+	require.ErrorIs(t, err, ErrUserNotFound)
+}
+
 func TestLedgerToJSON(t *testing.T) {
 	data, err := ledgerStruct.ToJSON()
 	require.NoError(t, err)
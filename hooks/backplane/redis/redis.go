@@ -0,0 +1,225 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+// Package redis provides a backplane hook which relays published messages between
+// multiple broker instances using redis pub/sub, allowing simple horizontal scaling
+// behind a load balancer without a full cluster.
+package redis
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/storage"
+	"github.com/mochi-mqtt/server/v2/packets"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/rs/xid"
+)
+
+// defaultAddr is the default address to the redis service.
+const defaultAddr = "localhost:6379"
+
+// defaultChannel is the default redis channel messages are relayed on.
+const defaultChannel = "mochi-backplane"
+
+var (
+	// ErrServerRequired indicates the hook was initialised without a server to deliver messages to.
+	ErrServerRequired = errors.New("backplane requires a server")
+)
+
+// Options contains configuration settings for the backplane.
+type Options struct {
+	Server   *mqtt.Server `yaml:"-" json:"-"` // the server to deliver relayed messages to
+	Address  string       `yaml:"address" json:"address"`
+	Username string       `yaml:"username" json:"username"`
+	Password string       `yaml:"password" json:"password"`
+	Database int          `yaml:"database" json:"database"`
+	Channel  string       `yaml:"channel" json:"channel"`
+	Options  *redis.Options
+}
+
+// envelope wraps a relayed message with the id of the instance which published it.
+type envelope struct {
+	Node    string          `json:"node"`
+	Message storage.Message `json:"message"`
+}
+
+// Hook is a backplane hook which relays messages between broker instances through redis.
+type Hook struct {
+	mqtt.HookBase
+	config *Options           // options for connecting to the Redis instance.
+	db     *redis.Client      // the Redis instance
+	sub    *redis.PubSub      // the subscription to the relay channel
+	cl     *mqtt.Client       // an inline client used to deliver relayed messages
+	node   string             // a unique id for this broker instance
+	ctx    context.Context    // a context for the connection
+	cancel context.CancelFunc // cancels the connection context
+	done   chan struct{}      // closed when the relay loop has halted
+}
+
+// ID returns the id of the hook.
+func (h *Hook) ID() string {
+	return "redis-backplane"
+}
+
+// Provides indicates which hook methods this hook provides.
+func (h *Hook) Provides(b byte) bool {
+	return bytes.Contains([]byte{
+		mqtt.OnPublished,
+	}, []byte{b})
+}
+
+// Init initializes and connects to the redis service, and subscribes to the relay channel.
+func (h *Hook) Init(config any) error {
+	if _, ok := config.(*Options); !ok && config != nil {
+		return mqtt.ErrInvalidConfigType
+	}
+
+	if config == nil {
+		config = new(Options)
+	}
+
+	h.config = config.(*Options)
+	if h.config.Server == nil {
+		return ErrServerRequired
+	}
+
+	if h.config.Options == nil {
+		h.config.Options = &redis.Options{
+			Addr:     h.config.Address,
+			DB:       h.config.Database,
+			Username: h.config.Username,
+			Password: h.config.Password,
+		}
+	}
+
+	if h.config.Options.Addr == "" {
+		h.config.Options.Addr = defaultAddr
+	}
+
+	if h.config.Channel == "" {
+		h.config.Channel = defaultChannel
+	}
+
+	h.node = xid.New().String()
+	h.ctx, h.cancel = context.WithCancel(context.Background())
+
+	h.Log.Info(
+		"connecting to redis backplane",
+		"channel", h.config.Channel,
+		"address", h.config.Options.Addr,
+		"node", h.node,
+	)
+
+	h.db = redis.NewClient(h.config.Options)
+	_, err := h.db.Ping(h.ctx).Result()
+	if err != nil {
+		return fmt.Errorf("failed to ping service: %w", err)
+	}
+
+	h.sub = h.db.Subscribe(h.ctx, h.config.Channel)
+	if _, err := h.sub.Receive(h.ctx); err != nil {
+		return fmt.Errorf("failed to subscribe to channel: %w", err)
+	}
+
+	h.cl = h.config.Server.NewClient(nil, mqtt.LocalListener, "backplane-"+h.node, true)
+	h.cl.Properties.ProtocolVersion = 5
+	h.done = make(chan struct{})
+	go h.relay(h.sub.Channel())
+
+	h.Log.Info("connected to redis backplane")
+
+	return nil
+}
+
+// Stop unsubscribes from the relay channel and closes the redis connection.
+func (h *Hook) Stop() error {
+	h.Log.Info("disconnecting from redis backplane")
+	if h.cancel != nil {
+		h.cancel()
+	}
+
+	if h.sub != nil {
+		_ = h.sub.Close()
+		<-h.done
+	}
+
+	if h.db == nil {
+		return nil
+	}
+
+	return h.db.Close()
+}
+
+// OnPublished relays messages published to this instance to all other instances.
+func (h *Hook) OnPublished(cl *mqtt.Client, pk packets.Packet) {
+	if h.db == nil {
+		h.Log.Error("", "error", storage.ErrDBFileNotOpen)
+		return
+	}
+
+	if cl == h.cl || strings.HasPrefix(pk.TopicName, "$SYS") {
+		return // don't echo relayed messages, and each instance publishes its own $SYS topics.
+	}
+
+	props := pk.Properties.Copy(false)
+	b, err := json.Marshal(envelope{
+		Node: h.node,
+		Message: storage.Message{
+			FixedHeader: pk.FixedHeader,
+			TopicName:   pk.TopicName,
+			Payload:     pk.Payload,
+			Created:     pk.Created,
+			Origin:      pk.Origin,
+			Properties: storage.MessageProperties{
+				PayloadFormat:         props.PayloadFormat,
+				PayloadFormatFlag:     props.PayloadFormatFlag,
+				MessageExpiryInterval: props.MessageExpiryInterval,
+				ContentType:           props.ContentType,
+				ResponseTopic:         props.ResponseTopic,
+				CorrelationData:       props.CorrelationData,
+				User:                  props.User,
+			},
+		},
+	})
+	if err != nil {
+		h.Log.Error("failed to marshal relayed message", "error", err, "topic", pk.TopicName)
+		return
+	}
+
+	err = h.db.Publish(h.ctx, h.config.Channel, b).Err()
+	if err != nil {
+		h.Log.Error("failed to publish relayed message", "error", err, "topic", pk.TopicName)
+	}
+}
+
+// relay delivers messages received from other instances to local subscribers.
+func (h *Hook) relay(ch <-chan *redis.Message) {
+	defer close(h.done)
+	for msg := range ch {
+		var env envelope
+		if err := json.Unmarshal([]byte(msg.Payload), &env); err != nil {
+			h.Log.Error("failed to unmarshal relayed message", "error", err)
+			continue
+		}
+
+		if env.Node == h.node {
+			continue
+		}
+
+		pk := env.Message.ToPacket()
+		pk.FixedHeader.Type = packets.Publish
+		pk.FixedHeader.Dup = false
+		pk.PacketID = uint16(pk.FixedHeader.Qos) // the inbound qos flow is never processed for inline clients.
+		if err := h.config.Server.InjectPacket(h.cl, pk); err != nil {
+			h.Log.Error("failed to deliver relayed message", "error", err, "topic", pk.TopicName)
+		}
+	}
+}
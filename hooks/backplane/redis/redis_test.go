@@ -0,0 +1,169 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package redis
+
+import (
+	"log/slog"
+	"os"
+	"testing"
+	"time"
+
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/packets"
+
+	miniredis "github.com/alicebob/miniredis/v2"
+	redis "github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/require"
+)
+
+var logger = slog.New(slog.NewTextHandler(os.Stdout, nil))
+
+func newServer(t *testing.T) *mqtt.Server {
+	s := mqtt.New(&mqtt.Options{
+		Logger:       logger,
+		InlineClient: true,
+	})
+	require.NoError(t, s.AddHook(new(auth.AllowHook), nil))
+	return s
+}
+
+func newHook(t *testing.T, s *mqtt.Server, addr string) *Hook {
+	h := new(Hook)
+	h.SetOpts(logger, nil)
+
+	err := h.Init(&Options{
+		Server: s,
+		Options: &redis.Options{
+			Addr: addr,
+		},
+	})
+	require.NoError(t, err)
+
+	return h
+}
+
+func TestID(t *testing.T) {
+	h := new(Hook)
+	require.Equal(t, "redis-backplane", h.ID())
+}
+
+func TestProvides(t *testing.T) {
+	h := new(Hook)
+	require.True(t, h.Provides(mqtt.OnPublished))
+	require.False(t, h.Provides(mqtt.OnPublish))
+	require.False(t, h.Provides(mqtt.OnConnectAuthenticate))
+}
+
+func TestInitBadConfig(t *testing.T) {
+	h := new(Hook)
+	h.SetOpts(logger, nil)
+
+	err := h.Init(map[string]any{})
+	require.ErrorIs(t, err, mqtt.ErrInvalidConfigType)
+}
+
+func TestInitNoServer(t *testing.T) {
+	h := new(Hook)
+	h.SetOpts(logger, nil)
+
+	err := h.Init(nil)
+	require.ErrorIs(t, err, ErrServerRequired)
+}
+
+func TestInitBadAddr(t *testing.T) {
+	h := new(Hook)
+	h.SetOpts(logger, nil)
+
+	err := h.Init(&Options{
+		Server: newServer(t),
+		Options: &redis.Options{
+			Addr: "abc:123",
+		},
+	})
+	require.Error(t, err)
+}
+
+func TestInitUseDefaults(t *testing.T) {
+	s := miniredis.RunT(t)
+	s.StartAddr(defaultAddr)
+	defer s.Close()
+
+	h := new(Hook)
+	h.SetOpts(logger, nil)
+	err := h.Init(&Options{Server: newServer(t)})
+	require.NoError(t, err)
+	defer h.Stop()
+
+	require.Equal(t, defaultAddr, h.config.Options.Addr)
+	require.Equal(t, defaultChannel, h.config.Channel)
+	require.NotEmpty(t, h.node)
+}
+
+func TestOnPublishedNoDB(t *testing.T) {
+	h := new(Hook)
+	h.SetOpts(logger, nil)
+	h.OnPublished(new(mqtt.Client), packets.Packet{TopicName: "a/b/c"})
+}
+
+func TestRelayBetweenInstances(t *testing.T) {
+	mr := miniredis.RunT(t)
+	defer mr.Close()
+
+	s1 := newServer(t)
+	h1 := newHook(t, s1, mr.Addr())
+	defer h1.Stop()
+
+	s2 := newServer(t)
+	h2 := newHook(t, s2, mr.Addr())
+	defer h2.Stop()
+
+	recv := make(chan packets.Packet, 2)
+	err := s2.Subscribe("a/b/c", 1, func(cl *mqtt.Client, sub packets.Subscription, pk packets.Packet) {
+		recv <- pk
+	})
+	require.NoError(t, err)
+
+	h1.OnPublished(new(mqtt.Client), packets.Packet{
+		FixedHeader: packets.FixedHeader{Type: packets.Publish, Qos: 1},
+		TopicName:   "a/b/c",
+		Payload:     []byte("hello"),
+	})
+
+	select {
+	case pk := <-recv:
+		require.Equal(t, "a/b/c", pk.TopicName)
+		require.Equal(t, []byte("hello"), pk.Payload)
+	case <-time.After(time.Second):
+		require.Fail(t, "relayed message not received")
+	}
+}
+
+func TestOnPublishedSkipsRelayedAndSys(t *testing.T) {
+	mr := miniredis.RunT(t)
+	defer mr.Close()
+
+	h1 := newHook(t, newServer(t), mr.Addr())
+	defer h1.Stop()
+
+	s2 := newServer(t)
+	h2 := newHook(t, s2, mr.Addr())
+	defer h2.Stop()
+
+	recv := make(chan packets.Packet, 2)
+	err := s2.Subscribe("#", 1, func(cl *mqtt.Client, sub packets.Subscription, pk packets.Packet) {
+		recv <- pk
+	})
+	require.NoError(t, err)
+
+	h1.OnPublished(h1.cl, packets.Packet{TopicName: "a/b/c"})
+	h1.OnPublished(new(mqtt.Client), packets.Packet{TopicName: "$SYS/broker/uptime"})
+
+	select {
+	case pk := <-recv:
+		require.Fail(t, "unexpected relayed message", pk.TopicName)
+	case <-time.After(time.Millisecond * 100):
+	}
+}
@@ -0,0 +1,138 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+// Package clientid provides a hook which binds client ids to usernames, so that
+// clients cannot connect using the session of another user.
+package clientid
+
+import (
+	"bytes"
+	"strings"
+
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/packets"
+)
+
+const (
+	// UsernamePlaceholder is replaced by the username of the client in a pattern.
+	UsernamePlaceholder = "{username}"
+
+	defaultPattern = UsernamePlaceholder // the client id must equal the username
+)
+
+// Options contains configuration settings for the client id binding.
+type Options struct {
+	// Pattern is the pattern a client id must match, where {username} is replaced with
+	// the username of the client and * matches any characters, eg. "{username}-*".
+	// Defaults to {username}, requiring the client id to equal the username.
+	Pattern string `yaml:"pattern" json:"pattern"`
+
+	// AllowAnonymous allows clients which connect without a username to use any client id.
+	AllowAnonymous bool `yaml:"allow_anonymous" json:"allow_anonymous"`
+
+	// Exempt lists usernames which may connect with any client id, such as administrators.
+	Exempt []string `yaml:"exempt" json:"exempt"`
+}
+
+// Hook rejects connections from clients whose client id does not match the pattern for
+// their username, preventing devices from impersonating each other's sessions. Clients
+// are refused with a client identifier not valid CONNACK.
+type Hook struct {
+	mqtt.HookBase
+	config *Options
+	exempt map[string]struct{}
+}
+
+// ID returns the id of the hook.
+func (h *Hook) ID() string {
+	return "clientid"
+}
+
+// Provides indicates which hook methods this hook provides.
+func (h *Hook) Provides(b byte) bool {
+	return bytes.Contains([]byte{
+		mqtt.OnConnect,
+	}, []byte{b})
+}
+
+// Init configures the hook with the pattern and exempt usernames.
+func (h *Hook) Init(config any) error {
+	if _, ok := config.(*Options); !ok && config != nil {
+		return mqtt.ErrInvalidConfigType
+	}
+
+	if config == nil {
+		config = new(Options)
+	}
+
+	h.config = config.(*Options)
+	if h.config.Pattern == "" {
+		h.config.Pattern = defaultPattern
+	}
+
+	h.exempt = make(map[string]struct{}, len(h.config.Exempt))
+	for _, username := range h.config.Exempt {
+		h.exempt[username] = struct{}{}
+	}
+
+	return nil
+}
+
+// OnConnect rejects the client if its client id does not match the pattern for its username.
+func (h *Hook) OnConnect(cl *mqtt.Client, pk packets.Packet) error {
+	if h.Allowed(cl.ID, string(pk.Connect.Username)) {
+		return nil
+	}
+
+	h.Log.Warn("client id not bound to username",
+		"client", cl.ID,
+		"username", string(pk.Connect.Username),
+		"remote", cl.Net.Remote)
+
+	return packets.ErrClientIdentifierNotValid
+}
+
+// Allowed returns true if a client id may be used by a username.
+func (h *Hook) Allowed(id, username string) bool {
+	if username == "" {
+		return h.config.AllowAnonymous
+	}
+
+	if _, ok := h.exempt[username]; ok {
+		return true
+	}
+
+	// the pattern is split before the username is substituted, so that any * in the
+	// username is matched literally.
+	parts := strings.Split(h.config.Pattern, "*")
+	for i := range parts {
+		parts[i] = strings.ReplaceAll(parts[i], UsernamePlaceholder, username)
+	}
+
+	return matchParts(parts, id)
+}
+
+// matchParts returns true if s matches the literal parts of a pattern which were
+// separated by *, where * matches any characters, including none.
+func matchParts(parts []string, s string) bool {
+	if len(parts) == 1 {
+		return parts[0] == s
+	}
+
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+
+	last := parts[len(parts)-1]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(s, part)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(part):]
+	}
+
+	return len(s) >= len(last) && strings.HasSuffix(s, last)
+}
@@ -0,0 +1,95 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package clientid
+
+import (
+	"log/slog"
+	"os"
+	"testing"
+
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/packets"
+
+	"github.com/stretchr/testify/require"
+)
+
+var logger = slog.New(slog.NewTextHandler(os.Stdout, nil))
+
+func newHook(t *testing.T, opts *Options) *Hook {
+	h := new(Hook)
+	h.SetOpts(logger, nil)
+	err := h.Init(opts)
+	require.NoError(t, err)
+	return h
+}
+
+func TestID(t *testing.T) {
+	h := new(Hook)
+	require.Equal(t, "clientid", h.ID())
+}
+
+func TestProvides(t *testing.T) {
+	h := new(Hook)
+	require.True(t, h.Provides(mqtt.OnConnect))
+	require.False(t, h.Provides(mqtt.OnConnectAuthenticate))
+}
+
+func TestInitBadConfig(t *testing.T) {
+	h := new(Hook)
+	h.SetOpts(logger, nil)
+	err := h.Init(map[string]any{})
+	require.ErrorIs(t, err, mqtt.ErrInvalidConfigType)
+}
+
+func TestInitDefaults(t *testing.T) {
+	h := new(Hook)
+	h.SetOpts(logger, nil)
+	require.NoError(t, h.Init(nil))
+	require.Equal(t, defaultPattern, h.config.Pattern)
+	require.False(t, h.config.AllowAnonymous)
+}
+
+func TestAllowedEqual(t *testing.T) {
+	h := newHook(t, new(Options))
+	require.True(t, h.Allowed("mochi", "mochi"))
+	require.False(t, h.Allowed("mochi-2", "mochi"))
+	require.False(t, h.Allowed("other", "mochi"))
+	require.False(t, h.Allowed("mochi", ""))
+}
+
+func TestAllowedPattern(t *testing.T) {
+	h := newHook(t, &Options{Pattern: "{username}-*"})
+	require.True(t, h.Allowed("mochi-1", "mochi"))
+	require.True(t, h.Allowed("mochi-", "mochi"))
+	require.False(t, h.Allowed("mochi", "mochi"))
+	require.False(t, h.Allowed("other-1", "mochi"))
+
+	h = newHook(t, &Options{Pattern: "site/*/{username}/*"})
+	require.True(t, h.Allowed("site/north/mochi/sensor", "mochi"))
+	require.False(t, h.Allowed("site/north/other/sensor", "mochi"))
+	require.False(t, h.Allowed("site/mochi", "mochi"))
+
+	// a wildcard in the username is matched literally.
+	h = newHook(t, new(Options))
+	require.True(t, h.Allowed("*", "*"))
+	require.False(t, h.Allowed("mochi", "*"))
+}
+
+func TestAllowedAnonymousAndExempt(t *testing.T) {
+	h := newHook(t, &Options{AllowAnonymous: true, Exempt: []string{"admin"}})
+	require.True(t, h.Allowed("anything", ""))
+	require.True(t, h.Allowed("anything", "admin"))
+	require.False(t, h.Allowed("anything", "mochi"))
+}
+
+func TestOnConnect(t *testing.T) {
+	h := newHook(t, new(Options))
+	cl := &mqtt.Client{ID: "mochi"}
+	pk := packets.Packet{Connect: packets.ConnectParams{Username: []byte("mochi")}}
+	require.NoError(t, h.OnConnect(cl, pk))
+
+	cl.ID = "zen"
+	require.ErrorIs(t, h.OnConnect(cl, pk), packets.ErrClientIdentifierNotValid)
+}
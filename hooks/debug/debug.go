@@ -130,6 +130,16 @@ func (h *Hook) OnClientExpired(cl *mqtt.Client) {
 	h.Log.Debug("client session expired", "method", "OnClientExpired", "client", cl.ID)
 }
 
+// OnBanned is called when a ban is added to the server.
+func (h *Hook) OnBanned(ban mqtt.Ban) {
+	h.Log.Debug("ban added", "method", "OnBanned", "client", ban.ClientID, "ip", ban.IP, "expiry", ban.Expiry)
+}
+
+// OnUnbanned is called when a ban is removed from the server or has expired.
+func (h *Hook) OnUnbanned(ban mqtt.Ban) {
+	h.Log.Debug("ban removed", "method", "OnUnbanned", "client", ban.ClientID, "ip", ban.IP)
+}
+
 // StoredClients is called when the server restores clients from a store.
 func (h *Hook) StoredClients() (v []storage.Client, err error) {
 	h.Log.Debug("", "method", "StoredClients")
@@ -162,6 +172,13 @@ func (h *Hook) StoredSysInfo() (v storage.SystemInfo, err error) {
 	return v, nil
 }
 
+// StoredBans is called when the server restores bans from a store.
+func (h *Hook) StoredBans() (v []storage.Ban, err error) {
+	h.Log.Debug("", "method", "StoredBans")
+
+	return v, nil
+}
+
 // packetMeta adds additional type-specific metadata to the debug logs.
 func (h *Hook) packetMeta(pk packets.Packet) map[string]any {
 	m := map[string]any{}
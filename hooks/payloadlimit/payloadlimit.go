@@ -0,0 +1,116 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+// Package payloadlimit provides a hook which limits the payload size of messages
+// published to topics matching a filter.
+package payloadlimit
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/packets"
+)
+
+var (
+	// ErrInvalidRule indicates a rule was configured without a filter or a positive size.
+	ErrInvalidRule = errors.New("payload limit rule requires a filter and a positive size")
+)
+
+// Rule limits the payload size of messages published to topics matching a filter.
+type Rule struct {
+	Filter  string `yaml:"filter" json:"filter"`     // the topic filter the limit applies to
+	MaxSize int    `yaml:"max_size" json:"max_size"` // the maximum payload size in bytes
+}
+
+// Options contains configuration settings for the payload limiter.
+type Options struct {
+	Rules []Rule `yaml:"rules" json:"rules"` // the payload limits to enforce; the first matching rule applies
+}
+
+// Hook is a per-topic payload size limiting hook. Rules are checked in order and only
+// the first rule matching the topic is applied, so more specific filters should be
+// listed before general ones. Publishes from inline clients are not limited.
+type Hook struct {
+	mqtt.HookBase
+	rules []Rule // the payload limits to enforce
+}
+
+// ID returns the id of the hook.
+func (h *Hook) ID() string {
+	return "payloadlimit"
+}
+
+// Provides indicates which hook methods this hook provides.
+func (h *Hook) Provides(b byte) bool {
+	return bytes.Contains([]byte{
+		mqtt.OnPublish,
+	}, []byte{b})
+}
+
+// Init validates the rules and initializes the payload limiter.
+func (h *Hook) Init(config any) error {
+	if _, ok := config.(*Options); !ok && config != nil {
+		return mqtt.ErrInvalidConfigType
+	}
+
+	if config == nil {
+		config = new(Options)
+	}
+
+	rules := config.(*Options).Rules
+	for _, rule := range rules {
+		if rule.Filter == "" || rule.MaxSize <= 0 {
+			return fmt.Errorf("%w: %s", ErrInvalidRule, rule.Filter)
+		}
+	}
+
+	h.rules = rules
+	return nil
+}
+
+// OnPublish rejects publishes with a payload larger than the first rule matching the topic.
+// MQTT v5 clients publishing with QoS 1 or 2 receive a packet too large reason code, and
+// MQTT v3 clients publishing with QoS 1 or 2 are disconnected.
+func (h *Hook) OnPublish(cl *mqtt.Client, pk packets.Packet) (packets.Packet, error) {
+	if cl.Net.Inline {
+		return pk, nil
+	}
+
+	for _, rule := range h.rules {
+		if !mqtt.MatchTopic(rule.Filter, pk.TopicName) {
+			continue
+		}
+
+		if len(pk.Payload) <= rule.MaxSize {
+			return pk, nil
+		}
+
+		h.Log.Warn("publish payload too large",
+			"client", cl.ID,
+			"topic", pk.TopicName,
+			"filter", rule.Filter,
+			"size", len(pk.Payload),
+			"max_size", rule.MaxSize)
+
+		if pk.FixedHeader.Qos > 0 {
+			if cl.Properties.ProtocolVersion == 5 {
+				return pk, packets.Code{
+					Code:   packets.ErrPacketTooLarge.Code,
+					Reason: fmt.Sprintf("payload exceeds %d bytes for %s", rule.MaxSize, rule.Filter),
+				}
+			}
+
+			// MQTT v3 has no failing acks, and an unacknowledged publish would be left
+			// pending on the client, so the client is disconnected.
+			cl.Stop(packets.ErrPacketTooLarge)
+		}
+
+		return pk, packets.ErrRejectPacket
+	}
+
+	return pk, nil
+}
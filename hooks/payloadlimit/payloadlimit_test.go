@@ -0,0 +1,117 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package payloadlimit
+
+import (
+	"log/slog"
+	"os"
+	"testing"
+
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/packets"
+
+	"github.com/stretchr/testify/require"
+)
+
+var logger = slog.New(slog.NewTextHandler(os.Stdout, nil))
+
+func newHook(t *testing.T, opts *Options) *Hook {
+	h := new(Hook)
+	h.SetOpts(logger, nil)
+	err := h.Init(opts)
+	require.NoError(t, err)
+	return h
+}
+
+func TestID(t *testing.T) {
+	h := new(Hook)
+	require.Equal(t, "payloadlimit", h.ID())
+}
+
+func TestProvides(t *testing.T) {
+	h := new(Hook)
+	require.True(t, h.Provides(mqtt.OnPublish))
+	require.False(t, h.Provides(mqtt.OnPublished))
+}
+
+func TestInitBadConfig(t *testing.T) {
+	h := new(Hook)
+	h.SetOpts(logger, nil)
+	err := h.Init(map[string]any{})
+	require.ErrorIs(t, err, mqtt.ErrInvalidConfigType)
+}
+
+func TestInitInvalidRule(t *testing.T) {
+	h := new(Hook)
+	h.SetOpts(logger, nil)
+	err := h.Init(&Options{Rules: []Rule{{Filter: "a/#"}}})
+	require.ErrorIs(t, err, ErrInvalidRule)
+
+	err = h.Init(&Options{Rules: []Rule{{MaxSize: 10}}})
+	require.ErrorIs(t, err, ErrInvalidRule)
+}
+
+func TestOnPublish(t *testing.T) {
+	h := newHook(t, &Options{Rules: []Rule{
+		{Filter: "firmware/#", MaxSize: 1024 * 1024},
+		{Filter: "#", MaxSize: 4},
+	}})
+	cl := &mqtt.Client{ID: "mochi"}
+
+	_, err := h.OnPublish(cl, packets.Packet{TopicName: "telemetry/a", Payload: []byte("1234")})
+	require.NoError(t, err)
+
+	_, err = h.OnPublish(cl, packets.Packet{TopicName: "telemetry/a", Payload: []byte("12345")})
+	require.ErrorIs(t, err, packets.ErrRejectPacket)
+
+	_, err = h.OnPublish(cl, packets.Packet{TopicName: "firmware/a", Payload: make([]byte, 1024)})
+	require.NoError(t, err)
+
+	_, err = h.OnPublish(cl, packets.Packet{TopicName: "firmware/a", Payload: make([]byte, 1024*1024+1)})
+	require.ErrorIs(t, err, packets.ErrRejectPacket)
+}
+
+func TestOnPublishV3Qos(t *testing.T) {
+	h := newHook(t, &Options{Rules: []Rule{{Filter: "a/#", MaxSize: 1}}})
+	cl := &mqtt.Client{ID: "mochi"}
+	cl.Properties.ProtocolVersion = 4
+
+	_, err := h.OnPublish(cl, packets.Packet{TopicName: "a/b", Payload: []byte("12")})
+	require.ErrorIs(t, err, packets.ErrRejectPacket)
+	require.NoError(t, cl.StopCause())
+
+	_, err = h.OnPublish(cl, packets.Packet{TopicName: "a/b", Payload: []byte("12"), FixedHeader: packets.FixedHeader{Qos: 1}})
+	require.ErrorIs(t, err, packets.ErrRejectPacket)
+	require.ErrorIs(t, cl.StopCause(), packets.ErrPacketTooLarge)
+}
+
+func TestOnPublishV5Qos(t *testing.T) {
+	h := newHook(t, &Options{Rules: []Rule{{Filter: "a/#", MaxSize: 1}}})
+	cl := &mqtt.Client{ID: "mochi"}
+	cl.Properties.ProtocolVersion = 5
+
+	_, err := h.OnPublish(cl, packets.Packet{TopicName: "a/b", Payload: []byte("12")})
+	require.ErrorIs(t, err, packets.ErrRejectPacket)
+
+	_, err = h.OnPublish(cl, packets.Packet{TopicName: "a/b", Payload: []byte("12"), FixedHeader: packets.FixedHeader{Qos: 1}})
+	var code packets.Code
+	require.ErrorAs(t, err, &code)
+	require.Equal(t, packets.ErrPacketTooLarge.Code, code.Code)
+	require.Equal(t, "payload exceeds 1 bytes for a/#", code.Reason)
+}
+
+func TestOnPublishUnmatched(t *testing.T) {
+	h := newHook(t, &Options{Rules: []Rule{{Filter: "a/#", MaxSize: 1}}})
+	_, err := h.OnPublish(&mqtt.Client{ID: "mochi"}, packets.Packet{TopicName: "b", Payload: []byte("12")})
+	require.NoError(t, err)
+}
+
+func TestOnPublishInlineNotLimited(t *testing.T) {
+	h := newHook(t, &Options{Rules: []Rule{{Filter: "#", MaxSize: 1}}})
+	cl := &mqtt.Client{ID: "inline"}
+	cl.Net.Inline = true
+	_, err := h.OnPublish(cl, packets.Packet{TopicName: "a", Payload: []byte("12")})
+	require.NoError(t, err)
+}
@@ -0,0 +1,236 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+// Package ratelimit provides a hook which limits the rate of messages published to
+// topics matching a filter, regardless of which client publishes them.
+//
+// Publishes over the limit are either rejected or queued. A queued publish is held in the
+// read loop of the publishing client, so no further packets from that client, including
+// pings, are processed until it is released. The delay is bounded by the MaxDelay of the
+// rule, which may not exceed MaxQueueDelay, and should be kept well below the keepalive of
+// the clients it applies to.
+package ratelimit
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/packets"
+)
+
+const (
+	ActionReject = "reject" // publishes over the limit are rejected
+	ActionQueue  = "queue"  // publishes over the limit are delayed until they are within the limit
+
+	// MaxQueueDelay is the maximum milliseconds a rule may delay a queued publish.
+	MaxQueueDelay int64 = 5000
+)
+
+var (
+	// ErrInvalidRule indicates a rule was configured without a filter or a positive rate.
+	ErrInvalidRule = errors.New("rate limit rule requires a filter and a positive rate")
+
+	// ErrInvalidAction indicates a rule was configured with an unknown action.
+	ErrInvalidAction = errors.New("rate limit action must be reject or queue")
+)
+
+// Rule limits the rate of messages published to topics matching a filter.
+type Rule struct {
+	Filter   string  `yaml:"filter" json:"filter"`       // the topic filter the limit applies to
+	Rate     float64 `yaml:"rate" json:"rate"`           // the number of messages allowed per second
+	Burst    int     `yaml:"burst" json:"burst"`         // the number of messages which may be published at once; defaults to the rate
+	Action   string  `yaml:"action" json:"action"`       // either reject or queue; defaults to reject
+	MaxDelay int64   `yaml:"max_delay" json:"max_delay"` // the maximum milliseconds a queued publish is delayed before it is rejected, up to MaxQueueDelay
+}
+
+// Options contains configuration settings for the rate limiter.
+type Options struct {
+	Rules []Rule     `yaml:"rules" json:"rules"` // the rate limits to enforce
+	Clock mqtt.Clock `yaml:"-" json:"-"`         // the clock used to measure rates, typically the server clock; defaults to the system time
+}
+
+// Sleeper is implemented by clocks which can delay the caller, such as a manually advanced
+// test clock. Queued publishes are delayed with time.Sleep if the clock is not a Sleeper.
+type Sleeper interface {
+	Sleep(d time.Duration)
+}
+
+// bucket is a token bucket enforcing a single rule.
+type bucket struct {
+	rule   Rule      // the rule being enforced
+	tokens float64   // the number of publishes currently available
+	last   time.Time // the last time the tokens were replenished
+	sync.Mutex
+}
+
+// reserve takes a token from the bucket, returning how long the caller must wait before
+// publishing. If the wait would exceed max, no token is taken and false is returned.
+func (b *bucket) reserve(now time.Time, max time.Duration) (time.Duration, bool) {
+	b.Lock()
+	defer b.Unlock()
+
+	if !b.last.IsZero() {
+		b.tokens = math.Min(float64(b.rule.Burst), b.tokens+now.Sub(b.last).Seconds()*b.rule.Rate)
+	}
+	b.last = now
+
+	var wait time.Duration
+	if b.tokens < 1 {
+		wait = time.Duration((1 - b.tokens) / b.rule.Rate * float64(time.Second))
+	}
+
+	if wait > max {
+		return 0, false
+	}
+
+	b.tokens--
+	return wait, true
+}
+
+// cancel returns a reserved token to the bucket.
+func (b *bucket) cancel() {
+	b.Lock()
+	defer b.Unlock()
+	b.tokens = math.Min(float64(b.rule.Burst), b.tokens+1)
+}
+
+// Hook is a per-topic publish rate limiting hook. Limits are shared between all
+// clients publishing to matching topics. Publishes from inline clients are not limited.
+type Hook struct {
+	mqtt.HookBase
+	clock   mqtt.Clock // the clock used to measure rates
+	buckets []*bucket  // a token bucket for each rule
+}
+
+// ID returns the id of the hook.
+func (h *Hook) ID() string {
+	return "ratelimit"
+}
+
+// Provides indicates which hook methods this hook provides.
+func (h *Hook) Provides(b byte) bool {
+	return bytes.Contains([]byte{
+		mqtt.OnPublish,
+	}, []byte{b})
+}
+
+// Init validates the rules and initializes the rate limiter.
+func (h *Hook) Init(config any) error {
+	if _, ok := config.(*Options); !ok && config != nil {
+		return mqtt.ErrInvalidConfigType
+	}
+
+	if config == nil {
+		config = new(Options)
+	}
+
+	options := config.(*Options)
+	h.clock = options.Clock
+	h.buckets = nil
+	for _, rule := range options.Rules {
+		if rule.Filter == "" || rule.Rate <= 0 {
+			return fmt.Errorf("%w: %s", ErrInvalidRule, rule.Filter)
+		}
+
+		if rule.Action == "" {
+			rule.Action = ActionReject
+		}
+
+		if rule.Action != ActionReject && rule.Action != ActionQueue {
+			return fmt.Errorf("%w: %s", ErrInvalidAction, rule.Action)
+		}
+
+		if rule.MaxDelay > MaxQueueDelay {
+			h.Log.Warn("rate limit max delay exceeds the maximum", "filter", rule.Filter, "max_delay", rule.MaxDelay, "maximum", MaxQueueDelay)
+			rule.MaxDelay = MaxQueueDelay
+		}
+
+		if rule.Burst <= 0 {
+			rule.Burst = int(math.Max(1, math.Ceil(rule.Rate)))
+		}
+
+		h.buckets = append(h.buckets, &bucket{
+			rule:   rule,
+			tokens: float64(rule.Burst),
+		})
+	}
+
+	return nil
+}
+
+// OnPublish enforces the rate limits of any rules matching the topic of a publish.
+func (h *Hook) OnPublish(cl *mqtt.Client, pk packets.Packet) (packets.Packet, error) {
+	if cl.Net.Inline {
+		return pk, nil
+	}
+
+	now := h.now()
+	var wait time.Duration
+	var reserved []*bucket
+	for _, b := range h.buckets {
+		if !mqtt.MatchTopic(b.rule.Filter, pk.TopicName) {
+			continue
+		}
+
+		var max time.Duration
+		if b.rule.Action == ActionQueue {
+			max = time.Millisecond * time.Duration(b.rule.MaxDelay)
+		}
+
+		d, ok := b.reserve(now, max)
+		if !ok {
+			for _, r := range reserved {
+				r.cancel()
+			}
+
+			h.Log.Debug("publish rate limit exceeded", "client", cl.ID, "topic", pk.TopicName, "filter", b.rule.Filter)
+			if pk.FixedHeader.Qos > 0 {
+				if cl.Properties.ProtocolVersion == 5 {
+					return pk, packets.ErrQuotaExceeded // acknowledged with a failing puback or pubrec
+				}
+
+				// MQTT v3 has no failing acks, and an unacknowledged publish would be left
+				// pending on the client, so the client is disconnected to resend it later.
+				cl.Stop(packets.ErrQuotaExceeded)
+			}
+
+			return pk, packets.ErrRejectPacket
+		}
+
+		reserved = append(reserved, b)
+		if d > wait {
+			wait = d
+		}
+	}
+
+	if wait > 0 {
+		h.sleep(wait) // delays reading further packets from the client until the publish is within the limit
+	}
+
+	return pk, nil
+}
+
+// now returns the current time from the clock of the hook, or the system time.
+func (h *Hook) now() time.Time {
+	if h.clock == nil {
+		return time.Now()
+	}
+
+	return h.clock.Now()
+}
+
+// sleep delays the caller for d, using the clock of the hook if it is a Sleeper.
+func (h *Hook) sleep(d time.Duration) {
+	if s, ok := h.clock.(Sleeper); ok {
+		s.Sleep(d)
+		return
+	}
+
+	time.Sleep(d)
+}
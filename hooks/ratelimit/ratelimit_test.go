@@ -0,0 +1,224 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package ratelimit
+
+import (
+	"log/slog"
+	"os"
+	"testing"
+	"time"
+
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/mqtttest"
+	"github.com/mochi-mqtt/server/v2/packets"
+
+	"github.com/stretchr/testify/require"
+)
+
+var logger = slog.New(slog.NewTextHandler(os.Stdout, nil))
+
+func newHook(t *testing.T, opts *Options) *Hook {
+	h := new(Hook)
+	h.SetOpts(logger, nil)
+	err := h.Init(opts)
+	require.NoError(t, err)
+	return h
+}
+
+func TestID(t *testing.T) {
+	h := new(Hook)
+	require.Equal(t, "ratelimit", h.ID())
+}
+
+func TestProvides(t *testing.T) {
+	h := new(Hook)
+	require.True(t, h.Provides(mqtt.OnPublish))
+	require.False(t, h.Provides(mqtt.OnPublished))
+}
+
+func TestInitBadConfig(t *testing.T) {
+	h := new(Hook)
+	h.SetOpts(logger, nil)
+	err := h.Init(map[string]any{})
+	require.ErrorIs(t, err, mqtt.ErrInvalidConfigType)
+}
+
+func TestInitInvalidRule(t *testing.T) {
+	h := new(Hook)
+	h.SetOpts(logger, nil)
+	err := h.Init(&Options{Rules: []Rule{{Filter: "a/#"}}})
+	require.ErrorIs(t, err, ErrInvalidRule)
+
+	err = h.Init(&Options{Rules: []Rule{{Rate: 1}}})
+	require.ErrorIs(t, err, ErrInvalidRule)
+
+	err = h.Init(&Options{Rules: []Rule{{Filter: "a/#", Rate: 1, Action: "drop"}}})
+	require.ErrorIs(t, err, ErrInvalidAction)
+}
+
+func TestInitDefaults(t *testing.T) {
+	h := newHook(t, &Options{Rules: []Rule{{Filter: "a/#", Rate: 2.5}, {Filter: "b/#", Rate: 0.1}}})
+	require.Len(t, h.buckets, 2)
+	require.Equal(t, ActionReject, h.buckets[0].rule.Action)
+	require.Equal(t, 3, h.buckets[0].rule.Burst)
+	require.Equal(t, 1, h.buckets[1].rule.Burst)
+}
+
+func TestInitMaxDelayBounded(t *testing.T) {
+	h := newHook(t, &Options{Rules: []Rule{{Filter: "a/#", Rate: 1, Action: ActionQueue, MaxDelay: MaxQueueDelay * 2}}})
+	require.Equal(t, MaxQueueDelay, h.buckets[0].rule.MaxDelay)
+}
+
+func TestBucketReserve(t *testing.T) {
+	b := &bucket{rule: Rule{Rate: 2, Burst: 2}, tokens: 2}
+	now := time.Now()
+
+	d, ok := b.reserve(now, 0)
+	require.True(t, ok)
+	require.Equal(t, time.Duration(0), d)
+
+	_, ok = b.reserve(now, 0)
+	require.True(t, ok)
+
+	_, ok = b.reserve(now, 0)
+	require.False(t, ok)
+
+	d, ok = b.reserve(now, time.Second)
+	require.True(t, ok)
+	require.Equal(t, time.Millisecond*500, d)
+
+	// tokens are replenished at the rate, but never beyond the burst.
+	_, ok = b.reserve(now.Add(time.Second*10), 0)
+	require.True(t, ok)
+	require.Equal(t, float64(1), b.tokens)
+}
+
+func TestBucketCancelBounded(t *testing.T) {
+	b := &bucket{rule: Rule{Rate: 1, Burst: 2}, tokens: 2}
+	b.cancel()
+	require.Equal(t, float64(2), b.tokens)
+
+	_, ok := b.reserve(time.Now(), 0)
+	require.True(t, ok)
+	b.cancel()
+	b.cancel()
+	require.Equal(t, float64(2), b.tokens)
+}
+
+func TestOnPublishReject(t *testing.T) {
+	h := newHook(t, &Options{Rules: []Rule{{Filter: "commands/#", Rate: 1, Burst: 2}}})
+	cl := &mqtt.Client{ID: "mochi"}
+	pk := packets.Packet{TopicName: "commands/a"}
+
+	_, err := h.OnPublish(cl, pk)
+	require.NoError(t, err)
+	_, err = h.OnPublish(&mqtt.Client{ID: "zen"}, pk) // limits are shared between clients
+	require.NoError(t, err)
+	_, err = h.OnPublish(cl, pk)
+	require.ErrorIs(t, err, packets.ErrRejectPacket)
+
+	_, err = h.OnPublish(cl, packets.Packet{TopicName: "other/a"})
+	require.NoError(t, err)
+
+	require.NoError(t, cl.StopCause())
+
+	cl.Properties.ProtocolVersion = 5
+	_, err = h.OnPublish(cl, packets.Packet{TopicName: "commands/a", FixedHeader: packets.FixedHeader{Qos: 1}})
+	require.ErrorIs(t, err, packets.ErrQuotaExceeded)
+	_, err = h.OnPublish(cl, packets.Packet{TopicName: "commands/a", FixedHeader: packets.FixedHeader{Qos: 2}})
+	require.ErrorIs(t, err, packets.ErrQuotaExceeded)
+	require.NoError(t, cl.StopCause())
+}
+
+func TestOnPublishRejectQosV3Disconnects(t *testing.T) {
+	h := newHook(t, &Options{Rules: []Rule{{Filter: "a/#", Rate: 1}}})
+	cl := &mqtt.Client{ID: "mochi"}
+	pk := packets.Packet{TopicName: "a/b", FixedHeader: packets.FixedHeader{Qos: 1}}
+
+	_, err := h.OnPublish(cl, pk)
+	require.NoError(t, err)
+
+	_, err = h.OnPublish(cl, pk)
+	require.ErrorIs(t, err, packets.ErrRejectPacket)
+	require.ErrorIs(t, cl.StopCause(), packets.ErrQuotaExceeded)
+}
+
+func TestOnPublishInlineNotLimited(t *testing.T) {
+	h := newHook(t, &Options{Rules: []Rule{{Filter: "#", Rate: 1}}})
+	cl := &mqtt.Client{ID: "inline"}
+	cl.Net.Inline = true
+	for i := 0; i < 5; i++ {
+		_, err := h.OnPublish(cl, packets.Packet{TopicName: "a"})
+		require.NoError(t, err)
+	}
+}
+
+func TestOnPublishQueue(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	clock := mqtttest.NewClock(start)
+	h := newHook(t, &Options{
+		Clock: clock,
+		Rules: []Rule{{Filter: "a/#", Rate: 20, Burst: 1, Action: ActionQueue, MaxDelay: 100}},
+	})
+	cl := &mqtt.Client{ID: "mochi"}
+	pk := packets.Packet{TopicName: "a/b"}
+
+	_, err := h.OnPublish(cl, pk)
+	require.NoError(t, err)
+	require.Equal(t, start, clock.Now())
+
+	_, err = h.OnPublish(cl, pk)
+	require.NoError(t, err)
+	require.Equal(t, start.Add(time.Millisecond*50), clock.Now()) // waited on the clock
+
+	_, err = h.OnPublish(cl, pk)
+	require.NoError(t, err)
+	require.Equal(t, start.Add(time.Millisecond*100), clock.Now())
+}
+
+func TestOnPublishQueueSystemClock(t *testing.T) {
+	h := newHook(t, &Options{Rules: []Rule{{Filter: "a/#", Rate: 20, Burst: 1, Action: ActionQueue, MaxDelay: 100}}})
+	cl := &mqtt.Client{ID: "mochi"}
+	pk := packets.Packet{TopicName: "a/b"}
+
+	_, err := h.OnPublish(cl, pk)
+	require.NoError(t, err)
+
+	start := time.Now()
+	_, err = h.OnPublish(cl, pk)
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, time.Since(start), time.Millisecond*40)
+}
+
+func TestOnPublishQueueExceedsMaxDelay(t *testing.T) {
+	clock := mqtttest.NewClock(time.Unix(1700000000, 0))
+	h := newHook(t, &Options{
+		Clock: clock,
+		Rules: []Rule{{Filter: "a/#", Rate: 1, Burst: 1, Action: ActionQueue, MaxDelay: 100}},
+	})
+	cl := &mqtt.Client{ID: "mochi"}
+	pk := packets.Packet{TopicName: "a/b"}
+
+	_, err := h.OnPublish(cl, pk)
+	require.NoError(t, err)
+
+	_, err = h.OnPublish(cl, pk)
+	require.ErrorIs(t, err, packets.ErrRejectPacket)
+	require.Equal(t, time.Unix(1700000000, 0), clock.Now()) // not delayed
+}
+
+func TestOnPublishMultipleRulesCancel(t *testing.T) {
+	h := newHook(t, &Options{Rules: []Rule{
+		{Filter: "a/#", Rate: 1, Burst: 5},
+		{Filter: "a/b", Rate: 1, Burst: 1},
+	}})
+	cl := &mqtt.Client{ID: "mochi"}
+
+	_, err := h.OnPublish(cl, packets.Packet{TopicName: "a/b"})
+	require.NoError(t, err)
+	_, err = h.OnPublish(cl, packets.Packet{TopicName: "a/b"})
+	require.ErrorIs(t, err, packets.ErrRejectPacket)
+	require.InDelta(t, 4, h.buckets[0].tokens, 0.1) // the token reserved from the first rule was returned
+}
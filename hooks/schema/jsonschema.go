@@ -0,0 +1,269 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"slices"
+	"unicode/utf8"
+)
+
+var (
+	// ErrInvalidJSON indicates a payload was not a valid JSON document.
+	ErrInvalidJSON = errors.New("payload is not valid json")
+
+	// ErrSchemaMismatch indicates a JSON payload did not conform to its schema.
+	ErrSchemaMismatch = errors.New("payload does not match json schema")
+)
+
+// jsonSchema is a compiled JSON Schema. The validation keywords type, enum, const,
+// properties, required, additionalProperties, items, minItems, maxItems, minLength,
+// maxLength, pattern, minimum, maximum, exclusiveMinimum, and exclusiveMaximum are
+// supported. Other keywords, including $ref and the combining keywords, are ignored.
+type jsonSchema struct {
+	never                bool                   // the schema is false, and matches nothing
+	Types                []string               `json:"-"`
+	Type                 json.RawMessage        `json:"type"`
+	Enum                 []any                  `json:"enum"`
+	Const                json.RawMessage        `json:"const"`
+	Properties           map[string]*jsonSchema `json:"properties"`
+	Required             []string               `json:"required"`
+	AdditionalProperties *jsonSchema            `json:"additionalProperties"`
+	Items                *jsonSchema            `json:"items"`
+	MinItems             *int                   `json:"minItems"`
+	MaxItems             *int                   `json:"maxItems"`
+	MinLength            *int                   `json:"minLength"`
+	MaxLength            *int                   `json:"maxLength"`
+	Pattern              string                 `json:"pattern"`
+	Minimum              *float64               `json:"minimum"`
+	Maximum              *float64               `json:"maximum"`
+	ExclusiveMinimum     *float64               `json:"exclusiveMinimum"`
+	ExclusiveMaximum     *float64               `json:"exclusiveMaximum"`
+	pattern              *regexp.Regexp         // the compiled pattern
+	constant             any                    // the decoded const value
+}
+
+// UnmarshalJSON decodes a schema, which may be an object or the boolean schemas true and false.
+func (s *jsonSchema) UnmarshalJSON(b []byte) error {
+	switch string(bytes.TrimSpace(b)) {
+	case "true":
+		*s = jsonSchema{}
+		return nil
+	case "false":
+		*s = jsonSchema{never: true}
+		return nil
+	}
+
+	type plain jsonSchema // prevents recursion into this method
+	if err := json.Unmarshal(b, (*plain)(s)); err != nil {
+		return err
+	}
+
+	if len(s.Type) > 0 {
+		var t string
+		if err := json.Unmarshal(s.Type, &t); err == nil {
+			s.Types = []string{t}
+		} else if err := json.Unmarshal(s.Type, &s.Types); err != nil {
+			return fmt.Errorf("invalid type: %w", err)
+		}
+	}
+
+	if len(s.Const) > 0 {
+		if err := json.Unmarshal(s.Const, &s.constant); err != nil {
+			return err
+		}
+	}
+
+	if s.Pattern != "" {
+		p, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid pattern: %w", err)
+		}
+		s.pattern = p
+	}
+
+	return nil
+}
+
+// JSONValidator validates payloads against a JSON Schema.
+type JSONValidator struct {
+	schema *jsonSchema
+}
+
+// NewJSONValidator compiles a JSON Schema document into a validator.
+func NewJSONValidator(schema []byte) (*JSONValidator, error) {
+	s := new(jsonSchema)
+	if err := json.Unmarshal(schema, s); err != nil {
+		return nil, fmt.Errorf("invalid json schema: %w", err)
+	}
+
+	return &JSONValidator{schema: s}, nil
+}
+
+// Validate returns an error describing the first part of the payload which does not
+// conform to the schema, or nil if the payload is valid.
+func (v *JSONValidator) Validate(payload []byte) error {
+	var doc any
+	if err := json.Unmarshal(payload, &doc); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidJSON, err)
+	}
+
+	return v.schema.validate(doc, "$")
+}
+
+// validate checks a decoded JSON value against the schema.
+func (s *jsonSchema) validate(v any, path string) error {
+	if s.never {
+		return fmt.Errorf("%w: %s is not allowed", ErrSchemaMismatch, path)
+	}
+
+	if len(s.Types) > 0 && !slices.ContainsFunc(s.Types, func(t string) bool { return isType(v, t) }) {
+		return fmt.Errorf("%w: %s must be of type %v", ErrSchemaMismatch, path, s.Types)
+	}
+
+	if len(s.Enum) > 0 && !slices.ContainsFunc(s.Enum, func(e any) bool { return reflect.DeepEqual(e, v) }) {
+		return fmt.Errorf("%w: %s must be one of %v", ErrSchemaMismatch, path, s.Enum)
+	}
+
+	if len(s.Const) > 0 && !reflect.DeepEqual(s.constant, v) {
+		return fmt.Errorf("%w: %s must be %s", ErrSchemaMismatch, path, s.Const)
+	}
+
+	switch val := v.(type) {
+	case map[string]any:
+		return s.validateObject(val, path)
+	case []any:
+		return s.validateArray(val, path)
+	case string:
+		return s.validateString(val, path)
+	case float64:
+		return s.validateNumber(val, path)
+	}
+
+	return nil
+}
+
+// validateObject checks the properties of a JSON object against the schema.
+func (s *jsonSchema) validateObject(obj map[string]any, path string) error {
+	for _, k := range s.Required {
+		if _, ok := obj[k]; !ok {
+			return fmt.Errorf("%w: %s.%s is required", ErrSchemaMismatch, path, k)
+		}
+	}
+
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys) // report the same error for the same payload
+
+	for _, k := range keys {
+		sub, ok := s.Properties[k]
+		if !ok {
+			sub = s.AdditionalProperties
+		}
+
+		if sub == nil {
+			continue
+		}
+
+		if err := sub.validate(obj[k], path+"."+k); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validateArray checks the items of a JSON array against the schema.
+func (s *jsonSchema) validateArray(arr []any, path string) error {
+	if s.MinItems != nil && len(arr) < *s.MinItems {
+		return fmt.Errorf("%w: %s must have at least %d items", ErrSchemaMismatch, path, *s.MinItems)
+	}
+
+	if s.MaxItems != nil && len(arr) > *s.MaxItems {
+		return fmt.Errorf("%w: %s must have at most %d items", ErrSchemaMismatch, path, *s.MaxItems)
+	}
+
+	if s.Items == nil {
+		return nil
+	}
+
+	for i, item := range arr {
+		if err := s.Items.validate(item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validateString checks a JSON string against the schema.
+func (s *jsonSchema) validateString(str, path string) error {
+	n := utf8.RuneCountInString(str)
+	if s.MinLength != nil && n < *s.MinLength {
+		return fmt.Errorf("%w: %s must be at least %d characters", ErrSchemaMismatch, path, *s.MinLength)
+	}
+
+	if s.MaxLength != nil && n > *s.MaxLength {
+		return fmt.Errorf("%w: %s must be at most %d characters", ErrSchemaMismatch, path, *s.MaxLength)
+	}
+
+	if s.pattern != nil && !s.pattern.MatchString(str) {
+		return fmt.Errorf("%w: %s must match %s", ErrSchemaMismatch, path, s.Pattern)
+	}
+
+	return nil
+}
+
+// validateNumber checks a JSON number against the schema.
+func (s *jsonSchema) validateNumber(f float64, path string) error {
+	switch {
+	case s.Minimum != nil && f < *s.Minimum:
+		return fmt.Errorf("%w: %s must be at least %v", ErrSchemaMismatch, path, *s.Minimum)
+	case s.Maximum != nil && f > *s.Maximum:
+		return fmt.Errorf("%w: %s must be at most %v", ErrSchemaMismatch, path, *s.Maximum)
+	case s.ExclusiveMinimum != nil && f <= *s.ExclusiveMinimum:
+		return fmt.Errorf("%w: %s must be greater than %v", ErrSchemaMismatch, path, *s.ExclusiveMinimum)
+	case s.ExclusiveMaximum != nil && f >= *s.ExclusiveMaximum:
+		return fmt.Errorf("%w: %s must be less than %v", ErrSchemaMismatch, path, *s.ExclusiveMaximum)
+	}
+
+	return nil
+}
+
+// isType returns true if a decoded JSON value is of a JSON Schema type.
+func isType(v any, t string) bool {
+	switch t {
+	case "null":
+		return v == nil
+	case "boolean":
+		_, ok := v.(bool)
+		return ok
+	case "object":
+		_, ok := v.(map[string]any)
+		return ok
+	case "array":
+		_, ok := v.([]any)
+		return ok
+	case "string":
+		_, ok := v.(string)
+		return ok
+	case "number":
+		_, ok := v.(float64)
+		return ok
+	case "integer":
+		f, ok := v.(float64)
+		return ok && f == math.Trunc(f)
+	}
+
+	return false
+}
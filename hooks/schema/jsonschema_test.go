@@ -0,0 +1,96 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package schema
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewJSONValidatorInvalid(t *testing.T) {
+	_, err := NewJSONValidator([]byte("{"))
+	require.Error(t, err)
+
+	_, err = NewJSONValidator([]byte(`{"type": 1}`))
+	require.Error(t, err)
+
+	_, err = NewJSONValidator([]byte(`{"pattern": "("}`))
+	require.Error(t, err)
+}
+
+func TestJSONValidatorValidate(t *testing.T) {
+	v, err := NewJSONValidator([]byte(`{
+		"type": "object",
+		"required": ["id", "temp"],
+		"additionalProperties": false,
+		"properties": {
+			"id": {"type": "string", "minLength": 2, "maxLength": 8, "pattern": "^dev"},
+			"temp": {"type": "number", "minimum": -40, "maximum": 85},
+			"count": {"type": "integer", "exclusiveMinimum": 0, "exclusiveMaximum": 10},
+			"unit": {"enum": ["c", "f"]},
+			"version": {"const": 2},
+			"tags": {"type": "array", "minItems": 1, "maxItems": 2, "items": {"type": "string"}},
+			"note": {"type": ["string", "null"]}
+		}
+	}`))
+	require.NoError(t, err)
+
+	tt := []struct {
+		desc    string
+		payload string
+		err     error
+		message string
+	}{
+		{desc: "valid", payload: `{"id": "dev1", "temp": 21.5, "count": 3, "unit": "c", "version": 2, "tags": ["a"], "note": null}`},
+		{desc: "not json", payload: `{`, err: ErrInvalidJSON},
+		{desc: "wrong type", payload: `[]`, err: ErrSchemaMismatch, message: "$ must be of type [object]"},
+		{desc: "missing required", payload: `{"id": "dev1"}`, err: ErrSchemaMismatch, message: "$.temp is required"},
+		{desc: "additional property", payload: `{"id": "dev1", "temp": 1, "x": 1}`, err: ErrSchemaMismatch, message: "$.x is not allowed"},
+		{desc: "short string", payload: `{"id": "d", "temp": 1}`, err: ErrSchemaMismatch, message: "$.id must be at least 2 characters"},
+		{desc: "long string", payload: `{"id": "dev123456", "temp": 1}`, err: ErrSchemaMismatch, message: "$.id must be at most 8 characters"},
+		{desc: "pattern", payload: `{"id": "abc", "temp": 1}`, err: ErrSchemaMismatch, message: "$.id must match ^dev"},
+		{desc: "minimum", payload: `{"id": "dev1", "temp": -41}`, err: ErrSchemaMismatch, message: "$.temp must be at least -40"},
+		{desc: "maximum", payload: `{"id": "dev1", "temp": 86}`, err: ErrSchemaMismatch, message: "$.temp must be at most 85"},
+		{desc: "integer", payload: `{"id": "dev1", "temp": 1, "count": 1.5}`, err: ErrSchemaMismatch, message: "$.count must be of type [integer]"},
+		{desc: "exclusive minimum", payload: `{"id": "dev1", "temp": 1, "count": 0}`, err: ErrSchemaMismatch, message: "$.count must be greater than 0"},
+		{desc: "exclusive maximum", payload: `{"id": "dev1", "temp": 1, "count": 10}`, err: ErrSchemaMismatch, message: "$.count must be less than 10"},
+		{desc: "enum", payload: `{"id": "dev1", "temp": 1, "unit": "k"}`, err: ErrSchemaMismatch, message: "$.unit must be one of [c f]"},
+		{desc: "const", payload: `{"id": "dev1", "temp": 1, "version": 1}`, err: ErrSchemaMismatch, message: "$.version must be 2"},
+		{desc: "min items", payload: `{"id": "dev1", "temp": 1, "tags": []}`, err: ErrSchemaMismatch, message: "$.tags must have at least 1 items"},
+		{desc: "max items", payload: `{"id": "dev1", "temp": 1, "tags": ["a", "b", "c"]}`, err: ErrSchemaMismatch, message: "$.tags must have at most 2 items"},
+		{desc: "item type", payload: `{"id": "dev1", "temp": 1, "tags": ["a", 1]}`, err: ErrSchemaMismatch, message: "$.tags[1] must be of type [string]"},
+		{desc: "multiple types", payload: `{"id": "dev1", "temp": 1, "note": 1}`, err: ErrSchemaMismatch, message: "$.note must be of type [string null]"},
+	}
+
+	for _, tx := range tt {
+		t.Run(tx.desc, func(t *testing.T) {
+			err := v.Validate([]byte(tx.payload))
+			if tx.err == nil {
+				require.NoError(t, err)
+				return
+			}
+
+			require.ErrorIs(t, err, tx.err)
+			require.Contains(t, err.Error(), tx.message)
+		})
+	}
+}
+
+func TestJSONValidatorBooleanSchemas(t *testing.T) {
+	v, err := NewJSONValidator([]byte(`true`))
+	require.NoError(t, err)
+	require.NoError(t, v.Validate([]byte(`"anything"`)))
+
+	v, err = NewJSONValidator([]byte(`{"properties": {"a": true, "b": false}}`))
+	require.NoError(t, err)
+	require.NoError(t, v.Validate([]byte(`{"a": 1}`)))
+	require.ErrorIs(t, v.Validate([]byte(`{"b": 1}`)), ErrSchemaMismatch)
+
+	v, err = NewJSONValidator([]byte(`{"type": "boolean"}`))
+	require.NoError(t, err)
+	require.NoError(t, v.Validate([]byte(`true`)))
+	require.ErrorIs(t, v.Validate([]byte(`1`)), ErrSchemaMismatch)
+}
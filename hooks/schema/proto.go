@@ -0,0 +1,104 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package schema
+
+import (
+	"errors"
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+var (
+	// ErrMessageNotFound indicates the message type was not found in the descriptor set.
+	ErrMessageNotFound = errors.New("protobuf message type not found in descriptor set")
+
+	// ErrInvalidProto indicates a payload was not a valid encoding of its message type.
+	ErrInvalidProto = errors.New("payload is not a valid protobuf message")
+)
+
+// ProtoValidator validates payloads as binary encodings of a protobuf message type.
+type ProtoValidator struct {
+	desc protoreflect.MessageDescriptor
+}
+
+// NewProtoValidator returns a validator for the message type with the given full name, eg.
+// telemetry.v1.Reading, from a serialized FileDescriptorSet, such as one produced by
+// protoc --descriptor_set_out --include_imports.
+func NewProtoValidator(descriptorSet []byte, message string) (*ProtoValidator, error) {
+	var set descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(descriptorSet, &set); err != nil {
+		return nil, fmt.Errorf("invalid descriptor set: %w", err)
+	}
+
+	files, err := protodesc.NewFiles(&set)
+	if err != nil {
+		return nil, fmt.Errorf("invalid descriptor set: %w", err)
+	}
+
+	d, err := files.FindDescriptorByName(protoreflect.FullName(message))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrMessageNotFound, message)
+	}
+
+	md, ok := d.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrMessageNotFound, message)
+	}
+
+	return &ProtoValidator{desc: md}, nil
+}
+
+// Validate returns an error if the payload cannot be decoded as the message type, is missing
+// required fields, or contains fields which the message type does not define.
+func (v *ProtoValidator) Validate(payload []byte) error {
+	m := dynamicpb.NewMessage(v.desc)
+	if err := proto.Unmarshal(payload, m); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidProto, err)
+	}
+
+	if hasUnknownFields(m) {
+		return fmt.Errorf("%w: unknown fields for %s", ErrInvalidProto, v.desc.FullName())
+	}
+
+	return nil
+}
+
+// hasUnknownFields returns true if a message or any message nested within it contains
+// fields which are not defined by its type.
+func hasUnknownFields(m protoreflect.Message) bool {
+	if len(m.GetUnknown()) > 0 {
+		return true
+	}
+
+	found := false
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		switch {
+		case fd.IsMap():
+			if fd.MapValue().Message() != nil {
+				v.Map().Range(func(_ protoreflect.MapKey, mv protoreflect.Value) bool {
+					found = hasUnknownFields(mv.Message())
+					return !found
+				})
+			}
+		case fd.IsList():
+			if fd.Message() != nil {
+				for i := 0; i < v.List().Len() && !found; i++ {
+					found = hasUnknownFields(v.List().Get(i).Message())
+				}
+			}
+		case fd.Message() != nil:
+			found = hasUnknownFields(v.Message())
+		}
+
+		return !found
+	})
+
+	return found
+}
@@ -0,0 +1,106 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package schema
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// readingDescriptorSet returns a serialized descriptor set for the proto2 file:
+//
+//	package telemetry;
+//	message Reading {
+//	  required string id = 1;
+//	  optional double temp = 2;
+//	  optional Location location = 3;
+//	}
+//	message Location { optional double lat = 1; }
+func readingDescriptorSet(t *testing.T) []byte {
+	field := func(name string, number int32, label descriptorpb.FieldDescriptorProto_Label, typ descriptorpb.FieldDescriptorProto_Type, typeName string) *descriptorpb.FieldDescriptorProto {
+		f := &descriptorpb.FieldDescriptorProto{
+			Name:   proto.String(name),
+			Number: proto.Int32(number),
+			Label:  label.Enum(),
+			Type:   typ.Enum(),
+		}
+		if typeName != "" {
+			f.TypeName = proto.String(typeName)
+		}
+		return f
+	}
+
+	set := &descriptorpb.FileDescriptorSet{
+		File: []*descriptorpb.FileDescriptorProto{
+			{
+				Name:    proto.String("telemetry.proto"),
+				Package: proto.String("telemetry"),
+				Syntax:  proto.String("proto2"),
+				MessageType: []*descriptorpb.DescriptorProto{
+					{
+						Name: proto.String("Reading"),
+						Field: []*descriptorpb.FieldDescriptorProto{
+							field("id", 1, descriptorpb.FieldDescriptorProto_LABEL_REQUIRED, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+							field("temp", 2, descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL, descriptorpb.FieldDescriptorProto_TYPE_DOUBLE, ""),
+							field("location", 3, descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".telemetry.Location"),
+						},
+					},
+					{
+						Name: proto.String("Location"),
+						Field: []*descriptorpb.FieldDescriptorProto{
+							field("lat", 1, descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL, descriptorpb.FieldDescriptorProto_TYPE_DOUBLE, ""),
+						},
+					},
+				},
+			},
+		},
+	}
+
+	b, err := proto.Marshal(set)
+	require.NoError(t, err)
+	return b
+}
+
+func TestNewProtoValidatorInvalid(t *testing.T) {
+	_, err := NewProtoValidator([]byte{0xff}, "telemetry.Reading")
+	require.Error(t, err)
+
+	_, err = NewProtoValidator(readingDescriptorSet(t), "telemetry.Missing")
+	require.ErrorIs(t, err, ErrMessageNotFound)
+
+	_, err = NewProtoValidator(readingDescriptorSet(t), "telemetry.Reading.id")
+	require.ErrorIs(t, err, ErrMessageNotFound)
+}
+
+func TestProtoValidatorValidate(t *testing.T) {
+	v, err := NewProtoValidator(readingDescriptorSet(t), "telemetry.Reading")
+	require.NoError(t, err)
+
+	id := protowire.AppendString(protowire.AppendTag(nil, 1, protowire.BytesType), "dev1")
+	temp := protowire.AppendFixed64(protowire.AppendTag(nil, 2, protowire.Fixed64Type), 0)
+	unknown := protowire.AppendVarint(protowire.AppendTag(nil, 9, protowire.VarintType), 1)
+	location := func(b []byte) []byte {
+		return protowire.AppendBytes(protowire.AppendTag(nil, 3, protowire.BytesType), b)
+	}
+
+	require.NoError(t, v.Validate(append(id, temp...)))
+	require.NoError(t, v.Validate(append(id, location(nil)...)))
+
+	err = v.Validate(temp)
+	require.ErrorIs(t, err, ErrInvalidProto) // missing required id
+
+	err = v.Validate([]byte{0x0a, 0x05, 'a'})
+	require.ErrorIs(t, err, ErrInvalidProto) // truncated
+
+	err = v.Validate(append(append([]byte{}, id...), unknown...))
+	require.ErrorIs(t, err, ErrInvalidProto)
+
+	err = v.Validate(append(append([]byte{}, id...), location(unknown)...))
+	require.ErrorIs(t, err, ErrInvalidProto) // unknown field in a nested message
+}
@@ -0,0 +1,226 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+// Package schema provides a hook which validates the payloads of messages published to
+// topics matching a filter against a JSON Schema, a protobuf message type, or a custom
+// validator, rejecting or dead-lettering invalid messages before they reach subscribers.
+package schema
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/packets"
+)
+
+// DeadLetterInvalid is the dead-letter reason given to messages with invalid payloads.
+const DeadLetterInvalid = "invalid_payload"
+
+var (
+	// ErrInvalidRule indicates a rule was configured without a filter, or without exactly
+	// one of a json schema, descriptor set, or validator.
+	ErrInvalidRule = errors.New("schema rule requires a filter and one of a json schema, descriptor set, or validator")
+
+	// ErrInvalidAction indicates a rule was configured with an unknown action.
+	ErrInvalidAction = errors.New("schema rule action must be reject or dead_letter")
+
+	// ErrServerRequired indicates a dead_letter rule was configured without a server.
+	ErrServerRequired = errors.New("schema dead_letter rules require a server")
+)
+
+// Validator validates the payload of a message, returning an error describing why it is
+// invalid, or nil if it is valid.
+type Validator interface {
+	Validate(payload []byte) error
+}
+
+// ValidatorFunc is a function which implements Validator.
+type ValidatorFunc func(payload []byte) error
+
+// Validate calls the function to validate a payload.
+func (f ValidatorFunc) Validate(payload []byte) error {
+	return f(payload)
+}
+
+// Action determines how a message with an invalid payload is handled.
+type Action string
+
+const (
+	// ActionReject rejects the message. This is the default.
+	ActionReject Action = "reject"
+
+	// ActionDeadLetter rejects the message and republishes it to the dead-letter topic of
+	// the server, with a dead-letter-reason of invalid_payload.
+	ActionDeadLetter Action = "dead_letter"
+)
+
+// Rule validates the payloads of messages published to topics matching a filter. Exactly
+// one of JSONSchema, DescriptorSet, or Validator must be set.
+type Rule struct {
+	Filter        string    `yaml:"filter" json:"filter"`                 // the topic filter the rule applies to
+	JSONSchema    string    `yaml:"json_schema" json:"json_schema"`       // a JSON Schema document payloads must conform to
+	DescriptorSet string    `yaml:"descriptor_set" json:"descriptor_set"` // the path of a protobuf FileDescriptorSet file
+	Message       string    `yaml:"message" json:"message"`               // the full name of the protobuf message type in the descriptor set
+	Action        Action    `yaml:"action" json:"action"`                 // reject (default) or dead_letter
+	Validator     Validator `yaml:"-" json:"-"`                           // a custom validator
+}
+
+// Options contains configuration settings for the schema validator.
+type Options struct {
+	Rules  []Rule       `yaml:"rules" json:"rules"` // the schemas to enforce; the first matching rule applies
+	Server *mqtt.Server `yaml:"-" json:"-"`         // the server to dead-letter invalid messages to
+}
+
+// rule is a rule with its validator resolved.
+type rule struct {
+	filter    string
+	action    Action
+	validator Validator
+}
+
+// Hook is a per-topic payload schema validation hook. Rules are checked in order and only
+// the first rule matching the topic is applied, so more specific filters should be listed
+// before general ones. Publishes from inline clients are not validated.
+type Hook struct {
+	mqtt.HookBase
+	config *Options // configuration values for the hook
+	rules  []rule   // the schemas to enforce
+}
+
+// ID returns the id of the hook.
+func (h *Hook) ID() string {
+	return "schema"
+}
+
+// Provides indicates which hook methods this hook provides.
+func (h *Hook) Provides(b byte) bool {
+	return bytes.Contains([]byte{
+		mqtt.OnPublish,
+	}, []byte{b})
+}
+
+// Init compiles the schemas of the rules and initializes the schema validator.
+func (h *Hook) Init(config any) error {
+	if _, ok := config.(*Options); !ok && config != nil {
+		return mqtt.ErrInvalidConfigType
+	}
+
+	if config == nil {
+		config = new(Options)
+	}
+
+	h.config = config.(*Options)
+	h.rules = make([]rule, 0, len(h.config.Rules))
+	for _, r := range h.config.Rules {
+		compiled, err := h.compile(r)
+		if err != nil {
+			return err
+		}
+
+		h.rules = append(h.rules, compiled)
+	}
+
+	return nil
+}
+
+// compile resolves the validator and action of a rule.
+func (h *Hook) compile(r Rule) (rule, error) {
+	n := 0
+	for _, set := range []bool{r.JSONSchema != "", r.DescriptorSet != "", r.Validator != nil} {
+		if set {
+			n++
+		}
+	}
+
+	if r.Filter == "" || n != 1 {
+		return rule{}, fmt.Errorf("%w: %s", ErrInvalidRule, r.Filter)
+	}
+
+	compiled := rule{
+		filter:    r.Filter,
+		action:    r.Action,
+		validator: r.Validator,
+	}
+
+	switch compiled.action {
+	case "":
+		compiled.action = ActionReject
+	case ActionReject:
+	case ActionDeadLetter:
+		if h.config.Server == nil {
+			return rule{}, fmt.Errorf("%w: %s", ErrServerRequired, r.Filter)
+		}
+	default:
+		return rule{}, fmt.Errorf("%w: %s", ErrInvalidAction, r.Action)
+	}
+
+	var err error
+	switch {
+	case r.JSONSchema != "":
+		compiled.validator, err = NewJSONValidator([]byte(r.JSONSchema))
+	case r.DescriptorSet != "":
+		var b []byte
+		if b, err = os.ReadFile(r.DescriptorSet); err == nil {
+			compiled.validator, err = NewProtoValidator(b, r.Message)
+		}
+	}
+
+	if err != nil {
+		return rule{}, fmt.Errorf("schema rule %s: %w", r.Filter, err)
+	}
+
+	return compiled, nil
+}
+
+// OnPublish rejects publishes with a payload which is not valid for the first rule matching
+// the topic, dead-lettering them if the rule requires it. MQTT v5 clients publishing with
+// QoS 1 or 2 receive a payload format invalid reason code, and MQTT v3 clients publishing
+// with QoS 1 or 2 are disconnected.
+func (h *Hook) OnPublish(cl *mqtt.Client, pk packets.Packet) (packets.Packet, error) {
+	if cl.Net.Inline {
+		return pk, nil
+	}
+
+	for _, r := range h.rules {
+		if !mqtt.MatchTopic(r.filter, pk.TopicName) {
+			continue
+		}
+
+		err := r.validator.Validate(pk.Payload)
+		if err == nil {
+			return pk, nil
+		}
+
+		h.Log.Warn("publish payload invalid",
+			"error", err,
+			"client", cl.ID,
+			"topic", pk.TopicName,
+			"filter", r.filter,
+			"action", r.action)
+
+		if r.action == ActionDeadLetter {
+			h.config.Server.DeadLetter(cl, pk, DeadLetterInvalid)
+		}
+
+		if pk.FixedHeader.Qos > 0 {
+			if cl.Properties.ProtocolVersion == 5 {
+				return pk, packets.Code{
+					Code:   packets.ErrPayloadFormatInvalid.Code,
+					Reason: fmt.Sprintf("payload does not match schema for %s", r.filter),
+				}
+			}
+
+			// MQTT v3 has no failing acks, and an unacknowledged publish would be left
+			// pending on the client, so the client is disconnected.
+			cl.Stop(packets.ErrPayloadFormatInvalid)
+		}
+
+		return pk, packets.ErrRejectPacket
+	}
+
+	return pk, nil
+}
@@ -0,0 +1,197 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package schema
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/packets"
+
+	"github.com/stretchr/testify/require"
+)
+
+var logger = slog.New(slog.NewTextHandler(os.Stdout, nil))
+
+const readingSchema = `{
+	"type": "object",
+	"required": ["temp"],
+	"properties": {
+		"temp": {"type": "number"}
+	}
+}`
+
+func newHook(t *testing.T, opts *Options) *Hook {
+	h := new(Hook)
+	h.SetOpts(logger, nil)
+	err := h.Init(opts)
+	require.NoError(t, err)
+	return h
+}
+
+func TestID(t *testing.T) {
+	h := new(Hook)
+	require.Equal(t, "schema", h.ID())
+}
+
+func TestProvides(t *testing.T) {
+	h := new(Hook)
+	require.True(t, h.Provides(mqtt.OnPublish))
+	require.False(t, h.Provides(mqtt.OnPublished))
+}
+
+func TestInitBadConfig(t *testing.T) {
+	h := new(Hook)
+	h.SetOpts(logger, nil)
+	err := h.Init(map[string]any{})
+	require.ErrorIs(t, err, mqtt.ErrInvalidConfigType)
+}
+
+func TestInitNilConfig(t *testing.T) {
+	h := new(Hook)
+	h.SetOpts(logger, nil)
+	err := h.Init(nil)
+	require.NoError(t, err)
+	require.Empty(t, h.rules)
+}
+
+func TestInitInvalidRule(t *testing.T) {
+	h := new(Hook)
+	h.SetOpts(logger, nil)
+	err := h.Init(&Options{Rules: []Rule{{Filter: "a/#"}}})
+	require.ErrorIs(t, err, ErrInvalidRule)
+
+	err = h.Init(&Options{Rules: []Rule{{JSONSchema: readingSchema}}})
+	require.ErrorIs(t, err, ErrInvalidRule)
+
+	err = h.Init(&Options{Rules: []Rule{{Filter: "a/#", JSONSchema: readingSchema, DescriptorSet: "set.pb"}}})
+	require.ErrorIs(t, err, ErrInvalidRule)
+
+	err = h.Init(&Options{Rules: []Rule{{Filter: "a/#", JSONSchema: readingSchema, Action: "drop"}}})
+	require.ErrorIs(t, err, ErrInvalidAction)
+
+	err = h.Init(&Options{Rules: []Rule{{Filter: "a/#", JSONSchema: readingSchema, Action: ActionDeadLetter}}})
+	require.ErrorIs(t, err, ErrServerRequired)
+
+	err = h.Init(&Options{Rules: []Rule{{Filter: "a/#", JSONSchema: "{"}}})
+	require.Error(t, err)
+
+	err = h.Init(&Options{Rules: []Rule{{Filter: "a/#", DescriptorSet: filepath.Join(t.TempDir(), "missing.pb")}}})
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestInitDescriptorSet(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "reading.pb")
+	require.NoError(t, os.WriteFile(path, readingDescriptorSet(t), 0600))
+
+	h := newHook(t, &Options{Rules: []Rule{{Filter: "a/#", DescriptorSet: path, Message: "telemetry.Reading"}}})
+	require.Len(t, h.rules, 1)
+	require.IsType(t, new(ProtoValidator), h.rules[0].validator)
+	require.Equal(t, ActionReject, h.rules[0].action)
+}
+
+func TestOnPublish(t *testing.T) {
+	h := newHook(t, &Options{Rules: []Rule{
+		{Filter: "sensors/+/reading", JSONSchema: readingSchema},
+		{Filter: "sensors/#", Validator: ValidatorFunc(func(payload []byte) error {
+			if len(payload) == 0 {
+				return errors.New("empty")
+			}
+			return nil
+		})},
+	}})
+	cl := &mqtt.Client{ID: "mochi"}
+
+	_, err := h.OnPublish(cl, packets.Packet{TopicName: "sensors/a/reading", Payload: []byte(`{"temp": 21.5}`)})
+	require.NoError(t, err)
+
+	_, err = h.OnPublish(cl, packets.Packet{TopicName: "sensors/a/reading", Payload: []byte(`{"temp": "hot"}`)})
+	require.ErrorIs(t, err, packets.ErrRejectPacket)
+
+	_, err = h.OnPublish(cl, packets.Packet{TopicName: "sensors/a/status", Payload: []byte("ok")})
+	require.NoError(t, err)
+
+	_, err = h.OnPublish(cl, packets.Packet{TopicName: "sensors/a/status"})
+	require.ErrorIs(t, err, packets.ErrRejectPacket)
+}
+
+func TestOnPublishV3Qos(t *testing.T) {
+	h := newHook(t, &Options{Rules: []Rule{{Filter: "a/#", JSONSchema: readingSchema}}})
+	cl := &mqtt.Client{ID: "mochi"}
+	cl.Properties.ProtocolVersion = 4
+
+	_, err := h.OnPublish(cl, packets.Packet{TopicName: "a/b", Payload: []byte("{}")})
+	require.ErrorIs(t, err, packets.ErrRejectPacket)
+	require.NoError(t, cl.StopCause())
+
+	_, err = h.OnPublish(cl, packets.Packet{TopicName: "a/b", Payload: []byte("{}"), FixedHeader: packets.FixedHeader{Qos: 1}})
+	require.ErrorIs(t, err, packets.ErrRejectPacket)
+	require.ErrorIs(t, cl.StopCause(), packets.ErrPayloadFormatInvalid)
+}
+
+func TestOnPublishV5Qos(t *testing.T) {
+	h := newHook(t, &Options{Rules: []Rule{{Filter: "a/#", JSONSchema: readingSchema}}})
+	cl := &mqtt.Client{ID: "mochi"}
+	cl.Properties.ProtocolVersion = 5
+
+	_, err := h.OnPublish(cl, packets.Packet{TopicName: "a/b", Payload: []byte("{}")})
+	require.ErrorIs(t, err, packets.ErrRejectPacket)
+
+	_, err = h.OnPublish(cl, packets.Packet{TopicName: "a/b", Payload: []byte("{}"), FixedHeader: packets.FixedHeader{Qos: 1}})
+	var code packets.Code
+	require.ErrorAs(t, err, &code)
+	require.Equal(t, packets.ErrPayloadFormatInvalid.Code, code.Code)
+	require.Equal(t, "payload does not match schema for a/#", code.Reason)
+}
+
+func TestOnPublishUnmatched(t *testing.T) {
+	h := newHook(t, &Options{Rules: []Rule{{Filter: "a/#", JSONSchema: readingSchema}}})
+	_, err := h.OnPublish(&mqtt.Client{ID: "mochi"}, packets.Packet{TopicName: "b", Payload: []byte("not json")})
+	require.NoError(t, err)
+}
+
+func TestOnPublishInlineNotValidated(t *testing.T) {
+	h := newHook(t, &Options{Rules: []Rule{{Filter: "#", JSONSchema: readingSchema}}})
+	cl := &mqtt.Client{ID: "inline"}
+	cl.Net.Inline = true
+	_, err := h.OnPublish(cl, packets.Packet{TopicName: "a", Payload: []byte("not json")})
+	require.NoError(t, err)
+}
+
+func TestOnPublishDeadLetter(t *testing.T) {
+	s := mqtt.New(&mqtt.Options{
+		Logger:          logger,
+		InlineClient:    true,
+		DeadLetterTopic: "dlq",
+	})
+
+	var received []packets.Packet
+	err := s.Subscribe("dlq", 1, func(cl *mqtt.Client, sub packets.Subscription, pk packets.Packet) {
+		received = append(received, pk)
+	})
+	require.NoError(t, err)
+
+	h := newHook(t, &Options{
+		Server: s,
+		Rules:  []Rule{{Filter: "a/#", JSONSchema: readingSchema, Action: ActionDeadLetter}},
+	})
+
+	cl := &mqtt.Client{ID: "mochi"}
+	_, err = h.OnPublish(cl, packets.Packet{FixedHeader: packets.FixedHeader{Type: packets.Publish}, TopicName: "a/b", Payload: []byte(`{"temp": 21.5}`)})
+	require.NoError(t, err)
+	require.Empty(t, received)
+
+	_, err = h.OnPublish(cl, packets.Packet{FixedHeader: packets.FixedHeader{Type: packets.Publish}, TopicName: "a/b", Payload: []byte("{}")})
+	require.ErrorIs(t, err, packets.ErrRejectPacket)
+	require.Len(t, received, 1)
+	require.Equal(t, "dlq", received[0].TopicName)
+	require.Equal(t, []byte("{}"), received[0].Payload)
+	require.Contains(t, received[0].Properties.User, packets.UserProperty{Key: mqtt.DeadLetterReasonProperty, Val: DeadLetterInvalid})
+	require.Contains(t, received[0].Properties.User, packets.UserProperty{Key: mqtt.DeadLetterTopicProperty, Val: "a/b"})
+}
@@ -0,0 +1,723 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+// Package standby provides a hot-standby replication hook. A primary broker streams
+// its client sessions, subscriptions, and retained messages to a standby broker,
+// which keeps an up-to-date copy of the state and can be promoted if the primary fails.
+package standby
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/storage"
+	"github.com/mochi-mqtt/server/v2/packets"
+)
+
+const (
+	ModePrimary = "primary" // the broker streams its state to standbys
+	ModeStandby = "standby" // the broker follows the state of a primary
+
+	defaultReconnectInterval = 5    // the default number of seconds between attempts to reach the primary
+	heartbeatInterval        = 5    // the number of seconds between heartbeats sent to standbys
+	followerBuffer           = 4096 // the number of updates which may be queued for a standby before it is dropped
+	nonceSize                = 32   // the number of random bytes in an authentication challenge
+)
+
+var (
+	// ErrServerRequired indicates the hook was initialised without a server to replicate.
+	ErrServerRequired = errors.New("standby requires a server")
+
+	// ErrInvalidMode indicates the hook was initialised with an unknown mode.
+	ErrInvalidMode = errors.New("standby mode must be primary or standby")
+
+	// ErrAddressRequired indicates the hook was initialised without an address.
+	ErrAddressRequired = errors.New("standby requires an address")
+
+	// ErrAuthRequired indicates a primary was initialised without a way to authenticate standbys.
+	ErrAuthRequired = errors.New("standby primary requires a secret or tls client certificate verification")
+
+	// ErrUnauthorized indicates a standby could not be authenticated by the primary.
+	ErrUnauthorized = errors.New("standby authentication failed")
+)
+
+// Options contains configuration settings for the replication hook.
+type Options struct {
+	Server            *mqtt.Server `yaml:"-" json:"-"`                                   // the server to replicate
+	Mode              string       `yaml:"mode" json:"mode"`                             // either primary or standby
+	Address           string       `yaml:"address" json:"address"`                       // the address the primary listens on, or the standby dials
+	ReconnectInterval int64        `yaml:"reconnect_interval" json:"reconnect_interval"` // seconds between attempts to reach the primary
+	PromoteAfter      int64        `yaml:"promote_after" json:"promote_after"`           // promote the standby if the primary is unreachable for this many seconds; never if 0
+
+	// Secret is a shared secret which standbys must prove they hold before the primary
+	// streams any state. The secret itself is never sent.
+	Secret string `yaml:"secret" json:"secret"`
+
+	// TLSConfig encrypts replication connections. On a primary it is the server config,
+	// which verifies standby certificates if ClientAuth is tls.RequireAndVerifyClientCert,
+	// and on a standby it is the client config used to dial the primary.
+	TLSConfig *tls.Config `yaml:"-" json:"-"`
+}
+
+// challenge is sent by the primary to a standby which must authenticate with a secret.
+type challenge struct {
+	Nonce []byte `json:"nonce"` // random bytes to be signed with the secret
+}
+
+// response is the answer of a standby to an authentication challenge.
+type response struct {
+	MAC []byte `json:"mac"` // the HMAC-SHA256 of the nonce keyed with the secret
+}
+
+// update is a single change to the replicated state. An empty update is a heartbeat.
+type update struct {
+	State       *mqtt.Snapshot         `json:"state,omitempty"`       // sessions, subscriptions, and retained messages to add or replace
+	Expire      string                 `json:"expire,omitempty"`      // the id of a client session which has ended
+	Unsubscribe []storage.Subscription `json:"unsubscribe,omitempty"` // subscriptions which have been removed
+	Clear       string                 `json:"clear,omitempty"`       // the topic of a retained message which has been removed
+}
+
+// Hook is a hot-standby replication hook. On the primary it accepts connections from
+// standbys and streams a snapshot of the server state followed by every change. On the
+// standby it applies those changes to its own server. Clients which connect directly to
+// a standby are not replicated back to the primary; traffic should only be directed to
+// the standby once it has been promoted. A primary only streams state to standbys which
+// authenticate with the shared secret, or present a client certificate verified by its
+// TLSConfig.
+type Hook struct {
+	mqtt.HookBase
+	config    *Options                 // hook configuration
+	listener  net.Listener             // the listener accepting standbys, on a primary
+	followers map[net.Conn]chan update // update queues of the connected standbys, on a primary
+	conn      net.Conn                 // the connection to the primary, on a standby
+	lastSeen  int64                    // the last time the primary was heard from, in unix seconds
+	promoted  bool                     // true if the standby has been promoted
+	ctx       context.Context          // a context for the replication connections
+	cancel    context.CancelFunc       // cancels the replication context
+	done      chan struct{}            // closed when the standby has stopped following the primary
+	sync.Mutex
+}
+
+// ID returns the id of the hook.
+func (h *Hook) ID() string {
+	return "standby"
+}
+
+// Provides indicates which hook methods this hook provides.
+func (h *Hook) Provides(b byte) bool {
+	return bytes.Contains([]byte{
+		mqtt.OnStarted,
+		mqtt.OnSessionEstablished,
+		mqtt.OnDisconnect,
+		mqtt.OnClientExpired,
+		mqtt.OnSubscribed,
+		mqtt.OnUnsubscribed,
+		mqtt.OnRetainMessage,
+		mqtt.OnRetainedExpired,
+	}, []byte{b})
+}
+
+// Init initializes the hook, and begins accepting standby connections if the hook
+// is running on a primary.
+func (h *Hook) Init(config any) error {
+	if _, ok := config.(*Options); !ok && config != nil {
+		return mqtt.ErrInvalidConfigType
+	}
+
+	if config == nil {
+		config = new(Options)
+	}
+
+	h.config = config.(*Options)
+	if h.config.Server == nil {
+		return ErrServerRequired
+	}
+
+	if h.config.Mode != ModePrimary && h.config.Mode != ModeStandby {
+		return ErrInvalidMode
+	}
+
+	if h.config.Address == "" {
+		return ErrAddressRequired
+	}
+
+	if h.config.ReconnectInterval <= 0 {
+		h.config.ReconnectInterval = defaultReconnectInterval
+	}
+
+	h.followers = map[net.Conn]chan update{}
+	h.ctx, h.cancel = context.WithCancel(context.Background())
+
+	if h.config.Mode == ModeStandby {
+		return nil
+	}
+
+	if h.config.Secret == "" && (h.config.TLSConfig == nil || h.config.TLSConfig.ClientAuth != tls.RequireAndVerifyClientCert) {
+		return ErrAuthRequired
+	}
+
+	var err error
+	h.listener, err = net.Listen("tcp", h.config.Address)
+	if err != nil {
+		return err
+	}
+
+	if h.config.TLSConfig != nil {
+		h.listener = tls.NewListener(h.listener, h.config.TLSConfig)
+	}
+
+	h.Log.Info("accepting standby connections", "address", h.listener.Addr().String())
+	go h.accept()
+
+	return nil
+}
+
+// Stop closes all replication connections.
+func (h *Hook) Stop() error {
+	if h.cancel != nil {
+		h.cancel()
+	}
+
+	h.Lock()
+	if h.listener != nil {
+		_ = h.listener.Close()
+	}
+
+	for conn := range h.followers {
+		_ = conn.Close()
+	}
+
+	if h.conn != nil {
+		_ = h.conn.Close()
+	}
+	done := h.done
+	h.Unlock()
+
+	if done != nil {
+		<-done
+	}
+
+	return nil
+}
+
+// Promote stops a standby from following the primary, so that it may take over serving
+// clients. The replicated sessions are taken over as clients reconnect to the standby.
+func (h *Hook) Promote() {
+	h.Lock()
+	defer h.Unlock()
+	if h.config.Mode != ModeStandby || h.promoted {
+		return
+	}
+
+	h.promoted = true
+	h.cancel()
+	if h.conn != nil {
+		_ = h.conn.Close()
+	}
+
+	h.Log.Warn("standby promoted; no longer following primary", "primary", h.config.Address)
+}
+
+// Promoted returns true if the standby has been promoted.
+func (h *Hook) Promoted() bool {
+	h.Lock()
+	defer h.Unlock()
+	return h.promoted
+}
+
+// OnStarted begins following the primary, if the hook is running on a standby.
+func (h *Hook) OnStarted() {
+	if h.config.Mode != ModeStandby {
+		return
+	}
+
+	h.Lock()
+	if h.done != nil || h.promoted {
+		h.Unlock()
+		return
+	}
+	h.done = make(chan struct{})
+	h.Unlock()
+
+	atomic.StoreInt64(&h.lastSeen, time.Now().Unix())
+	go h.follow()
+}
+
+// OnSessionEstablished replicates a client session and its subscriptions.
+func (h *Hook) OnSessionEstablished(cl *mqtt.Client, pk packets.Packet) {
+	if h.config.Mode != ModePrimary || cl.Net.Inline {
+		return
+	}
+
+	h.broadcast(update{State: clientState(cl)})
+}
+
+// OnDisconnect replicates the end of a client session which has expired on disconnect.
+func (h *Hook) OnDisconnect(cl *mqtt.Client, err error, expire bool) {
+	if h.config.Mode != ModePrimary || !expire || cl.Net.Inline {
+		return
+	}
+
+	if cur, ok := h.config.Server.Clients.Get(cl.ID); ok && cur != cl {
+		return // the session was taken over by a new connection
+	}
+
+	h.broadcast(update{Expire: cl.ID})
+}
+
+// OnClientExpired replicates the end of a client session which has expired.
+func (h *Hook) OnClientExpired(cl *mqtt.Client) {
+	if h.config.Mode != ModePrimary {
+		return
+	}
+
+	h.broadcast(update{Expire: cl.ID})
+}
+
+// OnSubscribed replicates new client subscriptions.
+func (h *Hook) OnSubscribed(cl *mqtt.Client, pk packets.Packet, reasonCodes []byte) {
+	if h.config.Mode != ModePrimary || cl.Net.Inline {
+		return
+	}
+
+	state := newState()
+	for i, sub := range pk.Filters {
+		if i >= len(reasonCodes) || reasonCodes[i] >= packets.ErrUnspecifiedError.Code {
+			continue
+		}
+
+		state.Subscriptions = append(state.Subscriptions, subscription(cl.ID, sub, reasonCodes[i]))
+	}
+
+	h.broadcast(update{State: state})
+}
+
+// OnUnsubscribed replicates removed client subscriptions.
+func (h *Hook) OnUnsubscribed(cl *mqtt.Client, pk packets.Packet) {
+	if h.config.Mode != ModePrimary || cl.Net.Inline {
+		return
+	}
+
+	u := update{}
+	for _, sub := range pk.Filters {
+		u.Unsubscribe = append(u.Unsubscribe, storage.Subscription{Client: cl.ID, Filter: sub.Filter})
+	}
+
+	h.broadcast(u)
+}
+
+// OnRetainMessage replicates retained messages as they are set or cleared.
+func (h *Hook) OnRetainMessage(cl *mqtt.Client, pk packets.Packet, r int64) {
+	if h.config.Mode != ModePrimary {
+		return
+	}
+
+	if r == -1 {
+		h.broadcast(update{Clear: pk.TopicName})
+		return
+	}
+
+	state := newState()
+	state.Retained = append(state.Retained, message(pk))
+	h.broadcast(update{State: state})
+}
+
+// OnRetainedExpired replicates the removal of an expired retained message.
+func (h *Hook) OnRetainedExpired(topic string) {
+	if h.config.Mode != ModePrimary {
+		return
+	}
+
+	h.broadcast(update{Clear: topic})
+}
+
+// broadcast queues an update for every connected standby. A standby which is too far
+// behind to accept the update is disconnected, and will resynchronise when it reconnects.
+func (h *Hook) broadcast(u update) {
+	h.Lock()
+	defer h.Unlock()
+	for conn, ch := range h.followers {
+		select {
+		case ch <- u:
+		default:
+			h.Log.Warn("standby too far behind; disconnecting", "remote", conn.RemoteAddr().String())
+			close(ch)
+			delete(h.followers, conn)
+		}
+	}
+}
+
+// accept accepts standby connections until the listener is closed.
+func (h *Hook) accept() {
+	for {
+		conn, err := h.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		go h.serve(conn)
+	}
+}
+
+// serve authenticates a standby, and sends it a snapshot of the server state followed
+// by each update.
+func (h *Hook) serve(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+
+	deadline := time.Second * heartbeatInterval * 3
+	_ = conn.SetDeadline(time.Now().Add(deadline))
+	if err := h.authenticate(conn); err != nil {
+		h.Log.Warn("rejected standby connection", "error", err, "remote", conn.RemoteAddr().String())
+		return
+	}
+
+	ch := make(chan update, followerBuffer)
+	h.Lock()
+	h.followers[conn] = ch
+	h.Unlock()
+
+	defer func() {
+		h.Lock()
+		delete(h.followers, conn)
+		h.Unlock()
+	}()
+
+	h.Log.Info("standby connected", "remote", conn.RemoteAddr().String())
+	_ = conn.SetWriteDeadline(time.Now().Add(deadline))
+	if err := h.config.Server.Snapshot(conn); err != nil {
+		h.Log.Error("failed to send snapshot to standby", "error", err, "remote", conn.RemoteAddr().String())
+		return
+	}
+
+	heartbeat := time.NewTicker(time.Second * heartbeatInterval)
+	defer heartbeat.Stop()
+
+	enc := json.NewEncoder(conn)
+	for {
+		var u update
+		select {
+		case <-h.ctx.Done():
+			return
+		case <-heartbeat.C:
+		case v, ok := <-ch:
+			if !ok {
+				return
+			}
+			u = v
+		}
+
+		_ = conn.SetWriteDeadline(time.Now().Add(deadline))
+		if err := enc.Encode(u); err != nil {
+			h.Log.Warn("standby disconnected", "error", err, "remote", conn.RemoteAddr().String())
+			return
+		}
+	}
+}
+
+// authenticate completes the TLS handshake of a standby connection, and challenges the
+// standby to prove it holds the secret if one is set.
+func (h *Hook) authenticate(conn net.Conn) error {
+	if tc, ok := conn.(*tls.Conn); ok {
+		if err := tc.HandshakeContext(h.ctx); err != nil {
+			return err
+		}
+	}
+
+	if h.config.Secret == "" {
+		return nil
+	}
+
+	c := challenge{Nonce: make([]byte, nonceSize)}
+	if _, err := rand.Read(c.Nonce); err != nil {
+		return err
+	}
+
+	if err := json.NewEncoder(conn).Encode(c); err != nil {
+		return err
+	}
+
+	var r response
+	if err := json.NewDecoder(conn).Decode(&r); err != nil {
+		return err
+	}
+
+	if !hmac.Equal(r.MAC, sign(h.config.Secret, c.Nonce)) {
+		return ErrUnauthorized
+	}
+
+	return nil
+}
+
+// sign returns the HMAC-SHA256 of a nonce keyed with the secret.
+func sign(secret string, nonce []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write(nonce)
+	return mac.Sum(nil)
+}
+
+// follow maintains a connection to the primary until the hook is stopped or promoted.
+func (h *Hook) follow() {
+	defer close(h.done)
+	for {
+		err := h.sync()
+		if h.ctx.Err() != nil {
+			return
+		}
+
+		h.Log.Warn("lost connection to primary", "error", err, "primary", h.config.Address)
+		if h.config.PromoteAfter > 0 && time.Now().Unix()-atomic.LoadInt64(&h.lastSeen) >= h.config.PromoteAfter {
+			h.Promote()
+			return
+		}
+
+		select {
+		case <-h.ctx.Done():
+			return
+		case <-time.After(time.Second * time.Duration(h.config.ReconnectInterval)):
+		}
+	}
+}
+
+// sync connects to the primary, restores its snapshot, and applies updates until the
+// connection is lost.
+func (h *Hook) sync() error {
+	d := &net.Dialer{Timeout: time.Second * time.Duration(h.config.ReconnectInterval)}
+	var conn net.Conn
+	var err error
+	if h.config.TLSConfig != nil {
+		conn, err = (&tls.Dialer{NetDialer: d, Config: h.config.TLSConfig}).DialContext(h.ctx, "tcp", h.config.Address)
+	} else {
+		conn, err = d.DialContext(h.ctx, "tcp", h.config.Address)
+	}
+	if err != nil {
+		return err
+	}
+
+	h.Lock()
+	if h.promoted {
+		h.Unlock()
+		_ = conn.Close()
+		return nil
+	}
+	h.conn = conn
+	h.Unlock()
+
+	defer func() {
+		h.Lock()
+		h.conn = nil
+		h.Unlock()
+		_ = conn.Close()
+	}()
+
+	deadline := time.Second * heartbeatInterval * 3
+	dec := json.NewDecoder(conn)
+
+	if h.config.Secret != "" {
+		var c challenge
+		_ = conn.SetDeadline(time.Now().Add(deadline))
+		if err := dec.Decode(&c); err != nil {
+			return err
+		}
+
+		if len(c.Nonce) == 0 {
+			return ErrUnauthorized // the primary did not challenge the standby
+		}
+
+		if err := json.NewEncoder(conn).Encode(response{MAC: sign(h.config.Secret, c.Nonce)}); err != nil {
+			return err
+		}
+	}
+
+	var snap mqtt.Snapshot
+	_ = conn.SetReadDeadline(time.Now().Add(deadline))
+	if err := dec.Decode(&snap); err != nil {
+		return err
+	}
+
+	if err := h.resync(snap); err != nil {
+		return err
+	}
+
+	h.Log.Info("following primary", "primary", h.config.Address, "clients", len(snap.Clients), "retained", len(snap.Retained))
+	for {
+		atomic.StoreInt64(&h.lastSeen, time.Now().Unix())
+
+		var u update
+		_ = conn.SetReadDeadline(time.Now().Add(deadline))
+		if err := dec.Decode(&u); err != nil {
+			return err
+		}
+
+		if err := h.apply(u); err != nil {
+			h.Log.Error("failed to apply update from primary", "error", err)
+		}
+	}
+}
+
+// resync replaces the replicated state of the standby with a snapshot from the primary.
+func (h *Hook) resync(snap mqtt.Snapshot) error {
+	s := h.config.Server
+	for _, cl := range s.Clients.GetAll() {
+		if !cl.Net.Inline && cl.Closed() {
+			h.removeClient(cl)
+		}
+	}
+
+	keep := map[string]bool{}
+	for _, msg := range snap.Retained {
+		keep[msg.TopicName] = true
+	}
+
+	for topic := range s.Topics.Retained.GetAll() {
+		if !keep[topic] {
+			h.clearRetained(topic)
+		}
+	}
+
+	return s.RestoreSnapshot(snap)
+}
+
+// apply applies a single update from the primary to the standby.
+func (h *Hook) apply(u update) error {
+	s := h.config.Server
+	if u.Expire != "" {
+		if cl, ok := s.Clients.Get(u.Expire); ok && cl.Closed() {
+			h.removeClient(cl)
+		}
+	}
+
+	for _, sub := range u.Unsubscribe {
+		s.Topics.Unsubscribe(sub.Filter, sub.Client)
+		if cl, ok := s.Clients.Get(sub.Client); ok {
+			cl.State.Subscriptions.Delete(sub.Filter)
+		}
+	}
+
+	if u.Clear != "" {
+		h.clearRetained(u.Clear)
+	}
+
+	if u.State == nil {
+		return nil
+	}
+
+	for _, c := range u.State.Clients {
+		if cl, ok := s.Clients.Get(c.ID); ok && cl.Closed() {
+			h.removeClient(cl) // replaced with the latest session state
+		}
+	}
+
+	return s.RestoreSnapshot(*u.State)
+}
+
+// removeClient removes a replicated client session and its subscriptions.
+func (h *Hook) removeClient(cl *mqtt.Client) {
+	cl.ClearInflights()
+	h.config.Server.UnsubscribeClient(cl)
+	h.config.Server.Clients.Delete(cl.ID)
+}
+
+// clearRetained removes a replicated retained message.
+func (h *Hook) clearRetained(topic string) {
+	s := h.config.Server
+	s.Topics.RetainMessage(packets.Packet{
+		FixedHeader: packets.FixedHeader{Type: packets.Publish, Retain: true},
+		TopicName:   topic,
+	})
+	atomic.StoreInt64(&s.Info.Retained, int64(s.Topics.Retained.Len()))
+}
+
+// newState returns an empty snapshot for carrying replicated state.
+func newState() *mqtt.Snapshot {
+	return &mqtt.Snapshot{
+		Version: mqtt.SnapshotVersion,
+		Created: time.Now().Unix(),
+	}
+}
+
+// clientState returns a snapshot containing a client session and its subscriptions.
+func clientState(cl *mqtt.Client) *mqtt.Snapshot {
+	props := cl.Properties.Props.Copy(false)
+	state := newState()
+	state.Clients = append(state.Clients, storage.Client{
+		ID:              cl.ID,
+		T:               storage.ClientKey,
+		Remote:          cl.Net.Remote,
+		Listener:        cl.Net.Listener,
+		Username:        cl.Properties.Username,
+		Clean:           cl.Properties.Clean,
+		ProtocolVersion: cl.Properties.ProtocolVersion,
+		Created:         cl.Session().Created,
+		Properties: storage.ClientProperties{
+			SessionExpiryInterval:     props.SessionExpiryInterval,
+			SessionExpiryIntervalFlag: props.SessionExpiryIntervalFlag,
+			AuthenticationMethod:      props.AuthenticationMethod,
+			AuthenticationData:        props.AuthenticationData,
+			RequestProblemInfo:        props.RequestProblemInfo,
+			RequestProblemInfoFlag:    props.RequestProblemInfoFlag,
+			RequestResponseInfo:       props.RequestResponseInfo,
+			ReceiveMaximum:            props.ReceiveMaximum,
+			TopicAliasMaximum:         props.TopicAliasMaximum,
+			User:                      props.User,
+			MaximumPacketSize:         props.MaximumPacketSize,
+		},
+		Will: storage.ClientWill(cl.Properties.Will),
+	})
+
+	for _, sub := range cl.State.Subscriptions.GetAll() {
+		state.Subscriptions = append(state.Subscriptions, subscription(cl.ID, sub, sub.Qos))
+	}
+
+	return state
+}
+
+// subscription converts a client subscription to a storage subscription.
+func subscription(client string, sub packets.Subscription, qos byte) storage.Subscription {
+	return storage.Subscription{
+		ID:                storage.SubscriptionKey + "_" + client + ":" + sub.Filter,
+		T:                 storage.SubscriptionKey,
+		Client:            client,
+		Filter:            sub.Filter,
+		Identifier:        sub.Identifier,
+		RetainHandling:    sub.RetainHandling,
+		Qos:               qos,
+		RetainAsPublished: sub.RetainAsPublished,
+		NoLocal:           sub.NoLocal,
+	}
+}
+
+// message converts a retained packet to a storage message.
+func message(pk packets.Packet) storage.Message {
+	props := pk.Properties.Copy(false)
+	return storage.Message{
+		ID:          storage.RetainedKey + "_" + pk.TopicName,
+		T:           storage.RetainedKey,
+		FixedHeader: pk.FixedHeader,
+		TopicName:   pk.TopicName,
+		Payload:     pk.Payload,
+		Compressed:  pk.Compressed,
+		Origin:      pk.Origin,
+		Created:     pk.Created,
+		Properties: storage.MessageProperties{
+			PayloadFormat:         props.PayloadFormat,
+			PayloadFormatFlag:     props.PayloadFormatFlag,
+			MessageExpiryInterval: props.MessageExpiryInterval,
+			ContentType:           props.ContentType,
+			ResponseTopic:         props.ResponseTopic,
+			CorrelationData:       props.CorrelationData,
+			User:                  props.User,
+		},
+	}
+}
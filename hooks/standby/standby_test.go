@@ -0,0 +1,294 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package standby
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"log/slog"
+	"math/big"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/storage"
+	"github.com/mochi-mqtt/server/v2/packets"
+
+	"github.com/stretchr/testify/require"
+)
+
+var logger = slog.New(slog.NewTextHandler(os.Stdout, nil))
+
+const secret = "mochi-secret"
+
+func newHook(t *testing.T, opts *Options) (*mqtt.Server, *Hook) {
+	s := mqtt.New(&mqtt.Options{Logger: logger})
+	opts.Server = s
+	h := new(Hook)
+	h.SetOpts(logger, nil)
+	require.NoError(t, h.Init(opts))
+	t.Cleanup(func() { _ = h.Stop() })
+	return s, h
+}
+
+func newPrimary(t *testing.T) (*mqtt.Server, *Hook) {
+	return newHook(t, &Options{Mode: ModePrimary, Address: "127.0.0.1:0", Secret: secret})
+}
+
+func newStandby(t *testing.T, addr string, promoteAfter int64) (*mqtt.Server, *Hook) {
+	return newHook(t, &Options{Mode: ModeStandby, Address: addr, ReconnectInterval: 1, PromoteAfter: promoteAfter, Secret: secret})
+}
+
+// newTLSConfigs returns a primary tls config which requires client certificates issued
+// by a test ca, and standby tls configs with and without such a certificate.
+func newTLSConfigs(t *testing.T) (primary, standby, anonymous *tls.Config) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "mochi"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	ca, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	cert := tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+
+	primary = &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+	}
+	standby = &tls.Config{MinVersion: tls.VersionTLS12, RootCAs: pool, Certificates: []tls.Certificate{cert}}
+	anonymous = &tls.Config{MinVersion: tls.VersionTLS12, RootCAs: pool}
+	return primary, standby, anonymous
+}
+
+func TestID(t *testing.T) {
+	h := new(Hook)
+	require.Equal(t, "standby", h.ID())
+}
+
+func TestProvides(t *testing.T) {
+	h := new(Hook)
+	require.True(t, h.Provides(mqtt.OnSessionEstablished))
+	require.True(t, h.Provides(mqtt.OnRetainMessage))
+	require.True(t, h.Provides(mqtt.OnStarted))
+	require.False(t, h.Provides(mqtt.OnPublish))
+}
+
+func TestInitBadConfig(t *testing.T) {
+	h := new(Hook)
+	h.SetOpts(logger, nil)
+	err := h.Init(map[string]any{})
+	require.ErrorIs(t, err, mqtt.ErrInvalidConfigType)
+}
+
+func TestInitNoServer(t *testing.T) {
+	h := new(Hook)
+	h.SetOpts(logger, nil)
+	err := h.Init(nil)
+	require.ErrorIs(t, err, ErrServerRequired)
+}
+
+func TestInitInvalidMode(t *testing.T) {
+	h := new(Hook)
+	h.SetOpts(logger, nil)
+	err := h.Init(&Options{Server: mqtt.New(nil), Mode: "other", Address: ":0"})
+	require.ErrorIs(t, err, ErrInvalidMode)
+}
+
+func TestInitNoAddress(t *testing.T) {
+	h := new(Hook)
+	h.SetOpts(logger, nil)
+	err := h.Init(&Options{Server: mqtt.New(nil), Mode: ModeStandby})
+	require.ErrorIs(t, err, ErrAddressRequired)
+}
+
+func TestInitAuthRequired(t *testing.T) {
+	h := new(Hook)
+	h.SetOpts(logger, nil)
+	err := h.Init(&Options{Server: mqtt.New(nil), Mode: ModePrimary, Address: "127.0.0.1:0"})
+	require.ErrorIs(t, err, ErrAuthRequired)
+
+	// encryption alone does not authenticate standbys.
+	err = h.Init(&Options{Server: mqtt.New(nil), Mode: ModePrimary, Address: "127.0.0.1:0", TLSConfig: &tls.Config{MinVersion: tls.VersionTLS12}})
+	require.ErrorIs(t, err, ErrAuthRequired)
+}
+
+func TestReplicationWrongSecret(t *testing.T) {
+	ps, ph := newPrimary(t)
+	ps.Topics.RetainMessage(packets.Packet{TopicName: "a/b/c", Payload: []byte("secret")})
+
+	ss, sh := newHook(t, &Options{Mode: ModeStandby, Address: ph.listener.Addr().String(), ReconnectInterval: 1, Secret: "wrong"})
+	require.Error(t, sh.sync()) // the primary closes the connection
+	require.Empty(t, ss.Topics.Messages("a/b/c"))
+
+	// a standby without a secret is not sent any state either.
+	ss, sh = newHook(t, &Options{Mode: ModeStandby, Address: ph.listener.Addr().String(), ReconnectInterval: 1})
+	require.Error(t, sh.sync())
+	require.Empty(t, ss.Topics.Messages("a/b/c"))
+
+	ph.Lock()
+	defer ph.Unlock()
+	require.Empty(t, ph.followers)
+}
+
+func TestReplicationTLS(t *testing.T) {
+	primary, standby, anonymous := newTLSConfigs(t)
+	ps, ph := newHook(t, &Options{Mode: ModePrimary, Address: "127.0.0.1:0", TLSConfig: primary})
+	ps.Topics.RetainMessage(packets.Packet{TopicName: "a/b/c", Payload: []byte("hello")})
+
+	ss, sh := newHook(t, &Options{Mode: ModeStandby, Address: ph.listener.Addr().String(), ReconnectInterval: 1, TLSConfig: anonymous})
+	require.Error(t, sh.sync())
+	require.Empty(t, ss.Topics.Messages("a/b/c"))
+
+	ss, sh = newHook(t, &Options{Mode: ModeStandby, Address: ph.listener.Addr().String(), ReconnectInterval: 1, TLSConfig: standby})
+	sh.OnStarted()
+	require.Eventually(t, func() bool {
+		return len(ss.Topics.Messages("a/b/c")) == 1
+	}, time.Second*2, time.Millisecond*10)
+}
+
+func TestReplication(t *testing.T) {
+	ps, ph := newPrimary(t)
+	ps.Topics.RetainMessage(packets.Packet{
+		FixedHeader: packets.FixedHeader{Type: packets.Publish, Retain: true},
+		TopicName:   "a/b/c",
+		Payload:     []byte("before"),
+	})
+
+	ss, sh := newStandby(t, ph.listener.Addr().String(), 0)
+	sh.OnStarted()
+
+	// the snapshot sent on connection contains the existing retained message.
+	require.Eventually(t, func() bool {
+		return len(ss.Topics.Messages("a/b/c")) == 1
+	}, time.Second*2, time.Millisecond*10)
+
+	require.Eventually(t, func() bool {
+		ph.Lock()
+		defer ph.Unlock()
+		return len(ph.followers) == 1
+	}, time.Second*2, time.Millisecond*10)
+
+	cl := ps.NewClient(nil, "tcp", "mochi", false)
+	cl.Properties.ProtocolVersion = 4
+	cl.Properties.Username = []byte("mochi-user")
+	cl.State.Subscriptions.Add("d/e/f", packets.Subscription{Filter: "d/e/f", Qos: 1})
+	ps.Clients.Add(cl)
+	ph.OnSessionEstablished(cl, packets.Packet{})
+	ph.OnSubscribed(cl, packets.Packet{Filters: packets.Subscriptions{{Filter: "g/h/i"}}}, []byte{0})
+	ph.OnRetainMessage(cl, packets.Packet{
+		FixedHeader: packets.FixedHeader{Type: packets.Publish, Retain: true},
+		TopicName:   "x/y/z",
+		Payload:     []byte("after"),
+	}, 1)
+	ph.OnRetainMessage(cl, packets.Packet{TopicName: "a/b/c"}, -1)
+
+	require.Eventually(t, func() bool {
+		scl, ok := ss.Clients.Get("mochi")
+		if !ok {
+			return false
+		}
+		_, ok = scl.State.Subscriptions.Get("g/h/i")
+		return ok && len(ss.Topics.Messages("x/y/z")) == 1 && len(ss.Topics.Messages("a/b/c")) == 0
+	}, time.Second*2, time.Millisecond*10)
+
+	scl, _ := ss.Clients.Get("mochi")
+	require.Equal(t, []byte("mochi-user"), scl.Properties.Username)
+	_, ok := scl.State.Subscriptions.Get("d/e/f")
+	require.True(t, ok)
+
+	ph.OnUnsubscribed(cl, packets.Packet{Filters: packets.Subscriptions{{Filter: "d/e/f"}}})
+	require.Eventually(t, func() bool {
+		_, ok := scl.State.Subscriptions.Get("d/e/f")
+		return !ok && len(ss.Topics.Subscribers("d/e/f").Subscriptions) == 0
+	}, time.Second*2, time.Millisecond*10)
+
+	ph.OnClientExpired(cl)
+	require.Eventually(t, func() bool {
+		_, ok := ss.Clients.Get("mochi")
+		return !ok
+	}, time.Second*2, time.Millisecond*10)
+}
+
+func TestPrimaryIgnoresTakenOverDisconnect(t *testing.T) {
+	ps, ph := newPrimary(t)
+	old := ps.NewClient(nil, "tcp", "mochi", false)
+	cur := ps.NewClient(nil, "tcp", "mochi", false)
+	ps.Clients.Add(cur)
+
+	ch := make(chan update, 1)
+	conn, _ := net.Pipe()
+	ph.followers[conn] = ch
+	ph.OnDisconnect(old, nil, true)
+	require.Len(t, ch, 0)
+
+	ph.OnDisconnect(cur, nil, true)
+	require.Len(t, ch, 1)
+	require.Equal(t, "mochi", (<-ch).Expire)
+}
+
+func TestResyncRemovesStaleState(t *testing.T) {
+	ss, sh := newStandby(t, "127.0.0.1:1", 0)
+	ss.Topics.RetainMessage(packets.Packet{TopicName: "stale", Payload: []byte("x")})
+	cl := ss.NewClient(nil, "tcp", "stale", false)
+	cl.Stop(nil)
+	ss.Clients.Add(cl)
+
+	snap := *newState()
+	snap.Clients = []storage.Client{{ID: "fresh", ProtocolVersion: 4}}
+	err := sh.resync(snap)
+	require.NoError(t, err)
+
+	_, ok := ss.Clients.Get("stale")
+	require.False(t, ok)
+	_, ok = ss.Clients.Get("fresh")
+	require.True(t, ok)
+	require.Empty(t, ss.Topics.Messages("stale"))
+}
+
+func TestPromoteAfter(t *testing.T) {
+	_, sh := newStandby(t, "127.0.0.1:1", 1)
+	sh.OnStarted()
+	require.Eventually(t, sh.Promoted, time.Second*5, time.Millisecond*50)
+}
+
+func TestPromote(t *testing.T) {
+	_, ph := newPrimary(t)
+	_, sh := newStandby(t, ph.listener.Addr().String(), 0)
+	sh.OnStarted()
+	require.Eventually(t, func() bool {
+		sh.Lock()
+		defer sh.Unlock()
+		return sh.conn != nil
+	}, time.Second*2, time.Millisecond*10)
+
+	sh.Promote()
+	require.True(t, sh.Promoted())
+	<-sh.done
+
+	ph.Promote() // no effect on a primary
+	require.False(t, ph.Promoted())
+}
@@ -50,6 +50,11 @@ func sysInfoKey() string {
 	return storage.SysInfoKey
 }
 
+// banKey returns a primary key for a ban.
+func banKey(ban mqtt.Ban) string {
+	return storage.BanKey + "_" + ban.Key()
+}
+
 // Serializable is an interface for objects that can be serialized and deserialized.
 type Serializable interface {
 	UnmarshalBinary([]byte) error
@@ -66,6 +71,15 @@ type Options struct {
 	// discardRatio must be in the range (0.0, 1.0), both endpoints excluded, otherwise, it will be set to the default value of 0.5.
 	GcDiscardRatio float64 `yaml:"gc_discard_ratio" json:"gc_discard_ratio"`
 	GcInterval     int64   `yaml:"gc_interval" json:"gc_interval"`
+	// EncryptionKey is a hex encoded 16, 24, or 32 byte AES key used to encrypt stored
+	// payloads and credentials, if no Cipher is set.
+	EncryptionKey string `yaml:"encryption_key" json:"encryption_key"`
+	// Cipher encrypts stored payloads and credentials, eg. using a key management service.
+	Cipher storage.Cipher `yaml:"-" json:"-"`
+	// SubscriptionsOnly stores clients and their subscriptions, retained messages, and bans,
+	// but not inflight or queued messages, so clients are resubscribed after a restart
+	// without the write load of persisting every qos message.
+	SubscriptionsOnly bool `yaml:"subscriptions_only" json:"subscriptions_only"`
 }
 
 // Hook is a persistent storage hook based using BadgerDB file store as a backend.
@@ -83,6 +97,13 @@ func (h *Hook) ID() string {
 
 // Provides indicates which hook methods this hook provides.
 func (h *Hook) Provides(b byte) bool {
+	if h.config != nil && h.config.SubscriptionsOnly {
+		switch b {
+		case mqtt.OnQosPublish, mqtt.OnQosComplete, mqtt.OnQosDropped, mqtt.StoredInflightMessages:
+			return false
+		}
+	}
+
 	return bytes.Contains([]byte{
 		mqtt.OnSessionEstablished,
 		mqtt.OnDisconnect,
@@ -96,11 +117,15 @@ func (h *Hook) Provides(b byte) bool {
 		mqtt.OnSysInfoTick,
 		mqtt.OnClientExpired,
 		mqtt.OnRetainedExpired,
+		mqtt.OnBanned,
+		mqtt.OnUnbanned,
+		mqtt.OnCompact,
 		mqtt.StoredClients,
 		mqtt.StoredInflightMessages,
 		mqtt.StoredRetainedMessages,
 		mqtt.StoredSubscriptions,
 		mqtt.StoredSysInfo,
+		mqtt.StoredBans,
 	}, []byte{b})
 }
 
@@ -143,6 +168,14 @@ func (h *Hook) Init(config any) error {
 		h.config.GcDiscardRatio = defaultGcDiscardRatio
 	}
 
+	if h.config.Cipher == nil && h.config.EncryptionKey != "" {
+		c, err := storage.NewAESCipherFromHex(h.config.EncryptionKey)
+		if err != nil {
+			return err
+		}
+		h.config.Cipher = c
+	}
+
 	if h.config.Options == nil {
 		defaultOpts := badgerdb.DefaultOptions(h.config.Path)
 		h.config.Options = &defaultOpts
@@ -155,12 +188,66 @@ func (h *Hook) Init(config any) error {
 		return err
 	}
 
+	if err := h.migrate(); err != nil {
+		_ = h.db.Close()
+		return err
+	}
+
 	h.gcTicker = time.NewTicker(time.Duration(h.config.GcInterval) * time.Second)
 	go h.gcLoop()
 
 	return nil
 }
 
+// migrate upgrades any records written by an older storage format version to the
+// current version, and records the current version in the store.
+func (h *Hook) migrate() error {
+	ver := storage.StoreVersion{Version: 1}
+	err := h.getKv(storage.VersionKey, &ver)
+	if err != nil && !errors.Is(err, badgerdb.ErrKeyNotFound) {
+		return err
+	}
+
+	if ver.Version > storage.Version {
+		return fmt.Errorf("%w: %d", storage.ErrUnsupportedVersion, ver.Version)
+	}
+
+	if ver.Version == storage.Version {
+		return nil
+	}
+
+	err = h.db.Update(func(txn *badgerdb.Txn) error {
+		iterator := txn.NewIterator(badgerdb.DefaultIteratorOptions)
+		defer iterator.Close()
+
+		for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+			item := iterator.Item()
+			value, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+
+			data, err := storage.Migrate(ver.Version, value)
+			if err != nil {
+				return fmt.Errorf("key %s: %w", item.Key(), err)
+			}
+
+			if !bytes.Equal(data, value) {
+				if err := txn.Set(item.KeyCopy(nil), data); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	h.Log.Info("migrated storage format", "from", ver.Version, "to", storage.Version)
+	return h.setKv(storage.VersionKey, storage.CurrentVersion())
+}
+
 // Stop closes the badger instance.
 func (h *Hook) Stop() error {
 	if h.gcTicker != nil {
@@ -195,6 +282,7 @@ func (h *Hook) updateClient(cl *mqtt.Client) {
 		Username:        cl.Properties.Username,
 		Clean:           cl.Properties.Clean,
 		ProtocolVersion: cl.Properties.ProtocolVersion,
+		Created:         cl.Session().Created,
 		Properties: storage.ClientProperties{
 			SessionExpiryInterval: props.SessionExpiryInterval,
 			AuthenticationMethod:  props.AuthenticationMethod,
@@ -209,6 +297,11 @@ func (h *Hook) updateClient(cl *mqtt.Client) {
 		Will: storage.ClientWill(cl.Properties.Will),
 	}
 
+	if err := in.Encrypt(h.config.Cipher); err != nil {
+		h.Log.Error("failed to encrypt data", "error", err, "key", in.ID)
+		return
+	}
+
 	err := h.setKv(clientKey(cl), in)
 	if err != nil {
 		h.Log.Error("failed to upsert client data", "error", err, "data", in)
@@ -291,6 +384,7 @@ func (h *Hook) OnRetainMessage(cl *mqtt.Client, pk packets.Packet, r int64) {
 		FixedHeader: pk.FixedHeader,
 		TopicName:   pk.TopicName,
 		Payload:     pk.Payload,
+		Compressed:  pk.Compressed,
 		Created:     pk.Created,
 		Origin:      pk.Origin,
 		Properties: storage.MessageProperties{
@@ -305,6 +399,11 @@ func (h *Hook) OnRetainMessage(cl *mqtt.Client, pk packets.Packet, r int64) {
 		},
 	}
 
+	if err := in.Encrypt(h.config.Cipher); err != nil {
+		h.Log.Error("failed to encrypt data", "error", err, "key", in.ID)
+		return
+	}
+
 	_ = h.setKv(in.ID, in)
 }
 
@@ -338,6 +437,11 @@ func (h *Hook) OnQosPublish(cl *mqtt.Client, pk packets.Packet, sent int64, rese
 		},
 	}
 
+	if err := in.Encrypt(h.config.Cipher); err != nil {
+		h.Log.Error("failed to encrypt data", "error", err, "key", in.ID)
+		return
+	}
+
 	_ = h.setKv(in.ID, in)
 }
 
@@ -396,6 +500,52 @@ func (h *Hook) OnClientExpired(cl *mqtt.Client) {
 	_ = h.delKv(clientKey(cl))
 }
 
+// OnBanned adds or updates a ban in the store.
+func (h *Hook) OnBanned(ban mqtt.Ban) {
+	if h.db == nil {
+		h.Log.Error("", "error", storage.ErrDBFileNotOpen)
+		return
+	}
+
+	in := &storage.Ban{
+		ID:       banKey(ban),
+		T:        storage.BanKey,
+		ClientID: ban.ClientID,
+		IP:       ban.IP,
+		Reason:   ban.Reason,
+		Created:  ban.Created,
+		Expiry:   ban.Expiry,
+	}
+
+	_ = h.setKv(in.ID, in)
+}
+
+// OnUnbanned deletes a removed or expired ban from the store.
+func (h *Hook) OnUnbanned(ban mqtt.Ban) {
+	if h.db == nil {
+		h.Log.Error("", "error", storage.ErrDBFileNotOpen)
+		return
+	}
+
+	_ = h.delKv(banKey(ban))
+}
+
+// OnCompact runs value log garbage collection until no further space can be reclaimed.
+func (h *Hook) OnCompact() error {
+	if h.db == nil {
+		return storage.ErrDBFileNotOpen
+	}
+
+	for {
+		err := h.db.RunValueLogGC(h.config.GcDiscardRatio)
+		if errors.Is(err, badgerdb.ErrNoRewrite) || errors.Is(err, badgerdb.ErrRejected) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
 // StoredClients returns all stored clients from the store.
 func (h *Hook) StoredClients() (v []storage.Client, err error) {
 	if h.db == nil {
@@ -406,6 +556,9 @@ func (h *Hook) StoredClients() (v []storage.Client, err error) {
 	err = h.iterKv(storage.ClientKey, func(value []byte) error {
 		obj := storage.Client{}
 		err = obj.UnmarshalBinary(value)
+		if err == nil {
+			err = obj.Decrypt(h.config.Cipher)
+		}
 		if err == nil {
 			v = append(v, obj)
 		}
@@ -444,6 +597,9 @@ func (h *Hook) StoredRetainedMessages() (v []storage.Message, err error) {
 	err = h.iterKv(storage.RetainedKey, func(value []byte) error {
 		obj := storage.Message{}
 		err = obj.UnmarshalBinary(value)
+		if err == nil {
+			err = obj.Decrypt(h.config.Cipher)
+		}
 		if err == nil {
 			v = append(v, obj)
 		}
@@ -467,6 +623,9 @@ func (h *Hook) StoredInflightMessages() (v []storage.Message, err error) {
 	err = h.iterKv(storage.InflightKey, func(value []byte) error {
 		obj := storage.Message{}
 		err = obj.UnmarshalBinary(value)
+		if err == nil {
+			err = obj.Decrypt(h.config.Cipher)
+		}
 		if err == nil {
 			v = append(v, obj)
 		}
@@ -489,6 +648,24 @@ func (h *Hook) StoredSysInfo() (v storage.SystemInfo, err error) {
 	return v, nil
 }
 
+// StoredBans returns all stored bans from the store.
+func (h *Hook) StoredBans() (v []storage.Ban, err error) {
+	if h.db == nil {
+		h.Log.Error("", "error", storage.ErrDBFileNotOpen)
+		return
+	}
+
+	err = h.iterKv(storage.BanKey, func(value []byte) error {
+		obj := storage.Ban{}
+		err = obj.UnmarshalBinary(value)
+		if err == nil {
+			v = append(v, obj)
+		}
+		return err
+	})
+	return
+}
+
 // Errorf satisfies the badger interface for an error logger.
 func (h *Hook) Errorf(m string, v ...interface{}) {
 	h.Log.Error(fmt.Sprintf(strings.ToLower(strings.Trim(m, "\n")), v...), "v", v)
@@ -518,6 +695,7 @@ func (h *Hook) setKv(k string, v storage.Serializable) error {
 	})
 	if err != nil {
 		h.Log.Error("failed to upsert data", "error", err, "key", k)
+		h.ReportError(nil, mqtt.ErrorSubsystemStorage, err)
 	}
 	return err
 }
@@ -530,6 +708,7 @@ func (h *Hook) delKv(k string) error {
 
 	if err != nil {
 		h.Log.Error("failed to delete data", "error", err, "key", k)
+		h.ReportError(nil, mqtt.ErrorSubsystemStorage, err)
 	}
 	return err
 }
@@ -8,6 +8,7 @@ package bolt
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"time"
 
 	mqtt "github.com/mochi-mqtt/server/v2"
@@ -53,10 +54,24 @@ func sysInfoKey() string {
 	return storage.SysInfoKey
 }
 
+// banKey returns a primary key for a ban.
+func banKey(ban mqtt.Ban) string {
+	return storage.BanKey + "_" + ban.Key()
+}
+
 // Options contains configuration settings for the bolt instance.
 type Options struct {
 	Options *bbolt.Options
 	Path    string `yaml:"path" json:"path"`
+	// EncryptionKey is a hex encoded 16, 24, or 32 byte AES key used to encrypt stored
+	// payloads and credentials, if no Cipher is set.
+	EncryptionKey string `yaml:"encryption_key" json:"encryption_key"`
+	// Cipher encrypts stored payloads and credentials, eg. using a key management service.
+	Cipher storage.Cipher `yaml:"-" json:"-"`
+	// SubscriptionsOnly stores clients and their subscriptions, retained messages, and bans,
+	// but not inflight or queued messages, so clients are resubscribed after a restart
+	// without the write load of persisting every qos message.
+	SubscriptionsOnly bool `yaml:"subscriptions_only" json:"subscriptions_only"`
 }
 
 // Hook is a persistent storage hook based using boltdb file store as a backend.
@@ -73,6 +88,13 @@ func (h *Hook) ID() string {
 
 // Provides indicates which hook methods this hook provides.
 func (h *Hook) Provides(b byte) bool {
+	if h.config != nil && h.config.SubscriptionsOnly {
+		switch b {
+		case mqtt.OnQosPublish, mqtt.OnQosComplete, mqtt.OnQosDropped, mqtt.StoredInflightMessages:
+			return false
+		}
+	}
+
 	return bytes.Contains([]byte{
 		mqtt.OnSessionEstablished,
 		mqtt.OnDisconnect,
@@ -86,11 +108,14 @@ func (h *Hook) Provides(b byte) bool {
 		mqtt.OnSysInfoTick,
 		mqtt.OnClientExpired,
 		mqtt.OnRetainedExpired,
+		mqtt.OnBanned,
+		mqtt.OnUnbanned,
 		mqtt.StoredClients,
 		mqtt.StoredInflightMessages,
 		mqtt.StoredRetainedMessages,
 		mqtt.StoredSubscriptions,
 		mqtt.StoredSysInfo,
+		mqtt.StoredBans,
 	}, []byte{b})
 }
 
@@ -114,15 +139,49 @@ func (h *Hook) Init(config any) error {
 		h.config.Path = defaultDbFile
 	}
 
+	if h.config.Cipher == nil && h.config.EncryptionKey != "" {
+		c, err := storage.NewAESCipherFromHex(h.config.EncryptionKey)
+		if err != nil {
+			return err
+		}
+		h.config.Cipher = c
+	}
+
 	var err error
 	h.db, err = storm.Open(h.config.Path, storm.BoltOptions(0600, h.config.Options), storm.Codec(sgob.Codec))
 	if err != nil {
 		return err
 	}
 
+	if err := h.checkVersion(); err != nil {
+		_ = h.db.Close()
+		return err
+	}
+
 	return nil
 }
 
+// checkVersion ensures the store was not written by a newer storage format version, and
+// records the current version in the store. Records are gob encoded structs which decode
+// across versions when fields are added, so no record migrations are applied.
+func (h *Hook) checkVersion() error {
+	ver := storage.StoreVersion{Version: 1}
+	err := h.db.One("ID", storage.VersionKey, &ver)
+	if err != nil && !errors.Is(err, storm.ErrNotFound) {
+		return err
+	}
+
+	if ver.Version > storage.Version {
+		return fmt.Errorf("%w: %d", storage.ErrUnsupportedVersion, ver.Version)
+	}
+
+	if ver.Version == storage.Version {
+		return nil
+	}
+
+	return h.db.Save(storage.CurrentVersion())
+}
+
 // Stop closes the boltdb instance.
 func (h *Hook) Stop() error {
 	return h.db.Close()
@@ -154,6 +213,7 @@ func (h *Hook) updateClient(cl *mqtt.Client) {
 		Username:        cl.Properties.Username,
 		Clean:           cl.Properties.Clean,
 		ProtocolVersion: cl.Properties.ProtocolVersion,
+		Created:         cl.Session().Created,
 		Properties: storage.ClientProperties{
 			SessionExpiryInterval: props.SessionExpiryInterval,
 			AuthenticationMethod:  props.AuthenticationMethod,
@@ -167,9 +227,15 @@ func (h *Hook) updateClient(cl *mqtt.Client) {
 		},
 		Will: storage.ClientWill(cl.Properties.Will),
 	}
+	if err := in.Encrypt(h.config.Cipher); err != nil {
+		h.Log.Error("failed to encrypt data", "error", err, "key", in.ID)
+		return
+	}
+
 	err := h.db.Save(in)
 	if err != nil {
 		h.Log.Error("failed to save client data", "error", err, "data", in)
+		h.ReportError(cl, mqtt.ErrorSubsystemStorage, err)
 	}
 }
 
@@ -191,6 +257,7 @@ func (h *Hook) OnDisconnect(cl *mqtt.Client, _ error, expire bool) {
 	err := h.db.DeleteStruct(&storage.Client{ID: clientKey(cl)})
 	if err != nil && !errors.Is(err, storm.ErrNotFound) {
 		h.Log.Error("failed to delete client", "error", err, "id", clientKey(cl))
+		h.ReportError(cl, mqtt.ErrorSubsystemStorage, err)
 	}
 }
 
@@ -218,6 +285,7 @@ func (h *Hook) OnSubscribed(cl *mqtt.Client, pk packets.Packet, reasonCodes []by
 		err := h.db.Save(in)
 		if err != nil {
 			h.Log.Error("failed to save subscription data", "error", err, "client", cl.ID, "data", in)
+			h.ReportError(cl, mqtt.ErrorSubsystemStorage, err)
 		}
 	}
 }
@@ -235,6 +303,7 @@ func (h *Hook) OnUnsubscribed(cl *mqtt.Client, pk packets.Packet) {
 		})
 		if err != nil {
 			h.Log.Error("failed to delete client", "error", err, "id", subscriptionKey(cl, pk.Filters[i].Filter))
+			h.ReportError(cl, mqtt.ErrorSubsystemStorage, err)
 		}
 	}
 }
@@ -252,6 +321,7 @@ func (h *Hook) OnRetainMessage(cl *mqtt.Client, pk packets.Packet, r int64) {
 		})
 		if err != nil {
 			h.Log.Error("failed to delete retained publish", "error", err, "id", retainedKey(pk.TopicName))
+			h.ReportError(cl, mqtt.ErrorSubsystemStorage, err)
 		}
 		return
 	}
@@ -263,6 +333,7 @@ func (h *Hook) OnRetainMessage(cl *mqtt.Client, pk packets.Packet, r int64) {
 		FixedHeader: pk.FixedHeader,
 		TopicName:   pk.TopicName,
 		Payload:     pk.Payload,
+		Compressed:  pk.Compressed,
 		Created:     pk.Created,
 		Origin:      pk.Origin,
 		Properties: storage.MessageProperties{
@@ -276,9 +347,15 @@ func (h *Hook) OnRetainMessage(cl *mqtt.Client, pk packets.Packet, r int64) {
 			User:                   props.User,
 		},
 	}
+	if err := in.Encrypt(h.config.Cipher); err != nil {
+		h.Log.Error("failed to encrypt data", "error", err, "key", in.ID)
+		return
+	}
+
 	err := h.db.Save(in)
 	if err != nil {
 		h.Log.Error("failed to save retained publish data", "error", err, "client", cl.ID, "data", in)
+		h.ReportError(cl, mqtt.ErrorSubsystemStorage, err)
 	}
 }
 
@@ -294,6 +371,7 @@ func (h *Hook) OnQosPublish(cl *mqtt.Client, pk packets.Packet, sent int64, rese
 		ID:          inflightKey(cl, pk),
 		T:           storage.InflightKey,
 		Origin:      pk.Origin,
+		PacketID:    pk.PacketID,
 		FixedHeader: pk.FixedHeader,
 		TopicName:   pk.TopicName,
 		Payload:     pk.Payload,
@@ -311,9 +389,15 @@ func (h *Hook) OnQosPublish(cl *mqtt.Client, pk packets.Packet, sent int64, rese
 		},
 	}
 
+	if err := in.Encrypt(h.config.Cipher); err != nil {
+		h.Log.Error("failed to encrypt data", "error", err, "key", in.ID)
+		return
+	}
+
 	err := h.db.Save(in)
 	if err != nil {
 		h.Log.Error("failed to save qos inflight data", "error", err, "client", cl.ID, "data", in)
+		h.ReportError(cl, mqtt.ErrorSubsystemStorage, err)
 	}
 }
 
@@ -329,6 +413,7 @@ func (h *Hook) OnQosComplete(cl *mqtt.Client, pk packets.Packet) {
 	})
 	if err != nil {
 		h.Log.Error("failed to delete inflight data", "error", err, "id", inflightKey(cl, pk))
+		h.ReportError(cl, mqtt.ErrorSubsystemStorage, err)
 	}
 }
 
@@ -357,6 +442,7 @@ func (h *Hook) OnSysInfoTick(sys *system.Info) {
 	err := h.db.Save(in)
 	if err != nil {
 		h.Log.Error("failed to save $SYS data", "error", err, "data", in)
+		h.ReportError(nil, mqtt.ErrorSubsystemStorage, err)
 	}
 }
 
@@ -369,6 +455,7 @@ func (h *Hook) OnRetainedExpired(filter string) {
 
 	if err := h.db.DeleteStruct(&storage.Message{ID: retainedKey(filter)}); err != nil {
 		h.Log.Error("failed to delete retained publish", "error", err, "id", retainedKey(filter))
+		h.ReportError(nil, mqtt.ErrorSubsystemStorage, err)
 	}
 }
 
@@ -382,6 +469,45 @@ func (h *Hook) OnClientExpired(cl *mqtt.Client) {
 	err := h.db.DeleteStruct(&storage.Client{ID: clientKey(cl)})
 	if err != nil && !errors.Is(err, storm.ErrNotFound) {
 		h.Log.Error("failed to delete expired client", "error", err, "id", clientKey(cl))
+		h.ReportError(cl, mqtt.ErrorSubsystemStorage, err)
+	}
+}
+
+// OnBanned adds or updates a ban in the store.
+func (h *Hook) OnBanned(ban mqtt.Ban) {
+	if h.db == nil {
+		h.Log.Error("", "error", storage.ErrDBFileNotOpen)
+		return
+	}
+
+	in := &storage.Ban{
+		ID:       banKey(ban),
+		T:        storage.BanKey,
+		ClientID: ban.ClientID,
+		IP:       ban.IP,
+		Reason:   ban.Reason,
+		Created:  ban.Created,
+		Expiry:   ban.Expiry,
+	}
+
+	err := h.db.Save(in)
+	if err != nil {
+		h.Log.Error("failed to save ban data", "error", err, "data", in)
+		h.ReportError(nil, mqtt.ErrorSubsystemStorage, err)
+	}
+}
+
+// OnUnbanned deletes a removed or expired ban from the store.
+func (h *Hook) OnUnbanned(ban mqtt.Ban) {
+	if h.db == nil {
+		h.Log.Error("", "error", storage.ErrDBFileNotOpen)
+		return
+	}
+
+	err := h.db.DeleteStruct(&storage.Ban{ID: banKey(ban)})
+	if err != nil && !errors.Is(err, storm.ErrNotFound) {
+		h.Log.Error("failed to delete ban data", "error", err, "id", banKey(ban))
+		h.ReportError(nil, mqtt.ErrorSubsystemStorage, err)
 	}
 }
 
@@ -392,11 +518,21 @@ func (h *Hook) StoredClients() (v []storage.Client, err error) {
 		return
 	}
 
-	err = h.db.Find("T", storage.ClientKey, &v)
+	var items []storage.Client
+	err = h.db.Find("T", storage.ClientKey, &items)
 	if err != nil && !errors.Is(err, storm.ErrNotFound) {
 		return
 	}
 
+	for _, item := range items {
+		if err := item.Decrypt(h.config.Cipher); err != nil {
+			h.Log.Error("failed to decrypt data", "error", err, "key", item.ID)
+			continue
+		}
+
+		v = append(v, item)
+	}
+
 	return v, nil
 }
 
@@ -422,11 +558,21 @@ func (h *Hook) StoredRetainedMessages() (v []storage.Message, err error) {
 		return
 	}
 
-	err = h.db.Find("T", storage.RetainedKey, &v)
+	var items []storage.Message
+	err = h.db.Find("T", storage.RetainedKey, &items)
 	if err != nil && !errors.Is(err, storm.ErrNotFound) {
 		return
 	}
 
+	for _, item := range items {
+		if err := item.Decrypt(h.config.Cipher); err != nil {
+			h.Log.Error("failed to decrypt data", "error", err, "key", item.ID)
+			continue
+		}
+
+		v = append(v, item)
+	}
+
 	return v, nil
 }
 
@@ -437,11 +583,21 @@ func (h *Hook) StoredInflightMessages() (v []storage.Message, err error) {
 		return
 	}
 
-	err = h.db.Find("T", storage.InflightKey, &v)
+	var items []storage.Message
+	err = h.db.Find("T", storage.InflightKey, &items)
 	if err != nil && !errors.Is(err, storm.ErrNotFound) {
 		return
 	}
 
+	for _, item := range items {
+		if err := item.Decrypt(h.config.Cipher); err != nil {
+			h.Log.Error("failed to decrypt data", "error", err, "key", item.ID)
+			continue
+		}
+
+		v = append(v, item)
+	}
+
 	return v, nil
 }
 
@@ -459,3 +615,18 @@ func (h *Hook) StoredSysInfo() (v storage.SystemInfo, err error) {
 
 	return v, nil
 }
+
+// StoredBans returns all stored bans from the store.
+func (h *Hook) StoredBans() (v []storage.Ban, err error) {
+	if h.db == nil {
+		h.Log.Error("", "error", storage.ErrDBFileNotOpen)
+		return
+	}
+
+	err = h.db.Find("T", storage.BanKey, &v)
+	if err != nil && !errors.Is(err, storm.ErrNotFound) {
+		return
+	}
+
+	return v, nil
+}
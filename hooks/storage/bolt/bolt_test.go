@@ -67,6 +67,11 @@ func TestSysInfoKey(t *testing.T) {
 	require.Equal(t, storage.SysInfoKey, sysInfoKey())
 }
 
+func TestBanKey(t *testing.T) {
+	k := banKey(mqtt.Ban{ClientID: "mochi", IP: "127.0.0.1"})
+	require.Equal(t, storage.BanKey+"_mochi@127.0.0.1", k)
+}
+
 func TestID(t *testing.T) {
 	h := new(Hook)
 	require.Equal(t, "bolt-db", h.ID())
@@ -88,10 +93,28 @@ func TestProvides(t *testing.T) {
 	require.True(t, h.Provides(mqtt.StoredRetainedMessages))
 	require.True(t, h.Provides(mqtt.StoredSubscriptions))
 	require.True(t, h.Provides(mqtt.StoredSysInfo))
+	require.True(t, h.Provides(mqtt.OnBanned))
+	require.True(t, h.Provides(mqtt.OnUnbanned))
+	require.True(t, h.Provides(mqtt.StoredBans))
 	require.False(t, h.Provides(mqtt.OnACLCheck))
 	require.False(t, h.Provides(mqtt.OnConnectAuthenticate))
 }
 
+func TestProvidesSubscriptionsOnly(t *testing.T) {
+	h := new(Hook)
+	h.config = &Options{SubscriptionsOnly: true}
+	require.True(t, h.Provides(mqtt.OnSessionEstablished))
+	require.True(t, h.Provides(mqtt.OnSubscribed))
+	require.True(t, h.Provides(mqtt.OnUnsubscribed))
+	require.True(t, h.Provides(mqtt.OnRetainMessage))
+	require.True(t, h.Provides(mqtt.StoredClients))
+	require.True(t, h.Provides(mqtt.StoredSubscriptions))
+	require.False(t, h.Provides(mqtt.OnQosPublish))
+	require.False(t, h.Provides(mqtt.OnQosComplete))
+	require.False(t, h.Provides(mqtt.OnQosDropped))
+	require.False(t, h.Provides(mqtt.StoredInflightMessages))
+}
+
 func TestInitBadConfig(t *testing.T) {
 	h := new(Hook)
 	h.SetOpts(logger, nil)
@@ -111,6 +134,25 @@ func TestInitUseDefaults(t *testing.T) {
 	require.Equal(t, defaultDbFile, h.config.Path)
 }
 
+func TestInitUnsupportedVersion(t *testing.T) {
+	h := new(Hook)
+	h.SetOpts(logger, nil)
+	err := h.Init(nil)
+	require.NoError(t, err)
+	defer teardown(t, h.config.Path, h)
+
+	ver := storage.StoreVersion{}
+	require.NoError(t, h.db.One("ID", storage.VersionKey, &ver))
+	require.Equal(t, storage.Version, ver.Version)
+
+	ver.Version = storage.Version + 1
+	require.NoError(t, h.db.Save(&ver))
+	require.NoError(t, h.Stop())
+
+	err = h.Init(nil)
+	require.ErrorIs(t, err, storage.ErrUnsupportedVersion)
+}
+
 func TestInitBadPath(t *testing.T) {
 	h := new(Hook)
 	h.SetOpts(logger, nil)
@@ -316,6 +358,47 @@ func TestOnUnsubscribedClosedDB(t *testing.T) {
 	h.OnUnsubscribed(client, pkf)
 }
 
+func TestInitBadEncryptionKey(t *testing.T) {
+	h := new(Hook)
+	h.SetOpts(logger, nil)
+	err := h.Init(&Options{
+		EncryptionKey: "abc",
+	})
+	require.ErrorIs(t, err, storage.ErrInvalidKey)
+}
+
+func TestOnRetainMessageEncrypted(t *testing.T) {
+	h := new(Hook)
+	h.SetOpts(logger, nil)
+	err := h.Init(&Options{
+		EncryptionKey: "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f",
+	})
+	require.NoError(t, err)
+	defer teardown(t, h.config.Path, h)
+
+	pk := packets.Packet{
+		FixedHeader: packets.FixedHeader{
+			Retain: true,
+		},
+		Payload:   []byte("hello"),
+		TopicName: "a/b/c",
+	}
+
+	h.OnRetainMessage(client, pk, 1)
+
+	r := new(storage.Message)
+	err = h.db.One("ID", retainedKey(pk.TopicName), r)
+	require.NoError(t, err)
+	require.True(t, r.Encrypted)
+	require.NotEqual(t, pk.Payload, r.Payload)
+
+	msgs, err := h.StoredRetainedMessages()
+	require.NoError(t, err)
+	require.Len(t, msgs, 1)
+	require.Equal(t, pk.Payload, msgs[0].Payload)
+	require.False(t, msgs[0].Encrypted)
+}
+
 func TestOnRetainMessageThenUnset(t *testing.T) {
 	h := new(Hook)
 	h.SetOpts(logger, nil)
@@ -728,6 +811,52 @@ func TestStoredSysInfoNoDB(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestOnBannedThenOnUnbanned(t *testing.T) {
+	h := new(Hook)
+	h.SetOpts(logger, nil)
+	err := h.Init(nil)
+	require.NoError(t, err)
+	defer teardown(t, h.config.Path, h)
+
+	ban := mqtt.Ban{ClientID: "mochi", IP: "127.0.0.1", Reason: "test", Created: 1, Expiry: 2}
+	h.OnBanned(ban)
+
+	r, err := h.StoredBans()
+	require.NoError(t, err)
+	require.Len(t, r, 1)
+	require.Equal(t, banKey(ban), r[0].ID)
+	require.Equal(t, storage.BanKey, r[0].T)
+	require.Equal(t, ban.ClientID, r[0].ClientID)
+	require.Equal(t, ban.IP, r[0].IP)
+	require.Equal(t, ban.Reason, r[0].Reason)
+	require.Equal(t, ban.Expiry, r[0].Expiry)
+
+	h.OnUnbanned(ban)
+	r, err = h.StoredBans()
+	require.NoError(t, err)
+	require.Len(t, r, 0)
+}
+
+func TestOnBannedNoDB(t *testing.T) {
+	h := new(Hook)
+	h.SetOpts(logger, nil)
+	h.OnBanned(mqtt.Ban{ClientID: "mochi"})
+}
+
+func TestOnUnbannedNoDB(t *testing.T) {
+	h := new(Hook)
+	h.SetOpts(logger, nil)
+	h.OnUnbanned(mqtt.Ban{ClientID: "mochi"})
+}
+
+func TestStoredBansNoDB(t *testing.T) {
+	h := new(Hook)
+	h.SetOpts(logger, nil)
+	v, err := h.StoredBans()
+	require.Empty(t, v)
+	require.NoError(t, err)
+}
+
 func TestStoredSysInfoClosedDB(t *testing.T) {
 	h := new(Hook)
 	h.SetOpts(logger, nil)
@@ -0,0 +1,171 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package storage
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	// ErrInvalidKey indicates an encryption key was not a hex encoded 16, 24, or 32 byte AES key.
+	ErrInvalidKey = errors.New("encryption key must be a hex encoded 16, 24, or 32 byte key")
+
+	// ErrNoCipher indicates an encrypted record was read from a store without a cipher to decrypt it.
+	ErrNoCipher = errors.New("record is encrypted but no cipher is configured")
+
+	// ErrCiphertextTooShort indicates encrypted data was too short to contain a nonce.
+	ErrCiphertextTooShort = errors.New("ciphertext too short")
+)
+
+// Cipher encrypts and decrypts the payloads and credentials of stored records. A Cipher
+// can be implemented to use keys held by a key management service.
+type Cipher interface {
+	Encrypt(plaintext []byte) ([]byte, error)
+	Decrypt(ciphertext []byte) ([]byte, error)
+}
+
+// AESCipher is a Cipher which seals data with AES-GCM, prefixing each ciphertext with
+// a random nonce.
+type AESCipher struct {
+	aead cipher.AEAD
+}
+
+// NewAESCipher returns an AES-GCM cipher for a 16, 24, or 32 byte key, selecting
+// AES-128, AES-192, or AES-256.
+func NewAESCipher(key []byte) (*AESCipher, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AESCipher{aead: aead}, nil
+}
+
+// NewAESCipherFromHex returns an AES-GCM cipher for a hex encoded key, such as a key
+// read from a config file.
+func NewAESCipherFromHex(key string) (*AESCipher, error) {
+	b, err := hex.DecodeString(key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+	}
+
+	return NewAESCipher(b)
+}
+
+// Encrypt seals plaintext, returning the nonce followed by the ciphertext.
+func (c *AESCipher) Encrypt(plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, c.aead.NonceSize(), c.aead.NonceSize()+len(plaintext)+c.aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return c.aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// Decrypt opens data sealed by Encrypt.
+func (c *AESCipher) Decrypt(ciphertext []byte) ([]byte, error) {
+	n := c.aead.NonceSize()
+	if len(ciphertext) < n {
+		return nil, ErrCiphertextTooShort
+	}
+
+	return c.aead.Open(nil, ciphertext[:n], ciphertext[n:], nil)
+}
+
+// crypt applies fn to each non-empty field, replacing its value with the result.
+func crypt(fn func([]byte) ([]byte, error), fields ...*[]byte) error {
+	for _, f := range fields {
+		if len(*f) == 0 {
+			continue
+		}
+
+		b, err := fn(*f)
+		if err != nil {
+			return err
+		}
+		*f = b
+	}
+
+	return nil
+}
+
+// Encrypt encrypts the username, authentication data, and will payload of the client
+// with c. The client is left unchanged if c is nil.
+func (d *Client) Encrypt(c Cipher) error {
+	if c == nil || d.Encrypted {
+		return nil
+	}
+
+	if err := crypt(c.Encrypt, &d.Username, &d.Properties.AuthenticationData, &d.Will.Payload); err != nil {
+		return err
+	}
+
+	d.Encrypted = true
+	return nil
+}
+
+// Decrypt decrypts the username, authentication data, and will payload of a client
+// which was encrypted with Encrypt.
+func (d *Client) Decrypt(c Cipher) error {
+	if !d.Encrypted {
+		return nil
+	}
+
+	if c == nil {
+		return ErrNoCipher
+	}
+
+	if err := crypt(c.Decrypt, &d.Username, &d.Properties.AuthenticationData, &d.Will.Payload); err != nil {
+		return err
+	}
+
+	d.Encrypted = false
+	return nil
+}
+
+// Encrypt encrypts the payload and correlation data of the message with c. The message
+// is left unchanged if c is nil.
+func (d *Message) Encrypt(c Cipher) error {
+	if c == nil || d.Encrypted {
+		return nil
+	}
+
+	if err := crypt(c.Encrypt, &d.Payload, &d.Properties.CorrelationData); err != nil {
+		return err
+	}
+
+	d.Encrypted = true
+	return nil
+}
+
+// Decrypt decrypts the payload and correlation data of a message which was encrypted
+// with Encrypt.
+func (d *Message) Decrypt(c Cipher) error {
+	if !d.Encrypted {
+		return nil
+	}
+
+	if c == nil {
+		return ErrNoCipher
+	}
+
+	if err := crypt(c.Decrypt, &d.Payload, &d.Properties.CorrelationData); err != nil {
+		return err
+	}
+
+	d.Encrypted = false
+	return nil
+}
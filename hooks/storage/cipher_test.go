@@ -0,0 +1,133 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package storage
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testKey = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+
+func newTestCipher(t *testing.T) *AESCipher {
+	c, err := NewAESCipherFromHex(testKey)
+	require.NoError(t, err)
+	return c
+}
+
+type failCipher struct{}
+
+func (failCipher) Encrypt(b []byte) ([]byte, error) { return nil, errors.New("test") }
+func (failCipher) Decrypt(b []byte) ([]byte, error) { return nil, errors.New("test") }
+
+func TestNewAESCipherInvalidKey(t *testing.T) {
+	_, err := NewAESCipher([]byte("short"))
+	require.ErrorIs(t, err, ErrInvalidKey)
+
+	_, err = NewAESCipherFromHex("not hex")
+	require.ErrorIs(t, err, ErrInvalidKey)
+}
+
+func TestAESCipherEncryptDecrypt(t *testing.T) {
+	c := newTestCipher(t)
+
+	a, err := c.Encrypt([]byte("mochi"))
+	require.NoError(t, err)
+	require.NotContains(t, string(a), "mochi")
+
+	b, err := c.Encrypt([]byte("mochi"))
+	require.NoError(t, err)
+	require.NotEqual(t, a, b) // unique nonce
+
+	d, err := c.Decrypt(a)
+	require.NoError(t, err)
+	require.Equal(t, []byte("mochi"), d)
+}
+
+func TestAESCipherDecryptInvalid(t *testing.T) {
+	c := newTestCipher(t)
+
+	_, err := c.Decrypt([]byte{1, 2})
+	require.ErrorIs(t, err, ErrCiphertextTooShort)
+
+	a, err := c.Encrypt([]byte("mochi"))
+	require.NoError(t, err)
+	a[len(a)-1] ^= 0xff
+
+	_, err = c.Decrypt(a)
+	require.Error(t, err)
+}
+
+func TestClientEncryptDecrypt(t *testing.T) {
+	c := newTestCipher(t)
+
+	d := clientStruct
+	d.Username = []byte("mochi")
+	d.Properties.AuthenticationData = []byte("secret")
+	d.Will.Payload = []byte("gone")
+	require.NoError(t, d.Encrypt(c))
+	require.True(t, d.Encrypted)
+	require.NotEqual(t, []byte("mochi"), d.Username)
+	require.NotEqual(t, []byte("secret"), d.Properties.AuthenticationData)
+	require.NotEqual(t, []byte("gone"), d.Will.Payload)
+
+	data, err := d.MarshalBinary()
+	require.NoError(t, err)
+
+	e := Client{}
+	require.NoError(t, e.UnmarshalBinary(data))
+	require.ErrorIs(t, e.Decrypt(nil), ErrNoCipher)
+	require.NoError(t, e.Decrypt(c))
+	require.False(t, e.Encrypted)
+	require.Equal(t, []byte("mochi"), e.Username)
+	require.Equal(t, []byte("secret"), e.Properties.AuthenticationData)
+	require.Equal(t, []byte("gone"), e.Will.Payload)
+}
+
+func TestClientEncryptNoCipher(t *testing.T) {
+	d := clientStruct
+	require.NoError(t, d.Encrypt(nil))
+	require.Equal(t, clientStruct, d)
+	require.NoError(t, d.Decrypt(nil))
+}
+
+func TestClientEncryptError(t *testing.T) {
+	d := clientStruct
+	require.Error(t, d.Encrypt(failCipher{}))
+	require.False(t, d.Encrypted)
+
+	d.Encrypted = true
+	require.Error(t, d.Decrypt(failCipher{}))
+}
+
+func TestMessageEncryptDecrypt(t *testing.T) {
+	c := newTestCipher(t)
+
+	d := messageStruct
+	d.Payload = []byte("payload")
+	d.Properties.CorrelationData = []byte("correlation")
+	require.NoError(t, d.Encrypt(c))
+	require.True(t, d.Encrypted)
+	require.NotEqual(t, []byte("payload"), d.Payload)
+	require.NotEqual(t, []byte("correlation"), d.Properties.CorrelationData)
+
+	require.ErrorIs(t, d.Decrypt(nil), ErrNoCipher)
+	require.NoError(t, d.Decrypt(c))
+	require.False(t, d.Encrypted)
+	require.Equal(t, []byte("payload"), d.Payload)
+	require.Equal(t, []byte("correlation"), d.Properties.CorrelationData)
+}
+
+func TestMessageEncryptError(t *testing.T) {
+	d := messageStruct
+	d.Payload = []byte("payload")
+	require.Error(t, d.Encrypt(failCipher{}))
+	require.False(t, d.Encrypted)
+
+	d.Encrypted = true
+	require.Error(t, d.Decrypt(failCipher{}))
+}
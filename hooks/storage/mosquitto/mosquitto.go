@@ -0,0 +1,340 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+// Package mosquitto reads Mosquitto persistence files (mosquitto.db) so their
+// sessions, subscriptions, and retained messages can be migrated into a storage hook.
+package mosquitto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/storage"
+	"github.com/mochi-mqtt/server/v2/packets"
+)
+
+// DBVersion is the Mosquitto persistence file version which can be read, as
+// written by Mosquitto 2.x.
+const DBVersion = 6
+
+const (
+	chunkCfg       = 1 // DB_CHUNK_CFG
+	chunkMsgStore  = 2 // DB_CHUNK_MSG_STORE
+	chunkClientMsg = 3 // DB_CHUNK_CLIENT_MSG
+	chunkRetain    = 4 // DB_CHUNK_RETAIN
+	chunkSub       = 5 // DB_CHUNK_SUB
+	chunkClient    = 6 // DB_CHUNK_CLIENT
+
+	sizeCfg      = 16 // sizeof(struct PF_cfg)
+	sizeMsgStore = 32 // sizeof(struct PF_msg_store)
+	sizeRetain   = 8  // sizeof(struct PF_retain)
+	sizeSub      = 12 // sizeof(struct PF_sub), including padding
+	sizeClient   = 24 // sizeof(struct PF_client), including padding
+
+	sessionExpiryNever = math.MaxUint32 // the session expiry interval used by mosquitto for MQTT v3 persistent sessions
+)
+
+var (
+	// magic is the header which begins every mosquitto.db file.
+	magic = []byte{0x00, 0xB5, 0x00, 'm', 'o', 's', 'q', 'u', 'i', 't', 't', 'o', ' ', 'd', 'b'}
+
+	// hostOrder is the byte order of the fields mosquitto writes without conversion.
+	// Files are not portable between architectures of differing endianness.
+	hostOrder = binary.LittleEndian
+
+	ErrInvalidFile        = errors.New("not a mosquitto persistence file")          // the magic header did not match
+	ErrUnsupportedVersion = errors.New("unsupported mosquitto persistence version") // the file version cannot be read
+	ErrMalformedChunk     = errors.New("malformed mosquitto persistence chunk")     // a chunk was shorter than its fields
+)
+
+// DB contains the state read from a mosquitto.db file, converted to storage types.
+type DB struct {
+	Clients       []storage.Client       // persistent client sessions
+	Subscriptions []storage.Subscription // subscriptions of the persistent sessions
+	Retained      []storage.Message      // retained messages
+}
+
+// Read reads a mosquitto.db persistence file from r. Queued and inflight client
+// messages are not imported, and expired retained messages are discarded.
+func Read(r io.Reader) (*DB, error) {
+	header := make([]byte, len(magic)+8)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidFile, err)
+	}
+
+	if !bytes.Equal(header[:len(magic)], magic) {
+		return nil, ErrInvalidFile
+	}
+
+	if v := binary.BigEndian.Uint32(header[len(magic)+4:]); v != DBVersion {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, v)
+	}
+
+	db := new(DB)
+	stored := map[uint64]storage.Message{}
+	var retained []uint64
+
+	now := time.Now().Unix()
+	ch := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, ch); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrMalformedChunk, err)
+		}
+
+		chunk := binary.BigEndian.Uint32(ch)
+		b := make([]byte, binary.BigEndian.Uint32(ch[4:]))
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrMalformedChunk, err)
+		}
+
+		var err error
+		switch chunk {
+		case chunkCfg:
+			err = readCfg(b)
+		case chunkMsgStore:
+			var id uint64
+			var msg storage.Message
+			id, msg, err = readMsgStore(b, now)
+			if err == nil {
+				stored[id] = msg
+			}
+		case chunkRetain:
+			if len(b) < sizeRetain {
+				err = ErrMalformedChunk
+				break
+			}
+			retained = append(retained, hostOrder.Uint64(b))
+		case chunkSub:
+			var sub storage.Subscription
+			sub, err = readSub(b)
+			if err == nil {
+				db.Subscriptions = append(db.Subscriptions, sub)
+			}
+		case chunkClient:
+			var cl storage.Client
+			cl, err = readClient(b)
+			if err == nil {
+				db.Clients = append(db.Clients, cl)
+			}
+		case chunkClientMsg:
+			// queued and inflight messages are not migrated.
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, id := range retained {
+		msg, ok := stored[id]
+		if !ok || msg.Created < 0 {
+			continue // the message was missing or has expired
+		}
+
+		msg.ID = storage.RetainedKey + "_" + msg.TopicName
+		msg.T = storage.RetainedKey
+		msg.FixedHeader.Retain = true
+		msg.Created = now
+		db.Retained = append(db.Retained, msg)
+	}
+
+	return db, nil
+}
+
+// Import writes the sessions, subscriptions, and retained messages of db into a
+// storage hook using the same hook methods the server uses to persist them.
+func Import(db *DB, h mqtt.Hook) {
+	clients := map[string]*mqtt.Client{}
+	for _, c := range db.Clients {
+		cl := &mqtt.Client{ID: c.ID}
+		cl.Net.Listener = c.Listener
+		cl.Properties.Username = c.Username
+		cl.Properties.Clean = c.Clean
+		cl.Properties.ProtocolVersion = c.ProtocolVersion
+		cl.Properties.Props.SessionExpiryInterval = c.Properties.SessionExpiryInterval
+		cl.Properties.Props.SessionExpiryIntervalFlag = c.Properties.SessionExpiryIntervalFlag
+		clients[c.ID] = cl
+
+		if h.Provides(mqtt.OnSessionEstablished) {
+			h.OnSessionEstablished(cl, packets.Packet{})
+		}
+	}
+
+	if h.Provides(mqtt.OnSubscribed) {
+		for _, sub := range db.Subscriptions {
+			cl, ok := clients[sub.Client]
+			if !ok {
+				cl = &mqtt.Client{ID: sub.Client}
+			}
+
+			h.OnSubscribed(cl, packets.Packet{
+				FixedHeader: packets.FixedHeader{Type: packets.Subscribe},
+				Filters: packets.Subscriptions{{
+					Filter:            sub.Filter,
+					Identifier:        sub.Identifier,
+					NoLocal:           sub.NoLocal,
+					RetainHandling:    sub.RetainHandling,
+					RetainAsPublished: sub.RetainAsPublished,
+					Qos:               sub.Qos,
+				}},
+			}, []byte{sub.Qos})
+		}
+	}
+
+	if h.Provides(mqtt.OnRetainMessage) {
+		cl := &mqtt.Client{ID: "mosquitto"}
+		for _, msg := range db.Retained {
+			h.OnRetainMessage(cl, msg.ToPacket(), 1)
+		}
+	}
+}
+
+// readCfg validates a DB_CHUNK_CFG chunk.
+func readCfg(b []byte) error {
+	if len(b) < sizeCfg {
+		return ErrMalformedChunk
+	}
+
+	if size := b[9]; size != 8 {
+		return fmt.Errorf("%w: unsupported db id size %d", ErrUnsupportedVersion, size)
+	}
+
+	return nil
+}
+
+// readMsgStore reads a DB_CHUNK_MSG_STORE chunk, returning the store id and message.
+// The created time of an expired message is set to -1.
+func readMsgStore(b []byte, now int64) (uint64, storage.Message, error) {
+	if len(b) < sizeMsgStore {
+		return 0, storage.Message{}, ErrMalformedChunk
+	}
+
+	id := hostOrder.Uint64(b)
+	expiry := int64(hostOrder.Uint64(b[8:]))
+	payloadLen := int(binary.BigEndian.Uint32(b[16:]))
+	sourceIDLen := int(binary.BigEndian.Uint16(b[22:]))
+	usernameLen := int(binary.BigEndian.Uint16(b[24:]))
+	topicLen := int(binary.BigEndian.Uint16(b[26:]))
+	qos := b[30]
+
+	rest := b[sizeMsgStore:]
+	if len(rest) < sourceIDLen+usernameLen+topicLen+payloadLen {
+		return 0, storage.Message{}, ErrMalformedChunk
+	}
+
+	origin := string(rest[:sourceIDLen])
+	rest = rest[sourceIDLen+usernameLen:]
+	topic := string(rest[:topicLen])
+	payload := append([]byte{}, rest[topicLen:topicLen+payloadLen]...)
+	rest = rest[topicLen+payloadLen:]
+
+	var props packets.Properties
+	if len(rest) > 0 {
+		if _, err := props.Decode(packets.Publish, bytes.NewBuffer(rest)); err != nil {
+			return 0, storage.Message{}, fmt.Errorf("%w: %v", ErrMalformedChunk, err)
+		}
+	}
+
+	msg := storage.Message{
+		FixedHeader: packets.FixedHeader{Type: packets.Publish, Qos: qos},
+		TopicName:   topic,
+		Payload:     payload,
+		Origin:      origin,
+		Properties: storage.MessageProperties{
+			PayloadFormat:     props.PayloadFormat,
+			PayloadFormatFlag: props.PayloadFormatFlag,
+			ContentType:       props.ContentType,
+			ResponseTopic:     props.ResponseTopic,
+			CorrelationData:   props.CorrelationData,
+			User:              props.User,
+		},
+	}
+
+	if expiry > 0 {
+		if expiry <= now {
+			msg.Created = -1
+		} else {
+			msg.Properties.MessageExpiryInterval = uint32(expiry - now)
+		}
+	}
+
+	return id, msg, nil
+}
+
+// readSub reads a DB_CHUNK_SUB chunk.
+func readSub(b []byte) (storage.Subscription, error) {
+	if len(b) < sizeSub {
+		return storage.Subscription{}, ErrMalformedChunk
+	}
+
+	identifier := int(binary.BigEndian.Uint32(b))
+	idLen := int(binary.BigEndian.Uint16(b[4:]))
+	topicLen := int(binary.BigEndian.Uint16(b[6:]))
+	qos := b[8]
+	options := b[9]
+
+	rest := b[sizeSub:]
+	if len(rest) < idLen+topicLen {
+		return storage.Subscription{}, ErrMalformedChunk
+	}
+
+	client := string(rest[:idLen])
+	filter := string(rest[idLen : idLen+topicLen])
+	return storage.Subscription{
+		ID:                storage.SubscriptionKey + "_" + client + ":" + filter,
+		T:                 storage.SubscriptionKey,
+		Client:            client,
+		Filter:            filter,
+		Identifier:        identifier,
+		Qos:               qos,
+		NoLocal:           options&0x04 > 0,
+		RetainAsPublished: options&0x08 > 0,
+		RetainHandling:    (options & 0x30) >> 4,
+	}, nil
+}
+
+// readClient reads a DB_CHUNK_CLIENT chunk.
+func readClient(b []byte) (storage.Client, error) {
+	if len(b) < sizeClient {
+		return storage.Client{}, ErrMalformedChunk
+	}
+
+	interval := binary.BigEndian.Uint32(b[8:])
+	idLen := int(binary.BigEndian.Uint16(b[14:]))
+	usernameLen := int(binary.BigEndian.Uint16(b[18:]))
+
+	rest := b[sizeClient:]
+	if len(rest) < idLen+usernameLen {
+		return storage.Client{}, ErrMalformedChunk
+	}
+
+	cl := storage.Client{
+		ID: string(rest[:idLen]),
+		T:  storage.ClientKey,
+	}
+
+	if usernameLen > 0 {
+		cl.Username = append([]byte{}, rest[idLen:idLen+usernameLen]...)
+	}
+
+	// mosquitto only persists sessions which were not clean, and records MQTT v3
+	// sessions as never expiring.
+	if interval == sessionExpiryNever {
+		cl.ProtocolVersion = 4
+	} else {
+		cl.ProtocolVersion = 5
+		cl.Properties.SessionExpiryInterval = interval
+		cl.Properties.SessionExpiryIntervalFlag = true
+	}
+
+	return cl, nil
+}
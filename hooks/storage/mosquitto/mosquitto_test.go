@@ -0,0 +1,198 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package mosquitto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/packets"
+
+	"github.com/stretchr/testify/require"
+)
+
+// dbWriter builds mosquitto.db files in the layout written by mosquitto 2.x.
+type dbWriter struct {
+	bytes.Buffer
+}
+
+func newDBWriter(version uint32) *dbWriter {
+	w := new(dbWriter)
+	w.Write(magic)
+	_ = binary.Write(w, binary.BigEndian, uint32(0))
+	_ = binary.Write(w, binary.BigEndian, version)
+	return w
+}
+
+func (w *dbWriter) chunk(t uint32, b []byte) {
+	_ = binary.Write(w, binary.BigEndian, t)
+	_ = binary.Write(w, binary.BigEndian, uint32(len(b)))
+	w.Write(b)
+}
+
+func (w *dbWriter) cfg() {
+	b := make([]byte, sizeCfg)
+	hostOrder.PutUint64(b, 10)
+	b[9] = 8
+	w.chunk(chunkCfg, b)
+}
+
+func (w *dbWriter) msgStore(id uint64, expiry int64, source, topic string, payload []byte, qos byte, props []byte) {
+	b := make([]byte, sizeMsgStore)
+	hostOrder.PutUint64(b, id)
+	hostOrder.PutUint64(b[8:], uint64(expiry))
+	binary.BigEndian.PutUint32(b[16:], uint32(len(payload)))
+	binary.BigEndian.PutUint16(b[22:], uint16(len(source)))
+	binary.BigEndian.PutUint16(b[26:], uint16(len(topic)))
+	b[30] = qos
+	b = append(b, source...)
+	b = append(b, topic...)
+	b = append(b, payload...)
+	b = append(b, props...)
+	w.chunk(chunkMsgStore, b)
+}
+
+func (w *dbWriter) retain(id uint64) {
+	b := make([]byte, sizeRetain)
+	hostOrder.PutUint64(b, id)
+	w.chunk(chunkRetain, b)
+}
+
+func (w *dbWriter) client(id, username string, interval uint32) {
+	b := make([]byte, sizeClient)
+	binary.BigEndian.PutUint32(b[8:], interval)
+	binary.BigEndian.PutUint16(b[14:], uint16(len(id)))
+	binary.BigEndian.PutUint16(b[18:], uint16(len(username)))
+	b = append(b, id...)
+	b = append(b, username...)
+	w.chunk(chunkClient, b)
+}
+
+func (w *dbWriter) sub(client, filter string, qos, options byte, identifier uint32) {
+	b := make([]byte, sizeSub)
+	binary.BigEndian.PutUint32(b, identifier)
+	binary.BigEndian.PutUint16(b[4:], uint16(len(client)))
+	binary.BigEndian.PutUint16(b[6:], uint16(len(filter)))
+	b[8] = qos
+	b[9] = options
+	b = append(b, client...)
+	b = append(b, filter...)
+	w.chunk(chunkSub, b)
+}
+
+func testDB() *dbWriter {
+	w := newDBWriter(DBVersion)
+	w.cfg()
+	w.msgStore(1, 0, "mochi", "a/b/c", []byte("hello"), 1, nil)
+	w.msgStore(2, time.Now().Unix()-10, "mochi", "d/e/f", []byte("expired"), 0, nil)
+	w.msgStore(3, 0, "zen", "g/h/i", []byte("props"), 0, []byte{5, 3, 0, 2, 'a', 'b'}) // content type "ab"
+	w.retain(1)
+	w.retain(2)
+	w.retain(3)
+	w.client("mochi", "mochi-user", sessionExpiryNever)
+	w.client("zen", "", 3600)
+	w.sub("mochi", "a/#", 1, 0x04|0x08|0x20, 0)
+	w.sub("zen", "g/+/i", 2, 0, 7)
+	w.chunk(chunkClientMsg, make([]byte, 16))
+	return w
+}
+
+func TestRead(t *testing.T) {
+	db, err := Read(testDB())
+	require.NoError(t, err)
+
+	require.Len(t, db.Retained, 2)
+	require.Equal(t, "a/b/c", db.Retained[0].TopicName)
+	require.Equal(t, []byte("hello"), db.Retained[0].Payload)
+	require.Equal(t, "mochi", db.Retained[0].Origin)
+	require.Equal(t, byte(1), db.Retained[0].FixedHeader.Qos)
+	require.True(t, db.Retained[0].FixedHeader.Retain)
+	require.Equal(t, "ab", db.Retained[1].Properties.ContentType)
+
+	require.Len(t, db.Clients, 2)
+	require.Equal(t, "mochi", db.Clients[0].ID)
+	require.Equal(t, []byte("mochi-user"), db.Clients[0].Username)
+	require.Equal(t, byte(4), db.Clients[0].ProtocolVersion)
+	require.Equal(t, byte(5), db.Clients[1].ProtocolVersion)
+	require.Equal(t, uint32(3600), db.Clients[1].Properties.SessionExpiryInterval)
+
+	require.Len(t, db.Subscriptions, 2)
+	require.Equal(t, "mochi", db.Subscriptions[0].Client)
+	require.Equal(t, "a/#", db.Subscriptions[0].Filter)
+	require.True(t, db.Subscriptions[0].NoLocal)
+	require.True(t, db.Subscriptions[0].RetainAsPublished)
+	require.Equal(t, byte(2), db.Subscriptions[0].RetainHandling)
+	require.Equal(t, 7, db.Subscriptions[1].Identifier)
+	require.Equal(t, byte(2), db.Subscriptions[1].Qos)
+}
+
+func TestReadInvalidFile(t *testing.T) {
+	_, err := Read(bytes.NewReader([]byte("not a db")))
+	require.ErrorIs(t, err, ErrInvalidFile)
+
+	_, err = Read(bytes.NewReader(make([]byte, 32)))
+	require.ErrorIs(t, err, ErrInvalidFile)
+}
+
+func TestReadUnsupportedVersion(t *testing.T) {
+	_, err := Read(newDBWriter(4))
+	require.ErrorIs(t, err, ErrUnsupportedVersion)
+}
+
+func TestReadMalformedChunk(t *testing.T) {
+	w := newDBWriter(DBVersion)
+	w.chunk(chunkClient, []byte{1, 2, 3})
+	_, err := Read(w)
+	require.ErrorIs(t, err, ErrMalformedChunk)
+
+	w = newDBWriter(DBVersion)
+	_ = binary.Write(w, binary.BigEndian, uint32(chunkRetain))
+	_ = binary.Write(w, binary.BigEndian, uint32(100))
+	_, err = Read(w)
+	require.ErrorIs(t, err, ErrMalformedChunk)
+}
+
+// recorderHook records the data written by Import.
+type recorderHook struct {
+	mqtt.HookBase
+	clients  []string
+	subs     []string
+	retained []packets.Packet
+}
+
+func (h *recorderHook) Provides(b byte) bool {
+	return bytes.Contains([]byte{
+		mqtt.OnSessionEstablished,
+		mqtt.OnSubscribed,
+		mqtt.OnRetainMessage,
+	}, []byte{b})
+}
+
+func (h *recorderHook) OnSessionEstablished(cl *mqtt.Client, pk packets.Packet) {
+	h.clients = append(h.clients, cl.ID)
+}
+
+func (h *recorderHook) OnSubscribed(cl *mqtt.Client, pk packets.Packet, reasonCodes []byte) {
+	h.subs = append(h.subs, cl.ID+":"+pk.Filters[0].Filter)
+}
+
+func (h *recorderHook) OnRetainMessage(cl *mqtt.Client, pk packets.Packet, r int64) {
+	h.retained = append(h.retained, pk)
+}
+
+func TestImport(t *testing.T) {
+	db, err := Read(testDB())
+	require.NoError(t, err)
+
+	h := new(recorderHook)
+	Import(db, h)
+	require.Equal(t, []string{"mochi", "zen"}, h.clients)
+	require.Equal(t, []string{"mochi:a/#", "zen:g/+/i"}, h.subs)
+	require.Len(t, h.retained, 2)
+	require.Equal(t, "a/b/c", h.retained[0].TopicName)
+}
@@ -47,6 +47,11 @@ func sysInfoKey() string {
 	return storage.SysInfoKey
 }
 
+// banKey returns a primary key for a ban.
+func banKey(ban mqtt.Ban) string {
+	return storage.BanKey + "_" + ban.Key()
+}
+
 // keyUpperBound returns the upper bound for a given byte slice by incrementing the last byte.
 // It returns nil if all bytes are incremented and equal to 0.
 func keyUpperBound(b []byte) []byte {
@@ -71,6 +76,15 @@ type Options struct {
 	Options *pebbledb.Options
 	Mode    string `yaml:"mode" json:"mode"`
 	Path    string `yaml:"path" json:"path"`
+	// EncryptionKey is a hex encoded 16, 24, or 32 byte AES key used to encrypt stored
+	// payloads and credentials, if no Cipher is set.
+	EncryptionKey string `yaml:"encryption_key" json:"encryption_key"`
+	// Cipher encrypts stored payloads and credentials, eg. using a key management service.
+	Cipher storage.Cipher `yaml:"-" json:"-"`
+	// SubscriptionsOnly stores clients and their subscriptions, retained messages, and bans,
+	// but not inflight or queued messages, so clients are resubscribed after a restart
+	// without the write load of persisting every qos message.
+	SubscriptionsOnly bool `yaml:"subscriptions_only" json:"subscriptions_only"`
 }
 
 // Hook is a persistent storage hook based using pebble DB file store as a backend.
@@ -88,6 +102,13 @@ func (h *Hook) ID() string {
 
 // Provides indicates which hook methods this hook provides.
 func (h *Hook) Provides(b byte) bool {
+	if h.config != nil && h.config.SubscriptionsOnly {
+		switch b {
+		case mqtt.OnQosPublish, mqtt.OnQosComplete, mqtt.OnQosDropped, mqtt.StoredInflightMessages:
+			return false
+		}
+	}
+
 	return bytes.Contains([]byte{
 		mqtt.OnSessionEstablished,
 		mqtt.OnDisconnect,
@@ -101,11 +122,15 @@ func (h *Hook) Provides(b byte) bool {
 		mqtt.OnSysInfoTick,
 		mqtt.OnClientExpired,
 		mqtt.OnRetainedExpired,
+		mqtt.OnBanned,
+		mqtt.OnUnbanned,
+		mqtt.OnCompact,
 		mqtt.StoredClients,
 		mqtt.StoredInflightMessages,
 		mqtt.StoredRetainedMessages,
 		mqtt.StoredSubscriptions,
 		mqtt.StoredSysInfo,
+		mqtt.StoredBans,
 	}, []byte{b})
 }
 
@@ -125,6 +150,14 @@ func (h *Hook) Init(config any) error {
 		h.config.Path = defaultDbFile
 	}
 
+	if h.config.Cipher == nil && h.config.EncryptionKey != "" {
+		c, err := storage.NewAESCipherFromHex(h.config.EncryptionKey)
+		if err != nil {
+			return err
+		}
+		h.config.Cipher = c
+	}
+
 	if h.config.Options == nil {
 		h.config.Options = &pebbledb.Options{}
 	}
@@ -140,9 +173,63 @@ func (h *Hook) Init(config any) error {
 		return err
 	}
 
+	if err := h.migrate(); err != nil {
+		_ = h.db.Close()
+		return err
+	}
+
 	return nil
 }
 
+// migrate upgrades any records written by an older storage format version to the
+// current version, and records the current version in the store.
+func (h *Hook) migrate() error {
+	ver := storage.StoreVersion{Version: 1}
+	err := h.getKv(storage.VersionKey, &ver)
+	if err != nil && !errors.Is(err, pebbledb.ErrNotFound) {
+		return err
+	}
+
+	if ver.Version > storage.Version {
+		return fmt.Errorf("%w: %d", storage.ErrUnsupportedVersion, ver.Version)
+	}
+
+	if ver.Version == storage.Version {
+		return nil
+	}
+
+	iter, err := h.db.NewIter(nil)
+	if err != nil {
+		return err
+	}
+
+	batch := h.db.NewBatch()
+	defer batch.Close()
+
+	for iter.First(); iter.Valid(); iter.Next() {
+		data, err := storage.Migrate(ver.Version, iter.Value())
+		if err != nil {
+			_ = iter.Close()
+			return fmt.Errorf("key %s: %w", iter.Key(), err)
+		}
+
+		if !bytes.Equal(data, iter.Value()) {
+			_ = batch.Set(bytes.Clone(iter.Key()), data, nil)
+		}
+	}
+
+	if err := iter.Close(); err != nil {
+		return err
+	}
+
+	if err := batch.Commit(h.mode); err != nil {
+		return err
+	}
+
+	h.Log.Info("migrated storage format", "from", ver.Version, "to", storage.Version)
+	return h.setKv(storage.VersionKey, storage.CurrentVersion())
+}
+
 // Stop closes the pebble instance.
 func (h *Hook) Stop() error {
 	err := h.db.Close()
@@ -176,6 +263,7 @@ func (h *Hook) updateClient(cl *mqtt.Client) {
 		Username:        cl.Properties.Username,
 		Clean:           cl.Properties.Clean,
 		ProtocolVersion: cl.Properties.ProtocolVersion,
+		Created:         cl.Session().Created,
 		Properties: storage.ClientProperties{
 			SessionExpiryInterval: props.SessionExpiryInterval,
 			AuthenticationMethod:  props.AuthenticationMethod,
@@ -189,6 +277,12 @@ func (h *Hook) updateClient(cl *mqtt.Client) {
 		},
 		Will: storage.ClientWill(cl.Properties.Will),
 	}
+
+	if err := in.Encrypt(h.config.Cipher); err != nil {
+		h.Log.Error("failed to encrypt data", "error", err, "key", in.ID)
+		return
+	}
+
 	h.setKv(clientKey(cl), in)
 }
 
@@ -267,6 +361,7 @@ func (h *Hook) OnRetainMessage(cl *mqtt.Client, pk packets.Packet, r int64) {
 		FixedHeader: pk.FixedHeader,
 		TopicName:   pk.TopicName,
 		Payload:     pk.Payload,
+		Compressed:  pk.Compressed,
 		Created:     pk.Created,
 		Origin:      pk.Origin,
 		Properties: storage.MessageProperties{
@@ -281,6 +376,11 @@ func (h *Hook) OnRetainMessage(cl *mqtt.Client, pk packets.Packet, r int64) {
 		},
 	}
 
+	if err := in.Encrypt(h.config.Cipher); err != nil {
+		h.Log.Error("failed to encrypt data", "error", err, "key", in.ID)
+		return
+	}
+
 	h.setKv(in.ID, in)
 }
 
@@ -313,6 +413,12 @@ func (h *Hook) OnQosPublish(cl *mqtt.Client, pk packets.Packet, sent int64, rese
 			User:                   props.User,
 		},
 	}
+
+	if err := in.Encrypt(h.config.Cipher); err != nil {
+		h.Log.Error("failed to encrypt data", "error", err, "key", in.ID)
+		return
+	}
+
 	h.setKv(in.ID, in)
 }
 
@@ -367,6 +473,43 @@ func (h *Hook) OnClientExpired(cl *mqtt.Client) {
 	h.delKv(clientKey(cl))
 }
 
+// OnBanned adds or updates a ban in the store.
+func (h *Hook) OnBanned(ban mqtt.Ban) {
+	if h.db == nil {
+		h.Log.Error("", "error", storage.ErrDBFileNotOpen)
+		return
+	}
+
+	in := &storage.Ban{
+		ID:       banKey(ban),
+		T:        storage.BanKey,
+		ClientID: ban.ClientID,
+		IP:       ban.IP,
+		Reason:   ban.Reason,
+		Created:  ban.Created,
+		Expiry:   ban.Expiry,
+	}
+	h.setKv(in.ID, in)
+}
+
+// OnUnbanned deletes a removed or expired ban from the store.
+func (h *Hook) OnUnbanned(ban mqtt.Ban) {
+	if h.db == nil {
+		h.Log.Error("", "error", storage.ErrDBFileNotOpen)
+		return
+	}
+	h.delKv(banKey(ban))
+}
+
+// OnCompact compacts the full key range of the store, removing deleted records.
+func (h *Hook) OnCompact() error {
+	if h.db == nil {
+		return storage.ErrDBFileNotOpen
+	}
+
+	return h.db.Compact([]byte{0x00}, []byte{0xff}, true)
+}
+
 // StoredClients returns all stored clients from the store.
 func (h *Hook) StoredClients() (v []storage.Client, err error) {
 	if h.db == nil {
@@ -381,9 +524,16 @@ func (h *Hook) StoredClients() (v []storage.Client, err error) {
 
 	for iter.First(); iter.Valid(); iter.Next() {
 		item := storage.Client{}
-		if err := item.UnmarshalBinary(iter.Value()); err == nil {
-			v = append(v, item)
+		if err := item.UnmarshalBinary(iter.Value()); err != nil {
+			continue
+		}
+
+		if err := item.Decrypt(h.config.Cipher); err != nil {
+			h.Log.Error("failed to decrypt data", "error", err, "key", item.ID)
+			continue
 		}
+
+		v = append(v, item)
 	}
 	return v, nil
 }
@@ -423,9 +573,16 @@ func (h *Hook) StoredRetainedMessages() (v []storage.Message, err error) {
 
 	for iter.First(); iter.Valid(); iter.Next() {
 		item := storage.Message{}
-		if err := item.UnmarshalBinary(iter.Value()); err == nil {
-			v = append(v, item)
+		if err := item.UnmarshalBinary(iter.Value()); err != nil {
+			continue
 		}
+
+		if err := item.Decrypt(h.config.Cipher); err != nil {
+			h.Log.Error("failed to decrypt data", "error", err, "key", item.ID)
+			continue
+		}
+
+		v = append(v, item)
 	}
 	return v, nil
 }
@@ -444,9 +601,16 @@ func (h *Hook) StoredInflightMessages() (v []storage.Message, err error) {
 
 	for iter.First(); iter.Valid(); iter.Next() {
 		item := storage.Message{}
-		if err := item.UnmarshalBinary(iter.Value()); err == nil {
-			v = append(v, item)
+		if err := item.UnmarshalBinary(iter.Value()); err != nil {
+			continue
+		}
+
+		if err := item.Decrypt(h.config.Cipher); err != nil {
+			h.Log.Error("failed to decrypt data", "error", err, "key", item.ID)
+			continue
 		}
+
+		v = append(v, item)
 	}
 	return v, nil
 }
@@ -466,6 +630,27 @@ func (h *Hook) StoredSysInfo() (v storage.SystemInfo, err error) {
 	return
 }
 
+// StoredBans returns all stored bans from the store.
+func (h *Hook) StoredBans() (v []storage.Ban, err error) {
+	if h.db == nil {
+		h.Log.Error("", "error", storage.ErrDBFileNotOpen)
+		return
+	}
+
+	iter, _ := h.db.NewIter(&pebbledb.IterOptions{
+		LowerBound: []byte(storage.BanKey),
+		UpperBound: keyUpperBound([]byte(storage.BanKey)),
+	})
+
+	for iter.First(); iter.Valid(); iter.Next() {
+		item := storage.Ban{}
+		if err := item.UnmarshalBinary(iter.Value()); err == nil {
+			v = append(v, item)
+		}
+	}
+	return v, nil
+}
+
 // Errorf satisfies the pebble interface for an error logger.
 func (h *Hook) Errorf(m string, v ...interface{}) {
 	h.Log.Error(fmt.Sprintf(strings.ToLower(strings.Trim(m, "\n")), v...), "v", v)
@@ -492,6 +677,7 @@ func (h *Hook) delKv(k string) error {
 	err := h.db.Delete([]byte(k), h.mode)
 	if err != nil {
 		h.Log.Error("failed to delete data", "error", err, "key", k)
+		h.ReportError(nil, mqtt.ErrorSubsystemStorage, err)
 		return err
 	}
 	return nil
@@ -503,6 +689,7 @@ func (h *Hook) setKv(k string, v storage.Serializable) error {
 	err := h.db.Set([]byte(k), bs, h.mode)
 	if err != nil {
 		h.Log.Error("failed to update data", "error", err, "key", k)
+		h.ReportError(nil, mqtt.ErrorSubsystemStorage, err)
 		return err
 	}
 	return nil
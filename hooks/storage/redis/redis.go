@@ -49,6 +49,11 @@ func sysInfoKey() string {
 	return storage.SysInfoKey
 }
 
+// banKey returns a primary key for a ban.
+func banKey(ban mqtt.Ban) string {
+	return ban.Key()
+}
+
 // Options contains configuration settings for the bolt instance.
 type Options struct {
 	Address  string `yaml:"address" json:"address"`
@@ -57,6 +62,15 @@ type Options struct {
 	Database int    `yaml:"database" json:"database"`
 	HPrefix  string `yaml:"h_prefix" json:"h_prefix"`
 	Options  *redis.Options
+	// EncryptionKey is a hex encoded 16, 24, or 32 byte AES key used to encrypt stored
+	// payloads and credentials, if no Cipher is set.
+	EncryptionKey string `yaml:"encryption_key" json:"encryption_key"`
+	// Cipher encrypts stored payloads and credentials, eg. using a key management service.
+	Cipher storage.Cipher `yaml:"-" json:"-"`
+	// SubscriptionsOnly stores clients and their subscriptions, retained messages, and bans,
+	// but not inflight or queued messages, so clients are resubscribed after a restart
+	// without the write load of persisting every qos message.
+	SubscriptionsOnly bool `yaml:"subscriptions_only" json:"subscriptions_only"`
 }
 
 // Hook is a persistent storage hook based using Redis as a backend.
@@ -74,6 +88,13 @@ func (h *Hook) ID() string {
 
 // Provides indicates which hook methods this hook provides.
 func (h *Hook) Provides(b byte) bool {
+	if h.config != nil && h.config.SubscriptionsOnly {
+		switch b {
+		case mqtt.OnQosPublish, mqtt.OnQosComplete, mqtt.OnQosDropped, mqtt.StoredInflightMessages:
+			return false
+		}
+	}
+
 	return bytes.Contains([]byte{
 		mqtt.OnSessionEstablished,
 		mqtt.OnDisconnect,
@@ -87,11 +108,14 @@ func (h *Hook) Provides(b byte) bool {
 		mqtt.OnSysInfoTick,
 		mqtt.OnClientExpired,
 		mqtt.OnRetainedExpired,
+		mqtt.OnBanned,
+		mqtt.OnUnbanned,
 		mqtt.StoredClients,
 		mqtt.StoredInflightMessages,
 		mqtt.StoredRetainedMessages,
 		mqtt.StoredSubscriptions,
 		mqtt.StoredSysInfo,
+		mqtt.StoredBans,
 	}, []byte{b})
 }
 
@@ -126,6 +150,14 @@ func (h *Hook) Init(config any) error {
 		h.config.HPrefix = defaultHPrefix
 	}
 
+	if h.config.Cipher == nil && h.config.EncryptionKey != "" {
+		c, err := storage.NewAESCipherFromHex(h.config.EncryptionKey)
+		if err != nil {
+			return err
+		}
+		h.config.Cipher = c
+	}
+
 	h.Log.Info(
 		"connecting to redis service",
 		"prefix", h.config.HPrefix,
@@ -143,7 +175,61 @@ func (h *Hook) Init(config any) error {
 
 	h.Log.Info("connected to redis service")
 
-	return nil
+	return h.migrate()
+}
+
+// migrate upgrades any records written by an older storage format version to the
+// current version, and records the current version in the store.
+func (h *Hook) migrate() error {
+	ver := storage.StoreVersion{Version: 1}
+	row, err := h.db.HGet(h.ctx, h.hKey(storage.VersionKey), storage.VersionKey).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return err
+	}
+
+	if err = ver.UnmarshalBinary([]byte(row)); err != nil {
+		return err
+	}
+
+	if ver.Version > storage.Version {
+		return fmt.Errorf("%w: %d", storage.ErrUnsupportedVersion, ver.Version)
+	}
+
+	if ver.Version == storage.Version {
+		return nil
+	}
+
+	for _, key := range []string{
+		storage.ClientKey,
+		storage.SubscriptionKey,
+		storage.RetainedKey,
+		storage.InflightKey,
+		storage.SysInfoKey,
+		storage.BanKey,
+	} {
+		rows, err := h.db.HGetAll(h.ctx, h.hKey(key)).Result()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return err
+		}
+
+		for field, value := range rows {
+			data, err := storage.Migrate(ver.Version, []byte(value))
+			if err != nil {
+				return fmt.Errorf("key %s: %w", field, err)
+			}
+
+			if string(data) == value {
+				continue
+			}
+
+			if err := h.db.HSet(h.ctx, h.hKey(key), field, data).Err(); err != nil {
+				return err
+			}
+		}
+	}
+
+	h.Log.Info("migrated storage format", "from", ver.Version, "to", storage.Version)
+	return h.db.HSet(h.ctx, h.hKey(storage.VersionKey), storage.VersionKey, storage.CurrentVersion()).Err()
 }
 
 // Stop closes the redis connection.
@@ -179,6 +265,7 @@ func (h *Hook) updateClient(cl *mqtt.Client) {
 		Username:        cl.Properties.Username,
 		Clean:           cl.Properties.Clean,
 		ProtocolVersion: cl.Properties.ProtocolVersion,
+		Created:         cl.Session().Created,
 		Properties: storage.ClientProperties{
 			SessionExpiryInterval: props.SessionExpiryInterval,
 			AuthenticationMethod:  props.AuthenticationMethod,
@@ -193,9 +280,15 @@ func (h *Hook) updateClient(cl *mqtt.Client) {
 		Will: storage.ClientWill(cl.Properties.Will),
 	}
 
+	if err := in.Encrypt(h.config.Cipher); err != nil {
+		h.Log.Error("failed to encrypt data", "error", err, "key", in.ID)
+		return
+	}
+
 	err := h.db.HSet(h.ctx, h.hKey(storage.ClientKey), clientKey(cl), in).Err()
 	if err != nil {
 		h.Log.Error("failed to hset client data", "error", err, "data", in)
+		h.ReportError(cl, mqtt.ErrorSubsystemStorage, err)
 	}
 }
 
@@ -217,6 +310,7 @@ func (h *Hook) OnDisconnect(cl *mqtt.Client, _ error, expire bool) {
 	err := h.db.HDel(h.ctx, h.hKey(storage.ClientKey), clientKey(cl)).Err()
 	if err != nil {
 		h.Log.Error("failed to delete client", "error", err, "id", clientKey(cl))
+		h.ReportError(cl, mqtt.ErrorSubsystemStorage, err)
 	}
 }
 
@@ -244,6 +338,7 @@ func (h *Hook) OnSubscribed(cl *mqtt.Client, pk packets.Packet, reasonCodes []by
 		err := h.db.HSet(h.ctx, h.hKey(storage.SubscriptionKey), subscriptionKey(cl, pk.Filters[i].Filter), in).Err()
 		if err != nil {
 			h.Log.Error("failed to hset subscription data", "error", err, "data", in)
+			h.ReportError(cl, mqtt.ErrorSubsystemStorage, err)
 		}
 	}
 }
@@ -259,6 +354,7 @@ func (h *Hook) OnUnsubscribed(cl *mqtt.Client, pk packets.Packet) {
 		err := h.db.HDel(h.ctx, h.hKey(storage.SubscriptionKey), subscriptionKey(cl, pk.Filters[i].Filter)).Err()
 		if err != nil {
 			h.Log.Error("failed to delete subscription data", "error", err, "id", clientKey(cl))
+			h.ReportError(cl, mqtt.ErrorSubsystemStorage, err)
 		}
 	}
 }
@@ -274,6 +370,7 @@ func (h *Hook) OnRetainMessage(cl *mqtt.Client, pk packets.Packet, r int64) {
 		err := h.db.HDel(h.ctx, h.hKey(storage.RetainedKey), retainedKey(pk.TopicName)).Err()
 		if err != nil {
 			h.Log.Error("failed to delete retained message data", "error", err, "id", retainedKey(pk.TopicName))
+			h.ReportError(cl, mqtt.ErrorSubsystemStorage, err)
 		}
 
 		return
@@ -286,6 +383,7 @@ func (h *Hook) OnRetainMessage(cl *mqtt.Client, pk packets.Packet, r int64) {
 		FixedHeader: pk.FixedHeader,
 		TopicName:   pk.TopicName,
 		Payload:     pk.Payload,
+		Compressed:  pk.Compressed,
 		Created:     pk.Created,
 		Origin:      pk.Origin,
 		Properties: storage.MessageProperties{
@@ -300,9 +398,15 @@ func (h *Hook) OnRetainMessage(cl *mqtt.Client, pk packets.Packet, r int64) {
 		},
 	}
 
+	if err := in.Encrypt(h.config.Cipher); err != nil {
+		h.Log.Error("failed to encrypt data", "error", err, "key", in.ID)
+		return
+	}
+
 	err := h.db.HSet(h.ctx, h.hKey(storage.RetainedKey), retainedKey(pk.TopicName), in).Err()
 	if err != nil {
 		h.Log.Error("failed to hset retained message data", "error", err, "data", in)
+		h.ReportError(cl, mqtt.ErrorSubsystemStorage, err)
 	}
 }
 
@@ -318,6 +422,7 @@ func (h *Hook) OnQosPublish(cl *mqtt.Client, pk packets.Packet, sent int64, rese
 		ID:          inflightKey(cl, pk),
 		T:           storage.InflightKey,
 		Origin:      pk.Origin,
+		PacketID:    pk.PacketID,
 		FixedHeader: pk.FixedHeader,
 		TopicName:   pk.TopicName,
 		Payload:     pk.Payload,
@@ -335,9 +440,15 @@ func (h *Hook) OnQosPublish(cl *mqtt.Client, pk packets.Packet, sent int64, rese
 		},
 	}
 
+	if err := in.Encrypt(h.config.Cipher); err != nil {
+		h.Log.Error("failed to encrypt data", "error", err, "key", in.ID)
+		return
+	}
+
 	err := h.db.HSet(h.ctx, h.hKey(storage.InflightKey), inflightKey(cl, pk), in).Err()
 	if err != nil {
 		h.Log.Error("failed to hset qos inflight message data", "error", err, "data", in)
+		h.ReportError(cl, mqtt.ErrorSubsystemStorage, err)
 	}
 }
 
@@ -351,6 +462,7 @@ func (h *Hook) OnQosComplete(cl *mqtt.Client, pk packets.Packet) {
 	err := h.db.HDel(h.ctx, h.hKey(storage.InflightKey), inflightKey(cl, pk)).Err()
 	if err != nil {
 		h.Log.Error("failed to delete qos inflight message data", "error", err, "id", inflightKey(cl, pk))
+		h.ReportError(cl, mqtt.ErrorSubsystemStorage, err)
 	}
 }
 
@@ -379,6 +491,7 @@ func (h *Hook) OnSysInfoTick(sys *system.Info) {
 	err := h.db.HSet(h.ctx, h.hKey(storage.SysInfoKey), sysInfoKey(), in).Err()
 	if err != nil {
 		h.Log.Error("failed to hset server info data", "error", err, "data", in)
+		h.ReportError(nil, mqtt.ErrorSubsystemStorage, err)
 	}
 }
 
@@ -392,6 +505,7 @@ func (h *Hook) OnRetainedExpired(filter string) {
 	err := h.db.HDel(h.ctx, h.hKey(storage.RetainedKey), retainedKey(filter)).Err()
 	if err != nil {
 		h.Log.Error("failed to delete expired retained message", "error", err, "id", retainedKey(filter))
+		h.ReportError(nil, mqtt.ErrorSubsystemStorage, err)
 	}
 }
 
@@ -405,6 +519,45 @@ func (h *Hook) OnClientExpired(cl *mqtt.Client) {
 	err := h.db.HDel(h.ctx, h.hKey(storage.ClientKey), clientKey(cl)).Err()
 	if err != nil {
 		h.Log.Error("failed to delete expired client", "error", err, "id", clientKey(cl))
+		h.ReportError(cl, mqtt.ErrorSubsystemStorage, err)
+	}
+}
+
+// OnBanned adds or updates a ban in the store.
+func (h *Hook) OnBanned(ban mqtt.Ban) {
+	if h.db == nil {
+		h.Log.Error("", "error", storage.ErrDBFileNotOpen)
+		return
+	}
+
+	in := &storage.Ban{
+		ID:       banKey(ban),
+		T:        storage.BanKey,
+		ClientID: ban.ClientID,
+		IP:       ban.IP,
+		Reason:   ban.Reason,
+		Created:  ban.Created,
+		Expiry:   ban.Expiry,
+	}
+
+	err := h.db.HSet(h.ctx, h.hKey(storage.BanKey), banKey(ban), in).Err()
+	if err != nil {
+		h.Log.Error("failed to hset ban data", "error", err, "data", in)
+		h.ReportError(nil, mqtt.ErrorSubsystemStorage, err)
+	}
+}
+
+// OnUnbanned deletes a removed or expired ban from the store.
+func (h *Hook) OnUnbanned(ban mqtt.Ban) {
+	if h.db == nil {
+		h.Log.Error("", "error", storage.ErrDBFileNotOpen)
+		return
+	}
+
+	err := h.db.HDel(h.ctx, h.hKey(storage.BanKey), banKey(ban)).Err()
+	if err != nil {
+		h.Log.Error("failed to delete ban data", "error", err, "id", banKey(ban))
+		h.ReportError(nil, mqtt.ErrorSubsystemStorage, err)
 	}
 }
 
@@ -427,6 +580,11 @@ func (h *Hook) StoredClients() (v []storage.Client, err error) {
 			h.Log.Error("failed to unmarshal client data", "error", err, "data", row)
 		}
 
+		if err = d.Decrypt(h.config.Cipher); err != nil {
+			h.Log.Error("failed to decrypt client data", "error", err, "key", d.ID)
+			continue
+		}
+
 		v = append(v, d)
 	}
 
@@ -477,6 +635,11 @@ func (h *Hook) StoredRetainedMessages() (v []storage.Message, err error) {
 			h.Log.Error("failed to unmarshal retained message data", "error", err, "data", row)
 		}
 
+		if err = d.Decrypt(h.config.Cipher); err != nil {
+			h.Log.Error("failed to decrypt retained message data", "error", err, "key", d.ID)
+			continue
+		}
+
 		v = append(v, d)
 	}
 
@@ -502,6 +665,11 @@ func (h *Hook) StoredInflightMessages() (v []storage.Message, err error) {
 			h.Log.Error("failed to unmarshal inflight message data", "error", err, "data", row)
 		}
 
+		if err = d.Decrypt(h.config.Cipher); err != nil {
+			h.Log.Error("failed to decrypt inflight message data", "error", err, "key", d.ID)
+			continue
+		}
+
 		v = append(v, d)
 	}
 
@@ -526,3 +694,28 @@ func (h *Hook) StoredSysInfo() (v storage.SystemInfo, err error) {
 
 	return v, nil
 }
+
+// StoredBans returns all stored bans from the store.
+func (h *Hook) StoredBans() (v []storage.Ban, err error) {
+	if h.db == nil {
+		h.Log.Error("", "error", storage.ErrDBFileNotOpen)
+		return
+	}
+
+	rows, err := h.db.HGetAll(h.ctx, h.hKey(storage.BanKey)).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		h.Log.Error("failed to HGetAll ban data", "error", err)
+		return
+	}
+
+	for _, row := range rows {
+		var d storage.Ban
+		if err = d.UnmarshalBinary([]byte(row)); err != nil {
+			h.Log.Error("failed to unmarshal ban data", "error", err, "data", row)
+		}
+
+		v = append(v, d)
+	}
+
+	return v, nil
+}
@@ -85,6 +85,11 @@ func TestSysInfoKey(t *testing.T) {
 	require.Equal(t, storage.SysInfoKey, sysInfoKey())
 }
 
+func TestBanKey(t *testing.T) {
+	k := banKey(mqtt.Ban{ClientID: "mochi", IP: "127.0.0.1"})
+	require.Equal(t, "mochi@127.0.0.1", k)
+}
+
 func TestID(t *testing.T) {
 	h := new(Hook)
 	h.SetOpts(logger, nil)
@@ -108,10 +113,28 @@ func TestProvides(t *testing.T) {
 	require.True(t, h.Provides(mqtt.StoredRetainedMessages))
 	require.True(t, h.Provides(mqtt.StoredSubscriptions))
 	require.True(t, h.Provides(mqtt.StoredSysInfo))
+	require.True(t, h.Provides(mqtt.OnBanned))
+	require.True(t, h.Provides(mqtt.OnUnbanned))
+	require.True(t, h.Provides(mqtt.StoredBans))
 	require.False(t, h.Provides(mqtt.OnACLCheck))
 	require.False(t, h.Provides(mqtt.OnConnectAuthenticate))
 }
 
+func TestProvidesSubscriptionsOnly(t *testing.T) {
+	h := new(Hook)
+	h.config = &Options{SubscriptionsOnly: true}
+	require.True(t, h.Provides(mqtt.OnSessionEstablished))
+	require.True(t, h.Provides(mqtt.OnSubscribed))
+	require.True(t, h.Provides(mqtt.OnUnsubscribed))
+	require.True(t, h.Provides(mqtt.OnRetainMessage))
+	require.True(t, h.Provides(mqtt.StoredClients))
+	require.True(t, h.Provides(mqtt.StoredSubscriptions))
+	require.False(t, h.Provides(mqtt.OnQosPublish))
+	require.False(t, h.Provides(mqtt.OnQosComplete))
+	require.False(t, h.Provides(mqtt.OnQosDropped))
+	require.False(t, h.Provides(mqtt.StoredInflightMessages))
+}
+
 func TestHKey(t *testing.T) {
 	s := miniredis.RunT(t)
 	defer s.Close()
@@ -177,6 +200,29 @@ func TestInitBadAddr(t *testing.T) {
 	require.Error(t, err)
 }
 
+func TestInitMigrate(t *testing.T) {
+	s := miniredis.RunT(t)
+	defer s.Close()
+	h := newHook(t, s.Addr())
+	defer teardown(t, h)
+
+	ver := storage.StoreVersion{}
+	row, err := h.db.HGet(h.ctx, h.hKey(storage.VersionKey), storage.VersionKey).Result()
+	require.NoError(t, err)
+	require.NoError(t, ver.UnmarshalBinary([]byte(row)))
+	require.Equal(t, storage.Version, ver.Version)
+
+	err = h.db.HSet(h.ctx, h.hKey(storage.VersionKey), storage.VersionKey, &storage.StoreVersion{Version: storage.Version + 1}).Err()
+	require.NoError(t, err)
+
+	err = h.Init(&Options{
+		Options: &redis.Options{
+			Addr: s.Addr(),
+		},
+	})
+	require.ErrorIs(t, err, storage.ErrUnsupportedVersion)
+}
+
 func TestOnSessionEstablishedThenOnDisconnect(t *testing.T) {
 	s := miniredis.RunT(t)
 	defer s.Close()
@@ -385,6 +431,39 @@ func TestOnUnsubscribedClosedDB(t *testing.T) {
 	h.OnUnsubscribed(client, pkf)
 }
 
+func TestOnRetainMessageEncrypted(t *testing.T) {
+	s := miniredis.RunT(t)
+	defer s.Close()
+	h := newHook(t, s.Addr())
+	defer teardown(t, h)
+
+	c, err := storage.NewAESCipherFromHex("000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f")
+	require.NoError(t, err)
+	h.config.Cipher = c
+
+	pk := packets.Packet{
+		FixedHeader: packets.FixedHeader{
+			Retain: true,
+		},
+		Payload:   []byte("hello"),
+		TopicName: "a/b/c",
+	}
+
+	h.OnRetainMessage(client, pk, 1)
+
+	r := new(storage.Message)
+	row, err := h.db.HGet(h.ctx, h.hKey(storage.RetainedKey), retainedKey(pk.TopicName)).Result()
+	require.NoError(t, err)
+	require.NoError(t, r.UnmarshalBinary([]byte(row)))
+	require.True(t, r.Encrypted)
+	require.NotEqual(t, pk.Payload, r.Payload)
+
+	msgs, err := h.StoredRetainedMessages()
+	require.NoError(t, err)
+	require.Len(t, msgs, 1)
+	require.Equal(t, pk.Payload, msgs[0].Payload)
+}
+
 func TestOnRetainMessageThenUnset(t *testing.T) {
 	s := miniredis.RunT(t)
 	defer s.Close()
@@ -822,6 +901,57 @@ func TestStoredSysInfoNoDB(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestOnBannedThenOnUnbanned(t *testing.T) {
+	s := miniredis.RunT(t)
+	defer s.Close()
+	h := newHook(t, s.Addr())
+	defer teardown(t, h)
+
+	ban := mqtt.Ban{ClientID: "mochi", IP: "127.0.0.1", Reason: "test", Created: 1, Expiry: 2}
+	h.OnBanned(ban)
+
+	r, err := h.StoredBans()
+	require.NoError(t, err)
+	require.Len(t, r, 1)
+	require.Equal(t, banKey(ban), r[0].ID)
+	require.Equal(t, storage.BanKey, r[0].T)
+	require.Equal(t, ban.ClientID, r[0].ClientID)
+	require.Equal(t, ban.IP, r[0].IP)
+	require.Equal(t, ban.Reason, r[0].Reason)
+	require.Equal(t, ban.Expiry, r[0].Expiry)
+
+	h.OnUnbanned(ban)
+	r, err = h.StoredBans()
+	require.NoError(t, err)
+	require.Len(t, r, 0)
+}
+
+func TestOnBannedNoDB(t *testing.T) {
+	s := miniredis.RunT(t)
+	defer s.Close()
+	h := newHook(t, s.Addr())
+	h.db = nil
+	h.OnBanned(mqtt.Ban{ClientID: "mochi"})
+}
+
+func TestOnUnbannedNoDB(t *testing.T) {
+	s := miniredis.RunT(t)
+	defer s.Close()
+	h := newHook(t, s.Addr())
+	h.db = nil
+	h.OnUnbanned(mqtt.Ban{ClientID: "mochi"})
+}
+
+func TestStoredBansNoDB(t *testing.T) {
+	s := miniredis.RunT(t)
+	defer s.Close()
+	h := newHook(t, s.Addr())
+	h.db = nil
+	v, err := h.StoredBans()
+	require.Empty(t, v)
+	require.NoError(t, err)
+}
+
 func TestStoredSysInfoClosedDB(t *testing.T) {
 	s := miniredis.RunT(t)
 	defer s.Close()
@@ -7,6 +7,7 @@ package storage
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 
 	"github.com/mochi-mqtt/server/v2/packets"
 	"github.com/mochi-mqtt/server/v2/system"
@@ -18,13 +19,62 @@ const (
 	RetainedKey     = "RET" // unique key to denote retained messages in a store
 	InflightKey     = "IFM" // unique key to denote inflight messages in a store
 	ClientKey       = "CL"  // unique key to denote clients in a store
+	BanKey          = "BAN" // unique key to denote bans in a store
+	VersionKey      = "VER" // unique key to denote the storage format version of a store
+
+	// Version is the current version of the storage format. Stores written before the
+	// version was recorded have no version marker and are treated as version 1.
+	Version = 2
 )
 
 var (
 	// ErrDBFileNotOpen indicates that the file database (e.g. bolt/badger) wasn't open for reading.
 	ErrDBFileNotOpen = errors.New("db file not open")
+
+	// ErrUnsupportedVersion indicates a store was written by a newer storage format version than is supported.
+	ErrUnsupportedVersion = errors.New("unsupported storage format version")
+
+	// ErrMissingMigration indicates there was no migration to upgrade a store from an older storage format version.
+	ErrMissingMigration = errors.New("missing storage format migration")
 )
 
+// Migration upgrades the data of a stored record by one storage format version. Each
+// record describes its own type in its t field.
+type Migration func(data []byte) ([]byte, error)
+
+// Migrations contains the migrations which upgrade stored records to the next storage
+// format version, keyed on the version they upgrade from.
+var Migrations = map[int]Migration{
+	// version 2 added the session creation time to clients, which version 1 records
+	// omit, so they can be read unchanged.
+	1: func(data []byte) ([]byte, error) {
+		return data, nil
+	},
+}
+
+// Migrate upgrades the data of a stored record from a storage format version to the
+// current Version, applying each migration in turn.
+func Migrate(from int, data []byte) ([]byte, error) {
+	if from > Version {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, from)
+	}
+
+	for v := from; v < Version; v++ {
+		m, ok := Migrations[v]
+		if !ok {
+			return nil, fmt.Errorf("%w: from version %d", ErrMissingMigration, v)
+		}
+
+		var err error
+		data, err = m(data)
+		if err != nil {
+			return nil, fmt.Errorf("migrate from version %d: %w", v, err)
+		}
+	}
+
+	return data, nil
+}
+
 // Serializable is an interface for objects that can be serialized and deserialized.
 type Serializable interface {
 	UnmarshalBinary([]byte) error
@@ -33,15 +83,17 @@ type Serializable interface {
 
 // Client is a storable representation of an MQTT client.
 type Client struct {
-	Will            ClientWill       `json:"will"`            // will topic and payload data if applicable
-	Properties      ClientProperties `json:"properties"`      // the connect properties for the client
-	Username        []byte           `json:"username"`        // the username of the client
-	ID              string           `json:"id" storm:"id"`   // the client id / storage key
-	T               string           `json:"t"`               // the data type (client)
-	Remote          string           `json:"remote"`          // the remote address of the client
-	Listener        string           `json:"listener"`        // the listener the client connected on
-	ProtocolVersion byte             `json:"protocolVersion"` // mqtt protocol version of the client
-	Clean           bool             `json:"clean"`           // if the client requested a clean start/session
+	Will            ClientWill       `json:"will"`                // will topic and payload data if applicable
+	Properties      ClientProperties `json:"properties"`          // the connect properties for the client
+	Username        []byte           `json:"username"`            // the username of the client
+	ID              string           `json:"id" storm:"id"`       // the client id / storage key
+	T               string           `json:"t"`                   // the data type (client)
+	Remote          string           `json:"remote"`              // the remote address of the client
+	Listener        string           `json:"listener"`            // the listener the client connected on
+	ProtocolVersion byte             `json:"protocolVersion"`     // mqtt protocol version of the client
+	Clean           bool             `json:"clean"`               // if the client requested a clean start/session
+	Created         int64            `json:"created,omitempty"`   // the unix time the session was created
+	Encrypted       bool             `json:"encrypted,omitempty"` // the credentials and will payload are encrypted
 }
 
 // ClientProperties contains a limited set of the mqtt v5 properties specific to a client connection.
@@ -85,16 +137,18 @@ func (d *Client) UnmarshalBinary(data []byte) error {
 
 // Message is a storable representation of an MQTT message (specifically publish).
 type Message struct {
-	Properties  MessageProperties   `json:"properties"`    // -
-	Payload     []byte              `json:"payload"`       // the message payload (if retained)
-	T           string              `json:"t"`             // the data type
-	ID          string              `json:"id" storm:"id"` // the storage key
-	Origin      string              `json:"origin"`        // the id of the client who sent the message
-	TopicName   string              `json:"topic_name"`    // the topic the message was sent to (if retained)
-	FixedHeader packets.FixedHeader `json:"fixedheader"`   // the header properties of the message
-	Created     int64               `json:"created"`       // the time the message was created in unixtime
-	Sent        int64               `json:"sent"`          // the last time the message was sent (for retries) in unixtime (if inflight)
-	PacketID    uint16              `json:"packet_id"`     // the unique id of the packet (if inflight)
+	Properties  MessageProperties   `json:"properties"`           // -
+	Payload     []byte              `json:"payload"`              // the message payload (if retained)
+	T           string              `json:"t"`                    // the data type
+	ID          string              `json:"id" storm:"id"`        // the storage key
+	Origin      string              `json:"origin"`               // the id of the client who sent the message
+	TopicName   string              `json:"topic_name"`           // the topic the message was sent to (if retained)
+	FixedHeader packets.FixedHeader `json:"fixedheader"`          // the header properties of the message
+	Created     int64               `json:"created"`              // the time the message was created in unixtime
+	Sent        int64               `json:"sent"`                 // the last time the message was sent (for retries) in unixtime (if inflight)
+	PacketID    uint16              `json:"packet_id"`            // the unique id of the packet (if inflight)
+	Encrypted   bool                `json:"encrypted,omitempty"`  // the payload and correlation data are encrypted
+	Compressed  bool                `json:"compressed,omitempty"` // the payload is snappy compressed (if retained)
 }
 
 // MessageProperties contains a limited subset of mqtt v5 properties specific to publish messages.
@@ -132,6 +186,7 @@ func (d *Message) ToPacket() packets.Packet {
 		Payload:     d.Payload,
 		Origin:      d.Origin,
 		Created:     d.Created,
+		Compressed:  d.Compressed,
 		Properties: packets.Properties{
 			PayloadFormat:          d.Properties.PayloadFormat,
 			PayloadFormatFlag:      d.Properties.PayloadFormatFlag,
@@ -198,3 +253,56 @@ func (d *SystemInfo) UnmarshalBinary(data []byte) error {
 	}
 	return json.Unmarshal(data, d)
 }
+
+// Ban is a storable representation of a client id and/or ip address ban.
+type Ban struct {
+	ID       string `json:"id" storm:"id"` // the storage key
+	T        string `json:"t"`             // the data type (ban)
+	ClientID string `json:"clientId"`      // the banned client id, if any
+	IP       string `json:"ip"`            // the banned ip address, if any
+	Reason   string `json:"reason"`        // the reason for the ban
+	Created  int64  `json:"created"`       // the time the ban was created
+	Expiry   int64  `json:"expiry"`        // the time the ban expires, or 0 if permanent
+}
+
+// MarshalBinary encodes the values into a json string.
+func (d Ban) MarshalBinary() (data []byte, err error) {
+	return json.Marshal(d)
+}
+
+// UnmarshalBinary decodes a json string into a struct.
+func (d *Ban) UnmarshalBinary(data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, d)
+}
+
+// StoreVersion is a storable marker of the storage format version of a store.
+type StoreVersion struct {
+	ID      string `json:"id" storm:"id"` // the storage key
+	T       string `json:"t"`             // the data type (version)
+	Version int    `json:"version"`       // the storage format version the store was written with
+}
+
+// CurrentVersion returns a version marker for the current storage format version.
+func CurrentVersion() *StoreVersion {
+	return &StoreVersion{
+		ID:      VersionKey,
+		T:       VersionKey,
+		Version: Version,
+	}
+}
+
+// MarshalBinary encodes the values into a json string.
+func (d StoreVersion) MarshalBinary() (data []byte, err error) {
+	return json.Marshal(d)
+}
+
+// UnmarshalBinary decodes a json string into a struct.
+func (d *StoreVersion) UnmarshalBinary(data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, d)
+}
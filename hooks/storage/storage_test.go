@@ -5,6 +5,7 @@
 package storage
 
 import (
+	"errors"
 	"testing"
 	"time"
 
@@ -112,7 +113,18 @@ var (
 			InflightDropped:  17,
 		},
 	}
-	sysInfoJSON = []byte(`{"version":"2.0.0","started":1,"time":0,"uptime":2,"bytes_received":3,"bytes_sent":4,"clients_connected":5,"clients_disconnected":0,"clients_maximum":7,"clients_total":0,"messages_received":10,"messages_sent":11,"messages_dropped":20,"retained":15,"inflight":16,"inflight_dropped":17,"subscriptions":0,"packets_received":12,"packets_sent":13,"memory_alloc":0,"threads":0,"t":"info","id":"id"}`)
+	sysInfoJSON = []byte(`{"version":"2.0.0","started":1,"time":0,"uptime":2,"bytes_received":3,"bytes_sent":4,"clients_connected":5,"clients_disconnected":0,"clients_maximum":7,"clients_total":0,"messages_received":10,"messages_sent":11,"messages_dropped":20,"messages_throttled":0,"retained":15,"inflight":16,"inflight_dropped":17,"subscriptions":0,"packets_received":12,"packets_sent":13,"memory_alloc":0,"threads":0,"t":"info","id":"id"}`)
+
+	banStruct = Ban{
+		ID:       "BAN_mochi@127.0.0.1",
+		T:        BanKey,
+		ClientID: "mochi",
+		IP:       "127.0.0.1",
+		Reason:   "test",
+		Created:  1,
+		Expiry:   2,
+	}
+	banJSON = []byte(`{"id":"BAN_mochi@127.0.0.1","t":"BAN","clientId":"mochi","ip":"127.0.0.1","reason":"test","created":1,"expiry":2}`)
 )
 
 func TestClientMarshalBinary(t *testing.T) {
@@ -195,6 +207,76 @@ func TestSysInfoUnmarshalBinaryEmpty(t *testing.T) {
 	require.Equal(t, SystemInfo{}, d)
 }
 
+func TestBanMarshalBinary(t *testing.T) {
+	data, err := banStruct.MarshalBinary()
+	require.NoError(t, err)
+	require.JSONEq(t, string(banJSON), string(data))
+}
+
+func TestBanUnmarshalBinary(t *testing.T) {
+	d := Ban{}
+	err := d.UnmarshalBinary(banJSON)
+	require.NoError(t, err)
+	require.Equal(t, banStruct, d)
+}
+
+func TestBanUnmarshalBinaryEmpty(t *testing.T) {
+	d := Ban{}
+	err := d.UnmarshalBinary([]byte{})
+	require.NoError(t, err)
+	require.Equal(t, Ban{}, d)
+}
+
+func TestStoreVersionMarshalBinary(t *testing.T) {
+	data, err := CurrentVersion().MarshalBinary()
+	require.NoError(t, err)
+
+	d := StoreVersion{}
+	require.NoError(t, d.UnmarshalBinary(data))
+	require.Equal(t, *CurrentVersion(), d)
+}
+
+func TestStoreVersionUnmarshalBinaryEmpty(t *testing.T) {
+	d := StoreVersion{}
+	err := d.UnmarshalBinary([]byte{})
+	require.NoError(t, err)
+	require.Equal(t, StoreVersion{}, d)
+}
+
+func TestMigrate(t *testing.T) {
+	data, err := Migrate(1, clientJSON)
+	require.NoError(t, err)
+
+	d := Client{}
+	require.NoError(t, d.UnmarshalBinary(data))
+	require.Equal(t, clientStruct, d)
+
+	data, err = Migrate(Version, clientJSON)
+	require.NoError(t, err)
+	require.Equal(t, clientJSON, data)
+}
+
+func TestMigrateUnsupportedVersion(t *testing.T) {
+	_, err := Migrate(Version+1, clientJSON)
+	require.ErrorIs(t, err, ErrUnsupportedVersion)
+}
+
+func TestMigrateMissingMigration(t *testing.T) {
+	_, err := Migrate(0, clientJSON)
+	require.ErrorIs(t, err, ErrMissingMigration)
+}
+
+func TestMigrateError(t *testing.T) {
+	m := Migrations[1]
+	defer func() { Migrations[1] = m }()
+	Migrations[1] = func(data []byte) ([]byte, error) {
+		return nil, errors.New("test")
+	}
+
+	_, err := Migrate(1, clientJSON)
+	require.Error(t, err)
+}
+
 func TestMessageToPacket(t *testing.T) {
 	d := messageStruct
 	pk := d.ToPacket()
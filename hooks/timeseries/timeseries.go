@@ -0,0 +1,470 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+// Package timeseries provides a hook which writes the payloads of messages published
+// to selected topic filters into a time-series database such as InfluxDB.
+package timeseries
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/packets"
+)
+
+const (
+	defaultMeasurement   = "mqtt"  // the default measurement name used by the default parser
+	defaultBatchSize     = 500     // the default maximum number of points written in one batch
+	defaultFlushInterval = 1000    // the default interval between flushes in milliseconds
+	defaultMaxBackoff    = 60      // the default maximum retry backoff in seconds
+	defaultMaxPending    = 100_000 // the default maximum number of points held while the database is unavailable
+)
+
+var (
+	// ErrNoWriter indicates that neither a Writer nor an InfluxDB URL was configured.
+	ErrNoWriter = errors.New("timeseries requires a writer or url")
+
+	// ErrUnparseablePayload indicates the default parser could not extract any fields from a payload.
+	ErrUnparseablePayload = errors.New("payload contains no numeric, boolean or string fields")
+)
+
+// Point is a single time-series measurement extracted from a message.
+type Point struct {
+	Measurement string            // the name of the measurement
+	Tags        map[string]string // indexed metadata for the point
+	Fields      map[string]any    // the values of the point
+	Time        time.Time         // the time of the point
+}
+
+// ParserFn converts the payload of a message published to a topic into zero or more points.
+type ParserFn func(topic string, payload []byte, ts time.Time) ([]Point, error)
+
+// Writer writes batches of points to a time-series database. Implementations
+// should return an error if the batch should be retried.
+type Writer interface {
+	Write(ctx context.Context, points []Point) error
+}
+
+// Options contains configuration settings for the time-series hook.
+type Options struct {
+	Filters       []string `yaml:"filters" json:"filters"`               // topic filters of messages to record
+	Measurement   string   `yaml:"measurement" json:"measurement"`       // measurement name used by the default parser
+	URL           string   `yaml:"url" json:"url"`                       // full InfluxDB write endpoint, e.g. http://localhost:8086/api/v2/write?org=o&bucket=b
+	Token         string   `yaml:"token" json:"token"`                   // InfluxDB api token
+	BatchSize     int      `yaml:"batch_size" json:"batch_size"`         // maximum number of points per write
+	FlushInterval int64    `yaml:"flush_interval" json:"flush_interval"` // milliseconds between flushes
+	MaxBackoff    int64    `yaml:"max_backoff" json:"max_backoff"`       // maximum seconds to wait between failed writes
+	MaxPending    int      `yaml:"max_pending" json:"max_pending"`       // maximum points held in memory before the oldest are dropped
+	Parser        ParserFn `yaml:"-" json:"-"`                           // converts payloads to points; defaults to DefaultParser
+	Writer        Writer   `yaml:"-" json:"-"`                           // writes points; defaults to an InfluxWriter for URL
+}
+
+// Hook is a hook which writes published messages to a time-series database.
+type Hook struct {
+	mqtt.HookBase
+	config  *Options           // hook configuration
+	pending []Point            // points waiting to be written
+	flush   chan struct{}      // signals that a full batch is ready
+	done    chan struct{}      // closed when the flush loop has halted
+	cancel  context.CancelFunc // cancels the flush loop
+	dropped int64              // number of points dropped since the last warning
+	sync.Mutex
+}
+
+// ID returns the id of the hook.
+func (h *Hook) ID() string {
+	return "timeseries"
+}
+
+// Provides indicates which hook methods this hook provides.
+func (h *Hook) Provides(b byte) bool {
+	return bytes.Contains([]byte{
+		mqtt.OnPublished,
+	}, []byte{b})
+}
+
+// Init initializes the hook and starts the background flush loop.
+func (h *Hook) Init(config any) error {
+	if _, ok := config.(*Options); !ok && config != nil {
+		return mqtt.ErrInvalidConfigType
+	}
+
+	if config == nil {
+		config = new(Options)
+	}
+
+	h.config = config.(*Options)
+	if h.config.Writer == nil {
+		if h.config.URL == "" {
+			return ErrNoWriter
+		}
+		h.config.Writer = NewInfluxWriter(h.config.URL, h.config.Token, nil)
+	}
+
+	if h.config.Measurement == "" {
+		h.config.Measurement = defaultMeasurement
+	}
+
+	if h.config.Parser == nil {
+		h.config.Parser = DefaultParser(h.config.Measurement)
+	}
+
+	if h.config.BatchSize <= 0 {
+		h.config.BatchSize = defaultBatchSize
+	}
+
+	if h.config.FlushInterval <= 0 {
+		h.config.FlushInterval = defaultFlushInterval
+	}
+
+	if h.config.MaxBackoff <= 0 {
+		h.config.MaxBackoff = defaultMaxBackoff
+	}
+
+	if h.config.MaxPending <= 0 {
+		h.config.MaxPending = defaultMaxPending
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	h.cancel = cancel
+	h.flush = make(chan struct{}, 1)
+	h.done = make(chan struct{})
+	go h.flushLoop(ctx)
+
+	return nil
+}
+
+// Stop halts the flush loop and makes a final attempt to write any pending points.
+func (h *Hook) Stop() error {
+	if h.cancel == nil {
+		return nil
+	}
+
+	h.cancel()
+	<-h.done
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	for h.Len() > 0 {
+		if err := h.write(ctx); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Len returns the number of points waiting to be written.
+func (h *Hook) Len() int {
+	h.Lock()
+	defer h.Unlock()
+	return len(h.pending)
+}
+
+// OnPublished parses messages published to matching topics and queues the resulting points.
+func (h *Hook) OnPublished(cl *mqtt.Client, pk packets.Packet) {
+	if !h.matches(pk.TopicName) {
+		return
+	}
+
+	ts := time.Now()
+	if pk.Created > 0 {
+		ts = time.Unix(pk.Created, 0)
+	}
+
+	points, err := h.config.Parser(pk.TopicName, pk.Payload, ts)
+	if err != nil {
+		h.Log.Debug("failed to parse payload", "error", err, "client", cl.ID, "topic", pk.TopicName)
+		return
+	}
+
+	h.enqueue(points)
+}
+
+// matches returns true if the topic matches any of the configured filters.
+func (h *Hook) matches(topic string) bool {
+	for _, filter := range h.config.Filters {
+		if mqtt.MatchTopic(filter, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+// enqueue adds points to the pending queue, dropping the oldest points if the
+// queue is full, and signals the flush loop when a batch is ready.
+func (h *Hook) enqueue(points []Point) {
+	h.Lock()
+	h.pending = append(h.pending, points...)
+	if over := len(h.pending) - h.config.MaxPending; over > 0 {
+		h.pending = h.pending[over:]
+		h.dropped += int64(over)
+	}
+	ready := len(h.pending) >= h.config.BatchSize
+	h.Unlock()
+
+	if ready {
+		select {
+		case h.flush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// flushLoop writes pending points every flush interval or whenever a batch is ready,
+// backing off exponentially while the writer is failing. The backoff is only reset once
+// a write has succeeded.
+func (h *Hook) flushLoop(ctx context.Context) {
+	defer close(h.done)
+
+	interval := time.Millisecond * time.Duration(h.config.FlushInterval)
+	maxBackoff := time.Second * time.Duration(h.config.MaxBackoff)
+	wait := interval
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-h.flush:
+			if wait > interval { // still backing off, let the timer retry.
+				continue
+			}
+		case <-timer.C:
+		}
+
+		for h.Len() > 0 {
+			if err := h.write(ctx); err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+
+				wait = min(wait*2, maxBackoff)
+				h.Log.Warn("failed to write points, backing off", "error", err, "pending", h.Len(), "retry", wait)
+				break
+			}
+
+			wait = interval
+
+			if h.Len() < h.config.BatchSize {
+				break
+			}
+		}
+
+		h.Lock()
+		if h.dropped > 0 {
+			h.Log.Warn("dropped points while database was unavailable", "dropped", h.dropped)
+			h.dropped = 0
+		}
+		h.Unlock()
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+	}
+}
+
+// write writes the oldest batch of pending points, removing them from the queue
+// only if the write succeeds.
+func (h *Hook) write(ctx context.Context) error {
+	h.Lock()
+	n := min(len(h.pending), h.config.BatchSize)
+	batch := make([]Point, n)
+	copy(batch, h.pending[:n])
+	h.Unlock()
+
+	if err := h.config.Writer.Write(ctx, batch); err != nil {
+		return err
+	}
+
+	h.Lock()
+	defer h.Unlock()
+	n = min(n, len(h.pending)) // the oldest points may have been dropped while writing.
+	h.pending = h.pending[n:]
+	return nil
+}
+
+// DefaultParser returns a parser which reads a JSON object payload into fields, using
+// each top level number, boolean and string value, or a plain numeric payload into a
+// single "value" field. Points are tagged with the topic of the message.
+func DefaultParser(measurement string) ParserFn {
+	return func(topic string, payload []byte, ts time.Time) ([]Point, error) {
+		fields := map[string]any{}
+		if f, err := strconv.ParseFloat(strings.TrimSpace(string(payload)), 64); err == nil {
+			fields["value"] = f
+		} else {
+			var obj map[string]any
+			if err := json.Unmarshal(payload, &obj); err != nil {
+				return nil, ErrUnparseablePayload
+			}
+
+			for k, v := range obj {
+				switch v.(type) {
+				case float64, bool, string:
+					fields[k] = v
+				}
+			}
+		}
+
+		if len(fields) == 0 {
+			return nil, ErrUnparseablePayload
+		}
+
+		return []Point{
+			{
+				Measurement: measurement,
+				Tags:        map[string]string{"topic": topic},
+				Fields:      fields,
+				Time:        ts,
+			},
+		}, nil
+	}
+}
+
+// InfluxWriter writes points to an InfluxDB http write endpoint using line protocol.
+type InfluxWriter struct {
+	url    string       // the full write endpoint url
+	token  string       // the api token, if any
+	client *http.Client // the http client used to send requests
+}
+
+// NewInfluxWriter returns a new InfluxWriter for a write endpoint url. If client is nil,
+// a client with a 10 second timeout is used.
+func NewInfluxWriter(url, token string, client *http.Client) *InfluxWriter {
+	if client == nil {
+		client = &http.Client{Timeout: time.Second * 10}
+	}
+
+	return &InfluxWriter{
+		url:    url,
+		token:  token,
+		client: client,
+	}
+}
+
+// Write sends a batch of points to InfluxDB.
+func (w *InfluxWriter) Write(ctx context.Context, points []Point) error {
+	var buf bytes.Buffer
+	for _, p := range points {
+		buf.WriteString(p.LineProtocol())
+		buf.WriteByte('\n')
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, &buf)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if w.token != "" {
+		req.Header.Set("Authorization", "Token "+w.token)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("influx write failed: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+var (
+	measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	tagEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+	stringEscaper      = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+)
+
+// LineProtocol encodes the point using InfluxDB line protocol, with tags and fields
+// sorted by key and a nanosecond precision timestamp.
+func (p Point) LineProtocol() string {
+	var sb strings.Builder
+	sb.WriteString(measurementEscaper.Replace(p.Measurement))
+
+	keys := make([]string, 0, len(p.Tags))
+	for k := range p.Tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if p.Tags[k] == "" {
+			continue
+		}
+		sb.WriteByte(',')
+		sb.WriteString(tagEscaper.Replace(k))
+		sb.WriteByte('=')
+		sb.WriteString(tagEscaper.Replace(p.Tags[k]))
+	}
+
+	keys = keys[:0]
+	for k := range p.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for i, k := range keys {
+		if i == 0 {
+			sb.WriteByte(' ')
+		} else {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(tagEscaper.Replace(k))
+		sb.WriteByte('=')
+		sb.WriteString(formatField(p.Fields[k]))
+	}
+
+	if !p.Time.IsZero() {
+		sb.WriteByte(' ')
+		sb.WriteString(strconv.FormatInt(p.Time.UnixNano(), 10))
+	}
+
+	return sb.String()
+}
+
+// formatField formats a field value according to its line protocol type.
+func formatField(v any) string {
+	switch x := v.(type) {
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(x), 'f', -1, 32)
+	case int:
+		return strconv.FormatInt(int64(x), 10) + "i"
+	case int32:
+		return strconv.FormatInt(int64(x), 10) + "i"
+	case int64:
+		return strconv.FormatInt(x, 10) + "i"
+	case uint32:
+		return strconv.FormatUint(uint64(x), 10) + "u"
+	case uint64:
+		return strconv.FormatUint(x, 10) + "u"
+	case bool:
+		return strconv.FormatBool(x)
+	case string:
+		return `"` + stringEscaper.Replace(x) + `"`
+	default:
+		return `"` + stringEscaper.Replace(fmt.Sprint(x)) + `"`
+	}
+}
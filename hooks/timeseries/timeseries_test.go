@@ -0,0 +1,272 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package timeseries
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/packets"
+
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	logger  = slog.New(slog.NewTextHandler(os.Stdout, nil))
+	errTest = errors.New("test")
+)
+
+type memWriter struct {
+	sync.Mutex
+	batches  [][]Point
+	fail     bool
+	attempts int
+}
+
+func (w *memWriter) Write(ctx context.Context, points []Point) error {
+	w.Lock()
+	defer w.Unlock()
+	w.attempts++
+	if w.fail {
+		return errTest
+	}
+	w.batches = append(w.batches, points)
+	return nil
+}
+
+func (w *memWriter) count() int {
+	w.Lock()
+	defer w.Unlock()
+	n := 0
+	for _, b := range w.batches {
+		n += len(b)
+	}
+	return n
+}
+
+func newHook(t *testing.T, opts *Options) *Hook {
+	h := new(Hook)
+	h.SetOpts(logger, nil)
+	err := h.Init(opts)
+	require.NoError(t, err)
+	return h
+}
+
+func TestID(t *testing.T) {
+	h := new(Hook)
+	require.Equal(t, "timeseries", h.ID())
+}
+
+func TestProvides(t *testing.T) {
+	h := new(Hook)
+	require.True(t, h.Provides(mqtt.OnPublished))
+	require.False(t, h.Provides(mqtt.OnPublish))
+}
+
+func TestInitBadConfig(t *testing.T) {
+	h := new(Hook)
+	h.SetOpts(logger, nil)
+	err := h.Init(map[string]any{})
+	require.ErrorIs(t, err, mqtt.ErrInvalidConfigType)
+}
+
+func TestInitNoWriter(t *testing.T) {
+	h := new(Hook)
+	h.SetOpts(logger, nil)
+	err := h.Init(nil)
+	require.ErrorIs(t, err, ErrNoWriter)
+}
+
+func TestInitUseDefaults(t *testing.T) {
+	h := newHook(t, &Options{URL: "http://localhost:8086/api/v2/write"})
+	defer h.Stop()
+
+	require.IsType(t, new(InfluxWriter), h.config.Writer)
+	require.NotNil(t, h.config.Parser)
+	require.Equal(t, defaultMeasurement, h.config.Measurement)
+	require.Equal(t, defaultBatchSize, h.config.BatchSize)
+	require.Equal(t, int64(defaultFlushInterval), h.config.FlushInterval)
+	require.Equal(t, int64(defaultMaxBackoff), h.config.MaxBackoff)
+	require.Equal(t, defaultMaxPending, h.config.MaxPending)
+}
+
+func TestOnPublishedFiltered(t *testing.T) {
+	w := new(memWriter)
+	h := newHook(t, &Options{
+		Filters:       []string{"sensors/#"},
+		Writer:        w,
+		FlushInterval: 10,
+	})
+
+	cl := &mqtt.Client{ID: "mochi"}
+	h.OnPublished(cl, packets.Packet{TopicName: "sensors/a/temp", Payload: []byte("21.5")})
+	h.OnPublished(cl, packets.Packet{TopicName: "other/a/temp", Payload: []byte("21.5")})
+	h.OnPublished(cl, packets.Packet{TopicName: "sensors/a/bad", Payload: []byte("not a number")})
+	require.Equal(t, 1, h.Len())
+
+	require.Eventually(t, func() bool { return w.count() == 1 }, time.Second, time.Millisecond*5)
+	require.NoError(t, h.Stop())
+
+	p := w.batches[0][0]
+	require.Equal(t, "mqtt", p.Measurement)
+	require.Equal(t, "sensors/a/temp", p.Tags["topic"])
+	require.Equal(t, 21.5, p.Fields["value"])
+}
+
+func TestOnPublishedBatchReady(t *testing.T) {
+	w := new(memWriter)
+	h := newHook(t, &Options{
+		Filters:       []string{"#"},
+		Writer:        w,
+		BatchSize:     2,
+		FlushInterval: 60000,
+	})
+	defer h.Stop()
+
+	cl := &mqtt.Client{ID: "mochi"}
+	h.OnPublished(cl, packets.Packet{TopicName: "a", Payload: []byte("1")})
+	h.OnPublished(cl, packets.Packet{TopicName: "b", Payload: []byte("2")})
+
+	require.Eventually(t, func() bool { return w.count() == 2 }, time.Second, time.Millisecond*5)
+}
+
+func TestEnqueueDropsOldest(t *testing.T) {
+	w := &memWriter{fail: true}
+	h := newHook(t, &Options{
+		Filters:       []string{"#"},
+		Writer:        w,
+		MaxPending:    2,
+		FlushInterval: 60000,
+	})
+
+	h.enqueue([]Point{{Measurement: "a"}, {Measurement: "b"}, {Measurement: "c"}})
+	require.Equal(t, 2, h.Len())
+	require.Equal(t, "b", h.pending[0].Measurement)
+	require.Equal(t, int64(1), h.dropped)
+
+	require.ErrorIs(t, h.Stop(), errTest)
+}
+
+func TestFlushRetriesAfterFailure(t *testing.T) {
+	w := &memWriter{fail: true}
+	h := newHook(t, &Options{
+		Filters:       []string{"#"},
+		Writer:        w,
+		FlushInterval: 5,
+		MaxBackoff:    1,
+	})
+	defer h.Stop()
+
+	h.OnPublished(&mqtt.Client{ID: "mochi"}, packets.Packet{TopicName: "a", Payload: []byte("1")})
+	time.Sleep(time.Millisecond * 20)
+	require.Equal(t, 1, h.Len())
+
+	w.Lock()
+	w.fail = false
+	w.Unlock()
+
+	require.Eventually(t, func() bool { return w.count() == 1 }, time.Second*2, time.Millisecond*10)
+	require.Equal(t, 0, h.Len())
+}
+
+func TestFlushBacksOffWhileFailing(t *testing.T) {
+	w := &memWriter{fail: true}
+	h := newHook(t, &Options{
+		Filters:       []string{"#"},
+		Writer:        w,
+		FlushInterval: 10,
+		MaxBackoff:    10,
+	})
+	defer h.Stop()
+
+	h.OnPublished(&mqtt.Client{ID: "mochi"}, packets.Packet{TopicName: "a", Payload: []byte("1")})
+	time.Sleep(time.Millisecond * 400)
+
+	// retries at 10ms, then 20, 40, 80, and 160ms; not every flush interval.
+	w.Lock()
+	defer w.Unlock()
+	require.GreaterOrEqual(t, w.attempts, 3)
+	require.LessOrEqual(t, w.attempts, 7)
+}
+
+func TestDefaultParserJSON(t *testing.T) {
+	ts := time.Unix(10, 0)
+	points, err := DefaultParser("m")("a/b", []byte(`{"temp":21.5,"ok":true,"name":"x","nested":{"a":1}}`), ts)
+	require.NoError(t, err)
+	require.Len(t, points, 1)
+	require.Equal(t, "m", points[0].Measurement)
+	require.Equal(t, ts, points[0].Time)
+	require.Equal(t, map[string]any{"temp": 21.5, "ok": true, "name": "x"}, points[0].Fields)
+}
+
+func TestDefaultParserUnparseable(t *testing.T) {
+	_, err := DefaultParser("m")("a/b", []byte(`abc`), time.Now())
+	require.ErrorIs(t, err, ErrUnparseablePayload)
+
+	_, err = DefaultParser("m")("a/b", []byte(`{"nested":{"a":1}}`), time.Now())
+	require.ErrorIs(t, err, ErrUnparseablePayload)
+}
+
+func TestPointLineProtocol(t *testing.T) {
+	p := Point{
+		Measurement: "my measurement",
+		Tags:        map[string]string{"topic": "a/b c", "host": "x,y", "empty": ""},
+		Fields: map[string]any{
+			"f":   1.5,
+			"i":   int64(2),
+			"u":   uint64(3),
+			"b":   true,
+			"s":   `say "hi"`,
+			"int": 4,
+		},
+		Time: time.Unix(1, 5),
+	}
+
+	require.Equal(t, `my\ measurement,host=x\,y,topic=a/b\ c b=true,f=1.5,i=2i,int=4i,s="say \"hi\"",u=3u 1000000005`, p.LineProtocol())
+	require.Equal(t, "m v=1", Point{Measurement: "m", Fields: map[string]any{"v": 1.0}}.LineProtocol())
+}
+
+func TestInfluxWriter(t *testing.T) {
+	var body string
+	var auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		body = string(b)
+		auth = r.Header.Get("Authorization")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	w := NewInfluxWriter(srv.URL, "secret", nil)
+	err := w.Write(context.Background(), []Point{
+		{Measurement: "m", Fields: map[string]any{"v": 1.0}},
+		{Measurement: "m", Fields: map[string]any{"v": 2.0}},
+	})
+	require.NoError(t, err)
+	require.Equal(t, "m v=1\nm v=2\n", body)
+	require.Equal(t, "Token secret", auth)
+}
+
+func TestInfluxWriterError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad line", http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	w := NewInfluxWriter(srv.URL, "", nil)
+	err := w.Write(context.Background(), []Point{{Measurement: "m", Fields: map[string]any{"v": 1.0}}})
+	require.Error(t, err)
+	require.True(t, strings.Contains(err.Error(), "bad line"))
+}
@@ -0,0 +1,347 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+// Package vault provides a hook which loads listener TLS certificates and auth ledger
+// rules from the KV version 2 secrets engine of a HashiCorp Vault server, and refreshes
+// them in the background, so secrets never need to be written to disk.
+package vault
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+)
+
+const (
+	defaultMount           = "secret"         // the default mount path of the kv secrets engine
+	defaultRefreshInterval = 300              // the default number of seconds between refreshes
+	defaultTimeout         = 10 * time.Second // the default timeout of requests to vault
+
+	FieldCertificate = "certificate" // the secret field holding the PEM encoded certificate chain
+	FieldPrivateKey  = "private_key" // the secret field holding the PEM encoded private key
+	FieldLedger      = "ledger"      // the secret field holding the JSON or YAML auth ledger
+)
+
+var (
+	// ErrAddressRequired indicates the hook was initialised without a vault address.
+	ErrAddressRequired = errors.New("vault address required")
+
+	// ErrPathRequired indicates the hook was initialised without any secrets to load.
+	ErrPathRequired = errors.New("vault requires a certificate or ledger path")
+
+	// ErrNoCertificate indicates a certificate was requested before one had been loaded.
+	ErrNoCertificate = errors.New("no certificate loaded from vault")
+
+	// ErrFieldMissing indicates a secret did not contain an expected string field.
+	ErrFieldMissing = errors.New("vault secret field missing")
+
+	// ErrRequestFailed indicates vault responded to a request with an error status.
+	ErrRequestFailed = errors.New("vault request failed")
+)
+
+// Options contains configuration settings for the vault hook.
+type Options struct {
+	Address         string       `yaml:"address" json:"address"`                   // the address of the vault server; defaults to VAULT_ADDR
+	Token           string       `yaml:"token" json:"token"`                       // the vault token; defaults to VAULT_TOKEN
+	Namespace       string       `yaml:"namespace" json:"namespace"`               // the vault enterprise namespace, if any
+	Mount           string       `yaml:"mount" json:"mount"`                       // the mount path of the kv v2 secrets engine; defaults to secret
+	CertificatePath string       `yaml:"certificate_path" json:"certificate_path"` // the path of a secret with certificate and private_key fields
+	LedgerPath      string       `yaml:"ledger_path" json:"ledger_path"`           // the path of a secret with a ledger field
+	RefreshInterval int64        `yaml:"refresh_interval" json:"refresh_interval"` // seconds between refreshes of the secrets
+	RenewToken      bool         `yaml:"renew_token" json:"renew_token"`           // renew the lease of the token on each refresh
+	HTTPClient      *http.Client `yaml:"-" json:"-"`                               // the client used for requests to vault
+}
+
+// Hook loads a listener certificate and auth ledger rules from vault. Use TLSConfig as
+// the TLSConfig of listeners, and Ledger as the Ledger of the auth hook. Secrets are
+// refreshed in the background, and the existing certificate and rules are kept if a
+// refresh fails. Certificates are served to new connections as soon as they are loaded,
+// and rules apply to all subsequent connections and ACL checks.
+type Hook struct {
+	mqtt.HookBase
+	config *Options         // hook configuration
+	ledger *auth.Ledger     // the auth ledger loaded from vault
+	cert   *tls.Certificate // the certificate loaded from vault
+	cancel chan struct{}    // closed to stop refreshing
+	sync.RWMutex
+}
+
+// ID returns the id of the hook.
+func (h *Hook) ID() string {
+	return "vault"
+}
+
+// Init configures the hook and loads the secrets from vault. An error is returned if
+// the secrets cannot be loaded.
+func (h *Hook) Init(config any) error {
+	if _, ok := config.(*Options); !ok && config != nil {
+		return mqtt.ErrInvalidConfigType
+	}
+
+	if config == nil {
+		config = new(Options)
+	}
+
+	h.config = config.(*Options)
+	if h.config.Address == "" {
+		h.config.Address = os.Getenv("VAULT_ADDR")
+	}
+
+	if h.config.Token == "" {
+		h.config.Token = os.Getenv("VAULT_TOKEN")
+	}
+
+	if h.config.Address == "" {
+		return ErrAddressRequired
+	}
+
+	if h.config.CertificatePath == "" && h.config.LedgerPath == "" {
+		return ErrPathRequired
+	}
+
+	if h.config.Mount == "" {
+		h.config.Mount = defaultMount
+	}
+
+	if h.config.RefreshInterval <= 0 {
+		h.config.RefreshInterval = defaultRefreshInterval
+	}
+
+	if h.config.HTTPClient == nil {
+		h.config.HTTPClient = &http.Client{Timeout: defaultTimeout}
+	}
+
+	h.ledger = &auth.Ledger{
+		Auth: auth.AuthRules{},
+		ACL:  auth.ACLRules{},
+	}
+
+	if err := h.Refresh(); err != nil {
+		return err
+	}
+
+	h.cancel = make(chan struct{})
+	go h.run(h.cancel)
+
+	return nil
+}
+
+// Stop stops refreshing the secrets.
+func (h *Hook) Stop() error {
+	h.Lock()
+	defer h.Unlock()
+	if h.cancel != nil {
+		close(h.cancel)
+		h.cancel = nil
+	}
+
+	return nil
+}
+
+// Ledger returns the auth ledger, which is updated in place whenever the rules are refreshed.
+func (h *Hook) Ledger() *auth.Ledger {
+	return h.ledger
+}
+
+// Certificate returns the current certificate, or nil if no certificate has been loaded.
+func (h *Hook) Certificate() *tls.Certificate {
+	h.RLock()
+	defer h.RUnlock()
+	return h.cert
+}
+
+// GetCertificate returns the current certificate for a TLS handshake.
+func (h *Hook) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cert := h.Certificate()
+	if cert == nil {
+		return nil, ErrNoCertificate
+	}
+
+	return cert, nil
+}
+
+// TLSConfig returns a tls.Config which serves the current certificate.
+func (h *Hook) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: h.GetCertificate,
+	}
+}
+
+// Refresh renews the token if configured, and reloads the certificate and ledger rules
+// from vault. If a secret cannot be loaded, the existing value is kept.
+func (h *Hook) Refresh() error {
+	if h.config.RenewToken {
+		if err := h.request(http.MethodPost, "auth/token/renew-self", nil); err != nil {
+			h.Log.Error("failed to renew vault token", "error", err)
+			return err
+		}
+	}
+
+	if h.config.CertificatePath != "" {
+		if err := h.loadCertificate(); err != nil {
+			h.Log.Error("failed to load certificate from vault", "error", err, "path", h.config.CertificatePath)
+			return err
+		}
+	}
+
+	if h.config.LedgerPath != "" {
+		if err := h.loadLedger(); err != nil {
+			h.Log.Error("failed to load auth ledger from vault", "error", err, "path", h.config.LedgerPath)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// loadCertificate reads and parses the certificate secret.
+func (h *Hook) loadCertificate() error {
+	data, err := h.read(h.config.CertificatePath)
+	if err != nil {
+		return err
+	}
+
+	certPEM, err := field(data, FieldCertificate)
+	if err != nil {
+		return err
+	}
+
+	keyPEM, err := field(data, FieldPrivateKey)
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.X509KeyPair([]byte(certPEM), []byte(keyPEM))
+	if err != nil {
+		return err
+	}
+
+	h.Lock()
+	h.cert = &cert
+	h.Unlock()
+
+	return nil
+}
+
+// loadLedger reads and parses the ledger secret, replacing the rules of the ledger.
+func (h *Hook) loadLedger() error {
+	data, err := h.read(h.config.LedgerPath)
+	if err != nil {
+		return err
+	}
+
+	rules, err := field(data, FieldLedger)
+	if err != nil {
+		return err
+	}
+
+	ln := new(auth.Ledger)
+	if err := ln.Unmarshal([]byte(rules)); err != nil {
+		return err
+	}
+
+	h.ledger.Update(ln)
+	h.Log.Info("loaded auth rules from vault",
+		"path", h.config.LedgerPath,
+		"authentication", len(ln.Auth),
+		"acl", len(ln.ACL))
+
+	return nil
+}
+
+// read returns the fields of the latest version of a kv v2 secret.
+func (h *Hook) read(path string) (map[string]any, error) {
+	var res struct {
+		Data struct {
+			Data map[string]any `json:"data"`
+		} `json:"data"`
+	}
+
+	if err := h.request(http.MethodGet, h.config.Mount+"/data/"+strings.TrimPrefix(path, "/"), &res); err != nil {
+		return nil, err
+	}
+
+	return res.Data.Data, nil
+}
+
+// request sends a request to the vault api, decoding the response into v if it is not nil.
+func (h *Hook) request(method, path string, v any) error {
+	u, err := url.JoinPath(h.config.Address, "v1", path)
+	if err != nil {
+		return err
+	}
+
+	var body io.Reader
+	if method == http.MethodPost {
+		body = bytes.NewReader([]byte("{}"))
+	}
+
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("X-Vault-Token", h.config.Token)
+	if h.config.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", h.config.Namespace)
+	}
+
+	resp, err := h.config.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var res struct {
+			Errors []string `json:"errors"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&res)
+		return fmt.Errorf("%w: %s %s: %s", ErrRequestFailed, method, path, strings.Join(append([]string{resp.Status}, res.Errors...), ", "))
+	}
+
+	if v == nil {
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// run refreshes the secrets at the refresh interval until cancelled.
+func (h *Hook) run(cancel chan struct{}) {
+	ticker := time.NewTicker(time.Duration(h.config.RefreshInterval) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-cancel:
+			return
+		case <-ticker.C:
+			_ = h.Refresh()
+		}
+	}
+}
+
+// field returns a string field of a secret.
+func field(data map[string]any, key string) (string, error) {
+	s, ok := data[key].(string)
+	if !ok || s == "" {
+		return "", fmt.Errorf("%w: %s", ErrFieldMissing, key)
+	}
+
+	return s, nil
+}
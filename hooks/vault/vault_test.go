@@ -0,0 +1,262 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package vault
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/packets"
+
+	"github.com/stretchr/testify/require"
+)
+
+var logger = slog.New(slog.NewTextHandler(os.Stdout, nil))
+
+const token = "mochi-token"
+
+// testVault is a fake vault server which serves kv v2 secrets.
+type testVault struct {
+	*httptest.Server
+	secrets  map[string]map[string]any
+	renewals atomic.Int64
+	sync.Mutex
+}
+
+// newTestVault returns a fake vault server serving secrets from the secret mount.
+func newTestVault(t *testing.T) *testVault {
+	v := &testVault{secrets: map[string]map[string]any{}}
+	v.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != token {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"errors":["permission denied"]}`))
+			return
+		}
+
+		if r.Method == http.MethodPost && r.URL.Path == "/v1/auth/token/renew-self" {
+			v.renewals.Add(1)
+			_, _ = w.Write([]byte(`{"auth":{"renewable":true}}`))
+			return
+		}
+
+		v.Lock()
+		data, ok := v.secrets[r.URL.Path]
+		v.Unlock()
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"errors":[]}`))
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{"data": data}})
+	}))
+	t.Cleanup(v.Close)
+	return v
+}
+
+// set stores a secret at a path of the secret mount.
+func (v *testVault) set(path string, data map[string]any) {
+	v.Lock()
+	defer v.Unlock()
+	v.secrets["/v1/secret/data/"+path] = data
+}
+
+// newKeyPair returns a PEM encoded self-signed certificate and private key.
+func newKeyPair(t *testing.T, name string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	kder, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kder}))
+}
+
+func newHook(t *testing.T, opts *Options) (*Hook, error) {
+	h := new(Hook)
+	h.SetOpts(logger, nil)
+	err := h.Init(opts)
+	t.Cleanup(func() { _ = h.Stop() })
+	return h, err
+}
+
+func TestID(t *testing.T) {
+	h := new(Hook)
+	require.Equal(t, "vault", h.ID())
+}
+
+func TestInitBadConfig(t *testing.T) {
+	h := new(Hook)
+	h.SetOpts(logger, nil)
+	err := h.Init(map[string]any{})
+	require.ErrorIs(t, err, mqtt.ErrInvalidConfigType)
+}
+
+func TestInitRequired(t *testing.T) {
+	t.Setenv("VAULT_ADDR", "")
+	_, err := newHook(t, &Options{})
+	require.ErrorIs(t, err, ErrAddressRequired)
+
+	_, err = newHook(t, &Options{Address: "http://127.0.0.1:8200"})
+	require.ErrorIs(t, err, ErrPathRequired)
+}
+
+func TestInitDefaults(t *testing.T) {
+	v := newTestVault(t)
+	v.set("mqtt/ledger", map[string]any{"ledger": `{"auth":[{"allow":true}]}`})
+	t.Setenv("VAULT_ADDR", v.URL)
+	t.Setenv("VAULT_TOKEN", token)
+
+	h, err := newHook(t, &Options{LedgerPath: "mqtt/ledger"})
+	require.NoError(t, err)
+	require.Equal(t, v.URL, h.config.Address)
+	require.Equal(t, token, h.config.Token)
+	require.Equal(t, defaultMount, h.config.Mount)
+	require.Equal(t, int64(defaultRefreshInterval), h.config.RefreshInterval)
+	require.Equal(t, defaultTimeout, h.config.HTTPClient.Timeout)
+}
+
+func TestInitLoadFailed(t *testing.T) {
+	v := newTestVault(t)
+
+	_, err := newHook(t, &Options{Address: v.URL, Token: token, LedgerPath: "mqtt/missing"})
+	require.ErrorIs(t, err, ErrRequestFailed)
+
+	v.set("mqtt/ledger", map[string]any{"ledger": `{"auth":[{"allow":true}]}`})
+	_, err = newHook(t, &Options{Address: v.URL, Token: "wrong", LedgerPath: "mqtt/ledger"})
+	require.ErrorIs(t, err, ErrRequestFailed)
+	require.Contains(t, err.Error(), "permission denied")
+
+	v.set("mqtt/tls", map[string]any{"certificate": "not a certificate"})
+	_, err = newHook(t, &Options{Address: v.URL, Token: token, CertificatePath: "mqtt/tls"})
+	require.ErrorIs(t, err, ErrFieldMissing)
+}
+
+func TestCertificate(t *testing.T) {
+	v := newTestVault(t)
+	certPEM, keyPEM := newKeyPair(t, "mochi")
+	v.set("mqtt/tls", map[string]any{"certificate": certPEM, "private_key": keyPEM})
+
+	h := new(Hook)
+	_, err := h.GetCertificate(nil)
+	require.ErrorIs(t, err, ErrNoCertificate)
+
+	h, err = newHook(t, &Options{Address: v.URL, Token: token, CertificatePath: "mqtt/tls"})
+	require.NoError(t, err)
+
+	cert, err := h.TLSConfig().GetCertificate(nil)
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+	require.Equal(t, "mochi", leaf.Subject.CommonName)
+
+	// a refreshed certificate is served to new connections.
+	certPEM, keyPEM = newKeyPair(t, "mochi-renewed")
+	v.set("mqtt/tls", map[string]any{"certificate": certPEM, "private_key": keyPEM})
+	require.NoError(t, h.Refresh())
+	leaf, err = x509.ParseCertificate(h.Certificate().Certificate[0])
+	require.NoError(t, err)
+	require.Equal(t, "mochi-renewed", leaf.Subject.CommonName)
+
+	// a failed refresh keeps the existing certificate.
+	v.set("mqtt/tls", map[string]any{"certificate": "invalid", "private_key": "invalid"})
+	require.Error(t, h.Refresh())
+	require.Equal(t, leaf.Raw, h.Certificate().Certificate[0])
+}
+
+func TestCertificateHandshake(t *testing.T) {
+	v := newTestVault(t)
+	certPEM, keyPEM := newKeyPair(t, "mochi")
+	v.set("mqtt/tls", map[string]any{"certificate": certPEM, "private_key": keyPEM})
+
+	h, err := newHook(t, &Options{Address: v.URL, Token: token, CertificatePath: "mqtt/tls"})
+	require.NoError(t, err)
+
+	sc, cc := net.Pipe()
+	defer sc.Close()
+	defer cc.Close()
+
+	go func() {
+		_ = tls.Server(sc, h.TLSConfig()).Handshake()
+	}()
+
+	client := tls.Client(cc, &tls.Config{InsecureSkipVerify: true}) // #nosec G402
+	require.NoError(t, client.Handshake())
+	require.Equal(t, "mochi", client.ConnectionState().PeerCertificates[0].Subject.CommonName)
+}
+
+func TestLedger(t *testing.T) {
+	v := newTestVault(t)
+	v.set("mqtt/ledger", map[string]any{"ledger": `{"auth":[{"username":"mochi","password":"melon","allow":true}]}`})
+
+	h, err := newHook(t, &Options{Address: v.URL, Token: token, LedgerPath: "/mqtt/ledger", RenewToken: true})
+	require.NoError(t, err)
+	require.Equal(t, int64(1), v.renewals.Load())
+
+	pk := packets.Packet{Connect: packets.ConnectParams{Username: []byte("mochi"), Password: []byte("melon")}}
+	cl := &mqtt.Client{Properties: mqtt.ClientProperties{Username: []byte("mochi")}}
+	_, ok := h.Ledger().AuthOk(cl, pk)
+	require.True(t, ok)
+
+	// yaml rules are also accepted, and replace the rules of the same ledger.
+	ledger := h.Ledger()
+	v.set("mqtt/ledger", map[string]any{"ledger": "auth:\n  - username: mochi\n    password: melon2\n    allow: true\n"})
+	require.NoError(t, h.Refresh())
+	require.Equal(t, int64(2), v.renewals.Load())
+	require.Same(t, ledger, h.Ledger())
+	_, ok = h.Ledger().AuthOk(cl, pk)
+	require.False(t, ok)
+
+	// a failed refresh keeps the existing rules.
+	v.set("mqtt/ledger", map[string]any{"ledger": 42})
+	require.ErrorIs(t, h.Refresh(), ErrFieldMissing)
+	require.Len(t, h.Ledger().Auth, 1)
+}
+
+func TestRefreshInBackground(t *testing.T) {
+	v := newTestVault(t)
+	v.set("mqtt/ledger", map[string]any{"ledger": `{"auth":[{"allow":true}]}`})
+
+	h, err := newHook(t, &Options{Address: v.URL, Token: token, LedgerPath: "mqtt/ledger", RefreshInterval: 1})
+	require.NoError(t, err)
+
+	v.set("mqtt/ledger", map[string]any{"ledger": `{"auth":[{"allow":true},{"allow":false}]}`})
+	require.Eventually(t, func() bool {
+		h.Ledger().Lock()
+		defer h.Ledger().Unlock()
+		return len(h.Ledger().Auth) == 2
+	}, 3*time.Second, 50*time.Millisecond)
+
+	require.NoError(t, h.Stop())
+	require.Nil(t, h.cancel)
+	require.NoError(t, h.Stop())
+}
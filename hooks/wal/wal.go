@@ -0,0 +1,394 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+// Package wal provides a write-ahead log hook which records inbound QoS 1 and 2
+// publishes before they are acknowledged, and replays any which were not delivered
+// to subscribers when the broker restarts after a crash.
+package wal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/storage"
+	"github.com/mochi-mqtt/server/v2/packets"
+)
+
+const (
+	defaultPath = ".wal" // the default file path for the log
+	replayID    = "wal"  // the id of the inline client used to replay messages
+
+	opPublish byte = 1 // a record of a publish which has been received
+	opAck     byte = 2 // a record of a publish which has been delivered
+)
+
+var (
+	// ErrServerRequired indicates the hook was initialised without a server to replay messages to.
+	ErrServerRequired = errors.New("wal requires a server")
+)
+
+// Options contains configuration settings for the write-ahead log.
+type Options struct {
+	Server *mqtt.Server `yaml:"-" json:"-"`             // the server to replay messages to
+	Path   string       `yaml:"path" json:"path"`       // the path to the log file
+	NoSync bool         `yaml:"no_sync" json:"no_sync"` // skip fsync after each write; faster, but records may be lost on power failure
+}
+
+// record is a single entry in the log.
+type record struct {
+	Op      byte             `json:"op"`                // either opPublish or opAck
+	Seq     uint64           `json:"seq"`               // the sequence number of the publish
+	Message *storage.Message `json:"message,omitempty"` // the published message, for opPublish records
+}
+
+// Hook is a write-ahead log hook which ensures acknowledged QoS 1 and 2 messages are
+// not lost if the broker stops before they have been delivered to subscribers. The hook
+// should be added after any hooks which may reject or modify publishes in OnPublish.
+type Hook struct {
+	mqtt.HookBase
+	config   *Options          // hook configuration
+	file     *os.File          // the open log file
+	seq      uint64            // the last issued sequence number
+	inflight map[string]uint64 // sequence numbers of logged publishes keyed on client and packet id
+	pending  []record          // logged publishes which were not delivered before the last stop
+	cl       *mqtt.Client      // an inline client used to replay pending messages
+	sync.Mutex
+}
+
+// ID returns the id of the hook.
+func (h *Hook) ID() string {
+	return "wal"
+}
+
+// Provides indicates which hook methods this hook provides.
+func (h *Hook) Provides(b byte) bool {
+	return bytes.Contains([]byte{
+		mqtt.OnStarted,
+		mqtt.OnPublish,
+		mqtt.OnPublished,
+		mqtt.OnCompact,
+	}, []byte{b})
+}
+
+// Init opens the log file and loads any publishes which were not delivered.
+func (h *Hook) Init(config any) error {
+	if _, ok := config.(*Options); !ok && config != nil {
+		return mqtt.ErrInvalidConfigType
+	}
+
+	if config == nil {
+		config = new(Options)
+	}
+
+	h.config = config.(*Options)
+	if h.config.Server == nil {
+		return ErrServerRequired
+	}
+
+	if h.config.Path == "" {
+		h.config.Path = defaultPath
+	}
+
+	h.inflight = map[string]uint64{}
+
+	pending, seq, err := readLog(h.config.Path)
+	if err != nil {
+		return fmt.Errorf("failed to read log: %w", err)
+	}
+
+	h.pending = pending
+	h.seq = seq
+
+	if err := h.rewrite(h.pending); err != nil {
+		return fmt.Errorf("failed to rewrite log: %w", err)
+	}
+
+	h.cl = h.config.Server.NewClient(nil, mqtt.LocalListener, replayID, true)
+	h.cl.Properties.ProtocolVersion = 5
+
+	return nil
+}
+
+// Stop closes the log file.
+func (h *Hook) Stop() error {
+	h.Lock()
+	defer h.Unlock()
+	if h.file == nil {
+		return nil
+	}
+
+	err := h.file.Close()
+	h.file = nil
+	return err
+}
+
+// Len returns the number of logged publishes which have not yet been delivered.
+func (h *Hook) Len() int {
+	h.Lock()
+	defer h.Unlock()
+	return len(h.inflight) + len(h.pending)
+}
+
+// OnStarted replays any publishes which were logged but not delivered before the
+// server last stopped.
+func (h *Hook) OnStarted() {
+	h.Lock()
+	pending := h.pending
+	h.Unlock()
+
+	if len(pending) == 0 {
+		return
+	}
+
+	h.Log.Info("replaying undelivered messages", "messages", len(pending))
+	for _, r := range pending {
+		pk := r.Message.ToPacket()
+		pk.FixedHeader.Type = packets.Publish
+		pk.FixedHeader.Dup = false
+		pk.PacketID = uint16(pk.FixedHeader.Qos) // the inbound qos flow is never processed for inline clients.
+		if err := h.config.Server.InjectPacket(h.cl, pk); err != nil {
+			h.Log.Error("failed to replay message", "error", err, "topic", pk.TopicName)
+			continue
+		}
+
+		h.Lock()
+		err := h.write(record{Op: opAck, Seq: r.Seq}, false)
+		h.Unlock()
+		if err != nil {
+			h.Log.Error("failed to log replayed message", "error", err, "topic", pk.TopicName)
+		}
+	}
+
+	h.Lock()
+	h.pending = nil
+	h.Unlock()
+}
+
+// OnPublish logs inbound QoS 1 and 2 publishes before they are acknowledged. If the
+// publish cannot be logged it is rejected, so the client will not consider it delivered;
+// MQTT v3 clients, which cannot be sent a failing ack, are disconnected.
+func (h *Hook) OnPublish(cl *mqtt.Client, pk packets.Packet) (packets.Packet, error) {
+	if pk.FixedHeader.Qos == 0 || cl.Net.Inline {
+		return pk, nil
+	}
+
+	props := pk.Properties.Copy(false)
+	msg := &storage.Message{
+		FixedHeader: pk.FixedHeader,
+		TopicName:   pk.TopicName,
+		Payload:     pk.Payload,
+		Created:     pk.Created,
+		Origin:      pk.Origin,
+		Properties: storage.MessageProperties{
+			PayloadFormat:         props.PayloadFormat,
+			PayloadFormatFlag:     props.PayloadFormatFlag,
+			MessageExpiryInterval: props.MessageExpiryInterval,
+			ContentType:           props.ContentType,
+			ResponseTopic:         props.ResponseTopic,
+			CorrelationData:       props.CorrelationData,
+			User:                  props.User,
+		},
+	}
+
+	h.Lock()
+	defer h.Unlock()
+	h.seq++
+	err := h.write(record{Op: opPublish, Seq: h.seq, Message: msg}, !h.config.NoSync)
+	if err != nil {
+		h.Log.Error("failed to log publish", "error", err, "client", cl.ID, "topic", pk.TopicName)
+		if cl.Properties.ProtocolVersion == 5 {
+			return pk, packets.ErrUnspecifiedError // acknowledged with a failing puback or pubrec
+		}
+
+		// MQTT v3 has no failing acks, and an unacknowledged publish would be left
+		// pending on the client, so the client is disconnected to resend it later.
+		cl.Stop(packets.ErrUnspecifiedError)
+		return pk, packets.ErrRejectPacket
+	}
+
+	h.inflight[inflightKey(cl, pk)] = h.seq
+	return pk, nil
+}
+
+// OnPublished records that a logged publish has been delivered to subscribers.
+func (h *Hook) OnPublished(cl *mqtt.Client, pk packets.Packet) {
+	if pk.FixedHeader.Qos == 0 || cl.Net.Inline {
+		return
+	}
+
+	h.Lock()
+	defer h.Unlock()
+	key := inflightKey(cl, pk)
+	seq, ok := h.inflight[key]
+	if !ok {
+		return
+	}
+
+	delete(h.inflight, key)
+
+	// Acks are not synced, as losing one only results in a duplicate delivery on replay.
+	if err := h.write(record{Op: opAck, Seq: seq}, false); err != nil {
+		h.Log.Error("failed to log delivery", "error", err, "client", cl.ID, "topic", pk.TopicName)
+	}
+}
+
+// OnCompact rewrites the log to contain only publishes which have not been delivered.
+func (h *Hook) OnCompact() error {
+	h.Lock()
+	defer h.Unlock()
+	pending, _, err := readLog(h.config.Path)
+	if err != nil {
+		return err
+	}
+
+	active := map[uint64]bool{}
+	for _, seq := range h.inflight {
+		active[seq] = true
+	}
+
+	for _, r := range h.pending {
+		active[r.Seq] = true
+	}
+
+	records := make([]record, 0, len(active))
+	for _, r := range pending {
+		if active[r.Seq] {
+			records = append(records, r)
+		}
+	}
+
+	return h.rewrite(records)
+}
+
+// write appends a record to the log, optionally syncing it to disk. The caller must
+// hold the lock.
+func (h *Hook) write(r record, sync bool) error {
+	if h.file == nil {
+		return storage.ErrDBFileNotOpen
+	}
+
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	if _, err := h.file.Write(append(b, '\n')); err != nil {
+		return err
+	}
+
+	if sync {
+		return h.file.Sync()
+	}
+
+	return nil
+}
+
+// rewrite atomically replaces the log with the given records and reopens it for
+// appending. The caller must hold the lock, or be initialising the hook.
+func (h *Hook) rewrite(records []record) error {
+	tmp := h.config.Path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	for _, r := range records {
+		b, err := json.Marshal(r)
+		if err != nil {
+			_ = f.Close()
+			return err
+		}
+		_, _ = w.Write(append(b, '\n'))
+	}
+
+	if err := w.Flush(); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if h.file != nil {
+		_ = h.file.Close()
+	}
+
+	if err := os.Rename(tmp, h.config.Path); err != nil {
+		return err
+	}
+
+	h.file, err = os.OpenFile(h.config.Path, os.O_APPEND|os.O_WRONLY, 0600)
+	return err
+}
+
+// readLog reads the log at path, returning the publish records which have not been
+// acknowledged in the order they were logged, and the highest sequence number.
+func readLog(path string) ([]record, uint64, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, 0, nil
+	} else if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	var seq uint64
+	var order []uint64
+	published := map[uint64]record{}
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 512*1024*1024)
+	for scanner.Scan() {
+		var r record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			break // a torn final write; records after it cannot be trusted
+		}
+
+		if r.Seq > seq {
+			seq = r.Seq
+		}
+
+		switch r.Op {
+		case opPublish:
+			if r.Message != nil {
+				published[r.Seq] = r
+				order = append(order, r.Seq)
+			}
+		case opAck:
+			delete(published, r.Seq)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	pending := make([]record, 0, len(published))
+	for _, s := range order {
+		if r, ok := published[s]; ok {
+			pending = append(pending, r)
+		}
+	}
+
+	return pending, seq, nil
+}
+
+// inflightKey returns a key identifying an inbound publish by client and packet id.
+func inflightKey(cl *mqtt.Client, pk packets.Packet) string {
+	return cl.ID + ":" + strconv.Itoa(int(pk.PacketID))
+}
@@ -0,0 +1,260 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package wal
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/packets"
+
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	logger = slog.New(slog.NewTextHandler(os.Stdout, nil))
+
+	client = &mqtt.Client{ID: "mochi"}
+
+	pkQos1 = packets.Packet{
+		FixedHeader: packets.FixedHeader{Type: packets.Publish, Qos: 1},
+		PacketID:    7,
+		TopicName:   "a/b/c",
+		Payload:     []byte("hello"),
+	}
+)
+
+func newServer(t *testing.T) *mqtt.Server {
+	s := mqtt.New(&mqtt.Options{
+		Logger:       logger,
+		InlineClient: true,
+	})
+	require.NoError(t, s.AddHook(new(auth.AllowHook), nil))
+	return s
+}
+
+func newHook(t *testing.T, s *mqtt.Server, path string) *Hook {
+	h := new(Hook)
+	h.SetOpts(logger, nil)
+	err := h.Init(&Options{
+		Server: s,
+		Path:   path,
+	})
+	require.NoError(t, err)
+	return h
+}
+
+func TestID(t *testing.T) {
+	h := new(Hook)
+	require.Equal(t, "wal", h.ID())
+}
+
+func TestProvides(t *testing.T) {
+	h := new(Hook)
+	require.True(t, h.Provides(mqtt.OnStarted))
+	require.True(t, h.Provides(mqtt.OnPublish))
+	require.True(t, h.Provides(mqtt.OnPublished))
+	require.True(t, h.Provides(mqtt.OnCompact))
+	require.False(t, h.Provides(mqtt.OnConnect))
+}
+
+func TestInitBadConfig(t *testing.T) {
+	h := new(Hook)
+	h.SetOpts(logger, nil)
+	err := h.Init(map[string]any{})
+	require.ErrorIs(t, err, mqtt.ErrInvalidConfigType)
+}
+
+func TestInitNoServer(t *testing.T) {
+	h := new(Hook)
+	h.SetOpts(logger, nil)
+	err := h.Init(nil)
+	require.ErrorIs(t, err, ErrServerRequired)
+}
+
+func TestInitUseDefaults(t *testing.T) {
+	h := new(Hook)
+	h.SetOpts(logger, nil)
+	err := h.Init(&Options{Server: newServer(t)})
+	require.NoError(t, err)
+	defer os.Remove(defaultPath)
+	defer h.Stop()
+
+	require.Equal(t, defaultPath, h.config.Path)
+	require.False(t, h.config.NoSync)
+}
+
+func TestOnPublishThenOnPublished(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal")
+	h := newHook(t, newServer(t), path)
+	defer h.Stop()
+
+	pk, err := h.OnPublish(client, pkQos1)
+	require.NoError(t, err)
+	require.Equal(t, pkQos1, pk)
+	require.Equal(t, 1, h.Len())
+
+	pending, seq, err := readLog(path)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	require.Equal(t, uint64(1), seq)
+	require.Equal(t, "a/b/c", pending[0].Message.TopicName)
+	require.Equal(t, []byte("hello"), pending[0].Message.Payload)
+
+	h.OnPublished(client, pkQos1)
+	require.Equal(t, 0, h.Len())
+
+	pending, _, err = readLog(path)
+	require.NoError(t, err)
+	require.Empty(t, pending)
+}
+
+func TestOnPublishSkipsQos0AndInline(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal")
+	h := newHook(t, newServer(t), path)
+	defer h.Stop()
+
+	pk := pkQos1
+	pk.FixedHeader.Qos = 0
+	_, err := h.OnPublish(client, pk)
+	require.NoError(t, err)
+
+	_, err = h.OnPublish(h.cl, pkQos1)
+	require.NoError(t, err)
+	require.Equal(t, 0, h.Len())
+
+	h.OnPublished(client, pk)
+	h.OnPublished(client, pkQos1) // not logged
+	require.Equal(t, 0, h.Len())
+}
+
+func TestOnPublishClosedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal")
+	h := newHook(t, newServer(t), path)
+	require.NoError(t, h.Stop())
+	require.NoError(t, h.Stop())
+
+	v5 := &mqtt.Client{ID: "mochi"}
+	v5.Properties.ProtocolVersion = 5
+	_, err := h.OnPublish(v5, pkQos1)
+	require.ErrorIs(t, err, packets.ErrUnspecifiedError)
+	require.NoError(t, v5.StopCause())
+
+	// v3 clients cannot be sent a failing ack, so they are disconnected.
+	v3 := &mqtt.Client{ID: "mochi"}
+	v3.Properties.ProtocolVersion = 4
+	_, err = h.OnPublish(v3, pkQos1)
+	require.ErrorIs(t, err, packets.ErrRejectPacket)
+	require.ErrorIs(t, v3.StopCause(), packets.ErrUnspecifiedError)
+}
+
+func TestReplayAfterRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal")
+	h := newHook(t, newServer(t), path)
+	_, err := h.OnPublish(client, pkQos1)
+	require.NoError(t, err)
+	pk2 := pkQos1
+	pk2.PacketID = 8
+	pk2.TopicName = "a/b/d"
+	_, err = h.OnPublish(client, pk2)
+	require.NoError(t, err)
+	h.OnPublished(client, pk2)
+	require.NoError(t, h.Stop()) // simulate a stop before pkQos1 was delivered
+
+	s := newServer(t)
+	recv := make(chan packets.Packet, 2)
+	err = s.Subscribe("a/b/#", 1, func(cl *mqtt.Client, sub packets.Subscription, pk packets.Packet) {
+		recv <- pk
+	})
+	require.NoError(t, err)
+
+	h = newHook(t, s, path)
+	defer h.Stop()
+	require.Equal(t, 1, h.Len())
+	require.Equal(t, uint64(2), h.seq)
+
+	h.OnStarted()
+	require.Equal(t, 0, h.Len())
+
+	select {
+	case pk := <-recv:
+		require.Equal(t, "a/b/c", pk.TopicName)
+		require.Equal(t, []byte("hello"), pk.Payload)
+	case <-time.After(time.Second):
+		require.Fail(t, "replayed message not received")
+	}
+
+	select {
+	case pk := <-recv:
+		require.Fail(t, "unexpected message replayed", pk.TopicName)
+	default:
+	}
+
+	pending, _, err := readLog(path)
+	require.NoError(t, err)
+	require.Empty(t, pending)
+}
+
+func TestOnCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal")
+	h := newHook(t, newServer(t), path)
+	defer h.Stop()
+
+	for i := uint16(1); i <= 3; i++ {
+		pk := pkQos1
+		pk.PacketID = i
+		_, err := h.OnPublish(client, pk)
+		require.NoError(t, err)
+		if i < 3 {
+			h.OnPublished(client, pk)
+		}
+	}
+
+	before, err := os.Stat(path)
+	require.NoError(t, err)
+
+	err = h.OnCompact()
+	require.NoError(t, err)
+
+	after, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Less(t, after.Size(), before.Size())
+
+	pending, _, err := readLog(path)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	require.Equal(t, uint64(3), pending[0].Seq)
+
+	// the log remains writable after compaction
+	pk := pkQos1
+	pk.PacketID = 3
+	h.OnPublished(client, pk)
+	pending, _, err = readLog(path)
+	require.NoError(t, err)
+	require.Empty(t, pending)
+}
+
+func TestReadLogTornWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal")
+	data := `{"op":1,"seq":1,"message":{"topic_name":"a/b/c"}}` + "\n" + `{"op":1,"seq":2,"mess`
+	require.NoError(t, os.WriteFile(path, []byte(data), 0600))
+
+	pending, seq, err := readLog(path)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	require.Equal(t, uint64(1), seq)
+}
+
+func TestReadLogNotExist(t *testing.T) {
+	pending, seq, err := readLog(filepath.Join(t.TempDir(), "missing"))
+	require.NoError(t, err)
+	require.Empty(t, pending)
+	require.Equal(t, uint64(0), seq)
+}
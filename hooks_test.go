@@ -55,6 +55,22 @@ func (h *modifiedHookBase) OnConnect(cl *Client, pk packets.Packet) error {
 		return errTestHook
 	}
 
+	return h.err
+}
+
+func (h *modifiedHookBase) OnCompact() error {
+	if h.fail {
+		return errTestHook
+	}
+
+	return nil
+}
+
+func (h *modifiedHookBase) OnClientForgotten(id string) error {
+	if h.fail {
+		return errTestHook
+	}
+
 	return nil
 }
 
@@ -170,6 +186,29 @@ func (h *modifiedHookBase) StoredSysInfo() (v storage.SystemInfo, err error) {
 	}, nil
 }
 
+func (h *modifiedHookBase) StoredBans() (v []storage.Ban, err error) {
+	if h.fail || h.failAt == 6 {
+		return v, errTestHook
+	}
+
+	return []storage.Ban{
+		{ID: "b1"},
+		{ID: "b2"},
+		{ID: "b3"},
+	}, nil
+}
+
+func (h *modifiedHookBase) StoredArchivedMessages(filter string, from, to int64) (v []storage.Message, err error) {
+	if h.fail || h.failAt == 7 {
+		return v, errTestHook
+	}
+
+	return []storage.Message{
+		{ID: "a1", TopicName: "a/b/c"},
+		{ID: "a2", TopicName: "a/b/d"},
+	}, nil
+}
+
 type providesCheckHook struct {
 	HookBase
 }
@@ -239,6 +278,7 @@ func TestHooksNonReturns(t *testing.T) {
 			h.OnSessionEstablish(cl, packets.Packet{})
 			h.OnSessionEstablished(cl, packets.Packet{})
 			h.OnDisconnect(cl, nil, false)
+			h.OnDisconnected(cl, DisconnectEvent{Reason: DisconnectClean})
 			h.OnPacketSent(cl, packets.Packet{}, []byte{})
 			h.OnPacketProcessed(cl, packets.Packet{}, nil)
 			h.OnSubscribed(cl, packets.Packet{}, []byte{1})
@@ -251,9 +291,15 @@ func TestHooksNonReturns(t *testing.T) {
 			h.OnQosComplete(cl, packets.Packet{})
 			h.OnQosDropped(cl, packets.Packet{})
 			h.OnPacketIDExhausted(cl, packets.Packet{})
+			h.OnInflightOverflow(cl, packets.Packet{})
 			h.OnWillSent(cl, packets.Packet{})
 			h.OnClientExpired(cl)
 			h.OnRetainedExpired("a/b/c")
+			h.OnBanned(Ban{ClientID: "mochi"})
+			h.OnUnbanned(Ban{ClientID: "mochi"})
+			h.OnPacket(cl, PacketInbound, []byte{})
+			h.OnRetainSet(cl, packets.Packet{})
+			h.OnRetainCleared(cl, packets.Packet{})
 
 			// on second iteration, check added hook methods
 			err := h.Add(new(modifiedHookBase), nil)
@@ -262,6 +308,86 @@ func TestHooksNonReturns(t *testing.T) {
 	}
 }
 
+func TestHooksOnCompact(t *testing.T) {
+	h := new(Hooks)
+	h.Log = logger
+
+	err := h.OnCompact()
+	require.NoError(t, err)
+
+	hook := new(modifiedHookBase)
+	err = h.Add(hook, nil)
+	require.NoError(t, err)
+
+	err = h.OnCompact()
+	require.NoError(t, err)
+
+	hook.fail = true
+	err = h.OnCompact()
+	require.ErrorIs(t, err, errTestHook)
+}
+
+func TestHooksOnClientForgotten(t *testing.T) {
+	h := new(Hooks)
+	h.Log = logger
+
+	err := h.OnClientForgotten("mochi")
+	require.NoError(t, err)
+
+	hook := new(modifiedHookBase)
+	err = h.Add(hook, nil)
+	require.NoError(t, err)
+
+	err = h.OnClientForgotten("mochi")
+	require.NoError(t, err)
+
+	hook.fail = true
+	err = h.OnClientForgotten("mochi")
+	require.ErrorIs(t, err, errTestHook)
+}
+
+func TestHooksOnError(t *testing.T) {
+	h := new(Hooks)
+	h.OnError(new(Client), errTestHook)
+
+	hook := new(panicHook)
+	err := h.Add(hook, nil)
+	require.NoError(t, err)
+
+	h.OnError(new(Client), errTestHook)
+	require.Equal(t, []error{errTestHook}, hook.Errors())
+}
+
+func TestHooksOnConnectNegotiate(t *testing.T) {
+	h := new(Hooks)
+	n := Negotiation{Keepalive: 30, SessionPresent: true, MaximumQos: 2}
+
+	nx, err := h.OnConnectNegotiate(new(Client), packets.Packet{}, n)
+	require.NoError(t, err)
+	require.Equal(t, n, nx)
+
+	err = h.Add(&negotiateHook{fn: func(cl *Client, pk packets.Packet, n Negotiation) (Negotiation, error) {
+		n.MaximumQos--
+		return n, nil
+	}}, nil)
+	require.NoError(t, err)
+
+	nx, err = h.OnConnectNegotiate(new(Client), packets.Packet{}, n)
+	require.NoError(t, err)
+	require.Equal(t, byte(1), nx.MaximumQos)
+
+	h = new(Hooks)
+	err = h.Add(&negotiateHook{fn: func(cl *Client, pk packets.Packet, n Negotiation) (Negotiation, error) {
+		n.MaximumQos = 0
+		return n, packets.ErrServerBusy
+	}}, nil)
+	require.NoError(t, err)
+
+	nx, err = h.OnConnectNegotiate(new(Client), packets.Packet{}, n)
+	require.ErrorIs(t, err, packets.ErrServerBusy)
+	require.Equal(t, n, nx)
+}
+
 func TestHooksOnConnectAuthenticate(t *testing.T) {
 	h := new(Hooks)
 
@@ -557,6 +683,50 @@ func TestHooksStoredSysInfo(t *testing.T) {
 	require.Equal(t, "", v.Info.Version)
 }
 
+func TestHooksStoredBans(t *testing.T) {
+	h := new(Hooks)
+	h.Log = logger
+
+	v, err := h.StoredBans()
+	require.NoError(t, err)
+	require.Len(t, v, 0)
+
+	hook := new(modifiedHookBase)
+	err = h.Add(hook, nil)
+	require.NoError(t, err)
+
+	v, err = h.StoredBans()
+	require.NoError(t, err)
+	require.Len(t, v, 3)
+
+	hook.fail = true
+	v, err = h.StoredBans()
+	require.Error(t, err)
+	require.Len(t, v, 0)
+}
+
+func TestHooksStoredArchivedMessages(t *testing.T) {
+	h := new(Hooks)
+	h.Log = logger
+
+	v, err := h.StoredArchivedMessages("a/b/#", 0, 0)
+	require.NoError(t, err)
+	require.Len(t, v, 0)
+
+	hook := new(modifiedHookBase)
+	err = h.Add(hook, nil)
+	require.NoError(t, err)
+
+	v, err = h.StoredArchivedMessages("a/b/#", 0, 0)
+	require.NoError(t, err)
+	require.Len(t, v, 2)
+
+	hook.fail = true
+	v, err = h.StoredArchivedMessages("a/b/#", 0, 0)
+	require.Error(t, err)
+	require.Len(t, v, 0)
+}
+
 func TestHookBaseID(t *testing.T) {
 	h := new(HookBase)
 	require.Equal(t, "base", h.ID())
@@ -665,3 +835,40 @@ func TestHookBaseStoreSysInfo(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, "", v.Version)
 }
+
+func TestHookBaseOnCompact(t *testing.T) {
+	h := new(HookBase)
+	require.NoError(t, h.OnCompact())
+}
+
+func TestHookBaseOnClientForgotten(t *testing.T) {
+	h := new(HookBase)
+	require.NoError(t, h.OnClientForgotten("mochi"))
+}
+
+func TestHookBaseOnError(t *testing.T) {
+	h := new(HookBase)
+	h.OnError(new(Client), errTestHook)
+}
+
+func TestHookBaseOnConnectNegotiate(t *testing.T) {
+	h := new(HookBase)
+	n := Negotiation{Keepalive: 30, MaximumQos: 1}
+	nx, err := h.OnConnectNegotiate(new(Client), packets.Packet{}, n)
+	require.NoError(t, err)
+	require.Equal(t, n, nx)
+}
+
+func TestHookBaseStoredBans(t *testing.T) {
+	h := new(HookBase)
+	v, err := h.StoredBans()
+	require.NoError(t, err)
+	require.Empty(t, v)
+}
+
+func TestHookBaseStoredArchivedMessages(t *testing.T) {
+	h := new(HookBase)
+	v, err := h.StoredArchivedMessages("#", 0, 0)
+	require.NoError(t, err)
+	require.Empty(t, v)
+}
@@ -12,10 +12,26 @@ import (
 	"github.com/mochi-mqtt/server/v2/packets"
 )
 
+// InflightPolicy determines how the server handles a message for a client whose inflight
+// store has reached the maximum inflight capability.
+type InflightPolicy string
+
+const (
+	// InflightReject drops the new message, applying flow control to the client until
+	// existing inflight messages are acknowledged or expire. This is the default.
+	InflightReject InflightPolicy = "reject"
+
+	// InflightEvictOldest drops the oldest outbound inflight publish of the client to
+	// make room for the new message.
+	InflightEvictOldest InflightPolicy = "evict_oldest"
+)
+
 // Inflight is a map of InflightMessage keyed on packet id.
 type Inflight struct {
 	sync.RWMutex
 	internal            map[uint16]packets.Packet // internal contains the inflight packets
+	reserved            map[uint16]struct{}       // ids of evicted messages which may have been sent, held until acknowledged
+	resends             map[uint16]inflightResend // resend backoff of outbound packets, keyed on packet id
 	receiveQuota        int32                     // remaining inbound qos quota for flow control
 	sendQuota           int32                     // remaining outbound qos quota for flow control
 	maximumReceiveQuota int32                     // maximum allowed receive quota
@@ -36,9 +52,28 @@ func (i *Inflight) Set(m packets.Packet) bool {
 
 	_, ok := i.internal[m.PacketID]
 	i.internal[m.PacketID] = m
+	delete(i.resends, m.PacketID)
 	return !ok
 }
 
+// Add adds an inflight packet if there is no inflight packet with the same packet id.
+// Returns true if the packet was added.
+func (i *Inflight) Add(m packets.Packet) bool {
+	i.Lock()
+	defer i.Unlock()
+
+	if _, ok := i.internal[m.PacketID]; ok {
+		return false
+	}
+
+	if _, ok := i.reserved[m.PacketID]; ok {
+		return false
+	}
+
+	i.internal[m.PacketID] = m
+	return true
+}
+
 // Get returns an inflight packet by packet id.
 func (i *Inflight) Get(id uint16) (packets.Packet, bool) {
 	i.RLock()
@@ -59,7 +94,9 @@ func (i *Inflight) Len() int {
 }
 
 // Clone returns a new instance of Inflight with the same message data.
-// This is used when transferring inflights from a taken-over session.
+// This is used when transferring inflights from a taken-over session. Reserved
+// packet ids are not cloned, as evicted messages are not resent to the new
+// connection, so they can no longer be acknowledged.
 func (i *Inflight) Clone() *Inflight {
 	c := NewInflights()
 	i.RLock()
@@ -70,15 +107,21 @@ func (i *Inflight) Clone() *Inflight {
 	return c
 }
 
-// GetAll returns all the inflight messages.
+// GetAll returns a snapshot of all the inflight messages, ordered by creation. The
+// packets are copies which share no memory with the inflight map, so they may be
+// modified by the caller; use Update to modify an inflight packet in place.
 func (i *Inflight) GetAll(immediate bool) []packets.Packet {
 	i.RLock()
 	defer i.RUnlock()
+	return i.getAll(immediate)
+}
 
+// getAll returns copies of the inflight messages. The caller must hold the lock.
+func (i *Inflight) getAll(immediate bool) []packets.Packet {
 	m := []packets.Packet{}
 	for _, v := range i.internal {
 		if !immediate || (immediate && v.Expiry < 0) {
-			m = append(m, v)
+			m = append(m, clonePacket(v))
 		}
 	}
 
@@ -96,7 +139,7 @@ func (i *Inflight) NextImmediate() (packets.Packet, bool) {
 	i.RLock()
 	defer i.RUnlock()
 
-	m := i.GetAll(true)
+	m := i.getAll(true)
 	if len(m) > 0 {
 		return m[0], true
 	}
@@ -104,6 +147,101 @@ func (i *Inflight) NextImmediate() (packets.Packet, bool) {
 	return packets.Packet{}, false
 }
 
+// Oldest returns a copy of the oldest inflight packet of a packet type, ordered by
+// creation time and then packet id.
+func (i *Inflight) Oldest(packetType byte) (packets.Packet, bool) {
+	i.RLock()
+	defer i.RUnlock()
+
+	var oldest packets.Packet
+	var ok bool
+	for _, v := range i.internal {
+		if v.FixedHeader.Type != packetType {
+			continue
+		}
+
+		if !ok || v.Created < oldest.Created || (v.Created == oldest.Created && v.PacketID < oldest.PacketID) {
+			oldest, ok = v, true
+		}
+	}
+
+	if !ok {
+		return oldest, false
+	}
+
+	return clonePacket(oldest), true
+}
+
+// Update atomically applies fn to the inflight packet with the packet id and stores
+// the result. Returns false if there is no inflight packet with the id.
+func (i *Inflight) Update(id uint16, fn func(pk *packets.Packet)) bool {
+	i.Lock()
+	defer i.Unlock()
+
+	pk, ok := i.internal[id]
+	if !ok {
+		return false
+	}
+
+	fn(&pk)
+	i.internal[id] = pk
+	return true
+}
+
+// clonePacket returns a deep copy of an inflight packet, retaining the fixed header
+// and packet id which are reset by packets.Packet.Copy.
+func clonePacket(pk packets.Packet) packets.Packet {
+	c := pk.Copy(true)
+	c.FixedHeader = pk.FixedHeader
+	c.Mods = pk.Mods
+	c.Ignore = pk.Ignore
+	return c
+}
+
+// Evict removes an inflight packet to make room for another message, returning true if
+// the packet existed. Unless the packet was still waiting for send quota, and so was never
+// sent, its packet id stays reserved until the client acknowledges it or reconnects, so a
+// late acknowledgement cannot complete a different message sent with the same id.
+func (i *Inflight) Evict(id uint16) bool {
+	i.Lock()
+	defer i.Unlock()
+
+	pk, ok := i.internal[id]
+	if !ok {
+		return false
+	}
+
+	delete(i.internal, id)
+	delete(i.resends, id)
+	if pk.Expiry >= 0 {
+		if i.reserved == nil {
+			i.reserved = map[uint16]struct{}{}
+		}
+		i.reserved[id] = struct{}{}
+	}
+
+	return true
+}
+
+// Reserved returns true if a packet id is held by an evicted message which may still be
+// acknowledged by the client.
+func (i *Inflight) Reserved(id uint16) bool {
+	i.RLock()
+	defer i.RUnlock()
+	_, ok := i.reserved[id]
+	return ok
+}
+
+// Release frees the packet id of an evicted message once it has been acknowledged,
+// returning true if the id was reserved.
+func (i *Inflight) Release(id uint16) bool {
+	i.Lock()
+	defer i.Unlock()
+	_, ok := i.reserved[id]
+	delete(i.reserved, id)
+	return ok
+}
+
 // Delete removes an in-flight message from the map. Returns true if the message existed.
 func (i *Inflight) Delete(id uint16) bool {
 	i.Lock()
@@ -111,10 +249,69 @@ func (i *Inflight) Delete(id uint16) bool {
 
 	_, ok := i.internal[id]
 	delete(i.internal, id)
+	delete(i.resends, id)
 
 	return ok
 }
 
+// inflightResend is the resend backoff of an outbound inflight packet.
+type inflightResend struct {
+	attempts int   // the number of times the packet has been resent
+	next     int64 // unix time by the server clock at which the packet is next resent
+}
+
+// resendDue is an outbound inflight packet which is due to be resent.
+type resendDue struct {
+	pk       packets.Packet // a copy of the inflight packet
+	attempts int            // the number of times the packet has been resent, including this time
+}
+
+// dueResends returns the outbound publish and pubrel packets which are due to be resent at
+// now, and schedules their next resend. A packet is first due interval seconds after it is
+// first seen, and the interval doubles after each resend, up to maximum seconds. Publishes
+// still waiting for send quota have never been sent, so are not resent.
+func (i *Inflight) dueResends(now, interval, maximum int64) []resendDue {
+	i.Lock()
+	defer i.Unlock()
+
+	if i.resends == nil {
+		i.resends = map[uint16]inflightResend{}
+	}
+
+	var due []resendDue
+	for id, pk := range i.internal {
+		outbound := (pk.FixedHeader.Type == packets.Publish && pk.Expiry >= 0) || pk.FixedHeader.Type == packets.Pubrel
+		if !outbound {
+			continue
+		}
+
+		r, ok := i.resends[id]
+		if !ok {
+			i.resends[id] = inflightResend{next: now + interval}
+			continue
+		}
+
+		if now < r.next {
+			continue
+		}
+
+		r.attempts++
+		backoff := maximum
+		if r.attempts < 32 && interval<<r.attempts < maximum {
+			backoff = interval << r.attempts
+		}
+		r.next = now + backoff
+		i.resends[id] = r
+		due = append(due, resendDue{pk: clonePacket(pk), attempts: r.attempts})
+	}
+
+	sort.Slice(due, func(a, b int) bool {
+		return due[a].pk.PacketID < due[b].pk.PacketID
+	})
+
+	return due
+}
+
 // TakeRecieveQuota reduces the receive quota by 1.
 func (i *Inflight) DecreaseReceiveQuota() {
 	if atomic.LoadInt32(&i.receiveQuota) > 0 {
@@ -55,6 +55,103 @@ func TestInflightGetAllAndImmediate(t *testing.T) {
 	}, cl.State.Inflight.GetAll(true))
 }
 
+func TestInflightGetAllSnapshot(t *testing.T) {
+	cl, _, _ := newTestClient()
+	cl.State.Inflight.Set(packets.Packet{
+		FixedHeader: packets.FixedHeader{Type: packets.Publish, Qos: 1, Dup: true},
+		PacketID:    1,
+		Payload:     []byte("hello"),
+		Properties: packets.Properties{
+			User: []packets.UserProperty{{Key: "k", Val: "v"}},
+		},
+	})
+
+	m := cl.State.Inflight.GetAll(false)
+	require.Len(t, m, 1)
+	require.True(t, m[0].FixedHeader.Dup)
+	require.Equal(t, uint16(1), m[0].PacketID)
+
+	m[0].Payload[0] = 'j'
+	m[0].Properties.User[0].Val = "x"
+	m[0].FixedHeader.Qos = 2
+
+	pk, ok := cl.State.Inflight.Get(1)
+	require.True(t, ok)
+	require.Equal(t, []byte("hello"), pk.Payload)
+	require.Equal(t, "v", pk.Properties.User[0].Val)
+	require.Equal(t, byte(1), pk.FixedHeader.Qos)
+}
+
+func TestInflightOldest(t *testing.T) {
+	cl, _, _ := newTestClient()
+	_, ok := cl.State.Inflight.Oldest(packets.Publish)
+	require.False(t, ok)
+
+	cl.State.Inflight.Set(packets.Packet{FixedHeader: packets.FixedHeader{Type: packets.Pubrel}, PacketID: 1, Created: 1})
+	cl.State.Inflight.Set(packets.Packet{FixedHeader: packets.FixedHeader{Type: packets.Publish}, PacketID: 4, Created: 3})
+	cl.State.Inflight.Set(packets.Packet{FixedHeader: packets.FixedHeader{Type: packets.Publish}, PacketID: 3, Created: 2})
+	cl.State.Inflight.Set(packets.Packet{FixedHeader: packets.FixedHeader{Type: packets.Publish}, PacketID: 2, Created: 2})
+
+	pk, ok := cl.State.Inflight.Oldest(packets.Publish)
+	require.True(t, ok)
+	require.Equal(t, uint16(2), pk.PacketID)
+
+	pk, ok = cl.State.Inflight.Oldest(packets.Pubrel)
+	require.True(t, ok)
+	require.Equal(t, uint16(1), pk.PacketID)
+}
+
+func TestInflightEvict(t *testing.T) {
+	i := NewInflights()
+	require.False(t, i.Evict(1))
+
+	i.Set(packets.Packet{FixedHeader: packets.FixedHeader{Type: packets.Publish}, PacketID: 1})
+	i.Set(packets.Packet{FixedHeader: packets.FixedHeader{Type: packets.Publish}, PacketID: 2, Expiry: -1}) // never sent
+
+	require.True(t, i.Evict(1))
+	require.True(t, i.Evict(2))
+	require.Equal(t, 0, i.Len())
+	require.True(t, i.Reserved(1))
+	require.False(t, i.Reserved(2))
+
+	require.False(t, i.Add(packets.Packet{PacketID: 1}))
+	require.True(t, i.Add(packets.Packet{PacketID: 2}))
+	require.False(t, i.Clone().Reserved(1))
+
+	require.True(t, i.Release(1))
+	require.False(t, i.Release(1))
+	require.False(t, i.Reserved(1))
+	require.True(t, i.Add(packets.Packet{PacketID: 1}))
+}
+
+func TestInflightUpdate(t *testing.T) {
+	cl, _, _ := newTestClient()
+	cl.State.Inflight.Set(packets.Packet{PacketID: 1})
+
+	ok := cl.State.Inflight.Update(1, func(pk *packets.Packet) {
+		pk.FixedHeader.Dup = true
+	})
+	require.True(t, ok)
+
+	pk, _ := cl.State.Inflight.Get(1)
+	require.True(t, pk.FixedHeader.Dup)
+
+	ok = cl.State.Inflight.Update(2, func(pk *packets.Packet) {
+		t.Fatal("update should not be called for a missing packet")
+	})
+	require.False(t, ok)
+}
+
+func TestInflightAdd(t *testing.T) {
+	cl, _, _ := newTestClient()
+	require.True(t, cl.State.Inflight.Add(packets.Packet{PacketID: 1, Created: 1}))
+	require.False(t, cl.State.Inflight.Add(packets.Packet{PacketID: 1, Created: 2}))
+
+	pk, ok := cl.State.Inflight.Get(1)
+	require.True(t, ok)
+	require.Equal(t, int64(1), pk.Created)
+}
+
 func TestInflightLen(t *testing.T) {
 	cl, _, _ := newTestClient()
 	cl.State.Inflight.Set(packets.Packet{PacketID: 2})
@@ -88,6 +185,44 @@ func TestInflightDelete(t *testing.T) {
 	require.False(t, r)
 }
 
+func TestInflightDueResends(t *testing.T) {
+	i := NewInflights()
+	i.Set(packets.Packet{FixedHeader: packets.FixedHeader{Type: packets.Publish, Qos: 1}, PacketID: 1})
+	i.Set(packets.Packet{FixedHeader: packets.FixedHeader{Type: packets.Pubrel}, PacketID: 2})
+	i.Set(packets.Packet{FixedHeader: packets.FixedHeader{Type: packets.Pubrec}, PacketID: 3})                      // inbound
+	i.Set(packets.Packet{FixedHeader: packets.FixedHeader{Type: packets.Publish, Qos: 1}, PacketID: 4, Expiry: -1}) // never sent
+
+	ids := func(due []resendDue) []uint16 {
+		out := []uint16{}
+		for _, r := range due {
+			out = append(out, r.pk.PacketID)
+		}
+		return out
+	}
+
+	require.Empty(t, i.dueResends(100, 10, 35)) // first seen
+	require.Empty(t, i.dueResends(109, 10, 35))
+
+	due := i.dueResends(110, 10, 35)
+	require.Equal(t, []uint16{1, 2}, ids(due))
+	require.Equal(t, 1, due[0].attempts)
+
+	require.Empty(t, i.dueResends(129, 10, 35)) // backoff doubled to 20
+	due = i.dueResends(130, 10, 35)
+	require.Equal(t, []uint16{1, 2}, ids(due))
+	require.Equal(t, 2, due[0].attempts)
+
+	require.Empty(t, i.dueResends(164, 10, 35)) // capped at 35
+	require.Equal(t, []uint16{1, 2}, ids(i.dueResends(165, 10, 35)))
+
+	// replacing or deleting a packet resets its backoff.
+	i.Set(packets.Packet{FixedHeader: packets.FixedHeader{Type: packets.Pubrel}, PacketID: 1})
+	require.True(t, i.Delete(2))
+	require.Empty(t, i.dueResends(200, 10, 35))
+	require.Equal(t, []uint16{1}, ids(i.dueResends(210, 10, 35)))
+	require.NotContains(t, i.resends, uint16(2))
+}
+
 func TestResetReceiveQuota(t *testing.T) {
 	i := NewInflights()
 	require.Equal(t, int32(0), atomic.LoadInt32(&i.maximumReceiveQuota))
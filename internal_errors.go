@@ -0,0 +1,56 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package mqtt
+
+import "fmt"
+
+const (
+	ErrorSubsystemPanic   = "panic"   // a panic recovered in a goroutine of a client
+	ErrorSubsystemDecode  = "decode"  // a malformed or undecodable packet from a client, or retained message
+	ErrorSubsystemWrite   = "write"   // a failure writing to the connection of a client
+	ErrorSubsystemStorage = "storage" // a failure writing to or reading from a persistent store
+)
+
+// InternalError is passed to the OnError hooks for each non-fatal error encountered by the
+// server, identifying the subsystem in which it occurred and the client it concerns, if any,
+// so that errors can be shipped to an error tracker rather than being lost in the logs.
+type InternalError struct {
+	Subsystem string // the subsystem in which the error occurred, such as ErrorSubsystemWrite
+	ClientID  string // the id of the client the error concerns, if any
+	Err       error  // the underlying error
+}
+
+// Error returns the subsystem, client id, and underlying error as an error string.
+func (e *InternalError) Error() string {
+	if e.ClientID == "" {
+		return fmt.Sprintf("%s: %v", e.Subsystem, e.Err)
+	}
+
+	return fmt.Sprintf("%s: client %s: %v", e.Subsystem, e.ClientID, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *InternalError) Unwrap() error {
+	return e.Err
+}
+
+// reportError passes an internal error in a subsystem to the OnError hooks. The client
+// may be nil if the error does not concern a client.
+func reportError(h *Hooks, cl *Client, subsystem string, err error) {
+	if h == nil || err == nil {
+		return
+	}
+
+	ie := &InternalError{
+		Subsystem: subsystem,
+		Err:       err,
+	}
+
+	if cl != nil {
+		ie.ClientID = cl.ID
+	}
+
+	h.OnError(cl, ie)
+}
@@ -0,0 +1,134 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package mqtt
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/mochi-mqtt/server/v2/packets"
+	"github.com/stretchr/testify/require"
+)
+
+type errorHook struct {
+	HookBase
+	sync.Mutex
+	errs []error
+}
+
+func (h *errorHook) ID() string {
+	return "errors"
+}
+
+func (h *errorHook) Provides(b byte) bool {
+	return b == OnError
+}
+
+func (h *errorHook) OnError(cl *Client, err error) {
+	h.Lock()
+	defer h.Unlock()
+	h.errs = append(h.errs, err)
+}
+
+func (h *errorHook) Errors() []error {
+	h.Lock()
+	defer h.Unlock()
+	return h.errs
+}
+
+func TestInternalError(t *testing.T) {
+	err := &InternalError{Subsystem: ErrorSubsystemWrite, ClientID: "zen", Err: io.ErrClosedPipe}
+	require.Equal(t, "write: client zen: io: read/write on closed pipe", err.Error())
+	require.ErrorIs(t, err, io.ErrClosedPipe)
+
+	err = &InternalError{Subsystem: ErrorSubsystemStorage, Err: io.ErrClosedPipe}
+	require.Equal(t, "storage: io: read/write on closed pipe", err.Error())
+}
+
+func TestReportError(t *testing.T) {
+	h := new(Hooks)
+	hook := new(errorHook)
+	require.NoError(t, h.Add(hook, nil))
+
+	reportError(nil, nil, ErrorSubsystemStorage, errTestHook)
+	reportError(h, nil, ErrorSubsystemStorage, nil)
+	require.Empty(t, hook.Errors())
+
+	reportError(h, &Client{ID: "zen"}, ErrorSubsystemWrite, errTestHook)
+	require.Len(t, hook.Errors(), 1)
+
+	var ie *InternalError
+	require.ErrorAs(t, hook.Errors()[0], &ie)
+	require.Equal(t, ErrorSubsystemWrite, ie.Subsystem)
+	require.Equal(t, "zen", ie.ClientID)
+	require.ErrorIs(t, ie, errTestHook)
+}
+
+func TestHookBaseReportError(t *testing.T) {
+	h := new(HookBase)
+	h.ReportError(nil, ErrorSubsystemStorage, errTestHook) // no options set
+
+	var got error
+	h.SetOpts(logger, &HookOptions{
+		ReportError: func(cl *Client, subsystem string, err error) {
+			got = err
+		},
+	})
+	h.ReportError(nil, ErrorSubsystemStorage, errTestHook)
+	require.ErrorIs(t, got, errTestHook)
+}
+
+func TestServerAddHookReportError(t *testing.T) {
+	s := newServer()
+	hook := new(errorHook)
+	require.NoError(t, s.AddHook(hook, nil))
+
+	hook.ReportError(nil, ErrorSubsystemStorage, errTestHook)
+	require.Len(t, hook.Errors(), 1)
+
+	var ie *InternalError
+	require.ErrorAs(t, hook.Errors()[0], &ie)
+	require.Equal(t, ErrorSubsystemStorage, ie.Subsystem)
+	require.Empty(t, ie.ClientID)
+}
+
+func TestClientWritePacketReportsError(t *testing.T) {
+	cl, _, _ := newTestClient()
+	hook := new(errorHook)
+	require.NoError(t, cl.ops.hooks.Add(hook, nil))
+	_ = cl.Net.Conn.Close()
+
+	require.Error(t, cl.WritePacket(*pkTable[1].Packet))
+	require.Error(t, cl.WritePacket(*pkTable[1].Packet))
+	require.Len(t, hook.Errors(), 1) // only the first write error is reported
+
+	var ie *InternalError
+	require.ErrorAs(t, hook.Errors()[0], &ie)
+	require.Equal(t, ErrorSubsystemWrite, ie.Subsystem)
+	require.Equal(t, cl.ID, ie.ClientID)
+}
+
+func TestClientReadReportsDecodeError(t *testing.T) {
+	cl, r, _ := newTestClient()
+	hook := new(errorHook)
+	require.NoError(t, cl.ops.hooks.Add(hook, nil))
+
+	go func() {
+		_, _ = r.Write([]byte{packets.Publish << 4, 4, 0, 5, 'a', '/'}) // topic name longer than the packet
+	}()
+
+	err := cl.Read(func(cl *Client, pk packets.Packet) error {
+		return nil
+	})
+	require.Error(t, err)
+	require.Len(t, hook.Errors(), 1)
+
+	var ie *InternalError
+	require.ErrorAs(t, hook.Errors()[0], &ie)
+	require.Equal(t, ErrorSubsystemDecode, ie.Subsystem)
+	require.True(t, errors.Is(err, ie.Err))
+}
@@ -0,0 +1,149 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package mqtt
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LatencyBuckets are the upper bounds in milliseconds of the delivery latency histogram
+// buckets. Deliveries slower than the last bound are counted in an overflow bucket.
+var LatencyBuckets = [...]int64{1, 2, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000}
+
+// LatencyHistogram is a snapshot of the delivery latencies of messages published to a
+// topic namespace and written to subscribers on a listener.
+type LatencyHistogram struct {
+	Listener string  `json:"listener"` // the id of the listener of the subscribers
+	Prefix   string  `json:"prefix"`   // the topic prefix of the messages
+	Buckets  []int64 `json:"buckets"`  // the number of deliveries in each of LatencyBuckets, and the overflow
+	Count    int64   `json:"count"`    // the total number of deliveries
+	Sum      int64   `json:"sum_us"`   // the sum of the delivery latencies in microseconds
+}
+
+// latencyHistogram contains the atomic counters for a histogram.
+type latencyHistogram struct {
+	buckets [len(LatencyBuckets) + 1]int64
+	count   int64
+	sum     int64
+}
+
+// DeliveryLatency is a map of histograms of the time taken from the receipt of a publish
+// to its write to each subscriber, keyed on the listener of the subscriber and the first
+// depth levels of the topic name.
+type DeliveryLatency struct {
+	internal map[string]*latencyHistogram // histograms keyed on listener and topic prefix
+	prefixes map[string]struct{}          // the topic prefixes with histograms
+	stats    *TopicStats                  // used to determine topic prefixes
+	limit    int                          // the maximum number of prefixes measured separately, or 0 for no limit
+	sync.RWMutex
+}
+
+// NewDeliveryLatency returns a new instance of DeliveryLatency which groups topics by
+// the first depth levels of the topic name. A depth of 0 or less groups all topics of
+// a listener together.
+func NewDeliveryLatency(depth int) *DeliveryLatency {
+	return &DeliveryLatency{
+		internal: map[string]*latencyHistogram{},
+		prefixes: map[string]struct{}{},
+		stats:    NewTopicStats(depth),
+	}
+}
+
+// SetLimit sets the maximum number of topic prefixes measured separately. Once the limit
+// is reached, deliveries on new prefixes are measured under TopicStatsOther. There is no
+// limit if limit is 0 or less.
+func (d *DeliveryLatency) SetLimit(limit int) {
+	d.Lock()
+	defer d.Unlock()
+	d.limit = max(limit, 0)
+}
+
+// Record adds the latency of a delivery on a listener to the histogram for the
+// namespace of the topic.
+func (d *DeliveryLatency) Record(listener, topic string, latency time.Duration) {
+	var prefix string
+	if d.stats.depth > 0 {
+		prefix = d.stats.prefix(topic)
+	}
+
+	key := listener + "\x00" + prefix
+	d.RLock()
+	h, ok := d.internal[key]
+	d.RUnlock()
+
+	if !ok {
+		d.Lock()
+		if h, ok = d.internal[key]; !ok {
+			if _, known := d.prefixes[prefix]; !known && d.limit > 0 && len(d.prefixes) >= d.limit {
+				prefix = TopicStatsOther
+				key = listener + "\x00" + prefix
+			}
+
+			if h, ok = d.internal[key]; !ok {
+				h = new(latencyHistogram)
+				d.internal[key] = h
+				d.prefixes[prefix] = struct{}{}
+			}
+		}
+		d.Unlock()
+	}
+
+	us := latency.Microseconds()
+	i := sort.Search(len(LatencyBuckets), func(i int) bool {
+		return LatencyBuckets[i]*1000 >= us
+	})
+
+	atomic.AddInt64(&h.buckets[i], 1)
+	atomic.AddInt64(&h.count, 1)
+	atomic.AddInt64(&h.sum, us)
+}
+
+// GetAll returns a copy of all histograms, ordered by listener and topic prefix.
+func (d *DeliveryLatency) GetAll() []LatencyHistogram {
+	d.RLock()
+	defer d.RUnlock()
+	m := make([]LatencyHistogram, 0, len(d.internal))
+	for k, v := range d.internal {
+		listener, prefix, _ := strings.Cut(k, "\x00")
+		m = append(m, v.clone(listener, prefix))
+	}
+
+	sort.Slice(m, func(i, j int) bool {
+		if m[i].Listener != m[j].Listener {
+			return m[i].Listener < m[j].Listener
+		}
+		return m[i].Prefix < m[j].Prefix
+	})
+
+	return m
+}
+
+// Len returns the number of histograms.
+func (d *DeliveryLatency) Len() int {
+	d.RLock()
+	defer d.RUnlock()
+	return len(d.internal)
+}
+
+// clone returns a copy of the histogram using atomic operations.
+func (h *latencyHistogram) clone(listener, prefix string) LatencyHistogram {
+	v := LatencyHistogram{
+		Listener: listener,
+		Prefix:   prefix,
+		Buckets:  make([]int64, len(h.buckets)),
+		Count:    atomic.LoadInt64(&h.count),
+		Sum:      atomic.LoadInt64(&h.sum),
+	}
+
+	for i := range h.buckets {
+		v.Buckets[i] = atomic.LoadInt64(&h.buckets[i])
+	}
+
+	return v
+}
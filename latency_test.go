@@ -0,0 +1,76 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package mqtt
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeliveryLatencyRecord(t *testing.T) {
+	d := NewDeliveryLatency(1)
+	d.Record("t1", "a/b/c", time.Microsecond*500)
+	d.Record("t1", "a/d", time.Millisecond*7)
+	d.Record("t1", "x/y", time.Second*10)
+	d.Record("t0", "a/b", time.Millisecond)
+	require.Equal(t, 3, d.Len())
+
+	hs := d.GetAll()
+	require.Len(t, hs, 3)
+	require.Equal(t, "t0", hs[0].Listener)
+	require.Equal(t, "a", hs[0].Prefix)
+	require.Equal(t, int64(1), hs[0].Buckets[0])
+
+	require.Equal(t, "t1", hs[1].Listener)
+	require.Equal(t, "a", hs[1].Prefix)
+	require.Equal(t, int64(2), hs[1].Count)
+	require.Equal(t, int64(7500), hs[1].Sum)
+	require.Equal(t, int64(1), hs[1].Buckets[0])
+	require.Equal(t, int64(1), hs[1].Buckets[3])
+
+	require.Equal(t, "x", hs[2].Prefix)
+	require.Len(t, hs[2].Buckets, len(LatencyBuckets)+1)
+	require.Equal(t, int64(1), hs[2].Buckets[len(LatencyBuckets)]) // overflow
+}
+
+func TestDeliveryLatencyRecordLimit(t *testing.T) {
+	d := NewDeliveryLatency(1)
+	d.SetLimit(2)
+	d.Record("t1", "a/b", time.Millisecond)
+	d.Record("t1", "b/c", time.Millisecond)
+	d.Record("t1", "c/d", time.Millisecond)
+	d.Record("t2", "a/b", time.Millisecond) // known prefixes are measured on any listener
+	d.Record("t2", "d/e", time.Millisecond)
+	d.Record("t1", "e/f", time.Millisecond)
+
+	hs := d.GetAll()
+	require.Len(t, hs, 5)
+	require.Equal(t, "a", hs[0].Prefix)
+	require.Equal(t, "b", hs[1].Prefix)
+	require.Equal(t, TopicStatsOther, hs[2].Prefix)
+	require.Equal(t, int64(2), hs[2].Count)
+	require.Equal(t, "t2", hs[3].Listener)
+	require.Equal(t, "a", hs[3].Prefix)
+	require.Equal(t, TopicStatsOther, hs[4].Prefix)
+	require.Equal(t, int64(1), hs[4].Count)
+}
+
+func TestServerDeliveryLatencyLimit(t *testing.T) {
+	s := New(&Options{Logger: logger, TopicMetricsDepth: 1, TopicMetricsLimit: 5})
+	require.Equal(t, 5, s.Latency.limit)
+}
+
+func TestDeliveryLatencyRecordNoDepth(t *testing.T) {
+	d := NewDeliveryLatency(0)
+	d.Record("t1", "a/b/c", time.Millisecond)
+	d.Record("t1", "x/y", time.Millisecond)
+
+	hs := d.GetAll()
+	require.Len(t, hs, 1)
+	require.Equal(t, "", hs[0].Prefix)
+	require.Equal(t, int64(2), hs[0].Count)
+}
@@ -0,0 +1,68 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package listeners
+
+import (
+	"errors"
+	"log/slog"
+	"math/rand"
+	"net"
+	"syscall"
+	"time"
+)
+
+const (
+	minAcceptDelay = 5 * time.Millisecond // the first delay after a temporary accept error
+	maxAcceptDelay = time.Second          // the largest delay between accept retries
+)
+
+// temporaryAcceptError returns true if an accept error is expected to clear by itself,
+// such as the process or system running out of file descriptors.
+func temporaryAcceptError(err error) bool {
+	if errors.Is(err, syscall.EMFILE) ||
+		errors.Is(err, syscall.ENFILE) ||
+		errors.Is(err, syscall.ENOBUFS) ||
+		errors.Is(err, syscall.ENOMEM) ||
+		errors.Is(err, syscall.ECONNABORTED) {
+		return true
+	}
+
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+// acceptBackoff delays the accept loop of a listener after temporary errors, doubling
+// the delay with jitter on each consecutive error up to maxAcceptDelay.
+type acceptBackoff struct {
+	delay time.Duration // the current delay, or zero if the last accept succeeded
+}
+
+// retry returns true if the accept error is temporary, after waiting for the next delay.
+// Temporary errors are logged so that descriptor exhaustion can be detected.
+func (b *acceptBackoff) retry(log *slog.Logger, id string, err error) bool {
+	if !temporaryAcceptError(err) {
+		return false
+	}
+
+	if b.delay == 0 {
+		b.delay = minAcceptDelay
+	} else if b.delay *= 2; b.delay > maxAcceptDelay {
+		b.delay = maxAcceptDelay
+	}
+
+	wait := b.delay/2 + time.Duration(rand.Int63n(int64(b.delay/2)+1)) // #nosec G404
+	log.Warn("temporary accept error, retrying", "error", err, "listener", id, "delay", wait)
+	time.Sleep(wait)
+	return true
+}
+
+// reset clears the delay after a successful accept, logging if the listener had been
+// backing off.
+func (b *acceptBackoff) reset(log *slog.Logger, id string) {
+	if b.delay != 0 {
+		log.Info("accept resumed", "listener", id)
+		b.delay = 0
+	}
+}
@@ -0,0 +1,79 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package listeners
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// exhaustedListener is a net.Listener which fails with EMFILE a number of times before
+// returning connections.
+type exhaustedListener struct {
+	net.Listener
+	failures int32
+}
+
+func (l *exhaustedListener) Accept() (net.Conn, error) {
+	if atomic.AddInt32(&l.failures, -1) >= 0 {
+		return nil, &net.OpError{Op: "accept", Net: "tcp", Err: os.NewSyscallError("accept", syscall.EMFILE)}
+	}
+
+	return l.Listener.Accept()
+}
+
+func TestTemporaryAcceptError(t *testing.T) {
+	require.True(t, temporaryAcceptError(&net.OpError{Op: "accept", Err: os.NewSyscallError("accept", syscall.EMFILE)}))
+	require.True(t, temporaryAcceptError(fmt.Errorf("wrapped: %w", syscall.ENFILE)))
+	require.False(t, temporaryAcceptError(net.ErrClosed))
+	require.False(t, temporaryAcceptError(errors.New("test")))
+}
+
+func TestAcceptBackoff(t *testing.T) {
+	var b acceptBackoff
+	require.False(t, b.retry(logger, "t1", net.ErrClosed))
+	require.Equal(t, int64(0), int64(b.delay))
+
+	require.True(t, b.retry(logger, "t1", syscall.EMFILE))
+	require.Equal(t, minAcceptDelay, b.delay)
+	require.True(t, b.retry(logger, "t1", syscall.EMFILE))
+	require.Equal(t, minAcceptDelay*2, b.delay)
+
+	b.reset(logger, "t1")
+	require.Equal(t, int64(0), int64(b.delay))
+}
+
+func TestNetServeRecoversFromExhaustion(t *testing.T) {
+	n, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	l := NewNet("t1", &exhaustedListener{Listener: n, failures: 3})
+	require.NoError(t, l.Init(logger))
+
+	established := make(chan bool, 1)
+	o := make(chan bool)
+	go func() {
+		l.Serve(func(id string, c net.Conn) error {
+			established <- true
+			return nil
+		})
+		o <- true
+	}()
+
+	conn, err := net.Dial("tcp", n.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	require.True(t, <-established)
+	l.Close(MockCloser)
+	<-o
+}
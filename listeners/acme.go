@@ -0,0 +1,189 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package listeners
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+const TypeACME = "acme"
+
+var (
+	// ErrACMEDomainsRequired indicates acme options were provided without any domains.
+	ErrACMEDomainsRequired = errors.New("acme options require at least one domain")
+
+	// ErrACMECertificateConflict indicates tls options contained both acme options and certificate files.
+	ErrACMECertificateConflict = errors.New("tls options cannot set both acme and certificate files")
+
+	// ErrACMERequired indicates an acme challenge listener was configured without acme options.
+	ErrACMERequired = errors.New("acme challenge listener requires tls acme options")
+)
+
+// ACMEOptions configures a listener to obtain and renew its certificates automatically
+// from an ACME certificate authority, such as Let's Encrypt. By setting them, the terms of
+// service of the certificate authority are accepted. Certificates are verified using the
+// TLS-ALPN-01 challenge, which requires the listener to be reachable on port 443, or the
+// HTTP-01 challenge, which requires an acme listener with the same options on port 80.
+type ACMEOptions struct {
+	Domains      []string `yaml:"domains" json:"domains"`             // the domains certificates may be requested for
+	Email        string   `yaml:"email" json:"email"`                 // the contact email for the account, optional
+	CacheDir     string   `yaml:"cache_dir" json:"cache_dir"`         // the directory certificates and account keys are stored in
+	DirectoryURL string   `yaml:"directory_url" json:"directory_url"` // the directory url of the authority; defaults to Let's Encrypt
+}
+
+// acmeKey identifies the acme options a certificate manager was created for.
+type acmeKey struct {
+	domains      string
+	email        string
+	cacheDir     string
+	directoryURL string
+}
+
+var (
+	acmeManagersMu sync.Mutex
+	acmeManagers   = map[acmeKey]*autocert.Manager{} // managers shared between listeners with the same options
+)
+
+// Manager returns the certificate manager for the options. Listeners with the same options
+// share a manager, so the acme challenge listener answers the challenges of TLS listeners
+// and certificates are only requested once. Without a CacheDir, certificates are requested
+// again whenever the process restarts, which may exceed the rate limits of the authority.
+func (o *ACMEOptions) Manager() (*autocert.Manager, error) {
+	if len(o.Domains) == 0 {
+		return nil, ErrACMEDomainsRequired
+	}
+
+	domains := slices.Clone(o.Domains)
+	slices.Sort(domains)
+	key := acmeKey{
+		domains:      strings.Join(domains, ","),
+		email:        o.Email,
+		cacheDir:     o.CacheDir,
+		directoryURL: o.DirectoryURL,
+	}
+
+	acmeManagersMu.Lock()
+	defer acmeManagersMu.Unlock()
+	if m, ok := acmeManagers[key]; ok {
+		return m, nil
+	}
+
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(domains...),
+		Email:      o.Email,
+	}
+
+	if o.CacheDir != "" {
+		m.Cache = autocert.DirCache(o.CacheDir)
+	}
+
+	if o.DirectoryURL != "" {
+		m.Client = &acme.Client{DirectoryURL: o.DirectoryURL}
+	}
+
+	acmeManagers[key] = m
+	return m, nil
+}
+
+// applyACME configures a tls.Config to obtain certificates from the acme options and
+// answer TLS-ALPN-01 challenges.
+func (o *ACMEOptions) applyACME(config *tls.Config) error {
+	m, err := o.Manager()
+	if err != nil {
+		return err
+	}
+
+	config.GetCertificate = m.GetCertificate
+	config.NextProtos = append(config.NextProtos, acme.ALPNProto)
+	return nil
+}
+
+// HTTPACMEChallenge is a listener which answers the HTTP-01 challenges of the acme options
+// in its TLS settings, and redirects all other requests to https.
+type HTTPACMEChallenge struct {
+	sync.RWMutex
+	id      string       // the internal id of the listener
+	address string       // the network address to bind to
+	config  Config       // configuration values for the listener
+	listen  *http.Server // the http server
+	end     uint32       // ensure the close methods are only called once
+}
+
+// NewHTTPACMEChallenge initializes and returns a new acme challenge listener, listening on an address.
+func NewHTTPACMEChallenge(config Config) *HTTPACMEChallenge {
+	return &HTTPACMEChallenge{
+		id:      config.ID,
+		address: config.Address,
+		config:  config,
+	}
+}
+
+// ID returns the id of the listener.
+func (l *HTTPACMEChallenge) ID() string {
+	return l.id
+}
+
+// Address returns the address of the listener.
+func (l *HTTPACMEChallenge) Address() string {
+	return l.address
+}
+
+// Protocol returns the protocol of the listener.
+func (l *HTTPACMEChallenge) Protocol() string {
+	return "http"
+}
+
+// Init initializes the listener.
+func (l *HTTPACMEChallenge) Init(_ *slog.Logger) error {
+	if l.config.TLS == nil || l.config.TLS.ACME == nil {
+		return ErrACMERequired
+	}
+
+	m, err := l.config.TLS.ACME.Manager()
+	if err != nil {
+		return err
+	}
+
+	l.listen = &http.Server{
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 5 * time.Second,
+		Addr:         l.address,
+		Handler:      m.HTTPHandler(nil),
+	}
+
+	return nil
+}
+
+// Serve starts listening for new connections and serving responses.
+func (l *HTTPACMEChallenge) Serve(establish EstablishFn) {
+	_ = l.listen.ListenAndServe()
+}
+
+// Close closes the listener and any client connections.
+func (l *HTTPACMEChallenge) Close(closeClients CloseFn) {
+	l.Lock()
+	defer l.Unlock()
+
+	if atomic.CompareAndSwapUint32(&l.end, 0, 1) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = l.listen.Shutdown(ctx)
+	}
+
+	closeClients(l.id)
+}
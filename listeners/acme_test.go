@@ -0,0 +1,152 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package listeners
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+func TestACMEOptionsManager(t *testing.T) {
+	_, err := (&ACMEOptions{}).Manager()
+	require.ErrorIs(t, err, ErrACMEDomainsRequired)
+
+	dir := t.TempDir()
+	opts := &ACMEOptions{
+		Domains:      []string{"mqtt.example.com", "example.com"},
+		Email:        "ops@example.com",
+		CacheDir:     dir,
+		DirectoryURL: "https://acme.example.com/directory",
+	}
+	m, err := opts.Manager()
+	require.NoError(t, err)
+	require.Equal(t, "ops@example.com", m.Email)
+	require.Equal(t, autocert.DirCache(dir), m.Cache)
+	require.Equal(t, "https://acme.example.com/directory", m.Client.DirectoryURL)
+	require.NoError(t, m.HostPolicy(context.Background(), "mqtt.example.com"))
+	require.Error(t, m.HostPolicy(context.Background(), "other.example.com"))
+
+	// listeners with the same options share a manager.
+	same, err := (&ACMEOptions{
+		Domains:      []string{"example.com", "mqtt.example.com"},
+		Email:        "ops@example.com",
+		CacheDir:     dir,
+		DirectoryURL: "https://acme.example.com/directory",
+	}).Manager()
+	require.NoError(t, err)
+	require.Same(t, m, same)
+
+	other, err := (&ACMEOptions{Domains: []string{"example.com", "mqtt.example.com"}}).Manager()
+	require.NoError(t, err)
+	require.NotSame(t, m, other)
+	require.Nil(t, other.Cache)
+	require.Nil(t, other.Client)
+}
+
+func TestTLSOptionsACME(t *testing.T) {
+	config, err := (&TLSOptions{ACME: &ACMEOptions{Domains: []string{"example.com"}}, Preset: TLSPresetFIPS}).TLSConfig()
+	require.NoError(t, err)
+	require.Empty(t, config.Certificates)
+	require.NotNil(t, config.GetCertificate)
+	require.Equal(t, []string{acme.ALPNProto}, config.NextProtos)
+	require.Equal(t, tlsPresetCipherSuites[TLSPresetFIPS], config.CipherSuites)
+
+	certFile, keyFile := writeTestKeyPair(t)
+	_, err = (&TLSOptions{CertFile: certFile, KeyFile: keyFile, ACME: &ACMEOptions{Domains: []string{"example.com"}}}).TLSConfig()
+	require.ErrorIs(t, err, ErrACMECertificateConflict)
+
+	_, err = (&TLSOptions{ACME: &ACMEOptions{}}).TLSConfig()
+	require.ErrorIs(t, err, ErrACMEDomainsRequired)
+}
+
+func TestNewHTTPACMEChallenge(t *testing.T) {
+	l := NewHTTPACMEChallenge(basicConfig)
+	require.Equal(t, basicConfig.ID, l.ID())
+	require.Equal(t, basicConfig.Address, l.Address())
+	require.Equal(t, "http", l.Protocol())
+}
+
+func TestHTTPACMEChallengeInitInvalid(t *testing.T) {
+	err := NewHTTPACMEChallenge(basicConfig).Init(logger)
+	require.ErrorIs(t, err, ErrACMERequired)
+
+	config := basicConfig
+	config.TLS = &TLSOptions{ACME: &ACMEOptions{}}
+	err = NewHTTPACMEChallenge(config).Init(logger)
+	require.ErrorIs(t, err, ErrACMEDomainsRequired)
+}
+
+func TestHTTPACMEChallengeHandler(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "token+http-01"), []byte("key-authorization"), 0600))
+
+	config := basicConfig
+	config.TLS = &TLSOptions{ACME: &ACMEOptions{Domains: []string{"example.com"}, CacheDir: dir}}
+	l := NewHTTPACMEChallenge(config)
+	require.NoError(t, l.Init(logger))
+
+	get := func(host, path string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "http://"+host+path, nil)
+		w := httptest.NewRecorder()
+		l.listen.Handler.ServeHTTP(w, r)
+		return w
+	}
+
+	w := get("example.com", "/.well-known/acme-challenge/token")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "key-authorization", w.Body.String())
+
+	require.Equal(t, http.StatusNotFound, get("example.com", "/.well-known/acme-challenge/missing").Code)
+	require.Equal(t, http.StatusForbidden, get("other.example.com", "/.well-known/acme-challenge/token").Code)
+
+	w = get("example.com", "/dashboard")
+	require.Equal(t, http.StatusFound, w.Code)
+	require.Equal(t, "https://example.com/dashboard", w.Header().Get("Location"))
+}
+
+func TestHTTPACMEChallengeServeAndClose(t *testing.T) {
+	config := basicConfig
+	config.TLS = &TLSOptions{ACME: &ACMEOptions{Domains: []string{"localhost"}}}
+	l := NewHTTPACMEChallenge(config)
+	require.NoError(t, l.Init(logger))
+
+	o := make(chan bool)
+	go func(o chan bool) {
+		l.Serve(MockEstablisher)
+		o <- true
+	}(o)
+
+	time.Sleep(time.Millisecond)
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get("http://localhost" + testAddr + "/")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	_, err = io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+
+	var closed bool
+	l.Close(func(id string) {
+		closed = true
+	})
+	require.True(t, closed)
+
+	_, err = client.Get("http://localhost" + testAddr + "/")
+	require.Error(t, err)
+	<-o
+}
@@ -0,0 +1,115 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package listeners
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	EnvListenPID     = "LISTEN_PID"     // the pid of the process the sockets were passed to
+	EnvListenFDs     = "LISTEN_FDS"     // the number of sockets passed, starting at fd 3
+	EnvListenFDNames = "LISTEN_FDNAMES" // colon separated names of the passed sockets
+
+	listenFDsStart = 3 // the first passed file descriptor, after stdin, stdout, and stderr
+)
+
+var (
+	// ErrInvalidActivation indicates the socket activation environment variables could not be parsed.
+	ErrInvalidActivation = errors.New("invalid socket activation environment")
+
+	// ErrListenerFileUnsupported indicates a listener socket cannot be passed to another process.
+	ErrListenerFileUnsupported = errors.New("listener does not support file handoff")
+)
+
+// ActivationListeners returns the listening sockets passed to the process using the
+// systemd socket activation protocol, keyed on their LISTEN_FDNAMES name, or on their
+// position (eg. "0") if no names were given. Serve each one with NewNet. If LISTEN_PID
+// is set, the sockets are only used if it matches the current process, so that sockets
+// can be handed over from a parent broker which cannot know the pid in advance. The
+// activation environment variables are unset so that they are not inherited further.
+func ActivationListeners() (map[string]net.Listener, error) {
+	return activationListeners(listenFDsStart)
+}
+
+// activationListeners returns the passed listening sockets starting at fd start.
+func activationListeners(start int) (map[string]net.Listener, error) {
+	count := os.Getenv(EnvListenFDs)
+	if count == "" {
+		return map[string]net.Listener{}, nil
+	}
+
+	pid := os.Getenv(EnvListenPID)
+	names := os.Getenv(EnvListenFDNames)
+	defer func() {
+		_ = os.Unsetenv(EnvListenPID)
+		_ = os.Unsetenv(EnvListenFDs)
+		_ = os.Unsetenv(EnvListenFDNames)
+	}()
+
+	if pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return map[string]net.Listener{}, nil
+	}
+
+	n, err := strconv.Atoi(count)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("%w: %s=%s", ErrInvalidActivation, EnvListenFDs, count)
+	}
+
+	var labels []string
+	if names != "" {
+		labels = strings.Split(names, ":")
+		if len(labels) != n {
+			return nil, fmt.Errorf("%w: %s has %d names for %d sockets", ErrInvalidActivation, EnvListenFDNames, len(labels), n)
+		}
+	}
+
+	ls := make(map[string]net.Listener, n)
+	for i := 0; i < n; i++ {
+		name := strconv.Itoa(i)
+		if labels != nil {
+			name = labels[i]
+		}
+
+		f := os.NewFile(uintptr(start+i), name)
+		l, err := net.FileListener(f)
+		_ = f.Close() // FileListener holds its own duplicate of the descriptor
+		if err != nil {
+			for _, v := range ls {
+				_ = v.Close()
+			}
+			return nil, fmt.Errorf("socket %s: %w", name, err)
+		}
+
+		ls[name] = l
+	}
+
+	return ls, nil
+}
+
+// ActivationEnv returns the environment variables describing listener files passed to
+// a new process in exec.Cmd.ExtraFiles, in order, so that the new process can retrieve
+// them with ActivationListeners.
+func ActivationEnv(names ...string) []string {
+	return []string{
+		EnvListenFDs + "=" + strconv.Itoa(len(names)),
+		EnvListenFDNames + "=" + strings.Join(names, ":"),
+	}
+}
+
+// listenerFile returns a duplicate of the file descriptor of a listening socket.
+func listenerFile(l net.Listener) (*os.File, error) {
+	fl, ok := l.(interface{ File() (*os.File, error) })
+	if !ok {
+		return nil, ErrListenerFileUnsupported
+	}
+
+	return fl.File()
+}
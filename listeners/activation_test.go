@@ -0,0 +1,101 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package listeners
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// handoverFile returns the file of a new tcp listener as passed to another process.
+func handoverFile(t *testing.T, config Config) (*TCP, *os.File) {
+	config.ID = "t1"
+	config.Address = "127.0.0.1:0"
+	l := NewTCP(config)
+	require.NoError(t, l.Init(logger))
+	t.Cleanup(func() { l.Close(MockCloser) })
+
+	f, err := l.File()
+	require.NoError(t, err)
+	t.Cleanup(func() { f.Close() })
+	return l, f
+}
+
+func TestActivationListeners(t *testing.T) {
+	l, f := handoverFile(t, Config{})
+	for _, env := range ActivationEnv("mqtt") {
+		k, v, _ := strings.Cut(env, "=")
+		t.Setenv(k, v)
+	}
+	t.Setenv(EnvListenPID, strconv.Itoa(os.Getpid()))
+
+	ls, err := activationListeners(int(f.Fd()))
+	require.NoError(t, err)
+	require.Len(t, ls, 1)
+	require.Equal(t, l.Address(), ls["mqtt"].Addr().String())
+	defer ls["mqtt"].Close()
+
+	// the environment is unset once read.
+	require.Equal(t, "", os.Getenv(EnvListenFDs))
+	require.Equal(t, "", os.Getenv(EnvListenPID))
+
+	// the inherited socket accepts connections for the original address.
+	conn, err := net.Dial("tcp", l.Address())
+	require.NoError(t, err)
+	defer conn.Close()
+}
+
+func TestActivationListenersUnnamed(t *testing.T) {
+	l, f := handoverFile(t, Config{DisableNoDelay: true})
+	t.Setenv(EnvListenFDs, "1")
+
+	ls, err := activationListeners(int(f.Fd()))
+	require.NoError(t, err)
+	require.Equal(t, l.Address(), ls["0"].Addr().String())
+	ls["0"].Close()
+}
+
+func TestActivationListenersNone(t *testing.T) {
+	ls, err := ActivationListeners()
+	require.NoError(t, err)
+	require.Empty(t, ls)
+
+	t.Setenv(EnvListenFDs, "1")
+	t.Setenv(EnvListenPID, "1")
+	ls, err = ActivationListeners()
+	require.NoError(t, err)
+	require.Empty(t, ls)
+}
+
+func TestActivationListenersInvalid(t *testing.T) {
+	t.Setenv(EnvListenFDs, "x")
+	_, err := ActivationListeners()
+	require.ErrorIs(t, err, ErrInvalidActivation)
+
+	t.Setenv(EnvListenFDs, "2")
+	t.Setenv(EnvListenFDNames, "mqtt")
+	_, err = ActivationListeners()
+	require.ErrorIs(t, err, ErrInvalidActivation)
+
+	f, err := os.CreateTemp(t.TempDir(), "fd")
+	require.NoError(t, err)
+	defer f.Close()
+	t.Setenv(EnvListenFDs, "1")
+	_, err = activationListeners(int(f.Fd()))
+	require.Error(t, err)
+}
+
+func TestTCPFileNotInitialized(t *testing.T) {
+	_, err := NewTCP(basicConfig).File()
+	require.ErrorIs(t, err, ErrListenerFileUnsupported)
+
+	_, err = listenerFile(&exhaustedListener{})
+	require.ErrorIs(t, err, ErrListenerFileUnsupported)
+}
@@ -0,0 +1,256 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package listeners
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+const (
+	NetworkDualStack = "tcp"  // listen on IPv4 and IPv6 where the address allows
+	NetworkIPv4      = "tcp4" // listen on IPv4 only
+	NetworkIPv6      = "tcp6" // listen on IPv6 only
+
+	maxDSCP = 63 // the largest differentiated services code point
+)
+
+var (
+	// ErrUnknownNetwork indicates a listener network was not one of tcp, tcp4, or tcp6.
+	ErrUnknownNetwork = errors.New("unknown listener network")
+
+	// ErrNoInterfaceAddress indicates a network interface had no address usable by the listener network.
+	ErrNoInterfaceAddress = errors.New("no usable address found for interface")
+
+	// ErrReusePortUnsupported indicates port reuse was requested on a platform which does not support it.
+	ErrReusePortUnsupported = errors.New("port reuse is not supported on this platform")
+
+	// ErrInvalidDSCP indicates a DSCP value was outside the range 0-63.
+	ErrInvalidDSCP = errors.New("dscp must be between 0 and 63")
+
+	// ErrDSCPUnsupported indicates DSCP marking was requested on a platform which does not support it.
+	ErrDSCPUnsupported = errors.New("dscp marking is not supported on this platform")
+
+	// ErrInvalidBufferSize indicates a socket buffer size was negative.
+	ErrInvalidBufferSize = errors.New("socket buffer size cannot be negative")
+
+	// ErrBufferSizeUnsupported indicates socket buffer sizes were set on a platform which does not support it.
+	ErrBufferSizeUnsupported = errors.New("socket buffer sizes are not supported on this platform")
+
+	// ErrInvalidLiveness indicates a keepalive probe count or user timeout was negative.
+	ErrInvalidLiveness = errors.New("keepalive count and user timeout cannot be negative")
+
+	// ErrLivenessUnsupported indicates a keepalive probe count or user timeout was set on a platform which does not support it.
+	ErrLivenessUnsupported = errors.New("keepalive count and user timeout are not supported on this platform")
+)
+
+// network returns the configured listener network, defaulting to dual-stack tcp.
+func (c *Config) network() (string, error) {
+	switch c.Network {
+	case "":
+		return NetworkDualStack, nil
+	case NetworkDualStack, NetworkIPv4, NetworkIPv6:
+		return c.Network, nil
+	default:
+		return "", fmt.Errorf("%w: %s", ErrUnknownNetwork, c.Network)
+	}
+}
+
+// listen opens a stream listener on address using the network, interface, and port
+// reuse settings of the config. If an interface is set, the host of the address is
+// replaced with the first address of the interface usable by the network.
+func (c *Config) listen(address string) (net.Listener, error) {
+	network, err := c.network()
+	if err != nil {
+		return nil, err
+	}
+
+	if c.Interface != "" {
+		_, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+
+		ip, err := interfaceAddress(c.Interface, network)
+		if err != nil {
+			return nil, err
+		}
+
+		address = net.JoinHostPort(ip, port)
+	}
+
+	if c.DSCP < 0 || c.DSCP > maxDSCP {
+		return nil, fmt.Errorf("%w: %d", ErrInvalidDSCP, c.DSCP)
+	}
+
+	if c.ReadBufferSize < 0 || c.WriteBufferSize < 0 {
+		return nil, ErrInvalidBufferSize
+	}
+
+	if c.KeepAliveCount < 0 || c.UserTimeout < 0 {
+		return nil, ErrInvalidLiveness
+	}
+
+	lc := net.ListenConfig{
+		Control:   c.control,
+		KeepAlive: c.KeepAlive,
+	}
+
+	ln, err := lc.Listen(context.Background(), network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.DisableNoDelay {
+		ln = &delayListener{Listener: ln}
+	}
+
+	if c.KeepAliveCount != 0 {
+		ln = &probeListener{Listener: ln, count: c.KeepAliveCount}
+	}
+
+	return ln, nil
+}
+
+// control applies socket options to the listener socket before it is bound. Accepted
+// client connections inherit the options of the listener socket.
+func (c *Config) control(network, address string, rc syscall.RawConn) error {
+	if !c.ReusePort && c.DSCP == 0 && c.ReadBufferSize == 0 && c.WriteBufferSize == 0 &&
+		c.KeepAliveCount == 0 && c.UserTimeout == 0 {
+		return nil
+	}
+
+	var serr error
+	err := rc.Control(func(fd uintptr) {
+		if c.ReusePort {
+			if serr = setReusePort(fd); serr != nil {
+				return
+			}
+		}
+
+		if c.DSCP != 0 {
+			if serr = setTOS(fd, network, c.DSCP<<2); serr != nil {
+				return
+			}
+		}
+
+		if c.ReadBufferSize != 0 || c.WriteBufferSize != 0 {
+			if serr = setBufferSizes(fd, c.ReadBufferSize, c.WriteBufferSize); serr != nil {
+				return
+			}
+		}
+
+		if c.KeepAliveCount != 0 || c.UserTimeout != 0 {
+			serr = setLiveness(fd, c.KeepAliveCount, c.UserTimeout)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	return serr
+}
+
+// interfaceAddress returns the first address of a named network interface which can be
+// used with the network, preferring IPv4 addresses for dual-stack listeners.
+func interfaceAddress(name, network string) (string, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return "", err
+	}
+
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return "", err
+	}
+
+	var v6 string
+	for _, addr := range addrs {
+		ipn, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+
+		if ip4 := ipn.IP.To4(); ip4 != nil {
+			if network != NetworkIPv6 {
+				return ip4.String(), nil
+			}
+			continue
+		}
+
+		if v6 == "" && network != NetworkIPv4 {
+			v6 = ipn.IP.String()
+			if ipn.IP.IsLinkLocalUnicast() {
+				v6 += "%" + iface.Name
+			}
+		}
+	}
+
+	if v6 == "" {
+		return "", fmt.Errorf("%w: %s", ErrNoInterfaceAddress, name)
+	}
+
+	return v6, nil
+}
+
+// delayListener is a net.Listener which enables Nagle's algorithm on accepted TCP
+// connections, which otherwise have TCP_NODELAY set.
+type delayListener struct {
+	net.Listener
+}
+
+// Accept waits for and returns the next connection with TCP_NODELAY cleared.
+func (l *delayListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	if tc, ok := conn.(*net.TCPConn); ok {
+		_ = tc.SetNoDelay(false)
+	}
+
+	return conn, nil
+}
+
+// File returns a duplicate of the underlying listening socket file descriptor.
+func (l *delayListener) File() (*os.File, error) {
+	return listenerFile(l.Listener)
+}
+
+// probeListener is a net.Listener which sets the keepalive probe count of accepted TCP
+// connections. The count is also set on the listening socket so that unsupported platforms
+// fail when binding, but is reset on accepted connections when their keepalives are enabled.
+type probeListener struct {
+	net.Listener
+	count int
+}
+
+// Accept waits for and returns the next connection with the keepalive probe count set.
+func (l *probeListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	if tc, ok := conn.(*net.TCPConn); ok {
+		if rc, err := tc.SyscallConn(); err == nil {
+			_ = rc.Control(func(fd uintptr) {
+				_ = setLiveness(fd, l.count, 0)
+			})
+		}
+	}
+
+	return conn, nil
+}
+
+// File returns a duplicate of the underlying listening socket file descriptor.
+func (l *probeListener) File() (*os.File, error) {
+	return listenerFile(l.Listener)
+}
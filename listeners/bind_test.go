@@ -0,0 +1,104 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package listeners
+
+import (
+	"net"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// loopbackInterface returns the name of the loopback network interface.
+func loopbackInterface(t *testing.T) string {
+	ifaces, err := net.Interfaces()
+	require.NoError(t, err)
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback != 0 {
+			return iface.Name
+		}
+	}
+
+	t.Skip("no loopback interface")
+	return ""
+}
+
+func TestConfigNetwork(t *testing.T) {
+	network, err := (&Config{}).network()
+	require.NoError(t, err)
+	require.Equal(t, NetworkDualStack, network)
+
+	network, err = (&Config{Network: NetworkIPv6}).network()
+	require.NoError(t, err)
+	require.Equal(t, NetworkIPv6, network)
+
+	_, err = (&Config{Network: "udp"}).network()
+	require.ErrorIs(t, err, ErrUnknownNetwork)
+}
+
+func TestConfigListenIPv4(t *testing.T) {
+	ln, err := (&Config{Network: NetworkIPv4}).listen("127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	require.NotNil(t, ln.Addr().(*net.TCPAddr).IP.To4())
+
+	_, err = (&Config{Network: NetworkIPv6}).listen("127.0.0.1:0")
+	require.Error(t, err)
+
+	_, err = (&Config{Network: "udp"}).listen("127.0.0.1:0")
+	require.ErrorIs(t, err, ErrUnknownNetwork)
+}
+
+func TestConfigListenInterface(t *testing.T) {
+	name := loopbackInterface(t)
+	ln, err := (&Config{Network: NetworkIPv4, Interface: name}).listen(":0")
+	require.NoError(t, err)
+	defer ln.Close()
+	require.True(t, ln.Addr().(*net.TCPAddr).IP.IsLoopback())
+
+	_, err = (&Config{Interface: name}).listen("missing-port")
+	require.Error(t, err)
+
+	_, err = (&Config{Interface: "mochi-missing0"}).listen(":0")
+	require.Error(t, err)
+}
+
+func TestInterfaceAddress(t *testing.T) {
+	name := loopbackInterface(t)
+	ip, err := interfaceAddress(name, NetworkIPv4)
+	require.NoError(t, err)
+	require.True(t, net.ParseIP(ip).IsLoopback())
+
+	_, err = interfaceAddress("mochi-missing0", NetworkIPv4)
+	require.Error(t, err)
+}
+
+func TestConfigListenReusePort(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("port reuse test requires linux")
+	}
+
+	c := &Config{Network: NetworkIPv4, ReusePort: true}
+	ln, err := c.listen("127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	ln2, err := c.listen(ln.Addr().String())
+	require.NoError(t, err)
+	ln2.Close()
+
+	_, err = (&Config{Network: NetworkIPv4}).listen(ln.Addr().String())
+	require.Error(t, err)
+}
+
+func TestTCPInitNetwork(t *testing.T) {
+	l := NewTCP(Config{ID: "t1", Address: "127.0.0.1:0", Network: NetworkIPv4})
+	require.NoError(t, l.Init(logger))
+	defer l.listen.Close()
+
+	l = NewTCP(Config{ID: "t2", Address: "127.0.0.1:0", Network: "udp"})
+	require.ErrorIs(t, l.Init(logger), ErrUnknownNetwork)
+}
@@ -0,0 +1,118 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package listeners
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"sync"
+)
+
+var (
+	// ErrCertificateRevoked indicates a client certificate was listed in a certificate revocation list.
+	ErrCertificateRevoked = errors.New("client certificate revoked")
+
+	// ErrInvalidCRL indicates a certificate revocation list file contained no revocation lists.
+	ErrInvalidCRL = errors.New("no certificate revocation lists found")
+)
+
+// revokedCert identifies a revoked certificate by the raw issuer name and serial number.
+type revokedCert struct {
+	issuer string
+	serial string
+}
+
+// CRLChecker rejects TLS connections from clients presenting certificates which have been
+// revoked by a certificate revocation list (CRL) file. Use VerifyConnection as the
+// VerifyConnection callback of a mutual TLS listener tls.Config. The file may contain one
+// or more DER or PEM encoded revocation lists, and can be reloaded while serving.
+type CRLChecker struct {
+	path    string                   // the path to the revocation list file
+	revoked map[revokedCert]struct{} // revoked certificates keyed on issuer and serial number
+	sync.RWMutex
+}
+
+// NewCRLChecker returns a new CRLChecker with the revocation lists loaded from a file.
+func NewCRLChecker(path string) (*CRLChecker, error) {
+	c := &CRLChecker{
+		path: path,
+	}
+
+	if err := c.Reload(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Reload reads the revocation list file, replacing the revoked certificates. If the file
+// cannot be read or parsed, the existing revocation lists are kept.
+func (c *CRLChecker) Reload() error {
+	data, err := os.ReadFile(c.path)
+	if err != nil {
+		return err
+	}
+
+	var ders [][]byte
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN")) {
+		for {
+			var block *pem.Block
+			block, data = pem.Decode(data)
+			if block == nil {
+				break
+			}
+			if block.Type == "X509 CRL" {
+				ders = append(ders, block.Bytes)
+			}
+		}
+	} else {
+		ders = append(ders, data)
+	}
+
+	if len(ders) == 0 {
+		return ErrInvalidCRL
+	}
+
+	revoked := map[revokedCert]struct{}{}
+	for _, der := range ders {
+		crl, err := x509.ParseRevocationList(der)
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range crl.RevokedCertificateEntries {
+			revoked[revokedCert{string(crl.RawIssuer), entry.SerialNumber.String()}] = struct{}{}
+		}
+	}
+
+	c.Lock()
+	c.revoked = revoked
+	c.Unlock()
+	return nil
+}
+
+// Revoked returns true if a certificate has been revoked by its issuer.
+func (c *CRLChecker) Revoked(cert *x509.Certificate) bool {
+	c.RLock()
+	defer c.RUnlock()
+	_, ok := c.revoked[revokedCert{string(cert.RawIssuer), cert.SerialNumber.String()}]
+	return ok
+}
+
+// VerifyConnection returns an error if any certificate presented by the client has been
+// revoked, causing the TLS handshake to fail before the client can connect.
+func (c *CRLChecker) VerifyConnection(cs tls.ConnectionState) error {
+	for _, cert := range cs.PeerCertificates {
+		if c.Revoked(cert) {
+			return ErrCertificateRevoked
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,191 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package listeners
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testCA is a certificate authority for issuing client certificates and revocation lists.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return &testCA{cert: cert, key: key}
+}
+
+// issue returns a client certificate with the serial number signed by the ca.
+func (ca *testCA) issue(t *testing.T, serial int64) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "mochi-client"},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// writeCRL writes a PEM encoded revocation list revoking the serial numbers to path.
+func (ca *testCA) writeCRL(t *testing.T, path string, serials ...int64) {
+	template := &x509.RevocationList{
+		Number:     big.NewInt(time.Now().UnixNano()),
+		ThisUpdate: time.Now().Add(-time.Minute),
+		NextUpdate: time.Now().Add(time.Hour),
+	}
+
+	for _, serial := range serials {
+		template.RevokedCertificateEntries = append(template.RevokedCertificateEntries, x509.RevocationListEntry{
+			SerialNumber:   big.NewInt(serial),
+			RevocationTime: time.Now(),
+		})
+	}
+
+	der, err := x509.CreateRevocationList(rand.Reader, template, ca.cert, ca.key)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der}), 0600))
+}
+
+func TestCRLCheckerRevoked(t *testing.T) {
+	ca := newTestCA(t, "mochi-ca")
+	path := filepath.Join(t.TempDir(), "ca.crl")
+	ca.writeCRL(t, path, 2)
+
+	c, err := NewCRLChecker(path)
+	require.NoError(t, err)
+	require.True(t, c.Revoked(ca.issue(t, 2).Leaf))
+	require.False(t, c.Revoked(ca.issue(t, 3).Leaf))
+
+	// the same serial from a different issuer is not revoked.
+	require.False(t, c.Revoked(newTestCA(t, "other-ca").issue(t, 2).Leaf))
+
+	ca.writeCRL(t, path, 3)
+	require.NoError(t, c.Reload())
+	require.False(t, c.Revoked(ca.issue(t, 2).Leaf))
+	require.True(t, c.Revoked(ca.issue(t, 3).Leaf))
+}
+
+func TestCRLCheckerDER(t *testing.T) {
+	ca := newTestCA(t, "mochi-ca")
+	path := filepath.Join(t.TempDir(), "ca.crl")
+	ca.writeCRL(t, path, 2)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	block, _ := pem.Decode(data)
+	require.NoError(t, os.WriteFile(path, block.Bytes, 0600))
+
+	c, err := NewCRLChecker(path)
+	require.NoError(t, err)
+	require.True(t, c.Revoked(ca.issue(t, 2).Leaf))
+}
+
+func TestCRLCheckerInvalid(t *testing.T) {
+	dir := t.TempDir()
+	_, err := NewCRLChecker(filepath.Join(dir, "missing.crl"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	path := filepath.Join(dir, "ca.crl")
+	require.NoError(t, os.WriteFile(path, testCertificate, 0600))
+	_, err = NewCRLChecker(path)
+	require.ErrorIs(t, err, ErrInvalidCRL)
+
+	require.NoError(t, os.WriteFile(path, []byte("not a crl"), 0600))
+	_, err = NewCRLChecker(path)
+	require.Error(t, err)
+
+	// a failed reload keeps the existing revocation lists.
+	ca := newTestCA(t, "mochi-ca")
+	ca.writeCRL(t, path, 2)
+	c, err := NewCRLChecker(path)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, []byte("not a crl"), 0600))
+	require.Error(t, c.Reload())
+	require.True(t, c.Revoked(ca.issue(t, 2).Leaf))
+}
+
+func TestCRLCheckerHandshake(t *testing.T) {
+	ca := newTestCA(t, "mochi-ca")
+	path := filepath.Join(t.TempDir(), "ca.crl")
+	ca.writeCRL(t, path, 2)
+	c, err := NewCRLChecker(path)
+	require.NoError(t, err)
+
+	serverCert, err := tls.X509KeyPair(testCertificate, testPrivateKey)
+	require.NoError(t, err)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	config := &tls.Config{
+		MinVersion:       tls.VersionTLS12,
+		Certificates:     []tls.Certificate{serverCert},
+		ClientAuth:       tls.RequireAndVerifyClientCert,
+		ClientCAs:        pool,
+		VerifyConnection: c.VerifyConnection,
+	}
+
+	connect := func(cert tls.Certificate) error {
+		sc, cc := net.Pipe()
+		defer sc.Close()
+		defer cc.Close()
+
+		errs := make(chan error, 1)
+		go func() {
+			err := tls.Server(sc, config).Handshake()
+			_ = sc.Close()
+			errs <- err
+		}()
+
+		client := tls.Client(cc, &tls.Config{InsecureSkipVerify: true, Certificates: []tls.Certificate{cert}}) // #nosec G402
+		_ = client.Handshake()
+		_, _ = client.Read(make([]byte, 1)) // receive any alert from the server
+		return <-errs
+	}
+
+	require.NoError(t, connect(ca.issue(t, 3)))
+	require.ErrorIs(t, connect(ca.issue(t, 2)), ErrCertificateRevoked)
+}
@@ -0,0 +1,105 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package listeners
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// HTTPOptions contains access control settings for http listeners, so they can be exposed
+// beyond localhost. Requests must present either the bearer token or the basic auth
+// credentials if any are set.
+type HTTPOptions struct {
+	Token          string   `yaml:"token" json:"token"`                     // a bearer token expected in the Authorization header
+	Username       string   `yaml:"username" json:"username"`               // a basic auth username
+	Password       string   `yaml:"password" json:"password"`               // a basic auth password
+	AllowedOrigins []string `yaml:"allowed_origins" json:"allowed_origins"` // origins allowed to make cross-origin requests, or * for any
+}
+
+// authorized returns true if the request presents the configured token or credentials,
+// or if none are configured.
+func (o *HTTPOptions) authorized(r *http.Request) bool {
+	if o.Token == "" && o.Username == "" && o.Password == "" {
+		return true
+	}
+
+	if o.Token != "" {
+		if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			if subtle.ConstantTimeCompare([]byte(token), []byte(o.Token)) == 1 {
+				return true
+			}
+		}
+	}
+
+	if o.Username != "" || o.Password != "" {
+		if u, p, ok := r.BasicAuth(); ok {
+			uok := subtle.ConstantTimeCompare([]byte(u), []byte(o.Username)) == 1
+			pok := subtle.ConstantTimeCompare([]byte(p), []byte(o.Password)) == 1
+			if uok && pok {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// allowedOrigin returns the value of the Access-Control-Allow-Origin header for a
+// request origin, or an empty string if the origin is not allowed, and whether the
+// origin may send credentials. Only explicitly listed origins are echoed and allowed
+// credentials; other origins receive a literal * if any origin is allowed.
+func (o *HTTPOptions) allowedOrigin(origin string) (string, bool) {
+	if origin == "" {
+		return "", false
+	}
+
+	wildcard := false
+	for _, v := range o.AllowedOrigins {
+		if v == "*" {
+			wildcard = true
+		} else if strings.EqualFold(v, origin) {
+			return origin, true
+		}
+	}
+
+	if wildcard {
+		return "*", false
+	}
+
+	return "", false
+}
+
+// handler wraps an http handler with the cors and authorization policy of the options.
+func (o *HTTPOptions) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(o.AllowedOrigins) > 0 {
+			w.Header().Add("Vary", "Origin")
+			if origin, credentials := o.allowedOrigin(r.Header.Get("Origin")); origin != "" {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				if credentials {
+					w.Header().Set("Access-Control-Allow-Credentials", "true")
+				}
+				if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+					w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+					w.Header().Set("Access-Control-Allow-Headers", "Authorization")
+					w.WriteHeader(http.StatusNoContent)
+					return
+				}
+			}
+		}
+
+		if !o.authorized(r) {
+			if o.Username != "" || o.Password != "" {
+				w.Header().Set("WWW-Authenticate", `Basic realm="mochi"`)
+			}
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,98 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package listeners
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mochi-mqtt/server/v2/system"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPOptionsAuthorized(t *testing.T) {
+	o := &HTTPOptions{}
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	require.True(t, o.authorized(r))
+
+	o = &HTTPOptions{Token: "secret", Username: "admin", Password: "pass"}
+	require.False(t, o.authorized(r))
+
+	r.Header.Set("Authorization", "Bearer wrong")
+	require.False(t, o.authorized(r))
+
+	r.Header.Set("Authorization", "Bearer secret")
+	require.True(t, o.authorized(r))
+
+	r = httptest.NewRequest(http.MethodGet, "/", nil)
+	r.SetBasicAuth("admin", "wrong")
+	require.False(t, o.authorized(r))
+
+	r.SetBasicAuth("admin", "pass")
+	require.True(t, o.authorized(r))
+}
+
+func TestHTTPOptionsAllowedOrigin(t *testing.T) {
+	o := &HTTPOptions{AllowedOrigins: []string{"https://dashboard.example.com"}}
+	origin, credentials := o.allowedOrigin("")
+	require.Equal(t, "", origin)
+	require.False(t, credentials)
+
+	origin, _ = o.allowedOrigin("https://evil.example.com")
+	require.Equal(t, "", origin)
+
+	origin, credentials = o.allowedOrigin("https://dashboard.example.com")
+	require.Equal(t, "https://dashboard.example.com", origin)
+	require.True(t, credentials)
+
+	// any origin is allowed without credentials, while listed origins keep them.
+	o.AllowedOrigins = []string{"*", "https://dashboard.example.com"}
+	origin, credentials = o.allowedOrigin("https://evil.example.com")
+	require.Equal(t, "*", origin)
+	require.False(t, credentials)
+
+	origin, credentials = o.allowedOrigin("https://dashboard.example.com")
+	require.Equal(t, "https://dashboard.example.com", origin)
+	require.True(t, credentials)
+}
+
+func TestHTTPStatsAuthorization(t *testing.T) {
+	config := basicConfig
+	config.HTTP = &HTTPOptions{Token: "secret", AllowedOrigins: []string{"https://dashboard.example.com"}}
+	l := NewHTTPStats(config, &system.Info{Version: "test"})
+	require.NoError(t, l.Init(logger))
+
+	w := httptest.NewRecorder()
+	l.listen.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	r.Header.Set("Origin", "https://dashboard.example.com")
+	w = httptest.NewRecorder()
+	l.listen.Handler.ServeHTTP(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "https://dashboard.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	require.Equal(t, "true", w.Header().Get("Access-Control-Allow-Credentials"))
+	require.Contains(t, w.Body.String(), `"version": "test"`)
+}
+
+func TestHTTPStatsPreflight(t *testing.T) {
+	config := basicConfig
+	config.HTTP = &HTTPOptions{Token: "secret", AllowedOrigins: []string{"*"}}
+	l := NewHTTPStats(config, &system.Info{})
+	require.NoError(t, l.Init(logger))
+
+	r := httptest.NewRequest(http.MethodOptions, "/", nil)
+	r.Header.Set("Origin", "https://dashboard.example.com")
+	r.Header.Set("Access-Control-Request-Method", http.MethodGet)
+	w := httptest.NewRecorder()
+	l.listen.Handler.ServeHTTP(w, r)
+	require.Equal(t, http.StatusNoContent, w.Code)
+	require.Equal(t, "Authorization", w.Header().Get("Access-Control-Allow-Headers"))
+	require.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"))
+	require.Empty(t, w.Header().Get("Access-Control-Allow-Credentials"))
+}
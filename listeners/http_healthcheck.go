@@ -55,6 +55,10 @@ func (l *HTTPHealthCheck) Protocol() string {
 
 // Init initializes the listener.
 func (l *HTTPHealthCheck) Init(_ *slog.Logger) error {
+	if err := l.config.loadTLSConfig(); err != nil {
+		return err
+	}
+
 	mux := http.NewServeMux()
 	mux.HandleFunc("/healthcheck", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
@@ -0,0 +1,104 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package listeners
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/pprof"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const TypePprof = "pprof"
+
+// HTTPPprof is a listener for serving the net/http/pprof profiling endpoints
+// under /debug/pprof/, for diagnosing performance issues on live brokers.
+type HTTPPprof struct {
+	sync.RWMutex
+	id      string       // the internal id of the listener
+	address string       // the network address to bind to
+	config  Config       // configuration values for the listener
+	listen  *http.Server // the http server
+	end     uint32       // ensure the close methods are only called once
+}
+
+// NewHTTPPprof initializes and returns a new HTTP pprof listener, listening on an address.
+func NewHTTPPprof(config Config) *HTTPPprof {
+	return &HTTPPprof{
+		id:      config.ID,
+		address: config.Address,
+		config:  config,
+	}
+}
+
+// ID returns the id of the listener.
+func (l *HTTPPprof) ID() string {
+	return l.id
+}
+
+// Address returns the address of the listener.
+func (l *HTTPPprof) Address() string {
+	return l.address
+}
+
+// Protocol returns the address of the listener.
+func (l *HTTPPprof) Protocol() string {
+	if l.listen != nil && l.listen.TLSConfig != nil {
+		return "https"
+	}
+
+	return "http"
+}
+
+// Init initializes the listener.
+func (l *HTTPPprof) Init(_ *slog.Logger) error {
+	if err := l.config.loadTLSConfig(); err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	l.listen = &http.Server{
+		ReadTimeout: 5 * time.Second,
+		Addr:        l.address,
+		Handler:     mux,
+	}
+
+	if l.config.TLSConfig != nil {
+		l.listen.TLSConfig = l.config.TLSConfig
+	}
+
+	return nil
+}
+
+// Serve starts listening for new connections and serving responses.
+func (l *HTTPPprof) Serve(establish EstablishFn) {
+	if l.listen.TLSConfig != nil {
+		_ = l.listen.ListenAndServeTLS("", "")
+	} else {
+		_ = l.listen.ListenAndServe()
+	}
+}
+
+// Close closes the listener and any client connections.
+func (l *HTTPPprof) Close(closeClients CloseFn) {
+	l.Lock()
+	defer l.Unlock()
+
+	if atomic.CompareAndSwapUint32(&l.end, 0, 1) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = l.listen.Shutdown(ctx)
+	}
+
+	closeClients(l.id)
+}
@@ -0,0 +1,100 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package listeners
+
+import (
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewHTTPPprof(t *testing.T) {
+	l := NewHTTPPprof(basicConfig)
+	require.Equal(t, basicConfig.ID, l.id)
+	require.Equal(t, basicConfig.Address, l.address)
+}
+
+func TestHTTPPprofID(t *testing.T) {
+	l := NewHTTPPprof(basicConfig)
+	require.Equal(t, basicConfig.ID, l.ID())
+}
+
+func TestHTTPPprofAddress(t *testing.T) {
+	l := NewHTTPPprof(basicConfig)
+	require.Equal(t, basicConfig.Address, l.Address())
+}
+
+func TestHTTPPprofProtocol(t *testing.T) {
+	l := NewHTTPPprof(basicConfig)
+	require.Equal(t, "http", l.Protocol())
+}
+
+func TestHTTPPprofTLSProtocol(t *testing.T) {
+	l := NewHTTPPprof(tlsConfig)
+	_ = l.Init(logger)
+	require.Equal(t, "https", l.Protocol())
+}
+
+func TestHTTPPprofInit(t *testing.T) {
+	l := NewHTTPPprof(basicConfig)
+	err := l.Init(logger)
+	require.NoError(t, err)
+
+	require.NotNil(t, l.listen)
+	require.Equal(t, basicConfig.Address, l.listen.Addr)
+}
+
+func TestHTTPPprofServeAndClose(t *testing.T) {
+	l := NewHTTPPprof(basicConfig)
+	err := l.Init(logger)
+	require.NoError(t, err)
+
+	o := make(chan bool)
+	go func(o chan bool) {
+		l.Serve(MockEstablisher)
+		o <- true
+	}(o)
+
+	time.Sleep(time.Millisecond)
+
+	resp, err := http.Get("http://localhost" + testAddr + "/debug/pprof/goroutine?debug=1")
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Contains(t, string(body), "goroutine profile")
+
+	var closed bool
+	l.Close(func(id string) {
+		closed = true
+	})
+
+	require.Equal(t, true, closed)
+
+	_, err = http.Get("http://localhost" + testAddr + "/debug/pprof/")
+	require.Error(t, err)
+	<-o
+}
+
+func TestHTTPPprofServeTLSAndClose(t *testing.T) {
+	l := NewHTTPPprof(tlsConfig)
+	err := l.Init(logger)
+	require.NoError(t, err)
+
+	o := make(chan bool)
+	go func(o chan bool) {
+		l.Serve(MockEstablisher)
+		o <- true
+	}(o)
+
+	time.Sleep(time.Millisecond)
+	l.Close(MockCloser)
+}
@@ -63,13 +63,22 @@ func (l *HTTPStats) Protocol() string {
 // Init initializes the listener.
 func (l *HTTPStats) Init(log *slog.Logger) error {
 	l.log = log
+	if err := l.config.loadTLSConfig(); err != nil {
+		return err
+	}
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", l.jsonHandler)
+
+	var handler http.Handler = mux
+	if l.config.HTTP != nil {
+		handler = l.config.HTTP.handler(mux)
+	}
+
 	l.listen = &http.Server{
 		ReadTimeout:  5 * time.Second,
 		WriteTimeout: 5 * time.Second,
 		Addr:         l.address,
-		Handler:      mux,
+		Handler:      handler,
 	}
 
 	if l.config.TLSConfig != nil {
@@ -8,6 +8,7 @@ import (
 	"crypto/tls"
 	"net"
 	"sync"
+	"time"
 
 	"log/slog"
 )
@@ -19,6 +20,60 @@ type Config struct {
 	Address string
 	// TLSConfig is a tls.Config configuration to be used with the listener. See examples folder for basic and mutual-tls use.
 	TLSConfig *tls.Config
+	// TLS contains TLS settings, such as certificate files and allowed versions, used to build
+	// the TLSConfig when the listener is initialized if no TLSConfig is set.
+	TLS *TLSOptions
+	// Network selects the address family of tcp and websocket listeners; tcp for dual-stack
+	// (the default), tcp4 for IPv4 only, or tcp6 for IPv6 only.
+	Network string
+	// Interface is the name of a network interface to bind to, eg. eth0. The host of the
+	// address is replaced with the first interface address usable by the network.
+	Interface string
+	// ReusePort sets SO_REUSEPORT on the listener socket, allowing multiple listeners or
+	// processes to bind the same address.
+	ReusePort bool
+	// DSCP is the differentiated services code point (0-63) marked on packets sent to
+	// clients, eg. 46 for expedited forwarding. Zero leaves the system default.
+	DSCP int
+	// DisableNoDelay enables Nagle's algorithm on client connections, batching small
+	// writes at the cost of latency. TCP_NODELAY is set by default.
+	DisableNoDelay bool
+	// KeepAlive is the period between TCP keepalive probes on client connections, used to
+	// detect dead peers and NAT mappings. Zero uses the system default of 15 seconds, and a
+	// negative value disables keepalives.
+	KeepAlive time.Duration
+	// KeepAliveCount is the number of unanswered TCP keepalive probes after which a client
+	// connection is considered dead and closed, so a silent connection whose peer has
+	// vanished is reaped after KeepAlive * (KeepAliveCount + 1). Zero uses the system
+	// default, which is 9 on Linux. Linux only.
+	KeepAliveCount int
+	// UserTimeout is the maximum time data sent to a client may remain unacknowledged before
+	// the connection is closed (TCP_USER_TIMEOUT), reaping dead subscribers without waiting
+	// for retransmissions to give up, which can take over 15 minutes. Zero uses the system
+	// default. Linux only.
+	UserTimeout time.Duration
+	// KeepaliveGrace is the multiple of the keepalive interval of a client after which its
+	// connection is closed if no packet has been received. Defaults to 1.5, as required by
+	// the MQTT specification. Larger values tolerate the delays of cellular networks, and
+	// smaller values enforce keepalives strictly.
+	KeepaliveGrace float64
+	// ReadOnly rejects all publishes from clients of the listener, including their will
+	// messages, while allowing them to subscribe, eg. for public data feed endpoints.
+	ReadOnly bool
+	// WriteOnly denies all subscriptions from clients of the listener, while allowing them
+	// to publish, eg. for sensor ingestion endpoints where devices must never receive each
+	// other's data.
+	WriteOnly bool
+	// ReadBufferSize and WriteBufferSize set the kernel receive and send buffer sizes
+	// (SO_RCVBUF and SO_SNDBUF) in bytes of client connections, eg. to fill high latency
+	// links. Zero uses the system default. The kernel may adjust or cap the sizes.
+	ReadBufferSize  int
+	WriteBufferSize int
+	// Websocket contains settings for websocket listeners, such as compression and the
+	// accepted subprotocols. Ignored by other listener types.
+	Websocket *WebsocketOptions
+	// HTTP contains authorization and cors settings for the http stats listener.
+	HTTP *HTTPOptions
 }
 
 // EstablishFn is a callback function for establishing new clients.
@@ -38,6 +93,11 @@ type Listener interface {
 	Close(CloseFn)           // stop and close the listener
 }
 
+// Configured is implemented by listeners which expose the configuration they were created with.
+type Configured interface {
+	Config() Config
+}
+
 // Listeners contains the network listeners for the broker.
 type Listeners struct {
 	ClientsWg sync.WaitGroup      // a waitgroup that waits for all clients in all listeners to finish.
@@ -74,6 +134,17 @@ func (l *Listeners) Len() int {
 	return len(l.internal)
 }
 
+// IDs returns the ids of all registered listeners.
+func (l *Listeners) IDs() []string {
+	l.RLock()
+	defer l.RUnlock()
+	ids := make([]string, 0, len(l.internal))
+	for id := range l.internal {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
 // Delete removes a listener from the internal map.
 func (l *Listeners) Delete(id string) {
 	l.Lock()
@@ -94,16 +165,7 @@ func (l *Listeners) Serve(id string, establisher EstablishFn) {
 
 // ServeAll starts all listeners serving from the internal map.
 func (l *Listeners) ServeAll(establisher EstablishFn) {
-	l.RLock()
-	i := 0
-	ids := make([]string, len(l.internal))
-	for id := range l.internal {
-		ids[i] = id
-		i++
-	}
-	l.RUnlock()
-
-	for _, id := range ids {
+	for _, id := range l.IDs() {
 		l.Serve(id, establisher)
 	}
 }
@@ -119,16 +181,7 @@ func (l *Listeners) Close(id string, closer CloseFn) {
 
 // CloseAll iterates and closes all registered listeners.
 func (l *Listeners) CloseAll(closer CloseFn) {
-	l.RLock()
-	i := 0
-	ids := make([]string, len(l.internal))
-	for id := range l.internal {
-		ids[i] = id
-		i++
-	}
-	l.RUnlock()
-
-	for _, id := range ids {
+	for _, id := range l.IDs() {
 		l.Close(id, closer)
 	}
 	l.ClientsWg.Wait()
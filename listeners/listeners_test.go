@@ -124,6 +124,15 @@ func TestServeListener(t *testing.T) {
 	require.False(t, l.internal["t1"].(*MockListener).IsServing())
 }
 
+func TestListenersIDs(t *testing.T) {
+	l := New()
+	require.Empty(t, l.IDs())
+
+	l.Add(NewMockListener("t1", testAddr))
+	l.Add(NewMockListener("t2", testAddr))
+	require.ElementsMatch(t, []string{"t1", "t2"}, l.IDs())
+}
+
 func TestServeAllListeners(t *testing.T) {
 	l := New()
 	l.Add(NewMockListener("t1", testAddr))
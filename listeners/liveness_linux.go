@@ -0,0 +1,30 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+//go:build linux
+
+package listeners
+
+import (
+	"syscall"
+	"time"
+)
+
+// tcpUserTimeout is the value of TCP_USER_TIMEOUT, which the syscall package does not define.
+const tcpUserTimeout = 0x12
+
+// setLiveness sets the number of keepalive probes and the user timeout of a socket, where non-zero.
+func setLiveness(fd uintptr, count int, timeout time.Duration) error {
+	if count != 0 {
+		if err := syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_KEEPCNT, count); err != nil {
+			return err
+		}
+	}
+
+	if timeout != 0 {
+		return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, tcpUserTimeout, int(timeout.Milliseconds()))
+	}
+
+	return nil
+}
@@ -0,0 +1,31 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+//go:build linux
+
+package listeners
+
+import (
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigListenKeepAliveCount(t *testing.T) {
+	def := acceptSockopt(t, &Config{}, syscall.IPPROTO_TCP, syscall.TCP_KEEPCNT)
+	require.Equal(t, def+2, acceptSockopt(t, &Config{KeepAliveCount: def + 2}, syscall.IPPROTO_TCP, syscall.TCP_KEEPCNT))
+
+	_, err := (&Config{KeepAliveCount: -1}).listen("127.0.0.1:0")
+	require.ErrorIs(t, err, ErrInvalidLiveness)
+}
+
+func TestConfigListenUserTimeout(t *testing.T) {
+	require.Equal(t, 0, acceptSockopt(t, &Config{}, syscall.IPPROTO_TCP, tcpUserTimeout))
+	require.Equal(t, 45000, acceptSockopt(t, &Config{UserTimeout: time.Second * 45}, syscall.IPPROTO_TCP, tcpUserTimeout))
+
+	_, err := (&Config{UserTimeout: -time.Second}).listen("127.0.0.1:0")
+	require.ErrorIs(t, err, ErrInvalidLiveness)
+}
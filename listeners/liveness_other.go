@@ -0,0 +1,14 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+//go:build !linux
+
+package listeners
+
+import "time"
+
+// setLiveness returns an error, as keepalive probe counts and user timeouts are not supported on this platform.
+func setLiveness(fd uintptr, count int, timeout time.Duration) error {
+	return ErrLivenessUnsupported
+}
@@ -5,7 +5,7 @@
 package listeners
 
 import (
-	"fmt"
+	"errors"
 	"net"
 	"sync"
 
@@ -14,6 +14,9 @@ import (
 
 const TypeMock = "mock"
 
+// ErrMockListenFailure is returned when initializing a mock listener which is set to fail.
+var ErrMockListenFailure = errors.New("listen failure")
+
 // MockEstablisher is a function signature which can be used in testing.
 func MockEstablisher(id string, c net.Conn) error {
 	return nil
@@ -57,7 +60,7 @@ func (l *MockListener) Serve(establisher EstablishFn) {
 // Init initializes the listener.
 func (l *MockListener) Init(log *slog.Logger) error {
 	if l.ErrListen {
-		return fmt.Errorf("listen failure")
+		return ErrMockListenFailure
 	}
 
 	l.Lock()
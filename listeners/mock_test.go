@@ -63,7 +63,7 @@ func TestNewMockListenerInitFailure(t *testing.T) {
 	mocked := NewMockListener("t1", testAddr)
 	mocked.ErrListen = true
 	err := mocked.Init(nil)
-	require.Error(t, err)
+	require.ErrorIs(t, err, ErrMockListenFailure)
 }
 
 func TestMockListenerServe(t *testing.T) {
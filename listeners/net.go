@@ -53,6 +53,7 @@ func (l *Net) Init(log *slog.Logger) error {
 // Serve starts waiting for new TCP connections, and calls the establish
 // connection callback for any received.
 func (l *Net) Serve(establish EstablishFn) {
+	var backoff acceptBackoff
 	for {
 		if atomic.LoadUint32(&l.end) == 1 {
 			return
@@ -60,8 +61,12 @@ func (l *Net) Serve(establish EstablishFn) {
 
 		conn, err := l.listener.Accept()
 		if err != nil {
+			if atomic.LoadUint32(&l.end) == 0 && backoff.retry(l.log, l.id, err) {
+				continue
+			}
 			return
 		}
+		backoff.reset(l.log, l.id)
 
 		if atomic.LoadUint32(&l.end) == 0 {
 			go func() {
@@ -0,0 +1,164 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package listeners
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+const (
+	defaultOCSPTimeout  = 5 * time.Second // the default timeout for requests to an ocsp responder
+	defaultOCSPCacheTTL = time.Hour       // how long a response without a next update time is cached
+	maxOCSPResponseSize = 1 << 20         // the maximum size of a response read from an ocsp responder
+)
+
+var (
+	// ErrOCSPUnavailable indicates the revocation status of a client certificate could not be determined.
+	ErrOCSPUnavailable = errors.New("client certificate ocsp status unavailable")
+
+	// ErrNoOCSPResponder indicates a certificate did not name an ocsp responder and none was configured.
+	ErrNoOCSPResponder = errors.New("no ocsp responder for certificate")
+)
+
+// OCSPOptions configures how certificate revocation status is requested from an OCSP responder.
+type OCSPOptions struct {
+	Responder string        `yaml:"responder" json:"responder"` // the responder url, overriding any named by the certificate
+	Timeout   time.Duration `yaml:"timeout" json:"timeout"`     // the timeout for each request, 5 seconds by default
+	FailOpen  bool          `yaml:"fail_open" json:"fail_open"` // accept clients whose status cannot be determined
+}
+
+// fetchOCSP requests the revocation status of a certificate from an OCSP responder, returning
+// the parsed response and the raw bytes as received. The responder named by the certificate
+// is used if responder is empty.
+func fetchOCSP(client *http.Client, responder string, cert, issuer *x509.Certificate) (*ocsp.Response, []byte, error) {
+	if responder == "" {
+		if len(cert.OCSPServer) == 0 {
+			return nil, nil, ErrNoOCSPResponder
+		}
+		responder = cert.OCSPServer[0]
+	}
+
+	req, err := ocsp.CreateRequest(cert, issuer, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	resp, err := client.Post(responder, "application/ocsp-request", bytes.NewReader(req))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("ocsp responder returned %s", resp.Status)
+	}
+
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, maxOCSPResponseSize))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	res, err := ocsp.ParseResponseForCert(raw, cert, issuer)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return res, raw, nil
+}
+
+// ocspStatus is a cached revocation status.
+type ocspStatus struct {
+	status  int       // the ocsp status of the certificate
+	expires time.Time // when the status should be requested again
+}
+
+// OCSPChecker rejects TLS connections from clients presenting certificates which an OCSP
+// responder reports as revoked. Use VerifyConnection as the VerifyConnection callback of a
+// mutual TLS listener tls.Config which verifies client certificates. Statuses are cached
+// until the next update time given by the responder, and failed requests are never cached.
+type OCSPChecker struct {
+	opts   OCSPOptions
+	client *http.Client
+	cache  map[revokedCert]ocspStatus // cached statuses keyed on issuer and serial number
+	sync.Mutex
+}
+
+// NewOCSPChecker returns a new OCSPChecker.
+func NewOCSPChecker(opts OCSPOptions) *OCSPChecker {
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultOCSPTimeout
+	}
+
+	return &OCSPChecker{
+		opts:   opts,
+		client: &http.Client{Timeout: opts.Timeout},
+		cache:  map[revokedCert]ocspStatus{},
+	}
+}
+
+// Status returns the ocsp status of a certificate issued by issuer, one of ocsp.Good,
+// ocsp.Revoked, or ocsp.Unknown, requesting it from the responder if it is not cached.
+func (c *OCSPChecker) Status(cert, issuer *x509.Certificate) (int, error) {
+	key := revokedCert{string(cert.RawIssuer), cert.SerialNumber.String()}
+
+	c.Lock()
+	cached, ok := c.cache[key]
+	c.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.status, nil
+	}
+
+	res, _, err := fetchOCSP(c.client, c.opts.Responder, cert, issuer)
+	if err != nil {
+		return ocsp.Unknown, err
+	}
+
+	expires := res.NextUpdate
+	if expires.IsZero() {
+		expires = time.Now().Add(defaultOCSPCacheTTL)
+	}
+
+	c.Lock()
+	for k, v := range c.cache {
+		if time.Now().After(v.expires) {
+			delete(c.cache, k)
+		}
+	}
+	c.cache[key] = ocspStatus{status: res.Status, expires: expires}
+	c.Unlock()
+
+	return res.Status, nil
+}
+
+// VerifyConnection returns an error if the verified client certificate has been revoked,
+// causing the TLS handshake to fail before the client can connect. If the status cannot be
+// determined the handshake also fails, unless FailOpen is set.
+func (c *OCSPChecker) VerifyConnection(cs tls.ConnectionState) error {
+	if len(cs.VerifiedChains) == 0 || len(cs.VerifiedChains[0]) < 2 {
+		return nil // no client certificate, or a certificate trusted directly
+	}
+
+	status, err := c.Status(cs.VerifiedChains[0][0], cs.VerifiedChains[0][1])
+	switch {
+	case status == ocsp.Revoked:
+		return ErrCertificateRevoked
+	case status == ocsp.Good, c.opts.FailOpen:
+		return nil
+	case err != nil:
+		return fmt.Errorf("%w: %v", ErrOCSPUnavailable, err)
+	}
+
+	return ErrOCSPUnavailable
+}
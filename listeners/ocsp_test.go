@@ -0,0 +1,163 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package listeners
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ocsp"
+)
+
+// newOCSPResponder returns a test ocsp responder for the ca which reports the serial numbers
+// as revoked, and a counter of the requests it has received.
+func newOCSPResponder(t *testing.T, ca *testCA, revoked ...int64) (*httptest.Server, *atomic.Int64) {
+	var requests atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		req, err := ocsp.ParseRequest(body)
+		require.NoError(t, err)
+
+		template := ocsp.Response{
+			Status:       ocsp.Good,
+			SerialNumber: req.SerialNumber,
+			ThisUpdate:   time.Now().Add(-time.Minute),
+			NextUpdate:   time.Now().Add(time.Hour),
+		}
+
+		for _, serial := range revoked {
+			if req.SerialNumber.Int64() == serial {
+				template.Status = ocsp.Revoked
+				template.RevokedAt = time.Now().Add(-time.Minute)
+			}
+		}
+
+		res, err := ocsp.CreateResponse(ca.cert, ca.cert, template, ca.key)
+		require.NoError(t, err)
+		_, _ = w.Write(res)
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, &requests
+}
+
+func TestNewOCSPChecker(t *testing.T) {
+	c := NewOCSPChecker(OCSPOptions{})
+	require.Equal(t, defaultOCSPTimeout, c.opts.Timeout)
+	require.Equal(t, defaultOCSPTimeout, c.client.Timeout)
+}
+
+func TestOCSPCheckerStatus(t *testing.T) {
+	ca := newTestCA(t, "mochi-ca")
+	srv, requests := newOCSPResponder(t, ca, 2)
+	c := NewOCSPChecker(OCSPOptions{Responder: srv.URL})
+
+	status, err := c.Status(ca.issue(t, 2).Leaf, ca.cert)
+	require.NoError(t, err)
+	require.Equal(t, ocsp.Revoked, status)
+
+	good := ca.issue(t, 3).Leaf
+	status, err = c.Status(good, ca.cert)
+	require.NoError(t, err)
+	require.Equal(t, ocsp.Good, status)
+	require.Equal(t, int64(2), requests.Load())
+
+	// cached until the next update.
+	status, err = c.Status(good, ca.cert)
+	require.NoError(t, err)
+	require.Equal(t, ocsp.Good, status)
+	require.Equal(t, int64(2), requests.Load())
+}
+
+func TestOCSPCheckerStatusErrors(t *testing.T) {
+	ca := newTestCA(t, "mochi-ca")
+
+	_, err := NewOCSPChecker(OCSPOptions{}).Status(ca.issue(t, 2).Leaf, ca.cert)
+	require.ErrorIs(t, err, ErrNoOCSPResponder)
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	_, err = NewOCSPChecker(OCSPOptions{Responder: failing.URL}).Status(ca.issue(t, 2).Leaf, ca.cert)
+	require.Error(t, err)
+
+	// a response signed by another ca is rejected.
+	srv, _ := newOCSPResponder(t, newTestCA(t, "other-ca"))
+	_, err = NewOCSPChecker(OCSPOptions{Responder: srv.URL}).Status(ca.issue(t, 2).Leaf, ca.cert)
+	require.Error(t, err)
+}
+
+func TestOCSPCheckerVerifyConnection(t *testing.T) {
+	ca := newTestCA(t, "mochi-ca")
+	srv, requests := newOCSPResponder(t, ca, 2)
+	c := NewOCSPChecker(OCSPOptions{Responder: srv.URL})
+
+	chain := func(serial int64) tls.ConnectionState {
+		return tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{ca.issue(t, serial).Leaf, ca.cert}}}
+	}
+
+	require.NoError(t, c.VerifyConnection(tls.ConnectionState{}))
+	require.NoError(t, c.VerifyConnection(tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{ca.cert}}}))
+	require.Equal(t, int64(0), requests.Load())
+
+	require.NoError(t, c.VerifyConnection(chain(3)))
+	require.ErrorIs(t, c.VerifyConnection(chain(2)), ErrCertificateRevoked)
+
+	srv.Close()
+	require.ErrorIs(t, c.VerifyConnection(chain(4)), ErrOCSPUnavailable)
+
+	c = NewOCSPChecker(OCSPOptions{Responder: srv.URL, FailOpen: true})
+	require.NoError(t, c.VerifyConnection(chain(4)))
+}
+
+func TestOCSPCheckerHandshake(t *testing.T) {
+	ca := newTestCA(t, "mochi-ca")
+	srv, _ := newOCSPResponder(t, ca, 2)
+	c := NewOCSPChecker(OCSPOptions{Responder: srv.URL})
+
+	serverCert, err := tls.X509KeyPair(testCertificate, testPrivateKey)
+	require.NoError(t, err)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	config := &tls.Config{
+		MinVersion:       tls.VersionTLS12,
+		Certificates:     []tls.Certificate{serverCert},
+		ClientAuth:       tls.RequireAndVerifyClientCert,
+		ClientCAs:        pool,
+		VerifyConnection: c.VerifyConnection,
+	}
+
+	connect := func(cert tls.Certificate) error {
+		sc, cc := net.Pipe()
+		defer sc.Close()
+		defer cc.Close()
+
+		errs := make(chan error, 1)
+		go func() {
+			err := tls.Server(sc, config).Handshake()
+			_ = sc.Close()
+			errs <- err
+		}()
+
+		client := tls.Client(cc, &tls.Config{InsecureSkipVerify: true, Certificates: []tls.Certificate{cert}}) // #nosec G402
+		_ = client.Handshake()
+		_, _ = client.Read(make([]byte, 1)) // receive any alert from the server
+		return <-errs
+	}
+
+	require.NoError(t, connect(ca.issue(t, 3)))
+	require.ErrorIs(t, connect(ca.issue(t, 2)), ErrCertificateRevoked)
+}
@@ -0,0 +1,18 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+//go:build linux && (386 || amd64 || arm)
+
+package listeners
+
+import "syscall"
+
+// soReusePort is the value of SO_REUSEPORT, which the syscall package does not define
+// for these architectures.
+const soReusePort = 0xf
+
+// setReusePort sets SO_REUSEPORT on a socket.
+func setReusePort(fd uintptr) error {
+	return syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soReusePort, 1)
+}
@@ -0,0 +1,12 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+//go:build !aix && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd && !linux
+
+package listeners
+
+// setReusePort returns an error, as port reuse is not supported on this platform.
+func setReusePort(fd uintptr) error {
+	return ErrReusePortUnsupported
+}
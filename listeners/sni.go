@@ -0,0 +1,76 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package listeners
+
+import (
+	"crypto/tls"
+	"errors"
+	"strings"
+	"sync"
+)
+
+var (
+	// ErrNoCertificate indicates no certificate matched the server name requested by a client
+	// and no default certificate was set.
+	ErrNoCertificate = errors.New("no certificate for server name")
+)
+
+// SNICertificates selects the certificate presented by a TLS listener based on the server
+// name (SNI) requested by the connecting client, so that a single listener can serve
+// multiple domains. Use GetCertificate as the GetCertificate callback of a tls.Config.
+// Certificates may be added and removed while the listener is serving.
+type SNICertificates struct {
+	Default *tls.Certificate            // the certificate used when no hostname matches, if any
+	certs   map[string]*tls.Certificate // certificates keyed on lowercase hostname
+	sync.RWMutex
+}
+
+// NewSNICertificates returns a new SNICertificates which falls back to the default
+// certificate for unknown or missing server names. The default may be nil.
+func NewSNICertificates(def *tls.Certificate) *SNICertificates {
+	return &SNICertificates{
+		Default: def,
+		certs:   map[string]*tls.Certificate{},
+	}
+}
+
+// Add sets the certificate to use for a hostname. The hostname may be a wildcard such as
+// *.example.com, which matches a single label in place of the asterisk.
+func (c *SNICertificates) Add(hostname string, cert *tls.Certificate) {
+	c.Lock()
+	defer c.Unlock()
+	c.certs[strings.ToLower(hostname)] = cert
+}
+
+// Delete removes the certificate for a hostname.
+func (c *SNICertificates) Delete(hostname string) {
+	c.Lock()
+	defer c.Unlock()
+	delete(c.certs, strings.ToLower(hostname))
+}
+
+// GetCertificate returns the certificate for the server name requested by the client,
+// preferring an exact hostname match to a wildcard match, and otherwise the default.
+func (c *SNICertificates) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.RLock()
+	defer c.RUnlock()
+
+	name := strings.TrimSuffix(strings.ToLower(hello.ServerName), ".")
+	if cert, ok := c.certs[name]; ok {
+		return cert, nil
+	}
+
+	if i := strings.Index(name, "."); i > 0 {
+		if cert, ok := c.certs["*"+name[i:]]; ok {
+			return cert, nil
+		}
+	}
+
+	if c.Default != nil {
+		return c.Default, nil
+	}
+
+	return nil, ErrNoCertificate
+}
@@ -0,0 +1,72 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package listeners
+
+import (
+	"crypto/tls"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSNICertificatesGetCertificate(t *testing.T) {
+	def := new(tls.Certificate)
+	a := new(tls.Certificate)
+	wild := new(tls.Certificate)
+
+	c := NewSNICertificates(def)
+	c.Add("a.example.com", a)
+	c.Add("*.Example.com", wild)
+
+	cert, err := c.GetCertificate(&tls.ClientHelloInfo{ServerName: "A.example.com."})
+	require.NoError(t, err)
+	require.Same(t, a, cert)
+
+	cert, err = c.GetCertificate(&tls.ClientHelloInfo{ServerName: "b.example.com"})
+	require.NoError(t, err)
+	require.Same(t, wild, cert)
+
+	cert, err = c.GetCertificate(&tls.ClientHelloInfo{ServerName: "c.b.example.com"})
+	require.NoError(t, err)
+	require.Same(t, def, cert)
+
+	cert, err = c.GetCertificate(&tls.ClientHelloInfo{})
+	require.NoError(t, err)
+	require.Same(t, def, cert)
+
+	c.Delete("a.example.com")
+	cert, err = c.GetCertificate(&tls.ClientHelloInfo{ServerName: "a.example.com"})
+	require.NoError(t, err)
+	require.Same(t, wild, cert)
+}
+
+func TestSNICertificatesNoDefault(t *testing.T) {
+	c := NewSNICertificates(nil)
+	_, err := c.GetCertificate(&tls.ClientHelloInfo{ServerName: "a.example.com"})
+	require.ErrorIs(t, err, ErrNoCertificate)
+}
+
+func TestSNICertificatesHandshake(t *testing.T) {
+	cert, err := tls.X509KeyPair(testCertificate, testPrivateKey)
+	require.NoError(t, err)
+
+	c := NewSNICertificates(nil)
+	c.Add("mochi.local", &cert)
+
+	sc, cc := net.Pipe()
+	defer sc.Close()
+	defer cc.Close()
+
+	server := tls.Server(sc, &tls.Config{MinVersion: tls.VersionTLS12, GetCertificate: c.GetCertificate})
+	go func() {
+		_ = server.Handshake()
+	}()
+
+	client := tls.Client(cc, &tls.Config{ServerName: "mochi.local", InsecureSkipVerify: true}) // #nosec G402
+	err = client.Handshake()
+	require.NoError(t, err)
+	require.Equal(t, cert.Certificate[0], client.ConnectionState().PeerCertificates[0].Raw)
+}
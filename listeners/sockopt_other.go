@@ -0,0 +1,17 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+//go:build !unix
+
+package listeners
+
+// setTOS returns an error, as DSCP marking is not supported on this platform.
+func setTOS(fd uintptr, network string, tos int) error {
+	return ErrDSCPUnsupported
+}
+
+// setBufferSizes returns an error, as socket buffer sizes are not supported on this platform.
+func setBufferSizes(fd uintptr, read, write int) error {
+	return ErrBufferSizeUnsupported
+}
@@ -0,0 +1,39 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+//go:build unix
+
+package listeners
+
+import "syscall"
+
+// setTOS sets the type of service byte of a socket. IPv6 sockets also set the traffic
+// class, as dual-stack sockets may carry both IPv4 and IPv6 connections.
+func setTOS(fd uintptr, network string, tos int) error {
+	if network == NetworkIPv6 {
+		if err := syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS, tos); err != nil {
+			return err
+		}
+
+		_ = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TOS, tos)
+		return nil
+	}
+
+	return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TOS, tos)
+}
+
+// setBufferSizes sets the receive and send buffer sizes of a socket, where non-zero.
+func setBufferSizes(fd uintptr, read, write int) error {
+	if read != 0 {
+		if err := syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_RCVBUF, read); err != nil {
+			return err
+		}
+	}
+
+	if write != 0 {
+		return syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_SNDBUF, write)
+	}
+
+	return nil
+}
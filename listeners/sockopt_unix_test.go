@@ -0,0 +1,74 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+//go:build unix
+
+package listeners
+
+import (
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// acceptSockopt listens with the config, accepts a connection, and returns the value of
+// a socket option of the accepted connection.
+func acceptSockopt(t *testing.T, c *Config, level, opt int) int {
+	ln, err := c.listen("127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	defer client.Close()
+
+	conn, err := ln.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	rc, err := conn.(*net.TCPConn).SyscallConn()
+	require.NoError(t, err)
+
+	var val int
+	var serr error
+	require.NoError(t, rc.Control(func(fd uintptr) {
+		val, serr = syscall.GetsockoptInt(int(fd), level, opt)
+	}))
+	require.NoError(t, serr)
+	return val
+}
+
+func TestConfigListenDSCP(t *testing.T) {
+	require.Equal(t, 46<<2, acceptSockopt(t, &Config{Network: NetworkIPv4, DSCP: 46}, syscall.IPPROTO_IP, syscall.IP_TOS))
+	require.Equal(t, 0, acceptSockopt(t, &Config{Network: NetworkIPv4}, syscall.IPPROTO_IP, syscall.IP_TOS))
+
+	_, err := (&Config{DSCP: 64}).listen("127.0.0.1:0")
+	require.ErrorIs(t, err, ErrInvalidDSCP)
+}
+
+func TestConfigListenNoDelay(t *testing.T) {
+	require.NotEqual(t, 0, acceptSockopt(t, &Config{}, syscall.IPPROTO_TCP, syscall.TCP_NODELAY))
+	require.Equal(t, 0, acceptSockopt(t, &Config{DisableNoDelay: true}, syscall.IPPROTO_TCP, syscall.TCP_NODELAY))
+}
+
+func TestConfigListenKeepAlive(t *testing.T) {
+	require.NotEqual(t, 0, acceptSockopt(t, &Config{KeepAlive: time.Second * 30}, syscall.SOL_SOCKET, syscall.SO_KEEPALIVE))
+	require.Equal(t, 0, acceptSockopt(t, &Config{KeepAlive: -1}, syscall.SOL_SOCKET, syscall.SO_KEEPALIVE))
+}
+
+func TestConfigListenBufferSizes(t *testing.T) {
+	def := acceptSockopt(t, &Config{}, syscall.SOL_SOCKET, syscall.SO_RCVBUF)
+	read := acceptSockopt(t, &Config{ReadBufferSize: def * 2}, syscall.SOL_SOCKET, syscall.SO_RCVBUF)
+	require.Greater(t, read, def)
+
+	def = acceptSockopt(t, &Config{}, syscall.SOL_SOCKET, syscall.SO_SNDBUF)
+	write := acceptSockopt(t, &Config{WriteBufferSize: def * 2}, syscall.SOL_SOCKET, syscall.SO_SNDBUF)
+	require.Greater(t, write, def)
+
+	_, err := (&Config{ReadBufferSize: -1}).listen("127.0.0.1:0")
+	require.ErrorIs(t, err, ErrInvalidBufferSize)
+}
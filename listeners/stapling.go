@@ -0,0 +1,170 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package listeners
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+const (
+	defaultOCSPRetryInterval = time.Minute // the interval between attempts after a failed refresh
+)
+
+var (
+	// ErrIssuerRequired indicates a certificate to be stapled was not followed by its issuer in the chain.
+	ErrIssuerRequired = errors.New("certificate chain must include the issuer")
+
+	// ErrOCSPNotGood indicates an OCSP responder did not report a certificate as good.
+	ErrOCSPNotGood = errors.New("ocsp status is not good")
+)
+
+// OCSPStapler staples OCSP responses to a listener certificate, so clients which check
+// revocation do not need to contact the responder themselves. Use GetCertificate as the
+// GetCertificate callback of the listener tls.Config. Responses are refreshed in the
+// background halfway through their validity, and the certificate is served without a
+// staple until a good response has been received.
+type OCSPStapler struct {
+	opts    OCSPOptions
+	client  *http.Client
+	leaf    *x509.Certificate // the certificate to be stapled
+	issuer  *x509.Certificate // the issuer of the certificate
+	cert    *tls.Certificate  // the certificate served to clients
+	refresh time.Time         // when the staple should next be refreshed
+	cancel  chan struct{}     // closed to stop refreshing
+	sync.RWMutex
+}
+
+// NewOCSPStapler returns a new OCSPStapler for a certificate, which must include its issuer
+// as the second certificate in the chain.
+func NewOCSPStapler(cert tls.Certificate, opts OCSPOptions) (*OCSPStapler, error) {
+	if len(cert.Certificate) < 2 {
+		return nil, ErrIssuerRequired
+	}
+
+	leaf := cert.Leaf
+	if leaf == nil {
+		var err error
+		if leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return nil, err
+		}
+	}
+
+	issuer, err := x509.ParseCertificate(cert.Certificate[1])
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultOCSPTimeout
+	}
+
+	return &OCSPStapler{
+		opts:   opts,
+		client: &http.Client{Timeout: opts.Timeout},
+		leaf:   leaf,
+		issuer: issuer,
+		cert:   &cert,
+	}, nil
+}
+
+// Refresh requests a new OCSP response and staples it to the certificate if the certificate
+// is reported as good. If the request fails, the existing staple is kept.
+func (s *OCSPStapler) Refresh() error {
+	res, raw, err := fetchOCSP(s.client, s.opts.Responder, s.leaf, s.issuer)
+	if err != nil {
+		return err
+	}
+
+	if res.Status != ocsp.Good {
+		return fmt.Errorf("%w: %d", ErrOCSPNotGood, res.Status)
+	}
+
+	refresh := time.Now().Add(defaultOCSPCacheTTL)
+	if !res.NextUpdate.IsZero() {
+		refresh = res.ThisUpdate.Add(res.NextUpdate.Sub(res.ThisUpdate) / 2)
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	cert := *s.cert
+	cert.OCSPStaple = raw
+	s.cert = &cert
+	s.refresh = refresh
+	return nil
+}
+
+// Certificate returns the certificate with the current staple.
+func (s *OCSPStapler) Certificate() *tls.Certificate {
+	s.RLock()
+	defer s.RUnlock()
+	return s.cert
+}
+
+// GetCertificate returns the certificate with the current staple for a TLS handshake.
+func (s *OCSPStapler) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return s.Certificate(), nil
+}
+
+// Start staples an initial response and refreshes it in the background until Stop is
+// called. If the initial request fails, the error is returned and the request is retried
+// in the background while the certificate is served without a staple.
+func (s *OCSPStapler) Start() error {
+	err := s.Refresh()
+
+	s.Lock()
+	defer s.Unlock()
+	if s.cancel != nil {
+		return err
+	}
+
+	s.cancel = make(chan struct{})
+	go s.run(s.cancel)
+	return err
+}
+
+// Stop stops refreshing the staple. The current staple remains in use.
+func (s *OCSPStapler) Stop() {
+	s.Lock()
+	defer s.Unlock()
+	if s.cancel != nil {
+		close(s.cancel)
+		s.cancel = nil
+	}
+}
+
+// run refreshes the staple when it is due until cancelled, retrying failed requests.
+func (s *OCSPStapler) run(cancel chan struct{}) {
+	timer := time.NewTimer(s.next())
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-cancel:
+			return
+		case <-timer.C:
+			_ = s.Refresh()
+			timer.Reset(s.next())
+		}
+	}
+}
+
+// next returns the time to wait before the staple should be refreshed.
+func (s *OCSPStapler) next() time.Duration {
+	s.RLock()
+	defer s.RUnlock()
+	if d := time.Until(s.refresh); d > 0 {
+		return d
+	}
+
+	return defaultOCSPRetryInterval
+}
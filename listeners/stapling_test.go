@@ -0,0 +1,127 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package listeners
+
+import (
+	"crypto/tls"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ocsp"
+)
+
+// issueChain returns a certificate issued by the ca with the ca included in the chain.
+func (ca *testCA) issueChain(t *testing.T, serial int64) tls.Certificate {
+	cert := ca.issue(t, serial)
+	cert.Certificate = append(cert.Certificate, ca.cert.Raw)
+	return cert
+}
+
+func TestNewOCSPStapler(t *testing.T) {
+	ca := newTestCA(t, "mochi-ca")
+
+	_, err := NewOCSPStapler(ca.issue(t, 2), OCSPOptions{})
+	require.ErrorIs(t, err, ErrIssuerRequired)
+
+	cert := ca.issueChain(t, 2)
+	cert.Leaf = nil
+	s, err := NewOCSPStapler(cert, OCSPOptions{})
+	require.NoError(t, err)
+	require.Equal(t, int64(2), s.leaf.SerialNumber.Int64())
+	require.Equal(t, ca.cert.Raw, s.issuer.Raw)
+	require.Equal(t, defaultOCSPTimeout, s.client.Timeout)
+	require.Nil(t, s.Certificate().OCSPStaple)
+	require.Equal(t, defaultOCSPRetryInterval, s.next())
+
+	cert.Certificate[1] = []byte("not a certificate")
+	_, err = NewOCSPStapler(cert, OCSPOptions{})
+	require.Error(t, err)
+}
+
+func TestOCSPStaplerRefresh(t *testing.T) {
+	ca := newTestCA(t, "mochi-ca")
+	srv, requests := newOCSPResponder(t, ca, 2)
+
+	s, err := NewOCSPStapler(ca.issueChain(t, 3), OCSPOptions{Responder: srv.URL})
+	require.NoError(t, err)
+	require.NoError(t, s.Refresh())
+	require.Equal(t, int64(1), requests.Load())
+
+	staple := s.Certificate().OCSPStaple
+	res, err := ocsp.ParseResponse(staple, ca.cert)
+	require.NoError(t, err)
+	require.Equal(t, ocsp.Good, res.Status)
+	require.InDelta(t, 29*time.Minute, s.next(), float64(time.Minute)) // halfway through the hour
+
+	// a failed refresh keeps the existing staple.
+	srv.Close()
+	require.Error(t, s.Refresh())
+	require.Equal(t, staple, s.Certificate().OCSPStaple)
+}
+
+func TestOCSPStaplerRefreshRevoked(t *testing.T) {
+	ca := newTestCA(t, "mochi-ca")
+	srv, _ := newOCSPResponder(t, ca, 2)
+
+	s, err := NewOCSPStapler(ca.issueChain(t, 2), OCSPOptions{Responder: srv.URL})
+	require.NoError(t, err)
+	require.ErrorIs(t, s.Refresh(), ErrOCSPNotGood)
+	require.Nil(t, s.Certificate().OCSPStaple)
+}
+
+func TestOCSPStaplerStartStop(t *testing.T) {
+	ca := newTestCA(t, "mochi-ca")
+	srv, requests := newOCSPResponder(t, ca)
+
+	s, err := NewOCSPStapler(ca.issueChain(t, 3), OCSPOptions{Responder: srv.URL})
+	require.NoError(t, err)
+	require.NoError(t, s.Start())
+	require.NotNil(t, s.cancel)
+	require.NotEmpty(t, s.Certificate().OCSPStaple)
+
+	require.NoError(t, s.Start()) // already running
+	require.Equal(t, int64(2), requests.Load())
+
+	s.Stop()
+	require.Nil(t, s.cancel)
+	s.Stop()
+	require.NotEmpty(t, s.Certificate().OCSPStaple)
+
+	// a failed initial request is returned, but the stapler keeps retrying.
+	srv.Close()
+	s, err = NewOCSPStapler(ca.issueChain(t, 4), OCSPOptions{Responder: srv.URL})
+	require.NoError(t, err)
+	require.Error(t, s.Start())
+	require.NotNil(t, s.cancel)
+	s.Stop()
+}
+
+func TestOCSPStaplerHandshake(t *testing.T) {
+	ca := newTestCA(t, "mochi-ca")
+	srv, _ := newOCSPResponder(t, ca)
+
+	s, err := NewOCSPStapler(ca.issueChain(t, 3), OCSPOptions{Responder: srv.URL})
+	require.NoError(t, err)
+	require.NoError(t, s.Refresh())
+
+	sc, cc := net.Pipe()
+	defer sc.Close()
+	defer cc.Close()
+
+	config := &tls.Config{MinVersion: tls.VersionTLS12, GetCertificate: s.GetCertificate}
+	go func() {
+		_ = tls.Server(sc, config).Handshake()
+	}()
+
+	client := tls.Client(cc, &tls.Config{InsecureSkipVerify: true}) // #nosec G402
+	require.NoError(t, client.Handshake())
+
+	res, err := ocsp.ParseResponse(client.ConnectionState().OCSPResponse, ca.cert)
+	require.NoError(t, err)
+	require.Equal(t, ocsp.Good, res.Status)
+	require.Equal(t, int64(3), res.SerialNumber.Int64())
+}
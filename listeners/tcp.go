@@ -7,6 +7,7 @@ package listeners
 import (
 	"crypto/tls"
 	"net"
+	"os"
 	"sync"
 	"sync/atomic"
 
@@ -21,6 +22,7 @@ type TCP struct { // [MQTT-4.2.0-1]
 	id      string       // the internal id of the listener
 	address string       // the network address to bind to
 	listen  net.Listener // a net.Listener which will listen for new clients
+	socket  net.Listener // the underlying socket listener, before any TLS wrapping
 	config  Config       // configuration values for the listener
 	log     *slog.Logger // server logger
 	end     uint32       // ensure the close methods are only called once
@@ -53,23 +55,47 @@ func (l *TCP) Protocol() string {
 	return "tcp"
 }
 
+// Config returns the configuration of the listener.
+func (l *TCP) Config() Config {
+	return l.config
+}
+
 // Init initializes the listener.
 func (l *TCP) Init(log *slog.Logger) error {
 	l.log = log
 
-	var err error
+	err := l.config.loadTLSConfig()
+	if err != nil {
+		return err
+	}
+
+	l.listen, err = l.config.listen(l.address)
+	if err != nil {
+		return err
+	}
+	l.socket = l.listen
+
 	if l.config.TLSConfig != nil {
-		l.listen, err = tls.Listen("tcp", l.address, l.config.TLSConfig)
-	} else {
-		l.listen, err = net.Listen("tcp", l.address)
+		l.listen = tls.NewListener(l.listen, l.config.TLSConfig)
+	}
+
+	return nil
+}
+
+// File returns a duplicate of the listening socket file descriptor, which can be passed
+// to a new broker process with ActivationEnv to restart without refusing connections.
+func (l *TCP) File() (*os.File, error) {
+	if l.socket == nil {
+		return nil, ErrListenerFileUnsupported
 	}
 
-	return err
+	return listenerFile(l.socket)
 }
 
 // Serve starts waiting for new TCP connections, and calls the establish
 // connection callback for any received.
 func (l *TCP) Serve(establish EstablishFn) {
+	var backoff acceptBackoff
 	for {
 		if atomic.LoadUint32(&l.end) == 1 {
 			return
@@ -77,8 +103,12 @@ func (l *TCP) Serve(establish EstablishFn) {
 
 		conn, err := l.listen.Accept()
 		if err != nil {
+			if atomic.LoadUint32(&l.end) == 0 && backoff.retry(l.log, l.id, err) {
+				continue
+			}
 			return
 		}
+		backoff.reset(l.log, l.id)
 
 		if atomic.LoadUint32(&l.end) == 0 {
 			go func() {
@@ -29,6 +29,11 @@ func TestTCPAddress(t *testing.T) {
 	require.Equal(t, testAddr, l.Address())
 }
 
+func TestTCPConfig(t *testing.T) {
+	l := NewTCP(Config{ID: "t1", KeepaliveGrace: 2})
+	require.Equal(t, 2.0, l.Config().KeepaliveGrace)
+}
+
 func TestTCPProtocol(t *testing.T) {
 	l := NewTCP(basicConfig)
 	require.Equal(t, "tcp", l.Protocol())
@@ -0,0 +1,113 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package listeners
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"sync"
+	"time"
+)
+
+const (
+	defaultTicketKeyInterval = time.Hour // the default interval between session ticket key rotations
+	defaultTicketKeys        = 3         // the default number of session ticket keys accepted for resumption
+)
+
+// TicketKeyRotator periodically rotates the session ticket keys of a tls.Config used by
+// a listener. New sessions are always issued tickets encrypted with the newest key, while
+// tickets encrypted with a limited number of previous keys can still be used to resume,
+// bounding how long a compromised key can decrypt past sessions. Session tickets are
+// disabled entirely by setting SessionTicketsDisabled on the tls.Config.
+type TicketKeyRotator struct {
+	config   *tls.Config   // the tls config of the listener
+	interval time.Duration // the interval between key rotations
+	max      int           // the maximum number of keys accepted for resumption
+	keys     [][32]byte    // the current keys, newest first
+	cancel   chan struct{} // closed to stop the rotator
+	sync.Mutex
+}
+
+// NewTicketKeyRotator returns a rotator for the session ticket keys of a tls.Config,
+// rotating every interval and accepting tickets from up to keys keys. Defaults of one
+// hour and three keys are used if the values are not positive.
+func NewTicketKeyRotator(config *tls.Config, interval time.Duration, keys int) *TicketKeyRotator {
+	if interval <= 0 {
+		interval = defaultTicketKeyInterval
+	}
+
+	if keys <= 0 {
+		keys = defaultTicketKeys
+	}
+
+	return &TicketKeyRotator{
+		config:   config,
+		interval: interval,
+		max:      keys,
+	}
+}
+
+// Rotate generates a new session ticket key and retires the oldest key if the maximum
+// number of keys has been reached.
+func (r *TicketKeyRotator) Rotate() error {
+	var key [32]byte
+	if _, err := rand.Read(key[:]); err != nil {
+		return err
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	r.keys = append([][32]byte{key}, r.keys...)
+	if len(r.keys) > r.max {
+		r.keys = r.keys[:r.max]
+	}
+
+	r.config.SetSessionTicketKeys(r.keys)
+	return nil
+}
+
+// Start sets an initial session ticket key and rotates the keys every interval until
+// Stop is called. It should be called before the listener begins serving.
+func (r *TicketKeyRotator) Start() error {
+	if err := r.Rotate(); err != nil {
+		return err
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	if r.cancel != nil {
+		return nil
+	}
+
+	r.cancel = make(chan struct{})
+	go r.rotate(r.cancel)
+	return nil
+}
+
+// Stop stops rotating the session ticket keys. Existing keys remain in use.
+func (r *TicketKeyRotator) Stop() {
+	r.Lock()
+	defer r.Unlock()
+	if r.cancel != nil {
+		close(r.cancel)
+		r.cancel = nil
+	}
+}
+
+// rotate rotates the session ticket keys every interval until cancelled.
+func (r *TicketKeyRotator) rotate(cancel chan struct{}) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-cancel:
+			return
+		case <-ticker.C:
+			_ = r.Rotate()
+		}
+	}
+}
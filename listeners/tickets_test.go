@@ -0,0 +1,124 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package listeners
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewTicketKeyRotatorDefaults(t *testing.T) {
+	r := NewTicketKeyRotator(new(tls.Config), 0, 0)
+	require.Equal(t, defaultTicketKeyInterval, r.interval)
+	require.Equal(t, defaultTicketKeys, r.max)
+}
+
+func TestTicketKeyRotatorRotate(t *testing.T) {
+	r := NewTicketKeyRotator(new(tls.Config), time.Hour, 2)
+
+	err := r.Rotate()
+	require.NoError(t, err)
+	require.Len(t, r.keys, 1)
+	first := r.keys[0]
+
+	err = r.Rotate()
+	require.NoError(t, err)
+	require.Len(t, r.keys, 2)
+	require.Equal(t, first, r.keys[1])
+
+	err = r.Rotate()
+	require.NoError(t, err)
+	require.Len(t, r.keys, 2)
+	require.NotEqual(t, first, r.keys[0])
+	require.NotEqual(t, first, r.keys[1])
+}
+
+func TestTicketKeyRotatorStartStop(t *testing.T) {
+	r := NewTicketKeyRotator(new(tls.Config), time.Millisecond*10, 3)
+	err := r.Start()
+	require.NoError(t, err)
+	err = r.Start() // already started
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		r.Lock()
+		defer r.Unlock()
+		return len(r.keys) == 3
+	}, time.Second, time.Millisecond*5)
+
+	r.Stop()
+	r.Stop()
+	require.Nil(t, r.cancel)
+}
+
+// newResumableCertificate returns a self-signed certificate which is currently valid, as
+// sessions are not resumed for expired certificates such as the test certificate.
+func newResumableCertificate(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"mochi.local"},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// handshake performs a TLS handshake with a server using config, returning the client
+// connection state once the server has finished.
+func handshake(t *testing.T, config *tls.Config, cache tls.ClientSessionCache) tls.ConnectionState {
+	sc, cc := net.Pipe()
+	defer sc.Close()
+	defer cc.Close()
+
+	done := make(chan struct{})
+	server := tls.Server(sc, config)
+	go func() {
+		defer close(done)
+		_ = server.Handshake()
+		_, _ = server.Write([]byte{1}) // flush the session ticket
+	}()
+
+	client := tls.Client(cc, &tls.Config{InsecureSkipVerify: true, ClientSessionCache: cache}) // #nosec G402
+	require.NoError(t, client.Handshake())
+	_, err := client.Read(make([]byte, 1))
+	require.NoError(t, err)
+	<-done
+	return client.ConnectionState()
+}
+
+func TestTicketKeyRotatorResumption(t *testing.T) {
+	cert := newResumableCertificate(t)
+	config := &tls.Config{MinVersion: tls.VersionTLS12, Certificates: []tls.Certificate{cert}}
+	r := NewTicketKeyRotator(config, time.Hour, 2)
+	require.NoError(t, r.Rotate())
+
+	cache := tls.NewLRUClientSessionCache(1)
+	require.False(t, handshake(t, config, cache).DidResume)
+	require.True(t, handshake(t, config, cache).DidResume)
+
+	// tickets encrypted with a previous key can still resume.
+	require.NoError(t, r.Rotate())
+	require.True(t, handshake(t, config, cache).DidResume)
+
+	// tickets encrypted with a retired key cannot.
+	require.NoError(t, r.Rotate())
+	require.NoError(t, r.Rotate())
+	require.False(t, handshake(t, config, cache).DidResume)
+}
@@ -0,0 +1,165 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package listeners
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+)
+
+const (
+	TLSPresetModern = "modern" // TLS 1.2 and above with forward secret AEAD cipher suites
+	TLSPresetFIPS   = "fips"   // TLS 1.2 and above with AES-GCM cipher suites and NIST curves only
+)
+
+var (
+	// ErrTLSCertificateRequired indicates TLS options were provided without a certificate and key file, or acme options.
+	ErrTLSCertificateRequired = errors.New("tls options require a certificate and key file")
+
+	// ErrUnknownTLSVersion indicates a TLS version was not one of 1.0, 1.1, 1.2, or 1.3.
+	ErrUnknownTLSVersion = errors.New("unknown tls version")
+
+	// ErrUnknownCipherSuite indicates a cipher suite name was not a supported cipher suite.
+	ErrUnknownCipherSuite = errors.New("unknown or insecure cipher suite")
+
+	// ErrUnknownTLSPreset indicates the TLS preset was not modern or fips.
+	ErrUnknownTLSPreset = errors.New("unknown tls preset")
+)
+
+// tlsVersions maps configured version names to TLS versions.
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// tlsPresetCipherSuites contains the TLS 1.2 cipher suites allowed by each preset. TLS 1.3
+// cipher suites are not configurable.
+var tlsPresetCipherSuites = map[string][]uint16{
+	TLSPresetModern: {
+		tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+		tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+	},
+	TLSPresetFIPS: {
+		tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	},
+}
+
+// TLSOptions contains file-configurable TLS settings for a listener, which are used to
+// build the listener TLSConfig if one is not provided directly.
+type TLSOptions struct {
+	CertFile     string       `yaml:"cert_file" json:"cert_file"`         // the path to a PEM encoded certificate
+	KeyFile      string       `yaml:"key_file" json:"key_file"`           // the path to a PEM encoded private key
+	Preset       string       `yaml:"preset" json:"preset"`               // either modern or fips; defaults to modern
+	MinVersion   string       `yaml:"min_version" json:"min_version"`     // the minimum TLS version, eg. 1.2; defaults to 1.2
+	MaxVersion   string       `yaml:"max_version" json:"max_version"`     // the maximum TLS version, eg. 1.3; defaults to the highest supported
+	CipherSuites []string     `yaml:"cipher_suites" json:"cipher_suites"` // TLS 1.2 cipher suite names, overriding the preset
+	ACME         *ACMEOptions `yaml:"acme" json:"acme"`                   // obtain certificates automatically instead of from files
+}
+
+// TLSConfig returns a tls.Config built from the options.
+func (o *TLSOptions) TLSConfig() (*tls.Config, error) {
+	var certs []tls.Certificate
+	switch {
+	case o.ACME != nil && (o.CertFile != "" || o.KeyFile != ""):
+		return nil, ErrACMECertificateConflict
+	case o.ACME != nil:
+	case o.CertFile == "" || o.KeyFile == "":
+		return nil, ErrTLSCertificateRequired
+	default:
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+
+	preset := o.Preset
+	if preset == "" {
+		preset = TLSPresetModern
+	}
+
+	suites, ok := tlsPresetCipherSuites[preset]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownTLSPreset, preset)
+	}
+
+	config := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: certs,
+		CipherSuites: suites,
+	}
+
+	if o.ACME != nil {
+		if err := o.ACME.applyACME(config); err != nil {
+			return nil, err
+		}
+	}
+
+	if preset == TLSPresetFIPS {
+		config.CurvePreferences = []tls.CurveID{tls.CurveP256, tls.CurveP384}
+	}
+
+	if o.MinVersion != "" {
+		if config.MinVersion, ok = tlsVersions[o.MinVersion]; !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownTLSVersion, o.MinVersion)
+		}
+	}
+
+	if o.MaxVersion != "" {
+		if config.MaxVersion, ok = tlsVersions[o.MaxVersion]; !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownTLSVersion, o.MaxVersion)
+		}
+	}
+
+	if len(o.CipherSuites) > 0 {
+		config.CipherSuites = make([]uint16, 0, len(o.CipherSuites))
+		for _, name := range o.CipherSuites {
+			id, ok := cipherSuiteID(name)
+			if !ok {
+				return nil, fmt.Errorf("%w: %s", ErrUnknownCipherSuite, name)
+			}
+			config.CipherSuites = append(config.CipherSuites, id)
+		}
+	}
+
+	return config, nil
+}
+
+// cipherSuiteID returns the id of a secure cipher suite by name.
+func cipherSuiteID(name string) (uint16, bool) {
+	for _, cs := range tls.CipherSuites() {
+		if cs.Name == name {
+			return cs.ID, true
+		}
+	}
+
+	return 0, false
+}
+
+// loadTLSConfig builds the TLSConfig of a listener config from its TLS options, if the
+// options are set and no TLSConfig was provided.
+func (c *Config) loadTLSConfig() error {
+	if c.TLSConfig != nil || c.TLS == nil {
+		return nil
+	}
+
+	config, err := c.TLS.TLSConfig()
+	if err != nil {
+		return err
+	}
+
+	c.TLSConfig = config
+	return nil
+}
@@ -0,0 +1,111 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package listeners
+
+import (
+	"crypto/tls"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// writeTestKeyPair writes the test certificate and key to files, returning their paths.
+func writeTestKeyPair(t *testing.T) (string, string) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	require.NoError(t, os.WriteFile(certFile, testCertificate, 0600))
+	require.NoError(t, os.WriteFile(keyFile, testPrivateKey, 0600))
+	return certFile, keyFile
+}
+
+func TestTLSOptionsDefaults(t *testing.T) {
+	certFile, keyFile := writeTestKeyPair(t)
+	config, err := (&TLSOptions{CertFile: certFile, KeyFile: keyFile}).TLSConfig()
+	require.NoError(t, err)
+	require.Len(t, config.Certificates, 1)
+	require.Equal(t, uint16(tls.VersionTLS12), config.MinVersion)
+	require.Equal(t, uint16(0), config.MaxVersion)
+	require.Equal(t, tlsPresetCipherSuites[TLSPresetModern], config.CipherSuites)
+	require.Nil(t, config.CurvePreferences)
+}
+
+func TestTLSOptionsFIPS(t *testing.T) {
+	certFile, keyFile := writeTestKeyPair(t)
+	config, err := (&TLSOptions{CertFile: certFile, KeyFile: keyFile, Preset: TLSPresetFIPS}).TLSConfig()
+	require.NoError(t, err)
+	require.Equal(t, tlsPresetCipherSuites[TLSPresetFIPS], config.CipherSuites)
+	require.Equal(t, []tls.CurveID{tls.CurveP256, tls.CurveP384}, config.CurvePreferences)
+}
+
+func TestTLSOptionsVersionsAndCipherSuites(t *testing.T) {
+	certFile, keyFile := writeTestKeyPair(t)
+	config, err := (&TLSOptions{
+		CertFile:     certFile,
+		KeyFile:      keyFile,
+		MinVersion:   "1.3",
+		MaxVersion:   "1.3",
+		CipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384"},
+	}).TLSConfig()
+	require.NoError(t, err)
+	require.Equal(t, uint16(tls.VersionTLS13), config.MinVersion)
+	require.Equal(t, uint16(tls.VersionTLS13), config.MaxVersion)
+	require.Equal(t, []uint16{tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384}, config.CipherSuites)
+}
+
+func TestTLSOptionsInvalid(t *testing.T) {
+	certFile, keyFile := writeTestKeyPair(t)
+
+	_, err := (&TLSOptions{CertFile: certFile}).TLSConfig()
+	require.ErrorIs(t, err, ErrTLSCertificateRequired)
+
+	_, err = (&TLSOptions{CertFile: certFile, KeyFile: certFile}).TLSConfig()
+	require.Error(t, err)
+
+	_, err = (&TLSOptions{CertFile: certFile, KeyFile: keyFile, Preset: "legacy"}).TLSConfig()
+	require.ErrorIs(t, err, ErrUnknownTLSPreset)
+
+	_, err = (&TLSOptions{CertFile: certFile, KeyFile: keyFile, MinVersion: "3"}).TLSConfig()
+	require.ErrorIs(t, err, ErrUnknownTLSVersion)
+
+	_, err = (&TLSOptions{CertFile: certFile, KeyFile: keyFile, MaxVersion: "1.4"}).TLSConfig()
+	require.ErrorIs(t, err, ErrUnknownTLSVersion)
+
+	_, err = (&TLSOptions{CertFile: certFile, KeyFile: keyFile, CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}}).TLSConfig()
+	require.ErrorIs(t, err, ErrUnknownCipherSuite)
+}
+
+func TestConfigLoadTLSConfig(t *testing.T) {
+	c := Config{}
+	require.NoError(t, c.loadTLSConfig())
+	require.Nil(t, c.TLSConfig)
+
+	c = Config{TLSConfig: tlsConfigBasic, TLS: &TLSOptions{}}
+	require.NoError(t, c.loadTLSConfig())
+	require.Same(t, tlsConfigBasic, c.TLSConfig)
+
+	c = Config{TLS: &TLSOptions{}}
+	require.ErrorIs(t, c.loadTLSConfig(), ErrTLSCertificateRequired)
+
+	certFile, keyFile := writeTestKeyPair(t)
+	c = Config{TLS: &TLSOptions{CertFile: certFile, KeyFile: keyFile}}
+	require.NoError(t, c.loadTLSConfig())
+	require.NotNil(t, c.TLSConfig)
+}
+
+func TestTCPInitTLSOptions(t *testing.T) {
+	certFile, keyFile := writeTestKeyPair(t)
+	l := NewTCP(Config{ID: "t1", Address: ":0", TLS: &TLSOptions{CertFile: certFile, KeyFile: keyFile}})
+	err := l.Init(logger)
+	require.NoError(t, err)
+	defer l.listen.Close()
+	require.NotNil(t, l.config.TLSConfig)
+
+	l = NewTCP(Config{ID: "t2", Address: ":0", TLS: &TLSOptions{}})
+	err = l.Init(logger)
+	require.ErrorIs(t, err, ErrTLSCertificateRequired)
+}
@@ -50,6 +50,11 @@ func (l *UnixSock) Protocol() string {
 	return "unix"
 }
 
+// Config returns the configuration of the listener.
+func (l *UnixSock) Config() Config {
+	return l.config
+}
+
 // Init initializes the listener.
 func (l *UnixSock) Init(log *slog.Logger) error {
 	l.log = log
@@ -63,6 +68,7 @@ func (l *UnixSock) Init(log *slog.Logger) error {
 // Serve starts waiting for new UnixSock connections, and calls the establish
 // connection callback for any received.
 func (l *UnixSock) Serve(establish EstablishFn) {
+	var backoff acceptBackoff
 	for {
 		if atomic.LoadUint32(&l.end) == 1 {
 			return
@@ -70,8 +76,12 @@ func (l *UnixSock) Serve(establish EstablishFn) {
 
 		conn, err := l.listen.Accept()
 		if err != nil {
+			if atomic.LoadUint32(&l.end) == 0 && backoff.retry(l.log, l.id, err) {
+				continue
+			}
 			return
 		}
+		backoff.reset(l.log, l.id)
 
 		if atomic.LoadUint32(&l.end) == 0 {
 			go func() {
@@ -35,6 +35,11 @@ func TestUnixSockAddress(t *testing.T) {
 	require.Equal(t, testUnixAddr, l.Address())
 }
 
+func TestUnixSockConfig(t *testing.T) {
+	l := NewUnixSock(Config{ID: "t1", KeepaliveGrace: 2})
+	require.Equal(t, 2.0, l.Config().KeepaliveGrace)
+}
+
 func TestUnixSockProtocol(t *testing.T) {
 	l := NewUnixSock(unixConfig)
 	require.Equal(t, "unix", l.Protocol())
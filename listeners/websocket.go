@@ -5,6 +5,7 @@
 package listeners
 
 import (
+	"compress/flate"
 	"context"
 	"errors"
 	"io"
@@ -24,8 +25,34 @@ const TypeWS = "ws"
 var (
 	// ErrInvalidMessage indicates that a message payload was not valid.
 	ErrInvalidMessage = errors.New("message type not binary")
+
+	// ErrInvalidCompressionLevel indicates the websocket compression level was not between -2 and 9.
+	ErrInvalidCompressionLevel = errors.New("invalid websocket compression level")
 )
 
+// CheckRequestFn is called with the http request of an incoming websocket connection
+// before it is upgraded. Returning an error rejects the connection. The returned value, such
+// as an auth token taken from a header or cookie, is attached to the connection and can be
+// retrieved with RequestValue, eg. in an OnConnectAuthenticate hook.
+type CheckRequestFn func(r *http.Request) (any, error)
+
+// WebsocketOptions contains settings for a websocket listener.
+type WebsocketOptions struct {
+	Compression      bool     `yaml:"compression" json:"compression"`             // negotiate permessage-deflate compression with clients which support it
+	CompressionLevel int      `yaml:"compression_level" json:"compression_level"` // the flate compression level, -2 to 9; zero uses the default level
+	Subprotocols     []string `yaml:"subprotocols" json:"subprotocols"`           // the accepted subprotocols in order of preference; defaults to mqtt
+	MaxMessageSize   int64    `yaml:"max_message_size" json:"max_message_size"`   // the maximum size in bytes of messages read from clients; zero is unlimited
+	MaxFrameSize     int      `yaml:"max_frame_size" json:"max_frame_size"`       // the maximum size in bytes of frames written to clients; zero uses the default of 4096
+
+	// CheckOrigin returns true if the Origin header of a request is allowed. All origins
+	// are allowed if nil.
+	CheckOrigin func(r *http.Request) bool `yaml:"-" json:"-"`
+
+	// CheckRequest validates a request before it is upgraded and returns a value to attach
+	// to the connection. All requests are allowed if nil.
+	CheckRequest CheckRequestFn `yaml:"-" json:"-"`
+}
+
 // Websocket is a listener for establishing websocket connections.
 type Websocket struct { // [MQTT-4.2.0-1]
 	sync.RWMutex
@@ -41,17 +68,31 @@ type Websocket struct { // [MQTT-4.2.0-1]
 
 // NewWebsocket initializes and returns a new Websocket listener, listening on an address.
 func NewWebsocket(config Config) *Websocket {
-	return &Websocket{
-		id:      config.ID,
-		address: config.Address,
-		config:  config,
-		upgrader: &websocket.Upgrader{
-			Subprotocols: []string{"mqtt"},
-			CheckOrigin: func(r *http.Request) bool {
-				return true
-			},
+	upgrader := &websocket.Upgrader{
+		Subprotocols: []string{"mqtt"},
+		CheckOrigin: func(r *http.Request) bool {
+			return true
 		},
 	}
+
+	if o := config.Websocket; o != nil {
+		upgrader.EnableCompression = o.Compression
+		upgrader.WriteBufferSize = o.MaxFrameSize
+		if len(o.Subprotocols) > 0 {
+			upgrader.Subprotocols = o.Subprotocols
+		}
+
+		if o.CheckOrigin != nil {
+			upgrader.CheckOrigin = o.CheckOrigin
+		}
+	}
+
+	return &Websocket{
+		id:       config.ID,
+		address:  config.Address,
+		config:   config,
+		upgrader: upgrader,
+	}
 }
 
 // ID returns the id of the listener.
@@ -66,16 +107,30 @@ func (l *Websocket) Address() string {
 
 // Protocol returns the address of the listener.
 func (l *Websocket) Protocol() string {
-	if l.config.TLSConfig != nil {
+	if l.config.TLSConfig != nil || l.config.TLS != nil {
 		return "wss"
 	}
 
 	return "ws"
 }
 
+// Config returns the configuration of the listener.
+func (l *Websocket) Config() Config {
+	return l.config
+}
+
 // Init initializes the listener.
 func (l *Websocket) Init(log *slog.Logger) error {
 	l.log = log
+	if err := l.config.loadTLSConfig(); err != nil {
+		return err
+	}
+
+	if o := l.config.Websocket; o != nil && o.CompressionLevel != 0 {
+		if o.CompressionLevel < flate.HuffmanOnly || o.CompressionLevel > flate.BestCompression {
+			return ErrInvalidCompressionLevel
+		}
+	}
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", l.handler)
@@ -92,13 +147,34 @@ func (l *Websocket) Init(log *slog.Logger) error {
 
 // handler upgrades and handles an incoming websocket connection.
 func (l *Websocket) handler(w http.ResponseWriter, r *http.Request) {
+	var value any
+	if o := l.config.Websocket; o != nil && o.CheckRequest != nil {
+		v, err := o.CheckRequest(r)
+		if err != nil {
+			l.log.Debug("websocket request rejected", "error", err, "listener", l.id, "remote", r.RemoteAddr)
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		value = v
+	}
+
 	c, err := l.upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		return
 	}
 	defer c.Close()
 
-	err = l.establish(l.id, &wsConn{Conn: c.UnderlyingConn(), c: c})
+	if o := l.config.Websocket; o != nil {
+		if o.MaxMessageSize > 0 {
+			c.SetReadLimit(o.MaxMessageSize)
+		}
+
+		if o.Compression && o.CompressionLevel != 0 {
+			_ = c.SetCompressionLevel(o.CompressionLevel)
+		}
+	}
+
+	err = l.establish(l.id, &wsConn{Conn: c.UnderlyingConn(), c: c, value: value})
 	if err != nil {
 		l.log.Warn("", "error", err)
 	}
@@ -107,13 +183,15 @@ func (l *Websocket) handler(w http.ResponseWriter, r *http.Request) {
 // Serve starts waiting for new Websocket connections, and calls the connection
 // establishment callback for any received.
 func (l *Websocket) Serve(establish EstablishFn) {
-	var err error
 	l.establish = establish
 
-	if l.listen.TLSConfig != nil {
-		err = l.listen.ListenAndServeTLS("", "")
-	} else {
-		err = l.listen.ListenAndServe()
+	ln, err := l.config.listen(l.address)
+	if err == nil {
+		if l.listen.TLSConfig != nil {
+			err = l.listen.ServeTLS(ln, "", "")
+		} else {
+			err = l.listen.Serve(ln)
+		}
 	}
 
 	// After the listener has been shutdown, no need to print the http.ErrServerClosed error.
@@ -143,6 +221,20 @@ type wsConn struct {
 
 	// reader for the current message (can be nil)
 	r io.Reader
+
+	// the value returned by the CheckRequest function of the listener
+	value any
+}
+
+// RequestValue returns the value attached to a websocket connection by the CheckRequest
+// function of its listener, and false if the connection is not a websocket connection.
+func RequestValue(c net.Conn) (any, bool) {
+	ws, ok := c.(*wsConn)
+	if !ok {
+		return nil, false
+	}
+
+	return ws.value, true
 }
 
 // Read reads the next span of bytes from the websocket connection and returns the number of bytes read.
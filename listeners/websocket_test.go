@@ -32,6 +32,11 @@ func TestWebsocketAddress(t *testing.T) {
 	require.Equal(t, testAddr, l.Address())
 }
 
+func TestWebsocketConfig(t *testing.T) {
+	l := NewWebsocket(Config{ID: "t1", KeepaliveGrace: 2})
+	require.Equal(t, 2.0, l.Config().KeepaliveGrace)
+}
+
 func TestWebsocketProtocol(t *testing.T) {
 	l := NewWebsocket(basicConfig)
 	require.Equal(t, "ws", l.Protocol())
@@ -171,3 +176,141 @@ func TestWebsocketConnectionReads(t *testing.T) {
 	s.Close()
 	_ = ws.Close()
 }
+
+func TestWebsocketInitInvalidCompressionLevel(t *testing.T) {
+	config := basicConfig
+	config.Websocket = &WebsocketOptions{Compression: true, CompressionLevel: 10}
+	l := NewWebsocket(config)
+	err := l.Init(logger)
+	require.ErrorIs(t, err, ErrInvalidCompressionLevel)
+}
+
+func TestWebsocketOptions(t *testing.T) {
+	config := basicConfig
+	config.Websocket = &WebsocketOptions{
+		Compression:      true,
+		CompressionLevel: 9,
+		Subprotocols:     []string{"mqttv3.1", "mqtt"},
+		MaxFrameSize:     1024,
+	}
+
+	l := NewWebsocket(config)
+	require.True(t, l.upgrader.EnableCompression)
+	require.Equal(t, 1024, l.upgrader.WriteBufferSize)
+	require.NoError(t, l.Init(logger))
+
+	e := make(chan bool)
+	l.establish = func(id string, c net.Conn) error {
+		e <- true
+		return nil
+	}
+
+	s := httptest.NewServer(http.HandlerFunc(l.handler))
+	defer s.Close()
+
+	d := websocket.Dialer{EnableCompression: true, Subprotocols: []string{"mqttv3.1"}}
+	ws, resp, err := d.Dial("ws"+strings.TrimPrefix(s.URL, "http"), nil)
+	require.NoError(t, err)
+	require.Equal(t, "mqttv3.1", ws.Subprotocol())
+	require.Contains(t, resp.Header.Get("Sec-Websocket-Extensions"), "permessage-deflate")
+	require.Equal(t, true, <-e)
+	_ = ws.Close()
+}
+
+func TestWebsocketMaxMessageSize(t *testing.T) {
+	config := basicConfig
+	config.Websocket = &WebsocketOptions{MaxMessageSize: 16}
+	l := NewWebsocket(config)
+	_ = l.Init(logger)
+
+	recv := make(chan error)
+	l.establish = func(id string, c net.Conn) error {
+		buf := make([]byte, 64)
+		_, err := c.Read(buf)
+		recv <- err
+		return nil
+	}
+
+	s := httptest.NewServer(http.HandlerFunc(l.handler))
+	defer s.Close()
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(s.URL, "http"), nil)
+	require.NoError(t, err)
+
+	err = ws.WriteMessage(websocket.BinaryMessage, make([]byte, 32))
+	require.NoError(t, err)
+	require.ErrorIs(t, <-recv, websocket.ErrReadLimit)
+	_ = ws.Close()
+}
+
+func TestWebsocketCheckOrigin(t *testing.T) {
+	config := basicConfig
+	config.Websocket = &WebsocketOptions{
+		CheckOrigin: func(r *http.Request) bool {
+			return r.Header.Get("Origin") == "https://dashboard.example.com"
+		},
+	}
+	l := NewWebsocket(config)
+	_ = l.Init(logger)
+	l.establish = func(id string, c net.Conn) error {
+		return nil
+	}
+
+	s := httptest.NewServer(http.HandlerFunc(l.handler))
+	defer s.Close()
+
+	url := "ws" + strings.TrimPrefix(s.URL, "http")
+	_, resp, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": []string{"https://evil.example.com"}})
+	require.ErrorIs(t, err, websocket.ErrBadHandshake)
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	ws, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": []string{"https://dashboard.example.com"}})
+	require.NoError(t, err)
+	_ = ws.Close()
+}
+
+func TestWebsocketCheckRequest(t *testing.T) {
+	config := basicConfig
+	config.Websocket = &WebsocketOptions{
+		CheckRequest: func(r *http.Request) (any, error) {
+			token, err := r.Cookie("token")
+			if err != nil {
+				return nil, err
+			}
+			return token.Value, nil
+		},
+	}
+	l := NewWebsocket(config)
+	_ = l.Init(logger)
+
+	recv := make(chan any)
+	l.establish = func(id string, c net.Conn) error {
+		v, ok := RequestValue(c)
+		require.True(t, ok)
+		recv <- v
+		return nil
+	}
+
+	s := httptest.NewServer(http.HandlerFunc(l.handler))
+	defer s.Close()
+
+	url := "ws" + strings.TrimPrefix(s.URL, "http")
+	_, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	require.ErrorIs(t, err, websocket.ErrBadHandshake)
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	ws, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Cookie": []string{"token=abc123"}})
+	require.NoError(t, err)
+	require.Equal(t, "abc123", <-recv)
+	_ = ws.Close()
+}
+
+func TestRequestValueNotWebsocket(t *testing.T) {
+	r, w := net.Pipe()
+	defer r.Close()
+	defer w.Close()
+
+	v, ok := RequestValue(r)
+	require.False(t, ok)
+	require.Nil(t, v)
+}
@@ -0,0 +1,133 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package mqtt
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync/atomic"
+)
+
+const (
+	LogListeners = "listeners" // logs from network listeners
+	LogClients   = "clients"   // logs about individual client connections and sessions
+	LogHooks     = "hooks"     // logs from hooks, including persistent storage hooks
+)
+
+var (
+	// ErrUnknownLogSubsystem indicates a log level was set for a subsystem which does not exist.
+	ErrUnknownLogSubsystem = errors.New("unknown log subsystem")
+)
+
+// logLevel is the level of a subsystem, which is only applied once it has been set.
+type logLevel struct {
+	level slog.LevelVar
+	set   atomic.Bool
+}
+
+// LogLevels contains log levels for server subsystems, overriding the level of the server
+// logger so that debug logging can be enabled for one subsystem at runtime.
+type LogLevels struct {
+	internal map[string]*logLevel // levels keyed on subsystem, fixed at creation
+}
+
+// NewLogLevels returns a new instance of LogLevels.
+func NewLogLevels() *LogLevels {
+	return &LogLevels{
+		internal: map[string]*logLevel{
+			LogListeners: new(logLevel),
+			LogClients:   new(logLevel),
+			LogHooks:     new(logLevel),
+		},
+	}
+}
+
+// Set sets the log level of a subsystem, taking effect immediately for all of its loggers.
+func (l *LogLevels) Set(subsystem string, level slog.Level) error {
+	lv, ok := l.internal[subsystem]
+	if !ok {
+		return ErrUnknownLogSubsystem
+	}
+
+	lv.level.Set(level)
+	lv.set.Store(true)
+	return nil
+}
+
+// Reset returns a subsystem to the level of the server logger.
+func (l *LogLevels) Reset(subsystem string) error {
+	lv, ok := l.internal[subsystem]
+	if !ok {
+		return ErrUnknownLogSubsystem
+	}
+
+	lv.set.Store(false)
+	return nil
+}
+
+// Get returns the log level of a subsystem, and false if the subsystem uses the level of
+// the server logger.
+func (l *LogLevels) Get(subsystem string) (slog.Level, bool) {
+	lv, ok := l.internal[subsystem]
+	if !ok || !lv.set.Load() {
+		return 0, false
+	}
+
+	return lv.level.Level(), true
+}
+
+// GetAll returns the log levels of all subsystems which have been set.
+func (l *LogLevels) GetAll() map[string]slog.Level {
+	m := map[string]slog.Level{}
+	for k, lv := range l.internal {
+		if lv.set.Load() {
+			m[k] = lv.level.Level()
+		}
+	}
+	return m
+}
+
+// Logger returns a logger for a subsystem which writes to the handler of the base logger,
+// filtered by the level of the subsystem if set.
+func (l *LogLevels) Logger(base *slog.Logger, subsystem string) *slog.Logger {
+	lv, ok := l.internal[subsystem]
+	if !ok || base == nil {
+		return base
+	}
+
+	return slog.New(&levelHandler{base: base.Handler(), level: lv})
+}
+
+// levelHandler is a slog.Handler which applies the level of a subsystem instead of the
+// level of the handler it wraps.
+type levelHandler struct {
+	base  slog.Handler
+	level *logLevel
+}
+
+// Enabled returns true if records of the level should be logged.
+func (h *levelHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	if h.level.set.Load() {
+		return level >= h.level.level.Level()
+	}
+
+	return h.base.Enabled(ctx, level)
+}
+
+// Handle passes a record to the wrapped handler.
+func (h *levelHandler) Handle(ctx context.Context, r slog.Record) error {
+	return h.base.Handle(ctx, r)
+}
+
+// WithAttrs returns a handler with the attributes added to the wrapped handler.
+func (h *levelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &levelHandler{base: h.base.WithAttrs(attrs), level: h.level}
+}
+
+// WithGroup returns a handler with the group added to the wrapped handler.
+func (h *levelHandler) WithGroup(name string) slog.Handler {
+	return &levelHandler{base: h.base.WithGroup(name), level: h.level}
+}
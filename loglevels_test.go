@@ -0,0 +1,74 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package mqtt
+
+import (
+	"bytes"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogLevelsSetGetReset(t *testing.T) {
+	l := NewLogLevels()
+	_, ok := l.Get(LogClients)
+	require.False(t, ok)
+	require.Empty(t, l.GetAll())
+
+	require.NoError(t, l.Set(LogClients, slog.LevelDebug))
+	lv, ok := l.Get(LogClients)
+	require.True(t, ok)
+	require.Equal(t, slog.LevelDebug, lv)
+	require.Equal(t, map[string]slog.Level{LogClients: slog.LevelDebug}, l.GetAll())
+
+	require.NoError(t, l.Reset(LogClients))
+	_, ok = l.Get(LogClients)
+	require.False(t, ok)
+
+	require.ErrorIs(t, l.Set("bridge", slog.LevelDebug), ErrUnknownLogSubsystem)
+	require.ErrorIs(t, l.Reset("bridge"), ErrUnknownLogSubsystem)
+}
+
+func TestLogLevelsLogger(t *testing.T) {
+	buf := new(bytes.Buffer)
+	base := slog.New(slog.NewTextHandler(buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+
+	l := NewLogLevels()
+	clients := l.Logger(base, LogClients).With("client", "mochi")
+	listeners := l.Logger(base, LogListeners)
+
+	clients.Debug("hidden")
+	require.Empty(t, buf.String())
+
+	require.NoError(t, l.Set(LogClients, slog.LevelDebug))
+	clients.Debug("shown")
+	listeners.Debug("hidden")
+	require.Contains(t, buf.String(), "msg=shown client=mochi")
+	require.NotContains(t, buf.String(), "hidden")
+
+	buf.Reset()
+	require.NoError(t, l.Set(LogClients, slog.LevelError))
+	clients.Warn("hidden")
+	listeners.Warn("shown")
+	require.NotContains(t, buf.String(), "hidden")
+	require.Contains(t, buf.String(), "msg=shown")
+
+	require.Nil(t, l.Logger(nil, LogClients))
+	require.Equal(t, base, l.Logger(base, "unknown"))
+}
+
+func TestServerLogLevelsClients(t *testing.T) {
+	s := newServer()
+	buf := new(bytes.Buffer)
+	s.Log = slog.New(slog.NewTextHandler(buf, nil))
+
+	require.NoError(t, s.LogLevels.Set(LogClients, slog.LevelDebug))
+	cl := s.NewClient(nil, "tcp1", "mochi", false)
+	cl.ops.log.Debug("client debug")
+	s.Log.Debug("server debug")
+	require.Contains(t, buf.String(), "client debug")
+	require.NotContains(t, buf.String(), "server debug")
+}
@@ -0,0 +1,82 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package mqtt
+
+import (
+	"strings"
+
+	"github.com/mochi-mqtt/server/v2/packets"
+)
+
+// MirrorTransform modifies a mirrored copy of a message before it is published, such as to
+// redact or tag the payload. The topic name of the packet has already been rewritten to the
+// mirror topic, and cl is the client which published the original message. If false is
+// returned the copy is not published.
+type MirrorTransform func(cl *Client, pk packets.Packet) (packets.Packet, bool)
+
+// MirrorRule duplicates messages published to topics matching a filter onto another topic,
+// eg. to mirror production telemetry into a staging namespace. The leading levels of the
+// filter which contain no wildcards are replaced with the target, so with a filter of
+// prod/telemetry/# and a target of staging/telemetry, a message published to
+// prod/telemetry/dev1 is mirrored to staging/telemetry/dev1.
+type MirrorRule struct {
+	Filter    string          `yaml:"filter" json:"filter"` // the topic filter of messages to mirror
+	Target    string          `yaml:"target" json:"target"` // the topic prefix replacing the literal levels of the filter
+	Transform MirrorTransform `yaml:"-" json:"-"`           // an optional function modifying each mirrored copy
+}
+
+// Topic returns the mirror topic of a topic name matching the rule filter, or false if the
+// topic does not match the filter.
+func (r MirrorRule) Topic(topic string) (string, bool) {
+	if r.Target == "" || !MatchTopic(r.Filter, topic) {
+		return "", false
+	}
+
+	prefix := r.Filter
+	if i := strings.IndexAny(prefix, "+#"); i >= 0 {
+		prefix = strings.TrimSuffix(prefix[:i], "/")
+	}
+
+	if prefix == "" {
+		return r.Target + "/" + topic, true
+	}
+
+	return r.Target + topic[len(prefix):], true
+}
+
+// mirrorMessage publishes a copy of a message to the mirror topic of each mirror rule with a
+// filter matching its topic. Mirrored copies are not themselves mirrored, so rules which
+// target the topics of other rules cannot loop.
+func (s *Server) mirrorMessage(cl *Client, pk packets.Packet) {
+	if pk.Ignore {
+		return
+	}
+
+	for _, rule := range s.Options.Mirrors {
+		topic, ok := rule.Topic(pk.TopicName)
+		if !ok {
+			continue
+		}
+
+		out := pk.Copy(false)
+		out.TopicName = topic
+		if rule.Transform != nil {
+			if out, ok = rule.Transform(cl, out); !ok {
+				continue
+			}
+		}
+
+		if !IsValidFilter(out.TopicName, true) || s.isReservedTopic(out.TopicName) {
+			cl.ops.log.Warn("invalid mirror topic", "client", cl.ID, "filter", rule.Filter, "topic", out.TopicName)
+			continue
+		}
+
+		if out.FixedHeader.Retain {
+			s.retainMessage(cl, out)
+		}
+
+		s.publishToSubscribers(out)
+	}
+}
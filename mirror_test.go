@@ -0,0 +1,115 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package mqtt
+
+import (
+	"testing"
+
+	"github.com/mochi-mqtt/server/v2/packets"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMirrorRuleTopic(t *testing.T) {
+	tt := []struct {
+		filter string
+		target string
+		topic  string
+		expect string
+		ok     bool
+	}{
+		{filter: "prod/telemetry/#", target: "staging/telemetry", topic: "prod/telemetry/dev1", expect: "staging/telemetry/dev1", ok: true},
+		{filter: "prod/#", target: "staging", topic: "prod/a/b", expect: "staging/a/b", ok: true},
+		{filter: "prod/+/temp", target: "staging", topic: "prod/dev1/temp", expect: "staging/dev1/temp", ok: true},
+		{filter: "prod/temp", target: "staging/temp", topic: "prod/temp", expect: "staging/temp", ok: true},
+		{filter: "+/temp", target: "mirror", topic: "dev1/temp", expect: "mirror/dev1/temp", ok: true},
+		{filter: "#", target: "mirror", topic: "a/b", expect: "mirror/a/b", ok: true},
+		{filter: "prod/#", target: "staging", topic: "dev/a", ok: false},
+		{filter: "prod/#", target: "", topic: "prod/a", ok: false},
+	}
+
+	for _, tx := range tt {
+		topic, ok := MirrorRule{Filter: tx.filter, Target: tx.target}.Topic(tx.topic)
+		require.Equal(t, tx.ok, ok, tx.filter)
+		require.Equal(t, tx.expect, topic, tx.filter)
+	}
+}
+
+func TestServerMirrorMessage(t *testing.T) {
+	s := newServer()
+	s.Options.Mirrors = []MirrorRule{
+		{Filter: "prod/#", Target: "staging"},
+		{Filter: "staging/#", Target: "loop"}, // mirrored copies are not mirrored again
+	}
+
+	cl, _, _ := newTestClient()
+	s.Clients.Add(cl)
+	s.Topics.Subscribe(cl.ID, packets.Subscription{Filter: "staging/#"})
+	s.Topics.Subscribe(cl.ID, packets.Subscription{Filter: "loop/#"})
+
+	pk := *packets.TPacketData[packets.Publish].Get(packets.TPublishRetain).Packet
+	pk.TopicName = "prod/a/b"
+	err := s.processPublish(cl, pk)
+	require.NoError(t, err)
+
+	require.Len(t, cl.State.outbound, 1)
+	out := <-cl.State.outbound
+	require.Equal(t, "staging/a/b", out.TopicName)
+	require.Equal(t, pk.Payload, out.Payload)
+	require.Len(t, s.Topics.Messages("staging/a/b"), 1)
+	require.Len(t, s.Topics.Messages("prod/a/b"), 1)
+	require.Len(t, s.Topics.Messages("loop/#"), 0)
+}
+
+func TestServerMirrorMessageTransform(t *testing.T) {
+	s := newServer()
+	s.Options.Mirrors = []MirrorRule{
+		{
+			Filter: "prod/#",
+			Target: "staging",
+			Transform: func(cl *Client, pk packets.Packet) (packets.Packet, bool) {
+				if pk.TopicName == "staging/secret" {
+					return pk, false
+				}
+
+				pk.Payload = []byte("redacted")
+				return pk, true
+			},
+		},
+	}
+
+	cl, _, _ := newTestClient()
+	s.Clients.Add(cl)
+	s.Topics.Subscribe(cl.ID, packets.Subscription{Filter: "staging/#"})
+
+	pk := *packets.TPacketData[packets.Publish].Get(packets.TPublishBasic).Packet
+	pk.TopicName = "prod/secret"
+	err := s.processPublish(cl, pk)
+	require.NoError(t, err)
+	require.Len(t, cl.State.outbound, 0)
+
+	pk.TopicName = "prod/a"
+	err = s.processPublish(cl, pk)
+	require.NoError(t, err)
+	require.Len(t, cl.State.outbound, 1)
+	out := <-cl.State.outbound
+	require.Equal(t, "staging/a", out.TopicName)
+	require.Equal(t, []byte("redacted"), out.Payload)
+	require.Equal(t, []byte("hello mochi"), pk.Payload) // the original is not modified
+}
+
+func TestServerMirrorMessageReservedTarget(t *testing.T) {
+	s := newServer()
+	s.Options.Mirrors = []MirrorRule{{Filter: "prod/#", Target: SysPrefix + "/mirror"}}
+
+	cl, _, _ := newTestClient()
+	s.Clients.Add(cl)
+	s.Topics.Subscribe(cl.ID, packets.Subscription{Filter: SysPrefix + "/#"})
+
+	pk := *packets.TPacketData[packets.Publish].Get(packets.TPublishBasic).Packet
+	pk.TopicName = "prod/a"
+	err := s.processPublish(cl, pk)
+	require.NoError(t, err)
+	require.Len(t, cl.State.outbound, 0)
+}
@@ -0,0 +1,49 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package mqtttest
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is a manually advanced clock which implements mqtt.Clock, allowing tests
+// to fast-forward time instead of sleeping.
+type Clock struct {
+	now time.Time
+	sync.RWMutex
+}
+
+// NewClock returns a new Clock set to t.
+func NewClock(t time.Time) *Clock {
+	return &Clock{now: t}
+}
+
+// Now returns the current time of the clock.
+func (c *Clock) Now() time.Time {
+	c.RLock()
+	defer c.RUnlock()
+	return c.now
+}
+
+// Set sets the current time of the clock.
+func (c *Clock) Set(t time.Time) {
+	c.Lock()
+	defer c.Unlock()
+	c.now = t
+}
+
+// Advance moves the clock forward by d.
+func (c *Clock) Advance(d time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// Sleep advances the clock by d and returns immediately, so code which waits on the
+// clock completes without delaying the test.
+func (c *Clock) Sleep(d time.Duration) {
+	c.Advance(d)
+}
@@ -0,0 +1,30 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package mqtttest
+
+import (
+	"testing"
+	"time"
+
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/stretchr/testify/require"
+)
+
+var _ mqtt.Clock = (*Clock)(nil)
+
+func TestClock(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	c := NewClock(start)
+	require.Equal(t, start, c.Now())
+
+	c.Advance(time.Minute)
+	require.Equal(t, start.Add(time.Minute), c.Now())
+
+	c.Set(start)
+	require.Equal(t, start, c.Now())
+
+	c.Sleep(time.Second)
+	require.Equal(t, start.Add(time.Second), c.Now())
+}
@@ -0,0 +1,367 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+// Package mqtttest provides a harness for testing code which embeds the broker, such as
+// hooks and auth controllers, using in-memory client connections and a controllable clock.
+package mqtttest
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/packets"
+)
+
+// DefaultTimeout is the default maximum time a connection waits to read a packet.
+const DefaultTimeout = time.Second * 5
+
+var (
+	// ErrUnexpectedPacket indicates a connection read a packet other than the one expected.
+	ErrUnexpectedPacket = errors.New("unexpected packet")
+
+	// ErrTimeout indicates the server did not end a connection in time.
+	ErrTimeout = errors.New("timed out waiting for the server")
+)
+
+// Harness is a broker server with a controllable clock which accepts in-memory
+// client connections.
+type Harness struct {
+	Server *mqtt.Server // the server under test
+	Clock  *Clock       // the clock used by the server
+	t      testing.TB
+}
+
+// New returns a new Harness for a server created with opts. The clock of the server is
+// replaced with a Clock set to the current time, and the logger discards output unless
+// one is set. The server is closed when the test ends.
+func New(t testing.TB, opts *mqtt.Options) *Harness {
+	if opts == nil {
+		opts = new(mqtt.Options)
+	}
+
+	clock := NewClock(time.Now())
+	opts.Clock = clock
+	if opts.Logger == nil {
+		opts.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+
+	h := &Harness{
+		Server: mqtt.New(opts),
+		Clock:  clock,
+		t:      t,
+	}
+
+	t.Cleanup(func() {
+		_ = h.Server.Close()
+	})
+
+	return h
+}
+
+// AddHook adds a hook to the server, failing the test if the hook cannot be added.
+// Hooks should be added before the server is started.
+func (h *Harness) AddHook(hook mqtt.Hook, config any) {
+	h.t.Helper()
+	if err := h.Server.AddHook(hook, config); err != nil {
+		h.t.Fatalf("add hook %s: %v", hook.ID(), err)
+	}
+}
+
+// Start starts the server, failing the test if it cannot be started.
+func (h *Harness) Start() {
+	h.t.Helper()
+	if err := h.Server.Serve(); err != nil {
+		h.t.Fatalf("serve: %v", err)
+	}
+}
+
+// Advance moves the clock forward by d and compacts the server, removing any sessions,
+// retained and inflight messages, and bans which have expired by the new time.
+func (h *Harness) Advance(d time.Duration) {
+	h.t.Helper()
+	h.Clock.Advance(d)
+	if err := h.Server.Compact(); err != nil {
+		h.t.Fatalf("compact: %v", err)
+	}
+}
+
+// Dial opens an in-memory connection to the server, as though it had been accepted
+// by the listener with the given id. The connection is closed when the test ends.
+func (h *Harness) Dial(listener string) *Conn {
+	server, client := net.Pipe()
+	c := &Conn{
+		Conn:            client,
+		ProtocolVersion: 5,
+		Timeout:         DefaultTimeout,
+		reader:          bufio.NewReader(client),
+		done:            make(chan struct{}),
+	}
+
+	go func() {
+		c.err = h.Server.EstablishConnection(listener, server)
+		close(c.done)
+	}()
+
+	h.t.Cleanup(func() {
+		_ = c.Close()
+	})
+
+	return c
+}
+
+// Conn is the client end of an in-memory connection to the server, which encodes and
+// decodes packets for the configured protocol version.
+type Conn struct {
+	net.Conn
+	ProtocolVersion byte          // the protocol version of the client, 5 by default
+	Timeout         time.Duration // the maximum time to wait to read a packet or for the server to end the connection
+	reader          *bufio.Reader // buffers reads from the connection
+	done            chan struct{} // closed when the server has ended the connection
+	err             error         // the error returned when the server ended the connection
+	packetID        uint16        // the last packet id used by the client
+}
+
+// WritePacket encodes and writes a packet to the server.
+func (c *Conn) WritePacket(pk packets.Packet) error {
+	pk.ProtocolVersion = c.ProtocolVersion
+
+	var err error
+	buf := new(bytes.Buffer)
+	switch pk.FixedHeader.Type {
+	case packets.Connect:
+		err = pk.ConnectEncode(buf)
+	case packets.Publish:
+		err = pk.PublishEncode(buf)
+	case packets.Puback:
+		err = pk.PubackEncode(buf)
+	case packets.Pubrec:
+		err = pk.PubrecEncode(buf)
+	case packets.Pubrel:
+		err = pk.PubrelEncode(buf)
+	case packets.Pubcomp:
+		err = pk.PubcompEncode(buf)
+	case packets.Subscribe:
+		err = pk.SubscribeEncode(buf)
+	case packets.Unsubscribe:
+		err = pk.UnsubscribeEncode(buf)
+	case packets.Pingreq:
+		err = pk.PingreqEncode(buf)
+	case packets.Disconnect:
+		err = pk.DisconnectEncode(buf)
+	case packets.Auth:
+		err = pk.AuthEncode(buf)
+	default:
+		err = fmt.Errorf("%w: %v", packets.ErrNoValidPacketAvailable, pk.FixedHeader.Type)
+	}
+	if err != nil {
+		return err
+	}
+
+	_ = c.SetWriteDeadline(time.Now().Add(c.Timeout))
+	_, err = buf.WriteTo(c.Conn)
+	return err
+}
+
+// ReadPacket reads and decodes the next packet from the server, waiting up to Timeout.
+func (c *Conn) ReadPacket() (pk packets.Packet, err error) {
+	_ = c.SetReadDeadline(time.Now().Add(c.Timeout))
+
+	b, err := c.reader.ReadByte()
+	if err != nil {
+		return pk, err
+	}
+
+	if err = pk.FixedHeader.Decode(b); err != nil {
+		return pk, err
+	}
+
+	pk.FixedHeader.Remaining, _, err = packets.DecodeLength(c.reader)
+	if err != nil {
+		return pk, err
+	}
+
+	buf := make([]byte, pk.FixedHeader.Remaining)
+	if _, err = io.ReadFull(c.reader, buf); err != nil {
+		return pk, err
+	}
+
+	pk.ProtocolVersion = c.ProtocolVersion
+	switch pk.FixedHeader.Type {
+	case packets.Connack:
+		err = pk.ConnackDecode(buf)
+	case packets.Publish:
+		err = pk.PublishDecode(buf)
+	case packets.Puback:
+		err = pk.PubackDecode(buf)
+	case packets.Pubrec:
+		err = pk.PubrecDecode(buf)
+	case packets.Pubrel:
+		err = pk.PubrelDecode(buf)
+	case packets.Pubcomp:
+		err = pk.PubcompDecode(buf)
+	case packets.Suback:
+		err = pk.SubackDecode(buf)
+	case packets.Unsuback:
+		err = pk.UnsubackDecode(buf)
+	case packets.Pingresp:
+		err = pk.PingrespDecode(buf)
+	case packets.Disconnect:
+		err = pk.DisconnectDecode(buf)
+	case packets.Auth:
+		err = pk.AuthDecode(buf)
+	default:
+		err = fmt.Errorf("%w: %v", packets.ErrNoValidPacketAvailable, pk.FixedHeader.Type)
+	}
+
+	return pk, err
+}
+
+// Expect reads the next packet from the server, returning ErrUnexpectedPacket if it is
+// not of the given type.
+func (c *Conn) Expect(packetType byte) (packets.Packet, error) {
+	pk, err := c.ReadPacket()
+	if err != nil {
+		return pk, err
+	}
+
+	if pk.FixedHeader.Type != packetType {
+		return pk, fmt.Errorf("%w: expected %s, got %s", ErrUnexpectedPacket, packets.PacketNames[packetType], packets.PacketNames[pk.FixedHeader.Type])
+	}
+
+	return pk, nil
+}
+
+// Connect connects with a clean session for the client id, returning the CONNACK.
+func (c *Conn) Connect(id string) (packets.Packet, error) {
+	return c.ConnectWith(packets.Packet{
+		Connect: packets.ConnectParams{
+			ClientIdentifier: id,
+			Clean:            true,
+		},
+	})
+}
+
+// ConnectWith sends a CONNECT packet, returning the CONNACK. The packet type and
+// protocol name are set automatically, so only the connect parameters and properties
+// need to be provided, such as the username and password.
+func (c *Conn) ConnectWith(pk packets.Packet) (packets.Packet, error) {
+	pk.FixedHeader.Type = packets.Connect
+	pk.Connect.ProtocolName = []byte("MQTT")
+	if c.ProtocolVersion == 3 {
+		pk.Connect.ProtocolName = []byte("MQIsdp")
+	}
+	pk.Connect.UsernameFlag = len(pk.Connect.Username) > 0
+	pk.Connect.PasswordFlag = len(pk.Connect.Password) > 0
+
+	if err := c.WritePacket(pk); err != nil {
+		return pk, err
+	}
+
+	return c.Expect(packets.Connack)
+}
+
+// Subscribe subscribes to a filter at the given qos, returning the SUBACK.
+func (c *Conn) Subscribe(filter string, qos byte) (packets.Packet, error) {
+	err := c.WritePacket(packets.Packet{
+		FixedHeader: packets.FixedHeader{Type: packets.Subscribe, Qos: 1},
+		PacketID:    c.nextPacketID(),
+		Filters:     packets.Subscriptions{{Filter: filter, Qos: qos}},
+	})
+	if err != nil {
+		return packets.Packet{}, err
+	}
+
+	return c.Expect(packets.Suback)
+}
+
+// Unsubscribe unsubscribes from a filter, returning the UNSUBACK.
+func (c *Conn) Unsubscribe(filter string) (packets.Packet, error) {
+	err := c.WritePacket(packets.Packet{
+		FixedHeader: packets.FixedHeader{Type: packets.Unsubscribe, Qos: 1},
+		PacketID:    c.nextPacketID(),
+		Filters:     packets.Subscriptions{{Filter: filter}},
+	})
+	if err != nil {
+		return packets.Packet{}, err
+	}
+
+	return c.Expect(packets.Unsuback)
+}
+
+// Publish publishes a payload to a topic at the given qos. For qos 1 the PUBACK is
+// returned, and for qos 2 the PUBCOMP is returned once the exchange is complete.
+func (c *Conn) Publish(topic string, payload []byte, qos byte, retain bool) (packets.Packet, error) {
+	pk := packets.Packet{
+		FixedHeader: packets.FixedHeader{Type: packets.Publish, Qos: qos, Retain: retain},
+		TopicName:   topic,
+		Payload:     payload,
+	}
+
+	if qos > 0 {
+		pk.PacketID = c.nextPacketID()
+	}
+
+	if err := c.WritePacket(pk); err != nil || qos == 0 {
+		return pk, err
+	}
+
+	if qos == 1 {
+		return c.Expect(packets.Puback)
+	}
+
+	if _, err := c.Expect(packets.Pubrec); err != nil {
+		return pk, err
+	}
+
+	err := c.WritePacket(packets.Packet{
+		FixedHeader: packets.FixedHeader{Type: packets.Pubrel, Qos: 1},
+		PacketID:    pk.PacketID,
+	})
+	if err != nil {
+		return pk, err
+	}
+
+	return c.Expect(packets.Pubcomp)
+}
+
+// Disconnect sends a DISCONNECT packet and waits for the server to end the connection.
+func (c *Conn) Disconnect() error {
+	err := c.WritePacket(packets.Packet{
+		FixedHeader: packets.FixedHeader{Type: packets.Disconnect},
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.Wait()
+}
+
+// Wait waits for the server to end the connection, returning the error the connection
+// ended with, or ErrTimeout if the connection did not end within Timeout.
+func (c *Conn) Wait() error {
+	select {
+	case <-c.done:
+		return c.err
+	case <-time.After(c.Timeout):
+		return ErrTimeout
+	}
+}
+
+// nextPacketID returns the next packet id for the client.
+func (c *Conn) nextPacketID() uint16 {
+	c.packetID++
+	if c.packetID == 0 {
+		c.packetID = 1
+	}
+
+	return c.packetID
+}
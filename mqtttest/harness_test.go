@@ -0,0 +1,280 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package mqtttest
+
+import (
+	"testing"
+	"time"
+
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/packets"
+	"github.com/stretchr/testify/require"
+)
+
+func newHarness(t *testing.T) *Harness {
+	h := New(t, nil)
+	h.AddHook(new(auth.AllowHook), nil)
+	h.Start()
+	return h
+}
+
+func TestHarnessPublishSubscribe(t *testing.T) {
+	h := newHarness(t)
+
+	sub := h.Dial("t1")
+	ack, err := sub.Connect("sub")
+	require.NoError(t, err)
+	require.Equal(t, packets.CodeSuccess.Code, ack.ReasonCode)
+
+	ack, err = sub.Subscribe("a/+", 1)
+	require.NoError(t, err)
+	require.Equal(t, []byte{1}, ack.ReasonCodes)
+
+	pub := h.Dial("t1")
+	_, err = pub.Connect("pub")
+	require.NoError(t, err)
+
+	_, err = pub.Publish("a/b", []byte("hello"), 1, false)
+	require.NoError(t, err)
+
+	pk, err := sub.Expect(packets.Publish)
+	require.NoError(t, err)
+	require.Equal(t, "a/b", pk.TopicName)
+	require.Equal(t, []byte("hello"), pk.Payload)
+
+	ack, err = pub.Publish("a/c", []byte("world"), 2, false)
+	require.NoError(t, err)
+	require.Equal(t, packets.Pubcomp, ack.FixedHeader.Type)
+
+	pk, err = sub.Expect(packets.Publish)
+	require.NoError(t, err)
+	require.Equal(t, "a/c", pk.TopicName)
+	require.Equal(t, byte(1), pk.FixedHeader.Qos)
+
+	ack, err = sub.Unsubscribe("a/+")
+	require.NoError(t, err)
+	require.Equal(t, packets.Unsuback, ack.FixedHeader.Type)
+
+	require.NoError(t, pub.Disconnect())
+}
+
+func TestHarnessConnectNotAuthorized(t *testing.T) {
+	h := New(t, nil)
+	h.AddHook(new(auth.Hook), &auth.Options{
+		Ledger: &auth.Ledger{
+			Auth: auth.AuthRules{{Username: "mochi", Password: "melon", Allow: true}},
+		},
+	})
+	h.Start()
+
+	c := h.Dial("t1")
+	ack, err := c.ConnectWith(packets.Packet{
+		Connect: packets.ConnectParams{
+			ClientIdentifier: "cl1",
+			Clean:            true,
+			Username:         []byte("mochi"),
+			Password:         []byte("wrong"),
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, packets.ErrBadUsernameOrPassword.Code, ack.ReasonCode)
+	require.Error(t, c.Wait())
+
+	c = h.Dial("t1")
+	ack, err = c.ConnectWith(packets.Packet{
+		Connect: packets.ConnectParams{
+			ClientIdentifier: "cl2",
+			Clean:            true,
+			Username:         []byte("mochi"),
+			Password:         []byte("melon"),
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, packets.CodeSuccess.Code, ack.ReasonCode)
+}
+
+func TestHarnessAdvanceExpiresSession(t *testing.T) {
+	h := newHarness(t)
+
+	c := h.Dial("t1")
+	_, err := c.ConnectWith(packets.Packet{
+		Connect: packets.ConnectParams{ClientIdentifier: "cl1"},
+		Properties: packets.Properties{
+			SessionExpiryInterval:     60,
+			SessionExpiryIntervalFlag: true,
+		},
+	})
+	require.NoError(t, err)
+	require.NoError(t, c.Disconnect())
+
+	_, ok := h.Server.Clients.Get("cl1")
+	require.True(t, ok)
+
+	h.Advance(time.Minute * 2)
+	_, ok = h.Server.Clients.Get("cl1")
+	require.False(t, ok)
+}
+
+func TestHarnessAdvanceExpiresKeepalive(t *testing.T) {
+	h := newHarness(t)
+
+	c := h.Dial("t1")
+	_, err := c.ConnectWith(packets.Packet{
+		Connect: packets.ConnectParams{ClientIdentifier: "cl1", Clean: true, Keepalive: 10},
+	})
+	require.NoError(t, err)
+
+	h.Advance(time.Second * 10)
+	cl, ok := h.Server.Clients.Get("cl1")
+	require.True(t, ok)
+	require.False(t, cl.Closed())
+
+	h.Advance(time.Second * 10)
+	require.NotErrorIs(t, c.Wait(), ErrTimeout)
+	require.True(t, cl.Closed())
+	require.ErrorIs(t, cl.StopCause(), packets.ErrKeepAliveTimeout)
+}
+
+func TestHarnessExpectUnexpected(t *testing.T) {
+	h := newHarness(t)
+
+	c := h.Dial("t1")
+	_, err := c.Connect("cl1")
+	require.NoError(t, err)
+
+	require.NoError(t, c.WritePacket(packets.Packet{FixedHeader: packets.FixedHeader{Type: packets.Pingreq}}))
+	_, err = c.Expect(packets.Suback)
+	require.ErrorIs(t, err, ErrUnexpectedPacket)
+}
+
+func TestHarnessProtocolVersion3(t *testing.T) {
+	h := newHarness(t)
+
+	c := h.Dial("t1")
+	c.ProtocolVersion = 4
+	ack, err := c.Connect("cl1")
+	require.NoError(t, err)
+	require.Equal(t, packets.CodeSuccess.Code, ack.ReasonCode)
+
+	cl, ok := h.Server.Clients.Get("cl1")
+	require.True(t, ok)
+	require.Equal(t, byte(4), cl.Properties.ProtocolVersion)
+}
+
+func TestHarnessMqtt31(t *testing.T) {
+	h := newHarness(t)
+
+	sub := h.Dial("t1")
+	sub.ProtocolVersion = 3
+	ack, err := sub.Connect("sub")
+	require.NoError(t, err)
+	require.Equal(t, packets.CodeSuccess.Code, ack.ReasonCode)
+
+	suback, err := sub.Subscribe("a/b/c", 1)
+	require.NoError(t, err)
+	require.Equal(t, []byte{1}, suback.ReasonCodes)
+
+	pub := h.Dial("t1")
+	pub.ProtocolVersion = 3
+	_, err = pub.Connect("pub")
+	require.NoError(t, err)
+	_, err = pub.Publish("a/b/c", []byte("legacy"), 1, false)
+	require.NoError(t, err)
+
+	pk, err := sub.Expect(packets.Publish)
+	require.NoError(t, err)
+	require.Equal(t, []byte("legacy"), pk.Payload)
+
+	cl, ok := h.Server.Clients.Get("sub")
+	require.True(t, ok)
+	require.Equal(t, byte(3), cl.Properties.ProtocolVersion)
+}
+
+func TestHarnessMqtt31Disabled(t *testing.T) {
+	caps := mqtt.NewDefaultServerCapabilities()
+	caps.MinimumProtocolVersion = 4
+	h := New(t, &mqtt.Options{Capabilities: caps})
+	h.AddHook(new(auth.AllowHook), nil)
+	h.Start()
+
+	c := h.Dial("t1")
+	c.ProtocolVersion = 3
+	ack, err := c.Connect("cl1")
+	require.NoError(t, err)
+	require.Equal(t, packets.Err3UnsupportedProtocolVersion.Code, ack.ReasonCode)
+	require.ErrorIs(t, c.Wait(), packets.ErrUnsupportedProtocolVersion)
+}
+
+func TestNewReplacesClock(t *testing.T) {
+	opts := &mqtt.Options{Clock: NewClock(time.Unix(0, 0))}
+	h := New(t, opts)
+	require.Same(t, h.Clock, opts.Clock)
+	require.NotNil(t, opts.Logger)
+}
+
+func TestHarnessQos2RetransmitAfterReconnect(t *testing.T) {
+	h := newHarness(t)
+
+	sub := h.Dial("t1")
+	_, err := sub.Connect("sub")
+	require.NoError(t, err)
+	_, err = sub.Subscribe("a/b", 2)
+	require.NoError(t, err)
+
+	session := packets.Packet{
+		Connect: packets.ConnectParams{ClientIdentifier: "pub"},
+		Properties: packets.Properties{
+			SessionExpiryInterval:     60,
+			SessionExpiryIntervalFlag: true,
+		},
+	}
+
+	publish := packets.Packet{
+		FixedHeader: packets.FixedHeader{Type: packets.Publish, Qos: 2},
+		TopicName:   "a/b",
+		Payload:     []byte("once"),
+		PacketID:    1,
+	}
+
+	pub := h.Dial("t1")
+	_, err = pub.ConnectWith(session)
+	require.NoError(t, err)
+	require.NoError(t, pub.WritePacket(publish))
+	_, err = pub.Expect(packets.Pubrec)
+	require.NoError(t, err)
+
+	// the connection drops before the client releases the message.
+	require.NoError(t, pub.Close())
+	require.NotErrorIs(t, pub.Wait(), ErrTimeout)
+
+	pub = h.Dial("t1")
+	ack, err := pub.ConnectWith(session)
+	require.NoError(t, err)
+	require.True(t, ack.SessionPresent)
+	_, err = pub.Expect(packets.Pubrec) // the pending pubrec is resent with the session
+	require.NoError(t, err)
+
+	publish.FixedHeader.Dup = true
+	require.NoError(t, pub.WritePacket(publish))
+	ack, err = pub.Expect(packets.Pubrec)
+	require.NoError(t, err)
+	require.Equal(t, packets.CodeSuccess.Code, ack.ReasonCode)
+
+	require.NoError(t, pub.WritePacket(packets.Packet{
+		FixedHeader: packets.FixedHeader{Type: packets.Pubrel, Qos: 1},
+		PacketID:    1,
+	}))
+	_, err = pub.Expect(packets.Pubcomp)
+	require.NoError(t, err)
+
+	pk, err := sub.Expect(packets.Publish)
+	require.NoError(t, err)
+	require.Equal(t, []byte("once"), pk.Payload)
+
+	sub.Timeout = time.Millisecond * 50
+	_, err = sub.ReadPacket()
+	require.Error(t, err) // the retransmission was not delivered again
+}
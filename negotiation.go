@@ -0,0 +1,72 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package mqtt
+
+import (
+	"github.com/mochi-mqtt/server/v2/packets"
+)
+
+// Negotiation contains the values the server intends to send to a connecting client in
+// its CONNACK, which may be adjusted by the OnConnectNegotiate hook.
+type Negotiation struct {
+	Keepalive      uint16 // the keepalive of the connection in seconds, sent as the server keepalive if changed (v5 only)
+	SessionPresent bool   // if an existing session will be resumed; informational, changes are ignored
+	MaximumQos     byte   // the maximum qos the client may publish and be granted, no greater than the server maximum
+}
+
+// negotiateConnect calls the OnConnectNegotiate hook with the values to be sent in the
+// CONNACK of a client, applying any adjustments to the client. It is called before any
+// existing session is taken over, so a rejection leaves the existing session untouched.
+func (s *Server) negotiateConnect(cl *Client, pk packets.Packet) error {
+	if !s.hooks.Provides(OnConnectNegotiate) {
+		return nil
+	}
+
+	n := Negotiation{
+		Keepalive:      cl.State.Keepalive,
+		SessionPresent: s.sessionResumable(pk),
+		MaximumQos:     s.Options.Capabilities.MaximumQos,
+	}
+
+	nx, err := s.hooks.OnConnectNegotiate(cl, pk, n)
+	if err != nil {
+		return err
+	}
+
+	// a changed keepalive can only be signalled to the client with the v5 server
+	// keepalive property, so v3 clients keep the keepalive they requested.
+	if nx.Keepalive != n.Keepalive && cl.Properties.ProtocolVersion >= 5 {
+		cl.State.Keepalive = nx.Keepalive
+		cl.State.ServerKeepalive = true // [MQTT-3.2.2-21]
+		cl.refreshDeadline(cl.State.Keepalive)
+	}
+
+	if nx.MaximumQos < n.MaximumQos {
+		cl.State.maximumQos = &nx.MaximumQos
+	}
+
+	return nil
+}
+
+// sessionResumable returns true if a connect packet will resume the existing session
+// of a client, matching the outcome of inheritClientSession.
+func (s *Server) sessionResumable(pk packets.Packet) bool {
+	existing, ok := s.Clients.Get(pk.Connect.ClientIdentifier)
+	if !ok || pk.Connect.Clean {
+		return false // [MQTT-3.2.2-2]
+	}
+
+	return !(existing.Properties.Clean && existing.Properties.ProtocolVersion < 5) // [MQTT-3.1.2-4]
+}
+
+// maximumQos returns the maximum qos available to a client, which is the server maximum
+// unless a lower maximum was negotiated when the client connected.
+func (s *Server) maximumQos(cl *Client) byte {
+	if q := cl.State.maximumQos; q != nil && *q < s.Options.Capabilities.MaximumQos {
+		return *q
+	}
+
+	return s.Options.Capabilities.MaximumQos
+}
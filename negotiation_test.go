@@ -0,0 +1,249 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package mqtt
+
+import (
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+
+	"github.com/mochi-mqtt/server/v2/packets"
+	"github.com/stretchr/testify/require"
+)
+
+type negotiateHook struct {
+	HookBase
+	fn func(cl *Client, pk packets.Packet, n Negotiation) (Negotiation, error)
+}
+
+func (h *negotiateHook) ID() string {
+	return "negotiate-hook"
+}
+
+func (h *negotiateHook) Provides(b byte) bool {
+	return b == OnConnectNegotiate
+}
+
+func (h *negotiateHook) OnConnectNegotiate(cl *Client, pk packets.Packet, n Negotiation) (Negotiation, error) {
+	return h.fn(cl, pk, n)
+}
+
+func TestServerNegotiateConnectNoHook(t *testing.T) {
+	s := newServer()
+	cl, _, _ := newTestClient()
+	cl.State.Keepalive = 30
+
+	err := s.negotiateConnect(cl, packets.Packet{})
+	require.NoError(t, err)
+	require.Equal(t, uint16(30), cl.State.Keepalive)
+	require.False(t, cl.State.ServerKeepalive)
+	require.Equal(t, byte(2), s.maximumQos(cl))
+}
+
+func TestServerNegotiateConnect(t *testing.T) {
+	s := newServer()
+	var got Negotiation
+	err := s.AddHook(&negotiateHook{fn: func(cl *Client, pk packets.Packet, n Negotiation) (Negotiation, error) {
+		got = n
+		n.Keepalive = 120
+		n.SessionPresent = true
+		n.MaximumQos = 1
+		return n, nil
+	}}, nil)
+	require.NoError(t, err)
+
+	cl, _, _ := newTestClient()
+	cl.Properties.ProtocolVersion = 5
+	cl.State.Keepalive = 30
+
+	err = s.negotiateConnect(cl, packets.Packet{Connect: packets.ConnectParams{ClientIdentifier: cl.ID}})
+	require.NoError(t, err)
+	require.Equal(t, Negotiation{Keepalive: 30, SessionPresent: false, MaximumQos: 2}, got)
+	require.Equal(t, uint16(120), cl.State.Keepalive)
+	require.True(t, cl.State.ServerKeepalive)
+	require.Equal(t, byte(1), s.maximumQos(cl))
+
+	s.Options.Capabilities.MaximumQos = 0
+	require.Equal(t, byte(0), s.maximumQos(cl))
+}
+
+func TestServerNegotiateConnectKeepaliveIgnoredMqtt3(t *testing.T) {
+	s := newServer()
+	err := s.AddHook(&negotiateHook{fn: func(cl *Client, pk packets.Packet, n Negotiation) (Negotiation, error) {
+		n.Keepalive = 120
+		return n, nil
+	}}, nil)
+	require.NoError(t, err)
+
+	cl, _, _ := newTestClient()
+	cl.Properties.ProtocolVersion = 4
+	cl.State.Keepalive = 30
+
+	err = s.negotiateConnect(cl, packets.Packet{})
+	require.NoError(t, err)
+	require.Equal(t, uint16(30), cl.State.Keepalive)
+	require.False(t, cl.State.ServerKeepalive)
+}
+
+func TestServerNegotiateConnectSessionPresent(t *testing.T) {
+	s := newServer()
+	var got Negotiation
+	err := s.AddHook(&negotiateHook{fn: func(cl *Client, pk packets.Packet, n Negotiation) (Negotiation, error) {
+		got = n
+		return n, nil
+	}}, nil)
+	require.NoError(t, err)
+
+	existing, _, _ := newTestClient()
+	existing.Properties.ProtocolVersion = 5
+	s.Clients.Add(existing)
+
+	cl, _, _ := newTestClient()
+	err = s.negotiateConnect(cl, packets.Packet{Connect: packets.ConnectParams{ClientIdentifier: existing.ID}})
+	require.NoError(t, err)
+	require.True(t, got.SessionPresent)
+
+	err = s.negotiateConnect(cl, packets.Packet{Connect: packets.ConnectParams{ClientIdentifier: existing.ID, Clean: true}})
+	require.NoError(t, err)
+	require.False(t, got.SessionPresent)
+
+	existing.Properties.ProtocolVersion = 4
+	existing.Properties.Clean = true
+	err = s.negotiateConnect(cl, packets.Packet{Connect: packets.ConnectParams{ClientIdentifier: existing.ID}})
+	require.NoError(t, err)
+	require.False(t, got.SessionPresent)
+}
+
+func TestServerNegotiateConnectMaximumQosNotRaised(t *testing.T) {
+	s := newServer()
+	s.Options.Capabilities.MaximumQos = 1
+	err := s.AddHook(&negotiateHook{fn: func(cl *Client, pk packets.Packet, n Negotiation) (Negotiation, error) {
+		n.MaximumQos = 2
+		return n, nil
+	}}, nil)
+	require.NoError(t, err)
+
+	cl, _, _ := newTestClient()
+	err = s.negotiateConnect(cl, packets.Packet{})
+	require.NoError(t, err)
+	require.Nil(t, cl.State.maximumQos)
+	require.Equal(t, byte(1), s.maximumQos(cl))
+}
+
+func TestServerNegotiatedMaximumQosApplied(t *testing.T) {
+	s := newServer()
+	q := byte(1)
+
+	cl, r, _ := newTestClient()
+	cl.Properties.ProtocolVersion = 5
+	cl.State.maximumQos = &q
+	s.Clients.Add(cl)
+	go func() { _, _ = io.Copy(io.Discard, r) }()
+
+	err := s.processSubscribe(cl, packets.Packet{
+		FixedHeader: packets.FixedHeader{Type: packets.Subscribe, Qos: 1},
+		PacketID:    1,
+		Filters:     packets.Subscriptions{{Filter: "a/b/c", Qos: 2}},
+	})
+	require.NoError(t, err)
+
+	sub, ok := cl.State.Subscriptions.Get("a/b/c")
+	require.True(t, ok)
+	require.Equal(t, byte(1), sub.Qos)
+
+	out, err := s.publishToClient(cl, packets.Subscription{Filter: "a/b/c", Qos: 2}, packets.Packet{
+		FixedHeader: packets.FixedHeader{Type: packets.Publish, Qos: 2},
+		TopicName:   "a/b/c",
+	})
+	require.NoError(t, err)
+	require.Equal(t, byte(1), out.FixedHeader.Qos)
+}
+
+func TestEstablishConnectionNegotiateRejected(t *testing.T) {
+	s := newServer()
+	err := s.AddHook(&negotiateHook{fn: func(cl *Client, pk packets.Packet, n Negotiation) (Negotiation, error) {
+		return n, packets.ErrImplementationSpecificError
+	}}, nil)
+	require.NoError(t, err)
+	defer s.Close()
+
+	existing, _, _ := newTestClient()
+	existing.ID = "zen"
+	existing.Properties.ProtocolVersion = 5
+	existing.State.Subscriptions.Add("a/b/c", packets.Subscription{Filter: "a/b/c", Qos: 1})
+	s.Topics.Subscribe(existing.ID, packets.Subscription{Filter: "a/b/c", Qos: 1})
+	s.Clients.Add(existing)
+
+	r, w := net.Pipe()
+	o := make(chan error)
+	go func() {
+		o <- s.EstablishConnection("tcp", r)
+	}()
+
+	go func() {
+		_, _ = w.Write(packets.TPacketData[packets.Connect].Get(packets.TConnectMqtt5).RawBytes)
+	}()
+
+	recv := make(chan []byte)
+	go func() {
+		buf, _ := io.ReadAll(w)
+		recv <- buf
+	}()
+
+	require.ErrorIs(t, <-o, packets.ErrImplementationSpecificError)
+	_ = r.Close()
+
+	buf := <-recv
+	require.Equal(t, packets.Connack<<4, buf[0])
+	require.Equal(t, packets.ErrImplementationSpecificError.Code, buf[3])
+
+	// the existing session is not taken over when the negotiation is rejected
+	require.Equal(t, uint32(0), atomic.LoadUint32(&existing.State.isTakenOver))
+	require.False(t, existing.Closed())
+	require.Equal(t, 1, existing.State.Subscriptions.Len())
+	require.Contains(t, s.Topics.Subscribers("a/b/c").Subscriptions, existing.ID)
+}
+
+func TestEstablishConnectionNegotiated(t *testing.T) {
+	s := newServer()
+	err := s.AddHook(&negotiateHook{fn: func(cl *Client, pk packets.Packet, n Negotiation) (Negotiation, error) {
+		n.MaximumQos = 1
+		n.Keepalive = 90
+		return n, nil
+	}}, nil)
+	require.NoError(t, err)
+	defer s.Close()
+
+	r, w := net.Pipe()
+	o := make(chan error)
+	go func() {
+		o <- s.EstablishConnection("tcp", r)
+	}()
+
+	go func() {
+		_, _ = w.Write(packets.TPacketData[packets.Connect].Get(packets.TConnectMqtt5).RawBytes)
+		_, _ = w.Write(packets.TPacketData[packets.Disconnect].Get(packets.TDisconnect).RawBytes)
+	}()
+
+	recv := make(chan []byte)
+	go func() {
+		buf, _ := io.ReadAll(w)
+		recv <- buf
+	}()
+
+	require.NoError(t, <-o)
+	_ = r.Close()
+
+	buf := <-recv
+	ack := packets.Packet{ProtocolVersion: 5}
+	require.NoError(t, ack.FixedHeader.Decode(buf[0]))
+	require.NoError(t, ack.ConnackDecode(buf[2:]))
+	require.Equal(t, packets.CodeSuccess.Code, ack.ReasonCode)
+	require.True(t, ack.Properties.MaximumQosFlag)
+	require.Equal(t, byte(1), ack.Properties.MaximumQos)
+	require.True(t, ack.Properties.ServerKeepAliveFlag)
+	require.Equal(t, uint16(90), ack.Properties.ServerKeepAlive)
+}
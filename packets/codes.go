@@ -88,6 +88,7 @@ var (
 	ErrProtocolViolationNoFilters             = Code{Code: 0x82, Reason: "protocol violation: must contain at least one filter"}
 	ErrProtocolViolationInvalidReason         = Code{Code: 0x82, Reason: "protocol violation: invalid reason"}
 	ErrProtocolViolationOversizeSubID         = Code{Code: 0x82, Reason: "protocol violation: oversize subscription id"}
+	ErrProtocolViolationRetainHandling        = Code{Code: 0x82, Reason: "protocol violation: invalid retain handling"}
 	ErrProtocolViolationDupNoQos              = Code{Code: 0x82, Reason: "protocol violation: dup true with no qos"}
 	ErrProtocolViolationUnsupportedProperty   = Code{Code: 0x82, Reason: "protocol violation: unsupported property"}
 	ErrProtocolViolationNoTopic               = Code{Code: 0x82, Reason: "protocol violation: no topic or alias"}
@@ -141,9 +142,11 @@ var (
 	V5CodesToV3 = map[Code]Code{
 		ErrUnsupportedProtocolVersion: Err3UnsupportedProtocolVersion,
 		ErrClientIdentifierNotValid:   Err3ClientIdentifierNotValid,
+		ErrClientIdentifierTooLong:    Err3ClientIdentifierNotValid,
 		ErrServerUnavailable:          Err3ServerUnavailable,
 		ErrMalformedUsername:          ErrMalformedUsernameOrPassword,
 		ErrMalformedPassword:          ErrMalformedUsernameOrPassword,
 		ErrBadUsernameOrPassword:      Err3NotAuthorized,
+		ErrBanned:                     Err3NotAuthorized,
 	}
 )
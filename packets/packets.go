@@ -128,8 +128,10 @@ type Packet struct {
 	Filters         Subscriptions // a list of subscription filters and their properties (subscribe, unsubscribe)
 	TopicName       string        // the topic a payload is being published to
 	Origin          string        // client id of the client who is issuing the packet (mostly internal use)
+	TraceID         string        // the trace id of a publish, if tracing is enabled on the server
 	FixedHeader     FixedHeader   // -
 	Created         int64         // unix timestamp indicating time packet was created/received on the server
+	Received        int64         // unix nano timestamp indicating when a publish was received, for measuring delivery latency
 	Expiry          int64         // unix timestamp indicating when the packet will expire and should be deleted
 	Mods            Mods          // internal broker control values for controlling certain mqtt v5 compliance
 	PacketID        uint16        // packet id for the packet (publish, qos, etc)
@@ -138,6 +140,7 @@ type Packet struct {
 	ReasonCode      byte          // reason code for a packet response (acks, etc)
 	ReservedBit     byte          // reserved, do not use (except in testing)
 	Ignore          bool          // if true, do not perform any message forwarding operations
+	Compressed      bool          // if true, the payload is snappy compressed (retained messages only)
 }
 
 // Mods specifies certain values required for certain mqtt v5 compliance within packet encoding/decoding.
@@ -212,8 +215,11 @@ func (pk *Packet) Copy(allowTransfer bool) Packet {
 		ReasonCode:     pk.ReasonCode,
 		Filters:        pk.Filters,
 		Created:        pk.Created,
+		Received:       pk.Received,
 		Expiry:         pk.Expiry,
 		Origin:         pk.Origin,
+		TraceID:        pk.TraceID,
+		Compressed:     pk.Compressed,
 	}
 
 	if allowTransfer {
@@ -389,7 +395,7 @@ func (pk *Packet) ConnectDecode(buf []byte) error {
 	if pk.ProtocolVersion == 5 {
 		n, err := pk.Properties.Decode(pk.FixedHeader.Type, bytes.NewBuffer(buf[offset:]))
 		if err != nil {
-			return fmt.Errorf("%s: %w", err, ErrMalformedProperties)
+			return fmt.Errorf("%w: %w", err, ErrMalformedProperties)
 		}
 		offset += n
 	}
@@ -524,18 +530,18 @@ func (pk *Packet) ConnackDecode(buf []byte) error {
 
 	pk.SessionPresent, offset, err = decodeByteBool(buf, 0)
 	if err != nil {
-		return fmt.Errorf("%s: %w", err, ErrMalformedSessionPresent)
+		return fmt.Errorf("%w: %w", err, ErrMalformedSessionPresent)
 	}
 
 	pk.ReasonCode, offset, err = decodeByte(buf, offset)
 	if err != nil {
-		return fmt.Errorf("%s: %w", err, ErrMalformedReasonCode)
+		return fmt.Errorf("%w: %w", err, ErrMalformedReasonCode)
 	}
 
 	if pk.ProtocolVersion == 5 {
 		_, err := pk.Properties.Decode(pk.FixedHeader.Type, bytes.NewBuffer(buf[offset:]))
 		if err != nil {
-			return fmt.Errorf("%s: %w", err, ErrMalformedProperties)
+			return fmt.Errorf("%w: %w", err, ErrMalformedProperties)
 		}
 	}
 
@@ -570,13 +576,13 @@ func (pk *Packet) DisconnectDecode(buf []byte) error {
 		var offset int
 		pk.ReasonCode, offset, err = decodeByte(buf, offset)
 		if err != nil {
-			return fmt.Errorf("%s: %w", err, ErrMalformedReasonCode)
+			return fmt.Errorf("%w: %w", err, ErrMalformedReasonCode)
 		}
 
 		if pk.FixedHeader.Remaining > 2 {
 			_, err = pk.Properties.Decode(pk.FixedHeader.Type, bytes.NewBuffer(buf[offset:]))
 			if err != nil {
-				return fmt.Errorf("%s: %w", err, ErrMalformedProperties)
+				return fmt.Errorf("%w: %w", err, ErrMalformedProperties)
 			}
 		}
 	}
@@ -642,20 +648,20 @@ func (pk *Packet) PublishDecode(buf []byte) error {
 
 	pk.TopicName, offset, err = decodeString(buf, 0) // [MQTT-3.3.2-1]
 	if err != nil {
-		return fmt.Errorf("%s: %w", err, ErrMalformedTopic)
+		return fmt.Errorf("%w: %w", err, ErrMalformedTopic)
 	}
 
 	if pk.FixedHeader.Qos > 0 {
 		pk.PacketID, offset, err = decodeUint16(buf, offset)
 		if err != nil {
-			return fmt.Errorf("%s: %w", err, ErrMalformedPacketID)
+			return fmt.Errorf("%w: %w", err, ErrMalformedPacketID)
 		}
 	}
 
 	if pk.ProtocolVersion == 5 {
 		n, err := pk.Properties.Decode(pk.FixedHeader.Type, bytes.NewBuffer(buf[offset:]))
 		if err != nil {
-			return fmt.Errorf("%s: %w", err, ErrMalformedProperties)
+			return fmt.Errorf("%w: %w", err, ErrMalformedProperties)
 		}
 
 		offset += n
@@ -730,19 +736,19 @@ func (pk *Packet) decodePubAckRelRecComp(buf []byte) error {
 	var err error
 	pk.PacketID, offset, err = decodeUint16(buf, offset)
 	if err != nil {
-		return fmt.Errorf("%s: %w", err, ErrMalformedPacketID)
+		return fmt.Errorf("%w: %w", err, ErrMalformedPacketID)
 	}
 
 	if pk.ProtocolVersion == 5 && pk.FixedHeader.Remaining > 2 {
 		pk.ReasonCode, offset, err = decodeByte(buf, offset)
 		if err != nil {
-			return fmt.Errorf("%s: %w", err, ErrMalformedReasonCode)
+			return fmt.Errorf("%w: %w", err, ErrMalformedReasonCode)
 		}
 
 		if pk.FixedHeader.Remaining > 3 {
 			_, err = pk.Properties.Decode(pk.FixedHeader.Type, bytes.NewBuffer(buf[offset:]))
 			if err != nil {
-				return fmt.Errorf("%s: %w", err, ErrMalformedProperties)
+				return fmt.Errorf("%w: %w", err, ErrMalformedProperties)
 			}
 		}
 	}
@@ -871,13 +877,13 @@ func (pk *Packet) SubackDecode(buf []byte) error {
 
 	pk.PacketID, offset, err = decodeUint16(buf, offset)
 	if err != nil {
-		return fmt.Errorf("%s: %w", err, ErrMalformedPacketID)
+		return fmt.Errorf("%w: %w", err, ErrMalformedPacketID)
 	}
 
 	if pk.ProtocolVersion == 5 {
 		n, err := pk.Properties.Decode(pk.FixedHeader.Type, bytes.NewBuffer(buf[offset:]))
 		if err != nil {
-			return fmt.Errorf("%s: %w", err, ErrMalformedProperties)
+			return fmt.Errorf("%w: %w", err, ErrMalformedProperties)
 		}
 		offset += n
 	}
@@ -937,7 +943,7 @@ func (pk *Packet) SubscribeDecode(buf []byte) error {
 	if pk.ProtocolVersion == 5 {
 		n, err := pk.Properties.Decode(pk.FixedHeader.Type, bytes.NewBuffer(buf[offset:]))
 		if err != nil {
-			return fmt.Errorf("%s: %w", err, ErrMalformedProperties)
+			return fmt.Errorf("%w: %w", err, ErrMalformedProperties)
 		}
 		offset += n
 	}
@@ -994,6 +1000,10 @@ func (pk *Packet) SubscribeValidate() Code {
 		if v.Identifier > 268435455 { // 3.3.2.3.8 The Subscription Identifier can have the value of 1 to 268,435,455.
 			return ErrProtocolViolationOversizeSubID //
 		}
+
+		if v.RetainHandling > 2 { // 3.8.3.1 It is a Protocol Error to send a Retain Handling value of 3.
+			return ErrProtocolViolationRetainHandling
+		}
 	}
 
 	return CodeSuccess
@@ -1027,13 +1037,13 @@ func (pk *Packet) UnsubackDecode(buf []byte) error {
 
 	pk.PacketID, offset, err = decodeUint16(buf, offset)
 	if err != nil {
-		return fmt.Errorf("%s: %w", err, ErrMalformedPacketID)
+		return fmt.Errorf("%w: %w", err, ErrMalformedPacketID)
 	}
 
 	if pk.ProtocolVersion == 5 {
 		n, err := pk.Properties.Decode(pk.FixedHeader.Type, bytes.NewBuffer(buf[offset:]))
 		if err != nil {
-			return fmt.Errorf("%s: %w", err, ErrMalformedProperties)
+			return fmt.Errorf("%w: %w", err, ErrMalformedProperties)
 		}
 
 		offset += n
@@ -1083,13 +1093,13 @@ func (pk *Packet) UnsubscribeDecode(buf []byte) error {
 
 	pk.PacketID, offset, err = decodeUint16(buf, offset)
 	if err != nil {
-		return fmt.Errorf("%s: %w", err, ErrMalformedPacketID)
+		return fmt.Errorf("%w: %w", err, ErrMalformedPacketID)
 	}
 
 	if pk.ProtocolVersion == 5 {
 		n, err := pk.Properties.Decode(pk.FixedHeader.Type, bytes.NewBuffer(buf[offset:]))
 		if err != nil {
-			return fmt.Errorf("%s: %w", err, ErrMalformedProperties)
+			return fmt.Errorf("%w: %w", err, ErrMalformedProperties)
 		}
 		offset += n
 	}
@@ -1099,7 +1109,7 @@ func (pk *Packet) UnsubscribeDecode(buf []byte) error {
 	for offset < len(buf) {
 		filter, offset, err = decodeString(buf, offset) // [MQTT-3.10.3-1]
 		if err != nil {
-			return fmt.Errorf("%s: %w", err, ErrMalformedTopic)
+			return fmt.Errorf("%w: %w", err, ErrMalformedTopic)
 		}
 		pk.Filters = append(pk.Filters, Subscription{Filter: filter})
 	}
@@ -1144,12 +1154,12 @@ func (pk *Packet) AuthDecode(buf []byte) error {
 
 	pk.ReasonCode, offset, err = decodeByte(buf, offset)
 	if err != nil {
-		return fmt.Errorf("%s: %w", err, ErrMalformedReasonCode)
+		return fmt.Errorf("%w: %w", err, ErrMalformedReasonCode)
 	}
 
 	_, err = pk.Properties.Decode(pk.FixedHeader.Type, bytes.NewBuffer(buf[offset:]))
 	if err != nil {
-		return fmt.Errorf("%s: %w", err, ErrMalformedProperties)
+		return fmt.Errorf("%w: %w", err, ErrMalformedProperties)
 	}
 
 	return nil
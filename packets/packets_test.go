@@ -207,6 +207,17 @@ func TestPacketEncode(t *testing.T) {
 	}
 }
 
+func TestPacketDecodeWrapsCause(t *testing.T) {
+	pk := Packet{FixedHeader: FixedHeader{Type: Publish}}
+	err := pk.PublishDecode([]byte{0, 5, 'a'})
+	require.ErrorIs(t, err, ErrMalformedTopic)
+	require.ErrorIs(t, err, ErrMalformedOffsetBytesOutOfRange)
+
+	var code Code
+	require.ErrorAs(t, err, &code)
+	require.Equal(t, ErrMalformedPacket.Code, code.Code)
+}
+
 func TestPacketDecode(t *testing.T) {
 	for _, pkt := range packetList {
 		require.Contains(t, TPacketData, pkt)
@@ -462,7 +473,10 @@ func TestCopy(t *testing.T) {
 		require.Equal(t, tt.Packet.Connect.WillPayload, pkc.Connect.WillPayload, pkInfo, tt.Case, tt.Desc)
 		require.Equal(t, tt.Packet.ReasonCodes, pkc.ReasonCodes, pkInfo, tt.Case, tt.Desc)
 		require.Equal(t, tt.Packet.Created, pkc.Created, pkInfo, tt.Case, tt.Desc)
+		require.Equal(t, tt.Packet.Received, pkc.Received, pkInfo, tt.Case, tt.Desc)
 		require.Equal(t, tt.Packet.Origin, pkc.Origin, pkInfo, tt.Case, tt.Desc)
+		require.Equal(t, tt.Packet.TraceID, pkc.TraceID, pkInfo, tt.Case, tt.Desc)
+		require.Equal(t, tt.Packet.Compressed, pkc.Compressed, pkInfo, tt.Case, tt.Desc)
 		require.EqualValues(t, pkc.Properties, tt.Packet.Properties)
 
 		pkcc := tt.Packet.Copy(false)
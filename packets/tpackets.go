@@ -170,6 +170,7 @@ const (
 	TSubscribeInvalidSharedNoLocal
 	TSubscribeInvalidFilter
 	TSubscribeInvalidIdentifierOversize
+	TSubscribeInvalidRetainHandling
 	TSuback
 	TSubackMany
 	TSubackDeny
@@ -3094,6 +3095,22 @@ var TPacketData = map[byte]TPacketCases{
 				},
 			},
 		},
+		{
+			Case:   TSubscribeInvalidRetainHandling,
+			Desc:   "invalid retain handling",
+			Group:  "validate",
+			Expect: ErrProtocolViolationRetainHandling,
+			Packet: &Packet{
+				FixedHeader: FixedHeader{
+					Type: Subscribe,
+					Qos:  1,
+				},
+				PacketID: 2,
+				Filters: Subscriptions{
+					{Filter: "a/b", RetainHandling: 3},
+				},
+			},
+		},
 
 		// Spec tests
 		{
@@ -0,0 +1,90 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package mqtt
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// KeepaliveHealth describes how closely a client keeps to its keepalive interval, based
+// on the intervals between the PINGREQ packets it sends. Clients which send other packets
+// within the keepalive interval may legitimately skip pings, so health is most telling for
+// idle devices, where missed pings and high jitter point to a broken keepalive implementation.
+type KeepaliveHealth struct {
+	ClientID     string  `json:"client_id"`           // the id of the client
+	Keepalive    uint16  `json:"keepalive"`           // the keepalive interval of the client in seconds
+	Pings        int64   `json:"pings"`               // the number of PINGREQ packets received
+	LastPing     int64   `json:"last_ping,omitempty"` // the unix time the last PINGREQ was received
+	MeanInterval float64 `json:"mean_interval"`       // the mean seconds between PINGREQ packets
+	Jitter       float64 `json:"jitter"`              // the smoothed variation in seconds between consecutive intervals
+	Missed       int64   `json:"missed"`              // the number of intervals which exceeded the keepalive
+	MissedRatio  float64 `json:"missed_ratio"`        // the fraction of intervals which exceeded the keepalive
+}
+
+// pingStats records the arrival intervals of the PINGREQ packets of a client.
+type pingStats struct {
+	sync.Mutex
+	count    int64         // the number of pings received
+	last     time.Time     // the time the last ping was received
+	interval time.Duration // the last interval between pings
+	total    time.Duration // the sum of the intervals between pings
+	jitter   float64       // the smoothed interval variation in seconds
+	missed   int64         // the number of intervals which exceeded the keepalive
+}
+
+// record adds a PINGREQ received at a time from a client with a keepalive in seconds.
+func (p *pingStats) record(now time.Time, keepalive uint16) {
+	p.Lock()
+	defer p.Unlock()
+
+	if p.count > 0 {
+		interval := now.Sub(p.last)
+		p.total += interval
+		if keepalive > 0 && interval > time.Duration(keepalive)*time.Second {
+			p.missed++
+		}
+
+		if p.count > 1 { // smoothed as per the rtp interarrival jitter of rfc 3550
+			d := math.Abs((interval - p.interval).Seconds())
+			p.jitter += (d - p.jitter) / 16
+		}
+		p.interval = interval
+	}
+
+	p.count++
+	p.last = now
+}
+
+// health returns the keepalive health of a client from the recorded pings.
+func (p *pingStats) health(id string, keepalive uint16) KeepaliveHealth {
+	p.Lock()
+	defer p.Unlock()
+
+	h := KeepaliveHealth{
+		ClientID:  id,
+		Keepalive: keepalive,
+		Pings:     p.count,
+		Jitter:    p.jitter,
+		Missed:    p.missed,
+	}
+
+	if p.count > 0 {
+		h.LastPing = p.last.Unix()
+	}
+
+	if intervals := p.count - 1; intervals > 0 {
+		h.MeanInterval = p.total.Seconds() / float64(intervals)
+		h.MissedRatio = float64(p.missed) / float64(intervals)
+	}
+
+	return h
+}
+
+// KeepaliveHealth returns the keepalive health of the client, if keepalive metrics are enabled.
+func (cl *Client) KeepaliveHealth() KeepaliveHealth {
+	return cl.State.pings.health(cl.ID, cl.State.Keepalive)
+}
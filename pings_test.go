@@ -0,0 +1,71 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package mqtt
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mochi-mqtt/server/v2/packets"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPingStatsRecord(t *testing.T) {
+	p := new(pingStats)
+	now := time.Unix(1700000000, 0)
+
+	h := p.health("zen", 10)
+	require.Equal(t, KeepaliveHealth{ClientID: "zen", Keepalive: 10}, h)
+
+	p.record(now, 10)
+	p.record(now.Add(time.Second*10), 10)
+	p.record(now.Add(time.Second*20), 10)
+	p.record(now.Add(time.Second*34), 10) // late
+
+	h = p.health("zen", 10)
+	require.Equal(t, int64(4), h.Pings)
+	require.Equal(t, now.Add(time.Second*34).Unix(), h.LastPing)
+	require.Equal(t, int64(1), h.Missed)
+	require.InDelta(t, 1.0/3, h.MissedRatio, 0.0001)
+	require.InDelta(t, 34.0/3, h.MeanInterval, 0.0001)
+	require.InDelta(t, 0.25, h.Jitter, 0.0001) // (0 + 4) / 16
+}
+
+func TestPingStatsRecordNoKeepalive(t *testing.T) {
+	p := new(pingStats)
+	now := time.Unix(1700000000, 0)
+	p.record(now, 0)
+	p.record(now.Add(time.Hour), 0)
+	require.Equal(t, int64(0), p.health("zen", 0).Missed)
+}
+
+func TestServerProcessPingreqKeepaliveMetrics(t *testing.T) {
+	s := newServer()
+	s.Options.KeepaliveMetrics = true
+	s.Options.Clock = fixedClock(time.Unix(100, 0))
+	cl, _, _ := newTestClient()
+	s.Clients.Add(cl)
+
+	go func() {
+		err := s.processPacket(cl, *packets.TPacketData[packets.Pingreq].Get(packets.TPingreq).Packet)
+		require.NoError(t, err)
+	}()
+
+	require.Eventually(t, func() bool {
+		return cl.KeepaliveHealth().Pings == 1
+	}, time.Second, time.Millisecond)
+
+	st := s.Stats()
+	require.Len(t, st.Keepalive, 1)
+	require.Equal(t, cl.ID, st.Keepalive[0].ClientID)
+	require.Equal(t, int64(100), st.Keepalive[0].LastPing)
+}
+
+func TestServerStatsKeepaliveMetricsDisabled(t *testing.T) {
+	s := newServer()
+	cl, _, _ := newTestClient()
+	s.Clients.Add(cl)
+	require.Nil(t, s.Stats().Keepalive)
+}
@@ -0,0 +1,51 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package mqtt
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// PanicError is the error a client is stopped with when the server recovers from a
+// panic in one of the goroutines of the client, such as in a hook or packet handler.
+type PanicError struct {
+	Value any    // the value passed to panic
+	Stack []byte // the stack trace of the goroutine which panicked
+}
+
+// Error returns the panic value as an error string.
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("recovered panic: %v", e.Value)
+}
+
+// Unwrap returns the panic value if it is an error.
+func (e *PanicError) Unwrap() error {
+	if err, ok := e.Value.(error); ok {
+		return err
+	}
+
+	return nil
+}
+
+// recoverPanic recovers from a panic in a goroutine of the client, so that only the
+// client is stopped and the broker keeps running. The panic is logged with its stack
+// and passed to the OnError hooks, and if errp is not nil, it is set to the panic error.
+// It must be deferred directly.
+func (cl *Client) recoverPanic(errp *error) {
+	v := recover()
+	if v == nil {
+		return
+	}
+
+	err := &PanicError{Value: v, Stack: debug.Stack()}
+	cl.ops.log.Error("recovered from client panic", "error", err, "client", cl.ID, "listener", cl.Net.Listener, "stack", string(err.Stack))
+	reportError(cl.ops.hooks, cl, ErrorSubsystemPanic, err)
+
+	cl.Stop(err)
+	if errp != nil {
+		*errp = err
+	}
+}
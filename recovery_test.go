@@ -0,0 +1,125 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package mqtt
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/mochi-mqtt/server/v2/packets"
+	"github.com/stretchr/testify/require"
+)
+
+type panicHook struct {
+	HookBase
+	sync.Mutex
+	errs []error
+}
+
+func (h *panicHook) ID() string {
+	return "panic"
+}
+
+func (h *panicHook) Provides(b byte) bool {
+	return b == OnPublish || b == OnError
+}
+
+func (h *panicHook) OnPublish(cl *Client, pk packets.Packet) (packets.Packet, error) {
+	panic("bad hook")
+}
+
+func (h *panicHook) OnError(cl *Client, err error) {
+	h.Lock()
+	defer h.Unlock()
+	h.errs = append(h.errs, err)
+}
+
+func (h *panicHook) Errors() []error {
+	h.Lock()
+	defer h.Unlock()
+	return h.errs
+}
+
+func TestPanicError(t *testing.T) {
+	err := &PanicError{Value: "bad"}
+	require.Equal(t, "recovered panic: bad", err.Error())
+	require.Nil(t, err.Unwrap())
+
+	err = &PanicError{Value: io.ErrUnexpectedEOF}
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+func TestClientReadRecoversPanic(t *testing.T) {
+	cl, r, _ := newTestClient()
+	hook := new(panicHook)
+	require.NoError(t, cl.ops.hooks.Add(hook, nil))
+
+	go func() {
+		_, _ = r.Write(packets.TPacketData[packets.Pingreq].Get(packets.TPingreq).RawBytes)
+	}()
+
+	err := cl.Read(func(cl *Client, pk packets.Packet) error {
+		panic("bad handler")
+	})
+
+	var pe *PanicError
+	require.ErrorAs(t, err, &pe)
+	require.Equal(t, "bad handler", pe.Value)
+	require.NotEmpty(t, pe.Stack)
+	require.True(t, cl.Closed())
+	require.ErrorAs(t, cl.StopCause(), &pe)
+
+	require.Len(t, hook.Errors(), 1)
+	require.ErrorAs(t, hook.Errors()[0], &pe)
+}
+
+func TestClientRecoverPanicNoPanic(t *testing.T) {
+	cl, _, _ := newTestClient()
+	err := errors.New("unchanged")
+	func() {
+		defer cl.recoverPanic(&err)
+	}()
+
+	require.Equal(t, "unchanged", err.Error())
+	require.False(t, cl.Closed())
+}
+
+func TestServerEstablishConnectionRecoversPanic(t *testing.T) {
+	s := New(&Options{Logger: logger})
+	defer s.Close()
+
+	hook := new(panicHook)
+	require.NoError(t, s.AddHook(new(AllowHook), nil))
+	require.NoError(t, s.AddHook(hook, nil))
+
+	r, w := net.Pipe()
+	o := make(chan error)
+	go func() {
+		o <- s.EstablishConnection("tcp", r)
+	}()
+
+	go func() {
+		_, _ = w.Write(packets.TPacketData[packets.Connect].Get(packets.TConnectClean).RawBytes)
+		_, _ = w.Write(packets.TPacketData[packets.Publish].Get(packets.TPublishBasic).RawBytes)
+	}()
+
+	go func() {
+		_, _ = io.Copy(io.Discard, w)
+	}()
+
+	var pe *PanicError
+	require.ErrorAs(t, <-o, &pe)
+	require.Equal(t, "bad hook", pe.Value)
+	require.Len(t, hook.Errors(), 1)
+
+	// the client is cleaned up as though it had disconnected.
+	_, ok := s.Clients.Get(packets.TPacketData[packets.Connect].Get(packets.TConnectClean).Packet.Connect.ClientIdentifier)
+	require.False(t, ok)
+
+	_ = w.Close()
+}
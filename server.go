@@ -6,12 +6,17 @@
 package mqtt
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"math"
 	"net"
 	"os"
 	"runtime"
+	"runtime/debug"
 	"sort"
 	"strconv"
 	"strings"
@@ -33,6 +38,17 @@ const (
 	InlineClientId                = "inline"
 )
 
+const (
+	ConnectionStateConnected     = "connected"                               // the connection event state for a connected client
+	ConnectionStateDisconnected  = "disconnected"                            // the connection event state for a disconnected client
+	defaultConnectionEventsTopic = "$SYS/broker/connection/{clientid}/state" // the default topic for connection events
+	defaultTraceProperty         = "trace-id"                                // the default user property holding message trace ids
+	defaultCompressionProperty   = "content-encoding"                        // the default user property marking compressed payloads
+	defaultBackpressureTimeout   = 1000                                      // the default maximum milliseconds a publish is held by backpressure
+	defaultTopicMetricsLimit     = 1000                                      // the default maximum number of topic prefixes counted separately
+	defaultInflightResendMaximum = 300                                       // the default maximum seconds between inflight resends
+)
+
 var (
 	// Deprecated: Use NewDefaultServerCapabilities to avoid data race issue.
 	DefaultServerCapabilities = NewDefaultServerCapabilities()
@@ -41,26 +57,32 @@ var (
 	ErrConnectionClosed       = errors.New("connection not open")                                      // connection is closed
 	ErrInlineClientNotEnabled = errors.New("please set Options.InlineClient=true to use this feature") // inline client is not enabled by default
 	ErrOptionsUnreadable      = errors.New("unable to read options from bytes")
+	ErrInvalidBan             = errors.New("a ban requires a client id or ip address") // a ban must match at least one value
+	ErrClientNotFound         = errors.New("client not found")                         // no client exists with the given id
+	ErrListenerIDNotFound     = errors.New("listener id not found")                    // no listener exists with the given id
 )
 
 // Capabilities indicates the capabilities and features provided by the server.
 type Capabilities struct {
-	MaximumClients               int64           `yaml:"maximum_clients" json:"maximum_clients"`                                 // maximum number of connected clients
-	MaximumMessageExpiryInterval int64           `yaml:"maximum_message_expiry_interval" json:"maximum_message_expiry_interval"` // maximum message expiry if message expiry is 0 or over
-	MaximumClientWritesPending   int32           `yaml:"maximum_client_writes_pending" json:"maximum_client_writes_pending"`     // maximum number of pending message writes for a client
-	MaximumSessionExpiryInterval uint32          `yaml:"maximum_session_expiry_interval" json:"maximum_session_expiry_interval"` // maximum number of seconds to keep disconnected sessions
-	MaximumPacketSize            uint32          `yaml:"maximum_packet_size" json:"maximum_packet_size"`                         // maximum packet size, no limit if 0
-	maximumPacketID              uint32          // unexported, used for testing only
-	ReceiveMaximum               uint16          `yaml:"receive_maximum" json:"receive_maximum"`                   // maximum number of concurrent qos messages per client
-	MaximumInflight              uint16          `yaml:"maximum_inflight" json:"maximum_inflight"`                 // maximum number of qos > 0 messages can be stored, 0(=8192)-65535
-	TopicAliasMaximum            uint16          `yaml:"topic_alias_maximum" json:"topic_alias_maximum"`           // maximum topic alias value
-	SharedSubAvailable           byte            `yaml:"shared_sub_available" json:"shared_sub_available"`         // support of shared subscriptions
-	MinimumProtocolVersion       byte            `yaml:"minimum_protocol_version" json:"minimum_protocol_version"` // minimum supported mqtt version
-	Compatibilities              Compatibilities `yaml:"compatibilities" json:"compatibilities"`                   // version compatibilities the server provides
-	MaximumQos                   byte            `yaml:"maximum_qos" json:"maximum_qos"`                           // maximum qos value available to clients
-	RetainAvailable              byte            `yaml:"retain_available" json:"retain_available"`                 // support of retain messages
-	WildcardSubAvailable         byte            `yaml:"wildcard_sub_available" json:"wildcard_sub_available"`     // support of wildcard subscriptions
-	SubIDAvailable               byte            `yaml:"sub_id_available" json:"sub_id_available"`                 // support of subscription identifiers
+	MaximumClients                int64           `yaml:"maximum_clients" json:"maximum_clients"`                                   // maximum number of connected clients
+	MaximumMessageExpiryInterval  int64           `yaml:"maximum_message_expiry_interval" json:"maximum_message_expiry_interval"`   // maximum message expiry if message expiry is 0 or over
+	MaximumOfflineMessageAge      int64           `yaml:"maximum_offline_message_age" json:"maximum_offline_message_age"`           // maximum age in seconds of messages queued for disconnected sessions, no limit if 0
+	MaximumClientWritesPending    int32           `yaml:"maximum_client_writes_pending" json:"maximum_client_writes_pending"`       // maximum number of pending message writes for a client
+	MaximumSessionExpiryInterval  uint32          `yaml:"maximum_session_expiry_interval" json:"maximum_session_expiry_interval"`   // maximum number of seconds to keep disconnected sessions
+	MaximumPacketSize             uint32          `yaml:"maximum_packet_size" json:"maximum_packet_size"`                           // maximum packet size, no limit if 0
+	MaximumUsernameConnections    int64           `yaml:"maximum_username_connections" json:"maximum_username_connections"`         // maximum number of simultaneous connections per username, no limit if 0
+	EvictOldestUsernameConnection bool            `yaml:"evict_oldest_username_connection" json:"evict_oldest_username_connection"` // disconnect the oldest connection of a username at the limit, instead of refusing the new one
+	maximumPacketID               uint32          // unexported, used for testing only
+	ReceiveMaximum                uint16          `yaml:"receive_maximum" json:"receive_maximum"`                   // maximum number of concurrent qos messages per client
+	MaximumInflight               uint16          `yaml:"maximum_inflight" json:"maximum_inflight"`                 // maximum number of qos > 0 messages can be stored, 0(=8192)-65535
+	TopicAliasMaximum             uint16          `yaml:"topic_alias_maximum" json:"topic_alias_maximum"`           // maximum topic alias value
+	SharedSubAvailable            byte            `yaml:"shared_sub_available" json:"shared_sub_available"`         // support of shared subscriptions
+	MinimumProtocolVersion        byte            `yaml:"minimum_protocol_version" json:"minimum_protocol_version"` // minimum supported mqtt version
+	Compatibilities               Compatibilities `yaml:"compatibilities" json:"compatibilities"`                   // version compatibilities the server provides
+	MaximumQos                    byte            `yaml:"maximum_qos" json:"maximum_qos"`                           // maximum qos value available to clients
+	RetainAvailable               byte            `yaml:"retain_available" json:"retain_available"`                 // support of retain messages
+	WildcardSubAvailable          byte            `yaml:"wildcard_sub_available" json:"wildcard_sub_available"`     // support of wildcard subscriptions
+	SubIDAvailable                byte            `yaml:"sub_id_available" json:"sub_id_available"`                 // support of subscription identifiers
 }
 
 // NewDefaultServerCapabilities defines the default features and capabilities provided by the server.
@@ -129,6 +151,172 @@ type Options struct {
 	// Enable Inline client to allow direct subscribing and publishing from the parent codebase,
 	// with negligible performance difference (disabled by default to prevent confusion in statistics).
 	InlineClient bool `yaml:"inline_client" json:"inline_client"`
+
+	// TopicMetricsDepth specifies the number of topic levels used to group per-topic message
+	// counters, which are published to $SYS/metrics/topics. Counting is disabled if 0.
+	TopicMetricsDepth int `yaml:"topic_metrics_depth" json:"topic_metrics_depth"`
+
+	// TopicMetricsLimit specifies the maximum number of topic prefixes which are counted
+	// separately by the topic and delivery latency metrics, so that publishers cannot grow
+	// them without bound by using many distinct topics. Messages on further prefixes are
+	// counted under the other prefix.
+	// Defaults to 1000 if TopicMetricsDepth is set, and unlimited if less than 0.
+	TopicMetricsLimit int `yaml:"topic_metrics_limit" json:"topic_metrics_limit"`
+
+	// ConnectionEvents enables publishing a retained JSON event each time a client connects
+	// or disconnects, allowing other services to track client presence.
+	ConnectionEvents bool `yaml:"connection_events" json:"connection_events"`
+
+	// ConnectionEventsTopic specifies the topic connection events are published to, where
+	// {clientid} is replaced with the id of the client. Defaults to $SYS/broker/connection/{clientid}/state.
+	ConnectionEventsTopic string `yaml:"connection_events_topic" json:"connection_events_topic"`
+
+	// CompactionInterval specifies the interval in seconds between scheduled compactions, which
+	// remove expired sessions and messages and compact any persistence stores. Disabled if 0.
+	CompactionInterval int64 `yaml:"compaction_interval" json:"compaction_interval"`
+
+	// ReservedTopicPrefixes specifies topic prefixes which, like $SYS, clients may not publish
+	// to and only receive from subscriptions with filters explicitly beginning with the prefix.
+	ReservedTopicPrefixes []string `yaml:"reserved_topic_prefixes" json:"reserved_topic_prefixes"`
+
+	// DescriptorHeadroom specifies the number of file descriptors to keep in reserve below the
+	// process open file limit (RLIMIT_NOFILE). Once the open client connections reach the limit
+	// less the headroom, new connections are refused with a server unavailable CONNACK instead of
+	// failing at the OS limit. Disabled if 0, or if the limit cannot be determined.
+	DescriptorHeadroom int64 `yaml:"descriptor_headroom" json:"descriptor_headroom"`
+
+	// TraceMessages stamps each inbound publish with a broker generated trace id, so a message
+	// can be followed through hooks, bridges, and subscribers. The trace id is available to hooks
+	// as packet.TraceID, and is sent to MQTT v5 subscribers as a user property. Publishes which
+	// already carry a trace id property, such as those from another broker, keep their trace id.
+	TraceMessages bool `yaml:"trace_messages" json:"trace_messages"`
+
+	// TraceProperty specifies the name of the user property holding the trace id. Defaults to trace-id.
+	TraceProperty string `yaml:"trace_property" json:"trace_property"`
+
+	// DeliveryLatency enables histograms of the time taken from the receipt of a publish to its
+	// write to each subscriber, grouped by listener and by TopicMetricsDepth levels of the topic,
+	// which are published to $SYS/metrics/latency.
+	DeliveryLatency bool `yaml:"delivery_latency" json:"delivery_latency"`
+
+	// KeepaliveMetrics enables recording of the intervals between the PINGREQ packets of each
+	// client, from which the keepalive health of connected clients, such as the ratio of missed
+	// pings and their jitter, is included in Stats.
+	KeepaliveMetrics bool `yaml:"keepalive_metrics" json:"keepalive_metrics"`
+
+	// BackpressureWatermark enables flow control of publishing clients. While any subscriber to a
+	// topic has at least this many pending writes, qos 1 and 2 publishes to the topic are held,
+	// which pauses reads from the publisher and delays its PUBACK or PUBREC, until the subscribers
+	// catch up or BackpressureTimeout elapses. Only the selected member of each shared subscription
+	// group is considered, and qos 0 publishes are never held. Disabled if 0.
+	BackpressureWatermark int32 `yaml:"backpressure_watermark" json:"backpressure_watermark"`
+
+	// BackpressureTimeout specifies the maximum time in milliseconds a publish is held by
+	// backpressure before it is delivered regardless. Defaults to 1000.
+	BackpressureTimeout int64 `yaml:"backpressure_timeout" json:"backpressure_timeout"`
+
+	// ViolationPolicy determines how malformed packets and protocol violations from connected
+	// clients are handled; one of disconnect (default), drop, or ban.
+	ViolationPolicy ViolationPolicy `yaml:"violation_policy" json:"violation_policy"`
+
+	// ViolationLimit specifies the number of violations a client may commit under the ban
+	// policy before it is disconnected and banned. Defaults to 10.
+	ViolationLimit int32 `yaml:"violation_limit" json:"violation_limit"`
+
+	// ViolationBanDuration specifies the duration in seconds of bans applied under the ban
+	// policy. Bans are indefinite if 0.
+	ViolationBanDuration int64 `yaml:"violation_ban_duration" json:"violation_ban_duration"`
+
+	// ViolationBanIP indicates that bans applied under the ban policy should match the remote
+	// ip address of the client rather than its client id. As an ip address may be shared by
+	// many clients, for example behind a NAT gateway or proxy, this is disabled by default.
+	ViolationBanIP bool `yaml:"violation_ban_ip" json:"violation_ban_ip"`
+
+	// InflightOverflow determines how a message is handled when the inflight store of the
+	// receiving client is full; one of reject (default) or evict_oldest.
+	InflightOverflow InflightPolicy `yaml:"inflight_overflow" json:"inflight_overflow"`
+
+	// InflightResendInterval specifies the seconds after which an unacknowledged qos 1 or 2
+	// message is resent to a connected MQTT v3 client, measured by the server clock. The
+	// interval doubles after each resend, up to InflightResendMaximum. If 0 (the default),
+	// messages are only resent when the client reconnects, which MQTT v5 requires of v5
+	// clients, so v5 clients are never resent messages on the interval [MQTT-4.4.0-1].
+	InflightResendInterval int64 `yaml:"inflight_resend_interval" json:"inflight_resend_interval"`
+
+	// InflightResendMaximum specifies the maximum seconds between resends of a message when
+	// InflightResendInterval is set. Defaults to 300.
+	InflightResendMaximum int64 `yaml:"inflight_resend_maximum" json:"inflight_resend_maximum"`
+
+	// ClientIDPolicy determines which client identifiers are accepted; one of any (default),
+	// strict, or relaxed. Clients with rejected identifiers are refused with a client identifier
+	// not valid CONNACK (identifier rejected for MQTT v3).
+	ClientIDPolicy ClientIDPolicy `yaml:"client_id_policy" json:"client_id_policy"`
+
+	// ClientIDMaxLength specifies the maximum length in bytes of client identifiers under the
+	// strict and relaxed client id policies. Defaults to 23.
+	ClientIDMaxLength int `yaml:"client_id_max_length" json:"client_id_max_length"`
+
+	// ClientIDGenerator generates the identifiers assigned to clients which connect with an
+	// empty client id. Defaults to xid.
+	ClientIDGenerator ClientIDGenerator `yaml:"-" json:"-"`
+
+	// SubscriberCacheSize specifies the number of recently published topics for which the
+	// matching subscribers are cached, so messages on busy topics are delivered without
+	// walking the topic tree. The cache is cleared whenever a subscription changes. Disabled if 0.
+	SubscriberCacheSize int `yaml:"subscriber_cache_size" json:"subscriber_cache_size"`
+
+	// RetainedCompressionThreshold specifies the payload size in bytes at or above which
+	// retained messages are snappy compressed in the topics tree. Payloads are decoded when
+	// read, so hooks and other readers of retained messages always see the original payload.
+	// Payloads which do not shrink are kept uncompressed. Disabled if 0.
+	RetainedCompressionThreshold int `yaml:"retained_compression_threshold" json:"retained_compression_threshold"`
+
+	// PayloadCompression enables the negotiated payload compression extension. Publishes with the
+	// CompressionProperty user property set to snappy are decompressed on receipt, so hooks and
+	// other subscribers see the original payload, and messages are recompressed for subscribers
+	// which set the same property in their CONNECT packet or connect to a CompressionListener.
+	PayloadCompression bool `yaml:"payload_compression" json:"payload_compression"`
+
+	// CompressionProperty specifies the name of the user property marking compressed payloads
+	// and compression capable clients. Defaults to content-encoding.
+	CompressionProperty string `yaml:"compression_property" json:"compression_property"`
+
+	// CompressionListeners lists the ids of listeners on which all payloads are snappy compressed
+	// in both directions, for clients such as MQTT v3 devices which cannot use user properties.
+	CompressionListeners []string `yaml:"compression_listeners" json:"compression_listeners"`
+
+	// ConnectStormRate specifies the number of connection attempts per second at which the
+	// server considers a mass reconnect to be underway, such as after a broker restart, and
+	// begins shaping connections to smooth the load. Shaping continues until the rate has
+	// stayed below the threshold for ten seconds. Disabled if 0.
+	ConnectStormRate int64 `yaml:"connect_storm_rate" json:"connect_storm_rate"`
+
+	// ConnectStormJitter specifies the maximum random delay in milliseconds applied before
+	// sending a CONNACK while connections are being shaped, spreading out the subsequent
+	// subscribes and resent messages of reconnecting clients.
+	ConnectStormJitter int64 `yaml:"connect_storm_jitter" json:"connect_storm_jitter"`
+
+	// ConnectStormBackoff specifies the base interval in milliseconds between connections from
+	// the same ip while connections are being shaped. Connections arriving sooner are refused
+	// with a server busy CONNACK (server unavailable for MQTT v3), and the interval doubles with
+	// each refusal, up to 64 times the base. Throttling is disabled if 0.
+	ConnectStormBackoff int64 `yaml:"connect_storm_backoff" json:"connect_storm_backoff"`
+
+	// Mirrors specifies rules which duplicate messages published to topics matching a filter
+	// onto another topic, such as to mirror production telemetry into a staging namespace.
+	Mirrors []MirrorRule `yaml:"mirrors" json:"mirrors"`
+
+	// DeadLetterTopic specifies a topic to which messages are republished when they are dropped
+	// instead of being delivered to a client, because they expired, the pending writes or
+	// inflight quota of the client was exhausted, or they were evicted from its inflight store.
+	// User properties on each message give the drop reason, original topic, and client id.
+	// Disabled if empty.
+	DeadLetterTopic string `yaml:"dead_letter_topic" json:"dead_letter_topic"`
+
+	// Clock provides the time used for message, session, will, and ban timestamps and expiry,
+	// and for keepalive deadlines, which are enforced against the clock by Compact. Defaults
+	// to the system clock, and can be replaced to fast-forward time in tests.
+	Clock Clock `yaml:"-" json:"-"`
 }
 
 // Server is an MQTT broker server. It should be created with server.New()
@@ -138,12 +326,22 @@ type Server struct {
 	Listeners    *listeners.Listeners // listeners are network interfaces which listen for new connections
 	Clients      *Clients             // clients known to the broker
 	Topics       *TopicsIndex         // an index of topic filter subscriptions and retained messages
+	Bans         *Bans                // client ids and ip addresses which are prevented from connecting
 	Info         *system.Info         // values about the server commonly known as $SYS topics
+	TopicStats   *TopicStats          // message counters grouped by topic prefix
+	Latency      *DeliveryLatency     // delivery latency histograms grouped by listener and topic prefix
+	Traces       *Traces              // client ids for which packet tracing is enabled
+	LogLevels    *LogLevels           // log levels of server subsystems, adjustable at runtime
 	loop         *loop                // loop contains tickers for the system event loop
 	done         chan bool            // indicate that the server is ending
 	Log          *slog.Logger         // minimal no-alloc logger
 	hooks        *Hooks               // hooks contains hooks for extra functionality such as auth and persistent storage
 	inlineClient *Client              // inlineClient is a special client used for inline subscriptions and inline Publish
+	openConns    int64                // the number of open client network connections, atomic
+	fdLimit      int64                // the process open file descriptor limit, or 0 if unknown
+	storm        *connectStorm        // detects and shapes mass reconnect events
+	serving      uint32               // 1 if the listeners have been started by Serve, atomic
+	draining     uint32               // 1 if the server is draining and no longer accepting connections, atomic
 }
 
 // loop contains interval tickers for the system events loop.
@@ -152,16 +350,109 @@ type loop struct {
 	clientExpiry   *time.Ticker     // interval ticker for cleaning expired clients
 	inflightExpiry *time.Ticker     // interval ticker for cleaning up expired inflight messages
 	retainedExpiry *time.Ticker     // interval ticker for cleaning retained messages
+	banExpiry      *time.Ticker     // interval ticker for cleaning expired bans
+	compaction     *time.Ticker     // interval ticker for compacting stores, if enabled
 	willDelaySend  *time.Ticker     // interval ticker for sending Will Messages with a delay
 	willDelayed    *packets.Packets // activate LWT packets which will be sent after a delay
 }
 
+// ConnectionEvent is the JSON payload published when a client connects or disconnects
+// and connection events are enabled.
+type ConnectionEvent struct {
+	ClientID        string `json:"client_id"`          // the id of the client
+	State           string `json:"state"`              // either connected or disconnected
+	Username        string `json:"username,omitempty"` // the username the client connected with
+	Remote          string `json:"remote"`             // the remote address of the client
+	Listener        string `json:"listener"`           // the id of the listener the client connected to
+	ProtocolVersion byte   `json:"protocol_version"`   // the mqtt protocol version of the client
+	Clean           bool   `json:"clean"`              // the client requested a clean session
+	Time            int64  `json:"time"`               // the unix time the event occurred
+	SessionCreated  int64  `json:"session_created"`    // the unix time the session was created
+	Connected       int64  `json:"connected"`          // the unix time the client connected
+	Error           string `json:"error,omitempty"`    // the error which caused the client to disconnect, if any
+	Reason          string `json:"reason,omitempty"`   // the classified reason the client disconnected
+}
+
+// RuntimeStats contains go runtime statistics which are published to $SYS/broker/runtime.
+type RuntimeStats struct {
+	Goroutines     int     `json:"goroutines"`        // the number of active goroutines
+	HeapAlloc      uint64  `json:"heap_alloc"`        // bytes of allocated heap objects
+	HeapInuse      uint64  `json:"heap_inuse"`        // bytes in in-use heap spans
+	HeapSys        uint64  `json:"heap_sys"`          // bytes of heap memory obtained from the os
+	HeapObjects    uint64  `json:"heap_objects"`      // the number of allocated heap objects
+	NumGC          uint32  `json:"num_gc"`            // the number of completed gc cycles
+	GCPauseTotalNs uint64  `json:"gc_pause_total_ns"` // cumulative nanoseconds spent in gc stop-the-world pauses
+	LastGCPauseNs  uint64  `json:"last_gc_pause_ns"`  // nanoseconds spent in the most recent gc pause
+	GCCPUFraction  float64 `json:"gc_cpu_fraction"`   // fraction of available cpu time used by the gc
+	NextGC         uint64  `json:"next_gc"`           // the target heap size of the next gc cycle
+}
+
+// newRuntimeStats returns runtime statistics from a set of memory stats.
+func newRuntimeStats(m *runtime.MemStats) RuntimeStats {
+	rs := RuntimeStats{
+		Goroutines:     runtime.NumGoroutine(),
+		HeapAlloc:      m.HeapAlloc,
+		HeapInuse:      m.HeapInuse,
+		HeapSys:        m.HeapSys,
+		HeapObjects:    m.HeapObjects,
+		NumGC:          m.NumGC,
+		GCPauseTotalNs: m.PauseTotalNs,
+		GCCPUFraction:  m.GCCPUFraction,
+		NextGC:         m.NextGC,
+	}
+
+	if m.NumGC > 0 {
+		rs.LastGCPauseNs = m.PauseNs[(m.NumGC+255)%256]
+	}
+
+	return rs
+}
+
+// Stats contains a snapshot of the server statistics and per-topic message counters.
+type Stats struct {
+	Info      *system.Info         `json:"info"`                // values about the server commonly known as $SYS topics
+	Topics    map[string]TopicStat `json:"topics"`              // message counters keyed on topic prefix
+	Latency   []LatencyHistogram   `json:"latency,omitempty"`   // delivery latency histograms, if enabled
+	Keepalive []KeepaliveHealth    `json:"keepalive,omitempty"` // the keepalive health of connected clients, if enabled
+}
+
+// BrokerInfo describes the broker build, runtime, and configuration highlights
+// which are published to $SYS/broker/info for inventory purposes.
+type BrokerInfo struct {
+	Version              string `json:"version"`                // the current version of the server
+	GoVersion            string `json:"go_version"`             // the go runtime version the server was built with
+	OS                   string `json:"os"`                     // the operating system the server is running on
+	Arch                 string `json:"arch"`                   // the architecture the server is running on
+	Revision             string `json:"revision,omitempty"`     // the vcs revision of the build, if known
+	Started              int64  `json:"started"`                // the time the server started in unix seconds
+	Uptime               int64  `json:"uptime"`                 // the number of seconds the server has been online
+	Listeners            int    `json:"listeners"`              // the number of listeners attached to the server
+	MaximumClients       int64  `json:"maximum_clients"`        // maximum number of connected clients
+	MaximumPacketSize    uint32 `json:"maximum_packet_size"`    // maximum packet size, no limit if 0
+	MaximumQos           byte   `json:"maximum_qos"`            // maximum qos value available to clients
+	RetainAvailable      bool   `json:"retain_available"`       // support of retain messages
+	WildcardSubAvailable bool   `json:"wildcard_sub_available"` // support of wildcard subscriptions
+	SharedSubAvailable   bool   `json:"shared_sub_available"`   // support of shared subscriptions
+	InlineClient         bool   `json:"inline_client"`          // the inline client is enabled
+}
+
+// ReplayTarget indicates where replayed messages should be sent. If ClientID is set,
+// messages are delivered directly to that client. Otherwise they are re-published to
+// the subscribers of Topic, or of their original topic if Topic is empty.
+type ReplayTarget struct {
+	ClientID string // the id of a client to deliver messages to
+	Topic    string // a topic to re-publish messages to
+}
+
 // ops contains server values which can be propagated to other structs.
 type ops struct {
-	options *Options     // a pointer to the server options and capabilities, for referencing in clients
-	info    *system.Info // pointers to server system info
-	hooks   *Hooks       // pointer to the server hooks
-	log     *slog.Logger // a structured logger for the client
+	options   *Options         // a pointer to the server options and capabilities, for referencing in clients
+	info      *system.Info     // pointers to server system info
+	hooks     *Hooks           // pointer to the server hooks
+	log       *slog.Logger     // a structured logger for the client
+	traces    *Traces          // pointer to the server packet traces
+	latency   *DeliveryLatency // pointer to the server delivery latency histograms
+	violation violationFn      // applies the server violation policy to malformed packets
 }
 
 // New returns a new instance of mochi mqtt broker. Optional parameters
@@ -174,15 +465,21 @@ func New(opts *Options) *Server {
 	opts.ensureDefaults()
 
 	s := &Server{
-		done:      make(chan bool),
-		Clients:   NewClients(),
-		Topics:    NewTopicsIndex(),
-		Listeners: listeners.New(),
+		done:       make(chan bool),
+		Clients:    NewClients(),
+		Topics:     NewTopicsIndex(),
+		Bans:       NewBans(),
+		TopicStats: NewTopicStats(opts.TopicMetricsDepth),
+		Latency:    NewDeliveryLatency(opts.TopicMetricsDepth),
+		Traces:     NewTraces(),
+		LogLevels:  NewLogLevels(),
+		Listeners:  listeners.New(),
 		loop: &loop{
 			sysTopics:      time.NewTicker(time.Second * time.Duration(opts.SysTopicResendInterval)),
 			clientExpiry:   time.NewTicker(time.Second),
 			inflightExpiry: time.NewTicker(time.Second),
 			retainedExpiry: time.NewTicker(time.Second),
+			banExpiry:      time.NewTicker(time.Second),
 			willDelaySend:  time.NewTicker(time.Second),
 			willDelayed:    packets.NewPackets(),
 		},
@@ -195,6 +492,20 @@ func New(opts *Options) *Server {
 		hooks: &Hooks{
 			Log: opts.Logger,
 		},
+		fdLimit: descriptorLimit(),
+		storm:   newConnectStorm(),
+	}
+
+	s.Topics.SetSubscriberCacheSize(s.Options.SubscriberCacheSize)
+	s.Topics.onDecodeError = func(topic string, err error) {
+		s.Log.Error("failed to decode retained message", "error", err, "topic", topic)
+		reportError(s.hooks, nil, ErrorSubsystemDecode, fmt.Errorf("retained message %s: %w", topic, err))
+	}
+	s.TopicStats.SetLimit(s.Options.TopicMetricsLimit)
+	s.Latency.SetLimit(s.Options.TopicMetricsLimit)
+
+	if s.Options.CompactionInterval > 0 {
+		s.loop.compaction = time.NewTicker(time.Second * time.Duration(s.Options.CompactionInterval))
 	}
 
 	if s.Options.InlineClient {
@@ -229,6 +540,54 @@ func (o *Options) ensureDefaults() {
 		o.ClientNetReadBufferSize = 1024 * 2
 	}
 
+	if o.TopicMetricsDepth > 0 && o.TopicMetricsLimit == 0 {
+		o.TopicMetricsLimit = defaultTopicMetricsLimit
+	}
+
+	if o.ConnectionEvents && o.ConnectionEventsTopic == "" {
+		o.ConnectionEventsTopic = defaultConnectionEventsTopic
+	}
+
+	if o.TraceMessages && o.TraceProperty == "" {
+		o.TraceProperty = defaultTraceProperty
+	}
+
+	if o.PayloadCompression && o.CompressionProperty == "" {
+		o.CompressionProperty = defaultCompressionProperty
+	}
+
+	if o.BackpressureWatermark > 0 && o.BackpressureTimeout == 0 {
+		o.BackpressureTimeout = defaultBackpressureTimeout
+	}
+
+	if o.ViolationPolicy == "" {
+		o.ViolationPolicy = ViolationDisconnect
+	}
+
+	if o.ViolationLimit == 0 {
+		o.ViolationLimit = defaultViolationLimit
+	}
+
+	if o.InflightOverflow == "" {
+		o.InflightOverflow = InflightReject
+	}
+
+	if o.InflightResendInterval > 0 && o.InflightResendMaximum < o.InflightResendInterval {
+		o.InflightResendMaximum = max(defaultInflightResendMaximum, o.InflightResendInterval)
+	}
+
+	if o.ClientIDPolicy == "" {
+		o.ClientIDPolicy = ClientIDAny
+	}
+
+	if o.ClientIDMaxLength == 0 {
+		o.ClientIDMaxLength = defaultClientIDMaxLength
+	}
+
+	if o.Clock == nil {
+		o.Clock = systemClock{}
+	}
+
 	if o.Logger == nil {
 		log := slog.New(slog.NewTextHandler(os.Stdout, nil))
 		o.Logger = log
@@ -241,14 +600,22 @@ func (o *Options) ensureDefaults() {
 // topic validation checks.
 func (s *Server) NewClient(c net.Conn, listener string, id string, inline bool) *Client {
 	cl := newClient(c, &ops{ // [MQTT-3.1.2-6] implicit
-		options: s.Options,
-		info:    s.Info,
-		hooks:   s.hooks,
-		log:     s.Log,
+		options:   s.Options,
+		info:      s.Info,
+		hooks:     s.hooks,
+		log:       s.LogLevels.Logger(s.Log, LogClients),
+		traces:    s.Traces,
+		latency:   s.Latency,
+		violation: s.handleViolation,
 	})
 
 	cl.ID = id
 	cl.Net.Listener = listener
+	if conf, ok := s.listenerConfig(listener); ok {
+		cl.State.keepaliveGrace = conf.KeepaliveGrace
+		cl.State.readOnly = conf.ReadOnly
+		cl.State.writeOnly = conf.WriteOnly
+	}
 
 	if inline { // inline clients bypass acl and some validity checks.
 		cl.Net.Inline = true
@@ -263,15 +630,21 @@ func (s *Server) NewClient(c net.Conn, listener string, id string, inline bool)
 // AddHook attaches a new Hook to the server. Ideally, this should be called
 // before the server is started with s.Serve().
 func (s *Server) AddHook(hook Hook, config any) error {
-	nl := s.Log.With("hook", hook.ID())
+	nl := s.LogLevels.Logger(s.Log, LogHooks).With("hook", hook.ID())
 	hook.SetOpts(nl, &HookOptions{
 		Capabilities: s.Options.Capabilities,
+		ReportError:  s.reportError,
 	})
 
 	s.Log.Info("added hook", "hook", hook.ID())
 	return s.hooks.Add(hook, config)
 }
 
+// reportError passes a non-fatal internal error in a subsystem to the OnError hooks.
+func (s *Server) reportError(cl *Client, subsystem string, err error) {
+	reportError(s.hooks, cl, subsystem, err)
+}
+
 // AddHooksFromConfig adds hooks to the server which were specified in the hooks config (usually from a config file).
 // New built-in hooks should be added to this list.
 func (s *Server) AddHooksFromConfig(hooks []HookLoadConfig) error {
@@ -289,7 +662,7 @@ func (s *Server) AddListener(l listeners.Listener) error {
 		return ErrListenerIDExists
 	}
 
-	nl := s.Log.With(slog.String("listener", l.ID()))
+	nl := s.LogLevels.Logger(s.Log, LogListeners).With(slog.String("listener", l.ID()))
 	err := l.Init(nl)
 	if err != nil {
 		return err
@@ -301,6 +674,21 @@ func (s *Server) AddListener(l listeners.Listener) error {
 	return nil
 }
 
+// listenerConfig returns the configuration of a listener, if the listener exposes it.
+func (s *Server) listenerConfig(id string) (listeners.Config, bool) {
+	l, ok := s.Listeners.Get(id)
+	if !ok {
+		return listeners.Config{}, false
+	}
+
+	c, ok := l.(listeners.Configured)
+	if !ok {
+		return listeners.Config{}, false
+	}
+
+	return c.Config(), true
+}
+
 // AddListenersFromConfig adds listeners to the server which were specified in the listeners config (usually from a config file).
 // New built-in listeners should be added to this list.
 func (s *Server) AddListenersFromConfig(configs []listeners.Config) error {
@@ -317,6 +705,10 @@ func (s *Server) AddListenersFromConfig(configs []listeners.Config) error {
 			l = listeners.NewHTTPHealthCheck(conf)
 		case listeners.TypeSysInfo:
 			l = listeners.NewHTTPStats(conf, s.Info)
+		case listeners.TypePprof:
+			l = listeners.NewHTTPPprof(conf)
+		case listeners.TypeACME:
+			l = listeners.NewHTTPACMEChallenge(conf)
 		case listeners.TypeMock:
 			l = listeners.NewMockListener(conf.ID, conf.Address)
 		default:
@@ -356,6 +748,7 @@ func (s *Server) Serve() error {
 		StoredRetainedMessages,
 		StoredSubscriptions,
 		StoredSysInfo,
+		StoredBans,
 	) {
 		err := s.readStore()
 		if err != nil {
@@ -363,6 +756,7 @@ func (s *Server) Serve() error {
 		}
 	}
 
+	atomic.StoreUint32(&s.serving, 1)
 	go s.eventLoop()                            // spin up event loop for issuing $SYS values and closing server.
 	s.Listeners.ServeAll(s.EstablishConnection) // start listening on all listeners.
 	s.publishSysTopics()                        // begin publishing $SYS system values.
@@ -371,11 +765,34 @@ func (s *Server) Serve() error {
 	return nil
 }
 
+// Run starts the server and blocks until ctx is cancelled, at which point the server
+// is gracefully closed. Any error starting the server is returned. If the server is
+// closed by other means, Run returns without closing it again.
+func (s *Server) Run(ctx context.Context) error {
+	if err := s.Serve(); err != nil {
+		return err
+	}
+
+	select {
+	case <-ctx.Done():
+		s.Log.Info("context cancelled", "error", context.Cause(ctx))
+		return s.Close()
+	case <-s.done:
+		return nil
+	}
+}
+
 // eventLoop loops forever, running various server housekeeping methods at different intervals.
 func (s *Server) eventLoop() {
 	s.Log.Debug("system event loop started")
 	defer s.Log.Debug("system event loop halted")
 
+	var compaction <-chan time.Time // nil blocks indefinitely if compaction is not scheduled
+	if s.loop.compaction != nil {
+		compaction = s.loop.compaction.C
+		defer s.loop.compaction.Stop()
+	}
+
 	for {
 		select {
 		case <-s.done:
@@ -384,13 +801,19 @@ func (s *Server) eventLoop() {
 		case <-s.loop.sysTopics.C:
 			s.publishSysTopics()
 		case <-s.loop.clientExpiry.C:
-			s.clearExpiredClients(time.Now().Unix())
+			s.clearExpiredClients(s.Options.now().Unix())
 		case <-s.loop.retainedExpiry.C:
-			s.clearExpiredRetainedMessages(time.Now().Unix())
+			s.clearExpiredRetainedMessages(s.Options.now().Unix())
+		case <-s.loop.banExpiry.C:
+			s.clearExpiredBans(s.Options.now().Unix())
 		case <-s.loop.willDelaySend.C:
-			s.sendDelayedLWT(time.Now().Unix())
+			s.sendDelayedLWT(s.Options.now().Unix())
 		case <-s.loop.inflightExpiry.C:
-			s.clearExpiredInflights(time.Now().Unix())
+			now := s.Options.now().Unix()
+			s.clearExpiredInflights(now)
+			s.resendInflights(now)
+		case <-compaction:
+			_ = s.Compact()
 		}
 	}
 }
@@ -403,12 +826,16 @@ func (s *Server) EstablishConnection(listener string, c net.Conn) error {
 
 // attachClient validates an incoming client connection and if viable, attaches the client
 // to the server, performs session housekeeping, and reads incoming packets.
-func (s *Server) attachClient(cl *Client, listener string) error {
+func (s *Server) attachClient(cl *Client, listener string) (err error) {
 	defer s.Listeners.ClientsWg.Done()
 	s.Listeners.ClientsWg.Add(1)
 
+	atomic.AddInt64(&s.openConns, 1)
+	defer atomic.AddInt64(&s.openConns, -1)
+
 	go cl.WriteLoop()
 	defer cl.Stop(nil)
+	defer cl.recoverPanic(&err)
 
 	pk, err := s.readConnectionPacket(cl)
 	if err != nil {
@@ -416,6 +843,29 @@ func (s *Server) attachClient(cl *Client, listener string) error {
 	}
 
 	cl.ParseConnect(listener, pk)
+	if _, ok := s.Bans.Match(cl.ID, remoteIP(cl.Net.Remote), s.Options.now().Unix()); ok {
+		if err := s.SendConnack(cl, packets.ErrBanned, false, nil); err != nil {
+			return fmt.Errorf("banned connection send ack: %w", err)
+		}
+		return packets.ErrBanned
+	}
+
+	shaping := false
+	if s.Options.ConnectStormRate > 0 {
+		var allowed bool
+		shaping, allowed = s.storm.attempt(remoteIP(cl.Net.Remote), s.Options.now(), s.Options.ConnectStormRate, time.Duration(s.Options.ConnectStormBackoff)*time.Millisecond)
+		if !allowed {
+			code := packets.ErrServerBusy
+			if cl.Properties.ProtocolVersion < 5 {
+				code = packets.ErrServerUnavailable
+			}
+			if err := s.SendConnack(cl, code, false, nil); err != nil {
+				return fmt.Errorf("connect storm send ack: %w", err)
+			}
+			return code
+		}
+	}
+
 	if atomic.LoadInt64(&s.Info.ClientsConnected) >= s.Options.Capabilities.MaximumClients {
 		if cl.Properties.ProtocolVersion < 5 {
 			s.SendConnack(cl, packets.ErrServerUnavailable, false, nil)
@@ -426,6 +876,14 @@ func (s *Server) attachClient(cl *Client, listener string) error {
 		return packets.ErrServerBusy
 	}
 
+	if s.descriptorsExhausted() {
+		cl.ops.log.Warn("refusing connection, file descriptor headroom reached", "client", cl.ID, "listener", listener, "limit", s.fdLimit)
+		if err := s.SendConnack(cl, packets.ErrServerUnavailable, false, nil); err != nil {
+			return fmt.Errorf("descriptor headroom send ack: %w", err)
+		}
+		return packets.ErrServerUnavailable
+	}
+
 	code := s.validateConnect(cl, pk) // [MQTT-3.1.4-1] [MQTT-3.1.4-2]
 	if code != packets.CodeSuccess {
 		if err := s.SendConnack(cl, code, false, nil); err != nil {
@@ -436,6 +894,12 @@ func (s *Server) attachClient(cl *Client, listener string) error {
 
 	err = s.hooks.OnConnect(cl, pk)
 	if err != nil {
+		var code packets.Code
+		if errors.As(err, &code) && code.Code >= packets.ErrUnspecifiedError.Code {
+			if err := s.SendConnack(cl, code, false, nil); err != nil {
+				return fmt.Errorf("on connect send ack: %w", err)
+			}
+		}
 		return err
 	}
 
@@ -449,12 +913,44 @@ func (s *Server) attachClient(cl *Client, listener string) error {
 		return packets.ErrBadUsernameOrPassword
 	}
 
+	if code := s.enforceUsernameConnections(cl); code != packets.CodeSuccess {
+		if err := s.SendConnack(cl, code, false, nil); err != nil {
+			return fmt.Errorf("username connections send ack: %w", err)
+		}
+		return code
+	}
+
+	err = s.negotiateConnect(cl, pk)
+	if err != nil {
+		var code packets.Code
+		if errors.As(err, &code) && code.Code >= packets.ErrUnspecifiedError.Code {
+			if err := s.SendConnack(cl, code, false, nil); err != nil {
+				return fmt.Errorf("negotiate connect send ack: %w", err)
+			}
+		}
+		return err
+	}
+
+	if shaping {
+		if d := s.storm.jitter(time.Duration(s.Options.ConnectStormJitter) * time.Millisecond); d > 0 {
+			select {
+			case <-time.After(d):
+			case <-s.done:
+			}
+		}
+	}
+
 	atomic.AddInt64(&s.Info.ClientsConnected, 1)
 	defer atomic.AddInt64(&s.Info.ClientsConnected, -1)
 
 	s.hooks.OnSessionEstablish(cl, pk)
 
 	sessionPresent := s.inheritClientSession(pk, cl)
+	now := s.Options.now()
+	atomic.StoreInt64(&cl.State.connected, now.UnixNano())
+	if atomic.LoadInt64(&cl.State.created) == 0 {
+		atomic.StoreInt64(&cl.State.created, now.Unix())
+	}
 	s.Clients.Add(cl) // [MQTT-4.1.0-1]
 
 	err = s.SendConnack(cl, code, sessionPresent, nil) // [MQTT-3.1.4-5] [MQTT-3.2.0-1] [MQTT-3.2.0-2] &[MQTT-3.14.0-1]
@@ -472,6 +968,7 @@ func (s *Server) attachClient(cl *Client, listener string) error {
 	}
 
 	s.hooks.OnSessionEstablished(cl, pk)
+	s.publishConnectionEvent(cl, ConnectionStateConnected, nil, "")
 
 	err = cl.Read(s.receivePacket)
 	if err != nil {
@@ -480,11 +977,20 @@ func (s *Server) attachClient(cl *Client, listener string) error {
 	} else {
 		cl.Properties.Will = Will{} // [MQTT-3.14.4-3] [MQTT-3.1.2-10]
 	}
-	s.Log.Debug("client disconnected", "error", err, "client", cl.ID, "remote", cl.Net.Remote, "listener", listener)
+	cl.ops.log.Debug("client disconnected", "error", err, "client", cl.ID, "remote", cl.Net.Remote, "listener", listener)
 
 	expire := (cl.Properties.ProtocolVersion == 5 && cl.Properties.Props.SessionExpiryInterval == 0) || (cl.Properties.ProtocolVersion < 5 && cl.Properties.Clean)
+	expire = expire || atomic.LoadUint32(&cl.State.forgotten) == 1
 	s.hooks.OnDisconnect(cl, err, expire)
 
+	ev := classifyDisconnect(cl, err)
+	ev.Expire = expire
+	s.hooks.OnDisconnected(cl, ev)
+	if ev.Reason != DisconnectTakeover && atomic.LoadUint32(&cl.State.isTakenOver) == 0 {
+		// the session continues on the new connection, which has published its own event.
+		s.publishConnectionEvent(cl, ConnectionStateDisconnected, ev.Err, ev.Reason)
+	}
+
 	if expire && atomic.LoadUint32(&cl.State.isTakenOver) == 0 {
 		cl.ClearInflights()
 		s.UnsubscribeClient(cl)
@@ -494,6 +1000,63 @@ func (s *Server) attachClient(cl *Client, listener string) error {
 	return err
 }
 
+// publishConnectionEvent publishes a retained connection event for a client, if
+// connection events are enabled.
+func (s *Server) publishConnectionEvent(cl *Client, state string, err error, reason DisconnectReason) {
+	if !s.Options.ConnectionEvents {
+		return
+	}
+
+	ev := ConnectionEvent{
+		ClientID:        cl.ID,
+		State:           state,
+		Username:        string(cl.Properties.Username),
+		Remote:          cl.Net.Remote,
+		Listener:        cl.Net.Listener,
+		ProtocolVersion: cl.Properties.ProtocolVersion,
+		Clean:           cl.Properties.Clean,
+		Time:            s.Options.now().Unix(),
+		SessionCreated:  atomic.LoadInt64(&cl.State.created),
+		Connected:       atomic.LoadInt64(&cl.State.connected) / int64(time.Second),
+		Reason:          string(reason),
+	}
+
+	if err != nil {
+		ev.Error = err.Error()
+	}
+
+	b, err := json.Marshal(ev)
+	if err != nil {
+		cl.ops.log.Error("failed to encode connection event", "error", err, "client", cl.ID)
+		return
+	}
+
+	pk := packets.Packet{
+		FixedHeader: packets.FixedHeader{
+			Type:   packets.Publish,
+			Retain: true,
+		},
+		TopicName: strings.ReplaceAll(s.Options.ConnectionEventsTopic, "{clientid}", cl.ID),
+		Payload:   b,
+		Created:   ev.Time,
+	}
+
+	s.Topics.RetainMessage(pk.Copy(false))
+	s.publishToSubscribers(pk)
+}
+
+// clearConnectionEvent removes the retained connection event of a client whose session
+// has expired, so that events do not accumulate for clients which never return.
+func (s *Server) clearConnectionEvent(cl *Client) {
+	if !s.Options.ConnectionEvents {
+		return
+	}
+
+	s.Topics.RetainMessage(packets.Packet{ // an empty payload removes the retained message
+		TopicName: strings.ReplaceAll(s.Options.ConnectionEventsTopic, "{clientid}", cl.ID),
+	})
+}
+
 // readConnectionPacket reads the first incoming header for a connection, and if
 // acceptable, returns the valid connection packet.
 func (s *Server) readConnectionPacket(cl *Client) (pk packets.Packet, err error) {
@@ -520,13 +1083,17 @@ func (s *Server) readConnectionPacket(cl *Client) (pk packets.Packet, err error)
 func (s *Server) receivePacket(cl *Client, pk packets.Packet) error {
 	err := s.processPacket(cl, pk)
 	if err != nil {
+		if IsViolation(err) {
+			return s.handleViolation(cl, pk, err)
+		}
+
 		if code, ok := err.(packets.Code); ok &&
 			cl.Properties.ProtocolVersion == 5 &&
 			code.Code >= packets.ErrUnspecifiedError.Code {
 			_ = s.DisconnectClient(cl, code)
 		}
 
-		s.Log.Warn("error processing packet", "error", err, "client", cl.ID, "listener", cl.Net.Listener, "pk", pk)
+		cl.ops.log.Warn("error processing packet", "error", err, "client", cl.ID, "listener", cl.Net.Listener, "pk", pk)
 
 		return err
 	}
@@ -534,6 +1101,57 @@ func (s *Server) receivePacket(cl *Client, pk packets.Packet) error {
 	return nil
 }
 
+// enforceUsernameConnections applies the per-username connection limit to a newly
+// authenticated client. If the username is at the limit, either the oldest connections
+// of the username are disconnected, or a failure code is returned to refuse the new client.
+// Reconnections which take over the session of the same client id are not counted.
+func (s *Server) enforceUsernameConnections(cl *Client) packets.Code {
+	max := s.Options.Capabilities.MaximumUsernameConnections
+	if max <= 0 || len(cl.Properties.Username) == 0 {
+		return packets.CodeSuccess
+	}
+
+	existing := s.Clients.GetByUsername(string(cl.Properties.Username))
+	for i := len(existing) - 1; i >= 0; i-- {
+		if existing[i].ID == cl.ID {
+			existing = append(existing[:i], existing[i+1:]...)
+		}
+	}
+
+	if int64(len(existing)) < max {
+		return packets.CodeSuccess
+	}
+
+	if !s.Options.Capabilities.EvictOldestUsernameConnection {
+		cl.ops.log.Warn("refusing connection, username connection limit reached", "client", cl.ID, "username", string(cl.Properties.Username), "limit", max)
+		if cl.Properties.ProtocolVersion < 5 {
+			return packets.ErrServerUnavailable
+		}
+		return packets.ErrQuotaExceeded
+	}
+
+	sort.Slice(existing, func(i, j int) bool {
+		return atomic.LoadInt64(&existing[i].State.connected) < atomic.LoadInt64(&existing[j].State.connected)
+	})
+
+	for _, old := range existing[:int64(len(existing))-max+1] {
+		old.ops.log.Warn("evicting oldest connection, username connection limit reached", "client", old.ID, "username", string(cl.Properties.Username), "limit", max)
+		_ = s.DisconnectClient(old, packets.ErrQuotaExceeded)
+	}
+
+	return packets.CodeSuccess
+}
+
+// descriptorsExhausted returns true if the open client connections have reached the
+// process file descriptor limit less the configured headroom.
+func (s *Server) descriptorsExhausted() bool {
+	if s.Options.DescriptorHeadroom <= 0 || s.fdLimit <= 0 {
+		return false
+	}
+
+	return atomic.LoadInt64(&s.openConns) > s.fdLimit-s.Options.DescriptorHeadroom
+}
+
 // validateConnect validates that a connect packet is compliant.
 func (s *Server) validateConnect(cl *Client, pk packets.Packet) packets.Code {
 	code := pk.ConnectValidate() // [MQTT-3.1.4-1] [MQTT-3.1.4-2]
@@ -545,6 +1163,10 @@ func (s *Server) validateConnect(cl *Client, pk packets.Packet) packets.Code {
 		return packets.ErrUnspecifiedError
 	}
 
+	if code := s.validateClientID(pk.Connect.ClientIdentifier); code != packets.CodeSuccess {
+		return code // [MQTT-3.1.3-9]
+	}
+
 	if cl.Properties.ProtocolVersion < s.Options.Capabilities.MinimumProtocolVersion {
 		return packets.ErrUnsupportedProtocolVersion // [MQTT-3.1.2-2]
 	} else if cl.Properties.Will.Qos > s.Options.Capabilities.MaximumQos {
@@ -561,6 +1183,7 @@ func (s *Server) validateConnect(cl *Client, pk packets.Packet) packets.Code {
 // session is abandoned.
 func (s *Server) inheritClientSession(pk packets.Packet, cl *Client) bool {
 	if existing, ok := s.Clients.Get(pk.Connect.ClientIdentifier); ok {
+		offline := existing.Closed()
 		_ = s.DisconnectClient(existing, packets.ErrSessionTakenOver)                                   // [MQTT-3.1.4-3]
 		if pk.Connect.Clean || (existing.Properties.Clean && existing.Properties.ProtocolVersion < 5) { // [MQTT-3.1.2-4] [MQTT-3.1.4-4]
 			s.UnsubscribeClient(existing)
@@ -570,12 +1193,22 @@ func (s *Server) inheritClientSession(pk packets.Packet, cl *Client) bool {
 		}
 
 		atomic.StoreUint32(&existing.State.isTakenOver, 1)
+		atomic.StoreInt64(&cl.State.created, atomic.LoadInt64(&existing.State.created))
+		cl.State.lastDisconnect = atomic.LoadInt64(&existing.State.disconnected)
+		if err := existing.StopCause(); err != nil {
+			cl.State.lastCause = err.Error()
+		}
+
 		if existing.State.Inflight.Len() > 0 {
 			cl.State.Inflight = existing.State.Inflight.Clone() // [MQTT-3.1.2-5]
 			if cl.State.Inflight.maximumReceiveQuota == 0 && cl.ops.options.Capabilities.ReceiveMaximum != 0 {
 				cl.State.Inflight.ResetReceiveQuota(int32(cl.ops.options.Capabilities.ReceiveMaximum)) // server receive max per client
 				cl.State.Inflight.ResetSendQuota(int32(cl.Properties.Props.ReceiveMaximum))            // client receive max
 			}
+
+			if offline && s.Options.Capabilities.MaximumOfflineMessageAge > 0 {
+				cl.ClearExpiredInflights(s.Options.now().Unix(), s.maximumInflightAge(true)) // discard stale messages before they are resent
+			}
 		}
 
 		for _, sub := range existing.State.Subscriptions.GetAll() {
@@ -591,7 +1224,7 @@ func (s *Server) inheritClientSession(pk packets.Packet, cl *Client) bool {
 		s.UnsubscribeClient(existing)
 		existing.ClearInflights()
 
-		s.Log.Debug("session taken over", "client", cl.ID, "old_remote", existing.Net.Remote, "new_remote", cl.Net.Remote)
+		cl.ops.log.Debug("session taken over", "client", cl.ID, "old_remote", existing.Net.Remote, "new_remote", cl.Net.Remote)
 
 		return true // [MQTT-3.2.2-3]
 	}
@@ -636,8 +1269,8 @@ func (s *Server) SendConnack(cl *Client, reason packets.Code, present bool, prop
 		return cl.WritePacket(ack)
 	}
 
-	if s.Options.Capabilities.MaximumQos < 2 {
-		properties.MaximumQos = s.Options.Capabilities.MaximumQos // [MQTT-3.2.2-9]
+	if maxQos := s.maximumQos(cl); maxQos < 2 {
+		properties.MaximumQos = maxQos // [MQTT-3.2.2-9]
 		properties.MaximumQosFlag = true
 	}
 
@@ -708,7 +1341,7 @@ func (s *Server) processPacket(cl *Client, pk packets.Packet) error {
 		}
 		err = s.processAuth(cl, pk)
 	default:
-		return fmt.Errorf("no valid packet available; %v", pk.FixedHeader.Type)
+		return fmt.Errorf("%w: %v", packets.ErrNoValidPacketAvailable, pk.FixedHeader.Type)
 	}
 
 	s.hooks.OnPacketProcessed(cl, pk, err)
@@ -739,6 +1372,10 @@ func (s *Server) processConnect(cl *Client, _ packets.Packet) error {
 
 // processPingreq processes a Pingreq packet.
 func (s *Server) processPingreq(cl *Client, _ packets.Packet) error {
+	if s.Options.KeepaliveMetrics {
+		cl.State.pings.record(s.Options.now(), cl.State.Keepalive)
+	}
+
 	return cl.WritePacket(packets.Packet{
 		FixedHeader: packets.FixedHeader{
 			Type: packets.Pingresp, // [MQTT-3.12.4-1]
@@ -767,6 +1404,59 @@ func (s *Server) Publish(topic string, payload []byte, retain bool, qos byte) er
 	})
 }
 
+// PublishReport describes which clients a message published with PublishWithReport was routed to.
+type PublishReport struct {
+	Topic     string            `json:"topic"`              // the topic the message was published to
+	Matched   []string          `json:"matched"`            // ids of clients with subscriptions matching the topic
+	Delivered []string          `json:"delivered"`          // ids of matched clients the message was written or queued to
+	Skipped   map[string]string `json:"skipped"`            // ids of matched clients the message was not sent to, and why
+	Inline    int               `json:"inline"`             // the number of inline subscriptions the message was sent to
+	Rejected  string            `json:"rejected,omitempty"` // the reason the message was rejected by a hook, if any
+}
+
+// PublishWithReport publishes a message from the inline client through the same path as Publish,
+// and reports which clients matched the topic and which the message was written to, so that
+// routing and subscriber ACLs can be verified. Shared subscriptions report only the
+// selected member of each group.
+func (s *Server) PublishWithReport(topic string, payload []byte, retain bool, qos byte) (PublishReport, error) {
+	report := PublishReport{
+		Topic:   topic,
+		Skipped: map[string]string{},
+	}
+
+	if !s.Options.InlineClient {
+		return report, ErrInlineClientNotEnabled
+	}
+
+	cl := s.inlineClient
+	pk := packets.Packet{
+		FixedHeader: packets.FixedHeader{
+			Type:   packets.Publish,
+			Qos:    qos,
+			Retain: retain,
+		},
+		TopicName:       topic,
+		Payload:         payload,
+		PacketID:        uint16(qos), // as with Publish, the inbound qos flow is never processed.
+		ProtocolVersion: cl.Properties.ProtocolVersion,
+	}
+
+	if code := pk.PublishValidate(s.Options.Capabilities.TopicAliasMaximum); code != packets.CodeSuccess {
+		return report, code
+	}
+
+	err := s.processPublishReport(cl, pk, &report)
+	s.hooks.OnPacketProcessed(cl, pk, err)
+	if err != nil {
+		return report, err
+	}
+
+	atomic.AddInt64(&s.Info.PacketsReceived, 1)
+	atomic.AddInt64(&s.Info.MessagesReceived, 1)
+
+	return report, nil
+}
+
 // Subscribe adds an inline subscription for the specified topic filter and subscription identifier
 // with the provided handler function.
 func (s *Server) Subscribe(filter string, subscriptionId int, handler InlineSubFn) error {
@@ -856,7 +1546,14 @@ func (s *Server) InjectPacket(cl *Client, pk packets.Packet) error {
 
 // processPublish processes a Publish packet.
 func (s *Server) processPublish(cl *Client, pk packets.Packet) error {
-	if !cl.Net.Inline && !IsValidFilter(pk.TopicName, true) {
+	return s.processPublishReport(cl, pk, nil)
+}
+
+// processPublishReport processes a Publish packet, recording where the message was routed
+// in the report if it is not nil.
+func (s *Server) processPublishReport(cl *Client, pk packets.Packet, report *PublishReport) error {
+	reserved := !cl.Net.Inline && s.isReservedTopic(pk.TopicName)
+	if !reserved && !cl.Net.Inline && !IsValidFilter(pk.TopicName, true) {
 		return nil
 	}
 
@@ -864,7 +1561,15 @@ func (s *Server) processPublish(cl *Client, pk packets.Packet) error {
 		return s.DisconnectClient(cl, packets.ErrReceiveMaximum) // ~[MQTT-3.3.4-7] ~[MQTT-3.3.4-8]
 	}
 
-	if !cl.Net.Inline && !s.hooks.OnACLCheck(cl, pk.TopicName, true) {
+	if reserved {
+		cl.ops.log.Warn("client attempted to publish to reserved topic", "client", cl.ID, "topic", pk.TopicName, "listener", cl.Net.Listener)
+	}
+
+	if cl.State.readOnly {
+		cl.ops.log.Debug("client attempted to publish on read-only listener", "client", cl.ID, "topic", pk.TopicName, "listener", cl.Net.Listener)
+	}
+
+	if reserved || cl.State.readOnly || (!cl.Net.Inline && !s.hooks.OnACLCheck(cl, pk.TopicName, true)) {
 		if pk.FixedHeader.Qos == 0 {
 			return nil
 		}
@@ -883,11 +1588,27 @@ func (s *Server) processPublish(cl *Client, pk packets.Packet) error {
 	}
 
 	pk.Origin = cl.ID
-	pk.Created = time.Now().Unix()
+	pk.Created = s.Options.now().Unix()
+
+	if s.Options.TraceMessages {
+		s.traceMessage(&pk)
+	}
+
+	if s.Options.DeliveryLatency {
+		pk.Received = time.Now().UnixNano()
+	}
 
 	if !cl.Net.Inline {
 		if pki, ok := cl.State.Inflight.Get(pk.PacketID); ok {
-			if pki.FixedHeader.Type == packets.Pubrec { // [MQTT-4.3.3-10]
+			if pki.FixedHeader.Type == packets.Pubrec && pk.FixedHeader.Qos == 2 && pk.FixedHeader.Dup { // [MQTT-3.3.1-1] [MQTT-4.3.3-10]
+				// the message was received but not yet released, such as before the client reconnected,
+				// so the retransmission is acknowledged again without delivering it to subscribers twice.
+				// A new publish which reuses the packet id without the dup flag is rejected below.
+				cl.ops.log.Debug("duplicate qos 2 publish", "client", cl.ID, "listener", cl.Net.Listener, "packet_id", pk.PacketID)
+				return cl.WritePacket(s.buildAck(pk.PacketID, packets.Pubrec, 0, pk.Properties, packets.CodeSuccess))
+			}
+
+			if pki.FixedHeader.Type == packets.Pubrec {
 				ack := s.buildAck(pk.PacketID, packets.Pubrec, 0, pk.Properties, packets.ErrPacketIdentifierInUse)
 				return cl.WritePacket(ack)
 			}
@@ -901,23 +1622,70 @@ func (s *Server) processPublish(cl *Client, pk packets.Packet) error {
 		pk.TopicName = cl.State.TopicAliases.Inbound.Set(pk.Properties.TopicAlias, pk.TopicName)
 	}
 
-	if pk.FixedHeader.Qos > s.Options.Capabilities.MaximumQos {
-		pk.FixedHeader.Qos = s.Options.Capabilities.MaximumQos // [MQTT-3.2.2-9] Reduce qos based on server max qos capability
+	if maxQos := s.maximumQos(cl); pk.FixedHeader.Qos > maxQos {
+		pk.FixedHeader.Qos = maxQos // [MQTT-3.2.2-9] Reduce qos based on server max qos capability
+	}
+
+	if s.Options.PayloadCompression && !cl.Net.Inline {
+		if err := s.decompressPublish(cl, &pk); err != nil {
+			cl.ops.log.Warn("failed to decompress publish", "error", err, "client", cl.ID, "listener", cl.Net.Listener, "topic", pk.TopicName)
+			if pk.FixedHeader.Qos == 0 || cl.Properties.ProtocolVersion != 5 {
+				return nil
+			}
+
+			ackType := packets.Puback
+			if pk.FixedHeader.Qos == 2 {
+				ackType = packets.Pubrec
+			}
+
+			code := packets.ErrPayloadFormatInvalid
+			if errors.Is(err, packets.ErrPacketTooLarge) {
+				code = packets.ErrPacketTooLarge
+			}
+
+			return cl.WritePacket(s.buildAck(pk.PacketID, ackType, 0, pk.Properties, code))
+		}
 	}
 
 	pkx, err := s.hooks.OnPublish(cl, pk)
 	if err == nil {
 		pk = pkx
 	} else if errors.Is(err, packets.ErrRejectPacket) {
+		if report != nil {
+			report.Rejected = err.Error()
+		}
 		return nil
 	} else if errors.Is(err, packets.CodeSuccessIgnore) {
 		pk.Ignore = true
 	} else if cl.Properties.ProtocolVersion == 5 && pk.FixedHeader.Qos > 0 && errors.As(err, new(packets.Code)) {
-		err = cl.WritePacket(s.buildAck(pk.PacketID, packets.Puback, 0, pk.Properties, err.(packets.Code)))
+		if report != nil {
+			report.Rejected = err.Error()
+		}
+
+		ackType := packets.Puback
+		if pk.FixedHeader.Qos == 2 {
+			ackType = packets.Pubrec // [MQTT-4.3.3-8] a rejected qos 2 publish is acknowledged with a failing pubrec
+		}
+
+		err = cl.WritePacket(s.buildAck(pk.PacketID, ackType, 0, pk.Properties, err.(packets.Code)))
 		if err != nil {
 			return err
 		}
 		return nil
+	} else if code := new(packets.Code); errors.As(err, code) && code.Code >= packets.ErrUnspecifiedError.Code {
+		// v3 clients and qos 0 publishers cannot be told the publish failed, so it is dropped.
+		if report != nil {
+			report.Rejected = err.Error()
+		}
+		return nil
+	}
+
+	var subscribers *Subscribers
+	if !pk.Ignore {
+		subscribers = s.selectSubscribers(pk)
+		if pk.FixedHeader.Qos > 0 {
+			s.applyBackpressure(cl, pk, subscribers)
+		}
 	}
 
 	if pk.FixedHeader.Retain { // [MQTT-3.3.1-5] ![MQTT-3.3.1-8]
@@ -928,7 +1696,8 @@ func (s *Server) processPublish(cl *Client, pk packets.Packet) error {
 	// When it publishes a package with a qos > 0, the server treats
 	// the package as qos=0, and the client receives it as qos=1 or 2.
 	if pk.FixedHeader.Qos == 0 || cl.Net.Inline {
-		s.publishToSubscribers(pk)
+		s.publishToSubscriberSet(pk, subscribers, report)
+		s.mirrorMessage(cl, pk)
 		s.hooks.OnPublished(cl, pk)
 		return nil
 	}
@@ -937,6 +1706,9 @@ func (s *Server) processPublish(cl *Client, pk packets.Packet) error {
 	ack := s.buildAck(pk.PacketID, packets.Puback, 0, pk.Properties, packets.QosCodes[pk.FixedHeader.Qos]) // [MQTT-4.3.2-4]
 	if pk.FixedHeader.Qos == 2 {
 		ack = s.buildAck(pk.PacketID, packets.Pubrec, 0, pk.Properties, packets.CodeSuccess) // [MQTT-3.3.4-1] [MQTT-4.3.3-8]
+
+		// the pubrec is kept until the message is released, and restored to the client from storage.
+		ack.Origin = cl.ID
 	}
 
 	if ok := cl.State.Inflight.Set(ack); ok {
@@ -957,12 +1729,103 @@ func (s *Server) processPublish(cl *Client, pk packets.Packet) error {
 		s.hooks.OnQosComplete(cl, ack)
 	}
 
-	s.publishToSubscribers(pk)
+	s.publishToSubscriberSet(pk, subscribers, report)
+	s.mirrorMessage(cl, pk)
 	s.hooks.OnPublished(cl, pk)
 
 	return nil
 }
 
+// applyBackpressure holds a qos publish from a client while any of its subscribers has at
+// least BackpressureWatermark pending writes, so that fast publishers are slowed to the pace
+// of their subscribers rather than having messages dropped. Publishes are held until the
+// subscribers drain or BackpressureTimeout elapses on the server clock, and never longer than
+// BackpressureTimeout in real time.
+func (s *Server) applyBackpressure(cl *Client, pk packets.Packet, subscribers *Subscribers) {
+	if s.Options.BackpressureWatermark <= 0 || cl.Net.Inline {
+		return
+	}
+
+	var saturated []*Client
+	for id := range subscribers.Subscriptions {
+		if sub, ok := s.Clients.Get(id); ok && sub.ID != cl.ID {
+			saturated = append(saturated, sub)
+		}
+	}
+
+	timeout := time.Millisecond * time.Duration(s.Options.BackpressureTimeout)
+	deadline := s.Options.now().Add(timeout)
+	var timer *time.Timer
+	for {
+		saturated = s.saturatedClients(saturated)
+		if len(saturated) == 0 {
+			break
+		}
+
+		if timer == nil {
+			timer = time.NewTimer(timeout)
+			defer timer.Stop()
+			atomic.AddInt64(&s.Info.MessagesThrottled, 1)
+			cl.ops.log.Debug("holding publish for saturated subscribers", "client", cl.ID, "listener", cl.Net.Listener, "topic", pk.TopicName, "subscribers", len(saturated))
+		} else if !s.Options.now().Before(deadline) {
+			cl.ops.log.Warn("backpressure timeout elapsed", "client", cl.ID, "listener", cl.Net.Listener, "topic", pk.TopicName, "subscribers", len(saturated))
+			return
+		}
+
+		// waiting on any one saturated subscriber is enough, as all are checked again on release.
+		sub := saturated[0]
+		drained := sub.drainedSignal()
+		if atomic.LoadInt32(&sub.State.outboundQty) < s.Options.BackpressureWatermark {
+			continue // drained before the signal was taken
+		}
+
+		select {
+		case <-drained:
+		case <-sub.Done():
+		case <-cl.Done():
+			return
+		case <-s.done:
+			return
+		case <-timer.C:
+			cl.ops.log.Warn("backpressure timeout elapsed", "client", cl.ID, "listener", cl.Net.Listener, "topic", pk.TopicName, "subscribers", len(saturated))
+			return
+		}
+	}
+}
+
+// saturatedClients returns the clients which are connected and have at least
+// BackpressureWatermark pending writes.
+func (s *Server) saturatedClients(clients []*Client) []*Client {
+	saturated := clients[:0]
+	for _, cl := range clients {
+		if !cl.Closed() && atomic.LoadInt32(&cl.State.outboundQty) >= s.Options.BackpressureWatermark {
+			saturated = append(saturated, cl)
+		}
+	}
+
+	return saturated
+}
+
+// traceMessage sets the trace id of a publish from its trace id user property, or if it
+// has none, generates a new trace id and adds it as a user property.
+func (s *Server) traceMessage(pk *packets.Packet) {
+	for _, up := range pk.Properties.User {
+		if up.Key == s.Options.TraceProperty {
+			pk.TraceID = up.Val
+			return
+		}
+	}
+
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	pk.TraceID = hex.EncodeToString(b)
+
+	// copy the user properties so the trace id is not appended into a shared backing array
+	user := make([]packets.UserProperty, len(pk.Properties.User), len(pk.Properties.User)+1)
+	copy(user, pk.Properties.User)
+	pk.Properties.User = append(user, packets.UserProperty{Key: s.Options.TraceProperty, Val: pk.TraceID})
+}
+
 // retainMessage adds a message to a topic, and if a persistent store is provided,
 // adds the message to the store to be reloaded if necessary.
 func (s *Server) retainMessage(cl *Client, pk packets.Packet) {
@@ -970,9 +1833,15 @@ func (s *Server) retainMessage(cl *Client, pk packets.Packet) {
 		return
 	}
 
-	out := pk.Copy(false)
-	r := s.Topics.RetainMessage(out)
+	// payloads are only compressed within the topics index, which decodes them when read.
+	r := s.Topics.RetainMessage(s.compressRetained(pk.Copy(false)))
 	s.hooks.OnRetainMessage(cl, pk, r)
+	switch r {
+	case 1:
+		s.hooks.OnRetainSet(cl, pk)
+	case -1:
+		s.hooks.OnRetainCleared(cl, pk)
+	}
 	atomic.StoreInt64(&s.Info.Retained, int64(s.Topics.Retained.Len()))
 }
 
@@ -982,47 +1851,106 @@ func (s *Server) publishToSubscribers(pk packets.Packet) {
 		return
 	}
 
-	if pk.Created == 0 {
-		pk.Created = time.Now().Unix()
-	}
-
-	pk.Expiry = pk.Created + s.Options.Capabilities.MaximumMessageExpiryInterval
-	if pk.Properties.MessageExpiryInterval > 0 {
-		pk.Expiry = pk.Created + int64(pk.Properties.MessageExpiryInterval)
-	}
+	s.publishToSubscriberSet(pk, s.selectSubscribers(pk), nil)
+}
 
+// selectSubscribers returns the subscribers to a publish, with one member selected from each
+// matching shared subscription group.
+func (s *Server) selectSubscribers(pk packets.Packet) *Subscribers {
 	subscribers := s.Topics.Subscribers(pk.TopicName)
 	if len(subscribers.Shared) > 0 {
-		subscribers = s.hooks.OnSelectSubscribers(subscribers, pk)
+		subscribers = s.hooks.OnSelectSubscribers(subscribers.Clone(), pk) // the index may share its result with other publishes
 		if len(subscribers.SharedSelected) == 0 {
 			subscribers.SelectShared()
 		}
 		subscribers.MergeSharedSelected()
 	}
 
+	return subscribers
+}
+
+// publishToSubscriberSet publishes a publish packet to a set of subscribers selected for it,
+// recording the result for each subscribing client in the report if it is not nil.
+func (s *Server) publishToSubscriberSet(pk packets.Packet, subscribers *Subscribers, report *PublishReport) {
+	if pk.Ignore || subscribers == nil {
+		return
+	}
+
+	if pk.Created == 0 {
+		pk.Created = s.Options.now().Unix()
+	}
+
+	pk.Expiry = pk.Created + s.Options.Capabilities.MaximumMessageExpiryInterval
+	if pk.Properties.MessageExpiryInterval > 0 {
+		pk.Expiry = pk.Created + int64(pk.Properties.MessageExpiryInterval)
+	}
+
+	if !strings.HasPrefix(pk.TopicName, SysPrefix) {
+		s.TopicStats.Record(pk.TopicName, len(pk.Payload))
+	}
+
 	for _, inlineSubscription := range subscribers.InlineSubscriptions {
 		inlineSubscription.Handler(s.inlineClient, inlineSubscription.Subscription, pk)
 	}
 
+	if report != nil {
+		report.Inline = len(subscribers.InlineSubscriptions)
+	}
+
 	for id, subs := range subscribers.Subscriptions {
 		if cl, ok := s.Clients.Get(id); ok {
 			_, err := s.publishToClient(cl, subs, pk)
 			if err != nil {
-				s.Log.Debug("failed publishing packet", "error", err, "client", cl.ID, "packet", pk)
+				cl.ops.log.Debug("failed publishing packet", "error", err, "client", cl.ID, "packet", pk)
+			}
+
+			if report == nil {
+				continue
+			}
+
+			report.Matched = append(report.Matched, id)
+			switch {
+			case err != nil:
+				report.Skipped[id] = err.Error()
+			case s.publishExcluded(cl, subs, pk):
+				report.Skipped[id] = "excluded by subscription"
+			default:
+				report.Delivered = append(report.Delivered, id)
 			}
 		}
 	}
+
+	if report != nil {
+		sort.Strings(report.Matched)
+		sort.Strings(report.Delivered)
+	}
 }
 
-func (s *Server) publishToClient(cl *Client, sub packets.Subscription, pk packets.Packet) (packets.Packet, error) {
+// publishExcluded returns true if a message should not be sent to a client for a subscription,
+// either because the subscription is no local and the client published the message, or
+// because the message is on a reserved topic which the filter does not explicitly match.
+func (s *Server) publishExcluded(cl *Client, sub packets.Subscription, pk packets.Packet) bool {
 	if sub.NoLocal && pk.Origin == cl.ID {
-		return pk, nil // [MQTT-3.8.3-3]
+		return true // [MQTT-3.8.3-3]
+	}
+
+	return s.isReservedTopic(pk.TopicName) && !s.isReservedTopic(trimSharePrefix(sub.Filter))
+}
+
+func (s *Server) publishToClient(cl *Client, sub packets.Subscription, pk packets.Packet) (packets.Packet, error) {
+	if s.publishExcluded(cl, sub, pk) {
+		return pk, nil
 	}
 
 	out := pk.Copy(false)
+
 	if !s.hooks.OnACLCheck(cl, pk.TopicName, false) {
 		return out, packets.ErrNotAuthorized
 	}
+	if sub.FwdRetainedFlag {
+		out.Received = 0 // retained messages are not measured, as they may have been received long ago
+	}
+
 	if !sub.FwdRetainedFlag && ((cl.Properties.ProtocolVersion == 5 && !sub.RetainAsPublished) || cl.Properties.ProtocolVersion < 5) { // ![MQTT-3.3.1-13] [v3 MQTT-3.3.1-9]
 		out.FixedHeader.Retain = false // [MQTT-3.3.1-12]
 	}
@@ -1039,8 +1967,8 @@ func (s *Server) publishToClient(cl *Client, sub packets.Subscription, pk packet
 		out.FixedHeader.Qos = sub.Qos
 	}
 
-	if out.FixedHeader.Qos > s.Options.Capabilities.MaximumQos {
-		out.FixedHeader.Qos = s.Options.Capabilities.MaximumQos // [MQTT-3.2.2-9]
+	if maxQos := s.maximumQos(cl); out.FixedHeader.Qos > maxQos {
+		out.FixedHeader.Qos = maxQos // [MQTT-3.2.2-9]
 	}
 
 	if cl.Properties.Props.TopicAliasMaximum > 0 {
@@ -1054,31 +1982,42 @@ func (s *Server) publishToClient(cl *Client, sub packets.Subscription, pk packet
 		}
 	}
 
+	if s.Options.PayloadCompression && !cl.Net.Inline {
+		s.compressPublish(cl, &out)
+	}
+
 	if out.FixedHeader.Qos > 0 {
 		if cl.State.Inflight.Len() >= int(s.Options.Capabilities.MaximumInflight) {
-			// add hook?
-			atomic.AddInt64(&s.Info.InflightDropped, 1)
-			s.Log.Warn("client store quota reached", "client", cl.ID, "listener", cl.Net.Listener)
-			return out, packets.ErrQuotaExceeded
-		}
-
-		i, err := cl.NextPacketID() // [MQTT-4.3.2-1] [MQTT-4.3.3-1]
-		if err != nil {
-			s.hooks.OnPacketIDExhausted(cl, pk)
-			atomic.AddInt64(&s.Info.InflightDropped, 1)
-			s.Log.Warn("packet ids exhausted", "error", err, "client", cl.ID, "listener", cl.Net.Listener)
-			return out, packets.ErrQuotaExceeded
+			s.hooks.OnInflightOverflow(cl, out)
+			if !s.evictInflight(cl) {
+				atomic.AddInt64(&s.Info.InflightDropped, 1)
+				cl.ops.log.Warn("client store quota reached", "client", cl.ID, "listener", cl.Net.Listener)
+				s.deadLetter(cl, pk, DeadLetterInflightFull)
+				return out, packets.ErrQuotaExceeded
+			}
 		}
 
-		out.PacketID = uint16(i) // [MQTT-2.2.1-4]
 		sentQuota := atomic.LoadInt32(&cl.State.Inflight.sendQuota)
+		for added := false; !added; {
+			i, err := cl.NextPacketID() // [MQTT-4.3.2-1] [MQTT-4.3.3-1]
+			if err != nil {
+				s.hooks.OnPacketIDExhausted(cl, pk)
+				atomic.AddInt64(&s.Info.InflightDropped, 1)
+				cl.ops.log.Warn("packet ids exhausted", "error", err, "client", cl.ID, "listener", cl.Net.Listener)
+				s.deadLetter(cl, pk, DeadLetterInflightFull)
+				return out, packets.ErrQuotaExceeded
+			}
 
-		if ok := cl.State.Inflight.Set(out); ok { // [MQTT-4.3.2-3] [MQTT-4.3.3-3]
-			atomic.AddInt64(&s.Info.Inflight, 1)
-			s.hooks.OnQosPublish(cl, out, out.Created, 0)
-			cl.State.Inflight.DecreaseSendQuota()
+			out.PacketID = uint16(i) // [MQTT-2.2.1-4]
+
+			// a concurrent publish to the client may have claimed the id since it was chosen.
+			added = cl.State.Inflight.Add(out) // [MQTT-4.3.2-3] [MQTT-4.3.3-3]
 		}
 
+		atomic.AddInt64(&s.Info.Inflight, 1)
+		s.hooks.OnQosPublish(cl, out, out.Created, 0)
+		cl.State.Inflight.DecreaseSendQuota()
+
 		if sentQuota == 0 && atomic.LoadInt32(&cl.State.Inflight.maximumSendQuota) > 0 {
 			out.Expiry = -1
 			cl.State.Inflight.Set(out)
@@ -1096,6 +2035,7 @@ func (s *Server) publishToClient(cl *Client, sub packets.Subscription, pk packet
 	default:
 		atomic.AddInt64(&s.Info.MessagesDropped, 1)
 		cl.ops.hooks.OnPublishDropped(cl, pk)
+		s.deadLetter(cl, pk, DeadLetterQueueFull)
 		if out.FixedHeader.Qos > 0 {
 			cl.State.Inflight.Delete(out.PacketID) // packet was dropped due to irregular circumstances, so rollback inflight.
 			cl.State.Inflight.IncreaseSendQuota()
@@ -1106,6 +2046,29 @@ func (s *Server) publishToClient(cl *Client, sub packets.Subscription, pk packet
 	return out, nil
 }
 
+// evictInflight removes the oldest outbound inflight publish of a client to make room
+// for a new message, if the inflight overflow policy allows it. Returns true if a
+// message was evicted.
+func (s *Server) evictInflight(cl *Client) bool {
+	if s.Options.InflightOverflow != InflightEvictOldest {
+		return false
+	}
+
+	pk, ok := cl.State.Inflight.Oldest(packets.Publish)
+	if !ok || !cl.State.Inflight.Evict(pk.PacketID) {
+		return false
+	}
+
+	atomic.AddInt64(&s.Info.Inflight, -1)
+	atomic.AddInt64(&s.Info.InflightDropped, 1)
+	cl.State.Inflight.IncreaseSendQuota()
+	s.hooks.OnQosDropped(cl, pk)
+	s.deadLetterInflight(cl, pk, DeadLetterEvicted)
+	cl.ops.log.Warn("evicted oldest inflight message", "client", cl.ID, "listener", cl.Net.Listener, "packet_id", pk.PacketID)
+
+	return true
+}
+
 func (s *Server) publishRetainedToClient(cl *Client, sub packets.Subscription, existed bool) {
 	if IsSharedFilter(sub.Filter) {
 		return // 4.8.2 Non-normative - Shared Subscriptions - No Retained Messages are sent to the Session when it first subscribes.
@@ -1119,7 +2082,7 @@ func (s *Server) publishRetainedToClient(cl *Client, sub packets.Subscription, e
 	for _, pkv := range s.Topics.Messages(sub.Filter) { // [MQTT-3.8.4-4]
 		_, err := s.publishToClient(cl, sub, pkv)
 		if err != nil {
-			s.Log.Debug("failed to publish retained message", "error", err, "client", cl.ID, "listener", cl.Net.Listener, "packet", pkv)
+			cl.ops.log.Debug("failed to publish retained message", "error", err, "client", cl.ID, "listener", cl.Net.Listener, "packet", pkv)
 			continue
 		}
 		s.hooks.OnRetainPublished(cl, pkv)
@@ -1143,8 +2106,8 @@ func (s *Server) buildAck(packetID uint16, pkt, qos byte, properties packets.Pro
 		PacketID:   packetID,    // [MQTT-2.2.1-5]
 		ReasonCode: reason.Code, // [MQTT-3.4.2-1]
 		Properties: properties,
-		Created:    time.Now().Unix(),
-		Expiry:     time.Now().Unix() + s.Options.Capabilities.MaximumMessageExpiryInterval,
+		Created:    s.Options.now().Unix(),
+		Expiry:     s.Options.now().Unix() + s.Options.Capabilities.MaximumMessageExpiryInterval,
 	}
 
 	return pk
@@ -1153,6 +2116,8 @@ func (s *Server) buildAck(packetID uint16, pkt, qos byte, properties packets.Pro
 // processPuback processes a Puback packet, denoting completion of a QOS 1 packet sent from the server.
 func (s *Server) processPuback(cl *Client, pk packets.Packet) error {
 	if _, ok := cl.State.Inflight.Get(pk.PacketID); !ok {
+		// the message may have been evicted after it was sent, so its packet id is released.
+		cl.State.Inflight.Release(pk.PacketID)
 		return nil // omit, but would be packets.ErrPacketIdentifierNotFound
 	}
 
@@ -1219,6 +2184,10 @@ func (s *Server) processPubrel(cl *Client, pk packets.Packet) error {
 
 // processPubcomp processes a Pubcomp packet, denoting completion of a QOS 2 packet sent from the server.
 func (s *Server) processPubcomp(cl *Client, pk packets.Packet) error {
+	if cl.State.Inflight.Release(pk.PacketID) {
+		return nil // the message was evicted after it was sent, and its quota already restored
+	}
+
 	// regardless of whether the pubcomp is a success or failure, we end the qos flow, delete inflight, and restore the quotas.
 	cl.State.Inflight.IncreaseReceiveQuota() // +1 RECV QUOTA
 	cl.State.Inflight.IncreaseSendQuota()    // +1 SENT QUOTA
@@ -1233,6 +2202,7 @@ func (s *Server) processPubcomp(cl *Client, pk packets.Packet) error {
 // processSubscribe processes a Subscribe packet.
 func (s *Server) processSubscribe(cl *Client, pk packets.Packet) error {
 	pk = s.hooks.OnSubscribe(cl, pk)
+	pk.Filters = append(packets.Subscriptions{}, pk.Filters...) // granted values are set without modifying the original packet
 	code := packets.CodeSuccess
 	if _, ok := cl.State.Inflight.Get(pk.PacketID); ok {
 		code = packets.ErrPacketIdentifierInUse
@@ -1248,22 +2218,25 @@ func (s *Server) processSubscribe(cl *Client, pk packets.Packet) error {
 			reasonCodes[i] = packets.ErrTopicFilterInvalid.Code
 		} else if sub.NoLocal && IsSharedFilter(sub.Filter) {
 			reasonCodes[i] = packets.ErrProtocolViolationInvalidSharedNoLocal.Code // [MQTT-3.8.3-4]
-		} else if !s.hooks.OnACLCheck(cl, sub.Filter, false) {
+		} else if cl.State.writeOnly || !s.hooks.OnACLCheck(cl, sub.Filter, false) {
 			reasonCodes[i] = packets.ErrNotAuthorized.Code
 			if s.Options.Capabilities.Compatibilities.ObscureNotAuthorized {
 				reasonCodes[i] = packets.ErrUnspecifiedError.Code
 			}
 		} else {
+			if maxQos := s.maximumQos(cl); sub.Qos > maxQos {
+				sub.Qos = maxQos // [MQTT-3.2.2-9]
+			}
+
+			// the subscription is stored with the granted qos, so the session and any
+			// persistent storage reflect the qos the client was told it received.
 			isNew := s.Topics.Subscribe(cl.ID, sub) // [MQTT-3.8.4-3]
 			if isNew {
 				atomic.AddInt64(&s.Info.Subscriptions, 1)
 			}
 			cl.State.Subscriptions.Add(sub.Filter, sub) // [MQTT-3.2.2-10]
 
-			if sub.Qos > s.Options.Capabilities.MaximumQos {
-				sub.Qos = s.Options.Capabilities.MaximumQos // [MQTT-3.2.2-9]
-			}
-
+			pk.Filters[i] = sub
 			filterExisted[i] = !isNew
 			reasonCodes[i] = sub.Qos // [MQTT-3.9.3-1] [MQTT-3.8.4-7]
 		}
@@ -1320,6 +2293,11 @@ func (s *Server) processUnsubscribe(cl *Client, pk packets.Packet) error {
 			continue
 		}
 
+		if !IsValidFilter(sub.Filter, false) {
+			reasonCodes[i] = packets.ErrTopicFilterInvalid.Code
+			continue
+		}
+
 		if q := s.Topics.Unsubscribe(sub.Filter, cl.ID); q {
 			atomic.AddInt64(&s.Info.Subscriptions, -1)
 			reasonCodes[i] = packets.CodeSuccess.Code
@@ -1345,6 +2323,7 @@ func (s *Server) processUnsubscribe(cl *Client, pk packets.Packet) error {
 		ack.Properties.ReasonString = code.Reason
 	}
 
+	pk.ReasonCodes = reasonCodes
 	s.hooks.OnUnsubscribed(cl, pk)
 	return cl.WritePacket(ack)
 }
@@ -1372,6 +2351,91 @@ func (s *Server) UnsubscribeClient(cl *Client) {
 	s.hooks.OnUnsubscribed(cl, packets.Packet{FixedHeader: packets.FixedHeader{Type: packets.Unsubscribe}, Filters: filters})
 }
 
+// AddClientSubscription subscribes an existing client to a filter on its behalf, as if
+// the client had sent a subscribe packet, so that provisioning systems can direct messages
+// to devices without changing them. ACL checks are not applied, and no suback is sent to
+// the client. Any retained messages matching the filter are delivered to the client.
+func (s *Server) AddClientSubscription(id string, sub packets.Subscription) error {
+	cl, ok := s.Clients.Get(id)
+	if !ok {
+		return ErrClientNotFound
+	}
+
+	if !IsValidFilter(sub.Filter, false) {
+		return packets.ErrTopicFilterInvalid
+	}
+
+	if sub.NoLocal && IsSharedFilter(sub.Filter) {
+		return packets.ErrProtocolViolationInvalidSharedNoLocal
+	}
+
+	if maxQos := s.maximumQos(cl); sub.Qos > maxQos {
+		sub.Qos = maxQos
+	}
+
+	pk := s.hooks.OnSubscribe(cl, packets.Packet{
+		Origin:      cl.ID,
+		FixedHeader: packets.FixedHeader{Type: packets.Subscribe},
+		Filters:     packets.Subscriptions{sub},
+	})
+
+	reasonCodes := make([]byte, len(pk.Filters))
+	filterExisted := make([]bool, len(pk.Filters))
+	for i, sub := range pk.Filters {
+		isNew := s.Topics.Subscribe(cl.ID, sub)
+		if isNew {
+			atomic.AddInt64(&s.Info.Subscriptions, 1)
+		}
+		cl.State.Subscriptions.Add(sub.Filter, sub)
+		filterExisted[i] = !isNew
+		reasonCodes[i] = sub.Qos
+	}
+
+	s.hooks.OnSubscribed(cl, pk, reasonCodes)
+
+	if cl.Closed() {
+		return nil
+	}
+
+	for i, sub := range pk.Filters {
+		s.publishRetainedToClient(cl, sub, filterExisted[i])
+	}
+
+	return nil
+}
+
+// RemoveClientSubscription unsubscribes an existing client from a filter on its behalf,
+// as if the client had sent an unsubscribe packet. No unsuback is sent to the client.
+func (s *Server) RemoveClientSubscription(id, filter string) error {
+	cl, ok := s.Clients.Get(id)
+	if !ok {
+		return ErrClientNotFound
+	}
+
+	if !IsValidFilter(filter, false) {
+		return packets.ErrTopicFilterInvalid
+	}
+
+	pk := s.hooks.OnUnsubscribe(cl, packets.Packet{
+		Origin:      cl.ID,
+		FixedHeader: packets.FixedHeader{Type: packets.Unsubscribe},
+		Filters:     packets.Subscriptions{{Filter: filter}},
+	})
+
+	pk.ReasonCodes = make([]byte, len(pk.Filters))
+	for i, sub := range pk.Filters {
+		pk.ReasonCodes[i] = packets.CodeNoSubscriptionExisted.Code
+		if s.Topics.Unsubscribe(sub.Filter, cl.ID) {
+			atomic.AddInt64(&s.Info.Subscriptions, -1)
+			pk.ReasonCodes[i] = packets.CodeSuccess.Code
+		}
+		cl.State.Subscriptions.Delete(sub.Filter)
+	}
+
+	s.hooks.OnUnsubscribed(cl, pk)
+	return nil
+}
+
 // processAuth processes an Auth packet.
 func (s *Server) processAuth(cl *Client, pk packets.Packet) error {
 	_, err := s.hooks.OnAuthPacket(cl, pk)
@@ -1399,6 +2463,71 @@ func (s *Server) processDisconnect(cl *Client, pk packets.Packet) error {
 	return nil
 }
 
+// Sessions returns the lifecycle of every connected and disconnected client session held
+// by the server, ordered by client id, eg. for reporting on dormant devices.
+func (s *Server) Sessions() []Session {
+	sessions := make([]Session, 0, s.Clients.Len())
+	for _, cl := range s.Clients.GetAll() {
+		if cl.Net.Inline {
+			continue
+		}
+		sessions = append(sessions, cl.Session())
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].ClientID < sessions[j].ClientID
+	})
+
+	return sessions
+}
+
+// ForgetClient erases the data held for a client id, such as for a right to be forgotten
+// request. The client is disconnected without sending its will, and its session,
+// subscriptions, and inflight and queued messages are removed from the server and any
+// stores. If retained is true, the retained messages published by the client are also
+// cleared. Hooks which keep other records of the client, such as audit logs or message
+// archives, are called to delete them, and their errors are returned.
+func (s *Server) ForgetClient(id string, retained bool) error {
+	cl, ok := s.Clients.Get(id)
+	if ok {
+		atomic.StoreUint32(&cl.State.forgotten, 1)
+		atomic.StoreUint32(&cl.Properties.Will.Flag, 0)
+		if !cl.Closed() {
+			_ = s.DisconnectClient(cl, packets.ErrAdministrativeAction)
+		}
+
+		cl.ClearInflights()
+		s.UnsubscribeClient(cl)
+		s.Clients.Delete(id)
+		s.hooks.OnClientExpired(cl)
+	} else {
+		cl = s.NewClient(nil, "", id, false)
+	}
+
+	s.loop.willDelayed.Delete(id)
+
+	if retained {
+		for topic, pk := range s.Topics.Retained.GetAll() {
+			if pk.Origin != id {
+				continue
+			}
+
+			s.retainMessage(cl, packets.Packet{
+				FixedHeader: packets.FixedHeader{
+					Type:   packets.Publish,
+					Retain: true,
+				},
+				TopicName: topic,
+				Origin:    id,
+				Created:   s.Options.now().Unix(),
+			})
+		}
+	}
+
+	s.Log.Info("client forgotten", "client", id, "retained", retained)
+	return s.hooks.OnClientForgotten(id)
+}
+
 // DisconnectClient sends a Disconnect packet to a client and then closes the client connection.
 func (s *Server) DisconnectClient(cl *Client, code packets.Code) error {
 	out := packets.Packet{
@@ -1426,6 +2555,139 @@ func (s *Server) DisconnectClient(cl *Client, code packets.Code) error {
 	return err
 }
 
+// Replay re-publishes archived messages matching a filter which were created between
+// from and to (unix seconds, inclusive) to the target, returning the number of messages
+// sent. Archived messages are provided by any hook implementing StoredArchivedMessages.
+// Replayed messages are never retained.
+func (s *Server) Replay(filter string, from, to int64, target ReplayTarget) (int, error) {
+	if !IsValidFilter(filter, false) {
+		return 0, packets.ErrTopicFilterInvalid
+	}
+
+	var cl *Client
+	if target.ClientID != "" {
+		var ok bool
+		if cl, ok = s.Clients.Get(target.ClientID); !ok {
+			return 0, ErrClientNotFound
+		}
+	}
+
+	msgs, err := s.hooks.StoredArchivedMessages(filter, from, to)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, msg := range msgs {
+		pk := msg.ToPacket()
+		pk.FixedHeader.Type = packets.Publish
+		pk.FixedHeader.Retain = false
+		pk.FixedHeader.Dup = false
+		pk.PacketID = 0
+		pk.Created = s.Options.now().Unix()
+
+		if cl == nil {
+			if target.Topic != "" {
+				pk.TopicName = target.Topic
+			}
+			s.publishToSubscribers(pk)
+			continue
+		}
+
+		pk.Expiry = pk.Created + s.Options.Capabilities.MaximumMessageExpiryInterval
+		if pk.Properties.MessageExpiryInterval > 0 {
+			pk.Expiry = pk.Created + int64(pk.Properties.MessageExpiryInterval)
+		}
+
+		_, err := s.publishToClient(cl, packets.Subscription{Filter: filter, Qos: pk.FixedHeader.Qos}, pk)
+		if err != nil {
+			return i, err
+		}
+	}
+
+	return len(msgs), nil
+}
+
+// Compact stops clients whose keepalive has expired, removes expired clients, retained
+// messages, inflight messages, and bans, and then requests any persistence hooks compact
+// their stores. Compact is run on schedule if Options.CompactionInterval is set, but may
+// also be called on demand.
+func (s *Server) Compact() error {
+	t := s.Options.now()
+	now := t.Unix()
+	s.clearExpiredKeepalives(t.UnixNano())
+	s.clearExpiredClients(now)
+	s.clearExpiredRetainedMessages(now)
+	s.clearExpiredInflights(now)
+	s.clearExpiredBans(now)
+
+	err := s.hooks.OnCompact()
+	if err != nil {
+		return err
+	}
+
+	s.Log.Debug("compaction complete")
+	return nil
+}
+
+// TraceClient enables or disables logging of every inbound and outbound packet
+// for the client with the given id. Tracing applies to the client id, so remains
+// in effect if the client reconnects.
+func (s *Server) TraceClient(id string, enable bool) {
+	if enable {
+		s.Traces.Add(id)
+		s.Log.Info("client packet trace enabled", "client", id)
+		return
+	}
+
+	s.Traces.Delete(id)
+	s.Log.Info("client packet trace disabled", "client", id)
+}
+
+// Ban prevents clients matching the client id and/or ip address from connecting
+// to the server for the duration of ttl, or indefinitely if ttl is 0. Any matching
+// clients which are currently connected are disconnected.
+func (s *Server) Ban(id, ip string, ttl time.Duration, reason string) (Ban, error) {
+	if id == "" && ip == "" {
+		return Ban{}, ErrInvalidBan
+	}
+
+	now := s.Options.now()
+	b := Ban{
+		ClientID: id,
+		IP:       ip,
+		Reason:   reason,
+		Created:  now.Unix(),
+	}
+
+	if ttl > 0 {
+		b.Expiry = now.Add(ttl).Unix()
+	}
+
+	s.Bans.Add(b)
+	s.hooks.OnBanned(b)
+
+	for _, cl := range s.Clients.GetAll() {
+		if !cl.Net.Inline && !cl.Closed() && b.Matches(cl.ID, remoteIP(cl.Net.Remote)) {
+			_ = s.DisconnectClient(cl, packets.ErrNotAuthorized)
+		}
+	}
+
+	return b, nil
+}
+
+// Unban removes the ban for the client id and ip address, returning true if it existed.
+func (s *Server) Unban(id, ip string) bool {
+	key := Ban{ClientID: id, IP: ip}.Key()
+	b, ok := s.Bans.Get(key)
+	if !ok {
+		return false
+	}
+
+	s.Bans.Delete(key)
+	s.hooks.OnUnbanned(b)
+	return true
+}
+
 // publishSysTopics publishes the current values to the server $SYS topics.
 // Due to the int to string conversions this method is not as cheap as
 // some of the others so the publishing interval should be set appropriately.
@@ -1435,7 +2697,7 @@ func (s *Server) publishSysTopics() {
 			Type:   packets.Publish,
 			Retain: true,
 		},
-		Created: time.Now().Unix(),
+		Created: s.Options.now().Unix(),
 	}
 
 	var m runtime.MemStats
@@ -1464,6 +2726,7 @@ func (s *Server) publishSysTopics() {
 		SysPrefix + "/broker/messages/received":    Int64toa(info.MessagesReceived),
 		SysPrefix + "/broker/messages/sent":        Int64toa(info.MessagesSent),
 		SysPrefix + "/broker/messages/dropped":     Int64toa(info.MessagesDropped),
+		SysPrefix + "/broker/messages/throttled":   Int64toa(info.MessagesThrottled),
 		SysPrefix + "/broker/messages/inflight":    Int64toa(info.Inflight),
 		SysPrefix + "/broker/retained":             Int64toa(info.Retained),
 		SysPrefix + "/broker/subscriptions":        Int64toa(info.Subscriptions),
@@ -1471,6 +2734,26 @@ func (s *Server) publishSysTopics() {
 		SysPrefix + "/broker/system/threads":       Int64toa(info.Threads),
 	}
 
+	if b, err := json.Marshal(s.BrokerInfo()); err == nil {
+		topics[SysPrefix+"/broker/info"] = string(b)
+	}
+
+	if b, err := json.Marshal(newRuntimeStats(&m)); err == nil {
+		topics[SysPrefix+"/broker/runtime"] = string(b)
+	}
+
+	if s.Options.TopicMetricsDepth > 0 {
+		if b, err := json.Marshal(s.TopicStats.GetAll()); err == nil {
+			topics[SysPrefix+"/metrics/topics"] = string(b)
+		}
+	}
+
+	if s.Options.DeliveryLatency {
+		if b, err := json.Marshal(s.Latency.GetAll()); err == nil {
+			topics[SysPrefix+"/metrics/latency"] = string(b)
+		}
+	}
+
 	for topic, payload := range topics {
 		pk.TopicName = topic
 		pk.Payload = []byte(payload)
@@ -1481,11 +2764,179 @@ func (s *Server) publishSysTopics() {
 	s.hooks.OnSysInfoTick(info)
 }
 
+// TopicsDump returns a copy of the subscription tree, describing the subscribers and
+// retained messages at each topic level, eg. for debugging routing issues.
+func (s *Server) TopicsDump() TopicNode {
+	return s.Topics.Dump()
+}
+
+// Stats returns a snapshot of the server statistics and per-topic message counters.
+func (s *Server) Stats() Stats {
+	st := Stats{
+		Info:    s.Info.Clone(),
+		Topics:  s.TopicStats.GetAll(),
+		Latency: s.Latency.GetAll(),
+	}
+
+	if s.Options.KeepaliveMetrics {
+		st.Keepalive = s.KeepaliveHealth()
+	}
+
+	return st
+}
+
+// KeepaliveHealth returns the keepalive health of each connected client, ordered by client
+// id. Pings are only recorded if keepalive metrics are enabled.
+func (s *Server) KeepaliveHealth() []KeepaliveHealth {
+	health := make([]KeepaliveHealth, 0, s.Clients.Len())
+	for _, cl := range s.Clients.GetAll() {
+		if cl.Net.Inline || cl.Closed() {
+			continue
+		}
+		health = append(health, cl.KeepaliveHealth())
+	}
+
+	sort.Slice(health, func(i, j int) bool {
+		return health[i].ClientID < health[j].ClientID
+	})
+
+	return health
+}
+
+// BrokerInfo returns the build, runtime, and configuration highlights of the server.
+func (s *Server) BrokerInfo() BrokerInfo {
+	started := atomic.LoadInt64(&s.Info.Started)
+	bi := BrokerInfo{
+		Version:              s.Info.Version,
+		GoVersion:            runtime.Version(),
+		OS:                   runtime.GOOS,
+		Arch:                 runtime.GOARCH,
+		Started:              started,
+		Uptime:               time.Now().Unix() - started,
+		Listeners:            s.Listeners.Len(),
+		MaximumClients:       s.Options.Capabilities.MaximumClients,
+		MaximumPacketSize:    s.Options.Capabilities.MaximumPacketSize,
+		MaximumQos:           s.Options.Capabilities.MaximumQos,
+		RetainAvailable:      s.Options.Capabilities.RetainAvailable == 1,
+		WildcardSubAvailable: s.Options.Capabilities.WildcardSubAvailable == 1,
+		SharedSubAvailable:   s.Options.Capabilities.SharedSubAvailable == 1,
+		InlineClient:         s.Options.InlineClient,
+	}
+
+	if build, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range build.Settings {
+			if setting.Key == "vcs.revision" {
+				bi.Revision = setting.Value
+			}
+		}
+	}
+
+	return bi
+}
+
+// Drain stops all listeners accepting new connections so that a load balancer can
+// direct clients elsewhere, leaving existing clients connected. If rate is greater than
+// 0, connected clients are then sent a server shutting down DISCONNECT at a rate of
+// rate clients per second, prompting them to reconnect to another server. Drain blocks
+// until every client has been disconnected or the server is closed.
+func (s *Server) Drain(rate int) {
+	if atomic.CompareAndSwapUint32(&s.draining, 0, 1) {
+		s.Log.Info("draining server, no longer accepting connections")
+		for _, id := range s.Listeners.IDs() {
+			s.Listeners.Close(id, func(id string) {}) // clients are disconnected below or on Close
+		}
+	}
+
+	if rate <= 0 {
+		return
+	}
+
+	clients := make([]*Client, 0, s.Clients.Len())
+	for _, cl := range s.Clients.GetAll() {
+		clients = append(clients, cl)
+	}
+
+	if s.disconnectGradually(clients, rate) {
+		s.Log.Info("server drained")
+	}
+}
+
+// ReplaceListener replaces the attached listener with the same id as l, for example to
+// apply new TLS or socket settings without restarting the server. The new listener is
+// started before the old listener is closed, so both must set ReusePort to bind the same
+// address. Clients connected through the old listener remain connected; if rate is
+// greater than 0 they are sent a server shutting down DISCONNECT at a rate of rate
+// clients per second, so that they reconnect through the new listener. ReplaceListener
+// blocks until these clients have been disconnected.
+func (s *Server) ReplaceListener(l listeners.Listener, rate int) error {
+	old, ok := s.Listeners.Get(l.ID())
+	if !ok {
+		return ErrListenerIDNotFound
+	}
+
+	clients := s.Clients.GetByListener(l.ID())
+
+	nl := s.LogLevels.Logger(s.Log, LogListeners).With(slog.String("listener", l.ID()))
+	if err := l.Init(nl); err != nil {
+		return err
+	}
+
+	s.Listeners.Add(l)
+	if atomic.LoadUint32(&s.serving) == 1 {
+		s.Listeners.Serve(l.ID(), s.EstablishConnection)
+	}
+
+	old.Close(func(id string) {}) // clients of the old listener are disconnected below
+	s.Log.Info("replaced listener", "id", l.ID(), "protocol", l.Protocol(), "address", l.Address())
+
+	s.disconnectGradually(clients, rate)
+	return nil
+}
+
+// disconnectGradually sends a server shutting down DISCONNECT to the network clients at
+// a rate of rate clients per second, returning false if the server closed first.
+func (s *Server) disconnectGradually(clients []*Client, rate int) bool {
+	if rate <= 0 {
+		return true
+	}
+
+	ticker := time.NewTicker(time.Second / time.Duration(rate))
+	defer ticker.Stop()
+
+	for _, cl := range clients {
+		if cl.Net.Inline || cl.Closed() {
+			continue
+		}
+
+		select {
+		case <-s.done:
+			return false
+		case <-ticker.C:
+		}
+
+		if !cl.Closed() {
+			_ = s.DisconnectClient(cl, packets.ErrServerShuttingDown)
+		}
+	}
+
+	return true
+}
+
 // Close attempts to gracefully shut down the server, all listeners, clients, and stores.
 func (s *Server) Close() error {
 	close(s.done)
 	s.Log.Info("gracefully stopping server")
-	s.Listeners.CloseAll(s.closeListenerClients)
+	if atomic.LoadUint32(&s.draining) == 1 {
+		// the listeners were already closed by Drain, so disconnect any remaining clients directly.
+		for _, cl := range s.Clients.GetAll() {
+			if !cl.Net.Inline && !cl.Closed() {
+				_ = s.DisconnectClient(cl, packets.ErrServerShuttingDown)
+			}
+		}
+		s.Listeners.ClientsWg.Wait()
+	} else {
+		s.Listeners.CloseAll(s.closeListenerClients)
+	}
 	s.hooks.OnStopped()
 	s.hooks.Stop()
 
@@ -1503,7 +2954,7 @@ func (s *Server) closeListenerClients(listener string) {
 
 // sendLWT issues an LWT message to a topic when a client disconnects.
 func (s *Server) sendLWT(cl *Client) {
-	if atomic.LoadUint32(&cl.Properties.Will.Flag) == 0 {
+	if atomic.LoadUint32(&cl.Properties.Will.Flag) == 0 || cl.State.readOnly {
 		return
 	}
 
@@ -1521,12 +2972,12 @@ func (s *Server) sendLWT(cl *Client) {
 			User: modifiedLWT.User,
 		},
 		Origin:  cl.ID,
-		Created: time.Now().Unix(),
+		Created: s.Options.now().Unix(),
 	}
 
 	if cl.Properties.Will.WillDelayInterval > 0 {
 		pk.Connect.WillProperties.WillDelayInterval = cl.Properties.Will.WillDelayInterval
-		pk.Expiry = time.Now().Unix() + int64(pk.Connect.WillProperties.WillDelayInterval)
+		pk.Expiry = s.Options.now().Unix() + int64(pk.Connect.WillProperties.WillDelayInterval)
 		s.loop.willDelayed.Add(cl.ID, pk)
 		return
 	}
@@ -1587,6 +3038,15 @@ func (s *Server) readStore() error {
 		s.Log.Debug("loaded $SYS info from store")
 	}
 
+	if s.hooks.Provides(StoredBans) {
+		bans, err := s.hooks.StoredBans()
+		if err != nil {
+			return fmt.Errorf("load bans; %w", err)
+		}
+		s.loadBans(bans)
+		s.Log.Debug("loaded bans from store", "len", len(bans))
+	}
+
 	return nil
 }
 
@@ -1650,6 +3110,7 @@ func (s *Server) loadClients(v []storage.Client) {
 			MaximumPacketSize:         c.Properties.MaximumPacketSize,
 		}
 		cl.Properties.Will = Will(c.Will)
+		cl.State.created = c.Created
 
 		// cancel the context, update cl.State such as disconnected time and stopCause.
 		cl.Stop(packets.ErrServerShuttingDown)
@@ -1681,6 +3142,35 @@ func (s *Server) loadRetained(v []storage.Message) {
 	}
 }
 
+// loadBans restores bans from the datastore.
+func (s *Server) loadBans(v []storage.Ban) {
+	for _, b := range v {
+		s.Bans.Add(Ban{
+			ClientID: b.ClientID,
+			IP:       b.IP,
+			Reason:   b.Reason,
+			Created:  b.Created,
+			Expiry:   b.Expiry,
+		})
+	}
+}
+
+// clearExpiredKeepalives stops all clients which have not sent a packet within the keepalive
+// grace of their listener (one and a half times their keepalive interval by default) by the
+// server clock, where now is in unix nanoseconds. Connections are otherwise ended by their
+// read deadline, which is always measured in real time.
+func (s *Server) clearExpiredKeepalives(now int64) {
+	for _, cl := range s.Clients.GetAll() {
+		deadline := atomic.LoadInt64(&cl.State.keepaliveDeadline)
+		if cl.Net.Inline || cl.Closed() || deadline == 0 || deadline >= now {
+			continue
+		}
+
+		cl.ops.log.Debug("client keepalive expired", "client", cl.ID, "listener", cl.Net.Listener)
+		cl.Stop(packets.ErrKeepAliveTimeout)
+	}
+}
+
 // clearExpiredClients deletes all clients which have been disconnected for longer
 // than their given expiry intervals.
 func (s *Server) clearExpiredClients(dt int64) {
@@ -1697,6 +3187,7 @@ func (s *Server) clearExpiredClients(dt int64) {
 
 		if disconnected+int64(expire) < dt {
 			s.hooks.OnClientExpired(client)
+			s.clearConnectionEvent(client)
 			s.Clients.Delete(id) // [MQTT-4.1.0-2]
 		}
 	}
@@ -1719,17 +3210,100 @@ func (s *Server) clearExpiredRetainedMessages(now int64) {
 	}
 }
 
+// clearExpiredBans deletes any bans which have expired.
+func (s *Server) clearExpiredBans(now int64) {
+	for key, b := range s.Bans.GetAll() {
+		if b.Expired(now) {
+			s.Bans.Delete(key)
+			s.hooks.OnUnbanned(b)
+		}
+	}
+}
+
 // clearExpiredInflights deletes any inflight messages which have expired.
 func (s *Server) clearExpiredInflights(now int64) {
 	for _, client := range s.Clients.GetAll() {
-		if deleted := client.ClearExpiredInflights(now, s.Options.Capabilities.MaximumMessageExpiryInterval); len(deleted) > 0 {
+		var pending map[uint16]packets.Packet
+		if s.Options.DeadLetterTopic != "" {
+			pending = map[uint16]packets.Packet{}
+			for _, pk := range client.State.Inflight.GetAll(false) {
+				pending[pk.PacketID] = pk
+			}
+		}
+
+		if deleted := client.ClearExpiredInflights(now, s.maximumInflightAge(client.Closed())); len(deleted) > 0 {
 			for _, id := range deleted {
 				s.hooks.OnQosDropped(client, packets.Packet{PacketID: id})
+				if pk, ok := pending[id]; ok {
+					s.deadLetterInflight(client, pk, DeadLetterExpired)
+				}
+			}
+		}
+	}
+}
+
+// resendInflights queues unacknowledged inflight messages to be resent to connected MQTT v3
+// clients once their resend backoff has elapsed, if an inflight resend interval is set. A
+// message is not resent if the outbound queue of the client is full, and is tried again
+// after the next backoff.
+func (s *Server) resendInflights(now int64) {
+	if s.Options.InflightResendInterval <= 0 {
+		return
+	}
+
+	for _, cl := range s.Clients.GetAll() {
+		if cl.Net.Inline || cl.Net.Conn == nil || cl.Closed() || cl.Properties.ProtocolVersion >= 5 {
+			continue // [MQTT-4.4.0-1]
+		}
+
+		for _, r := range cl.State.Inflight.dueResends(now, s.Options.InflightResendInterval, s.Options.InflightResendMaximum) {
+			pk := r.pk
+			if pk.FixedHeader.Type == packets.Publish {
+				pk.FixedHeader.Dup = true // [MQTT-3.3.1-1]
+				cl.State.Inflight.Update(pk.PacketID, func(p *packets.Packet) {
+					p.FixedHeader.Dup = true
+				})
+			}
+
+			select {
+			case cl.State.outbound <- &pk:
+				atomic.AddInt32(&cl.State.outboundQty, 1)
+				s.hooks.OnQosPublish(cl, pk, now, r.attempts)
+			default:
 			}
 		}
 	}
 }
 
+// isReservedTopic returns true if a topic or filter begins with $SYS or one of the
+// reserved topic prefixes.
+func (s *Server) isReservedTopic(topic string) bool {
+	if topic == SysPrefix || strings.HasPrefix(topic, SysPrefix+"/") {
+		return true
+	}
+
+	for _, prefix := range s.Options.ReservedTopicPrefixes {
+		prefix = strings.TrimSuffix(prefix, "/")
+		if prefix != "" && (topic == prefix || strings.HasPrefix(topic, prefix+"/")) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// maximumInflightAge returns the maximum age of inflight messages for a client, which
+// is limited by the maximum offline message age while the client is disconnected.
+func (s *Server) maximumInflightAge(offline bool) int64 {
+	max := s.Options.Capabilities.MaximumMessageExpiryInterval
+	age := s.Options.Capabilities.MaximumOfflineMessageAge
+	if offline && age > 0 && (max == 0 || age < max) {
+		return age
+	}
+
+	return max
+}
+
 // sendDelayedLWT sends any LWT messages which have reached their issue time.
 func (s *Server) sendDelayedLWT(dt int64) {
 	for id, pk := range s.loop.willDelayed.GetAll() {
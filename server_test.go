@@ -6,10 +6,14 @@ package mqtt
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"io"
 	"log/slog"
 	"net"
+	"runtime"
 	"strconv"
 	"sync"
 	"sync/atomic"
@@ -95,6 +99,50 @@ func (h *DelayHook) OnDisconnect(cl *Client, err error, expire bool) {
 	time.Sleep(h.DisconnectDelay)
 }
 
+type banRecorderHook struct {
+	HookBase
+	banned   []Ban
+	unbanned []Ban
+}
+
+func (h *banRecorderHook) ID() string {
+	return "ban-recorder-hook"
+}
+
+func (h *banRecorderHook) Provides(b byte) bool {
+	return bytes.Contains([]byte{OnBanned, OnUnbanned}, []byte{b})
+}
+
+func (h *banRecorderHook) OnBanned(ban Ban) {
+	h.banned = append(h.banned, ban)
+}
+
+func (h *banRecorderHook) OnUnbanned(ban Ban) {
+	h.unbanned = append(h.unbanned, ban)
+}
+
+type retainRecorderHook struct {
+	HookBase
+	set     []string
+	cleared []string
+}
+
+func (h *retainRecorderHook) ID() string {
+	return "retain-recorder-hook"
+}
+
+func (h *retainRecorderHook) Provides(b byte) bool {
+	return bytes.Contains([]byte{OnRetainSet, OnRetainCleared}, []byte{b})
+}
+
+func (h *retainRecorderHook) OnRetainSet(cl *Client, pk packets.Packet) {
+	h.set = append(h.set, pk.TopicName)
+}
+
+func (h *retainRecorderHook) OnRetainCleared(cl *Client, pk packets.Packet) {
+	h.cleared = append(h.cleared, pk.TopicName)
+}
+
 func newServer() *Server {
 	cc := NewDefaultServerCapabilities()
 	cc.MaximumMessageExpiryInterval = 0
@@ -130,6 +178,35 @@ func TestOptionsSetDefaults(t *testing.T) {
 	opts = new(Options)
 	opts.ensureDefaults()
 	require.Equal(t, defaultSysTopicInterval, opts.SysTopicResendInterval)
+	require.Equal(t, "", opts.ConnectionEventsTopic)
+}
+
+func TestOptionsSetDefaultsInflightResend(t *testing.T) {
+	opts := &Options{}
+	opts.ensureDefaults()
+	require.Equal(t, int64(0), opts.InflightResendMaximum)
+
+	opts = &Options{InflightResendInterval: 10}
+	opts.ensureDefaults()
+	require.Equal(t, int64(defaultInflightResendMaximum), opts.InflightResendMaximum)
+
+	opts = &Options{InflightResendInterval: 600}
+	opts.ensureDefaults()
+	require.Equal(t, int64(600), opts.InflightResendMaximum)
+
+	opts = &Options{InflightResendInterval: 10, InflightResendMaximum: 60}
+	opts.ensureDefaults()
+	require.Equal(t, int64(60), opts.InflightResendMaximum)
+}
+
+func TestOptionsSetDefaultsConnectionEvents(t *testing.T) {
+	opts := &Options{ConnectionEvents: true}
+	opts.ensureDefaults()
+	require.Equal(t, defaultConnectionEventsTopic, opts.ConnectionEventsTopic)
+
+	opts = &Options{ConnectionEvents: true, ConnectionEventsTopic: "presence/{clientid}"}
+	opts.ensureDefaults()
+	require.Equal(t, "presence/{clientid}", opts.ConnectionEventsTopic)
 }
 
 func TestNew(t *testing.T) {
@@ -184,7 +261,40 @@ func TestServerNewClient(t *testing.T) {
 	require.NotNil(t, cl.Net.Conn)
 	require.NotNil(t, cl.Net.bconn)
 	require.NotNil(t, cl.ops)
-	require.Equal(t, s.Log, cl.ops.log)
+	require.Equal(t, s.Log.Handler(), cl.ops.log.Handler().(*levelHandler).base)
+}
+
+func TestServerNewClientKeepaliveGrace(t *testing.T) {
+	s := New(nil)
+	s.Log = logger
+	l := listeners.NewTCP(listeners.Config{ID: "t1", Address: "127.0.0.1:0", KeepaliveGrace: 2})
+	err := s.AddListener(l)
+	require.NoError(t, err)
+	defer l.Close(func(id string) {})
+
+	cl := s.NewClient(nil, "t1", "test", false)
+	require.Equal(t, 2.0, cl.State.keepaliveGrace)
+
+	cl = s.NewClient(nil, "testing", "test", false)
+	require.Equal(t, 0.0, cl.State.keepaliveGrace)
+}
+
+func TestServerNewClientReadOnly(t *testing.T) {
+	s := New(nil)
+	s.Log = logger
+	l := listeners.NewMockListener("m1", ":1882")
+	require.NoError(t, s.AddListener(l))
+	require.False(t, s.NewClient(nil, "m1", "test", false).State.readOnly) // mock listeners have no config
+
+	ro := listeners.NewTCP(listeners.Config{ID: "t1", Address: "127.0.0.1:0", ReadOnly: true})
+	require.NoError(t, s.AddListener(ro))
+	defer ro.Close(func(id string) {})
+	require.True(t, s.NewClient(nil, "t1", "test", false).State.readOnly)
+
+	wo := listeners.NewTCP(listeners.Config{ID: "t2", Address: "127.0.0.1:0", WriteOnly: true})
+	require.NoError(t, s.AddListener(wo))
+	defer wo.Close(func(id string) {})
+	require.True(t, s.NewClient(nil, "t2", "test", false).State.writeOnly)
 }
 
 func TestServerNewClientInline(t *testing.T) {
@@ -271,6 +381,8 @@ func TestServerAddListenersFromConfig(t *testing.T) {
 		{Type: listeners.TypeWS, ID: "ws", Address: ":1882"},
 		{Type: listeners.TypeHealthCheck, ID: "health", Address: ":1881"},
 		{Type: listeners.TypeSysInfo, ID: "info", Address: ":1880"},
+		{Type: listeners.TypePprof, ID: "pprof", Address: ":1879"},
+		{Type: listeners.TypeACME, ID: "acme", Address: ":1878", TLS: &listeners.TLSOptions{ACME: &listeners.ACMEOptions{Domains: []string{"example.com"}}}},
 		{Type: listeners.TypeUnix, ID: "unix", Address: "mochi.sock"},
 		{Type: listeners.TypeMock, ID: "mock", Address: "0"},
 		{Type: "unknown", ID: "unknown"},
@@ -278,7 +390,7 @@ func TestServerAddListenersFromConfig(t *testing.T) {
 
 	err := s.AddListenersFromConfig(lc)
 	require.NoError(t, err)
-	require.Equal(t, 6, s.Listeners.Len())
+	require.Equal(t, 8, s.Listeners.Len())
 
 	tcp, _ := s.Listeners.Get("tcp")
 	require.Equal(t, "[::]:1883", tcp.Address())
@@ -292,6 +404,12 @@ func TestServerAddListenersFromConfig(t *testing.T) {
 	info, _ := s.Listeners.Get("info")
 	require.Equal(t, ":1880", info.Address())
 
+	pprof, _ := s.Listeners.Get("pprof")
+	require.Equal(t, ":1879", pprof.Address())
+
+	acme, _ := s.Listeners.Get("acme")
+	require.Equal(t, ":1878", acme.Address())
+
 	unix, _ := s.Listeners.Get("unix")
 	require.Equal(t, "mochi.sock", unix.Address())
 
@@ -386,6 +504,71 @@ func TestServerServeFromConfigHookError(t *testing.T) {
 	require.Error(t, err)
 }
 
+func TestServerRun(t *testing.T) {
+	s := newServer()
+	err := s.AddListener(listeners.NewMockListener("t1", ":1882"))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() {
+		errs <- s.Run(ctx)
+	}()
+
+	require.Eventually(t, func() bool {
+		return atomic.LoadUint32(&s.serving) == 1
+	}, time.Second, time.Millisecond)
+
+	cancel()
+	select {
+	case err = <-errs:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("run should return when the context is cancelled")
+	}
+
+	listener, _ := s.Listeners.Get("t1")
+	require.False(t, listener.(*listeners.MockListener).IsServing())
+
+	select {
+	case <-s.done:
+	default:
+		t.Fatal("server should be closed")
+	}
+}
+
+func TestServerRunClosed(t *testing.T) {
+	s := newServer()
+	errs := make(chan error, 1)
+	go func() {
+		errs <- s.Run(context.Background())
+	}()
+
+	require.Eventually(t, func() bool {
+		return atomic.LoadUint32(&s.serving) == 1
+	}, time.Second, time.Millisecond)
+
+	require.NoError(t, s.Close())
+	select {
+	case err := <-errs:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("run should return when the server is closed")
+	}
+}
+
+func TestServerRunServeError(t *testing.T) {
+	s := newServer()
+	defer s.Close()
+
+	s.Options.Listeners = []listeners.Config{
+		{Type: listeners.TypeTCP, ID: "tcp", Address: "x"},
+	}
+
+	err := s.Run(context.Background())
+	require.Error(t, err)
+}
+
 func TestServerServeReadStoreFailure(t *testing.T) {
 	s := newServer()
 	defer s.Close()
@@ -540,6 +723,139 @@ func TestEstablishConnection(t *testing.T) {
 	require.False(t, ok)
 }
 
+func TestEstablishConnectionEvents(t *testing.T) {
+	s := New(&Options{
+		Logger:           logger,
+		InlineClient:     true,
+		ConnectionEvents: true,
+	})
+	_ = s.AddHook(new(AllowHook), nil)
+	defer s.Close()
+
+	events := make(chan packets.Packet, 2)
+	err := s.Subscribe(SysPrefix+"/broker/connection/+/state", 1, func(cl *Client, sub packets.Subscription, pk packets.Packet) {
+		events <- pk
+	})
+	require.NoError(t, err)
+
+	r, w := net.Pipe()
+	o := make(chan error)
+	go func() {
+		o <- s.EstablishConnection("tcp", r)
+	}()
+
+	go func() {
+		_, _ = w.Write(packets.TPacketData[packets.Connect].Get(packets.TConnectClean).RawBytes)
+		_, _ = w.Write(packets.TPacketData[packets.Disconnect].Get(packets.TDisconnect).RawBytes)
+	}()
+
+	go func() {
+		_, _ = io.ReadAll(w)
+	}()
+
+	require.NoError(t, <-o)
+	_ = w.Close()
+	_ = r.Close()
+
+	for _, state := range []string{ConnectionStateConnected, ConnectionStateDisconnected} {
+		select {
+		case pk := <-events:
+			require.Equal(t, SysPrefix+"/broker/connection/zen/state", pk.TopicName)
+			var ev ConnectionEvent
+			require.NoError(t, json.Unmarshal(pk.Payload, &ev))
+			require.Equal(t, "zen", ev.ClientID)
+			require.Equal(t, state, ev.State)
+			require.Equal(t, "tcp", ev.Listener)
+			require.NotEqual(t, int64(0), ev.SessionCreated)
+			require.NotEqual(t, int64(0), ev.Connected)
+		case <-time.After(time.Second):
+			require.Fail(t, "connection event not received", state)
+		}
+	}
+
+	pk, ok := s.Topics.Retained.Get(SysPrefix + "/broker/connection/zen/state")
+	require.True(t, ok)
+	require.Contains(t, string(pk.Payload), `"state":"disconnected"`)
+}
+
+func TestEstablishConnectionEventsTakeover(t *testing.T) {
+	s := New(&Options{
+		Logger:           logger,
+		InlineClient:     true,
+		ConnectionEvents: true,
+	})
+	_ = s.AddHook(new(AllowHook), nil)
+	defer s.Close()
+
+	events := make(chan string, 4)
+	err := s.Subscribe(SysPrefix+"/broker/connection/+/state", 1, func(cl *Client, sub packets.Subscription, pk packets.Packet) {
+		var ev ConnectionEvent
+		require.NoError(t, json.Unmarshal(pk.Payload, &ev))
+		events <- ev.State
+	})
+	require.NoError(t, err)
+
+	connect := func() (net.Conn, chan error) {
+		r, w := net.Pipe()
+		o := make(chan error, 1)
+		go func() {
+			o <- s.EstablishConnection("tcp", r)
+			_ = r.Close()
+		}()
+
+		_, err := w.Write(packets.TPacketData[packets.Connect].Get(packets.TConnectMqtt311).RawBytes)
+		require.NoError(t, err)
+		go func() {
+			_, _ = io.ReadAll(w)
+		}()
+		return w, o
+	}
+
+	w1, o1 := connect()
+	defer w1.Close()
+	require.Equal(t, ConnectionStateConnected, <-events)
+
+	w2, o2 := connect()
+	defer w2.Close()
+	<-o1 // the first connection ends when its session is taken over
+	require.Equal(t, ConnectionStateConnected, <-events)
+
+	pk, ok := s.Topics.Retained.Get(SysPrefix + "/broker/connection/zen/state")
+	require.True(t, ok)
+	require.Contains(t, string(pk.Payload), `"state":"connected"`)
+
+	_, err = w2.Write(packets.TPacketData[packets.Disconnect].Get(packets.TDisconnect).RawBytes)
+	require.NoError(t, err)
+	require.NoError(t, <-o2)
+	require.Equal(t, ConnectionStateDisconnected, <-events)
+	require.Empty(t, events)
+}
+
+func TestPublishConnectionEventCustomTopic(t *testing.T) {
+	s := newServer()
+	s.Options.ConnectionEvents = true
+	s.Options.ConnectionEventsTopic = "presence/{clientid}"
+
+	cl, _, _ := newTestClient()
+	s.publishConnectionEvent(cl, ConnectionStateDisconnected, packets.ErrServerShuttingDown, DisconnectShutdown)
+
+	pk, ok := s.Topics.Retained.Get("presence/mochi")
+	require.True(t, ok)
+
+	var ev ConnectionEvent
+	require.NoError(t, json.Unmarshal(pk.Payload, &ev))
+	require.Equal(t, ConnectionStateDisconnected, ev.State)
+	require.Equal(t, packets.ErrServerShuttingDown.Error(), ev.Error)
+	require.Equal(t, string(DisconnectShutdown), ev.Reason)
+}
+
+func TestPublishConnectionEventDisabled(t *testing.T) {
+	s := newServer()
+	cl, _, _ := newTestClient()
+	s.publishConnectionEvent(cl, ConnectionStateConnected, nil, "")
+	require.Equal(t, 0, s.Topics.Retained.Len())
+}
+
 func TestEstablishConnectionAckFailure(t *testing.T) {
 	s := newServer()
 	defer s.Close()
@@ -979,6 +1295,161 @@ func TestEstablishConnectionMaximumClientsReached(t *testing.T) {
 	_ = r.Close()
 }
 
+func TestEstablishConnectionDescriptorHeadroom(t *testing.T) {
+	s := New(&Options{
+		Logger:             logger,
+		DescriptorHeadroom: 10,
+	})
+	s.fdLimit = 10
+	_ = s.AddHook(new(AllowHook), nil)
+	defer s.Close()
+
+	r, w := net.Pipe()
+	o := make(chan error)
+	go func() {
+		o <- s.EstablishConnection("tcp", r)
+	}()
+
+	go func() {
+		_, _ = w.Write(packets.TPacketData[packets.Connect].Get(packets.TConnectClean).RawBytes)
+	}()
+
+	// receive the connack
+	recv := make(chan []byte)
+	go func() {
+		buf, err := io.ReadAll(w)
+		require.NoError(t, err)
+		recv <- buf
+	}()
+
+	err := <-o
+	require.ErrorIs(t, err, packets.ErrServerUnavailable)
+	_ = r.Close()
+	require.Equal(t, packets.Err3ServerUnavailable.Code, (<-recv)[3])
+	require.Equal(t, int64(0), atomic.LoadInt64(&s.openConns))
+}
+
+func TestServerDescriptorsExhausted(t *testing.T) {
+	s := New(&Options{Logger: logger})
+	s.fdLimit = 100
+	s.openConns = 95
+	require.False(t, s.descriptorsExhausted())
+
+	s.Options.DescriptorHeadroom = 10
+	require.True(t, s.descriptorsExhausted())
+
+	s.openConns = 90
+	require.False(t, s.descriptorsExhausted())
+
+	s.fdLimit = 0
+	s.openConns = 1000
+	require.False(t, s.descriptorsExhausted())
+}
+
+func TestServerEnforceUsernameConnections(t *testing.T) {
+	s := newServer()
+	defer s.Close()
+
+	var existing []*Client
+	for i := 0; i < 2; i++ {
+		cl, r, _ := newTestClient()
+		go func() { _, _ = io.Copy(io.Discard, r) }()
+		cl.ID = "mochi-" + strconv.Itoa(i)
+		cl.Properties.Username = []byte("shared")
+		cl.State.connected = int64(i + 1)
+		s.Clients.Add(cl)
+		existing = append(existing, cl)
+	}
+
+	cl, _, _ := newTestClient()
+	cl.ID = "mochi-new"
+	cl.Properties.Username = []byte("shared")
+	cl.Properties.ProtocolVersion = 5
+	require.Equal(t, packets.CodeSuccess, s.enforceUsernameConnections(cl)) // no limit
+
+	s.Options.Capabilities.MaximumUsernameConnections = 2
+	require.Equal(t, packets.ErrQuotaExceeded, s.enforceUsernameConnections(cl))
+	cl.Properties.ProtocolVersion = 4
+	require.Equal(t, packets.ErrServerUnavailable, s.enforceUsernameConnections(cl))
+
+	// a reconnection of an existing client id is not counted.
+	cl.ID = "mochi-1"
+	require.Equal(t, packets.CodeSuccess, s.enforceUsernameConnections(cl))
+	cl.ID = "mochi-new"
+
+	s.Options.Capabilities.EvictOldestUsernameConnection = true
+	require.Equal(t, packets.CodeSuccess, s.enforceUsernameConnections(cl))
+	require.True(t, existing[0].Closed())
+	require.False(t, existing[1].Closed())
+	require.ErrorIs(t, existing[0].StopCause(), packets.ErrQuotaExceeded)
+}
+
+func TestEstablishConnectionUsernameLimit(t *testing.T) {
+	s := newServer()
+	s.Options.Capabilities.MaximumUsernameConnections = 1
+	defer s.Close()
+
+	existing, _, _ := newTestClient()
+	existing.ID = "other"
+	existing.Properties.Username = []byte("mochi")
+	s.Clients.Add(existing)
+
+	r, w := net.Pipe()
+	o := make(chan error)
+	go func() {
+		o <- s.EstablishConnection("tcp", r)
+	}()
+
+	go func() {
+		_, _ = w.Write(packets.TPacketData[packets.Connect].Get(packets.TConnectUserPassLWT).RawBytes)
+	}()
+
+	recv := make(chan []byte)
+	go func() {
+		buf, err := io.ReadAll(w)
+		require.NoError(t, err)
+		recv <- buf
+	}()
+
+	err := <-o
+	require.ErrorIs(t, err, packets.ErrServerUnavailable)
+	_ = r.Close()
+	require.Equal(t, packets.Err3ServerUnavailable.Code, (<-recv)[3])
+}
+
+func TestEstablishConnectionBanned(t *testing.T) {
+	s := newServer()
+	defer s.Close()
+
+	_, err := s.Ban("zen", "", 0, "test")
+	require.NoError(t, err)
+
+	r, w := net.Pipe()
+	o := make(chan error)
+	go func() {
+		o <- s.EstablishConnection("tcp", r)
+	}()
+
+	go func() {
+		_, _ = w.Write(packets.TPacketData[packets.Connect].Get(packets.TConnectClean).RawBytes)
+	}()
+
+	// receive the connack
+	recv := make(chan []byte)
+	go func() {
+		buf, err := io.ReadAll(w)
+		require.NoError(t, err)
+		recv <- buf
+	}()
+
+	err = <-o
+	require.Error(t, err)
+	require.ErrorIs(t, err, packets.ErrBanned)
+	require.Equal(t, packets.TPacketData[packets.Connack].Get(packets.TConnackBadUsernamePasswordNoSession).RawBytes, <-recv)
+
+	_ = r.Close()
+}
+
 // See https://github.com/mochi-mqtt/server/issues/178
 func TestServerEstablishConnectionZeroByteUsernameIsValid(t *testing.T) {
 	s := newServer()
@@ -1072,15 +1543,45 @@ func TestServerEstablishConnectionOnConnectError(t *testing.T) {
 	_ = r.Close()
 }
 
-func TestServerSendConnack(t *testing.T) {
+func TestServerEstablishConnectionOnConnectCode(t *testing.T) {
 	s := newServer()
-	cl, r, w := newTestClient()
-	cl.Properties.ProtocolVersion = 5
-	s.Options.Capabilities.MaximumQos = 1
-	cl.Properties.Props = packets.Properties{
-		AssignedClientID: "mochi",
-	}
-	go func() {
+	hook := new(modifiedHookBase)
+	hook.err = packets.ErrClientIdentifierNotValid
+	err := s.AddHook(hook, nil)
+	require.NoError(t, err)
+
+	r, w := net.Pipe()
+	o := make(chan error)
+	go func() {
+		o <- s.EstablishConnection("tcp", r)
+	}()
+
+	go func() {
+		_, _ = w.Write(packets.TPacketData[packets.Connect].Get(packets.TConnectMqtt5).RawBytes)
+	}()
+
+	recv := make(chan []byte)
+	go func() {
+		buf, err := io.ReadAll(w)
+		require.NoError(t, err)
+		recv <- buf
+	}()
+
+	err = <-o
+	require.ErrorIs(t, err, packets.ErrClientIdentifierNotValid)
+	_ = r.Close()
+	require.Equal(t, packets.ErrClientIdentifierNotValid.Code, (<-recv)[3])
+}
+
+func TestServerSendConnack(t *testing.T) {
+	s := newServer()
+	cl, r, w := newTestClient()
+	cl.Properties.ProtocolVersion = 5
+	s.Options.Capabilities.MaximumQos = 1
+	cl.Properties.Props = packets.Properties{
+		AssignedClientID: "mochi",
+	}
+	go func() {
 		err := s.SendConnack(cl, packets.CodeSuccess, true, nil)
 		require.NoError(t, err)
 		_ = w.Close()
@@ -1238,6 +1739,180 @@ func TestInheritClientSession(t *testing.T) {
 	require.Equal(t, 0, cl.State.Subscriptions.Len())
 }
 
+func TestInheritClientSessionLifecycle(t *testing.T) {
+	s := newServer()
+
+	existing, _, _ := newTestClient()
+	existing.ID = "mochi"
+	existing.State.created = 100
+	existing.Stop(packets.ErrKeepAliveTimeout)
+	s.Clients.Add(existing)
+
+	cl, _, _ := newTestClient()
+	cl.Properties.ProtocolVersion = 5
+	require.True(t, s.inheritClientSession(packets.Packet{Connect: packets.ConnectParams{ClientIdentifier: "mochi"}}, cl))
+
+	sess := cl.Session()
+	require.Equal(t, int64(100), sess.Created)
+	require.Equal(t, existing.State.disconnected, sess.LastDisconnected)
+	require.Equal(t, packets.ErrKeepAliveTimeout.Error(), sess.DisconnectReason)
+}
+
+func TestServerSessions(t *testing.T) {
+	s := newServer()
+
+	for _, id := range []string{"zen", "mochi"} {
+		cl, _, _ := newTestClient()
+		cl.ID = id
+		cl.State.created = 100
+		s.Clients.Add(cl)
+	}
+
+	zen, _ := s.Clients.Get("zen")
+	zen.Stop(packets.ErrKeepAliveTimeout)
+
+	sessions := s.Sessions()
+	require.Len(t, sessions, 2)
+	require.Equal(t, "mochi", sessions[0].ClientID)
+	require.True(t, sessions[0].Connected)
+	require.Equal(t, "zen", sessions[1].ClientID)
+	require.False(t, sessions[1].Connected)
+	require.Equal(t, int64(100), sessions[1].Created)
+	require.Equal(t, packets.ErrKeepAliveTimeout.Error(), sessions[1].DisconnectReason)
+}
+
+func TestServerForgetClient(t *testing.T) {
+	s := newServer()
+
+	cl, _, _ := newTestClient()
+	cl.ID = "mochi"
+	cl.Stop(packets.ErrKeepAliveTimeout)
+	cl.State.Subscriptions.Add("a/b", packets.Subscription{Filter: "a/b"})
+	s.Topics.Subscribe(cl.ID, packets.Subscription{Filter: "a/b"})
+	cl.State.Inflight.Set(packets.Packet{PacketID: 1})
+	s.Clients.Add(cl)
+
+	retain := func(topic, origin string) {
+		s.retainMessage(cl, packets.Packet{
+			FixedHeader: packets.FixedHeader{Type: packets.Publish, Retain: true},
+			TopicName:   topic,
+			Payload:     []byte("hello"),
+			Origin:      origin,
+		})
+	}
+	retain("a/b", "mochi")
+	retain("c/d", "zen")
+	s.loop.willDelayed.Add("mochi", packets.Packet{TopicName: "will"})
+
+	require.NoError(t, s.ForgetClient("mochi", true))
+
+	_, ok := s.Clients.Get("mochi")
+	require.False(t, ok)
+	require.Equal(t, 0, cl.State.Inflight.Len())
+	require.Empty(t, s.Topics.Subscribers("a/b").Subscriptions)
+	require.Equal(t, 0, s.loop.willDelayed.Len())
+
+	_, ok = s.Topics.Retained.Get("a/b")
+	require.False(t, ok)
+	_, ok = s.Topics.Retained.Get("c/d")
+	require.True(t, ok)
+}
+
+func TestServerForgetClientKeepRetained(t *testing.T) {
+	s := newServer()
+	s.retainMessage(s.NewClient(nil, "", "mochi", false), packets.Packet{
+		FixedHeader: packets.FixedHeader{Type: packets.Publish, Retain: true},
+		TopicName:   "a/b",
+		Payload:     []byte("hello"),
+		Origin:      "mochi",
+	})
+
+	require.NoError(t, s.ForgetClient("mochi", false))
+	_, ok := s.Topics.Retained.Get("a/b")
+	require.True(t, ok)
+}
+
+func TestServerForgetClientConnected(t *testing.T) {
+	s := newServer()
+
+	cl, r, _ := newTestClient()
+	cl.ID = "mochi"
+	cl.Properties.Will = Will{Flag: 1, TopicName: "will", Payload: []byte("gone")}
+	s.Clients.Add(cl)
+	go func() {
+		_, _ = io.Copy(io.Discard, r)
+	}()
+
+	require.NoError(t, s.ForgetClient("mochi", false))
+	require.True(t, cl.Closed())
+	require.Equal(t, uint32(1), cl.State.forgotten)
+	require.Equal(t, uint32(0), cl.Properties.Will.Flag)
+	_ = r.Close()
+}
+
+func TestServerForgetClientHookError(t *testing.T) {
+	s := newServer()
+	hook := new(modifiedHookBase)
+	hook.fail = true
+	require.NoError(t, s.AddHook(hook, nil))
+
+	err := s.ForgetClient("mochi", false)
+	require.ErrorIs(t, err, errTestHook)
+}
+
+func TestInheritClientSessionOfflineMessageAge(t *testing.T) {
+	s := newServer()
+	s.Options.Capabilities.MaximumOfflineMessageAge = 10
+
+	n := time.Now().Unix()
+	existing, _, _ := newTestClient()
+	existing.Net.Conn = nil
+	existing.ID = "mochi"
+	existing.State.Inflight.Set(packets.Packet{PacketID: 1, Created: n - 20})
+	existing.State.Inflight.Set(packets.Packet{PacketID: 2, Created: n - 1})
+	existing.Stop(nil)
+	s.Clients.Add(existing)
+
+	cl, _, _ := newTestClient()
+	cl.Properties.ProtocolVersion = 4
+	b := s.inheritClientSession(packets.Packet{Connect: packets.ConnectParams{ClientIdentifier: "mochi"}}, cl)
+	require.True(t, b)
+	require.Equal(t, 1, cl.State.Inflight.Len())
+	_, ok := cl.State.Inflight.Get(2)
+	require.True(t, ok)
+}
+
+func TestInheritClientSessionDisconnectsExisting(t *testing.T) {
+	s := newServer()
+
+	existing, r, _ := newTestClient()
+	existing.ID = "mochi"
+	existing.Properties.ProtocolVersion = 5
+	s.Clients.Add(existing)
+
+	recv := make(chan []byte)
+	go func() {
+		buf, err := io.ReadAll(r)
+		require.NoError(t, err)
+		recv <- buf
+	}()
+
+	cl, _, _ := newTestClient()
+	cl.Properties.ProtocolVersion = 5
+	s.inheritClientSession(packets.Packet{Connect: packets.ConnectParams{ClientIdentifier: "mochi"}}, cl)
+
+	select {
+	case buf := <-recv:
+		require.GreaterOrEqual(t, len(buf), 3)
+		require.Equal(t, packets.Disconnect<<4, buf[0])
+		require.Equal(t, packets.ErrSessionTakenOver.Code, buf[2]) // [MQTT-3.1.4-3]
+	case <-time.After(time.Second):
+		require.Fail(t, "disconnect not received by existing client")
+	}
+
+	require.True(t, existing.Closed())
+}
+
 func TestServerUnsubscribeClient(t *testing.T) {
 	s := newServer()
 	cl, _, _ := newTestClient()
@@ -1251,11 +1926,69 @@ func TestServerUnsubscribeClient(t *testing.T) {
 	require.Equal(t, 0, len(subs.Subscriptions))
 }
 
+func TestServerAddClientSubscription(t *testing.T) {
+	s := newServer()
+	s.Options.Capabilities.MaximumQos = 1
+	cl, _, _ := newTestClient()
+	s.Clients.Add(cl)
+	s.Topics.RetainMessage(*packets.TPacketData[packets.Publish].Get(packets.TPublishRetain).Packet)
+
+	err := s.AddClientSubscription(cl.ID, packets.Subscription{Filter: "a/b/c", Qos: 2})
+	require.NoError(t, err)
+	sub, ok := cl.State.Subscriptions.Get("a/b/c")
+	require.True(t, ok)
+	require.Equal(t, byte(1), sub.Qos)
+	require.Equal(t, 1, len(s.Topics.Subscribers("a/b/c").Subscriptions))
+	require.Equal(t, int64(1), atomic.LoadInt64(&s.Info.Subscriptions))
+	require.Len(t, cl.State.outbound, 1) // retained message delivered
+
+	err = s.RemoveClientSubscription(cl.ID, "a/b/c")
+	require.NoError(t, err)
+	_, ok = cl.State.Subscriptions.Get("a/b/c")
+	require.False(t, ok)
+	require.Equal(t, 0, len(s.Topics.Subscribers("a/b/c").Subscriptions))
+	require.Equal(t, int64(0), atomic.LoadInt64(&s.Info.Subscriptions))
+}
+
+func TestServerAddClientSubscriptionInvalid(t *testing.T) {
+	s := newServer()
+	cl, _, _ := newTestClient()
+	s.Clients.Add(cl)
+
+	err := s.AddClientSubscription("unknown", packets.Subscription{Filter: "a/b/c"})
+	require.ErrorIs(t, err, ErrClientNotFound)
+
+	err = s.AddClientSubscription(cl.ID, packets.Subscription{Filter: "a/#/c"})
+	require.ErrorIs(t, err, packets.ErrTopicFilterInvalid)
+
+	err = s.AddClientSubscription(cl.ID, packets.Subscription{Filter: SharePrefix + "/g/a", NoLocal: true})
+	require.ErrorIs(t, err, packets.ErrProtocolViolationInvalidSharedNoLocal)
+
+	err = s.RemoveClientSubscription("unknown", "a/b/c")
+	require.ErrorIs(t, err, ErrClientNotFound)
+
+	err = s.RemoveClientSubscription(cl.ID, "a/#/c")
+	require.ErrorIs(t, err, packets.ErrTopicFilterInvalid)
+}
+
+func TestServerAddClientSubscriptionOffline(t *testing.T) {
+	s := newServer()
+	cl, _, _ := newTestClient()
+	cl.Stop(nil)
+	s.Clients.Add(cl)
+	s.Topics.RetainMessage(*packets.TPacketData[packets.Publish].Get(packets.TPublishRetain).Packet)
+
+	err := s.AddClientSubscription(cl.ID, packets.Subscription{Filter: "a/b/c"})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(s.Topics.Subscribers("a/b/c").Subscriptions))
+	require.Len(t, cl.State.outbound, 0)
+}
+
 func TestServerProcessPacketFailure(t *testing.T) {
 	s := newServer()
 	cl, _, _ := newTestClient()
 	err := s.processPacket(cl, packets.Packet{})
-	require.Error(t, err)
+	require.ErrorIs(t, err, packets.ErrNoValidPacketAvailable)
 }
 
 func TestServerProcessPacketConnect(t *testing.T) {
@@ -1380,6 +2113,116 @@ func TestServerPublishNoInlineClient(t *testing.T) {
 	require.ErrorIs(t, err, ErrInlineClientNotEnabled)
 }
 
+func TestServerPublishWithReport(t *testing.T) {
+	s := newServerWithInlineClient()
+	s.Options.ReservedTopicPrefixes = []string{"a"}
+
+	online, _, _ := newTestClient()
+	online.ID = "online"
+	s.Clients.Add(online)
+	s.Topics.Subscribe(online.ID, packets.Subscription{Filter: "a/b/c"})
+
+	offline, _, _ := newTestClient()
+	offline.ID = "offline"
+	offline.Stop(nil)
+	s.Clients.Add(offline)
+	s.Topics.Subscribe(offline.ID, packets.Subscription{Filter: "a/+/c"})
+
+	wildcard, _, _ := newTestClient()
+	wildcard.ID = "wildcard"
+	s.Clients.Add(wildcard)
+	s.Topics.Subscribe(wildcard.ID, packets.Subscription{Filter: "#"})
+
+	err := s.Subscribe("a/b/c", 1, func(cl *Client, sub packets.Subscription, pk packets.Packet) {})
+	require.NoError(t, err)
+
+	report, err := s.PublishWithReport("a/b/c", []byte("hello"), true, 0)
+	require.NoError(t, err)
+	require.Equal(t, "a/b/c", report.Topic)
+	require.Equal(t, []string{"offline", "online", "wildcard"}, report.Matched)
+	require.Equal(t, []string{"online"}, report.Delivered)
+	require.Equal(t, packets.CodeDisconnect.Error(), report.Skipped["offline"])
+	require.Equal(t, "excluded by subscription", report.Skipped["wildcard"])
+	require.Equal(t, 1, report.Inline)
+	require.Empty(t, report.Rejected)
+	require.Len(t, online.State.outbound, 1)
+	require.Len(t, s.Topics.Messages("a/b/c"), 1)
+}
+
+func TestServerPublishWithReportStampsMessage(t *testing.T) {
+	s := newServerWithInlineClient()
+	s.Options.TraceMessages = true
+	s.Options.TraceProperty = defaultTraceProperty
+	s.Options.DeliveryLatency = true
+
+	cl, _, _ := newTestClient()
+	s.Clients.Add(cl)
+	s.Topics.Subscribe(cl.ID, packets.Subscription{Filter: "a/b/c"})
+
+	report, err := s.PublishWithReport("a/b/c", []byte("hello"), false, 0)
+	require.NoError(t, err)
+	require.Equal(t, []string{cl.ID}, report.Delivered)
+	require.Equal(t, int64(1), atomic.LoadInt64(&s.Info.MessagesReceived))
+	require.Equal(t, int64(1), atomic.LoadInt64(&s.Info.PacketsReceived))
+
+	pk := <-cl.State.outbound
+	require.Len(t, pk.TraceID, 32)
+	require.Equal(t, s.inlineClient.ID, pk.Origin)
+	require.NotEqual(t, int64(0), pk.Created)
+	require.NotEqual(t, int64(0), pk.Received)
+}
+
+func TestServerPublishWithReportRejected(t *testing.T) {
+	s := newServerWithInlineClient()
+	hook := new(modifiedHookBase)
+	hook.fail = true
+	hook.err = packets.ErrRejectPacket
+	err := s.AddHook(hook, nil)
+	require.NoError(t, err)
+
+	cl, _, _ := newTestClient()
+	s.Clients.Add(cl)
+	s.Topics.Subscribe(cl.ID, packets.Subscription{Filter: "a/b/c"})
+
+	report, err := s.PublishWithReport("a/b/c", []byte("hello"), false, 0)
+	require.NoError(t, err)
+	require.Equal(t, packets.ErrRejectPacket.Error(), report.Rejected)
+	require.Empty(t, report.Matched)
+	require.Len(t, cl.State.outbound, 0)
+}
+
+func TestServerPublishWithReportRejectedCode(t *testing.T) {
+	s := newServerWithInlineClient()
+	hook := new(modifiedHookBase)
+	hook.fail = true
+	hook.err = packets.ErrNotAuthorized
+	err := s.AddHook(hook, nil)
+	require.NoError(t, err)
+
+	cl, _, _ := newTestClient()
+	s.Clients.Add(cl)
+	s.Topics.Subscribe(cl.ID, packets.Subscription{Filter: "a/b/c", Qos: 1})
+
+	report, err := s.PublishWithReport("a/b/c", []byte("hello"), false, 0)
+	require.NoError(t, err)
+	require.Equal(t, packets.ErrNotAuthorized.Error(), report.Rejected)
+	require.Empty(t, report.Matched)
+	require.Len(t, cl.State.outbound, 0)
+
+	s.inlineClient.Properties.ProtocolVersion = 5
+	report, err = s.PublishWithReport("a/b/c", []byte("hello"), false, 1)
+	require.NoError(t, err)
+	require.Equal(t, packets.ErrNotAuthorized.Error(), report.Rejected)
+	require.Empty(t, report.Matched)
+	require.Len(t, cl.State.outbound, 0)
+}
+
+func TestServerPublishWithReportNoInlineClient(t *testing.T) {
+	s := newServer()
+	_, err := s.PublishWithReport("a/b/c", []byte("hello"), false, 0)
+	require.ErrorIs(t, err, ErrInlineClientNotEnabled)
+}
+
 func TestInjectPacketError(t *testing.T) {
 	s := newServer()
 	defer s.Close()
@@ -1627,40 +2470,274 @@ func TestServerProcessPacketPublishMaximumReceive(t *testing.T) {
 	require.Equal(t, packets.TPacketData[packets.Disconnect].Get(packets.TDisconnectReceiveMaximum).RawBytes, buf)
 }
 
-func TestServerProcessPublishInvalidTopic(t *testing.T) {
+func TestServerTraceMessage(t *testing.T) {
 	s := newServer()
-	_ = s.Serve()
-	defer s.Close()
+	s.Options.TraceProperty = defaultTraceProperty
+
+	user := []packets.UserProperty{{Key: "k", Val: "v"}, {Key: "x", Val: "y"}}
+	pk := packets.Packet{Properties: packets.Properties{User: user[:1]}}
+	s.traceMessage(&pk)
+	require.Len(t, pk.TraceID, 32)
+	require.Equal(t, []packets.UserProperty{{Key: "k", Val: "v"}, {Key: defaultTraceProperty, Val: pk.TraceID}}, pk.Properties.User)
+	require.Equal(t, "x", user[1].Key) // shared backing array is not overwritten
+
+	pk2 := packets.Packet{}
+	s.traceMessage(&pk2)
+	require.NotEqual(t, pk.TraceID, pk2.TraceID)
+}
+
+func TestServerTraceMessageExisting(t *testing.T) {
+	s := newServer()
+	s.Options.TraceProperty = defaultTraceProperty
+
+	pk := packets.Packet{Properties: packets.Properties{User: []packets.UserProperty{{Key: defaultTraceProperty, Val: "upstream"}}}}
+	s.traceMessage(&pk)
+	require.Equal(t, "upstream", pk.TraceID)
+	require.Len(t, pk.Properties.User, 1)
+}
+
+func TestServerProcessPublishTraceMessages(t *testing.T) {
+	s := New(&Options{
+		Logger:        logger,
+		TraceMessages: true,
+	})
+	_ = s.AddHook(new(AllowHook), nil)
+	require.Equal(t, defaultTraceProperty, s.Options.TraceProperty)
+
 	cl, _, _ := newTestClient()
-	err := s.processPublish(cl, *packets.TPacketData[packets.Publish].Get(packets.TPublishSpecDenySysTopic).Packet)
-	require.NoError(t, err) // $SYS Topics should be ignored?
+	s.Clients.Add(cl)
+
+	pk := *packets.TPacketData[packets.Publish].Get(packets.TPublishRetain).Packet
+	err := s.processPublish(cl, pk)
+	require.NoError(t, err)
+
+	pkx, ok := s.Topics.Retained.Get(pk.TopicName)
+	require.True(t, ok)
+	require.Len(t, pkx.TraceID, 32)
+	require.Contains(t, pkx.Properties.User, packets.UserProperty{Key: defaultTraceProperty, Val: pkx.TraceID})
 }
 
-func TestServerProcessPublishACLCheckDeny(t *testing.T) {
-	tt := []struct {
-		name             string
-		protocolVersion  byte
-		pk               packets.Packet
-		expectErr        error
-		expectReponse    []byte
-		expectDisconnect bool
-	}{
-		{
-			name:             "v4_QOS0",
-			protocolVersion:  4,
-			pk:               *packets.TPacketData[packets.Publish].Get(packets.TPublishBasic).Packet,
-			expectErr:        nil,
-			expectReponse:    nil,
-			expectDisconnect: false,
-		},
-		{
-			name:             "v4_QOS1",
-			protocolVersion:  4,
-			pk:               *packets.TPacketData[packets.Publish].Get(packets.TPublishQos1).Packet,
-			expectErr:        packets.ErrNotAuthorized,
-			expectReponse:    nil,
-			expectDisconnect: true,
-		},
+func TestServerProcessPublishDeliveryLatency(t *testing.T) {
+	s := New(&Options{
+		Logger:          logger,
+		DeliveryLatency: true,
+	})
+	_ = s.AddHook(new(AllowHook), nil)
+
+	cl, _, _ := newTestClient()
+	s.Clients.Add(cl)
+
+	pk := *packets.TPacketData[packets.Publish].Get(packets.TPublishRetain).Packet
+	err := s.processPublish(cl, pk)
+	require.NoError(t, err)
+
+	pkx, ok := s.Topics.Retained.Get(pk.TopicName)
+	require.True(t, ok)
+	require.NotEqual(t, int64(0), pkx.Received)
+
+	// retained messages are not measured when delivered to new subscribers
+	sub, _, _ := newTestClient()
+	out, err := s.publishToClient(sub, packets.Subscription{Filter: pk.TopicName, FwdRetainedFlag: true}, pkx)
+	require.NoError(t, err)
+	require.Equal(t, int64(0), out.Received)
+}
+
+func TestServerProcessPublishBackpressure(t *testing.T) {
+	s := New(&Options{
+		Logger:                logger,
+		BackpressureWatermark: 1,
+		BackpressureTimeout:   30,
+	})
+	_ = s.AddHook(new(AllowHook), nil)
+
+	cl, r, _ := newTestClient()
+	s.Clients.Add(cl)
+	go func() { _, _ = io.Copy(io.Discard, r) }()
+
+	sub, sr, _ := newTestClient()
+	sub.ID = "sub"
+	s.Clients.Add(sub)
+	s.Topics.Subscribe(sub.ID, packets.Subscription{Filter: "a/b/c", Qos: 1})
+	go func() { _, _ = io.Copy(io.Discard, sr) }()
+	atomic.StoreInt32(&sub.State.outboundQty, 1)
+
+	pk := *packets.TPacketData[packets.Publish].Get(packets.TPublishQos1).Packet
+	start := time.Now()
+	err := s.processPublish(cl, pk)
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, time.Since(start), time.Millisecond*30)
+	require.Equal(t, int64(1), atomic.LoadInt64(&s.Info.MessagesThrottled))
+
+	// the publish is released as soon as the subscriber catches up
+	s.Options.BackpressureTimeout = 10000
+	go func() {
+		time.Sleep(time.Millisecond * 10)
+		atomic.StoreInt32(&sub.State.outboundQty, 0)
+		sub.signalDrained()
+	}()
+
+	start = time.Now()
+	err = s.processPublish(cl, pk)
+	require.NoError(t, err)
+	require.Less(t, time.Since(start), time.Second)
+	require.Equal(t, int64(2), atomic.LoadInt64(&s.Info.MessagesThrottled))
+}
+
+func TestServerProcessPublishBackpressureQos0NotHeld(t *testing.T) {
+	s := New(&Options{
+		Logger:                logger,
+		BackpressureWatermark: 1,
+		BackpressureTimeout:   10000,
+	})
+	_ = s.AddHook(new(AllowHook), nil)
+
+	cl, _, _ := newTestClient()
+	s.Clients.Add(cl)
+
+	sub, _, _ := newTestClient()
+	sub.ID = "sub"
+	s.Clients.Add(sub)
+	s.Topics.Subscribe(sub.ID, packets.Subscription{Filter: "a/b/c"})
+	atomic.StoreInt32(&sub.State.outboundQty, 1)
+
+	start := time.Now()
+	err := s.processPublish(cl, *packets.TPacketData[packets.Publish].Get(packets.TPublishBasic).Packet)
+	require.NoError(t, err)
+	require.Less(t, time.Since(start), time.Second)
+	require.Equal(t, int64(0), atomic.LoadInt64(&s.Info.MessagesThrottled))
+}
+
+func TestServerApplyBackpressureDeadlineUsesClock(t *testing.T) {
+	clock := new(steppedClock)
+	clock.unix.Store(1000)
+	s := New(&Options{
+		Logger:                logger,
+		Clock:                 clock,
+		BackpressureWatermark: 1,
+		BackpressureTimeout:   10000,
+	})
+
+	cl, _, _ := newTestClient()
+	s.Clients.Add(cl)
+
+	sub, _, _ := newTestClient()
+	sub.ID = "sub"
+	s.Clients.Add(sub)
+	s.Topics.Subscribe(sub.ID, packets.Subscription{Filter: "a/b/c"})
+	atomic.StoreInt32(&sub.State.outboundQty, 1)
+
+	// the subscriber writes one message but remains saturated, after the deadline has passed on the server clock.
+	go func() {
+		time.Sleep(time.Millisecond * 10)
+		clock.unix.Add(11)
+		sub.signalDrained()
+	}()
+
+	start := time.Now()
+	pk := packets.Packet{TopicName: "a/b/c", FixedHeader: packets.FixedHeader{Type: packets.Publish, Qos: 1}}
+	s.applyBackpressure(cl, pk, s.selectSubscribers(pk))
+	require.Less(t, time.Since(start), time.Second)
+	require.Equal(t, int64(1), atomic.LoadInt64(&s.Info.MessagesThrottled))
+}
+
+func TestServerApplyBackpressureSharedSubscribers(t *testing.T) {
+	s := New(&Options{
+		Logger:                logger,
+		BackpressureWatermark: 1,
+		BackpressureTimeout:   10,
+	})
+
+	cl, _, _ := newTestClient()
+	s.Clients.Add(cl)
+
+	sub, _, _ := newTestClient()
+	sub.ID = "sub"
+	s.Clients.Add(sub)
+	s.Topics.Subscribe(sub.ID, packets.Subscription{Filter: "$share/g/a/b/c"})
+	atomic.StoreInt32(&sub.State.outboundQty, 1)
+
+	pk := packets.Packet{TopicName: "a/b/c", FixedHeader: packets.FixedHeader{Type: packets.Publish, Qos: 1}}
+	s.applyBackpressure(cl, pk, s.selectSubscribers(pk))
+	require.Equal(t, int64(1), atomic.LoadInt64(&s.Info.MessagesThrottled))
+}
+
+func TestServerProcessPublishBackpressureDisabled(t *testing.T) {
+	s := newServer()
+	_ = s.AddHook(new(AllowHook), nil)
+	require.Equal(t, int64(0), s.Options.BackpressureTimeout)
+
+	cl, _, _ := newTestClient()
+	s.Clients.Add(cl)
+
+	sub, _, _ := newTestClient()
+	sub.ID = "sub"
+	s.Clients.Add(sub)
+	s.Topics.Subscribe(sub.ID, packets.Subscription{Filter: "a/b/c"})
+	atomic.StoreInt32(&sub.State.outboundQty, 10)
+
+	err := s.processPublish(cl, *packets.TPacketData[packets.Publish].Get(packets.TPublishBasic).Packet)
+	require.NoError(t, err)
+	require.Equal(t, int64(0), atomic.LoadInt64(&s.Info.MessagesThrottled))
+}
+
+func TestServerApplyBackpressureIgnoresClosedAndSelf(t *testing.T) {
+	s := New(&Options{
+		Logger:                logger,
+		BackpressureWatermark: 1,
+	})
+	require.Equal(t, int64(defaultBackpressureTimeout), s.Options.BackpressureTimeout)
+
+	cl, _, _ := newTestClient()
+	s.Clients.Add(cl)
+	s.Topics.Subscribe(cl.ID, packets.Subscription{Filter: "a/b/c"})
+	atomic.StoreInt32(&cl.State.outboundQty, 1)
+
+	sub, _, _ := newTestClient()
+	sub.ID = "sub"
+	s.Clients.Add(sub)
+	s.Topics.Subscribe(sub.ID, packets.Subscription{Filter: "a/b/c"})
+	atomic.StoreInt32(&sub.State.outboundQty, 1)
+	sub.Stop(errClientStop)
+
+	pk := packets.Packet{TopicName: "a/b/c", FixedHeader: packets.FixedHeader{Type: packets.Publish, Qos: 1}}
+	s.applyBackpressure(cl, pk, s.selectSubscribers(pk))
+	require.Equal(t, int64(0), atomic.LoadInt64(&s.Info.MessagesThrottled))
+}
+
+func TestServerProcessPublishInvalidTopic(t *testing.T) {
+	s := newServer()
+	_ = s.Serve()
+	defer s.Close()
+	cl, _, _ := newTestClient()
+	err := s.processPublish(cl, *packets.TPacketData[packets.Publish].Get(packets.TPublishSpecDenySysTopic).Packet)
+	require.NoError(t, err) // $SYS Topics should be ignored?
+}
+
+func TestServerProcessPublishACLCheckDeny(t *testing.T) {
+	tt := []struct {
+		name             string
+		protocolVersion  byte
+		pk               packets.Packet
+		expectErr        error
+		expectReponse    []byte
+		expectDisconnect bool
+	}{
+		{
+			name:             "v4_QOS0",
+			protocolVersion:  4,
+			pk:               *packets.TPacketData[packets.Publish].Get(packets.TPublishBasic).Packet,
+			expectErr:        nil,
+			expectReponse:    nil,
+			expectDisconnect: false,
+		},
+		{
+			name:             "v4_QOS1",
+			protocolVersion:  4,
+			pk:               *packets.TPacketData[packets.Publish].Get(packets.TPublishQos1).Packet,
+			expectErr:        packets.ErrNotAuthorized,
+			expectReponse:    nil,
+			expectDisconnect: true,
+		},
 		{
 			name:             "v4_QOS2",
 			protocolVersion:  4,
@@ -1732,6 +2809,137 @@ func TestServerProcessPublishACLCheckDeny(t *testing.T) {
 	}
 }
 
+func TestServerProcessPublishReservedTopic(t *testing.T) {
+	s := newServer()
+	s.Options.ReservedTopicPrefixes = []string{"a/"}
+	_ = s.Serve()
+	defer s.Close()
+
+	cl, r, w := newTestClient()
+	cl.Properties.ProtocolVersion = 5
+	s.Clients.Add(cl)
+
+	go func() {
+		err := s.processPublish(cl, *packets.TPacketData[packets.Publish].Get(packets.TPublishQos1Mqtt5).Packet)
+		require.NoError(t, err)
+		_ = w.Close()
+	}()
+
+	buf, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, packets.TPacketData[packets.Puback].Get(packets.TPubackMqtt5NotAuthorized).RawBytes, buf)
+	require.Len(t, s.Topics.Messages("a/b/c"), 0)
+	require.False(t, cl.Closed())
+}
+
+func TestServerProcessPublishReadOnly(t *testing.T) {
+	s := newServer()
+	_ = s.Serve()
+	defer s.Close()
+
+	cl, r, w := newTestClient()
+	cl.Properties.ProtocolVersion = 5
+	cl.State.readOnly = true
+	s.Clients.Add(cl)
+
+	go func() {
+		err := s.processPublish(cl, *packets.TPacketData[packets.Publish].Get(packets.TPublishRetainMqtt5).Packet)
+		require.NoError(t, err)
+		err = s.processPublish(cl, *packets.TPacketData[packets.Publish].Get(packets.TPublishQos1Mqtt5).Packet)
+		require.NoError(t, err)
+		_ = w.Close()
+	}()
+
+	buf, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, packets.TPacketData[packets.Puback].Get(packets.TPubackMqtt5NotAuthorized).RawBytes, buf)
+	require.Len(t, s.Topics.Messages("a/b/c"), 0)
+	require.False(t, cl.Closed())
+}
+
+func TestServerSendLWTReadOnly(t *testing.T) {
+	s := newServer()
+	cl, _, _ := newTestClient()
+	cl.State.readOnly = true
+	cl.Properties.Will = Will{
+		Flag:      1,
+		TopicName: "a/b/c",
+		Payload:   []byte("hello mochi"),
+		Retain:    true,
+	}
+
+	s.sendLWT(cl)
+	require.Len(t, s.Topics.Messages("a/b/c"), 0)
+}
+
+func TestServerProcessPublishReservedTopicSys(t *testing.T) {
+	s := newServer()
+	_ = s.Serve()
+	defer s.Close()
+
+	cl, _, _ := newTestClient()
+	s.Clients.Add(cl)
+	sub, _, _ := newTestClient()
+	sub.ID = "sub"
+	s.Clients.Add(sub)
+	s.Topics.Subscribe(sub.ID, packets.Subscription{Filter: "$SYS/#"})
+
+	pk := packets.Packet{
+		FixedHeader: packets.FixedHeader{Type: packets.Publish},
+		TopicName:   SysPrefix + "/broker/uptime",
+		Payload:     []byte("spoofed"),
+	}
+
+	err := s.processPublish(cl, pk)
+	require.NoError(t, err)
+	require.Len(t, sub.State.outbound, 0)
+
+	inline := s.NewClient(nil, LocalListener, InlineClientId, true)
+	err = s.processPublish(inline, pk)
+	require.NoError(t, err)
+	require.Len(t, sub.State.outbound, 1)
+}
+
+func TestPublishToClientReservedTopic(t *testing.T) {
+	s := newServer()
+	s.Options.ReservedTopicPrefixes = []string{"internal"}
+	cl, _, _ := newTestClient()
+	s.Clients.Add(cl)
+
+	pk := packets.Packet{
+		FixedHeader: packets.FixedHeader{Type: packets.Publish},
+		TopicName:   "internal/a",
+	}
+
+	for _, filter := range []string{"#", "+/a", "$share/g/#"} {
+		_, err := s.publishToClient(cl, packets.Subscription{Filter: filter}, pk)
+		require.NoError(t, err)
+		require.Len(t, cl.State.outbound, 0, filter)
+	}
+
+	for _, filter := range []string{"internal/#", "$share/g/internal/a"} {
+		_, err := s.publishToClient(cl, packets.Subscription{Filter: filter}, pk)
+		require.NoError(t, err)
+		require.Len(t, cl.State.outbound, 1, filter)
+		<-cl.State.outbound
+	}
+}
+
+func TestServerIsReservedTopic(t *testing.T) {
+	s := newServer()
+	s.Options.ReservedTopicPrefixes = []string{"internal/", "ops"}
+
+	require.True(t, s.isReservedTopic("$SYS/broker/uptime"))
+	require.True(t, s.isReservedTopic("$SYS"))
+	require.False(t, s.isReservedTopic("$SYSTEM/a"))
+	require.True(t, s.isReservedTopic("internal"))
+	require.True(t, s.isReservedTopic("internal/a/b"))
+	require.True(t, s.isReservedTopic("ops/a"))
+	require.False(t, s.isReservedTopic("internalx/a"))
+	require.False(t, s.isReservedTopic("a/internal"))
+	require.False(t, s.isReservedTopic("#"))
+}
+
 func TestServerProcessPublishOnMessageRecvRejected(t *testing.T) {
 	s := newServer()
 	require.NotNil(t, s)
@@ -1749,6 +2957,30 @@ func TestServerProcessPublishOnMessageRecvRejected(t *testing.T) {
 	require.NoError(t, err) // packets rejected silently
 }
 
+func TestServerProcessPublishOnPublishCodeQos2(t *testing.T) {
+	s := newServer()
+	hook := new(modifiedHookBase)
+	hook.fail = true
+	hook.err = packets.ErrQuotaExceeded
+	err := s.AddHook(hook, nil)
+	require.NoError(t, err)
+
+	cl, r, w := newTestClient()
+	cl.Properties.ProtocolVersion = 5
+
+	go func() {
+		err := s.processPublish(cl, *packets.TPacketData[packets.Publish].Get(packets.TPublishQos2Mqtt5).Packet)
+		require.NoError(t, err)
+		_ = w.Close()
+	}()
+
+	buf, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, packets.Pubrec, buf[0]>>4)
+	require.Equal(t, packets.ErrQuotaExceeded.Code, buf[4])
+	require.Equal(t, 0, cl.State.Inflight.Len())
+}
+
 func TestServerProcessPacketPublishQos0(t *testing.T) {
 	s := newServer()
 	cl, r, w := newTestClient()
@@ -1801,6 +3033,57 @@ func TestServerProcessPacketPublishQos2PacketIDInUse(t *testing.T) {
 	require.Equal(t, int64(1), atomic.LoadInt64(&s.Info.Inflight))
 }
 
+func TestServerProcessPacketPublishQos1PacketIDInUseByPubrec(t *testing.T) {
+	s := newServer()
+	cl, r, w := newTestClient()
+	cl.Properties.ProtocolVersion = 5
+	cl.State.Inflight.Set(packets.Packet{PacketID: 7, FixedHeader: packets.FixedHeader{Type: packets.Pubrec}})
+	atomic.StoreInt64(&s.Info.Inflight, 1)
+
+	go func() {
+		err := s.processPacket(cl, *packets.TPacketData[packets.Publish].Get(packets.TPublishQos1Mqtt5).Packet)
+		require.NoError(t, err)
+		_ = w.Close()
+	}()
+
+	buf, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, packets.TPacketData[packets.Pubrec].Get(packets.TPubrecMqtt5IDInUse).RawBytes, buf)
+	require.Equal(t, int64(1), atomic.LoadInt64(&s.Info.Inflight))
+}
+
+func TestServerProcessPacketPublishQos2Duplicate(t *testing.T) {
+	s := newServer()
+	cl, r, w := newTestClient()
+	cl.Properties.ProtocolVersion = 5
+	cl.State.Inflight.Set(packets.Packet{PacketID: 7, FixedHeader: packets.FixedHeader{Type: packets.Pubrec}})
+	atomic.StoreInt64(&s.Info.Inflight, 1)
+
+	sub, _, _ := newTestClient()
+	sub.ID = "sub"
+	s.Clients.Add(sub)
+	s.Topics.Subscribe(sub.ID, packets.Subscription{Filter: "a/b/c", Qos: 2})
+
+	pk := *packets.TPacketData[packets.Publish].Get(packets.TPublishQos2Mqtt5).Packet
+	pk.FixedHeader.Dup = true
+	go func() {
+		err := s.processPacket(cl, pk)
+		require.NoError(t, err)
+		_ = w.Close()
+	}()
+
+	buf, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, packets.Pubrec<<4, buf[0])
+	require.Equal(t, []byte{0, 7, packets.CodeSuccess.Code}, buf[2:5])
+
+	require.Equal(t, int64(1), atomic.LoadInt64(&s.Info.Inflight))
+	require.Equal(t, int32(0), atomic.LoadInt32(&sub.State.outboundQty))
+	pki, ok := cl.State.Inflight.Get(7)
+	require.True(t, ok)
+	require.Equal(t, packets.Pubrec, pki.FixedHeader.Type)
+}
+
 func TestServerProcessPacketPublishQos1(t *testing.T) {
 	s := newServer()
 	cl, r, w := newTestClient()
@@ -1829,6 +3112,11 @@ func TestServerProcessPacketPublishQos2(t *testing.T) {
 	buf, err := io.ReadAll(r)
 	require.NoError(t, err)
 	require.Equal(t, packets.TPacketData[packets.Pubrec].Get(packets.TPubrec).RawBytes, buf)
+
+	pk, ok := cl.State.Inflight.Get(7)
+	require.True(t, ok)
+	require.Equal(t, packets.Pubrec, pk.FixedHeader.Type)
+	require.Equal(t, cl.ID, pk.Origin)
 }
 
 func TestServerProcessPacketPublishDowngradeQos(t *testing.T) {
@@ -2185,13 +3473,147 @@ func TestPublishToClientExceedMaximumInflight(t *testing.T) {
 	require.Equal(t, int64(1), atomic.LoadInt64(&s.Info.InflightDropped))
 }
 
-func TestPublishToClientExhaustedPacketID(t *testing.T) {
-	s := newServer()
-	cl, _, _ := newTestClient()
-	for i := uint32(0); i <= cl.ops.options.Capabilities.maximumPacketID; i++ {
-		cl.State.Inflight.Set(packets.Packet{PacketID: uint16(i)})
-	}
-
+type inflightOverflowHook struct {
+	HookBase
+	overflowed []packets.Packet
+	dropped    []packets.Packet
+}
+
+func (h *inflightOverflowHook) ID() string {
+	return "inflight-overflow"
+}
+
+func (h *inflightOverflowHook) Provides(b byte) bool {
+	return b == OnInflightOverflow || b == OnQosDropped
+}
+
+func (h *inflightOverflowHook) OnInflightOverflow(cl *Client, pk packets.Packet) {
+	h.overflowed = append(h.overflowed, pk)
+}
+
+func (h *inflightOverflowHook) OnQosDropped(cl *Client, pk packets.Packet) {
+	h.dropped = append(h.dropped, pk)
+}
+
+func TestPublishToClientExceedMaximumInflightEvictOldest(t *testing.T) {
+	const MaxInflight uint16 = 5
+	s := newServer()
+	require.Equal(t, InflightReject, s.Options.InflightOverflow)
+	s.Options.InflightOverflow = InflightEvictOldest
+	hook := new(inflightOverflowHook)
+	require.NoError(t, s.AddHook(hook, nil))
+
+	cl, _, _ := newTestClient()
+	s.Options.Capabilities.MaximumInflight = MaxInflight
+	cl.ops.options.Capabilities.MaximumInflight = MaxInflight
+	cl.State.Inflight.Set(packets.Packet{FixedHeader: packets.FixedHeader{Type: packets.Pubrel}, PacketID: 1, Created: 1})
+	for i := uint16(2); i <= MaxInflight; i++ {
+		cl.State.Inflight.Set(packets.Packet{FixedHeader: packets.FixedHeader{Type: packets.Publish, Qos: 1}, PacketID: i, Created: int64(i)})
+	}
+	atomic.StoreInt64(&s.Info.Inflight, int64(MaxInflight))
+
+	out, err := s.publishToClient(cl, packets.Subscription{Filter: "a/b/c", Qos: 1}, *packets.TPacketData[packets.Publish].Get(packets.TPublishQos1).Packet)
+	require.NoError(t, err)
+	require.Equal(t, int(MaxInflight), cl.State.Inflight.Len())
+
+	_, ok := cl.State.Inflight.Get(1)
+	require.True(t, ok)
+	pk, ok := cl.State.Inflight.Get(out.PacketID)
+	require.True(t, ok)
+	require.Equal(t, out.Payload, pk.Payload)
+
+	require.Len(t, hook.overflowed, 1)
+	require.Len(t, hook.dropped, 1)
+	require.Equal(t, uint16(2), hook.dropped[0].PacketID)
+	require.Equal(t, int64(1), atomic.LoadInt64(&s.Info.InflightDropped))
+	require.Equal(t, int64(MaxInflight), atomic.LoadInt64(&s.Info.Inflight))
+}
+
+func TestPublishToClientExceedMaximumInflightEvictNoPublish(t *testing.T) {
+	const MaxInflight uint16 = 2
+	s := newServer()
+	s.Options.InflightOverflow = InflightEvictOldest
+	cl, _, _ := newTestClient()
+	s.Options.Capabilities.MaximumInflight = MaxInflight
+	for i := uint16(1); i <= MaxInflight; i++ {
+		cl.State.Inflight.Set(packets.Packet{FixedHeader: packets.FixedHeader{Type: packets.Pubrec}, PacketID: i})
+	}
+
+	_, err := s.publishToClient(cl, packets.Subscription{Filter: "a/b/c", Qos: 1}, *packets.TPacketData[packets.Publish].Get(packets.TPublishQos1).Packet)
+	require.ErrorIs(t, err, packets.ErrQuotaExceeded)
+	require.Equal(t, int(MaxInflight), cl.State.Inflight.Len())
+	require.Equal(t, int64(1), atomic.LoadInt64(&s.Info.InflightDropped))
+}
+
+func TestPublishToClientEvictOldestReservesPacketID(t *testing.T) {
+	s := newServer()
+	s.Options.InflightOverflow = InflightEvictOldest
+	cl, _, _ := newTestClient()
+	s.Options.Capabilities.MaximumInflight = 1
+	cl.ops.options.Capabilities.MaximumInflight = 1
+	cl.State.Inflight.Set(packets.Packet{FixedHeader: packets.FixedHeader{Type: packets.Publish, Qos: 1}, PacketID: 1})
+	atomic.StoreUint32(&cl.State.packetID, 0)
+
+	out, err := s.publishToClient(cl, packets.Subscription{Filter: "a/b/c", Qos: 1}, *packets.TPacketData[packets.Publish].Get(packets.TPublishQos1).Packet)
+	require.NoError(t, err)
+	require.Equal(t, uint16(2), out.PacketID) // a late puback for the evicted message cannot complete the new one
+	require.True(t, cl.State.Inflight.Reserved(1))
+
+	err = s.processPuback(cl, packets.Packet{FixedHeader: packets.FixedHeader{Type: packets.Puback}, PacketID: 1})
+	require.NoError(t, err)
+	require.False(t, cl.State.Inflight.Reserved(1))
+	_, ok := cl.State.Inflight.Get(2)
+	require.True(t, ok)
+}
+
+func TestServerProcessPubcompEvictedPacketID(t *testing.T) {
+	s := newServer()
+	cl, _, _ := newTestClient()
+	cl.State.Inflight.Set(packets.Packet{FixedHeader: packets.FixedHeader{Type: packets.Publish, Qos: 2}, PacketID: 1})
+	require.True(t, cl.State.Inflight.Evict(1))
+	quota := atomic.LoadInt32(&cl.State.Inflight.sendQuota)
+
+	err := s.processPubcomp(cl, packets.Packet{FixedHeader: packets.FixedHeader{Type: packets.Pubcomp}, PacketID: 1})
+	require.NoError(t, err)
+	require.False(t, cl.State.Inflight.Reserved(1))
+	require.Equal(t, quota, atomic.LoadInt32(&cl.State.Inflight.sendQuota))
+}
+
+func TestPublishToClientConcurrentPacketIDs(t *testing.T) {
+	s := newServer()
+	cl, _, _ := newTestClient()
+	cl.Net.Conn = nil // messages for an offline client are stored as inflight without being sent
+	maxIDs := int(cl.ops.options.Capabilities.maximumPacketID)
+
+	var wg sync.WaitGroup
+	var sent, exhausted int64
+	for i := 0; i < maxIDs*2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := s.publishToClient(cl, packets.Subscription{Filter: "a/b/c", Qos: 1}, *packets.TPacketData[packets.Publish].Get(packets.TPublishQos1).Packet)
+			if errors.Is(err, packets.ErrQuotaExceeded) {
+				atomic.AddInt64(&exhausted, 1)
+			} else if err == nil || errors.Is(err, packets.CodeDisconnect) {
+				atomic.AddInt64(&sent, 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	require.Equal(t, int64(maxIDs), sent)
+	require.Equal(t, int64(maxIDs), exhausted)
+	require.Equal(t, maxIDs, cl.State.Inflight.Len())
+	require.Equal(t, int64(maxIDs), atomic.LoadInt64(&s.Info.Inflight))
+}
+
+func TestPublishToClientExhaustedPacketID(t *testing.T) {
+	s := newServer()
+	cl, _, _ := newTestClient()
+	for i := uint32(0); i <= cl.ops.options.Capabilities.maximumPacketID; i++ {
+		cl.State.Inflight.Set(packets.Packet{PacketID: uint16(i)})
+	}
+
 	_, err := s.publishToClient(cl, packets.Subscription{Filter: "a/b/c", Qos: 1}, *packets.TPacketData[packets.Publish].Get(packets.TPublishQos1).Packet)
 	require.Error(t, err)
 	require.ErrorIs(t, err, packets.ErrQuotaExceeded)
@@ -2390,6 +3812,25 @@ func TestNoRetainMessage(t *testing.T) {
 	require.Equal(t, int64(1), atomic.LoadInt64(&s.Info.Retained))
 }
 
+func TestRetainMessageSetAndClearedHooks(t *testing.T) {
+	s := newServer()
+	hook := new(retainRecorderHook)
+	err := s.AddHook(hook, nil)
+	require.NoError(t, err)
+
+	pk := *packets.TPacketData[packets.Publish].Get(packets.TPublishRetain).Packet
+	s.retainMessage(new(Client), pk)
+	s.retainMessage(new(Client), pk)
+	require.Equal(t, []string{pk.TopicName, pk.TopicName}, hook.set)
+	require.Empty(t, hook.cleared)
+
+	pk.Payload = []byte{}
+	s.retainMessage(new(Client), pk)
+	s.retainMessage(new(Client), pk) // nothing left to clear
+	require.Equal(t, []string{pk.TopicName}, hook.cleared)
+	require.Len(t, hook.set, 2)
+}
+
 func TestServerProcessPacketPuback(t *testing.T) {
 	tt := ProtocolTest{
 		{
@@ -2819,6 +4260,16 @@ func TestServerProcessPacketSubscribeInvalid(t *testing.T) {
 	require.ErrorIs(t, err, packets.ErrProtocolViolationNoPacketID)
 }
 
+func TestServerProcessPacketSubscribeInvalidRetainHandling(t *testing.T) {
+	s := newServer()
+	cl, _, _ := newTestClient()
+	cl.Properties.ProtocolVersion = 5
+
+	err := s.processPacket(cl, *packets.TPacketData[packets.Subscribe].Get(packets.TSubscribeInvalidRetainHandling).Packet)
+	require.Error(t, err)
+	require.ErrorIs(t, err, packets.ErrProtocolViolationRetainHandling)
+}
+
 func TestServerProcessPacketSubscribeInvalidFilter(t *testing.T) {
 	s := newServer()
 	cl, r, w := newTestClient()
@@ -2892,6 +4343,71 @@ func TestServerProcessSubscribeDowngradeQos(t *testing.T) {
 	require.Equal(t, []byte{0, 1, 1}, buf[4:])
 }
 
+type grantHook struct {
+	HookBase
+	filters     packets.Subscriptions
+	reasonCodes []byte
+}
+
+func (h *grantHook) ID() string {
+	return "grant-hook"
+}
+
+func (h *grantHook) Provides(b byte) bool {
+	return b == OnSubscribe || b == OnSubscribed
+}
+
+func (h *grantHook) OnSubscribe(cl *Client, pk packets.Packet) packets.Packet {
+	for i := range pk.Filters {
+		if pk.Filters[i].Filter == "d/e/f" {
+			pk.Filters[i].Qos = 0 // downgrade by access policy
+		}
+	}
+	return pk
+}
+
+func (h *grantHook) OnSubscribed(cl *Client, pk packets.Packet, reasonCodes []byte) {
+	h.filters = pk.Filters
+	h.reasonCodes = reasonCodes
+}
+
+func TestServerProcessSubscribeGrantedQos(t *testing.T) {
+	s := newServer()
+	s.Options.Capabilities.MaximumQos = 1
+	hook := new(grantHook)
+	require.NoError(t, s.AddHook(hook, nil))
+	cl, r, _ := newTestClient()
+	cl.Properties.ProtocolVersion = 5
+	go func() { _, _ = io.Copy(io.Discard, r) }()
+
+	pk := packets.Packet{
+		FixedHeader: packets.FixedHeader{Type: packets.Subscribe, Qos: 1},
+		PacketID:    15,
+		Filters: packets.Subscriptions{
+			{Filter: "a/b/c", Qos: 2},
+			{Filter: "d/e/f", Qos: 2},
+			{Filter: "a/#/c", Qos: 1},
+		},
+	}
+
+	err := s.processSubscribe(cl, pk)
+	require.NoError(t, err)
+	require.Equal(t, []byte{1, 0, packets.ErrTopicFilterInvalid.Code}, hook.reasonCodes)
+	require.Equal(t, byte(1), hook.filters[0].Qos)
+	require.Equal(t, byte(0), hook.filters[1].Qos)
+	require.Equal(t, byte(2), pk.Filters[0].Qos)
+
+	sub, ok := cl.State.Subscriptions.Get("a/b/c")
+	require.True(t, ok)
+	require.Equal(t, byte(1), sub.Qos)
+
+	sub, ok = cl.State.Subscriptions.Get("d/e/f")
+	require.True(t, ok)
+	require.Equal(t, byte(0), sub.Qos)
+
+	require.Equal(t, byte(1), s.Topics.Subscribers("a/b/c").Subscriptions[cl.ID].Qos)
+}
+
 func TestServerProcessSubscribeWithRetainHandling1(t *testing.T) {
 	s := newServer()
 	cl, r, w := newTestClient()
@@ -2914,6 +4430,33 @@ func TestServerProcessSubscribeWithRetainHandling1(t *testing.T) {
 	require.Equal(t, packets.TPacketData[packets.Suback].Get(packets.TSuback).RawBytes, buf)
 }
 
+func TestServerProcessSubscribeWithRetainHandling1New(t *testing.T) {
+	s := newServer()
+	cl, r, w := newTestClient()
+	s.Clients.Add(cl)
+
+	retained := s.Topics.RetainMessage(*packets.TPacketData[packets.Publish].Get(packets.TPublishRetain).Packet)
+	require.Equal(t, int64(1), retained)
+
+	go func() {
+		err := s.processPacket(cl, *packets.TPacketData[packets.Subscribe].Get(packets.TSubscribeRetainHandling1).Packet)
+		require.NoError(t, err)
+
+		time.Sleep(time.Millisecond)
+		_ = w.Close()
+	}()
+
+	buf, err := io.ReadAll(r)
+	require.NoError(t, err)
+	suback := packets.TPacketData[packets.Suback].Get(packets.TSuback).RawBytes
+	require.Greater(t, len(buf), len(suback)) // the retained message follows the suback
+	require.Equal(t, suback, buf[:len(suback)])
+
+	sub, ok := s.Topics.Subscribers("a/b/c").Subscriptions[cl.ID]
+	require.True(t, ok)
+	require.Equal(t, byte(1), sub.RetainHandling)
+}
+
 func TestServerProcessSubscribeWithRetainHandling2(t *testing.T) {
 	s := newServer()
 	cl, r, w := newTestClient()
@@ -2987,6 +4530,24 @@ func TestServerProcessSubscribeACLCheckDeny(t *testing.T) {
 	require.Equal(t, packets.TPacketData[packets.Suback].Get(packets.TSubackDeny).RawBytes, buf)
 }
 
+func TestServerProcessSubscribeWriteOnly(t *testing.T) {
+	s := newServer()
+	cl, r, w := newTestClient()
+	cl.Properties.ProtocolVersion = 5
+	cl.State.writeOnly = true
+
+	go func() {
+		err := s.processSubscribe(cl, *packets.TPacketData[packets.Subscribe].Get(packets.TSubscribe).Packet)
+		require.NoError(t, err)
+		_ = w.Close()
+	}()
+
+	buf, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, packets.TPacketData[packets.Suback].Get(packets.TSubackDeny).RawBytes, buf)
+	require.Equal(t, 0, cl.State.Subscriptions.Len())
+}
+
 func TestServerProcessSubscribeACLCheckDenyObscure(t *testing.T) {
 	s := New(&Options{
 		Logger: logger,
@@ -3058,6 +4619,71 @@ func TestServerProcessPacketUnsubscribePackedIDInUse(t *testing.T) {
 	require.Equal(t, int64(0), atomic.LoadInt64(&s.Info.Subscriptions))
 }
 
+type unsubscribedHook struct {
+	HookBase
+	pk packets.Packet
+}
+
+func (h *unsubscribedHook) ID() string {
+	return "unsubscribed-hook"
+}
+
+func (h *unsubscribedHook) Provides(b byte) bool {
+	return b == OnUnsubscribed
+}
+
+func (h *unsubscribedHook) OnUnsubscribed(cl *Client, pk packets.Packet) {
+	h.pk = pk
+}
+
+func TestServerProcessUnsubscribeReasonCodes(t *testing.T) {
+	s := newServer()
+	hook := new(unsubscribedHook)
+	require.NoError(t, s.AddHook(hook, nil))
+
+	cl, r, w := newTestClient()
+	cl.Properties.ProtocolVersion = 5
+	s.Topics.Subscribe(cl.ID, packets.Subscription{Filter: "a/b/c"})
+	s.Topics.Subscribe("other", packets.Subscription{Filter: "d/e/f"})
+	cl.State.Subscriptions.Add("a/b/c", packets.Subscription{Filter: "a/b/c"})
+
+	go func() {
+		err := s.processUnsubscribe(cl, packets.Packet{
+			FixedHeader: packets.FixedHeader{Type: packets.Unsubscribe, Qos: 1},
+			PacketID:    15,
+			Filters:     packets.Subscriptions{{Filter: "a/b/c"}, {Filter: "d/e/f"}, {Filter: "a/#/c"}},
+		})
+		require.NoError(t, err)
+		_ = w.Close()
+	}()
+
+	buf, err := io.ReadAll(r)
+	require.NoError(t, err)
+
+	codes := []byte{packets.CodeSuccess.Code, packets.CodeNoSubscriptionExisted.Code, packets.ErrTopicFilterInvalid.Code}
+	require.Equal(t, codes, buf[len(buf)-3:])
+	require.Equal(t, codes, hook.pk.ReasonCodes)
+	require.Len(t, hook.pk.Filters, 3)
+	require.Equal(t, 0, cl.State.Subscriptions.Len())
+	require.Len(t, s.Topics.Subscribers("d/e/f").Subscriptions, 1)
+}
+
+func TestServerRemoveClientSubscriptionReasonCodes(t *testing.T) {
+	s := newServer()
+	hook := new(unsubscribedHook)
+	require.NoError(t, s.AddHook(hook, nil))
+
+	cl, _, _ := newTestClient()
+	s.Clients.Add(cl)
+
+	require.NoError(t, s.RemoveClientSubscription(cl.ID, "a/b/c"))
+	require.Equal(t, []byte{packets.CodeNoSubscriptionExisted.Code}, hook.pk.ReasonCodes)
+
+	s.Topics.Subscribe(cl.ID, packets.Subscription{Filter: "a/b/c"})
+	require.NoError(t, s.RemoveClientSubscription(cl.ID, "a/b/c"))
+	require.Equal(t, []byte{packets.CodeSuccess.Code}, hook.pk.ReasonCodes)
+}
+
 func TestServerProcessPacketUnsubscribeInvalid(t *testing.T) {
 	s := newServer()
 	cl, _, _ := newTestClient()
@@ -3297,6 +4923,131 @@ func TestServerSendLWTDelayed(t *testing.T) {
 	require.Equal(t, packets.TPacketData[packets.Publish].Get(packets.TPublishBasic).RawBytes, <-recv)
 }
 
+func TestServerBan(t *testing.T) {
+	s := newServer()
+	hook := new(banRecorderHook)
+	_ = s.AddHook(hook, nil)
+
+	_, err := s.Ban("", "", 0, "")
+	require.ErrorIs(t, err, ErrInvalidBan)
+
+	cl, r, _ := newTestClient()
+	cl.Net.Remote = "127.0.0.1:1883"
+	s.Clients.Add(cl)
+	go func() {
+		_, _ = io.ReadAll(r)
+	}()
+
+	b, err := s.Ban("", "127.0.0.1", time.Minute, "test")
+	require.NoError(t, err)
+	require.Equal(t, "127.0.0.1", b.IP)
+	require.Equal(t, "test", b.Reason)
+	require.Greater(t, b.Expiry, b.Created)
+	require.Equal(t, 1, s.Bans.Len())
+	require.Equal(t, []Ban{b}, hook.banned)
+	require.True(t, cl.Closed())
+	require.ErrorIs(t, cl.StopCause(), packets.ErrNotAuthorized)
+
+	b, err = s.Ban("mochi", "", 0, "")
+	require.NoError(t, err)
+	require.Equal(t, int64(0), b.Expiry)
+	require.Equal(t, 2, s.Bans.Len())
+}
+
+func TestServerReplayInvalidFilter(t *testing.T) {
+	s := newServer()
+	n, err := s.Replay("a/#/c", 0, 0, ReplayTarget{})
+	require.ErrorIs(t, err, packets.ErrTopicFilterInvalid)
+	require.Equal(t, 0, n)
+}
+
+func TestServerReplayClientNotFound(t *testing.T) {
+	s := newServer()
+	n, err := s.Replay("a/b/#", 0, 0, ReplayTarget{ClientID: "mochi"})
+	require.ErrorIs(t, err, ErrClientNotFound)
+	require.Equal(t, 0, n)
+}
+
+func TestServerReplayHookError(t *testing.T) {
+	s := newServer()
+	hook := new(modifiedHookBase)
+	hook.failAt = 7
+	_ = s.AddHook(hook, nil)
+
+	_, err := s.Replay("a/b/#", 0, 0, ReplayTarget{})
+	require.Error(t, err)
+}
+
+func TestServerReplayToTopic(t *testing.T) {
+	s := newServerWithInlineClient()
+	_ = s.AddHook(new(modifiedHookBase), nil)
+
+	recv := make(chan packets.Packet, 2)
+	err := s.Subscribe("replay/topic", 1, func(cl *Client, sub packets.Subscription, pk packets.Packet) {
+		recv <- pk
+	})
+	require.NoError(t, err)
+
+	n, err := s.Replay("a/b/#", 0, 0, ReplayTarget{Topic: "replay/topic"})
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+
+	for i := 0; i < 2; i++ {
+		select {
+		case pk := <-recv:
+			require.Equal(t, "replay/topic", pk.TopicName)
+			require.False(t, pk.FixedHeader.Retain)
+		case <-time.After(time.Second):
+			require.Fail(t, "replayed message not received")
+		}
+	}
+}
+
+func TestServerReplayToClient(t *testing.T) {
+	s := newServer()
+	_ = s.AddHook(new(modifiedHookBase), nil)
+
+	cl, _, _ := newTestClient()
+	s.Clients.Add(cl)
+
+	n, err := s.Replay("a/b/#", 0, 0, ReplayTarget{ClientID: cl.ID})
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+	require.Equal(t, int32(2), atomic.LoadInt32(&cl.State.outboundQty))
+
+	pk := <-cl.State.outbound
+	require.Equal(t, "a/b/c", pk.TopicName)
+	require.Equal(t, packets.Publish, pk.FixedHeader.Type)
+	pk = <-cl.State.outbound
+	require.Equal(t, "a/b/d", pk.TopicName)
+}
+
+func TestServerTraceClient(t *testing.T) {
+	s := newServer()
+	s.TraceClient("mochi", true)
+	require.True(t, s.Traces.Has("mochi"))
+
+	cl := s.NewClient(nil, "tcp", "mochi", false)
+	require.True(t, cl.ops.traces.Has(cl.ID))
+
+	s.TraceClient("mochi", false)
+	require.False(t, cl.ops.traces.Has(cl.ID))
+}
+
+func TestServerUnban(t *testing.T) {
+	s := newServer()
+	hook := new(banRecorderHook)
+	_ = s.AddHook(hook, nil)
+
+	require.False(t, s.Unban("mochi", ""))
+
+	b, err := s.Ban("mochi", "", 0, "")
+	require.NoError(t, err)
+	require.True(t, s.Unban("mochi", ""))
+	require.Equal(t, 0, s.Bans.Len())
+	require.Equal(t, []Ban{b}, hook.unbanned)
+}
+
 func TestServerReadStore(t *testing.T) {
 	s := newServer()
 	hook := new(modifiedHookBase)
@@ -3321,11 +5072,15 @@ func TestServerReadStore(t *testing.T) {
 	hook.failAt = 5 // sys info
 	err = s.readStore()
 	require.Error(t, err)
+
+	hook.failAt = 6 // bans
+	err = s.readStore()
+	require.Error(t, err)
 }
 
 func TestServerLoadClients(t *testing.T) {
 	v := []storage.Client{
-		{ID: "mochi"},
+		{ID: "mochi", Created: 100},
 		{ID: "zen"},
 		{ID: "mochi-co"},
 		{ID: "v3-clean", ProtocolVersion: 4, Clean: true},
@@ -3361,6 +5116,7 @@ func TestServerLoadClients(t *testing.T) {
 	cl, ok := s.Clients.Get("mochi")
 	require.True(t, ok)
 	require.Equal(t, "mochi", cl.ID)
+	require.Equal(t, int64(100), cl.Session().Created)
 
 	_, ok = s.Clients.Get("v3-clean")
 	require.False(t, ok)
@@ -3473,14 +5229,184 @@ func TestServerClose(t *testing.T) {
 	require.Equal(t, packets.TPacketData[packets.Disconnect].Get(packets.TDisconnectShuttingDown).RawBytes, <-recv)
 }
 
-func TestServerClearExpiredInflights(t *testing.T) {
-	s := New(nil)
-	require.NotNil(t, s)
-	s.Options.Capabilities.MaximumMessageExpiryInterval = 4
-
-	n := time.Now().Unix()
-	cl, _, _ := newTestClient()
-	cl.ops.info = s.Info
+func TestServerDrain(t *testing.T) {
+	s := newServer()
+
+	cl, r, _ := newTestClient()
+	cl.Net.Listener = "t1"
+	cl.Properties.ProtocolVersion = 5
+	s.Clients.Add(cl)
+
+	cl2, r2, _ := newTestClient()
+	cl2.ID = "mochi2"
+	cl2.Net.Listener = "t1"
+	cl2.Properties.ProtocolVersion = 5
+	s.Clients.Add(cl2)
+
+	err := s.AddListener(listeners.NewMockListener("t1", ":1882"))
+	require.NoError(t, err)
+	_ = s.Serve()
+	time.Sleep(time.Millisecond)
+	listener, _ := s.Listeners.Get("t1")
+
+	// drain without disconnecting clients
+	s.Drain(0)
+	require.False(t, listener.(*listeners.MockListener).IsServing())
+	require.False(t, cl.Closed())
+	require.False(t, cl2.Closed())
+
+	recv := make(chan []byte, 2)
+	for _, c := range []net.Conn{r, r2} {
+		go func(c net.Conn) {
+			buf, err := io.ReadAll(c)
+			require.NoError(t, err)
+			recv <- buf
+		}(c)
+	}
+
+	start := time.Now()
+	s.Drain(20)
+	require.GreaterOrEqual(t, time.Since(start), time.Millisecond*90)
+	require.True(t, cl.Closed())
+	require.True(t, cl2.Closed())
+	require.Equal(t, packets.TPacketData[packets.Disconnect].Get(packets.TDisconnectShuttingDown).RawBytes, <-recv)
+	require.Equal(t, packets.TPacketData[packets.Disconnect].Get(packets.TDisconnectShuttingDown).RawBytes, <-recv)
+	_ = s.Close()
+}
+
+func TestServerDrainAttachedClient(t *testing.T) {
+	s := newServer()
+	l := listeners.NewTCP(listeners.Config{ID: "t1", Address: "127.0.0.1:0"})
+	require.NoError(t, s.AddListener(l))
+	require.NoError(t, s.Serve())
+
+	conn, err := net.Dial("tcp", l.Address())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write(packets.TPacketData[packets.Connect].Get(packets.TConnectMqtt311).RawBytes)
+	require.NoError(t, err)
+
+	connack := packets.TPacketData[packets.Connack].Get(packets.TConnackAcceptedNoSession).RawBytes
+	buf := make([]byte, len(connack))
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	require.Equal(t, connack, buf)
+
+	// the attached client must not block draining.
+	done := make(chan struct{})
+	go func() {
+		s.Drain(0)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		require.Fail(t, "drain blocked on attached client")
+	}
+
+	cl, ok := s.Clients.Get("zen")
+	require.True(t, ok)
+	require.False(t, cl.Closed())
+
+	_, err = net.DialTimeout("tcp", l.Address(), time.Millisecond*100)
+	require.Error(t, err)
+
+	_ = s.Close()
+	require.True(t, cl.Closed())
+}
+
+func TestServerDrainThenClose(t *testing.T) {
+	s := newServer()
+
+	cl, r, _ := newTestClient()
+	cl.Net.Listener = "t1"
+	cl.Properties.ProtocolVersion = 5
+	s.Clients.Add(cl)
+
+	err := s.AddListener(listeners.NewMockListener("t1", ":1882"))
+	require.NoError(t, err)
+	_ = s.Serve()
+	time.Sleep(time.Millisecond)
+
+	recv := make(chan []byte)
+	go func() {
+		buf, err := io.ReadAll(r)
+		require.NoError(t, err)
+		recv <- buf
+	}()
+
+	s.Drain(0)
+	require.False(t, cl.Closed())
+
+	_ = s.Close()
+	require.True(t, cl.Closed())
+	require.Equal(t, packets.TPacketData[packets.Disconnect].Get(packets.TDisconnectShuttingDown).RawBytes, <-recv)
+}
+
+func TestServerReplaceListener(t *testing.T) {
+	s := newServer()
+	defer s.Close()
+
+	cl, r, _ := newTestClient()
+	cl.Net.Listener = "t1"
+	cl.Properties.ProtocolVersion = 5
+	s.Clients.Add(cl)
+
+	old := listeners.NewMockListener("t1", ":1882")
+	err := s.AddListener(old)
+	require.NoError(t, err)
+	_ = s.Serve()
+	time.Sleep(time.Millisecond)
+
+	recv := make(chan []byte)
+	go func() {
+		buf, err := io.ReadAll(r)
+		require.NoError(t, err)
+		recv <- buf
+	}()
+
+	nl := listeners.NewMockListener("t1", ":1882")
+	err = s.ReplaceListener(nl, 100)
+	require.NoError(t, err)
+	time.Sleep(time.Millisecond)
+
+	l, ok := s.Listeners.Get("t1")
+	require.True(t, ok)
+	require.Same(t, nl, l)
+	require.False(t, old.IsServing())
+	require.True(t, nl.IsServing())
+	require.True(t, cl.Closed())
+	require.Equal(t, packets.TPacketData[packets.Disconnect].Get(packets.TDisconnectShuttingDown).RawBytes, <-recv)
+}
+
+func TestServerReplaceListenerFailure(t *testing.T) {
+	s := newServer()
+	defer s.Close()
+
+	err := s.ReplaceListener(listeners.NewMockListener("t1", ":1882"), 0)
+	require.ErrorIs(t, err, ErrListenerIDNotFound)
+
+	old := listeners.NewMockListener("t1", ":1882")
+	require.NoError(t, s.AddListener(old))
+
+	nl := listeners.NewMockListener("t1", ":1882")
+	nl.ErrListen = true
+	err = s.ReplaceListener(nl, 0)
+	require.Error(t, err)
+
+	l, _ := s.Listeners.Get("t1")
+	require.Same(t, old, l)
+}
+
+func TestServerClearExpiredInflights(t *testing.T) {
+	s := New(nil)
+	require.NotNil(t, s)
+	s.Options.Capabilities.MaximumMessageExpiryInterval = 4
+
+	n := time.Now().Unix()
+	cl, _, _ := newTestClient()
+	cl.ops.info = s.Info
 
 	cl.State.Inflight.Set(packets.Packet{PacketID: 1, Expiry: n - 1})
 	cl.State.Inflight.Set(packets.Packet{PacketID: 2, Expiry: n - 2})
@@ -3501,6 +5427,112 @@ func TestServerClearExpiredInflights(t *testing.T) {
 	require.Len(t, cl.State.Inflight.GetAll(false), 3)
 }
 
+func TestServerResendInflights(t *testing.T) {
+	s := newServer()
+	s.Options.InflightResendInterval = 10
+	s.Options.InflightResendMaximum = 60
+
+	_, w := net.Pipe()
+	v3 := s.NewClient(w, "tcp1", "v3", false)
+	v3.Properties.ProtocolVersion = 4
+	v3.State.Inflight.Set(packets.Packet{FixedHeader: packets.FixedHeader{Type: packets.Publish, Qos: 1}, PacketID: 1})
+	s.Clients.Add(v3)
+
+	v5 := s.NewClient(w, "tcp1", "v5", false)
+	v5.Properties.ProtocolVersion = 5
+	v5.State.Inflight.Set(packets.Packet{FixedHeader: packets.FixedHeader{Type: packets.Publish, Qos: 1}, PacketID: 1})
+	s.Clients.Add(v5)
+
+	s.resendInflights(100)
+	require.Len(t, v3.State.outbound, 0)
+
+	s.resendInflights(110)
+	require.Len(t, v3.State.outbound, 1)
+	require.Equal(t, int32(1), atomic.LoadInt32(&v3.State.outboundQty))
+	pk := <-v3.State.outbound
+	require.Equal(t, uint16(1), pk.PacketID)
+	require.True(t, pk.FixedHeader.Dup)
+
+	stored, ok := v3.State.Inflight.Get(1)
+	require.True(t, ok)
+	require.True(t, stored.FixedHeader.Dup)
+
+	require.Len(t, v5.State.outbound, 0) // [MQTT-4.4.0-1]
+
+	s.Options.InflightResendInterval = 0
+	s.resendInflights(200)
+	require.Len(t, v3.State.outbound, 0)
+}
+
+func TestServerResendInflightsQueueFull(t *testing.T) {
+	s := newServer()
+	s.Options.InflightResendInterval = 10
+	s.Options.InflightResendMaximum = 60
+
+	_, w := net.Pipe()
+	cl := s.NewClient(w, "tcp1", "v3", false)
+	cl.Properties.ProtocolVersion = 4
+	for i := 0; i < cap(cl.State.outbound); i++ {
+		cl.State.outbound <- &packets.Packet{}
+	}
+	cl.State.Inflight.Set(packets.Packet{FixedHeader: packets.FixedHeader{Type: packets.Publish, Qos: 1}, PacketID: 1})
+	s.Clients.Add(cl)
+
+	s.resendInflights(100)
+	s.resendInflights(110) // dropped, and tried again after the next backoff
+	require.Equal(t, int32(0), atomic.LoadInt32(&cl.State.outboundQty))
+	<-cl.State.outbound
+
+	s.resendInflights(129)
+	require.Equal(t, int32(0), atomic.LoadInt32(&cl.State.outboundQty))
+	s.resendInflights(130)
+	require.Equal(t, int32(1), atomic.LoadInt32(&cl.State.outboundQty))
+}
+
+func TestServerClearExpiredInflightsOffline(t *testing.T) {
+	s := New(nil)
+	require.NotNil(t, s)
+	s.Options.Capabilities.MaximumMessageExpiryInterval = 100
+	s.Options.Capabilities.MaximumOfflineMessageAge = 10
+
+	n := time.Now().Unix()
+	online, _, _ := newTestClient()
+	online.ops.info = s.Info
+	online.State.Inflight.Set(packets.Packet{PacketID: 1, Created: n - 20})
+	s.Clients.Add(online)
+
+	offline, _, _ := newTestClient()
+	offline.ID = "offline"
+	offline.ops.info = s.Info
+	offline.State.Inflight.Set(packets.Packet{PacketID: 1, Created: n - 20}) // over offline age limit
+	offline.State.Inflight.Set(packets.Packet{PacketID: 2, Created: n - 5})
+	offline.Stop(nil)
+	s.Clients.Add(offline)
+
+	s.clearExpiredInflights(n)
+	require.Equal(t, 1, online.State.Inflight.Len())
+	require.Equal(t, 1, offline.State.Inflight.Len())
+	_, ok := offline.State.Inflight.Get(2)
+	require.True(t, ok)
+}
+
+func TestServerMaximumInflightAge(t *testing.T) {
+	s := New(nil)
+	s.Options.Capabilities.MaximumMessageExpiryInterval = 100
+	require.Equal(t, int64(100), s.maximumInflightAge(true))
+
+	s.Options.Capabilities.MaximumOfflineMessageAge = 10
+	require.Equal(t, int64(10), s.maximumInflightAge(true))
+	require.Equal(t, int64(100), s.maximumInflightAge(false))
+
+	s.Options.Capabilities.MaximumOfflineMessageAge = 1000
+	require.Equal(t, int64(100), s.maximumInflightAge(true))
+
+	s.Options.Capabilities.MaximumMessageExpiryInterval = 0
+	require.Equal(t, int64(1000), s.maximumInflightAge(true))
+	require.Equal(t, int64(0), s.maximumInflightAge(false))
+}
+
 func TestServerClearExpiredRetained(t *testing.T) {
 	s := New(nil)
 	require.NotNil(t, s)
@@ -3531,6 +5563,92 @@ func TestServerClearExpiredRetained(t *testing.T) {
 	require.Len(t, s.Topics.Retained.GetAll(), 6)
 }
 
+func TestNewWithCompactionInterval(t *testing.T) {
+	s := New(&Options{
+		Logger:             logger,
+		CompactionInterval: 60,
+	})
+	require.NotNil(t, s.loop.compaction)
+
+	s = New(nil)
+	require.Nil(t, s.loop.compaction)
+}
+
+func TestServerCompact(t *testing.T) {
+	s := newServer()
+	hook := new(modifiedHookBase)
+	_ = s.AddHook(hook, nil)
+
+	n := time.Now().Unix()
+	s.Bans.Add(Ban{ClientID: "a", Expiry: n - 1})
+	s.Topics.RetainMessage(packets.Packet{ProtocolVersion: 5, TopicName: "a/b/c", Payload: []byte("hello"), Created: n - 10, Expiry: n - 1})
+
+	cl, _, _ := newTestClient()
+	cl.State.disconnected = n - 10
+	cl.State.cancelOpen()
+	cl.Properties.ProtocolVersion = 5
+	cl.Properties.Props.SessionExpiryInterval = 1
+	cl.Properties.Props.SessionExpiryIntervalFlag = true
+	s.Clients.Add(cl)
+
+	err := s.Compact()
+	require.NoError(t, err)
+	require.Equal(t, 0, s.Bans.Len())
+	require.Equal(t, 0, s.Topics.Retained.Len())
+	_, ok := s.Clients.Get(cl.ID)
+	require.False(t, ok)
+
+	hook.fail = true
+	err = s.Compact()
+	require.ErrorIs(t, err, errTestHook)
+}
+
+func TestServerClearExpiredKeepalives(t *testing.T) {
+	s := newServer()
+
+	expired, _, _ := newTestClient()
+	expired.ID = "expired"
+	expired.State.keepaliveDeadline = time.Unix(99, 0).UnixNano()
+	s.Clients.Add(expired)
+
+	live, _, _ := newTestClient()
+	live.ID = "live"
+	live.State.keepaliveDeadline = time.Unix(100, int64(500*time.Millisecond)).UnixNano()
+	s.Clients.Add(live)
+
+	none, _, _ := newTestClient()
+	none.ID = "none"
+	s.Clients.Add(none)
+
+	s.clearExpiredKeepalives(time.Unix(100, int64(400*time.Millisecond)).UnixNano())
+	require.True(t, expired.Closed())
+	require.ErrorIs(t, expired.StopCause(), packets.ErrKeepAliveTimeout)
+	require.False(t, live.Closed()) // not expired within the final second
+	require.False(t, none.Closed())
+
+	s.clearExpiredKeepalives(time.Unix(100, int64(600*time.Millisecond)).UnixNano())
+	require.True(t, live.Closed())
+}
+
+func TestServerProcessPublishUsesClock(t *testing.T) {
+	s := New(&Options{
+		Logger: logger,
+		Clock:  fixedClock(time.Unix(1000, 0)),
+	})
+	_ = s.AddHook(new(AllowHook), nil)
+
+	cl, _, _ := newTestClient()
+	s.Clients.Add(cl)
+
+	pk := *packets.TPacketData[packets.Publish].Get(packets.TPublishRetain).Packet
+	err := s.processPublish(cl, pk)
+	require.NoError(t, err)
+
+	pkx, ok := s.Topics.Retained.Get(pk.TopicName)
+	require.True(t, ok)
+	require.Equal(t, int64(1000), pkx.Created)
+}
+
 func TestServerClearExpiredClients(t *testing.T) {
 	s := New(nil)
 	require.NotNil(t, s)
@@ -3577,6 +5695,202 @@ func TestServerClearExpiredClients(t *testing.T) {
 	require.Equal(t, 2, s.Clients.Len())
 }
 
+func TestServerClearExpiredClientsConnectionEvents(t *testing.T) {
+	s := newServer()
+	s.Options.ConnectionEvents = true
+	s.Options.ensureDefaults()
+
+	n := time.Now().Unix()
+	cl, _, _ := newTestClient()
+	cl.State.disconnected = n - 10
+	cl.State.cancelOpen()
+	cl.Properties.ProtocolVersion = 5
+	cl.Properties.Props.SessionExpiryInterval = 8
+	cl.Properties.Props.SessionExpiryIntervalFlag = true
+	s.Clients.Add(cl)
+
+	s.publishConnectionEvent(cl, ConnectionStateDisconnected, nil, DisconnectClean)
+	_, ok := s.Topics.Retained.Get(SysPrefix + "/broker/connection/mochi/state")
+	require.True(t, ok)
+
+	s.clearExpiredClients(n)
+	require.Equal(t, 0, s.Clients.Len())
+	_, ok = s.Topics.Retained.Get(SysPrefix + "/broker/connection/mochi/state")
+	require.False(t, ok)
+	require.Empty(t, s.Topics.Messages(SysPrefix+"/broker/connection/#"))
+}
+
+func TestServerLoadBans(t *testing.T) {
+	v := []storage.Ban{
+		{ID: "BAN_mochi@", ClientID: "mochi", Reason: "test"},
+		{ID: "BAN_@127.0.0.1", IP: "127.0.0.1", Expiry: 10},
+	}
+
+	s := newServer()
+	require.Equal(t, 0, s.Bans.Len())
+	s.loadBans(v)
+	require.Equal(t, 2, s.Bans.Len())
+
+	b, ok := s.Bans.Get("mochi@")
+	require.True(t, ok)
+	require.Equal(t, "test", b.Reason)
+
+	b, ok = s.Bans.Get("@127.0.0.1")
+	require.True(t, ok)
+	require.Equal(t, int64(10), b.Expiry)
+}
+
+func TestServerClearExpiredBans(t *testing.T) {
+	s := New(nil)
+	require.NotNil(t, s)
+
+	n := time.Now().Unix()
+	s.Bans.Add(Ban{ClientID: "a", Expiry: n - 1})
+	s.Bans.Add(Ban{ClientID: "b", Expiry: n + 10})
+	s.Bans.Add(Ban{ClientID: "c"})
+	require.Equal(t, 3, s.Bans.Len())
+
+	s.clearExpiredBans(n)
+	require.Equal(t, 2, s.Bans.Len())
+	_, ok := s.Bans.Get("a@")
+	require.False(t, ok)
+}
+
+func TestServerBrokerInfo(t *testing.T) {
+	s := newServerWithInlineClient()
+	_ = s.AddListener(listeners.NewMockListener("t1", ":1882"))
+
+	bi := s.BrokerInfo()
+	require.Equal(t, Version, bi.Version)
+	require.Equal(t, runtime.Version(), bi.GoVersion)
+	require.Equal(t, runtime.GOOS, bi.OS)
+	require.Equal(t, runtime.GOARCH, bi.Arch)
+	require.Equal(t, s.Info.Started, bi.Started)
+	require.Equal(t, 1, bi.Listeners)
+	require.Equal(t, s.Options.Capabilities.MaximumQos, bi.MaximumQos)
+	require.True(t, bi.RetainAvailable)
+	require.True(t, bi.InlineClient)
+}
+
+func TestServerPublishSysTopicsBrokerInfo(t *testing.T) {
+	s := newServer()
+	s.publishSysTopics()
+
+	pk, ok := s.Topics.Retained.Get(SysPrefix + "/broker/info")
+	require.True(t, ok)
+
+	var bi BrokerInfo
+	err := json.Unmarshal(pk.Payload, &bi)
+	require.NoError(t, err)
+	require.Equal(t, Version, bi.Version)
+	require.Equal(t, runtime.Version(), bi.GoVersion)
+}
+
+func TestServerPublishSysTopicsRuntime(t *testing.T) {
+	s := newServer()
+	s.publishSysTopics()
+
+	pk, ok := s.Topics.Retained.Get(SysPrefix + "/broker/runtime")
+	require.True(t, ok)
+
+	var rs RuntimeStats
+	err := json.Unmarshal(pk.Payload, &rs)
+	require.NoError(t, err)
+	require.Greater(t, rs.Goroutines, 0)
+	require.Greater(t, rs.HeapAlloc, uint64(0))
+	require.Greater(t, rs.HeapSys, uint64(0))
+}
+
+func TestNewRuntimeStatsLastGCPause(t *testing.T) {
+	m := runtime.MemStats{NumGC: 2}
+	m.PauseNs[1] = 500
+	rs := newRuntimeStats(&m)
+	require.Equal(t, uint32(2), rs.NumGC)
+	require.Equal(t, uint64(500), rs.LastGCPauseNs)
+
+	rs = newRuntimeStats(&runtime.MemStats{})
+	require.Equal(t, uint64(0), rs.LastGCPauseNs)
+}
+
+func TestServerPublishSysTopicsTopicMetrics(t *testing.T) {
+	s := New(&Options{
+		Logger:            logger,
+		TopicMetricsDepth: 1,
+	})
+	s.publishToSubscribers(packets.Packet{TopicName: "a/b/c", Payload: []byte("hello")})
+	s.publishToSubscribers(packets.Packet{TopicName: SysPrefix + "/broker/uptime", Payload: []byte("1")})
+	s.publishSysTopics()
+
+	pk, ok := s.Topics.Retained.Get(SysPrefix + "/metrics/topics")
+	require.True(t, ok)
+
+	m := map[string]TopicStat{}
+	err := json.Unmarshal(pk.Payload, &m)
+	require.NoError(t, err)
+	require.Equal(t, map[string]TopicStat{"a": {Messages: 1, Bytes: 5}}, m)
+}
+
+func TestServerPublishSysTopicsTopicMetricsDisabled(t *testing.T) {
+	s := newServer()
+	s.publishToSubscribers(packets.Packet{TopicName: "a/b/c", Payload: []byte("hello")})
+	s.publishSysTopics()
+
+	_, ok := s.Topics.Retained.Get(SysPrefix + "/metrics/topics")
+	require.False(t, ok)
+	require.Equal(t, 0, s.TopicStats.Len())
+}
+
+func TestServerPublishSysTopicsDeliveryLatency(t *testing.T) {
+	s := New(&Options{
+		Logger:          logger,
+		DeliveryLatency: true,
+	})
+	s.Latency.Record("tcp1", "a/b", time.Millisecond*3)
+	s.publishSysTopics()
+
+	pk, ok := s.Topics.Retained.Get(SysPrefix + "/metrics/latency")
+	require.True(t, ok)
+
+	var hs []LatencyHistogram
+	err := json.Unmarshal(pk.Payload, &hs)
+	require.NoError(t, err)
+	require.Len(t, hs, 1)
+	require.Equal(t, "tcp1", hs[0].Listener)
+	require.Equal(t, int64(1), hs[0].Count)
+	require.Equal(t, s.Stats().Latency, hs)
+}
+
+func TestServerPublishSysTopicsDeliveryLatencyDisabled(t *testing.T) {
+	s := newServer()
+	s.publishSysTopics()
+
+	_, ok := s.Topics.Retained.Get(SysPrefix + "/metrics/latency")
+	require.False(t, ok)
+}
+
+func TestServerTopicsDump(t *testing.T) {
+	s := newServer()
+	s.Topics.Subscribe("cl1", packets.Subscription{Filter: "a/b"})
+
+	node := s.TopicsDump()
+	require.Len(t, node.Children, 1)
+	require.Equal(t, "a", node.Children[0].Filter)
+	require.Equal(t, []string{"cl1"}, node.Children[0].Children[0].Clients)
+}
+
+func TestServerStats(t *testing.T) {
+	s := New(&Options{
+		Logger:            logger,
+		TopicMetricsDepth: 2,
+	})
+	atomic.StoreInt64(&s.Info.MessagesReceived, 3)
+	s.publishToSubscribers(packets.Packet{TopicName: "a/b/c", Payload: []byte("hello")})
+
+	stats := s.Stats()
+	require.Equal(t, int64(3), stats.Info.MessagesReceived)
+	require.Equal(t, map[string]TopicStat{"a/b": {Messages: 1, Bytes: 5}}, stats.Topics)
+}
+
 func TestLoadServerInfoRestoreOnRestart(t *testing.T) {
 	s := New(nil)
 	s.Options.Capabilities.Compatibilities.RestoreSysInfoOnRestart = true
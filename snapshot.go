@@ -0,0 +1,271 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package mqtt
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+
+	"github.com/mochi-mqtt/server/v2/hooks/storage"
+	"github.com/mochi-mqtt/server/v2/packets"
+)
+
+// SnapshotVersion is the version of the snapshot format written by Server.Snapshot.
+const SnapshotVersion = 1
+
+var (
+	ErrSnapshotVersion = errors.New("unsupported snapshot version") // the snapshot was written by an incompatible version
+)
+
+// Snapshot is a point-in-time dump of the broker state, containing client sessions,
+// their subscriptions and inflight messages, and retained messages.
+type Snapshot struct {
+	Version       int                    `json:"version"`       // the version of the snapshot format
+	Created       int64                  `json:"created"`       // the time the snapshot was taken in unixtime
+	Clients       []storage.Client       `json:"clients"`       // client sessions
+	Subscriptions []storage.Subscription `json:"subscriptions"` // client subscriptions
+	Inflight      []storage.Message      `json:"inflight"`      // inflight messages, with the origin set to the owning client id
+	Retained      []storage.Message      `json:"retained"`      // retained messages
+}
+
+// Snapshot writes a versioned dump of the client sessions, subscriptions, inflight
+// messages, and retained messages held by the server to w. Inline clients are not
+// included in the snapshot.
+func (s *Server) Snapshot(w io.Writer) error {
+	snap := Snapshot{
+		Version:       SnapshotVersion,
+		Created:       s.Options.now().Unix(),
+		Clients:       []storage.Client{},
+		Subscriptions: []storage.Subscription{},
+		Inflight:      []storage.Message{},
+		Retained:      []storage.Message{},
+	}
+
+	for _, cl := range s.Clients.GetAll() {
+		if cl.Net.Inline {
+			continue
+		}
+
+		props := cl.Properties.Props.Copy(false)
+		snap.Clients = append(snap.Clients, storage.Client{
+			ID:              cl.ID,
+			T:               storage.ClientKey,
+			Remote:          cl.Net.Remote,
+			Listener:        cl.Net.Listener,
+			Username:        cl.Properties.Username,
+			Clean:           cl.Properties.Clean,
+			ProtocolVersion: cl.Properties.ProtocolVersion,
+			Properties: storage.ClientProperties{
+				SessionExpiryInterval:     props.SessionExpiryInterval,
+				SessionExpiryIntervalFlag: props.SessionExpiryIntervalFlag,
+				AuthenticationMethod:      props.AuthenticationMethod,
+				AuthenticationData:        props.AuthenticationData,
+				RequestProblemInfo:        props.RequestProblemInfo,
+				RequestProblemInfoFlag:    props.RequestProblemInfoFlag,
+				RequestResponseInfo:       props.RequestResponseInfo,
+				ReceiveMaximum:            props.ReceiveMaximum,
+				TopicAliasMaximum:         props.TopicAliasMaximum,
+				User:                      props.User,
+				MaximumPacketSize:         props.MaximumPacketSize,
+			},
+			Will: storage.ClientWill(cl.Properties.Will),
+		})
+
+		for _, sub := range cl.State.Subscriptions.GetAll() {
+			snap.Subscriptions = append(snap.Subscriptions, storage.Subscription{
+				ID:                cl.ID + ":" + sub.Filter,
+				T:                 storage.SubscriptionKey,
+				Client:            cl.ID,
+				Filter:            sub.Filter,
+				Identifier:        sub.Identifier,
+				RetainHandling:    sub.RetainHandling,
+				Qos:               sub.Qos,
+				RetainAsPublished: sub.RetainAsPublished,
+				NoLocal:           sub.NoLocal,
+			})
+		}
+
+		for _, pk := range cl.State.Inflight.GetAll(false) {
+			msg := snapshotMessage(pk, storage.InflightKey)
+			msg.ID = cl.ID + ":" + fmt.Sprint(pk.PacketID)
+			msg.Origin = cl.ID // inflight messages are restored to the client named by the origin
+			snap.Inflight = append(snap.Inflight, msg)
+		}
+	}
+
+	for _, pk := range s.Topics.RetainedMessages() {
+		msg := snapshotMessage(pk, storage.RetainedKey)
+		msg.ID = pk.TopicName
+		snap.Retained = append(snap.Retained, msg)
+	}
+
+	return json.NewEncoder(w).Encode(snap)
+}
+
+// Restore reads a snapshot written by Snapshot from r and loads the client sessions,
+// subscriptions, inflight messages, and retained messages into the server. Clients which
+// already exist on the server are not replaced. Hooks are notified of the restored state
+// as though it had been newly established, so that it is persisted by any storage hooks.
+// Restore is intended to be called before the server is started with Serve.
+func (s *Server) Restore(r io.Reader) error {
+	var snap Snapshot
+	if err := json.NewDecoder(r).Decode(&snap); err != nil {
+		return fmt.Errorf("failed to read snapshot; %w", err)
+	}
+
+	if err := s.RestoreSnapshot(snap); err != nil {
+		return err
+	}
+
+	s.Log.Info("restored snapshot", "clients", len(snap.Clients), "subscriptions", len(snap.Subscriptions), "retained", len(snap.Retained))
+	return nil
+}
+
+// RestoreSnapshot loads the state contained in a decoded snapshot into the server,
+// in the same manner as Restore.
+func (s *Server) RestoreSnapshot(snap Snapshot) error {
+	if snap.Version != SnapshotVersion {
+		return fmt.Errorf("%w: %d", ErrSnapshotVersion, snap.Version)
+	}
+
+	clients := make([]storage.Client, 0, len(snap.Clients))
+	for _, c := range snap.Clients {
+		if _, ok := s.Clients.Get(c.ID); ok {
+			continue
+		}
+		clients = append(clients, c)
+	}
+
+	s.loadClients(clients)
+	s.loadSubscriptions(snap.Subscriptions)
+	s.loadInflight(snap.Inflight)
+	s.loadRetained(snap.Retained)
+	atomic.StoreInt64(&s.Info.Retained, int64(s.Topics.Retained.Len()))
+	s.notifyRestored(snap, clients)
+
+	return nil
+}
+
+// notifyRestored passes the state restored from a snapshot to the session, subscription,
+// inflight, and retained message hooks. Clients which were not restored because they
+// already existed on the server are not passed to the session hooks.
+func (s *Server) notifyRestored(snap Snapshot, clients []storage.Client) {
+	for _, c := range clients {
+		if cl, ok := s.Clients.Get(c.ID); ok {
+			s.hooks.OnSessionEstablished(cl, packets.Packet{})
+		}
+	}
+
+	for _, sub := range snap.Subscriptions {
+		cl, ok := s.Clients.Get(sub.Client)
+		if !ok {
+			continue
+		}
+
+		if sb, ok := cl.State.Subscriptions.Get(sub.Filter); ok {
+			s.hooks.OnSubscribed(cl, packets.Packet{Filters: packets.Subscriptions{sb}}, []byte{sb.Qos})
+		}
+	}
+
+	for _, msg := range snap.Inflight {
+		if cl, ok := s.Clients.Get(msg.Origin); ok {
+			if pk, ok := cl.State.Inflight.Get(msg.PacketID); ok {
+				s.hooks.OnQosPublish(cl, pk, msg.Sent, 0)
+			}
+		}
+	}
+
+	if len(snap.Retained) == 0 {
+		return
+	}
+
+	cl := s.NewClient(nil, LocalListener, InlineClientId, true)
+	for _, msg := range snap.Retained {
+		if pk, ok := s.Topics.RetainedMessage(msg.TopicName); ok {
+			s.hooks.OnRetainMessage(cl, pk, 1)
+		}
+	}
+}
+
+// ExportRetained writes all retained messages held by the server to w as a JSON
+// array, returning the number of messages written.
+func (s *Server) ExportRetained(w io.Writer) (int, error) {
+	msgs := []storage.Message{}
+	for _, pk := range s.Topics.RetainedMessages() {
+		msg := snapshotMessage(pk, storage.RetainedKey)
+		msg.ID = pk.TopicName
+		msgs = append(msgs, msg)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(msgs); err != nil {
+		return 0, err
+	}
+
+	return len(msgs), nil
+}
+
+// ImportRetained reads a JSON array of retained messages written by ExportRetained
+// from r and retains them on the server, replacing any existing retained message for
+// the same topic. Hooks are notified of each message so they may be persisted by any
+// storage hooks. Messages without a created time are stamped with the server clock, and
+// message expiry intervals are measured from the created time, as for published messages.
+// Returns the number of messages imported.
+func (s *Server) ImportRetained(r io.Reader) (int, error) {
+	var msgs []storage.Message
+	if err := json.NewDecoder(r).Decode(&msgs); err != nil {
+		return 0, fmt.Errorf("failed to read retained messages; %w", err)
+	}
+
+	cl := s.NewClient(nil, LocalListener, InlineClientId, true)
+	for _, msg := range msgs {
+		pk := msg.ToPacket()
+		pk.FixedHeader.Type = packets.Publish
+		pk.FixedHeader.Retain = true
+		if pk.Created == 0 {
+			pk.Created = s.Options.now().Unix()
+		}
+
+		pk.Expiry = pk.Created + s.Options.Capabilities.MaximumMessageExpiryInterval
+		if pk.Properties.MessageExpiryInterval > 0 {
+			pk.ProtocolVersion = 5 // only v5 messages carry an expiry interval
+			pk.Expiry = pk.Created + int64(pk.Properties.MessageExpiryInterval)
+		}
+
+		s.retainMessage(cl, pk)
+	}
+
+	return len(msgs), nil
+}
+
+// snapshotMessage converts a packet to a storage message of type t.
+func snapshotMessage(pk packets.Packet, t string) storage.Message {
+	props := pk.Properties.Copy(false)
+	return storage.Message{
+		T:           t,
+		FixedHeader: pk.FixedHeader,
+		TopicName:   pk.TopicName,
+		Payload:     pk.Payload,
+		Compressed:  pk.Compressed,
+		Origin:      pk.Origin,
+		Created:     pk.Created,
+		PacketID:    pk.PacketID,
+		Properties: storage.MessageProperties{
+			PayloadFormat:          props.PayloadFormat,
+			PayloadFormatFlag:      props.PayloadFormatFlag,
+			MessageExpiryInterval:  props.MessageExpiryInterval,
+			ContentType:            props.ContentType,
+			ResponseTopic:          props.ResponseTopic,
+			CorrelationData:        props.CorrelationData,
+			SubscriptionIdentifier: props.SubscriptionIdentifier,
+			TopicAlias:             props.TopicAlias,
+			User:                   props.User,
+		},
+	}
+}
@@ -0,0 +1,242 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package mqtt
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mochi-mqtt/server/v2/hooks/storage"
+	"github.com/mochi-mqtt/server/v2/packets"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	s := newServerWithInlineClient()
+	s.loadClients([]storage.Client{
+		{ID: "mochi", ProtocolVersion: 4, Username: []byte("mochi-user")},
+	})
+	s.loadSubscriptions([]storage.Subscription{
+		{Client: "mochi", Filter: "a/b/c", Qos: 1},
+	})
+	s.loadInflight([]storage.Message{
+		{Origin: "mochi", PacketID: 7, TopicName: "a/b/c", Payload: []byte("inflight"), FixedHeader: packets.FixedHeader{Type: packets.Publish, Qos: 1}},
+	})
+	s.loadRetained([]storage.Message{
+		{TopicName: "d/e/f", Payload: []byte("retained"), FixedHeader: packets.FixedHeader{Type: packets.Publish, Retain: true}},
+	})
+
+	buf := new(bytes.Buffer)
+	err := s.Snapshot(buf)
+	require.NoError(t, err)
+
+	r := newServer()
+	err = r.Restore(buf)
+	require.NoError(t, err)
+
+	cl, ok := r.Clients.Get("mochi")
+	require.True(t, ok)
+	require.Equal(t, []byte("mochi-user"), cl.Properties.Username)
+	require.Equal(t, byte(4), cl.Properties.ProtocolVersion)
+
+	sub, ok := cl.State.Subscriptions.Get("a/b/c")
+	require.True(t, ok)
+	require.Equal(t, byte(1), sub.Qos)
+	require.Equal(t, 1, len(r.Topics.Subscribers("a/b/c").Subscriptions))
+
+	pk, ok := cl.State.Inflight.Get(7)
+	require.True(t, ok)
+	require.Equal(t, []byte("inflight"), pk.Payload)
+
+	retained := r.Topics.Messages("d/e/f")
+	require.Len(t, retained, 1)
+	require.Equal(t, []byte("retained"), retained[0].Payload)
+}
+
+type restoreRecorderHook struct {
+	HookBase
+	sessions   []string
+	subscribed []string
+	inflight   []uint16
+	retained   []string
+}
+
+func (h *restoreRecorderHook) ID() string {
+	return "restore-recorder"
+}
+
+func (h *restoreRecorderHook) Provides(b byte) bool {
+	return bytes.Contains([]byte{OnSessionEstablished, OnSubscribed, OnQosPublish, OnRetainMessage}, []byte{b})
+}
+
+func (h *restoreRecorderHook) OnSessionEstablished(cl *Client, pk packets.Packet) {
+	h.sessions = append(h.sessions, cl.ID)
+}
+
+func (h *restoreRecorderHook) OnSubscribed(cl *Client, pk packets.Packet, reasonCodes []byte) {
+	for i, sub := range pk.Filters {
+		h.subscribed = append(h.subscribed, cl.ID+":"+sub.Filter+":"+string('0'+reasonCodes[i]))
+	}
+}
+
+func (h *restoreRecorderHook) OnQosPublish(cl *Client, pk packets.Packet, sent int64, resends int) {
+	h.inflight = append(h.inflight, pk.PacketID)
+}
+
+func (h *restoreRecorderHook) OnRetainMessage(cl *Client, pk packets.Packet, r int64) {
+	h.retained = append(h.retained, pk.TopicName+":"+string(pk.Payload))
+}
+
+func TestRestoreNotifiesHooks(t *testing.T) {
+	s := newServer()
+	s.loadClients([]storage.Client{{ID: "mochi", ProtocolVersion: 4}, {ID: "zen", ProtocolVersion: 4}})
+	s.loadSubscriptions([]storage.Subscription{{Client: "mochi", Filter: "a/b/c", Qos: 1}})
+	s.loadInflight([]storage.Message{
+		{Origin: "mochi", PacketID: 7, TopicName: "a/b/c", FixedHeader: packets.FixedHeader{Type: packets.Publish, Qos: 1}},
+	})
+	s.loadRetained([]storage.Message{
+		{TopicName: "d/e/f", Payload: []byte("retained"), FixedHeader: packets.FixedHeader{Type: packets.Publish, Retain: true}},
+	})
+
+	buf := new(bytes.Buffer)
+	require.NoError(t, s.Snapshot(buf))
+
+	r := newServer()
+	r.loadClients([]storage.Client{{ID: "zen", ProtocolVersion: 4}})
+	hook := new(restoreRecorderHook)
+	require.NoError(t, r.AddHook(hook, nil))
+	require.NoError(t, r.Restore(buf))
+
+	require.Equal(t, []string{"mochi"}, hook.sessions) // zen already existed
+	require.Equal(t, []string{"mochi:a/b/c:1"}, hook.subscribed)
+	require.Equal(t, []uint16{7}, hook.inflight)
+	require.Equal(t, []string{"d/e/f:retained"}, hook.retained)
+}
+
+func TestSnapshotSkipsInlineClient(t *testing.T) {
+	s := newServerWithInlineClient()
+	buf := new(bytes.Buffer)
+	err := s.Snapshot(buf)
+	require.NoError(t, err)
+
+	var snap Snapshot
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &snap))
+	require.Equal(t, SnapshotVersion, snap.Version)
+	require.Empty(t, snap.Clients)
+}
+
+func TestRestoreExistingClient(t *testing.T) {
+	s := newServer()
+	s.loadClients([]storage.Client{{ID: "mochi", Username: []byte("a")}})
+	buf := new(bytes.Buffer)
+	require.NoError(t, s.Snapshot(buf))
+
+	r := newServer()
+	r.loadClients([]storage.Client{{ID: "mochi", Username: []byte("b")}})
+	require.NoError(t, r.Restore(buf))
+
+	cl, ok := r.Clients.Get("mochi")
+	require.True(t, ok)
+	require.Equal(t, []byte("b"), cl.Properties.Username)
+}
+
+func TestRestoreBadVersion(t *testing.T) {
+	s := newServer()
+	err := s.Restore(strings.NewReader(`{"version":99}`))
+	require.ErrorIs(t, err, ErrSnapshotVersion)
+}
+
+func TestRestoreBadJSON(t *testing.T) {
+	s := newServer()
+	err := s.Restore(strings.NewReader(`{`))
+	require.Error(t, err)
+}
+
+func TestExportImportRetained(t *testing.T) {
+	s := newServer()
+	s.loadRetained([]storage.Message{
+		{TopicName: "a/b/c", Payload: []byte("hello"), FixedHeader: packets.FixedHeader{Type: packets.Publish, Retain: true, Qos: 1}},
+		{TopicName: "d/e/f", Payload: []byte("world"), FixedHeader: packets.FixedHeader{Type: packets.Publish, Retain: true}},
+	})
+
+	buf := new(bytes.Buffer)
+	n, err := s.ExportRetained(buf)
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+
+	r := newServer()
+	n, err = r.ImportRetained(buf)
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+	require.Equal(t, int64(2), r.Info.Retained)
+
+	pks := r.Topics.Messages("a/b/c")
+	require.Len(t, pks, 1)
+	require.Equal(t, []byte("hello"), pks[0].Payload)
+	require.Equal(t, byte(1), pks[0].FixedHeader.Qos)
+	require.True(t, pks[0].FixedHeader.Retain)
+}
+
+func TestImportRetainedUsesClock(t *testing.T) {
+	s := New(&Options{
+		Logger: logger,
+		Clock:  fixedClock(time.Unix(1000, 0)),
+	})
+
+	n, err := s.ImportRetained(strings.NewReader(`[
+		{"topic_name": "a/b/c", "payload": "aGVsbG8=", "properties": {"messageExpiry": 60}},
+		{"topic_name": "d/e/f", "payload": "d29ybGQ=", "created": 900}
+	]`))
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+
+	pk, ok := s.Topics.Retained.Get("a/b/c")
+	require.True(t, ok)
+	require.Equal(t, int64(1000), pk.Created)
+	require.Equal(t, int64(1060), pk.Expiry)
+
+	pk, ok = s.Topics.Retained.Get("d/e/f")
+	require.True(t, ok)
+	require.Equal(t, int64(900), pk.Created)
+	require.Equal(t, 900+s.Options.Capabilities.MaximumMessageExpiryInterval, pk.Expiry)
+
+	s.clearExpiredRetainedMessages(1061)
+	_, ok = s.Topics.Retained.Get("a/b/c")
+	require.False(t, ok)
+	_, ok = s.Topics.Retained.Get("d/e/f")
+	require.True(t, ok)
+}
+
+func TestSnapshotUsesClock(t *testing.T) {
+	s := New(&Options{
+		Logger: logger,
+		Clock:  fixedClock(time.Unix(1000, 0)),
+	})
+
+	buf := new(bytes.Buffer)
+	require.NoError(t, s.Snapshot(buf))
+
+	var snap Snapshot
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &snap))
+	require.Equal(t, int64(1000), snap.Created)
+}
+
+func TestExportRetainedEmpty(t *testing.T) {
+	s := newServer()
+	buf := new(bytes.Buffer)
+	n, err := s.ExportRetained(buf)
+	require.NoError(t, err)
+	require.Equal(t, 0, n)
+	require.Equal(t, "[]\n", buf.String())
+}
+
+func TestImportRetainedBadJSON(t *testing.T) {
+	s := newServer()
+	_, err := s.ImportRetained(strings.NewReader(`{`))
+	require.Error(t, err)
+}
@@ -0,0 +1,95 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package mqtt
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	connectStormHold       = 10 * time.Second // how long storm shaping stays active after the rate falls below the threshold
+	connectStormMaxBackoff = 64               // the maximum multiple of the base backoff applied to an ip
+)
+
+// connectBackoff is the throttling state of a remote ip during a connect storm.
+type connectBackoff struct {
+	next     time.Time     // the earliest time the ip may connect again
+	interval time.Duration // the current backoff interval of the ip
+}
+
+// connectStorm detects mass reconnect events, such as those following a broker restart,
+// and shapes them by delaying CONNACKs and throttling repeated connects from the same ip.
+type connectStorm struct {
+	sync.Mutex
+	window  time.Time                  // the start of the current one second counting window
+	count   int64                      // the number of connect attempts in the current window
+	until   time.Time                  // the time storm shaping remains active until
+	backoff map[string]*connectBackoff // throttling state keyed on remote ip
+}
+
+// newConnectStorm returns a new instance of connectStorm.
+func newConnectStorm() *connectStorm {
+	return &connectStorm{
+		backoff: map[string]*connectBackoff{},
+	}
+}
+
+// attempt records a connect attempt from an ip at a time, returning true if storm shaping
+// is active, and false for allowed if the ip must be refused under the backoff policy.
+func (c *connectStorm) attempt(ip string, now time.Time, rate int64, base time.Duration) (active, allowed bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	if now.Sub(c.window) >= time.Second {
+		c.window = now
+		c.count = 0
+		for k, b := range c.backoff {
+			if now.After(b.next.Add(b.interval)) {
+				delete(c.backoff, k) // the ip has been quiet for a full interval
+			}
+		}
+	}
+
+	c.count++
+	if c.count >= rate {
+		c.until = now.Add(connectStormHold)
+	}
+
+	if !now.Before(c.until) {
+		return false, true
+	}
+
+	if base <= 0 || ip == "" {
+		return true, true
+	}
+
+	b, ok := c.backoff[ip]
+	if !ok {
+		c.backoff[ip] = &connectBackoff{next: now.Add(base), interval: base}
+		return true, true
+	}
+
+	if now.Before(b.next) {
+		if b.interval < base*connectStormMaxBackoff {
+			b.interval *= 2
+		}
+		b.next = now.Add(b.interval)
+		return true, false
+	}
+
+	b.next = now.Add(b.interval)
+	return true, true
+}
+
+// jitter returns a random delay of up to max.
+func (c *connectStorm) jitter(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+
+	return time.Duration(rand.Int63n(int64(max) + 1)) // #nosec G404
+}
@@ -0,0 +1,118 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package mqtt
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mochi-mqtt/server/v2/packets"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnectStormAttempt(t *testing.T) {
+	c := newConnectStorm()
+	now := time.Unix(1700000000, 0)
+
+	active, allowed := c.attempt("1.2.3.4", now, 3, time.Second)
+	require.False(t, active)
+	require.True(t, allowed)
+
+	active, _ = c.attempt("1.2.3.5", now, 3, time.Second)
+	require.False(t, active)
+
+	// the third attempt in the window reaches the rate and activates shaping
+	active, allowed = c.attempt("1.2.3.4", now, 3, time.Second)
+	require.True(t, active)
+	require.True(t, allowed)
+
+	// a repeat connect from the same ip inside the backoff is refused, doubling the interval
+	active, allowed = c.attempt("1.2.3.4", now.Add(time.Millisecond*100), 3, time.Second)
+	require.True(t, active)
+	require.False(t, allowed)
+	require.Equal(t, time.Second*2, c.backoff["1.2.3.4"].interval)
+
+	active, allowed = c.attempt("1.2.3.4", now.Add(time.Second*3), 3, time.Second)
+	require.True(t, active)
+	require.True(t, allowed)
+
+	// shaping ends once the rate has stayed low for the hold period
+	active, allowed = c.attempt("1.2.3.4", now.Add(connectStormHold+time.Second), 3, time.Second)
+	require.False(t, active)
+	require.True(t, allowed)
+}
+
+func TestConnectStormBackoffCapped(t *testing.T) {
+	c := newConnectStorm()
+	now := time.Unix(1700000000, 0)
+	for i := 0; i < 20; i++ {
+		c.attempt("1.2.3.4", now, 1, time.Second)
+	}
+	require.Equal(t, time.Second*connectStormMaxBackoff, c.backoff["1.2.3.4"].interval)
+}
+
+func TestConnectStormBackoffPruned(t *testing.T) {
+	c := newConnectStorm()
+	now := time.Unix(1700000000, 0)
+	c.attempt("1.2.3.4", now, 1, time.Second)
+	require.Len(t, c.backoff, 1)
+
+	c.attempt("1.2.3.5", now.Add(time.Second*3), 1, time.Second)
+	require.NotContains(t, c.backoff, "1.2.3.4")
+}
+
+func TestConnectStormNoBackoff(t *testing.T) {
+	c := newConnectStorm()
+	now := time.Unix(1700000000, 0)
+	for i := 0; i < 3; i++ {
+		active, allowed := c.attempt("1.2.3.4", now, 1, 0)
+		require.True(t, active)
+		require.True(t, allowed)
+	}
+	require.Empty(t, c.backoff)
+}
+
+func TestConnectStormJitter(t *testing.T) {
+	c := newConnectStorm()
+	require.Equal(t, time.Duration(0), c.jitter(0))
+	for i := 0; i < 10; i++ {
+		require.LessOrEqual(t, c.jitter(time.Millisecond), time.Millisecond)
+	}
+}
+
+func TestEstablishConnectionConnectStormThrottled(t *testing.T) {
+	s := newServer()
+	s.Options.ConnectStormRate = 1
+	s.Options.ConnectStormBackoff = 60000
+	defer s.Close()
+
+	// an earlier attempt from the pipe activates shaping and starts its backoff
+	s.storm.attempt("pipe", s.Options.now(), 1, time.Minute)
+
+	r, w := net.Pipe()
+	o := make(chan error)
+	go func() {
+		o <- s.EstablishConnection("tcp", r)
+	}()
+
+	go func() {
+		_, _ = w.Write(packets.TPacketData[packets.Connect].Get(packets.TConnectMqtt5).RawBytes)
+	}()
+
+	recv := make(chan []byte)
+	go func() {
+		buf, _ := io.ReadAll(w)
+		recv <- buf
+	}()
+
+	require.ErrorIs(t, <-o, packets.ErrServerBusy)
+	_ = r.Close()
+
+	buf := <-recv
+	require.Equal(t, packets.Connack<<4, buf[0])
+	require.Equal(t, packets.ErrServerBusy.Code, buf[3])
+}
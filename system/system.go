@@ -23,6 +23,7 @@ type Info struct {
 	MessagesReceived    int64  `json:"messages_received"`    // total number of publish messages received
 	MessagesSent        int64  `json:"messages_sent"`        // total number of publish messages sent
 	MessagesDropped     int64  `json:"messages_dropped"`     // total number of publish messages dropped to slow subscriber
+	MessagesThrottled   int64  `json:"messages_throttled"`   // total number of publish messages held to apply backpressure to the publisher
 	Retained            int64  `json:"retained"`             // total number of retained messages active on the broker
 	Inflight            int64  `json:"inflight"`             // the number of messages currently in-flight
 	InflightDropped     int64  `json:"inflight_dropped"`     // the number of inflight messages which were dropped
@@ -49,6 +50,7 @@ func (i *Info) Clone() *Info {
 		MessagesReceived:    atomic.LoadInt64(&i.MessagesReceived),
 		MessagesSent:        atomic.LoadInt64(&i.MessagesSent),
 		MessagesDropped:     atomic.LoadInt64(&i.MessagesDropped),
+		MessagesThrottled:   atomic.LoadInt64(&i.MessagesThrottled),
 		Retained:            atomic.LoadInt64(&i.Retained),
 		Inflight:            atomic.LoadInt64(&i.Inflight),
 		InflightDropped:     atomic.LoadInt64(&i.InflightDropped),
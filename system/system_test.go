@@ -21,6 +21,7 @@ func TestClone(t *testing.T) {
 		MessagesReceived:    10,
 		MessagesSent:        11,
 		MessagesDropped:     20,
+		MessagesThrottled:   21,
 		Retained:            12,
 		Inflight:            13,
 		InflightDropped:     14,
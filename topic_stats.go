@@ -0,0 +1,130 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package mqtt
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// TopicStatsOther is the prefix under which messages are counted once the limit of
+// separately counted topic prefixes has been reached.
+const TopicStatsOther = "other"
+
+// TopicStat contains message and byte counters for a topic namespace.
+type TopicStat struct {
+	Messages int64 `json:"messages"` // the number of messages published to the namespace
+	Bytes    int64 `json:"bytes"`    // the number of payload bytes published to the namespace
+}
+
+// TopicStats is a map of message counters keyed on topic prefix, where the prefix
+// is the first depth levels of the topic name.
+type TopicStats struct {
+	internal map[string]*TopicStat // counters keyed on topic prefix
+	depth    int                   // the number of topic levels used as the prefix
+	limit    int                   // the maximum number of prefixes counted separately, or 0 for no limit
+	sync.RWMutex
+}
+
+// NewTopicStats returns a new instance of TopicStats which counts topics by the
+// first depth levels of the topic name. A depth of 0 or less disables counting.
+func NewTopicStats(depth int) *TopicStats {
+	return &TopicStats{
+		internal: map[string]*TopicStat{},
+		depth:    depth,
+	}
+}
+
+// SetLimit sets the maximum number of topic prefixes counted separately. Once the limit
+// is reached, messages on new prefixes are counted under TopicStatsOther. There is no
+// limit if limit is 0 or less.
+func (t *TopicStats) SetLimit(limit int) {
+	t.Lock()
+	defer t.Unlock()
+	t.limit = max(limit, 0)
+}
+
+// Record increments the counters for the namespace of a topic.
+func (t *TopicStats) Record(topic string, size int) {
+	if t.depth <= 0 {
+		return
+	}
+
+	key := t.prefix(topic)
+	t.RLock()
+	stat, ok := t.internal[key]
+	t.RUnlock()
+
+	if !ok {
+		t.Lock()
+		if stat, ok = t.internal[key]; !ok {
+			if t.limit > 0 && len(t.internal) >= t.limit {
+				key = TopicStatsOther
+			}
+
+			if stat, ok = t.internal[key]; !ok {
+				stat = new(TopicStat)
+				t.internal[key] = stat
+			}
+		}
+		t.Unlock()
+	}
+
+	atomic.AddInt64(&stat.Messages, 1)
+	atomic.AddInt64(&stat.Bytes, int64(size))
+}
+
+// Get returns a copy of the counters for a topic prefix, if it exists.
+func (t *TopicStats) Get(prefix string) (TopicStat, bool) {
+	t.RLock()
+	defer t.RUnlock()
+	stat, ok := t.internal[prefix]
+	if !ok {
+		return TopicStat{}, false
+	}
+
+	return stat.clone(), true
+}
+
+// GetAll returns a copy of all counters keyed on topic prefix.
+func (t *TopicStats) GetAll() map[string]TopicStat {
+	t.RLock()
+	defer t.RUnlock()
+	m := make(map[string]TopicStat, len(t.internal))
+	for k, v := range t.internal {
+		m[k] = v.clone()
+	}
+	return m
+}
+
+// Len returns the number of counted topic prefixes.
+func (t *TopicStats) Len() int {
+	t.RLock()
+	defer t.RUnlock()
+	return len(t.internal)
+}
+
+// prefix returns the first depth levels of a topic.
+func (t *TopicStats) prefix(topic string) string {
+	i := 0
+	for n := 0; n < t.depth; n++ {
+		j := strings.IndexByte(topic[i:], '/')
+		if j < 0 {
+			return topic
+		}
+		i += j + 1
+	}
+
+	return topic[:i-1]
+}
+
+// clone returns a copy of the counters using atomic operations.
+func (s *TopicStat) clone() TopicStat {
+	return TopicStat{
+		Messages: atomic.LoadInt64(&s.Messages),
+		Bytes:    atomic.LoadInt64(&s.Bytes),
+	}
+}
@@ -0,0 +1,86 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package mqtt
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTopicStatsPrefix(t *testing.T) {
+	tt := []struct {
+		depth  int
+		topic  string
+		expect string
+	}{
+		{depth: 1, topic: "a/b/c", expect: "a"},
+		{depth: 2, topic: "a/b/c", expect: "a/b"},
+		{depth: 3, topic: "a/b/c", expect: "a/b/c"},
+		{depth: 5, topic: "a/b/c", expect: "a/b/c"},
+		{depth: 1, topic: "a", expect: "a"},
+		{depth: 1, topic: "/a/b", expect: ""},
+		{depth: 2, topic: "/a/b", expect: "/a"},
+	}
+
+	for _, tx := range tt {
+		require.Equal(t, tx.expect, NewTopicStats(tx.depth).prefix(tx.topic), tx.topic)
+	}
+}
+
+func TestTopicStatsRecord(t *testing.T) {
+	ts := NewTopicStats(1)
+	ts.Record("a/b/c", 3)
+	ts.Record("a/d", 5)
+	ts.Record("x/y", 1)
+	require.Equal(t, 2, ts.Len())
+
+	stat, ok := ts.Get("a")
+	require.True(t, ok)
+	require.Equal(t, TopicStat{Messages: 2, Bytes: 8}, stat)
+
+	_, ok = ts.Get("q")
+	require.False(t, ok)
+
+	require.Equal(t, map[string]TopicStat{
+		"a": {Messages: 2, Bytes: 8},
+		"x": {Messages: 1, Bytes: 1},
+	}, ts.GetAll())
+}
+
+func TestTopicStatsRecordLimit(t *testing.T) {
+	ts := NewTopicStats(1)
+	ts.SetLimit(2)
+	ts.Record("a/b", 1)
+	ts.Record("b/c", 2)
+	ts.Record("c/d", 3)
+	ts.Record("d/e", 4)
+	ts.Record("a/c", 5)
+
+	require.Equal(t, map[string]TopicStat{
+		"a":             {Messages: 2, Bytes: 6},
+		"b":             {Messages: 1, Bytes: 2},
+		TopicStatsOther: {Messages: 2, Bytes: 7},
+	}, ts.GetAll())
+
+	ts.SetLimit(-1)
+	ts.Record("e/f", 1)
+	require.Equal(t, 4, ts.Len())
+}
+
+func TestServerTopicMetricsLimit(t *testing.T) {
+	s := New(&Options{Logger: logger, TopicMetricsDepth: 1})
+	require.Equal(t, defaultTopicMetricsLimit, s.Options.TopicMetricsLimit)
+	require.Equal(t, defaultTopicMetricsLimit, s.TopicStats.limit)
+
+	s = New(&Options{Logger: logger})
+	require.Equal(t, 0, s.Options.TopicMetricsLimit)
+}
+
+func TestTopicStatsRecordDisabled(t *testing.T) {
+	ts := NewTopicStats(0)
+	ts.Record("a/b/c", 3)
+	require.Equal(t, 0, ts.Len())
+}
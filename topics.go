@@ -5,10 +5,12 @@
 package mqtt
 
 import (
+	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
 
+	"github.com/golang/snappy"
 	"github.com/mochi-mqtt/server/v2/packets"
 )
 
@@ -66,6 +68,7 @@ func (a *InboundTopicAliases) Set(id uint16, topic string) string {
 // OutboundTopicAliases contains a map of topic aliases sent from the broker to the client.
 type OutboundTopicAliases struct {
 	internal map[string]uint16
+	topics   map[uint16]string // reverse index of aliases to topics
 	sync.RWMutex
 	cursor  uint32
 	maximum uint16
@@ -76,6 +79,7 @@ func NewOutboundTopicAliases(topicAliasMaximum uint16) *OutboundTopicAliases {
 	return &OutboundTopicAliases{
 		maximum:  topicAliasMaximum,
 		internal: map[string]uint16{},
+		topics:   map[uint16]string{},
 	}
 }
 
@@ -100,10 +104,19 @@ func (a *OutboundTopicAliases) Set(topic string) (uint16, bool) {
 	}
 
 	a.internal[topic] = uint16(i) + 1
+	a.topics[uint16(i)+1] = topic
 	atomic.StoreUint32(&a.cursor, i+1)
 	return uint16(i) + 1, false
 }
 
+// Topic returns the topic which was assigned an alias, or an empty string if the alias
+// has not been set.
+func (a *OutboundTopicAliases) Topic(alias uint16) string {
+	a.RLock()
+	defer a.RUnlock()
+	return a.topics[alias]
+}
+
 // SharedSubscriptions contains a map of subscriptions to a shared filter,
 // keyed on share group then client id.
 type SharedSubscriptions struct {
@@ -346,10 +359,118 @@ func (s *Subscribers) MergeSharedSelected() {
 	}
 }
 
+// Clone returns a copy of the subscribers which can be modified without affecting the original.
+func (s *Subscribers) Clone() *Subscribers {
+	c := &Subscribers{
+		Shared:              make(map[string]map[string]packets.Subscription, len(s.Shared)),
+		SharedSelected:      make(map[string]packets.Subscription, len(s.SharedSelected)),
+		Subscriptions:       make(map[string]packets.Subscription, len(s.Subscriptions)),
+		InlineSubscriptions: make(map[int]InlineSubscription, len(s.InlineSubscriptions)),
+	}
+
+	for filter, subs := range s.Shared {
+		c.Shared[filter] = make(map[string]packets.Subscription, len(subs))
+		for client, sub := range subs {
+			c.Shared[filter][client] = cloneSubscription(sub)
+		}
+	}
+
+	for client, sub := range s.SharedSelected {
+		c.SharedSelected[client] = cloneSubscription(sub)
+	}
+
+	for client, sub := range s.Subscriptions {
+		c.Subscriptions[client] = cloneSubscription(sub)
+	}
+
+	for id, inline := range s.InlineSubscriptions {
+		c.InlineSubscriptions[id] = inline
+	}
+
+	return c
+}
+
+// cloneSubscription returns a copy of a subscription with its own identifiers map, as
+// merging subscriptions modifies the identifiers in place.
+func cloneSubscription(sub packets.Subscription) packets.Subscription {
+	if sub.Identifiers != nil {
+		ids := make(map[string]int, len(sub.Identifiers))
+		for k, v := range sub.Identifiers {
+			ids[k] = v
+		}
+		sub.Identifiers = ids
+	}
+
+	return sub
+}
+
+// subscriberCache contains the subscribers resolved for recently published topics, so
+// messages on frequently used topics can be delivered without walking the index.
+type subscriberCache struct {
+	internal   map[string]subscriberCacheEntry // cached subscribers keyed on topic
+	size       int                             // the maximum number of cached topics
+	generation uint64                          // incremented whenever the subscriptions of the index change, atomic
+	sync.RWMutex
+}
+
+// subscriberCacheEntry contains the subscribers of a topic and the generation of the
+// index they were resolved from.
+type subscriberCacheEntry struct {
+	subs       *Subscribers
+	generation uint64
+}
+
+// get returns the cached subscribers of a topic if they were resolved from the given generation.
+func (c *subscriberCache) get(topic string, generation uint64) (*Subscribers, bool) {
+	c.RLock()
+	defer c.RUnlock()
+	e, ok := c.internal[topic]
+	if !ok || e.generation != generation {
+		return nil, false
+	}
+
+	return e.subs, true
+}
+
+// add caches the subscribers of a topic, evicting an arbitrary topic if the cache is full.
+func (c *subscriberCache) add(topic string, generation uint64, subs *Subscribers) {
+	c.Lock()
+	defer c.Unlock()
+	if atomic.LoadUint64(&c.generation) != generation {
+		return // the subscriptions changed while the subscribers were being resolved
+	}
+
+	if _, ok := c.internal[topic]; !ok && len(c.internal) >= c.size {
+		for k := range c.internal {
+			delete(c.internal, k)
+			break
+		}
+	}
+
+	c.internal[topic] = subscriberCacheEntry{subs: subs, generation: generation}
+}
+
+// invalidate discards all cached subscribers.
+func (c *subscriberCache) invalidate() {
+	c.Lock()
+	defer c.Unlock()
+	atomic.AddUint64(&c.generation, 1)
+	c.internal = make(map[string]subscriberCacheEntry, c.size)
+}
+
+// len returns the number of cached topics.
+func (c *subscriberCache) len() int {
+	c.RLock()
+	defer c.RUnlock()
+	return len(c.internal)
+}
+
 // TopicsIndex is a prefix/trie tree containing topic subscribers and retained messages.
 type TopicsIndex struct {
-	Retained *packets.Packets
-	root     *particle // a leaf containing a message and more leaves.
+	Retained      *packets.Packets              // retained messages as stored, which may be compressed; see RetainedMessage
+	root          *particle                     // a leaf containing a message and more leaves.
+	cache         *subscriberCache              // cached subscribers of recently published topics, if enabled
+	onDecodeError func(topic string, err error) // called when a compressed retained payload cannot be decoded
 }
 
 // NewTopicsIndex returns a pointer to a new instance of Index.
@@ -363,11 +484,43 @@ func NewTopicsIndex() *TopicsIndex {
 	}
 }
 
+// SetSubscriberCacheSize enables caching of the subscribers resolved for up to size recently
+// published topics, which is discarded whenever a subscription is added or removed. Caching is
+// disabled if size is 0 or less. It should be called before the index is in use.
+func (x *TopicsIndex) SetSubscriberCacheSize(size int) {
+	if size <= 0 {
+		x.cache = nil
+		return
+	}
+
+	x.cache = &subscriberCache{
+		internal: make(map[string]subscriberCacheEntry, size),
+		size:     size,
+	}
+}
+
+// SubscriberCacheLen returns the number of topics with cached subscribers.
+func (x *TopicsIndex) SubscriberCacheLen() int {
+	if x.cache == nil {
+		return 0
+	}
+
+	return x.cache.len()
+}
+
+// invalidateSubscribers discards any cached subscribers after the subscriptions change.
+func (x *TopicsIndex) invalidateSubscribers() {
+	if x.cache != nil {
+		x.cache.invalidate()
+	}
+}
+
 // InlineSubscribe adds a new internal subscription for a topic filter, returning
 // true if the subscription was new.
 func (x *TopicsIndex) InlineSubscribe(subscription InlineSubscription) bool {
 	x.root.Lock()
 	defer x.root.Unlock()
+	defer x.invalidateSubscribers()
 
 	var existed bool
 	n := x.set(subscription.Filter, 0)
@@ -382,6 +535,7 @@ func (x *TopicsIndex) InlineSubscribe(subscription InlineSubscription) bool {
 func (x *TopicsIndex) InlineUnsubscribe(id int, filter string) bool {
 	x.root.Lock()
 	defer x.root.Unlock()
+	defer x.invalidateSubscribers()
 
 	particle := x.seek(filter, 0)
 	if particle == nil {
@@ -401,6 +555,7 @@ func (x *TopicsIndex) InlineUnsubscribe(id int, filter string) bool {
 func (x *TopicsIndex) Subscribe(client string, subscription packets.Subscription) bool {
 	x.root.Lock()
 	defer x.root.Unlock()
+	defer x.invalidateSubscribers()
 
 	var existed bool
 	prefix, _ := isolateParticle(subscription.Filter, 0)
@@ -423,6 +578,7 @@ func (x *TopicsIndex) Subscribe(client string, subscription packets.Subscription
 func (x *TopicsIndex) Unsubscribe(filter, client string) bool {
 	x.root.Lock()
 	defer x.root.Unlock()
+	defer x.invalidateSubscribers()
 
 	var d int
 	prefix, _ := isolateParticle(filter, 0)
@@ -436,15 +592,18 @@ func (x *TopicsIndex) Unsubscribe(filter, client string) bool {
 		return false
 	}
 
+	var existed bool
 	if shareSub {
 		group, _ := isolateParticle(filter, 1)
+		_, existed = particle.shared.Get(group, client)
 		particle.shared.Delete(group, client)
 	} else {
+		_, existed = particle.subscriptions.Get(client)
 		particle.subscriptions.Delete(client)
 	}
 
 	x.trim(particle)
-	return true
+	return existed
 }
 
 // RetainMessage saves a message payload to the end of a topic address. Returns
@@ -521,6 +680,52 @@ func (x *TopicsIndex) trim(n *particle) {
 	}
 }
 
+// RetainedMessage returns the retained message for a topic, with its payload decoded if it
+// was compressed. ok is false if there is no retained message for the topic, or if its
+// payload cannot be decoded, in which case the error is reported.
+func (x *TopicsIndex) RetainedMessage(topic string) (packets.Packet, bool) {
+	pk, ok := x.Retained.Get(topic)
+	if !ok {
+		return pk, false
+	}
+
+	return x.decodeRetained(topic, pk)
+}
+
+// RetainedMessages returns all retained messages keyed on topic, with their payloads decoded
+// if they were compressed. Messages with payloads which cannot be decoded are reported, and
+// returned still compressed so that they are not lost from snapshots.
+func (x *TopicsIndex) RetainedMessages() map[string]packets.Packet {
+	m := x.Retained.GetAll()
+	for topic, pk := range m {
+		if pk, ok := x.decodeRetained(topic, pk); ok {
+			m[topic] = pk
+		}
+	}
+
+	return m
+}
+
+// decodeRetained returns a retained message with its payload decoded if it was compressed,
+// or false if the payload cannot be decoded.
+func (x *TopicsIndex) decodeRetained(topic string, pk packets.Packet) (packets.Packet, bool) {
+	if !pk.Compressed {
+		return pk, true
+	}
+
+	b, err := snappy.Decode(nil, pk.Payload)
+	if err != nil {
+		if x.onDecodeError != nil {
+			x.onDecodeError(topic, err)
+		}
+		return pk, false
+	}
+
+	pk.Payload = b
+	pk.Compressed = false
+	return pk, true
+}
+
 // Messages returns a slice of any retained messages which match a filter.
 func (x *TopicsIndex) Messages(filter string) []packets.Packet {
 	return x.scanMessages(filter, 0, nil, []packets.Packet{})
@@ -537,7 +742,7 @@ func (x *TopicsIndex) scanMessages(filter string, d int, n *particle, pks []pack
 	}
 
 	if !strings.ContainsRune(filter, '#') && !strings.ContainsRune(filter, '+') {
-		if pk, ok := x.Retained.Get(filter); ok {
+		if pk, ok := x.RetainedMessage(filter); ok {
 			pks = append(pks, pk)
 		}
 		return pks
@@ -552,7 +757,7 @@ func (x *TopicsIndex) scanMessages(filter string, d int, n *particle, pks []pack
 
 			if !hasNext {
 				if adjacent.retainPath != "" {
-					if pk, ok := x.Retained.Get(adjacent.retainPath); ok {
+					if pk, ok := x.RetainedMessage(adjacent.retainPath); ok {
 						pks = append(pks, pk)
 					}
 				}
@@ -570,7 +775,7 @@ func (x *TopicsIndex) scanMessages(filter string, d int, n *particle, pks []pack
 			return x.scanMessages(filter, d+1, particle, pks)
 		}
 
-		if pk, ok := x.Retained.Get(particle.retainPath); ok {
+		if pk, ok := x.RetainedMessage(particle.retainPath); ok {
 			pks = append(pks, pk)
 		}
 	}
@@ -579,8 +784,27 @@ func (x *TopicsIndex) scanMessages(filter string, d int, n *particle, pks []pack
 }
 
 // Subscribers returns a map of clients who are subscribed to matching filters,
-// their subscription ids and highest qos.
+// their subscription ids and highest qos. If the subscriber cache is enabled, the
+// subscribers of recently published topics are returned from the cache, and the
+// result is shared between callers, so it must not be modified; use Clone to take
+// a copy before selecting and merging shared subscribers.
 func (x *TopicsIndex) Subscribers(topic string) *Subscribers {
+	if x.cache == nil {
+		return x.resolveSubscribers(topic)
+	}
+
+	generation := atomic.LoadUint64(&x.cache.generation)
+	if subs, ok := x.cache.get(topic, generation); ok {
+		return subs
+	}
+
+	subs := x.resolveSubscribers(topic)
+	x.cache.add(topic, generation, subs)
+	return subs
+}
+
+// resolveSubscribers walks the index for the subscribers matching a topic.
+func (x *TopicsIndex) resolveSubscribers(topic string) *Subscribers {
 	return x.scanSubscribers(topic, 0, nil, &Subscribers{
 		Shared:              map[string]map[string]packets.Subscription{},
 		SharedSelected:      map[string]packets.Subscription{},
@@ -703,6 +927,51 @@ func IsSharedFilter(filter string) bool {
 	return strings.EqualFold(prefix, SharePrefix)
 }
 
+// trimSharePrefix returns the topic filter of a shared subscription filter, or the
+// filter unchanged if it is not shared.
+func trimSharePrefix(filter string) string {
+	if !IsSharedFilter(filter) {
+		return filter
+	}
+
+	parts := strings.SplitN(filter, "/", 3)
+	if len(parts) < 3 {
+		return ""
+	}
+
+	return parts[2]
+}
+
+// MatchTopic returns true if a topic name matches a (non-shared) topic filter.
+// Filters beginning with a wildcard do not match topics beginning with $. [MQTT-4.7.2-1]
+func MatchTopic(filter, topic string) bool {
+	if len(topic) > 0 && topic[0] == '$' && len(filter) > 0 && (filter[0] == '+' || filter[0] == '#') {
+		return false
+	}
+
+	for {
+		fp, fNext := isolateParticle(filter, 0)
+		tp, tNext := isolateParticle(topic, 0)
+
+		switch {
+		case fp == "#":
+			return true
+		case fp != "+" && fp != tp:
+			return false
+		case !fNext && !tNext:
+			return true
+		case !tNext:
+			rest := filter[len(fp)+1:]
+			return rest == "#"
+		case !fNext:
+			return false
+		}
+
+		filter = filter[len(fp)+1:]
+		topic = topic[len(tp)+1:]
+	}
+}
+
 // IsValidFilter returns true if the filter is valid.
 func IsValidFilter(filter string, forPublish bool) bool {
 	if !forPublish && len(filter) == 0 { // publishing can accept zero-length topic filter if topic alias exists, so we don't enforce for publish.
@@ -744,6 +1013,73 @@ func IsValidFilter(filter string, forPublish bool) bool {
 	return true
 }
 
+// TopicNode describes a level of the topic tree, including the subscriptions to the filter
+// ending at the level and whether a message is retained on the topic, for debugging routing.
+type TopicNode struct {
+	Key      string              `json:"key"`                // the topic level of the node
+	Filter   string              `json:"filter"`             // the topic filter ending at the node
+	Clients  []string            `json:"clients,omitempty"`  // ids of clients subscribed to the filter
+	Shared   map[string][]string `json:"shared,omitempty"`   // ids of clients with shared subscriptions to the filter, keyed on group
+	Inline   []int               `json:"inline,omitempty"`   // ids of inline subscriptions to the filter
+	Retained bool                `json:"retained,omitempty"` // true if a message is retained on the topic
+	Children []TopicNode         `json:"children,omitempty"` // the next topic levels, ordered by key
+}
+
+// Dump returns a copy of the topic tree, starting from a root node with an empty key.
+func (x *TopicsIndex) Dump() TopicNode {
+	x.root.Lock()
+	defer x.root.Unlock()
+	return x.dumpParticle(x.root, "")
+}
+
+// dumpParticle returns a TopicNode for a particle and its children.
+func (x *TopicsIndex) dumpParticle(n *particle, filter string) TopicNode {
+	node := TopicNode{
+		Key:      n.key,
+		Filter:   filter,
+		Retained: n.retainPath != "",
+	}
+
+	for id := range n.subscriptions.GetAll() {
+		node.Clients = append(node.Clients, id)
+	}
+	sort.Strings(node.Clients)
+
+	if n.shared != nil {
+		for group, subs := range n.shared.GetAll() {
+			if node.Shared == nil {
+				node.Shared = map[string][]string{}
+			}
+
+			for id := range subs {
+				node.Shared[group] = append(node.Shared[group], id)
+			}
+			sort.Strings(node.Shared[group])
+		}
+	}
+
+	if n.inlineSubscriptions != nil {
+		for id := range n.inlineSubscriptions.GetAll() {
+			node.Inline = append(node.Inline, id)
+		}
+		sort.Ints(node.Inline)
+	}
+
+	for key, child := range n.particles.getAll() {
+		f := key
+		if filter != "" || n.parent != nil {
+			f = filter + "/" + key
+		}
+		node.Children = append(node.Children, x.dumpParticle(child, f))
+	}
+
+	sort.Slice(node.Children, func(i, j int) bool {
+		return node.Children[i].Key < node.Children[j].Key
+	})
+
+	return node
+}
+
 // particle is a child node on the tree.
 type particle struct {
 	key                 string               // the key of the particle
@@ -293,6 +293,9 @@ func TestUnsubscribe(t *testing.T) {
 	require.NotNil(t, client)
 	require.True(t, exists)
 
+	ok = index.Unsubscribe("d/e/f", "cl1")
+	require.False(t, ok)
+
 	ok = index.Unsubscribe("fdasfdas/dfsfads/sa", "nobody")
 	require.False(t, ok)
 }
@@ -625,15 +628,22 @@ func TestSubscribersFind(t *testing.T) {
 }
 
 func BenchmarkSubscribers(b *testing.B) {
-	index := NewTopicsIndex()
-	index.Subscribe("cl1", packets.Subscription{Filter: "a/b/c"})
-	index.Subscribe("cl1", packets.Subscription{Filter: "a/+/c"})
-	index.Subscribe("cl1", packets.Subscription{Filter: "a/b/c/+"})
-	index.Subscribe("cl2", packets.Subscription{Filter: "a/b/c/d"})
-	index.Subscribe("cl3", packets.Subscription{Filter: "#"})
-
-	for n := 0; n < b.N; n++ {
-		index.Subscribers("a/b/c")
+	for _, size := range []int{0, 16} {
+		b.Run(fmt.Sprintf("cache=%d", size), func(b *testing.B) {
+			index := NewTopicsIndex()
+			index.SetSubscriberCacheSize(size)
+			index.Subscribe("cl1", packets.Subscription{Filter: "a/b/c"})
+			index.Subscribe("cl1", packets.Subscription{Filter: "a/+/c"})
+			index.Subscribe("cl1", packets.Subscription{Filter: "a/b/c/+"})
+			index.Subscribe("cl2", packets.Subscription{Filter: "a/b/c/d"})
+			index.Subscribe("cl3", packets.Subscription{Filter: "#"})
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for n := 0; n < b.N; n++ {
+				index.Subscribers("a/b/c")
+			}
+		})
 	}
 }
 
@@ -782,6 +792,44 @@ func TestIsSharedFilter(t *testing.T) {
 	require.False(t, IsSharedFilter("a/b/c"))
 }
 
+func TestTrimSharePrefix(t *testing.T) {
+	require.Equal(t, "a/b/c", trimSharePrefix(SharePrefix+"/tmp/a/b/c"))
+	require.Equal(t, "", trimSharePrefix(SharePrefix+"/tmp"))
+	require.Equal(t, "a/b/c", trimSharePrefix("a/b/c"))
+}
+
+func TestMatchTopic(t *testing.T) {
+	tt := []struct {
+		filter string
+		topic  string
+		expect bool
+	}{
+		{filter: "a/b/c", topic: "a/b/c", expect: true},
+		{filter: "a/b/c", topic: "a/b/d", expect: false},
+		{filter: "a/b", topic: "a/b/c", expect: false},
+		{filter: "a/b/c", topic: "a/b", expect: false},
+		{filter: "a/+/c", topic: "a/b/c", expect: true},
+		{filter: "a/+/c", topic: "a/b/d", expect: false},
+		{filter: "a/+", topic: "a", expect: false},
+		{filter: "a/#", topic: "a", expect: true},
+		{filter: "a/#", topic: "a/b/c", expect: true},
+		{filter: "#", topic: "a/b/c", expect: true},
+		{filter: "+/+", topic: "a/b", expect: true},
+		{filter: "+/+", topic: "a/b/c", expect: false},
+		{filter: "#", topic: "$SYS/broker", expect: false},
+		{filter: "+/broker", topic: "$SYS/broker", expect: false},
+		{filter: "$SYS/#", topic: "$SYS/broker", expect: true},
+		{filter: "a//c", topic: "a//c", expect: true},
+		{filter: "a/+/c", topic: "a//c", expect: true},
+	}
+
+	for _, tx := range tt {
+		t.Run(tx.filter+" "+tx.topic, func(t *testing.T) {
+			require.Equal(t, tx.expect, MatchTopic(tx.filter, tx.topic))
+		})
+	}
+}
+
 func TestNewInboundAliases(t *testing.T) {
 	a := NewInboundTopicAliases(5)
 	require.NotNil(t, a)
@@ -808,6 +856,47 @@ func TestInboundAliasesSetMaxZero(t *testing.T) {
 	require.NotContains(t, a.internal, id)
 }
 
+func TestTopicsDump(t *testing.T) {
+	index := NewTopicsIndex()
+	index.Subscribe("cl1", packets.Subscription{Filter: "a/b/c"})
+	index.Subscribe("cl2", packets.Subscription{Filter: "a/b/c"})
+	index.Subscribe("cl1", packets.Subscription{Filter: "a/+"})
+	index.Subscribe("cl3", packets.Subscription{Filter: SharePrefix + "/grp/a/b"})
+	index.Subscribe("cl4", packets.Subscription{Filter: "/x"})
+	index.InlineSubscribe(InlineSubscription{Subscription: packets.Subscription{Filter: "a/+", Identifier: 2}})
+	index.RetainMessage(packets.Packet{TopicName: "a/b", Payload: []byte("hello"), FixedHeader: packets.FixedHeader{Retain: true}})
+
+	require.Equal(t, TopicNode{
+		Children: []TopicNode{
+			{
+				Children: []TopicNode{
+					{Key: "x", Filter: "/x", Clients: []string{"cl4"}},
+				},
+			},
+			{
+				Key:    "a",
+				Filter: "a",
+				Children: []TopicNode{
+					{Key: "+", Filter: "a/+", Clients: []string{"cl1"}, Inline: []int{2}},
+					{
+						Key:      "b",
+						Filter:   "a/b",
+						Shared:   map[string][]string{"grp": {"cl3"}},
+						Retained: true,
+						Children: []TopicNode{
+							{Key: "c", Filter: "a/b/c", Clients: []string{"cl1", "cl2"}},
+						},
+					},
+				},
+			},
+		},
+	}, index.Dump())
+}
+
+func TestTopicsDumpEmpty(t *testing.T) {
+	require.Equal(t, TopicNode{}, NewTopicsIndex().Dump())
+}
+
 func TestNewOutboundAliases(t *testing.T) {
 	a := NewOutboundTopicAliases(5)
 	require.NotNil(t, a)
@@ -839,6 +928,13 @@ func TestOutboundAliasesSet(t *testing.T) {
 	require.Equal(t, uint16(2), n)
 }
 
+func TestOutboundAliasesTopic(t *testing.T) {
+	a := NewOutboundTopicAliases(3)
+	n, _ := a.Set("t1")
+	require.Equal(t, "t1", a.Topic(n))
+	require.Equal(t, "", a.Topic(2))
+}
+
 func TestOutboundAliasesSetMaxZero(t *testing.T) {
 	topic := "test"
 	a := NewOutboundTopicAliases(0)
@@ -1066,3 +1162,91 @@ func TestInlineUnsubscribe(t *testing.T) {
 	ok = index.InlineUnsubscribe(1, "not/exist")
 	require.False(t, ok)
 }
+
+func TestSubscriberCache(t *testing.T) {
+	index := NewTopicsIndex()
+	require.Equal(t, 0, index.SubscriberCacheLen())
+
+	index.SetSubscriberCacheSize(2)
+	index.Subscribe("cl1", packets.Subscription{Filter: "a/+/c", Qos: 1, Identifier: 1})
+	index.Subscribe("cl2", packets.Subscription{Filter: SharePrefix + "/tmp/a/b/c", Qos: 1})
+
+	subs := index.Subscribers("a/b/c")
+	require.Len(t, subs.Subscriptions, 1)
+	require.Equal(t, 1, index.SubscriberCacheLen())
+
+	require.Same(t, subs, index.Subscribers("a/b/c")) // the cached result is shared
+
+	// modifying a clone of the subscribers does not affect the cache
+	clone := subs.Clone()
+	clone.SelectShared()
+	clone.MergeSharedSelected()
+	require.Len(t, clone.Subscriptions, 2)
+	clone.Subscriptions["cl1"].Identifiers["x"] = 2
+
+	subs = index.Subscribers("a/b/c")
+	require.Len(t, subs.Subscriptions, 1)
+	require.Len(t, subs.Shared, 1)
+	require.Empty(t, subs.SharedSelected)
+	require.Equal(t, map[string]int{"a/+/c": 1}, subs.Subscriptions["cl1"].Identifiers)
+
+	index.Subscribe("cl3", packets.Subscription{Filter: "a/b/#"})
+	require.Equal(t, 0, index.SubscriberCacheLen())
+	require.Len(t, index.Subscribers("a/b/c").Subscriptions, 2)
+
+	index.Unsubscribe("a/b/#", "cl3")
+	require.Len(t, index.Subscribers("a/b/c").Subscriptions, 1)
+
+	index.Subscribers("d/e/f")
+	index.Subscribers("g/h/i")
+	require.Equal(t, 2, index.SubscriberCacheLen())
+
+	index.InlineSubscribe(InlineSubscription{Handler: func(cl *Client, sub packets.Subscription, pk packets.Packet) {}, Subscription: packets.Subscription{Filter: "a/b/c", Identifier: 1}})
+	require.Equal(t, 0, index.SubscriberCacheLen())
+	require.Len(t, index.Subscribers("a/b/c").InlineSubscriptions, 1)
+
+	index.InlineUnsubscribe(1, "a/b/c")
+	require.Empty(t, index.Subscribers("a/b/c").InlineSubscriptions)
+
+	index.SetSubscriberCacheSize(0)
+	index.Subscribers("a/b/c")
+	require.Equal(t, 0, index.SubscriberCacheLen())
+}
+
+func TestSubscriberCacheStaleGeneration(t *testing.T) {
+	index := NewTopicsIndex()
+	index.SetSubscriberCacheSize(10)
+
+	generation := index.cache.generation
+	index.Subscribe("cl1", packets.Subscription{Filter: "a/b/c"})
+	index.cache.add("a/b/c", generation, &Subscribers{})
+	require.Equal(t, 0, index.SubscriberCacheLen())
+
+	require.Len(t, index.Subscribers("a/b/c").Subscriptions, 1)
+}
+
+func TestServerSubscriberCacheSize(t *testing.T) {
+	s := New(&Options{Logger: logger, SubscriberCacheSize: 16})
+	require.NotNil(t, s.Topics.cache)
+	require.Equal(t, 16, s.Topics.cache.size)
+
+	s = New(&Options{Logger: logger})
+	require.Nil(t, s.Topics.cache)
+}
+
+func TestServerSelectSubscribersCached(t *testing.T) {
+	s := New(&Options{Logger: logger, SubscriberCacheSize: 16})
+	s.Topics.Subscribe("cl1", packets.Subscription{Filter: "a/b/c"})
+	s.Topics.Subscribe("cl2", packets.Subscription{Filter: SharePrefix + "/tmp/a/b/c"})
+
+	pk := packets.Packet{TopicName: "a/b/c"}
+	for i := 0; i < 2; i++ {
+		subs := s.selectSubscribers(pk)
+		require.Len(t, subs.Subscriptions, 2)
+	}
+
+	// selecting shared subscribers does not modify the cached result
+	cached := s.Topics.Subscribers("a/b/c")
+	require.Len(t, cached.Subscriptions, 1)
+	require.Empty(t, cached.SharedSelected)
+}
@@ -0,0 +1,69 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package mqtt
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Traces is a set of client ids for which every decoded inbound and outbound
+// packet is logged, for debugging individual clients.
+type Traces struct {
+	internal map[string]struct{} // client ids with tracing enabled
+	size     int64               // the number of traced client ids, for a lock-free fast path
+	sync.RWMutex
+}
+
+// NewTraces returns a new instance of Traces.
+func NewTraces() *Traces {
+	return &Traces{
+		internal: map[string]struct{}{},
+	}
+}
+
+// Add enables tracing for a client id.
+func (t *Traces) Add(id string) {
+	t.Lock()
+	defer t.Unlock()
+	t.internal[id] = struct{}{}
+	atomic.StoreInt64(&t.size, int64(len(t.internal)))
+}
+
+// Delete disables tracing for a client id.
+func (t *Traces) Delete(id string) {
+	t.Lock()
+	defer t.Unlock()
+	delete(t.internal, id)
+	atomic.StoreInt64(&t.size, int64(len(t.internal)))
+}
+
+// Has returns true if tracing is enabled for a client id.
+func (t *Traces) Has(id string) bool {
+	if t == nil || atomic.LoadInt64(&t.size) == 0 {
+		return false
+	}
+
+	t.RLock()
+	defer t.RUnlock()
+	_, ok := t.internal[id]
+	return ok
+}
+
+// GetAll returns all traced client ids.
+func (t *Traces) GetAll() []string {
+	t.RLock()
+	defer t.RUnlock()
+	ids := make([]string, 0, len(t.internal))
+	for id := range t.internal {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// Len returns the number of traced client ids.
+func (t *Traces) Len() int {
+	return int(atomic.LoadInt64(&t.size))
+}
@@ -0,0 +1,32 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package mqtt
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTracesAddHasDelete(t *testing.T) {
+	tr := NewTraces()
+	require.False(t, tr.Has("mochi"))
+
+	tr.Add("mochi")
+	tr.Add("zen")
+	require.Equal(t, 2, tr.Len())
+	require.True(t, tr.Has("mochi"))
+	require.False(t, tr.Has("other"))
+	require.ElementsMatch(t, []string{"mochi", "zen"}, tr.GetAll())
+
+	tr.Delete("mochi")
+	require.Equal(t, 1, tr.Len())
+	require.False(t, tr.Has("mochi"))
+}
+
+func TestTracesHasNil(t *testing.T) {
+	var tr *Traces
+	require.False(t, tr.Has("mochi"))
+}
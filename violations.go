@@ -0,0 +1,87 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package mqtt
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/mochi-mqtt/server/v2/packets"
+)
+
+// ViolationPolicy determines how the server handles malformed packets and protocol
+// violations from connected clients.
+type ViolationPolicy string
+
+const (
+	// ViolationDisconnect disconnects the client, sending an MQTT v5 client a DISCONNECT
+	// with the malformed packet or protocol violation reason code. This is the default.
+	ViolationDisconnect ViolationPolicy = "disconnect"
+
+	// ViolationDrop logs and discards the offending packet, keeping the client connected.
+	ViolationDrop ViolationPolicy = "drop"
+
+	// ViolationBan logs and discards offending packets until the client reaches the
+	// violation limit, at which point it is disconnected and banned.
+	ViolationBan ViolationPolicy = "ban"
+)
+
+const defaultViolationLimit = 10 // the default number of violations before a client is banned
+
+// violationFn handles a malformed packet or protocol violation from a client.
+type violationFn func(cl *Client, pk packets.Packet, err error) error
+
+// IsViolation returns true if an error is a malformed packet or protocol violation code.
+func IsViolation(err error) bool {
+	var code packets.Code
+	if !errors.As(err, &code) {
+		return false
+	}
+
+	return code.Code == packets.ErrMalformedPacket.Code || code.Code == packets.ErrProtocolViolation.Code
+}
+
+// handleViolation applies the violation policy to a malformed packet or protocol violation
+// from a client, returning the violation if the client was disconnected, or nil if the
+// packet was discarded and the client may continue.
+func (s *Server) handleViolation(cl *Client, pk packets.Packet, err error) error {
+	n := atomic.AddInt32(&cl.State.violations, 1)
+	s.Log.Warn("protocol violation", "error", err, "client", cl.ID, "listener", cl.Net.Listener, "policy", s.Options.ViolationPolicy, "violations", n, "pk", pk)
+
+	if cl.Closed() {
+		return err // the client was already disconnected while processing the packet
+	}
+
+	switch s.Options.ViolationPolicy {
+	case ViolationDrop:
+		return nil
+	case ViolationBan:
+		if n < s.Options.ViolationLimit {
+			return nil
+		}
+	}
+
+	code := packets.ErrMalformedPacket
+	_ = errors.As(err, &code)
+	if cl.Properties.ProtocolVersion == 5 {
+		_ = s.DisconnectClient(cl, code)
+	}
+
+	if s.Options.ViolationPolicy == ViolationBan {
+		id, ip := cl.ID, ""
+		if s.Options.ViolationBanIP {
+			if ip = remoteIP(cl.Net.Remote); ip != "" {
+				id = "" // ban every client connecting from the address
+			}
+		}
+
+		ttl := time.Second * time.Duration(s.Options.ViolationBanDuration)
+		_, _ = s.Ban(id, ip, ttl, fmt.Sprintf("%d protocol violations", n))
+	}
+
+	return err
+}
@@ -0,0 +1,172 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: 2023 mochi-mqtt, mochi-co
+// SPDX-FileContributor: mochi-co
+
+package mqtt
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/mochi-mqtt/server/v2/packets"
+	"github.com/stretchr/testify/require"
+)
+
+func newViolationClient(t *testing.T, s *Server) (*Client, net.Conn) {
+	r, w := net.Pipe()
+	cl := s.NewClient(w, "tcp1", "mochi", false)
+	cl.Net.Remote = "10.0.0.1:1234"
+	cl.Properties.ProtocolVersion = 5
+	t.Cleanup(func() {
+		cl.Stop(errClientStop)
+		_ = r.Close()
+	})
+
+	return cl, r
+}
+
+func TestIsViolation(t *testing.T) {
+	require.True(t, IsViolation(packets.ErrMalformedTopic))
+	require.True(t, IsViolation(fmt.Errorf("test: %w", packets.ErrMalformedTopic)))
+	require.True(t, IsViolation(packets.ErrProtocolViolationSecondConnect))
+	require.False(t, IsViolation(packets.ErrRejectPacket))
+	require.False(t, IsViolation(io.EOF))
+	require.False(t, IsViolation(nil))
+}
+
+func TestServerHandleViolationDisconnect(t *testing.T) {
+	s := newServer()
+	require.Equal(t, ViolationDisconnect, s.Options.ViolationPolicy)
+
+	cl, r := newViolationClient(t, s)
+	go func() { _, _ = io.Copy(io.Discard, r) }()
+
+	err := s.handleViolation(cl, packets.Packet{}, packets.ErrMalformedTopic)
+	require.ErrorIs(t, err, packets.ErrMalformedTopic)
+	require.True(t, cl.Closed())
+	require.ErrorIs(t, cl.StopCause(), packets.ErrMalformedTopic)
+	require.Equal(t, 0, s.Bans.Len())
+}
+
+func TestServerHandleViolationDrop(t *testing.T) {
+	s := newServer()
+	s.Options.ViolationPolicy = ViolationDrop
+
+	cl, _ := newViolationClient(t, s)
+	err := s.handleViolation(cl, packets.Packet{}, packets.ErrMalformedTopic)
+	require.NoError(t, err)
+	require.False(t, cl.Closed())
+	require.Equal(t, int32(1), cl.State.violations)
+}
+
+func TestServerHandleViolationAlreadyClosed(t *testing.T) {
+	s := newServer()
+	s.Options.ViolationPolicy = ViolationDrop
+
+	cl, _ := newViolationClient(t, s)
+	cl.Stop(errClientStop)
+	err := s.handleViolation(cl, packets.Packet{}, packets.ErrProtocolViolationNoTopic)
+	require.ErrorIs(t, err, packets.ErrProtocolViolationNoTopic)
+}
+
+func TestServerHandleViolationBan(t *testing.T) {
+	s := newServer()
+	s.Options.ViolationPolicy = ViolationBan
+	s.Options.ViolationLimit = 2
+	s.Options.ViolationBanDuration = 60
+
+	cl, r := newViolationClient(t, s)
+	go func() { _, _ = io.Copy(io.Discard, r) }()
+
+	err := s.handleViolation(cl, packets.Packet{}, packets.ErrMalformedTopic)
+	require.NoError(t, err)
+	require.False(t, cl.Closed())
+
+	err = s.handleViolation(cl, packets.Packet{}, packets.ErrMalformedTopic)
+	require.ErrorIs(t, err, packets.ErrMalformedTopic)
+	require.True(t, cl.Closed())
+
+	ban, ok := s.Bans.Get(Ban{ClientID: "mochi"}.Key())
+	require.True(t, ok)
+	require.Equal(t, ban.Created+60, ban.Expiry)
+	require.Equal(t, "2 protocol violations", ban.Reason)
+
+	_, ok = s.Bans.Get(Ban{IP: "10.0.0.1"}.Key())
+	require.False(t, ok)
+}
+
+func TestServerHandleViolationBanIP(t *testing.T) {
+	s := newServer()
+	s.Options.ViolationPolicy = ViolationBan
+	s.Options.ViolationLimit = 1
+	s.Options.ViolationBanIP = true
+
+	cl, r := newViolationClient(t, s)
+	go func() { _, _ = io.Copy(io.Discard, r) }()
+
+	err := s.handleViolation(cl, packets.Packet{}, packets.ErrMalformedTopic)
+	require.ErrorIs(t, err, packets.ErrMalformedTopic)
+	require.True(t, cl.Closed())
+
+	_, ok := s.Bans.Get(Ban{IP: "10.0.0.1"}.Key())
+	require.True(t, ok)
+
+	_, ok = s.Bans.Get(Ban{ClientID: "mochi"}.Key())
+	require.False(t, ok)
+}
+
+func TestClientReadViolationDrop(t *testing.T) {
+	s := newServer()
+	s.Options.ViolationPolicy = ViolationDrop
+
+	cl, r := newViolationClient(t, s)
+	cl.Properties.ProtocolVersion = 4
+	go func() {
+		_, _ = r.Write([]byte{
+			byte(packets.Publish << 4), 1, 0, // malformed topic
+			byte(packets.Pingreq << 4), 0,
+		})
+	}()
+
+	errDone := errors.New("done")
+	var got []packets.Packet
+	err := cl.Read(func(cl *Client, pk packets.Packet) error {
+		got = append(got, pk)
+		return errDone
+	})
+
+	require.ErrorIs(t, err, errDone)
+	require.Len(t, got, 1)
+	require.Equal(t, packets.Pingreq, got[0].FixedHeader.Type)
+	require.Equal(t, int32(1), cl.State.violations)
+}
+
+func TestClientReadViolationDisconnect(t *testing.T) {
+	s := newServer()
+
+	cl, r := newViolationClient(t, s)
+	cl.Properties.ProtocolVersion = 4
+	go func() {
+		_, _ = r.Write([]byte{byte(packets.Publish << 4), 1, 0})
+	}()
+
+	err := cl.Read(func(cl *Client, pk packets.Packet) error {
+		return nil
+	})
+
+	require.ErrorIs(t, err, packets.ErrMalformedTopic)
+}
+
+func TestServerReceivePacketViolationDrop(t *testing.T) {
+	s := newServer()
+	s.Options.ViolationPolicy = ViolationDrop
+
+	cl, _ := newViolationClient(t, s)
+	err := s.receivePacket(cl, *packets.TPacketData[packets.Connect].Get(packets.TConnectMqtt5).Packet)
+	require.NoError(t, err)
+	require.False(t, cl.Closed())
+	require.Equal(t, int32(1), cl.State.violations)
+}